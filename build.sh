@@ -1,4 +1,4 @@
 #!/bin/bash
 
-CGO_ENABLED=0 go build -a -ldflags '-extldflags "-static"' cmd/server/main.go 
+CGO_ENABLED=0 go build -a -ldflags "-extldflags -static -X main.gitCommit=$(git rev-parse --short HEAD) -X main.buildDate=$(date -u +%Y-%m-%d)" -o main ./cmd/server
 docker build --tag lightwalletd:latest -f docker/Dockerfile .
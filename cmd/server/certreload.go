@@ -0,0 +1,126 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// certReloader loads the certificate and key files again when they change,
+// so that a renewed certificate is served to new connections without a
+// restart. Connections already established keep the certificate they were
+// handshaken with. Changes are noticed by the files' modification times.
+type certReloader struct {
+	certPath string
+	keyPath  string
+	certMod  time.Time
+	keyMod   time.Time
+
+	mutex sync.RWMutex
+	cert  tls.Certificate
+
+	// stapler, if set, serves the certificate instead, with its OCSP
+	// staple.
+	stapler *ocspStapler
+	log     *logrus.Entry
+}
+
+// newCertReloader serves the certificate config was built with from
+// certPath and keyPath, by newTLSConfig, and reloads it from there. If
+// stapler is set, it has taken over config's certificate already, and is
+// given the reloaded ones.
+func newCertReloader(config *tls.Config, certPath, keyPath string, stapler *ocspStapler, log *logrus.Entry) (*certReloader, error) {
+	r := &certReloader{
+		certPath: certPath,
+		keyPath:  keyPath,
+		stapler:  stapler,
+		log:      log,
+	}
+	var err error
+	if r.certMod, r.keyMod, err = r.modTimes(); err != nil {
+		return nil, err
+	}
+	if stapler != nil {
+		return r, nil
+	}
+	if len(config.Certificates) != 1 {
+		return nil, errors.New("reloading needs exactly one certificate")
+	}
+	r.cert = config.Certificates[0]
+	config.Certificates = nil
+	config.GetCertificate = r.getCertificate
+	return r, nil
+}
+
+func (r *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return &r.cert, nil
+}
+
+func (r *certReloader) modTimes() (certMod, keyMod time.Time, err error) {
+	certInfo, err := os.Stat(r.certPath)
+	if err != nil {
+		return
+	}
+	keyInfo, err := os.Stat(r.keyPath)
+	if err != nil {
+		return
+	}
+	return certInfo.ModTime(), keyInfo.ModTime(), nil
+}
+
+// Reload loads the certificate and key again if either file changed since
+// they were last loaded, and reports whether it did. On failure, such as
+// when only one of the files has been replaced yet, the certificate served
+// is kept, and the next Reload tries again.
+func (r *certReloader) Reload() (bool, error) {
+	certMod, keyMod, err := r.modTimes()
+	if err != nil {
+		return false, err
+	}
+	if certMod.Equal(r.certMod) && keyMod.Equal(r.keyMod) {
+		return false, nil
+	}
+	cert, err := tls.LoadX509KeyPair(r.certPath, r.keyPath)
+	if err != nil {
+		return false, err
+	}
+	if r.stapler != nil {
+		if err := r.stapler.setCertificate(cert); err != nil {
+			return false, err
+		}
+		go r.stapler.Refresh()
+	} else {
+		r.mutex.Lock()
+		r.cert = cert
+		r.mutex.Unlock()
+	}
+	r.certMod, r.keyMod = certMod, keyMod
+	return true, nil
+}
+
+// Run checks the files for changes every interval, forever.
+func (r *certReloader) Run(interval time.Duration) {
+	for range time.Tick(interval) {
+		reloaded, err := r.Reload()
+		if err != nil {
+			r.log.WithFields(logrus.Fields{
+				"cert_file": r.certPath,
+				"key_path":  r.keyPath,
+				"error":     err,
+			}).Warn("couldn't reload the TLS certificate, still serving the previous one")
+			continue
+		}
+		if reloaded {
+			r.log.WithFields(logrus.Fields{
+				"cert_file": r.certPath,
+			}).Info("reloaded the TLS certificate")
+		}
+	}
+}
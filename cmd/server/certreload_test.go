@@ -0,0 +1,94 @@
+package main
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+// servedName runs a TLS handshake against config and returns the common
+// name of the certificate the server presented.
+func servedName(t *testing.T, config *tls.Config) string {
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+
+	go func() {
+		server := tls.Server(serverConn, config)
+		server.Handshake()
+		server.Close()
+	}()
+
+	client := tls.Client(clientConn, &tls.Config{InsecureSkipVerify: true})
+	if err := client.Handshake(); err != nil {
+		t.Fatalf("handshake failed: %v", err)
+	}
+	return client.ConnectionState().PeerCertificates[0].Subject.CommonName
+}
+
+// touch moves the modification time of paths forward, so that a rewrite
+// within the file system's timestamp resolution is noticed.
+func touch(t *testing.T, paths ...string) {
+	later := time.Now().Add(time.Minute)
+	for _, path := range paths {
+		if err := os.Chtimes(path, later, later); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCertReloader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lightwalletd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certPath, keyPath := writeTestCert(t, dir, "old.example.com")
+
+	config, err := newTLSConfig(certPath, keyPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloader, err := newCertReloader(config, certPath, keyPath, nil, log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reloaded, err := reloader.Reload(); reloaded || err != nil {
+		t.Errorf("reloaded unchanged files: %v, %v", reloaded, err)
+	}
+
+	writeTestCert(t, dir, "new.example.com")
+	touch(t, certPath, keyPath)
+	if reloaded, err := reloader.Reload(); !reloaded || err != nil {
+		t.Fatalf("didn't reload the renewed certificate: %v, %v", reloaded, err)
+	}
+	if name := servedName(t, config); name != "new.example.com" {
+		t.Errorf("served %q after the renewal", name)
+	}
+
+	// A certificate without its key is refused, and retried.
+	keyPEM, err := ioutil.ReadFile(keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestCert(t, dir, "newer.example.com")
+	if err := ioutil.WriteFile(keyPath, keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	touch(t, certPath, keyPath)
+	if _, err := reloader.Reload(); err == nil {
+		t.Error("expected a mismatched key to be refused")
+	}
+	if name := servedName(t, config); name != "new.example.com" {
+		t.Errorf("served %q after a failed reload", name)
+	}
+	writeTestCert(t, dir, "newer.example.com")
+	if reloaded, err := reloader.Reload(); !reloaded || err != nil {
+		t.Fatalf("didn't retry the reload: %v, %v", reloaded, err)
+	}
+	if name := servedName(t, config); name != "newer.example.com" {
+		t.Errorf("served %q after the retry", name)
+	}
+}
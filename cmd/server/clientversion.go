@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// clientVersionHeader is the request metadata key with which wallets say
+// which version they are, as "1.4.2" or "name/1.4.2".
+const clientVersionHeader = "client-version"
+
+// walletServicePrefix is the start of the full names of the wallet methods,
+// the only ones the client version is checked for; health checks and
+// reflection are left alone.
+const walletServicePrefix = "/cash.z.wallet.sdk.rpc.CompactTxStreamer/"
+
+// parseClientVersion parses a dotted version, such as "1.4.2", ignoring a
+// "name/" before it and anything after the digits of each part, such as
+// "-beta".
+func parseClientVersion(s string) ([]int, error) {
+	s = s[strings.LastIndex(s, "/")+1:]
+	var version []int
+	for _, part := range strings.Split(s, ".") {
+		digits := strings.IndexFunc(part, func(r rune) bool { return r < '0' || r > '9' })
+		if digits < 0 {
+			digits = len(part)
+		}
+		n, err := strconv.Atoi(part[:digits])
+		if err != nil {
+			return nil, fmt.Errorf("bad version %q", s)
+		}
+		version = append(version, n)
+		if digits < len(part) {
+			break
+		}
+	}
+	return version, nil
+}
+
+// olderVersion reports whether version a is older than b, missing parts
+// counting as zero.
+func olderVersion(a, b []int) bool {
+	for i := 0; i < len(a) || i < len(b); i++ {
+		var x, y int
+		if i < len(a) {
+			x = a[i]
+		}
+		if i < len(b) {
+			y = b[i]
+		}
+		if x != y {
+			return x < y
+		}
+	}
+	return false
+}
+
+// clientVersionCheck turns away wallets older than a minimum version, as
+// they report it in clientVersionHeader, pointing them to upgradeURL. A nil
+// *clientVersionCheck lets every client through.
+type clientVersionCheck struct {
+	min        []int
+	minText    string
+	upgradeURL string
+	// require turns away the clients that don't report a version too.
+	require bool
+}
+
+// newClientVersionCheck returns the check for clients older than min, or
+// nil if min is empty.
+func newClientVersionCheck(min, upgradeURL string, require bool) (*clientVersionCheck, error) {
+	if min == "" {
+		return nil, nil
+	}
+	version, err := parseClientVersion(min)
+	if err != nil {
+		return nil, err
+	}
+	return &clientVersionCheck{min: version, minText: min, upgradeURL: upgradeURL, require: require}, nil
+}
+
+// check returns the FailedPrecondition error for a call to fullMethod with
+// ctx, if the client is too old.
+func (c *clientVersionCheck) check(ctx context.Context, fullMethod string) error {
+	if c == nil || !strings.HasPrefix(fullMethod, walletServicePrefix) {
+		return nil
+	}
+
+	var reported string
+	if md, ok := metadata.FromIncomingContext(ctx); ok && len(md.Get(clientVersionHeader)) > 0 {
+		reported = md.Get(clientVersionHeader)[0]
+	}
+	var msg string
+	if reported == "" {
+		if !c.require {
+			return nil
+		}
+		msg = fmt.Sprintf("this server needs wallets of version %s or later, which report their version", c.minText)
+	} else if version, err := parseClientVersion(reported); err != nil || olderVersion(version, c.min) {
+		msg = fmt.Sprintf("wallet version %s is no longer supported by this server, upgrade to %s or later", reported, c.minText)
+	} else {
+		return nil
+	}
+
+	loggerFromContext(ctx).WithFields(logrus.Fields{
+		"method":         fullMethod,
+		"client_version": reported,
+	}).Info("turned away an old client")
+
+	if c.upgradeURL != "" {
+		msg += ": " + c.upgradeURL
+	}
+	st := status.New(codes.FailedPrecondition, msg)
+	if c.upgradeURL != "" {
+		if detailed, err := st.WithDetails(&errdetails.Help{
+			Links: []*errdetails.Help_Link{{Description: "upgrade the wallet", Url: c.upgradeURL}},
+		}); err == nil {
+			st = detailed
+		}
+	}
+	return st.Err()
+}
+
+func (c *clientVersionCheck) unaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := c.check(ctx, info.FullMethod); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+func (c *clientVersionCheck) streamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := c.check(ss.Context(), info.FullMethod); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
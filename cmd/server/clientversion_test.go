@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/adityapk00/lightwalletd/walletrpc"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestParseClientVersion(t *testing.T) {
+	for _, tt := range []struct {
+		s       string
+		version []int
+		older   bool // than 1.4
+	}{
+		{"1.4", []int{1, 4}, false},
+		{"1.4.0", []int{1, 4, 0}, false},
+		{"zecwallet-lite/1.3.9", []int{1, 3, 9}, true},
+		{"2.0.0-beta.1", []int{2, 0, 0}, false},
+		{"1", []int{1}, true},
+	} {
+		version, err := parseClientVersion(tt.s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(version) != len(tt.version) || olderVersion(version, tt.version) || olderVersion(tt.version, version) {
+			t.Errorf("%q parsed as %v, expected %v", tt.s, version, tt.version)
+		}
+		if olderVersion(version, []int{1, 4}) != tt.older {
+			t.Errorf("%q older than 1.4: %v, expected %v", tt.s, !tt.older, tt.older)
+		}
+	}
+	for _, s := range []string{"", "beta", "1..2"} {
+		if _, err := parseClientVersion(s); err == nil {
+			t.Errorf("%q parsed", s)
+		}
+	}
+}
+
+func TestClientVersionCheck(t *testing.T) {
+	check, err := newClientVersionCheck("1.4.0", "https://example.com/upgrade", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer(
+		grpc.UnaryInterceptor(check.unaryInterceptor()),
+		grpc.StreamInterceptor(check.streamInterceptor()),
+	)
+	defer server.Stop()
+	client := startTestServer(t, server, &stubStreamer{})
+	withVersion := func(version string) context.Context {
+		return metadata.AppendToOutgoingContext(context.Background(), clientVersionHeader, version)
+	}
+
+	for _, ctx := range []context.Context{withVersion("zecwallet-lite/1.4.2"), context.Background()} {
+		if _, err := client.GetLatestBlock(ctx, &walletrpc.ChainSpec{}); err != nil {
+			t.Errorf("recent or unknown client turned away: %v", err)
+		}
+	}
+
+	_, err = client.GetLatestBlock(withVersion("1.3.9"), &walletrpc.ChainSpec{})
+	st := status.Convert(err)
+	if st.Code() != codes.FailedPrecondition {
+		t.Fatalf("expected FailedPrecondition for an old client, got %v", err)
+	}
+	if len(st.Details()) != 1 || st.Details()[0].(*errdetails.Help).Links[0].Url != "https://example.com/upgrade" {
+		t.Errorf("expected the upgrade link, got %v", st.Details())
+	}
+
+	stream, err := client.GetBlockRange(withVersion("1.3.9"), &walletrpc.BlockRange{
+		Start: &walletrpc.BlockID{Height: 1},
+		End:   &walletrpc.BlockID{Height: 2},
+	})
+	if err == nil {
+		_, err = stream.Recv()
+	}
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("expected FailedPrecondition for an old client's stream, got %v", err)
+	}
+
+	check.require = true
+	if _, err := client.GetLatestBlock(context.Background(), &walletrpc.ChainSpec{}); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("expected clients without a version to be turned away, got %v", err)
+	}
+
+	// Without a minimum, everyone gets through.
+	if check, err := newClientVersionCheck("", "", true); check != nil || err != nil {
+		t.Errorf("expected no check, got %v, %v", check, err)
+	}
+}
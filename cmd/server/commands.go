@@ -0,0 +1,377 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/adityapk00/lightwalletd/common"
+	"github.com/adityapk00/lightwalletd/frontend"
+)
+
+const usage = `Usage: %s [command] [flags]
+
+Commands:
+  serve         run the server (the default when no command is given)
+  version       print the version and build information
+  check-config  check the settings and the connection to zcashd, then exit
+  gen-tls       write a self-signed TLS certificate and key
+
+Run "%[1]s <command> -h" for a command's flags.
+`
+
+// gitCommit is the commit the binary was built from, and buildDate when,
+// set at build time with -ldflags "-X main.gitCommit=... -X main.buildDate=...".
+var (
+	gitCommit = "unknown"
+	buildDate = "unknown"
+)
+
+// printVersion writes the version and build information to out.
+func printVersion(out io.Writer) {
+	fmt.Fprintf(out, "lightwalletd %s\n", frontend.Version)
+	fmt.Fprintf(out, "git commit: %s\n", gitCommit)
+	fmt.Fprintf(out, "build date: %s\n", buildDate)
+	if info, ok := debug.ReadBuildInfo(); ok {
+		fmt.Fprintf(out, "module: %s %s\n", info.Main.Path, info.Main.Version)
+	}
+	fmt.Fprintf(out, "go: %s %s/%s\n", runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	formats := frontend.CompactFormatVersions()
+	fmt.Fprintf(out, "compact block formats: %s (default %d)\n",
+		strings.Trim(fmt.Sprint(formats), "[]"), formats[len(formats)-1])
+	fmt.Fprintf(out, "protocol version: %d\n", frontend.ProtocolVersion)
+}
+
+// checkConfig reads the settings from args, the environment and the config
+// file as serve does, then runs selfTest on them. newRPC makes the client
+// for a zcash.conf file.
+func checkConfig(args []string, out io.Writer, newRPC func(confPath string) (common.RPCClient, error)) error {
+	fs := flag.NewFlagSet("check-config", flag.ContinueOnError)
+	opts := defineFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := setFlagsFromEnv(fs, os.LookupEnv); err != nil {
+		return err
+	}
+	if opts.configPath != "" {
+		if err := loadConfigFile(fs, opts.configPath); err != nil {
+			return err
+		}
+	}
+	if err := checkOperatorInfo(opts); err != nil {
+		return err
+	}
+	if err := checkSQLite(opts, common.SQLiteSupported); err != nil {
+		return err
+	}
+	if _, err := newClientVersionCheck(opts.minClientVersion, opts.clientUpgradeURL, opts.requireClientVer); err != nil {
+		return fmt.Errorf("bad -min-client-version: %v", err)
+	}
+	if opts.diskProbeInterval > 0 {
+		if _, err := diskProbeDir(opts); err != nil {
+			return err
+		}
+	}
+	if opts.noticesFile != "" {
+		if _, err := common.NewNoticeFile(opts.noticesFile, log); err != nil {
+			return fmt.Errorf("bad -notices-file: %v", err)
+		}
+	}
+	fmt.Fprintln(out, "settings: ok")
+	return selfTest(opts, out, newRPC)
+}
+
+// certExpiryWarning is how soon before it expires the TLS certificate is
+// reported as expiring.
+const certExpiryWarning = 14 * 24 * time.Hour
+
+// selfTest checks what the server needs to start with opts, without
+// starting it: the TLS certificate and key, the zcashd credentials and
+// connection of each backend, and that the directories the server writes
+// to are writable. Every check is run and reported to out; an error means
+// at least one failed.
+func selfTest(opts *Options, out io.Writer, newRPC func(confPath string) (common.RPCClient, error)) error {
+	failed := 0
+	report := func(check string, err error, detail string) {
+		if err != nil {
+			failed++
+			fmt.Fprintf(out, "%s: FAILED: %v\n", check, err)
+			return
+		}
+		fmt.Fprintf(out, "%s: ok%s\n", check, detail)
+	}
+
+	if !opts.noTLS && opts.tlsSelfSigned {
+		// The certificate is generated there if it's not yet.
+		report(opts.tlsSelfSignedDir, checkWritable(opts.tlsSelfSignedDir, 0700), ", writable")
+	} else if !opts.noTLS && opts.bindAddr != "" && opts.acmeDomain == "" {
+		detail, err := checkCertificate(opts, time.Now())
+		report("TLS certificate", err, detail)
+	}
+	if !opts.noTLS {
+		err := applyTLSPolicy(&tls.Config{}, opts.tlsMinVersion, splitList(opts.tlsCipherSuites), splitList(opts.tlsCurves))
+		report("TLS policy", err, "")
+	}
+	if !opts.noTLS && opts.tlsClientCA != "" {
+		_, err := loadCertPool(opts.tlsClientCA)
+		report("TLS client CAs", err, "")
+	}
+
+	if opts.zcashConfPath == "" {
+		report("zcashd", errors.New("no -conf-file given"), "")
+	}
+	for _, confPath := range splitList(opts.zcashConfPath) {
+		detail, err := checkNode(confPath, newRPC)
+		report(confPath, err, detail)
+	}
+
+	if !opts.noTLS && opts.acmeDomain != "" {
+		// autocert keeps the account key there, and makes it private.
+		report(opts.acmeCacheDir, checkWritable(opts.acmeCacheDir, 0700), ", writable")
+	}
+	var dirs []string
+	if opts.cacheStore == "mmap" {
+		dirs = append(dirs, filepath.Dir(opts.cacheFile))
+	}
+	if opts.cacheTierDir != "" {
+		dirs = append(dirs, opts.cacheTierDir)
+	}
+	if opts.checkpointFile != "" {
+		dirs = append(dirs, filepath.Dir(opts.checkpointFile))
+	}
+	if opts.statusFile != "" {
+		dirs = append(dirs, filepath.Dir(opts.statusFile))
+	}
+	for _, dir := range dirs {
+		report(dir, checkWritable(dir, 0755), ", writable")
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d checks failed", failed)
+	}
+	return nil
+}
+
+// checkCertificate loads the TLS certificate and key of opts, and checks
+// that the certificate is valid at now.
+func checkCertificate(opts *Options, now time.Time) (string, error) {
+	if opts.tlsCertPath == "" || opts.tlsKeyPath == "" {
+		return "", errors.New("no TLS certificate and key given, and -no-tls not set")
+	}
+	config, err := newTLSConfig(opts.tlsCertPath, opts.tlsKeyPath, splitList(opts.tlsAllowedSNI))
+	if err != nil {
+		return "", err
+	}
+	cert, err := x509.ParseCertificate(config.Certificates[0].Certificate[0])
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case now.Before(cert.NotBefore):
+		return "", fmt.Errorf("not valid until %s", cert.NotBefore.Format(time.RFC3339))
+	case now.After(cert.NotAfter):
+		return "", fmt.Errorf("expired on %s", cert.NotAfter.Format(time.RFC3339))
+	case cert.NotAfter.Sub(now) < certExpiryWarning:
+		return fmt.Sprintf(", but expires soon, on %s", cert.NotAfter.Format(time.RFC3339)), nil
+	}
+	return fmt.Sprintf(", expires on %s", cert.NotAfter.Format(time.RFC3339)), nil
+}
+
+// checkNode reads the credentials in confPath and calls getinfo and
+// getblockchaininfo with them.
+func checkNode(confPath string, newRPC func(confPath string) (common.RPCClient, error)) (string, error) {
+	client, err := newRPC(confPath)
+	if err != nil {
+		return "", err
+	}
+	if _, err := client.RawRequest("getinfo", nil); err != nil {
+		return "", fmt.Errorf("getinfo: %v", err)
+	}
+	info, err := common.GetChainInfo(client)
+	if err != nil {
+		return "", fmt.Errorf("couldn't reach zcashd: %v", err)
+	}
+	return fmt.Sprintf(", zcashd on %s at height %d", info.Chain, info.Blocks), nil
+}
+
+// checkWritable checks that a file can be created in dir, creating dir with
+// perm if it doesn't exist, as the server would.
+func checkWritable(dir string, perm os.FileMode) error {
+	if err := os.MkdirAll(dir, perm); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(dir, ".lightwalletd-self-test")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// genTLS writes a new self-signed certificate for hosts, valid for validFor,
+// to certPath, and its key to keyPath. Existing files aren't overwritten.
+func genTLS(certPath, keyPath string, hosts []string, validFor time.Duration) error {
+	if len(hosts) == 0 {
+		return errors.New("no hosts to issue the certificate for")
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: hosts[0]},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(validFor),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	if err := writeNewFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return err
+	}
+	return writeNewFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+}
+
+// selfSignedValidity is how long the -tls-self-signed certificate is valid.
+// Wallets pin it, so it's meant to outlast the server.
+const selfSignedValidity = 10 * 365 * 24 * time.Hour
+
+// selfSignedCert points the certificate and key of opts at the ones in
+// -tls-self-signed-dir, first generating them if they aren't there yet, for
+// the hosts of the -bind-addr addresses, or this host's name and localhost
+// if they're unspecified. It reports whether it generated them.
+func selfSignedCert(opts *Options) (bool, error) {
+	opts.tlsCertPath = filepath.Join(opts.tlsSelfSignedDir, "cert.pem")
+	opts.tlsKeyPath = filepath.Join(opts.tlsSelfSignedDir, "key.pem")
+	if _, err := os.Stat(opts.tlsCertPath); err == nil {
+		return false, nil
+	}
+
+	var hosts []string
+	for _, addr := range splitList(opts.bindAddr) {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return false, err
+		}
+		if ip := net.ParseIP(host); host == "" || ip != nil && ip.IsUnspecified() {
+			name, err := os.Hostname()
+			if err != nil {
+				return false, err
+			}
+			hosts = append(hosts, name, "localhost")
+			continue
+		}
+		hosts = append(hosts, host)
+	}
+	if len(hosts) == 0 {
+		hosts = []string{"localhost"}
+	}
+	if err := os.MkdirAll(opts.tlsSelfSignedDir, 0700); err != nil {
+		return false, err
+	}
+	if err := genTLS(opts.tlsCertPath, opts.tlsKeyPath, dedup(hosts), selfSignedValidity); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// dedup returns list without its repeated entries, in order.
+func dedup(list []string) []string {
+	seen := make(map[string]bool, len(list))
+	var kept []string
+	for _, s := range list {
+		if !seen[s] {
+			seen[s] = true
+			kept = append(kept, s)
+		}
+	}
+	return kept
+}
+
+// certFingerprint returns the SHA-256 fingerprint of the first certificate
+// in the PEM file certPath, as openssl prints it.
+func certFingerprint(certPath string) (string, error) {
+	data, err := ioutil.ReadFile(certPath)
+	if err != nil {
+		return "", err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return "", errors.New("no PEM certificate found")
+	}
+	sum := sha256.Sum256(block.Bytes)
+	hexes := make([]string, len(sum))
+	for i, b := range sum {
+		hexes[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(hexes, ":"), nil
+}
+
+// writeNewFile writes data to a file at path, which mustn't exist yet.
+func writeNewFile(path string, data []byte, perm os.FileMode) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// genTLSCommand runs gen-tls with the flags in args.
+func genTLSCommand(args []string) error {
+	fs := flag.NewFlagSet("gen-tls", flag.ContinueOnError)
+	certPath := fs.String("cert", "cert.pem", "where to write the certificate")
+	keyPath := fs.String("key", "key.pem", "where to write the key")
+	hosts := fs.String("hosts", "localhost,127.0.0.1", "comma-separated hostnames and IP addresses the certificate is for")
+	validFor := fs.Duration("valid-for", 365*24*time.Hour, "how long the certificate is valid")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := genTLS(*certPath, *keyPath, splitList(*hosts), *validFor); err != nil {
+		return err
+	}
+	fmt.Printf("wrote %s and %s\n", *certPath, *keyPath)
+	return nil
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adityapk00/lightwalletd/common"
+	"github.com/adityapk00/lightwalletd/frontend"
+)
+
+// chainNode answers getinfo and getblockchaininfo with its JSON, and fails
+// anything else. An empty chainNode fails everything.
+type chainNode string
+
+func (n chainNode) RawRequest(method string, params []json.RawMessage) (json.RawMessage, error) {
+	if (method != "getinfo" && method != "getblockchaininfo") || n == "" {
+		return nil, errors.New("connection refused")
+	}
+	return json.RawMessage(n), nil
+}
+
+func TestPrintVersion(t *testing.T) {
+	var out bytes.Buffer
+	printVersion(&out)
+	if !strings.Contains(out.String(), "lightwalletd "+frontend.Version) || !strings.Contains(out.String(), "git commit: ") ||
+		!strings.Contains(out.String(), "build date: ") || !strings.Contains(out.String(), "protocol version: ") {
+		t.Errorf("unexpected version output:\n%s", out.String())
+	}
+}
+
+func TestCheckConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lightwalletd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certPath, keyPath := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := genTLS(certPath, keyPath, []string{"localhost"}, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	node := chainNode(`{"chain": "main", "blocks": 1000000}`)
+	newRPC := func(confPath string) (common.RPCClient, error) {
+		return node, nil
+	}
+	var out bytes.Buffer
+	if err := checkConfig([]string{"-conf-file", "zcash.conf", "-tls-cert", certPath, "-tls-key", keyPath}, &out, newRPC); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "zcashd on main at height 1000000") {
+		t.Errorf("unexpected output:\n%s", out.String())
+	}
+
+	for _, tt := range []struct {
+		args   []string
+		newRPC func(string) (common.RPCClient, error)
+	}{
+		{[]string{"-no-tls"}, newRPC},
+		{[]string{"-conf-file", "zcash.conf"}, newRPC},
+		{[]string{"-conf-file", "zcash.conf", "-tls-cert", keyPath, "-tls-key", keyPath}, newRPC},
+		{[]string{"-conf-file", "zcash.conf", "-no-tls"}, func(string) (common.RPCClient, error) {
+			return nil, errors.New("rpcpassword not set")
+		}},
+		{[]string{"-conf-file", "zcash.conf", "-no-tls"}, func(string) (common.RPCClient, error) {
+			return chainNode(""), nil
+		}},
+	} {
+		if err := checkConfig(tt.args, ioutil.Discard, tt.newRPC); err == nil {
+			t.Errorf("%v: expected the check to fail", tt.args)
+		}
+	}
+}
+
+func TestGenTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lightwalletd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certPath, keyPath := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+
+	if err := genTLS(certPath, keyPath, []string{"lwd.example.com", "127.0.0.1"}, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(keyPath); err != nil {
+		t.Error(err)
+	} else if info.Mode().Perm() != 0600 {
+		t.Errorf("key file is %v, expected it to be private", info.Mode())
+	}
+	config, err := newTLSConfig(certPath, keyPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(config.Certificates[0].Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cert.VerifyHostname("lwd.example.com"); err != nil {
+		t.Error(err)
+	}
+	if err := cert.VerifyHostname("127.0.0.1"); err != nil {
+		t.Error(err)
+	}
+
+	if err := genTLS(certPath, keyPath, []string{"localhost"}, time.Hour); err == nil {
+		t.Error("expected gen-tls to refuse to overwrite the files")
+	}
+}
+
+func TestSelfSignedCert(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lightwalletd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	opts := &Options{
+		bindAddr:         "lwd.example.com:9067,127.0.0.1:9067",
+		tlsSelfSignedDir: filepath.Join(dir, "tls"),
+	}
+
+	created, err := selfSignedCert(opts)
+	if err != nil || !created {
+		t.Fatalf("expected the certificate to be generated: %v, %v", created, err)
+	}
+	config, err := newTLSConfig(opts.tlsCertPath, opts.tlsKeyPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(config.Certificates[0].Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, host := range []string{"lwd.example.com", "127.0.0.1"} {
+		if err := cert.VerifyHostname(host); err != nil {
+			t.Error(err)
+		}
+	}
+	fingerprint, err := certFingerprint(opts.tlsCertPath)
+	if err != nil || len(fingerprint) != 32*3-1 {
+		t.Errorf("bad fingerprint %q, %v", fingerprint, err)
+	}
+
+	// The next run serves the same certificate.
+	created, err = selfSignedCert(opts)
+	if err != nil || created {
+		t.Fatalf("expected the certificate to be reused: %v, %v", created, err)
+	}
+	if again, _ := certFingerprint(opts.tlsCertPath); again != fingerprint {
+		t.Errorf("the certificate changed from %s to %s", fingerprint, again)
+	}
+}
+
+func TestSelfTest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lightwalletd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certPath, keyPath := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := genTLS(certPath, keyPath, []string{"localhost"}, 24*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	opts := defineFlags(fs)
+	if err := fs.Parse([]string{"-tls-cert", certPath, "-tls-key", keyPath, "-conf-file", "a.conf,b.conf",
+		"-cache-tier-dir", filepath.Join(dir, "tier"), "-status-file", filepath.Join(certPath, "status.json")}); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	err = selfTest(opts, &out, func(confPath string) (common.RPCClient, error) {
+		if confPath == "b.conf" {
+			return chainNode(""), nil
+		}
+		return chainNode(`{"chain": "main", "blocks": 1000000}`), nil
+	})
+	if err == nil || err.Error() != "2 checks failed" {
+		t.Errorf("expected the b.conf and status file checks to fail, got %v", err)
+	}
+	report := out.String()
+	for _, want := range []string{
+		"TLS certificate: ok, but expires soon",
+		"a.conf: ok, zcashd on main at height 1000000",
+		"b.conf: FAILED: getinfo",
+		filepath.Join(dir, "tier") + ": ok, writable",
+		certPath + ": FAILED",
+	} {
+		if !strings.Contains(report, want) {
+			t.Errorf("report doesn't contain %q:\n%s", want, report)
+		}
+	}
+}
+
+func TestCheckCertificate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lightwalletd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certPath, keyPath := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := genTLS(certPath, keyPath, []string{"localhost"}, 90*24*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	opts := &Options{tlsCertPath: certPath, tlsKeyPath: keyPath}
+
+	now := time.Now()
+	for _, tt := range []struct {
+		at      time.Time
+		want    string
+		wantErr bool
+	}{
+		{now, ", expires on", false},
+		{now.Add(80 * 24 * time.Hour), "expires soon", false},
+		{now.Add(100 * 24 * time.Hour), "", true},
+		{now.Add(-24 * time.Hour), "", true},
+	} {
+		detail, err := checkCertificate(opts, tt.at)
+		if (err != nil) != tt.wantErr || !strings.Contains(detail, tt.want) {
+			t.Errorf("at %v: got %q, %v", tt.at, detail, err)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"sort"
+
+	"gopkg.in/yaml.v2"
+)
+
+// loadConfigFile sets the flags of fs that weren't given on the command line
+// or in the environment from the YAML file at path. The file's keys are flag
+// names, and may be grouped in sections named after the flags' first word:
+//
+//	bind-addr: 0.0.0.0:9067
+//	tls:
+//	  cert: cert.pem        # -tls-cert
+//	  key: key.pem          # -tls-key
+//	cache:
+//	  size: 100000          # -cache-size
+//	min-latency:            # repeatable flags take a map or a list
+//	  GetTransaction: 300ms
+//
+// Keys that don't name a flag are refused.
+func loadConfigFile(fs *flag.FlagSet, path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var config map[string]interface{}
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+
+	settings := make(map[string][]string)
+	for key, value := range config {
+		if err := flattenConfig(fs, key, key, value, settings); err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+	}
+
+	given := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+	names := make([]string, 0, len(settings))
+	for name := range settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if given[name] {
+			continue
+		}
+		for _, value := range settings[name] {
+			if err := fs.Set(name, value); err != nil {
+				return fmt.Errorf("%s: %s: %v", path, name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// flattenConfig adds the settings in value, found at path in the config file,
+// to settings, by flag name. name is the flag value is for, or the start of
+// the names of the flags in it if it's a section.
+func flattenConfig(fs *flag.FlagSet, name, path string, value interface{}, settings map[string][]string) error {
+	if name == "config" {
+		return fmt.Errorf("%s: a config file can't name another", path)
+	}
+	f := fs.Lookup(name)
+	repeatable := false
+	if f != nil {
+		_, repeatable = f.Value.(methodFlag)
+	}
+
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		if repeatable {
+			for method, methodValue := range v {
+				if !isScalar(methodValue) {
+					return fmt.Errorf("%s.%v: expected a single value", path, method)
+				}
+				settings[name] = append(settings[name], fmt.Sprintf("%v=%v", method, methodValue))
+			}
+			return nil
+		}
+		for key, child := range v {
+			if err := flattenConfig(fs, fmt.Sprintf("%s-%v", name, key), fmt.Sprintf("%s.%v", path, key), child, settings); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case []interface{}:
+		if f == nil {
+			return fmt.Errorf("unknown setting %q", path)
+		}
+		if !repeatable {
+			return fmt.Errorf("%s: expected a single value, not a list", path)
+		}
+		for _, item := range v {
+			if !isScalar(item) {
+				return fmt.Errorf("%s: expected a list of values", path)
+			}
+			settings[name] = append(settings[name], fmt.Sprint(item))
+		}
+		return nil
+	}
+
+	if f == nil {
+		return fmt.Errorf("unknown setting %q", path)
+	}
+	if !isScalar(value) {
+		return fmt.Errorf("%s: missing value", path)
+	}
+	if _, ok := settings[name]; ok && !repeatable {
+		return fmt.Errorf("%s: %s is set twice", path, name)
+	}
+	settings[name] = append(settings[name], fmt.Sprint(value))
+	return nil
+}
+
+// isScalar reports whether a value decoded from YAML is a single value.
+func isScalar(value interface{}) bool {
+	switch value.(type) {
+	case nil, map[interface{}]interface{}, []interface{}:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeTestConfig writes config to a file in dir and returns its path.
+func writeTestConfig(t *testing.T, dir, config string) string {
+	path := filepath.Join(dir, "lightwalletd.yml")
+	if err := ioutil.WriteFile(path, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lightwalletd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	bindAddr := fs.String("bind-addr", "127.0.0.1:9067", "")
+	tlsCert := fs.String("tls-cert", "", "")
+	noTLS := fs.Bool("no-tls", false, "")
+	cacheSize := fs.Int("cache-size", 40000, "")
+	minProgress := fs.Float64("send-min-verification-progress", 0.9999, "")
+	refresh := fs.Duration("tls-ocsp-refresh", time.Hour, "")
+	minLatency := methodFlag{}
+	fs.Var(minLatency, "min-latency", "")
+	deprecated := methodFlag{}
+	fs.Var(deprecated, "deprecate-method", "")
+
+	path := writeTestConfig(t, dir, `
+bind-addr: 0.0.0.0:9067
+no-tls: true
+tls:
+  cert: cert.pem
+  ocsp-refresh: 30m
+cache:
+  size: 1000
+send-min-verification-progress: 0.99
+min-latency:
+  GetTransaction: 300ms
+deprecate-method:
+  - GetAddressTxids=use GetTaddressTxids
+`)
+	// The command line wins over the file.
+	if err := fs.Parse([]string{"-cache-size", "5"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadConfigFile(fs, path); err != nil {
+		t.Fatal(err)
+	}
+	if *bindAddr != "0.0.0.0:9067" || !*noTLS || *tlsCert != "cert.pem" || *refresh != 30*time.Minute || *minProgress != 0.99 {
+		t.Errorf("settings not applied: %s %t %s %v %v", *bindAddr, *noTLS, *tlsCert, *refresh, *minProgress)
+	}
+	if *cacheSize != 5 {
+		t.Errorf("config file overrode the command line, cache size %d", *cacheSize)
+	}
+	if minLatency["GetTransaction"] != "300ms" || deprecated["GetAddressTxids"] != "use GetTaddressTxids" {
+		t.Errorf("repeatable flags set to %v and %v", minLatency, deprecated)
+	}
+}
+
+func TestLoadConfigFileErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lightwalletd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, tt := range []struct {
+		config string
+		want   string
+	}{
+		{"tls:\n  crt: cert.pem\n", `unknown setting "tls.crt"`},
+		{"bind-adr: 0.0.0.0:9067\n", `unknown setting "bind-adr"`},
+		{"cache-size: lots\n", "cache-size"},
+		{"cache-size: 1\ncache:\n  size: 2\n", "set twice"},
+		{"bind-addr: [a, b]\n", "not a list"},
+		{"config: other.yml\n", "can't name another"},
+		{"bind-addr: [\n", "yaml"},
+	} {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.String("bind-addr", "", "")
+		fs.String("tls-cert", "", "")
+		fs.Int("cache-size", 0, "")
+		fs.String("config", "", "")
+
+		err := loadConfigFile(fs, writeTestConfig(t, dir, tt.config))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%q: expected an error about %q, got %v", tt.config, tt.want, err)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+)
+
+// envPrefix starts the names of the environment variables the flags can be
+// given with: -cache-size is LWD_CACHE_SIZE.
+const envPrefix = "LWD_"
+
+// envName returns the environment variable for the flag called name.
+func envName(name string) string {
+	return envPrefix + strings.ToUpper(strings.Replace(name, "-", "_", -1))
+}
+
+// setFlagsFromEnv sets each flag of fs that wasn't given on the command line
+// from its environment variable, if that's set, as looked up with lookup.
+// Repeatable flags such as -min-latency take a comma-separated list.
+func setFlagsFromEnv(fs *flag.FlagSet, lookup func(string) (string, bool)) error {
+	given := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if err != nil || given[f.Name] {
+			return
+		}
+		value, ok := lookup(envName(f.Name))
+		if !ok {
+			return
+		}
+		values := []string{value}
+		if _, repeatable := f.Value.(methodFlag); repeatable {
+			values = strings.Split(value, ",")
+		}
+		for _, v := range values {
+			if setErr := fs.Set(f.Name, v); setErr != nil {
+				err = fmt.Errorf("%s: %v", envName(f.Name), setErr)
+				return
+			}
+		}
+	})
+	return err
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"testing"
+)
+
+func TestSetFlagsFromEnv(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	bindAddr := fs.String("bind-addr", "127.0.0.1:9067", "")
+	confFile := fs.String("conf-file", "", "")
+	cacheSize := fs.Int("cache-size", 40000, "")
+	minLatency := methodFlag{}
+	fs.Var(minLatency, "min-latency", "")
+
+	env := map[string]string{
+		"LWD_BIND_ADDR":   "0.0.0.0:9067",
+		"LWD_CONF_FILE":   "/etc/zcash.conf",
+		"LWD_CACHE_SIZE":  "1000",
+		"LWD_MIN_LATENCY": "GetTransaction=300ms,GetAddressTxids=500ms",
+	}
+	lookup := func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	}
+
+	// The command line wins over the environment.
+	if err := fs.Parse([]string{"-conf-file", "zcash.conf"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := setFlagsFromEnv(fs, lookup); err != nil {
+		t.Fatal(err)
+	}
+	if *bindAddr != "0.0.0.0:9067" || *cacheSize != 1000 {
+		t.Errorf("flags not set from the environment: %s, %d", *bindAddr, *cacheSize)
+	}
+	if *confFile != "zcash.conf" {
+		t.Errorf("environment overrode the command line: %s", *confFile)
+	}
+	if minLatency["GetTransaction"] != "300ms" || minLatency["GetAddressTxids"] != "500ms" {
+		t.Errorf("repeatable flag set to %v", minLatency)
+	}
+
+	fs = flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.Int("cache-size", 40000, "")
+	env["LWD_CACHE_SIZE"] = "lots"
+	if err := setFlagsFromEnv(fs, lookup); err == nil {
+		t.Error("expected a bad value to be rejected")
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"time"
+
+	"github.com/adityapk00/lightwalletd/common"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// healthService is the name the health of CompactTxStreamer is reported
+// under, besides the server's as a whole, "".
+const healthService = "cash.z.wallet.sdk.rpc.CompactTxStreamer"
+
+// checkHealth reports whether zcashd answers and the cache is at most maxLag
+// blocks behind it, and if not, why.
+func checkHealth(rpcClient common.RPCClient, cache *common.BlockCache, maxLag int) (bool, string) {
+	info, err := common.GetChainInfo(rpcClient)
+	if err != nil {
+		return false, "zcashd doesn't answer"
+	}
+	if lag := info.Blocks - cache.GetLatestBlock(); lag > maxLag {
+		return false, "the cache is behind zcashd"
+	}
+	return true, ""
+}
+
+// runHealthChecks sets the status reported by server, for the server and
+// for healthService, from checkHealth every interval, forever. The status
+// is NOT_SERVING until the first check passes.
+func runHealthChecks(server *health.Server, rpcClient common.RPCClient, cache *common.BlockCache, maxLag int, interval time.Duration, log *logrus.Entry) {
+	serving := healthpb.HealthCheckResponse_NOT_SERVING
+	server.SetServingStatus("", serving)
+	server.SetServingStatus(healthService, serving)
+
+	for {
+		ok, reason := checkHealth(rpcClient, cache, maxLag)
+		status := healthpb.HealthCheckResponse_NOT_SERVING
+		if ok {
+			status = healthpb.HealthCheckResponse_SERVING
+		}
+		if status != serving {
+			log.WithFields(logrus.Fields{
+				"status": status,
+				"reason": reason,
+			}).Info("health status changed")
+			serving = status
+			server.SetServingStatus("", serving)
+			server.SetServingStatus(healthService, serving)
+		}
+		time.Sleep(interval)
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/adityapk00/lightwalletd/common"
+	"github.com/adityapk00/lightwalletd/walletrpc"
+)
+
+func TestCheckHealth(t *testing.T) {
+	cache := common.NewBlockCache(10, log)
+	for height := 100; height <= 101; height++ {
+		if err, _ := cache.Add(height, &walletrpc.CompactBlock{Height: uint64(height)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tt := range []struct {
+		name string
+		node chainNode
+		ok   bool
+	}{
+		{"caught up", `{"chain": "main", "blocks": 101}`, true},
+		{"within the lag", `{"chain": "main", "blocks": 106}`, true},
+		{"behind", `{"chain": "main", "blocks": 107}`, false},
+		{"zcashd down", "", false},
+	} {
+		if ok, reason := checkHealth(tt.node, cache, 5); ok != tt.ok {
+			t.Errorf("%s: healthy %v (%s), expected %v", tt.name, ok, reason, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,795 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/adityapk00/lightwalletd/common"
+	"github.com/adityapk00/lightwalletd/walletrpc"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// chainUnaryInterceptors combines interceptors into one, the first being the
+// outermost. grpc-go only accepts a single unary interceptor per server.
+func chainUnaryInterceptors(interceptors ...grpc.UnaryServerInterceptor) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		chained := handler
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			interceptor, next := interceptors[i], chained
+			chained = func(ctx context.Context, req interface{}) (interface{}, error) {
+				return interceptor(ctx, req, info, next)
+			}
+		}
+		return chained(ctx, req)
+	}
+}
+
+// chainStreamInterceptors is the streaming counterpart of chainUnaryInterceptors.
+func chainStreamInterceptors(interceptors ...grpc.StreamServerInterceptor) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		chained := handler
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			interceptor, next := interceptors[i], chained
+			chained = func(srv interface{}, ss grpc.ServerStream) error {
+				return interceptor(srv, ss, info, next)
+			}
+		}
+		return chained(srv, ss)
+	}
+}
+
+// methodName returns the bare method name ("GetBlock") of a full gRPC method
+// name ("/cash.z.wallet.sdk.rpc.CompactTxStreamer/GetBlock").
+func methodName(fullMethod string) string {
+	return fullMethod[strings.LastIndex(fullMethod, "/")+1:]
+}
+
+// methodFlag is a repeatable command line flag of the form -flag Method=value.
+type methodFlag map[string]string
+
+func (f methodFlag) String() string {
+	pairs := make([]string, 0, len(f))
+	for method, value := range f {
+		pairs = append(pairs, method+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (f methodFlag) Set(s string) error {
+	parts := strings.SplitN(s, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return fmt.Errorf("expected Method=value, got %q", s)
+	}
+	f[parts[0]] = parts[1]
+	return nil
+}
+
+// Methods returns the configured method names, sorted.
+func (f methodFlag) Methods() []string {
+	methods := make([]string, 0, len(f))
+	for method := range f {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+	return methods
+}
+
+// validateMethods checks that every method in f is served by server.
+func validateMethods(f methodFlag, server *grpc.Server) error {
+	known := make(map[string]bool)
+	for _, info := range server.GetServiceInfo() {
+		for _, method := range info.Methods {
+			known[method.Name] = true
+		}
+	}
+	for _, method := range f.Methods() {
+		if !known[method] {
+			return fmt.Errorf("unknown method %q", method)
+		}
+	}
+	return nil
+}
+
+// deprecationHeader is the response header carrying the deprecation notice
+// of a deprecated method.
+const deprecationHeader = "x-lightwalletd-deprecated"
+
+func logDeprecatedCall(ctx context.Context, fullMethod, notice string) {
+	loggerFromContext(ctx).WithFields(logrus.Fields{
+		"method": fullMethod,
+		"notice": notice,
+	}).Warn("deprecated method called")
+}
+
+// deprecationUnaryInterceptor flags calls to the deprecated methods, which
+// map a method name to a notice for the client, without blocking them.
+func deprecationUnaryInterceptor(deprecated methodFlag) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if notice, ok := deprecated[methodName(info.FullMethod)]; ok {
+			logDeprecatedCall(ctx, info.FullMethod, notice)
+			grpc.SetHeader(ctx, metadata.Pairs(deprecationHeader, notice))
+		}
+		return handler(ctx, req)
+	}
+}
+
+func deprecationStreamInterceptor(deprecated methodFlag) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if notice, ok := deprecated[methodName(info.FullMethod)]; ok {
+			logDeprecatedCall(ss.Context(), info.FullMethod, notice)
+			ss.SetHeader(metadata.Pairs(deprecationHeader, notice))
+		}
+		return handler(srv, ss)
+	}
+}
+
+// parseMethodDurations parses the values of f as durations.
+func parseMethodDurations(f methodFlag) (map[string]time.Duration, error) {
+	durations := make(map[string]time.Duration, len(f))
+	for method, value := range f {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", method, err)
+		}
+		durations[method] = d
+	}
+	return durations, nil
+}
+
+// methodLogging is how the successful calls of a method are logged: at level,
+// and only one call in every. A nil *methodLogging logs every call at Info.
+type methodLogging struct {
+	level logrus.Level
+	every uint64
+	calls uint64
+}
+
+// sample counts a call, and reports whether it's one to log.
+func (l *methodLogging) sample() bool {
+	if l == nil || l.every <= 1 {
+		return true
+	}
+	return (atomic.AddUint64(&l.calls, 1)-1)%l.every == 0
+}
+
+func (l *methodLogging) getLevel() logrus.Level {
+	if l == nil {
+		return logrus.InfoLevel
+	}
+	return l.level
+}
+
+// parseMethodLogging parses the values of f as a log level ("debug"),
+// optionally followed by how many calls to log one of ("debug/100").
+func parseMethodLogging(f methodFlag) (map[string]*methodLogging, error) {
+	methods := make(map[string]*methodLogging, len(f))
+	for method, value := range f {
+		parts := strings.SplitN(value, "/", 2)
+		level, err := logrus.ParseLevel(parts[0])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", method, err)
+		}
+		logging := &methodLogging{level: level, every: 1}
+		if len(parts) == 2 {
+			logging.every, err = strconv.ParseUint(parts[1], 10, 64)
+			if err != nil || logging.every == 0 {
+				return nil, fmt.Errorf("%s: bad sampling %q, expected a positive number of calls", method, parts[1])
+			}
+		}
+		methods[method] = logging
+	}
+	return methods, nil
+}
+
+// waitUntil sleeps until deadline, or until ctx is done.
+func waitUntil(ctx context.Context, deadline time.Time) {
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+	}
+}
+
+// minLatencyUnaryInterceptor holds back the responses, errors included, of
+// the methods in floors until the given time has passed since the call
+// started. Whether a transaction or address query was answered from the cache
+// or had to go to zcashd then can't be told apart by how quickly it returned,
+// so an observer timing a client can't learn whether the data was recently
+// asked for by someone else.
+func minLatencyUnaryInterceptor(floors map[string]time.Duration) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		floor, ok := floors[methodName(info.FullMethod)]
+		if !ok {
+			return handler(ctx, req)
+		}
+
+		deadline := time.Now().Add(floor)
+		resp, err := handler(ctx, req)
+		waitUntil(ctx, deadline)
+		return resp, err
+	}
+}
+
+// paddedServerStream holds back the first message of a stream until deadline.
+type paddedServerStream struct {
+	grpc.ServerStream
+	deadline time.Time
+	sent     bool
+}
+
+func (s *paddedServerStream) SendMsg(m interface{}) error {
+	if !s.sent {
+		waitUntil(s.Context(), s.deadline)
+		s.sent = true
+	}
+	return s.ServerStream.SendMsg(m)
+}
+
+// minLatencyStreamInterceptor is the streaming counterpart of
+// minLatencyUnaryInterceptor. It pads the time to the first message, or to the
+// end of the stream if there are none.
+func minLatencyStreamInterceptor(floors map[string]time.Duration) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		floor, ok := floors[methodName(info.FullMethod)]
+		if !ok {
+			return handler(srv, ss)
+		}
+
+		padded := &paddedServerStream{ServerStream: ss, deadline: time.Now().Add(floor)}
+		err := handler(srv, padded)
+		if !padded.sent {
+			waitUntil(ss.Context(), padded.deadline)
+		}
+		return err
+	}
+}
+
+// requestIDTrailer is the response trailer carrying the ID under which a call
+// was logged, so that clients can quote it when reporting problems.
+const requestIDTrailer = "x-request-id"
+
+type requestIDKey struct{}
+
+func newRequestID() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// requestIDFromContext returns the ID of the call ctx belongs to, if any.
+func requestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// contextServerStream replaces the context of a grpc.ServerStream.
+type contextServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextServerStream) Context() context.Context {
+	return s.ctx
+}
+
+// requestIDUnaryInterceptor gives each call an ID, which loggerFromContext
+// adds to the call's log entries, and if trailer is set, returns it to the
+// client in the requestIDTrailer trailer.
+func requestIDUnaryInterceptor(trailer bool) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		id := newRequestID()
+		if trailer {
+			grpc.SetTrailer(ctx, metadata.Pairs(requestIDTrailer, id))
+		}
+		return handler(context.WithValue(ctx, requestIDKey{}, id), req)
+	}
+}
+
+func requestIDStreamInterceptor(trailer bool) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		id := newRequestID()
+		if trailer {
+			ss.SetTrailer(metadata.Pairs(requestIDTrailer, id))
+		}
+		ctx := context.WithValue(ss.Context(), requestIDKey{}, id)
+		return handler(srv, &contextServerStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+// retryAfterHeader carries the number of seconds after which a shed request
+// may be retried, for clients that don't decode error details.
+const retryAfterHeader = "retry-after"
+
+// loadShedder caps the number of calls served at once. A call arriving when
+// all slots are taken waits up to queueWait for one, and is then turned away
+// with Unavailable and a hint to retry after retryAfter. Subscriptions, which
+// stay open as long as the client wants and mostly wait for new blocks,
+// don't hold a slot: subscriptionMethods never take one, and GetBlockRange
+// gives its slot back once it's asked to follow the tip.
+type loadShedder struct {
+	slots      chan struct{}
+	queueWait  time.Duration
+	retryAfter time.Duration
+	shed       prometheus.Counter
+}
+
+func newLoadShedder(maxConcurrent int, queueWait, retryAfter time.Duration, shed prometheus.Counter) *loadShedder {
+	return &loadShedder{
+		slots:      make(chan struct{}, maxConcurrent),
+		queueWait:  queueWait,
+		retryAfter: retryAfter,
+		shed:       shed,
+	}
+}
+
+// acquire takes a slot, returning a function to give it back, or the error to
+// shed the call with.
+func (l *loadShedder) acquire(ctx context.Context) (func(), error) {
+	select {
+	case l.slots <- struct{}{}:
+		return func() { <-l.slots }, nil
+	default:
+	}
+
+	if l.queueWait > 0 {
+		timer := time.NewTimer(l.queueWait)
+		defer timer.Stop()
+		select {
+		case l.slots <- struct{}{}:
+			return func() { <-l.slots }, nil
+		case <-ctx.Done():
+			return nil, status.FromContextError(ctx.Err()).Err()
+		case <-timer.C:
+		}
+	}
+
+	l.shed.Inc()
+	st := status.New(codes.Unavailable, "server busy, retry later")
+	if detailed, err := st.WithDetails(&errdetails.RetryInfo{
+		RetryDelay: ptypes.DurationProto(l.retryAfter),
+	}); err == nil {
+		st = detailed
+	}
+	return nil, st.Err()
+}
+
+func (l *loadShedder) retryAfterMD() metadata.MD {
+	return metadata.Pairs(retryAfterHeader, strconv.Itoa(int(math.Ceil(l.retryAfter.Seconds()))))
+}
+
+// unaryInterceptor sheds unary calls; a nil loadShedder lets everything through.
+func (l *loadShedder) unaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if l == nil {
+			return handler(ctx, req)
+		}
+		release, err := l.acquire(ctx)
+		if err != nil {
+			grpc.SetHeader(ctx, l.retryAfterMD())
+			return nil, err
+		}
+		defer release()
+		return handler(ctx, req)
+	}
+}
+
+// subscriptionMethods are the streams the load shedder lets through without
+// a slot.
+var subscriptionMethods = map[string]bool{
+	"SubscribeBlocks": true,
+	"SubscribeReorgs": true,
+}
+
+func (l *loadShedder) streamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if l == nil || subscriptionMethods[methodName(info.FullMethod)] {
+			return handler(srv, ss)
+		}
+		release, err := l.acquire(ss.Context())
+		if err != nil {
+			ss.SetHeader(l.retryAfterMD())
+			return err
+		}
+		var once sync.Once
+		stream := &followingStream{ServerStream: ss, release: func() { once.Do(release) }}
+		defer stream.release()
+		return handler(srv, stream)
+	}
+}
+
+// followingStream calls release when it receives a BlockRange that follows
+// the tip, which makes the call a subscription.
+type followingStream struct {
+	grpc.ServerStream
+	release func()
+}
+
+func (s *followingStream) RecvMsg(m interface{}) error {
+	err := s.ServerStream.RecvMsg(m)
+	if span, ok := m.(*walletrpc.BlockRange); ok && err == nil && span.Follow {
+		s.release()
+	}
+	return err
+}
+
+// peerQuota is how many calls to a method a peer may make per window.
+type peerQuota struct {
+	limit  int
+	window time.Duration
+}
+
+// parsePeerQuotas parses the values of f as quotas of the form calls/window,
+// "1000/24h" for example.
+func parsePeerQuotas(f methodFlag) (map[string]peerQuota, error) {
+	quotas := make(map[string]peerQuota, len(f))
+	for method, value := range f {
+		parts := strings.SplitN(value, "/", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("%s: expected calls/window, got %q", method, value)
+		}
+		limit, err := strconv.Atoi(parts[0])
+		if err != nil || limit < 1 {
+			return nil, fmt.Errorf("%s: bad number of calls %q", method, parts[0])
+		}
+		window, err := time.ParseDuration(parts[1])
+		if err != nil || window <= 0 {
+			return nil, fmt.Errorf("%s: bad window %q", method, parts[1])
+		}
+		quotas[method] = peerQuota{limit: limit, window: window}
+	}
+	return quotas, nil
+}
+
+type quotaKey struct {
+	method string
+	peer   string
+}
+
+// quotaUsage counts a peer's calls to a method in the window started at start.
+type quotaUsage struct {
+	start time.Time
+	calls int
+}
+
+// quotaLimiter holds each peer to the quotas of the methods it calls. A
+// peer's window starts with its first call, and its count starts over once
+// the window has passed. At most maxPeers peer and method pairs are tracked;
+// when more turn up, those whose windows ended are forgotten first, then
+// those whose windows started earliest.
+type quotaLimiter struct {
+	quotas   map[string]peerQuota
+	maxPeers int
+	rejected *prometheus.CounterVec
+	now      func() time.Time
+
+	mutex sync.Mutex
+	usage map[quotaKey]*quotaUsage
+}
+
+func newQuotaLimiter(quotas map[string]peerQuota, maxPeers int, rejected *prometheus.CounterVec) *quotaLimiter {
+	return &quotaLimiter{
+		quotas:   quotas,
+		maxPeers: maxPeers,
+		rejected: rejected,
+		now:      time.Now,
+		usage:    make(map[quotaKey]*quotaUsage),
+	}
+}
+
+// use counts a call to method by peer, returning the error to refuse it with
+// if the peer's quota is used up.
+func (l *quotaLimiter) use(method, peer string) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	quota, ok := l.quotas[method]
+	if !ok {
+		return nil
+	}
+
+	now := l.now()
+	key := quotaKey{method, peer}
+	usage, ok := l.usage[key]
+	if ok && now.Sub(usage.start) >= quota.window {
+		ok = false
+	}
+	if !ok {
+		if _, tracked := l.usage[key]; !tracked && len(l.usage) >= l.maxPeers {
+			l.forgetOne(now)
+		}
+		usage = &quotaUsage{start: now}
+		l.usage[key] = usage
+	}
+
+	if usage.calls >= quota.limit {
+		l.rejected.WithLabelValues(method).Inc()
+		reset := usage.start.Add(quota.window).Sub(now)
+		st := status.Newf(codes.ResourceExhausted, "quota of %d %s calls per %v used up, it resets in %v",
+			quota.limit, method, quota.window, reset.Round(time.Second))
+		if detailed, err := st.WithDetails(&errdetails.RetryInfo{
+			RetryDelay: ptypes.DurationProto(reset),
+		}); err == nil {
+			st = detailed
+		}
+		return st.Err()
+	}
+	usage.calls++
+	return nil
+}
+
+// setQuotas replaces the quotas. The calls peers already made in their
+// current windows still count.
+func (l *quotaLimiter) setQuotas(quotas map[string]peerQuota) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.quotas = quotas
+}
+
+// forgetOne makes room for a new peer. The caller holds the mutex.
+func (l *quotaLimiter) forgetOne(now time.Time) {
+	var oldest quotaKey
+	var oldestStart time.Time
+	for key, usage := range l.usage {
+		if now.Sub(usage.start) >= l.quotas[key.method].window {
+			delete(l.usage, key)
+			return
+		}
+		if oldestStart.IsZero() || usage.start.Before(oldestStart) {
+			oldest, oldestStart = key, usage.start
+		}
+	}
+	delete(l.usage, oldest)
+}
+
+// unaryInterceptor applies the quotas to unary calls; a nil quotaLimiter lets
+// everything through.
+func (l *quotaLimiter) unaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if l == nil {
+			return handler(ctx, req)
+		}
+		if err := l.use(methodName(info.FullMethod), peerAddr(ctx)); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+func (l *quotaLimiter) streamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if l == nil {
+			return handler(srv, ss)
+		}
+		if err := l.use(methodName(info.FullMethod), peerAddr(ss.Context())); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
+
+// memoryBudget gives each call a common.RequestMemory of limit bytes. Stream
+// messages are reserved while they're being sent, and the handlers reserve
+// what else they buffer. A call that goes over its budget is failed with
+// ResourceExhausted and counted in aborted. A nil memoryBudget sets no budget.
+type memoryBudget struct {
+	limit   int
+	aborted *prometheus.CounterVec
+}
+
+func newMemoryBudget(limit int, aborted *prometheus.CounterVec) *memoryBudget {
+	if limit <= 0 {
+		return nil
+	}
+	return &memoryBudget{limit: limit, aborted: aborted}
+}
+
+// check turns the error of a call that went over its budget into
+// ResourceExhausted.
+func (b *memoryBudget) check(memory *common.RequestMemory, method string, err error) error {
+	if err == nil || !memory.Exceeded() {
+		return err
+	}
+	b.aborted.WithLabelValues(method).Inc()
+	return status.Error(codes.ResourceExhausted, err.Error())
+}
+
+// budgetServerStream reserves each message sent on a stream until it's sent.
+type budgetServerStream struct {
+	grpc.ServerStream
+	ctx    context.Context
+	memory *common.RequestMemory
+}
+
+func (s *budgetServerStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *budgetServerStream) SendMsg(m interface{}) error {
+	size := 0
+	if msg, ok := m.(proto.Message); ok {
+		size = proto.Size(msg)
+	}
+	if err := s.memory.Reserve(size); err != nil {
+		return err
+	}
+	defer s.memory.Release(size)
+	return s.ServerStream.SendMsg(m)
+}
+
+func (b *memoryBudget) unaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if b == nil {
+			return handler(ctx, req)
+		}
+		memory := common.NewRequestMemory(b.limit)
+		resp, err := handler(common.WithRequestMemory(ctx, memory), req)
+		return resp, b.check(memory, methodName(info.FullMethod), err)
+	}
+}
+
+func (b *memoryBudget) streamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if b == nil {
+			return handler(srv, ss)
+		}
+		memory := common.NewRequestMemory(b.limit)
+		err := handler(srv, &budgetServerStream{
+			ServerStream: ss,
+			ctx:          common.WithRequestMemory(ss.Context(), memory),
+			memory:       memory,
+		})
+		return b.check(memory, methodName(info.FullMethod), err)
+	}
+}
+
+// peerAddr returns the IP address a call came from, as for logging.
+func peerAddr(ctx context.Context) string {
+	if peerInfo, ok := peer.FromContext(ctx); ok {
+		if ip, _, err := net.SplitHostPort(peerInfo.Addr.String()); err == nil {
+			return ip
+		}
+		return peerInfo.Addr.String()
+	}
+	return "unknown"
+}
+
+// trustedProxies are the networks of the proxies whose x-real-ip and
+// x-forwarded-for headers are believed. Peers on a Unix socket are always
+// trusted: they're proxies on the same host.
+type trustedProxies []*net.IPNet
+
+// parseTrustedProxies parses a comma-separated list of CIDR networks and
+// IP addresses.
+func parseTrustedProxies(s string) (trustedProxies, error) {
+	var proxies trustedProxies
+	for _, entry := range splitList(s) {
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("bad IP address %q", entry)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			proxies = append(proxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, err
+		}
+		proxies = append(proxies, network)
+	}
+	return proxies, nil
+}
+
+func (p trustedProxies) contains(ip net.IP) bool {
+	for _, network := range p {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p trustedProxies) trusts(addr net.Addr) bool {
+	if addr.Network() == "unix" {
+		return true
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && p.contains(ip)
+}
+
+// forwardedAddr is the address of a client, as given by a proxy.
+type forwardedAddr string
+
+func (forwardedAddr) Network() string {
+	return "tcp"
+}
+
+func (a forwardedAddr) String() string {
+	return string(a)
+}
+
+// clientAddr returns the peer of the call in ctx with the address of the
+// client behind it, if the peer is a trusted proxy that gave one: in
+// x-real-ip, or else in x-forwarded-for, where the client is the last
+// address that isn't a trusted proxy's. Headers from other peers are
+// ignored, as any client could set them.
+func (p trustedProxies) clientAddr(ctx context.Context) (*peer.Peer, bool) {
+	peerInfo, ok := peer.FromContext(ctx)
+	if !ok || !p.trusts(peerInfo.Addr) {
+		return nil, false
+	}
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return nil, false
+	}
+	var client net.IP
+	if realIP := md.Get("x-real-ip"); len(realIP) > 0 {
+		client = net.ParseIP(strings.TrimSpace(realIP[0]))
+	} else {
+		var hops []string
+		for _, header := range md.Get("x-forwarded-for") {
+			hops = append(hops, splitList(header)...)
+		}
+		for i := len(hops) - 1; i >= 0; i-- {
+			client = net.ParseIP(hops[i])
+			if client == nil || !p.contains(client) {
+				break
+			}
+		}
+	}
+	if client == nil {
+		return nil, false
+	}
+	return &peer.Peer{Addr: forwardedAddr(client.String()), AuthInfo: peerInfo.AuthInfo}, true
+}
+
+// clientAddrUnaryInterceptor replaces the peer of calls relayed by trusted
+// proxies with the client they give, for everything after it: logs,
+// quotas and metrics.
+func clientAddrUnaryInterceptor(proxies trustedProxies) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if client, ok := proxies.clientAddr(ctx); ok {
+			ctx = peer.NewContext(ctx, client)
+		}
+		return handler(ctx, req)
+	}
+}
+
+func clientAddrStreamInterceptor(proxies trustedProxies) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if client, ok := proxies.clientAddr(ss.Context()); ok {
+			ss = &contextServerStream{ServerStream: ss, ctx: peer.NewContext(ss.Context(), client)}
+		}
+		return handler(srv, ss)
+	}
+}
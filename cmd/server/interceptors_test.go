@@ -0,0 +1,640 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/adityapk00/lightwalletd/walletrpc"
+)
+
+// stubStreamer answers a few methods with fixed responses.
+type stubStreamer struct {
+	walletrpc.UnimplementedCompactTxStreamerServer
+}
+
+func (s *stubStreamer) GetLatestBlock(ctx context.Context, in *walletrpc.ChainSpec) (*walletrpc.BlockID, error) {
+	return &walletrpc.BlockID{Height: 1}, nil
+}
+
+func (s *stubStreamer) GetLightdInfo(ctx context.Context, in *walletrpc.Empty) (*walletrpc.LightdInfo, error) {
+	return &walletrpc.LightdInfo{}, nil
+}
+
+func (s *stubStreamer) GetBlockRange(span *walletrpc.BlockRange, resp walletrpc.CompactTxStreamer_GetBlockRangeServer) error {
+	for h := span.Start.Height; h <= span.End.Height; h++ {
+		if err := resp.Send(&walletrpc.CompactBlock{Height: h}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestChainInterceptorsOrder(t *testing.T) {
+	var order []string
+	record := func(name string) grpc.UnaryServerInterceptor {
+		return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			order = append(order, name)
+			return handler(ctx, req)
+		}
+	}
+
+	server := grpc.NewServer(grpc.UnaryInterceptor(chainUnaryInterceptors(record("a"), record("b"), record("c"))))
+	defer server.Stop()
+	client := startTestServer(t, server, &stubStreamer{})
+
+	if _, err := client.GetLatestBlock(context.Background(), &walletrpc.ChainSpec{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(order) != 3 || order[0] != "a" || order[1] != "b" || order[2] != "c" {
+		t.Errorf("interceptors ran in order %v", order)
+	}
+}
+
+func TestDeprecationHeader(t *testing.T) {
+	deprecated := methodFlag{}
+	deprecated.Set("GetLatestBlock=use SubscribeBlocks instead")
+	deprecated.Set("GetBlockRange=going away")
+
+	server := grpc.NewServer(
+		grpc.UnaryInterceptor(chainUnaryInterceptors(deprecationUnaryInterceptor(deprecated))),
+		grpc.StreamInterceptor(chainStreamInterceptors(deprecationStreamInterceptor(deprecated))),
+	)
+	defer server.Stop()
+	client := startTestServer(t, server, &stubStreamer{})
+	ctx := context.Background()
+
+	var header metadata.MD
+	if _, err := client.GetLatestBlock(ctx, &walletrpc.ChainSpec{}, grpc.Header(&header)); err != nil {
+		t.Fatal(err)
+	}
+	if notice := header.Get(deprecationHeader); len(notice) != 1 || notice[0] != "use SubscribeBlocks instead" {
+		t.Errorf("expected the deprecation notice, got %v", notice)
+	}
+
+	header = nil
+	if _, err := client.GetLightdInfo(ctx, &walletrpc.Empty{}, grpc.Header(&header)); err != nil {
+		t.Fatal(err)
+	}
+	if notice := header.Get(deprecationHeader); len(notice) != 0 {
+		t.Errorf("unexpected deprecation notice %v", notice)
+	}
+
+	stream, err := client.GetBlockRange(ctx, &walletrpc.BlockRange{
+		Start: &walletrpc.BlockID{Height: 1},
+		End:   &walletrpc.BlockID{Height: 2},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for {
+		if _, err := stream.Recv(); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal("deprecated methods should still be served:", err)
+		}
+	}
+	header, _ = stream.Header()
+	if notice := header.Get(deprecationHeader); len(notice) != 1 || notice[0] != "going away" {
+		t.Errorf("expected the stream deprecation notice, got %v", notice)
+	}
+}
+
+func TestValidateMethods(t *testing.T) {
+	server := grpc.NewServer()
+	walletrpc.RegisterCompactTxStreamerServer(server, &stubStreamer{})
+
+	good := methodFlag{"GetBlock": "x", "GetAddressTxids": "y"}
+	if err := validateMethods(good, server); err != nil {
+		t.Error(err)
+	}
+
+	bad := methodFlag{"GetBlok": "x"}
+	if err := validateMethods(bad, server); err == nil {
+		t.Error("expected a misspelled method to be rejected")
+	}
+
+	if err := (methodFlag{}).Set("no-equals-sign"); err == nil {
+		t.Error("expected a malformed flag value to be rejected")
+	}
+}
+
+func TestMinLatency(t *testing.T) {
+	floor := 50 * time.Millisecond
+	floors, err := parseMethodDurations(methodFlag{"GetLatestBlock": "50ms", "GetBlockRange": "50ms"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := grpc.NewServer(
+		grpc.UnaryInterceptor(chainUnaryInterceptors(minLatencyUnaryInterceptor(floors))),
+		grpc.StreamInterceptor(chainStreamInterceptors(minLatencyStreamInterceptor(floors))),
+	)
+	defer server.Stop()
+	client := startTestServer(t, server, &stubStreamer{})
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		start := time.Now()
+		if _, err := client.GetLatestBlock(ctx, &walletrpc.ChainSpec{}); err != nil {
+			t.Fatal(err)
+		}
+		if elapsed := time.Since(start); elapsed < floor {
+			t.Errorf("GetLatestBlock answered in %v, before the %v floor", elapsed, floor)
+		}
+	}
+
+	start := time.Now()
+	stream, err := client.GetBlockRange(ctx, &walletrpc.BlockRange{
+		Start: &walletrpc.BlockID{Height: 1},
+		End:   &walletrpc.BlockID{Height: 2},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.Recv(); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < floor {
+		t.Errorf("first block arrived in %v, before the %v floor", elapsed, floor)
+	}
+
+	// Methods without a floor aren't held back.
+	start = time.Now()
+	if _, err := client.GetLightdInfo(ctx, &walletrpc.Empty{}); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed >= floor {
+		t.Errorf("GetLightdInfo was padded to %v", elapsed)
+	}
+
+	if _, err := parseMethodDurations(methodFlag{"GetLatestBlock": "soon"}); err == nil {
+		t.Error("expected a bad duration to be rejected")
+	}
+}
+
+func TestRequestIDTrailer(t *testing.T) {
+	hook := test.NewLocal(logger)
+	logger.SetLevel(logrus.InfoLevel)
+	defer func() {
+		logger.SetLevel(logrus.PanicLevel)
+		logger.ReplaceHooks(make(logrus.LevelHooks))
+	}()
+
+	server := grpc.NewServer(
+		grpc.UnaryInterceptor(chainUnaryInterceptors(requestIDUnaryInterceptor(true), logInterceptor(nil))),
+		grpc.StreamInterceptor(chainStreamInterceptors(requestIDStreamInterceptor(true), streamLogInterceptor(nil))),
+	)
+	defer server.Stop()
+	client := startTestServer(t, server, &stubStreamer{})
+	ctx := context.Background()
+
+	// loggedID returns the request_id of the log entry for method.
+	loggedID := func(method string) string {
+		for _, entry := range hook.AllEntries() {
+			if entry.Data["method"] == "/cash.z.wallet.sdk.rpc.CompactTxStreamer/"+method {
+				id, _ := entry.Data["request_id"].(string)
+				return id
+			}
+		}
+		return ""
+	}
+
+	var trailer metadata.MD
+	if _, err := client.GetLatestBlock(ctx, &walletrpc.ChainSpec{}, grpc.Trailer(&trailer)); err != nil {
+		t.Fatal(err)
+	}
+	ids := trailer.Get(requestIDTrailer)
+	if len(ids) != 1 || ids[0] == "" {
+		t.Fatalf("expected a request ID trailer, got %v", trailer)
+	}
+	if logged := loggedID("GetLatestBlock"); logged != ids[0] {
+		t.Errorf("trailer has request ID %q, logs have %q", ids[0], logged)
+	}
+
+	stream, err := client.GetBlockRange(ctx, &walletrpc.BlockRange{
+		Start: &walletrpc.BlockID{Height: 1},
+		End:   &walletrpc.BlockID{Height: 2},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for {
+		if _, err := stream.Recv(); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+	}
+	streamIDs := stream.Trailer().Get(requestIDTrailer)
+	if len(streamIDs) != 1 || streamIDs[0] == ids[0] {
+		t.Fatalf("expected a new request ID trailer on the stream, got %v", streamIDs)
+	}
+	if logged := loggedID("GetBlockRange"); logged != streamIDs[0] {
+		t.Errorf("stream trailer has request ID %q, logs have %q", streamIDs[0], logged)
+	}
+}
+
+func TestMethodLogLevels(t *testing.T) {
+	hook := test.NewLocal(logger)
+	logger.SetLevel(logrus.InfoLevel)
+	defer func() {
+		logger.SetLevel(logrus.PanicLevel)
+		logger.ReplaceHooks(make(logrus.LevelHooks))
+	}()
+
+	methods, err := parseMethodLogging(methodFlag{
+		"GetLatestBlock": "debug",
+		"GetBlockRange":  "info/2",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer(
+		grpc.UnaryInterceptor(chainUnaryInterceptors(logInterceptor(methods))),
+		grpc.StreamInterceptor(chainStreamInterceptors(streamLogInterceptor(methods))),
+	)
+	defer server.Stop()
+	client := startTestServer(t, server, &stubStreamer{})
+	ctx := context.Background()
+
+	logged := func(method string) int {
+		n := 0
+		for _, entry := range hook.AllEntries() {
+			if entry.Data["method"] == "/cash.z.wallet.sdk.rpc.CompactTxStreamer/"+method {
+				n++
+			}
+		}
+		return n
+	}
+
+	if _, err := client.GetLatestBlock(ctx, &walletrpc.ChainSpec{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.GetLightdInfo(ctx, &walletrpc.Empty{}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		stream, err := client.GetBlockRange(ctx, &walletrpc.BlockRange{
+			Start: &walletrpc.BlockID{Height: 1},
+			End:   &walletrpc.BlockID{Height: 1},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for {
+			if _, err := stream.Recv(); err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	if n := logged("GetLatestBlock"); n != 0 {
+		t.Errorf("GetLatestBlock, configured to Debug, logged %d times at Info", n)
+	}
+	if n := logged("GetLightdInfo"); n != 1 {
+		t.Errorf("GetLightdInfo logged %d times, expected once", n)
+	}
+	if n := logged("GetBlockRange"); n != 2 {
+		t.Errorf("GetBlockRange logged %d of 3 calls, expected 2 (one in two)", n)
+	}
+
+	logger.SetLevel(logrus.DebugLevel)
+	if _, err := client.GetLatestBlock(ctx, &walletrpc.ChainSpec{}); err != nil {
+		t.Fatal(err)
+	}
+	if n := logged("GetLatestBlock"); n != 1 {
+		t.Errorf("GetLatestBlock logged %d times at Debug, expected once", n)
+	}
+
+	for _, bad := range []string{"loud", "info/0", "info/some"} {
+		if _, err := parseMethodLogging(methodFlag{"GetBlock": bad}); err == nil {
+			t.Errorf("expected %q to be rejected", bad)
+		}
+	}
+}
+
+func TestPeerQuotas(t *testing.T) {
+	loopback, err := parseTrustedProxies("127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	quotas, err := parsePeerQuotas(methodFlag{"GetLatestBlock": "2/1h"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rejected := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "test_quota_rejections", Help: "test counter"}, []string{"method"})
+	limiter := newQuotaLimiter(quotas, 100, rejected)
+	now := time.Now()
+	limiter.now = func() time.Time { return now }
+
+	server := grpc.NewServer(
+		grpc.UnaryInterceptor(chainUnaryInterceptors(clientAddrUnaryInterceptor(loopback), limiter.unaryInterceptor())),
+		grpc.StreamInterceptor(chainStreamInterceptors(clientAddrStreamInterceptor(loopback), limiter.streamInterceptor())),
+	)
+	defer server.Stop()
+	client := startTestServer(t, server, &stubStreamer{})
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		if _, err := client.GetLatestBlock(ctx, &walletrpc.ChainSpec{}); err != nil {
+			t.Fatalf("call %d within the quota failed: %v", i, err)
+		}
+	}
+	_, err = client.GetLatestBlock(ctx, &walletrpc.ChainSpec{})
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("expected ResourceExhausted past the quota, got %v", err)
+	}
+	if n := testutil.ToFloat64(rejected.WithLabelValues("GetLatestBlock")); n != 1 {
+		t.Errorf("counted %v rejections, expected 1", n)
+	}
+
+	// Other methods, and other peers, have quotas of their own.
+	if _, err := client.GetLightdInfo(ctx, &walletrpc.Empty{}); err != nil {
+		t.Errorf("method without a quota refused: %v", err)
+	}
+	otherPeer := metadata.AppendToOutgoingContext(ctx, "x-real-ip", "203.0.113.7")
+	if _, err := client.GetLatestBlock(otherPeer, &walletrpc.ChainSpec{}); err != nil {
+		t.Errorf("another peer was refused: %v", err)
+	}
+
+	// The quota resets once the window has passed.
+	now = now.Add(time.Hour)
+	if _, err := client.GetLatestBlock(ctx, &walletrpc.ChainSpec{}); err != nil {
+		t.Errorf("quota not reset after the window: %v", err)
+	}
+
+	for _, bad := range []string{"2", "none/1h", "0/1h", "2/soon", "2/-1h"} {
+		if _, err := parsePeerQuotas(methodFlag{"GetBlock": bad}); err == nil {
+			t.Errorf("expected %q to be rejected", bad)
+		}
+	}
+}
+
+func TestPeerQuotasBounded(t *testing.T) {
+	quotas := map[string]peerQuota{"GetBlock": {limit: 1, window: time.Hour}}
+	rejected := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "test_quota_rejections", Help: "test counter"}, []string{"method"})
+	limiter := newQuotaLimiter(quotas, 2, rejected)
+	now := time.Now()
+	limiter.now = func() time.Time { return now }
+
+	for _, peer := range []string{"a", "b", "c"} {
+		if err := limiter.use("GetBlock", peer); err != nil {
+			t.Fatalf("%s: %v", peer, err)
+		}
+		now = now.Add(time.Minute)
+	}
+	if len(limiter.usage) != 2 {
+		t.Fatalf("tracking %d peers, expected at most 2", len(limiter.usage))
+	}
+	if _, ok := limiter.usage[quotaKey{"GetBlock", "a"}]; ok {
+		t.Error("expected the earliest peer to be forgotten")
+	}
+	if err := limiter.use("GetBlock", "c"); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("expected the latest peer's quota to be kept, got %v", err)
+	}
+}
+
+func TestRequestMemoryBudget(t *testing.T) {
+	aborted := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "test_memory_aborts", Help: "test counter"}, []string{"method"})
+	budget := newMemoryBudget(2, aborted)
+	server := grpc.NewServer(
+		grpc.UnaryInterceptor(chainUnaryInterceptors(budget.unaryInterceptor())),
+		grpc.StreamInterceptor(chainStreamInterceptors(budget.streamInterceptor())),
+	)
+	defer server.Stop()
+	client := startTestServer(t, server, &stubStreamer{})
+
+	// Blocks up to 127 take 2 bytes, those after 3: the stream is aborted at
+	// 128, having sent the others.
+	stream, err := client.GetBlockRange(context.Background(), &walletrpc.BlockRange{
+		Start: &walletrpc.BlockID{Height: 120},
+		End:   &walletrpc.BlockID{Height: 130},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	received := 0
+	for {
+		if _, err = stream.Recv(); err != nil {
+			break
+		}
+		received++
+	}
+	if status.Code(err) != codes.ResourceExhausted || received != 8 {
+		t.Errorf("expected ResourceExhausted after 8 blocks, got %v after %d", err, received)
+	}
+	if n := testutil.ToFloat64(aborted.WithLabelValues("GetBlockRange")); n != 1 {
+		t.Errorf("counted %v aborted calls, expected 1", n)
+	}
+
+	// Calls within the budget aren't affected.
+	if _, err := client.GetLatestBlock(context.Background(), &walletrpc.ChainSpec{}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLoadShedding(t *testing.T) {
+	shed := prometheus.NewCounter(prometheus.CounterOpts{Name: "test_shed", Help: "test counter"})
+	shedder := newLoadShedder(1, 10*time.Millisecond, 5*time.Second, shed)
+	server := grpc.NewServer(
+		grpc.UnaryInterceptor(chainUnaryInterceptors(shedder.unaryInterceptor())),
+		grpc.StreamInterceptor(chainStreamInterceptors(shedder.streamInterceptor())),
+	)
+	defer server.Stop()
+	service := &slowStreamer{
+		entered: make(chan bool),
+		release: make(chan bool),
+		counter: prometheus.NewCounter(prometheus.CounterOpts{Name: "test_calls", Help: "test counter"}),
+	}
+	client := startTestServer(t, server, service)
+	ctx := context.Background()
+
+	// Occupy the only slot.
+	done := make(chan error)
+	go func() {
+		_, err := client.GetLatestBlock(ctx, &walletrpc.ChainSpec{})
+		done <- err
+	}()
+	<-service.entered
+
+	var header metadata.MD
+	_, err := client.GetLatestBlock(ctx, &walletrpc.ChainSpec{}, grpc.Header(&header))
+	if status.Code(err) != codes.Unavailable {
+		t.Fatalf("expected Unavailable under overload, got %v", err)
+	}
+	var retryInfo *errdetails.RetryInfo
+	for _, detail := range status.Convert(err).Details() {
+		if info, ok := detail.(*errdetails.RetryInfo); ok {
+			retryInfo = info
+		}
+	}
+	if retryInfo == nil || retryInfo.RetryDelay.GetSeconds() != 5 {
+		t.Errorf("expected a 5s RetryInfo detail, got %v", retryInfo)
+	}
+	if retryAfter := header.Get(retryAfterHeader); len(retryAfter) != 1 || retryAfter[0] != "5" {
+		t.Errorf("expected a retry-after header of 5, got %v", retryAfter)
+	}
+
+	// Streams are shed too.
+	stream, err := client.GetBlockRange(ctx, &walletrpc.BlockRange{
+		Start: &walletrpc.BlockID{Height: 1},
+		End:   &walletrpc.BlockID{Height: 2},
+	})
+	if err == nil {
+		_, err = stream.Recv()
+	}
+	if status.Code(err) != codes.Unavailable {
+		t.Errorf("expected the stream to be shed, got %v", err)
+	}
+	if testutil.ToFloat64(shed) != 2 {
+		t.Errorf("expected 2 shed calls counted, got %v", testutil.ToFloat64(shed))
+	}
+
+	// Calls are served again once the slot frees up.
+	service.release <- true
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	go func() { <-service.entered; service.release <- true }()
+	if _, err := client.GetLatestBlock(ctx, &walletrpc.ChainSpec{}); err != nil {
+		t.Errorf("call after overload failed: %v", err)
+	}
+}
+
+// subscribedStreamer keeps its subscriptions, and GetBlockRange when asked
+// to follow the tip, open until the client goes away.
+type subscribedStreamer struct {
+	slowStreamer
+}
+
+func (s *subscribedStreamer) SubscribeBlocks(arg *walletrpc.SubscribeBlocksArg, stream walletrpc.CompactTxStreamer_SubscribeBlocksServer) error {
+	if err := stream.Send(&walletrpc.BlockUpdate{Tip: &walletrpc.BlockID{Height: 1}}); err != nil {
+		return err
+	}
+	<-stream.Context().Done()
+	return nil
+}
+
+func (s *subscribedStreamer) GetBlockRange(span *walletrpc.BlockRange, stream walletrpc.CompactTxStreamer_GetBlockRangeServer) error {
+	if err := stream.Send(&walletrpc.CompactBlock{Height: span.Start.Height}); err != nil {
+		return err
+	}
+	if span.Follow {
+		<-stream.Context().Done()
+	}
+	return nil
+}
+
+func TestLoadSheddingSubscriptions(t *testing.T) {
+	shed := prometheus.NewCounter(prometheus.CounterOpts{Name: "test_shed", Help: "test counter"})
+	shedder := newLoadShedder(1, 10*time.Millisecond, 5*time.Second, shed)
+	server := grpc.NewServer(
+		grpc.UnaryInterceptor(chainUnaryInterceptors(shedder.unaryInterceptor())),
+		grpc.StreamInterceptor(chainStreamInterceptors(shedder.streamInterceptor())),
+	)
+	defer server.Stop()
+	service := &subscribedStreamer{slowStreamer{
+		entered: make(chan bool),
+		release: make(chan bool),
+		counter: prometheus.NewCounter(prometheus.CounterOpts{Name: "test_calls", Help: "test counter"}),
+	}}
+	client := startTestServer(t, server, service)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// More open subscriptions than slots.
+	for i := 0; i < 3; i++ {
+		blocks, err := client.SubscribeBlocks(ctx, &walletrpc.SubscribeBlocksArg{})
+		if err == nil {
+			_, err = blocks.Recv()
+		}
+		if err != nil {
+			t.Fatalf("subscription %d: %v", i, err)
+		}
+		following, err := client.GetBlockRange(ctx, &walletrpc.BlockRange{Start: &walletrpc.BlockID{Height: 1}, Follow: true})
+		if err == nil {
+			_, err = following.Recv()
+		}
+		if err != nil {
+			t.Fatalf("following range %d: %v", i, err)
+		}
+	}
+
+	// They don't hold the slot, so calls are still served.
+	go func() { <-service.entered; service.release <- true }()
+	if _, err := client.GetLatestBlock(ctx, &walletrpc.ChainSpec{}); err != nil {
+		t.Errorf("call with subscriptions open: %v", err)
+	}
+	blocks, err := client.GetBlockRange(ctx, &walletrpc.BlockRange{Start: &walletrpc.BlockID{Height: 1}, End: &walletrpc.BlockID{Height: 1}})
+	if err == nil {
+		_, err = blocks.Recv()
+	}
+	if err != nil {
+		t.Errorf("range with subscriptions open: %v", err)
+	}
+	if testutil.ToFloat64(shed) != 0 {
+		t.Errorf("expected no shed calls, got %v", testutil.ToFloat64(shed))
+	}
+}
+
+func TestTrustedProxies(t *testing.T) {
+	proxies, err := parseTrustedProxies("10.0.0.0/8, 192.0.2.1, 2001:db8::/32")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, bad := range []string{"10.0.0.0/33", "proxy.example.com"} {
+		if _, err := parseTrustedProxies(bad); err == nil {
+			t.Errorf("expected %q to be rejected", bad)
+		}
+	}
+
+	for _, tt := range []struct {
+		peer   net.Addr
+		header []string
+		want   string
+	}{
+		{&net.TCPAddr{IP: net.ParseIP("10.1.2.3"), Port: 4000}, []string{"x-real-ip", "203.0.113.7"}, "203.0.113.7"},
+		{&net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 4000}, []string{"x-real-ip", "2001:db8:ffff::7"}, "2001:db8:ffff::7"},
+		{&net.UnixAddr{Name: "@", Net: "unix"}, []string{"x-real-ip", "203.0.113.7"}, "203.0.113.7"},
+		// The client is the last hop that isn't a trusted proxy.
+		{&net.TCPAddr{IP: net.ParseIP("10.1.2.3"), Port: 4000}, []string{"x-forwarded-for", "198.51.100.9, 203.0.113.7, 10.9.9.9"}, "203.0.113.7"},
+		// Untrusted peers can't pass for someone else.
+		{&net.TCPAddr{IP: net.ParseIP("198.51.100.1"), Port: 4000}, []string{"x-real-ip", "203.0.113.7"}, "198.51.100.1:4000"},
+		{&net.TCPAddr{IP: net.ParseIP("198.51.100.1"), Port: 4000}, []string{"x-forwarded-for", "203.0.113.7"}, "198.51.100.1:4000"},
+		// Nor can garbage from a trusted one.
+		{&net.TCPAddr{IP: net.ParseIP("10.1.2.3"), Port: 4000}, []string{"x-real-ip", "somewhere"}, "10.1.2.3:4000"},
+		{&net.TCPAddr{IP: net.ParseIP("10.1.2.3"), Port: 4000}, nil, "10.1.2.3:4000"},
+	} {
+		ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: tt.peer})
+		if tt.header != nil {
+			ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(tt.header...))
+		}
+		var got string
+		interceptor := clientAddrUnaryInterceptor(proxies)
+		interceptor(ctx, nil, &grpc.UnaryServerInfo{}, func(ctx context.Context, req interface{}) (interface{}, error) {
+			if peerInfo, ok := peer.FromContext(ctx); ok {
+				got = peerInfo.Addr.String()
+			}
+			return nil, nil
+		})
+		if got != tt.want {
+			t.Errorf("%v %v: got %s, expected %s", tt.peer, tt.header, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+)
+
+// listenNetwork returns the network to listen on addr with: IPv6 and IPv4
+// literals get their own family, so that 0.0.0.0:9067 and [::]:9067 can both
+// be bound instead of the IPv6 socket also taking the IPv4 port.
+func listenNetwork(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "tcp"
+	}
+	ip := net.ParseIP(host)
+	switch {
+	case ip == nil:
+		return "tcp"
+	case ip.To4() != nil:
+		return "tcp4"
+	default:
+		return "tcp6"
+	}
+}
+
+// listen opens a listener on each of addrs. An address that can't be bound,
+// an IPv6 one on a host without IPv6 for example, is logged and skipped; it's
+// an error only if none can be.
+func listen(addrs []string) ([]net.Listener, error) {
+	var listeners []net.Listener
+	for _, addr := range addrs {
+		listener, err := net.Listen(listenNetwork(addr), addr)
+		if err != nil {
+			log.WithFields(logrus.Fields{
+				"bind_addr": addr,
+				"error":     err,
+			}).Error("couldn't create listener, skipping it")
+			continue
+		}
+		listeners = append(listeners, listener)
+	}
+	if len(listeners) == 0 {
+		return nil, errors.New("couldn't listen on any address")
+	}
+	return listeners, nil
+}
+
+// listenUnix opens a listener on a Unix socket at path, readable and
+// writable as mode allows. A socket left at path by an earlier run is
+// replaced, any other file is not.
+func listenUnix(path string, mode os.FileMode) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and isn't a socket", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+// parseFileMode parses an octal file mode, such as 0660.
+func parseFileMode(s string) (os.FileMode, error) {
+	mode, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, fmt.Errorf("bad file mode %q, expected octal permissions such as 0660", s)
+	}
+	return os.FileMode(mode), nil
+}
+
+// serveAll serves server on each of listeners until it's stopped. A listener
+// that fails only stops serving on that one; an error is returned if they all
+// failed.
+func serveAll(server *grpc.Server, listeners []net.Listener) error {
+	errs := make(chan error, len(listeners))
+	for _, listener := range listeners {
+		go func(listener net.Listener) {
+			err := server.Serve(listener)
+			if err != nil {
+				log.WithFields(logrus.Fields{
+					"addr":  listener.Addr().String(),
+					"error": err,
+				}).Error("stopped serving on listener")
+			}
+			errs <- err
+		}(listener)
+	}
+
+	var lastErr error
+	failed := 0
+	for range listeners {
+		if err := <-errs; err != nil {
+			failed++
+			lastErr = err
+		}
+	}
+	if failed == len(listeners) {
+		return lastErr
+	}
+	return nil
+}
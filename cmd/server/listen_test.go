@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+
+	"github.com/adityapk00/lightwalletd/walletrpc"
+)
+
+func TestListenNetwork(t *testing.T) {
+	for addr, want := range map[string]string{
+		"0.0.0.0:9067":       "tcp4",
+		"127.0.0.1:9067":     "tcp4",
+		"[::]:9067":          "tcp6",
+		"[::1]:9067":         "tcp6",
+		"localhost:9067":     "tcp",
+		":9067":              "tcp",
+		"lwd.example.com:80": "tcp",
+	} {
+		if got := listenNetwork(addr); got != want {
+			t.Errorf("%s: got %s, expected %s", addr, got, want)
+		}
+	}
+}
+
+func TestListenSkipsBadAddresses(t *testing.T) {
+	listeners, err := listen([]string{"127.0.0.1:0", "256.0.0.1:0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listeners) != 1 {
+		t.Fatalf("expected one listener, got %d", len(listeners))
+	}
+	listeners[0].Close()
+
+	if _, err := listen([]string{"256.0.0.1:0", "nohost.invalid:0"}); err == nil {
+		t.Error("expected an error when no address can be listened on")
+	}
+}
+
+func TestServeAll(t *testing.T) {
+	server := grpc.NewServer()
+	walletrpc.RegisterCompactTxStreamerServer(server, &stubStreamer{})
+	listeners, err := listen([]string{"127.0.0.1:0", "127.0.0.1:0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	go func() {
+		served <- serveAll(server, listeners)
+	}()
+
+	// One listener failing leaves the other serving.
+	listeners[0].Close()
+	conn, err := grpc.Dial(listeners[1].Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := walletrpc.NewCompactTxStreamerClient(conn).GetLatestBlock(ctx, &walletrpc.ChainSpec{}); err != nil {
+		t.Fatal(err)
+	}
+
+	server.Stop()
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("expected no error once stopped, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("serveAll didn't return after the server stopped")
+	}
+}
+
+func TestListenUnix(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lightwalletd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certPath, keyPath := writeTestCert(t, dir, "localhost")
+	tlsConfig, err := newTLSConfig(certPath, keyPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A socket left by an earlier run is replaced.
+	path := filepath.Join(dir, "lightwalletd.sock")
+	stale, err := listenUnix(path, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+	unixListener, err := listenUnix(path, 0660)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(path); err != nil {
+		t.Fatal(err)
+	} else if info.Mode().Perm() != 0660 {
+		t.Errorf("socket mode is %v, expected 0660", info.Mode().Perm())
+	}
+	tcpListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := grpc.NewServer(grpc.Creds(unixPlaintextCreds{credentials.NewTLS(tlsConfig)}))
+	walletrpc.RegisterCompactTxStreamerServer(server, &stubStreamer{})
+	go serveAll(server, []net.Listener{tcpListener, unixListener})
+	defer server.Stop()
+
+	call := func(addr string, timeout time.Duration, opts ...grpc.DialOption) error {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		conn, err := grpc.DialContext(ctx, addr, append(opts, grpc.WithBlock())...)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		_, err = walletrpc.NewCompactTxStreamerClient(conn).GetLatestBlock(ctx, &walletrpc.ChainSpec{})
+		return err
+	}
+	unixDialer := grpc.WithDialer(func(addr string, timeout time.Duration) (net.Conn, error) {
+		return net.DialTimeout("unix", addr, timeout)
+	})
+	if err := call(path, 5*time.Second, grpc.WithInsecure(), unixDialer); err != nil {
+		t.Errorf("plaintext call on the Unix socket failed: %v", err)
+	}
+	if err := call(tcpListener.Addr().String(), 200*time.Millisecond, grpc.WithInsecure()); err == nil {
+		t.Error("plaintext call on the TCP port succeeded")
+	}
+	clientTLS := credentials.NewTLS(&tls.Config{InsecureSkipVerify: true})
+	if err := call(tcpListener.Addr().String(), 5*time.Second, grpc.WithTransportCredentials(clientTLS)); err != nil {
+		t.Errorf("TLS call on the TCP port failed: %v", err)
+	}
+
+	// Other files aren't replaced.
+	if _, err := listenUnix(certPath, 0660); err == nil {
+		t.Error("expected listenUnix to refuse to replace a regular file")
+	}
+}
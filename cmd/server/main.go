@@ -2,12 +2,17 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"flag"
 	"fmt"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
@@ -16,7 +21,9 @@ import (
 	"github.com/sirupsen/logrus"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
-	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/keepalive"
 	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/reflection"
 
@@ -44,34 +51,90 @@ func init() {
 	log = logger.WithFields(logrus.Fields{
 		"app": "frontend-grpc",
 	})
+}
+
+// instanceLabel is the log field and metric label carrying -instance-label.
+const instanceLabel = "instance_label"
+
+// withInstanceLabel adds label, constant for the life of the process, to the
+// entries logged through entry and to the metrics registered through the
+// returned registerer. An empty label changes nothing.
+func withInstanceLabel(label string, entry *logrus.Entry, registry prometheus.Registerer) (*logrus.Entry, prometheus.Registerer) {
+	if label == "" {
+		return entry, registry
+	}
+	return entry.WithField(instanceLabel, label),
+		prometheus.WrapRegistererWith(prometheus.Labels{instanceLabel: label}, registry)
+}
 
-	promRegistry.MustRegister(metrics.LatestBlockCounter)
-	promRegistry.MustRegister(metrics.TotalErrors)
-	promRegistry.MustRegister(metrics.TotalBlocksServedConter)
-	promRegistry.MustRegister(metrics.SendTransactionsCounter)
-	promRegistry.MustRegister(metrics.TotalSaplingParamsCounter)
-	promRegistry.MustRegister(metrics.TotalSproutParamsCounter)
+func registerMetrics(registry prometheus.Registerer) {
+	registry.MustRegister(metrics.LatestBlockCounter)
+	registry.MustRegister(metrics.TotalErrors)
+	registry.MustRegister(metrics.TotalBlocksServedConter)
+	registry.MustRegister(metrics.SendTransactionsCounter)
+	registry.MustRegister(metrics.SendTransactionRetrySuccesses)
+	registry.MustRegister(metrics.SendTransactionDedupHits)
+	registry.MustRegister(metrics.TransactionCacheHits)
+	registry.MustRegister(metrics.TotalSaplingParamsCounter)
+	registry.MustRegister(metrics.TotalSproutParamsCounter)
+	registry.MustRegister(metrics.ParamsThrottled)
+	registry.MustRegister(metrics.BlockRangeStreams)
+	registry.MustRegister(metrics.BlockRangeStreamsLimit)
+	registry.MustRegister(metrics.BlockCacheHits)
+	registry.MustRegister(metrics.BlockFetchesCoalesced)
+	registry.MustRegister(metrics.ShedRequests)
+	registry.MustRegister(metrics.QuotaRejections)
+	registry.MustRegister(metrics.SLOBurnRate)
+	registry.MustRegister(metrics.RequestMemoryAborts)
+	registry.MustRegister(metrics.MemoryLimit)
+	registry.MustRegister(metrics.CacheMaxEntries)
+	registry.MustRegister(metrics.CacheCompactions)
+	registry.MustRegister(metrics.BlockValidations)
+	registry.MustRegister(metrics.BlockValidationMismatches)
+	registry.MustRegister(metrics.OCSPStapleValidUntil)
+	registry.MustRegister(metrics.DiskProbeLatency)
+	registry.MustRegister(metrics.DiskProbeSlow)
+	registry.MustRegister(metrics.RPCBackendRequests)
+	registry.MustRegister(metrics.RPCBackendErrors)
+	registry.MustRegister(metrics.RPCBackendUp)
+	registry.MustRegister(metrics.SaplingActivationHeight)
+	registry.MustRegister(metrics.OrchardActivationHeight)
+	registry.MustRegister(metrics.ConsensusBranchID)
 }
 
-// TODO stream logging
+// logInterceptor logs each call, at the level configured for its method in
+// methods, Info if there's none. Failed calls are always logged, as errors.
+func logInterceptor(methods map[string]*methodLogging) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		reqLog := loggerFromContext(ctx)
+		start := time.Now()
 
-func LoggingInterceptor() grpc.ServerOption {
-	return grpc.UnaryInterceptor(logInterceptor)
+		resp, err := handler(ctx, req)
+
+		logCall(reqLog, methods[methodName(info.FullMethod)], info.FullMethod, start, err)
+		return resp, err
+	}
 }
 
-func logInterceptor(
-	ctx context.Context,
-	req interface{},
-	info *grpc.UnaryServerInfo,
-	handler grpc.UnaryHandler,
-) (interface{}, error) {
-	reqLog := loggerFromContext(ctx)
-	start := time.Now()
+func streamLogInterceptor(methods map[string]*methodLogging) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		reqLog := loggerFromContext(ss.Context())
+		start := time.Now()
+
+		err := handler(srv, ss)
 
-	resp, err := handler(ctx, req)
+		logCall(reqLog, methods[methodName(info.FullMethod)], info.FullMethod, start, err)
+		return err
+	}
+}
+
+func logCall(reqLog *logrus.Entry, logging *methodLogging, method string, start time.Time, err error) {
+	if err == nil && !logging.sample() {
+		return
+	}
 
 	entry := reqLog.WithFields(logrus.Fields{
-		"method":   info.FullMethod,
+		"method":   method,
 		"duration": time.Since(start),
 		"error":    err,
 	})
@@ -79,64 +142,418 @@ func logInterceptor(
 	if err != nil {
 		entry.Error("call failed")
 	} else {
-		entry.Info("method called")
+		entry.Log(logging.getLevel(), "method called")
 	}
-
-	return resp, err
 }
 
 func loggerFromContext(ctx context.Context) *logrus.Entry {
-	if xRealIP, ok := metadata.FromIncomingContext(ctx); ok {
-		realIP := xRealIP.Get("x-real-ip")
-		if len(realIP) > 0 {
-			return log.WithFields(logrus.Fields{"peer_addr": realIP[0]})
-		}
+	reqLog := log
+	if id := requestIDFromContext(ctx); id != "" {
+		reqLog = reqLog.WithField("request_id", id)
+	}
+	if fields := clientCertFields(ctx); len(fields) > 0 {
+		reqLog = reqLog.WithFields(fields)
 	}
 
 	if peerInfo, ok := peer.FromContext(ctx); ok {
-		return log.WithFields(logrus.Fields{"peer_addr": peerInfo.Addr})
+		return reqLog.WithFields(logrus.Fields{"peer_addr": peerInfo.Addr})
 	}
 
-	return log.WithFields(logrus.Fields{"peer_addr": "unknown"})
+	return reqLog.WithFields(logrus.Fields{"peer_addr": "unknown"})
 }
 
 type Options struct {
-	bindAddr      string
-	tlsCertPath   string
-	tlsKeyPath    string
-	noTLS         bool
-	logLevel      uint64
-	logPath       string
-	zcashConfPath string
-	cacheSize     int
-	metricsPort   uint
-	paramsPort    uint
+	configPath         string
+	bindAddr           string
+	bindUnix           string
+	bindUnixMode       string
+	tlsCertPath        string
+	tlsKeyPath         string
+	noTLS              bool
+	tlsAllowedSNI      string
+	acmeDomain         string
+	acmeCacheDir       string
+	acmeEmail          string
+	acmeHTTPAddr       string
+	tlsOCSPStapling    bool
+	tlsOCSPRefresh     time.Duration
+	tlsReloadInterval  time.Duration
+	tlsClientCA        string
+	tlsMinVersion      string
+	tlsCipherSuites    string
+	tlsCurves          string
+	tlsSelfSigned      bool
+	proxyProtocol      bool
+	trustedProxies     string
+	tlsSelfSignedDir   string
+	logLevel           uint64
+	logPath            string
+	requestIDTrailer   bool
+	zcashConfPath      string
+	broadcastAll       bool
+	saplingHeight      int
+	donationAddress    string
+	donationTaddress   string
+	operatorName       string
+	operatorContact    string
+	privacyPolicyURL   string
+	noticesFile        string
+	noticesInterval    time.Duration
+	minClientVersion   string
+	clientUpgradeURL   string
+	requireClientVer   bool
+	cacheSize          int
+	clampWarmWindow    bool
+	memoryLimitMB      uint64
+	cacheMinSize       int
+	ingestInterval     time.Duration
+	ingestPrefetch     int
+	cacheStore         string
+	cacheFile          string
+	cacheWindow        int
+	cacheEviction      string
+	cacheTierDir       string
+	cacheHashIndex     bool
+	cacheCompactAfter  int
+	validateSample     float64
+	coalesceBlocks     int
+	coalesceLinger     time.Duration
+	checkpointInterval int
+	checkpointFile     string
+	addressIndexDB     string
+	treeStateDB        string
+	treeStateMax       int
+	sendRequireSynced  bool
+	sendMinProgress    float64
+	sendCheckBranch    bool
+	sendDedupWindow    time.Duration
+	sendDedupMax       int
+	minInputConfs      int
+	sendRetryCodes     string
+	sendRetryBackoff   time.Duration
+	maxRangeStreams    int
+	maxFullBlocks      int
+	maxTxSize          int
+	txCacheSize        int
+	txCacheTTL         time.Duration
+	txBatch            int
+	txStatusWindow     time.Duration
+	txStatusMax        int
+	taddrTxidsPage     int
+	maxRecvMsgSize     int
+	maxSendMsgSize     int
+	maxStreams         uint
+	rangeCheckpoints   int
+	followBlockRange   bool
+	emptyHeartbeat     int
+	subscribeKeepalive time.Duration
+	lightdInfoCached   bool
+	nodeStatusInterval time.Duration
+	healthMaxLag       int
+	healthInterval     time.Duration
+	lightdInfoStale    bool
+	statusFile         string
+	statusPort         int
+	statusBindAddr     string
+	statusInterval     time.Duration
+	diskProbeInterval  time.Duration
+	diskProbeDir       string
+	diskProbeThreshold time.Duration
+	diskProbeReadyz    bool
+	deprecated         methodFlag
+	minLatency         methodFlag
+	lookupStrategy     methodFlag
+	logMethod          methodFlag
+	peerQuota          methodFlag
+	peerQuotaMaxPeers  int
+	slo                methodFlag
+	sloWindow          time.Duration
+	maxConcurrent      int
+	requestMemory      int
+	shedQueueWait      time.Duration
+	shedRetryAfter     time.Duration
+	maxConnAge         time.Duration
+	maxConnAgeGrace    time.Duration
+	maxConnIdle        time.Duration
+	keepaliveTime      time.Duration
+	keepaliveTimeout   time.Duration
+	keepaliveMinTime   time.Duration
+	keepaliveNoStream  bool
+	metricsPort        uint
+	sharedPort         bool
+	metricsGrace       time.Duration
+	upgradeTimeout     time.Duration
+	selfTest           bool
+	instanceLabel      string
+	paramsPort         uint
+	paramsRate         float64
+	paramsBurst        int
+}
+
+// keepaliveOptions sets how the server keeps connections alive and when it
+// closes them, zero fields keeping gRPC's defaults. Time and Timeout have it
+// ping idle clients, both to find dead connections and to keep NAT mappings
+// open. MaxConnectionAge makes it send clients a GOAWAY once their
+// connection is that old, so that a load balancer gets to place them again;
+// calls already in flight, including long streams such as GetBlockRange,
+// keep going on the old connection for up to MaxConnectionAgeGrace (forever
+// if zero), while new calls go to the new one. policy bounds how often
+// clients may ping the server.
+func keepaliveOptions(params keepalive.ServerParameters, policy keepalive.EnforcementPolicy) []grpc.ServerOption {
+	var opts []grpc.ServerOption
+	if params != (keepalive.ServerParameters{}) {
+		opts = append(opts, grpc.KeepaliveParams(params))
+	}
+	if policy != (keepalive.EnforcementPolicy{}) {
+		opts = append(opts, grpc.KeepaliveEnforcementPolicy(policy))
+	}
+	return opts
+}
+
+// messageLimitOptions caps the size of the messages the server receives and
+// sends, in bytes, and the number of calls a client may have in flight on
+// one connection, if maxStreams isn't zero. Larger messages fail the call
+// with ResourceExhausted; further calls wait for one to finish.
+func messageLimitOptions(maxRecv, maxSend int, maxStreams uint) []grpc.ServerOption {
+	opts := []grpc.ServerOption{
+		grpc.MaxRecvMsgSize(maxRecv),
+		grpc.MaxSendMsgSize(maxSend),
+	}
+	if maxStreams > 0 {
+		opts = append(opts, grpc.MaxConcurrentStreams(uint32(maxStreams)))
+	}
+	return opts
+}
+
+// defineFlags defines the command line flags on fs, and returns the Options
+// they set.
+func defineFlags(fs *flag.FlagSet) *Options {
+	opts := &Options{
+		deprecated:     methodFlag{},
+		minLatency:     methodFlag{},
+		lookupStrategy: methodFlag{},
+		logMethod:      methodFlag{},
+		peerQuota:      methodFlag{},
+		slo:            methodFlag{},
+	}
+	fs.BoolVar(&opts.selfTest, "self-test", false, "check the TLS certificate, the zcashd credentials and connection, and that the cache directories are writable, then exit, non-zero if a check failed")
+	fs.StringVar(&opts.configPath, "config", "", "a YAML file to read settings from, overridden by the environment and the command line (optional)")
+	fs.StringVar(&opts.bindAddr, "bind-addr", "127.0.0.1:9067", "the address to listen on, or a comma-separated list of them, such as 0.0.0.0:9067,[::]:9067")
+	fs.StringVar(&opts.bindUnix, "bind-unix", "", "a Unix socket to also listen on, served without TLS, for a proxy on the same host (optional; set -bind-addr to \"\" to only listen there)")
+	fs.BoolVar(&opts.proxyProtocol, "proxy-protocol", false, "expect a PROXY protocol header, version 1 or 2, on each TCP connection, from a load balancer in front of the server, and take the client address from it")
+	fs.StringVar(&opts.trustedProxies, "trusted-proxies", "127.0.0.0/8,::1", "comma-separated networks and addresses of the proxies whose x-real-ip and x-forwarded-for headers give the client's address; proxies on the -bind-unix socket are always trusted")
+	fs.StringVar(&opts.bindUnixMode, "bind-unix-mode", "0660", "the permissions of the -bind-unix socket")
+	fs.StringVar(&opts.tlsCertPath, "tls-cert", "", "the path to a TLS certificate (optional)")
+	fs.StringVar(&opts.tlsKeyPath, "tls-key", "", "the path to a TLS key file (optional)")
+	fs.BoolVar(&opts.noTLS, "no-tls", false, "Disable TLS, serve un-encrypted traffic.")
+	fs.StringVar(&opts.tlsAllowedSNI, "tls-allowed-sni", "", "comma-separated hostnames clients must ask for in the TLS handshake (default: any)")
+	fs.BoolVar(&opts.tlsSelfSigned, "tls-self-signed", false, "serve a self-signed certificate for the -bind-addr hosts, generated on the first run, instead of -tls-cert and -tls-key")
+	fs.StringVar(&opts.tlsSelfSignedDir, "tls-self-signed-dir", "tls", "the directory to keep the -tls-self-signed certificate and key in")
+	fs.StringVar(&opts.acmeDomain, "acme-domain", "", "comma-separated domains to get TLS certificates for from Let's Encrypt, instead of -tls-cert and -tls-key")
+	fs.StringVar(&opts.acmeCacheDir, "acme-cache-dir", "acme-cache", "the directory to keep the -acme-domain certificates and account key in")
+	fs.StringVar(&opts.acmeEmail, "acme-email", "", "the address Let's Encrypt sends certificate expiry notices to (optional)")
+	fs.StringVar(&opts.acmeHTTPAddr, "acme-http-addr", ":80", "the address to answer Let's Encrypt's HTTP challenges on, if the gRPC server isn't reachable on port 443 (\"\" to not listen)")
+	fs.BoolVar(&opts.tlsOCSPStapling, "tls-ocsp-stapling", false, "staple OCSP responses from the certificate's responder to the TLS handshakes; the certificate file must include the issuer's certificate")
+	fs.DurationVar(&opts.tlsOCSPRefresh, "tls-ocsp-refresh", time.Hour, "how often to fetch a new OCSP response for -tls-ocsp-stapling")
+	fs.DurationVar(&opts.tlsReloadInterval, "tls-reload-interval", time.Minute, "how often to check -tls-cert and -tls-key for a renewed certificate to serve to new connections (0 to never reload)")
+	fs.StringVar(&opts.tlsClientCA, "tls-client-ca", "", "require clients to present a certificate issued by one of the CAs in this PEM file, and log who they are (optional)")
+	fs.StringVar(&opts.tlsMinVersion, "tls-min-version", "1.2", "the oldest TLS version clients may use: 1.0, 1.1, 1.2 or 1.3")
+	fs.StringVar(&opts.tlsCipherSuites, "tls-cipher-suites", "", "comma-separated cipher suites offered to TLS 1.2 and older clients, by their Go names, such as TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256 (default: Go's)")
+	fs.StringVar(&opts.tlsCurves, "tls-curves", "", "comma-separated elliptic curves for the key exchange, in order of preference: X25519, P256, P384, P521 (default: Go's)")
+	fs.Uint64Var(&opts.logLevel, "log-level", uint64(logrus.InfoLevel), "log level (logrus 1-7)")
+	fs.StringVar(&opts.logPath, "log-file", "", "log file to write to")
+	fs.BoolVar(&opts.requestIDTrailer, "request-id-trailer", true, "return the request_id of each call's log entries in the x-request-id trailer")
+	fs.StringVar(&opts.zcashConfPath, "conf-file", "", "conf file to pull RPC creds from (comma-separated for multiple backends, the first is the primary)")
+	fs.BoolVar(&opts.broadcastAll, "rpc-broadcast-all", false, "send transactions to all RPC backends instead of only the primary")
+	fs.IntVar(&opts.saplingHeight, "sapling-activation-height", 0, "Sapling activation height to use on regtest, or if the node doesn't report one")
+	fs.StringVar(&opts.donationAddress, "donation-address", "", "shielded address to take donations at, reported to wallets by GetLightdInfo")
+	fs.StringVar(&opts.donationTaddress, "donation-taddress", "", "transparent address to take donations at, reported to wallets by GetLightdInfo")
+	fs.StringVar(&opts.operatorName, "operator-name", "", "who runs this server, reported to wallets by GetLightdInfo")
+	fs.StringVar(&opts.operatorContact, "operator-contact", "", "how to reach the operator, such as an email address or URL, reported to wallets by GetLightdInfo")
+	fs.StringVar(&opts.privacyPolicyURL, "privacy-policy-url", "", "URL of the server's privacy policy, reported to wallets by GetLightdInfo")
+	fs.StringVar(&opts.noticesFile, "notices-file", "", "JSON file of notices for wallets, such as maintenance windows, served by GetServerNotices and GetLightdInfo")
+	fs.DurationVar(&opts.noticesInterval, "notices-reload-interval", time.Minute, "how often to check -notices-file for changes (0 to never reload)")
+	fs.StringVar(&opts.minClientVersion, "min-client-version", "", "turn away wallets reporting an older version than this in the client-version header")
+	fs.StringVar(&opts.clientUpgradeURL, "client-upgrade-url", "", "where wallets turned away by -min-client-version are told to upgrade from")
+	fs.BoolVar(&opts.requireClientVer, "require-client-version", false, "with -min-client-version, also turn away wallets that don't report their version")
+	fs.IntVar(&opts.cacheSize, "cache-size", 40000, "number of blocks to hold in the cache")
+	fs.BoolVar(&opts.clampWarmWindow, "clamp-warm-window", true, "if -cache-size can't hold the blocks below the tip the cache is warmed with, warm it with fewer; otherwise refuse to start")
+	fs.Uint64Var(&opts.memoryLimitMB, "memory-limit-mb", 0, "soft memory limit in MiB; the cache shrinks as the heap gets close to it (0 for none)")
+	fs.IntVar(&opts.cacheMinSize, "cache-min-size", 1000, "smallest number of blocks the cache is shrunk to under memory pressure")
+	fs.DurationVar(&opts.ingestInterval, "ingest-poll-interval", 5*time.Second, "how often to ask zcashd for new blocks")
+	fs.IntVar(&opts.ingestPrefetch, "ingest-prefetch", 1, "number of blocks past the tip to request from zcashd at once")
+	fs.StringVar(&opts.cacheStore, "cache-store", "memory", "where to keep cached blocks: \"memory\" or \"mmap\" (a memory-mapped file)")
+	fs.StringVar(&opts.cacheFile, "cache-file", "lightwalletd-cache.dat", "the file backing the cache when -cache-store=mmap")
+	fs.IntVar(&opts.cacheWindow, "cache-window", 0, "keep this many of the latest blocks in memory in front of -cache-store=mmap (0 disables)")
+	fs.StringVar(&opts.cacheEviction, "cache-eviction", "oldest", "which blocks a full cache drops first: \"oldest\", \"recent\" (the least recently used) or \"activity\" (those with the fewest shielded spends and outputs)")
+	fs.StringVar(&opts.cacheTierDir, "cache-tier-dir", "", "keep the blocks evicted from the cache in this directory, and serve them from there instead of asking zcashd again (optional)")
+	fs.BoolVar(&opts.cacheHashIndex, "cache-hash-index", true, "index cached blocks by hash as well as height")
+	fs.Float64Var(&opts.validateSample, "validate-sample-rate", 0, "fraction of ingested blocks to compare with their full block once cached, from 0 (none) to 1 (all)")
+	fs.IntVar(&opts.cacheCompactAfter, "cache-compact-after", 0, "compact the cache in the background once reorgs have dropped this many blocks (0 disables)")
+	fs.IntVar(&opts.coalesceBlocks, "coalesce-max-blocks", 1000, "share getblock calls for uncached blocks between concurrent requests, holding at most this many blocks (0 disables)")
+	fs.DurationVar(&opts.coalesceLinger, "coalesce-linger", 2*time.Second, "how long a shared uncached block is kept for requests that are slightly behind")
+	fs.IntVar(&opts.checkpointInterval, "checkpoint-interval", 1000, "record a checkpoint every this many blocks for GetCheckpointIndex (0 disables)")
+	fs.StringVar(&opts.checkpointFile, "checkpoint-file", "", "file to keep checkpoints in across restarts (optional)")
+	fs.StringVar(&opts.treeStateDB, "tree-state-db", "", "SQLite database to keep GetTreeState answers in across restarts, in builds with cgo (by default they're kept in memory)")
+	fs.IntVar(&opts.treeStateMax, "tree-state-max", 10000, "number of tree states GetTreeState keeps, answering them again without zcashd (0 disables)")
+	fs.StringVar(&opts.addressIndexDB, "address-index-db", "", "index transparent addresses in this SQLite database, in builds with cgo, and answer the t-address RPCs from it, for zcashd without -insightexplorer (optional)")
+	fs.BoolVar(&opts.sendRequireSynced, "send-require-synced", true, "refuse to broadcast transactions while zcashd is not synced")
+	fs.Float64Var(&opts.sendMinProgress, "send-min-verification-progress", 0.9999, "verification progress below which zcashd is considered not synced")
+	fs.BoolVar(&opts.sendCheckBranch, "send-check-branch", false, "refuse transactions whose version doesn't match zcashd's current consensus branch")
+	fs.DurationVar(&opts.sendDedupWindow, "send-dedup-window", 0, "answer resubmissions of a transaction broadcast within this long from memory (0 disables)")
+	fs.IntVar(&opts.sendDedupMax, "send-dedup-max", 10000, "number of broadcast transactions remembered for -send-dedup-window")
+	fs.IntVar(&opts.minInputConfs, "send-min-input-confirmations", 0, "reject transactions spending transparent outputs with fewer confirmations (0 disables, needs txindex)")
+	fs.StringVar(&opts.sendRetryCodes, "send-retry-codes", "", "comma-separated sendrawtransaction error codes to retry once, e.g. -28 (optional)")
+	fs.DurationVar(&opts.sendRetryBackoff, "send-retry-backoff", 500*time.Millisecond, "how long to wait before retrying sendrawtransaction")
+	fs.IntVar(&opts.maxRangeStreams, "max-block-range-streams", 0, "maximum number of concurrent GetBlockRange streams (0 for no limit)")
+	fs.IntVar(&opts.maxRecvMsgSize, "max-recv-msg-size", 4<<20, "largest message in bytes the server accepts, enough for the largest transaction SendTransaction may carry")
+	fs.IntVar(&opts.maxSendMsgSize, "max-send-msg-size", 16<<20, "largest message in bytes the server sends; must be larger than -max-transaction-size")
+	fs.UintVar(&opts.maxStreams, "max-concurrent-streams", 100, "most calls, including streams, a client may have in flight on one connection (0 for no limit)")
+	fs.IntVar(&opts.maxTxSize, "max-transaction-size", 4<<20, "largest transaction in bytes GetTransaction returns, by default gRPC's default message size limit (0 for no limit)")
+	fs.IntVar(&opts.txCacheSize, "tx-cache-size", 1000, "number of mined transactions GetTransaction remembers, answering lookups of them again without zcashd (0 disables)")
+	fs.DurationVar(&opts.txCacheTTL, "tx-cache-ttl", 10*time.Minute, "how long GetTransaction remembers a transaction for -tx-cache-size, bounding how long a reorg goes unnoticed")
+	fs.DurationVar(&opts.txStatusWindow, "tx-status-window", 24*time.Hour, "how long GetTxStatus remembers the inputs of broadcast transactions, to report conflicts (0 disables)")
+	fs.IntVar(&opts.txStatusMax, "tx-status-max", 10000, "number of broadcast transactions remembered for -tx-status-window")
+	fs.IntVar(&opts.txBatch, "transactions-batch", 50, "most transactions GetTransactions asks zcashd for in one batch of calls")
+	fs.IntVar(&opts.taddrTxidsPage, "taddress-txids-page", 10000, "blocks GetTaddressTxids asks zcashd about in one getaddresstxids call (0 for the whole range)")
+	fs.IntVar(&opts.maxFullBlocks, "max-full-block-requests", 0, "allow GetBlock to return full blocks, with at most this many requests at once (0 disables)")
+	fs.IntVar(&opts.rangeCheckpoints, "range-checkpoint-min-interval", 100, "smallest checkpoint interval clients may ask for in GetBlockRange (0 disables)")
+	fs.BoolVar(&opts.followBlockRange, "follow-block-range", false, "let GetBlockRange clients follow the tip, receiving new blocks as they're ingested")
+	fs.IntVar(&opts.emptyHeartbeat, "empty-block-heartbeat", 100, "most empty blocks in a row GetBlockRange leaves out for clients that skip them, before sending one to show progress (0 for no limit)")
+	fs.DurationVar(&opts.subscribeKeepalive, "subscribe-keepalive", 30*time.Second, "ping SubscribeBlocks and SubscribeReorgs clients after this long without an update, so that proxies don't close idle streams (0 to never ping)")
+	fs.BoolVar(&opts.lightdInfoCached, "lightd-info-cached", false, "answer GetLightdInfo from the node's status as last refreshed, without waiting on the node")
+	fs.DurationVar(&opts.nodeStatusInterval, "node-status-interval", 5*time.Second, "how often to refresh the node's status, for the activation height and branch ID metrics and -lightd-info-cached")
+	fs.IntVar(&opts.healthMaxLag, "health-max-lag", 10, "most blocks the cache may be behind zcashd for the gRPC health service to report SERVING")
+	fs.DurationVar(&opts.healthInterval, "health-interval", 10*time.Second, "how often the gRPC health service checks zcashd and the cache")
+	fs.BoolVar(&opts.lightdInfoStale, "lightd-info-stale-node-fields", false, "with -lightd-info-cached, keep reporting the node's last known subversion and mempool size while it's unreachable")
+	fs.IntVar(&opts.statusPort, "status-port", 0, "answer each connection on this TCP port with the cached tip and sync state, then close it (0 disables)")
+	fs.StringVar(&opts.statusBindAddr, "status-bind-addr", "127.0.0.1", "the address to listen on for -status-port")
+	fs.StringVar(&opts.statusFile, "status-file", "", "periodically write the sync status as JSON to this file (optional)")
+	fs.DurationVar(&opts.statusInterval, "status-interval", 10*time.Second, "how often to update -status-file")
+	fs.DurationVar(&opts.diskProbeInterval, "disk-probe-interval", 0, "how often to time a write and read in -disk-probe-dir (0 disables)")
+	fs.StringVar(&opts.diskProbeDir, "disk-probe-dir", "", "directory the disk probe writes to; defaults to that of -cache-file with -cache-store=mmap, and is required otherwise")
+	fs.DurationVar(&opts.diskProbeThreshold, "disk-probe-threshold", 500*time.Millisecond, "disk probe latency above which the disk is considered slow")
+	fs.BoolVar(&opts.diskProbeReadyz, "disk-probe-readyz", false, "serve /readyz on the metrics port, failing while the disk is slow")
+	fs.Var(opts.deprecated, "deprecate-method", "mark a method as deprecated, as Method=notice (can be repeated)")
+	fs.Var(opts.minLatency, "min-latency", "don't answer a method faster than this, as Method=duration, to hide cache hits from timing (can be repeated)")
+	fs.Var(opts.peerQuota, "peer-quota", "limit the calls each peer makes to a method, as Method=calls/window, 1000/24h for example (can be repeated)")
+	fs.IntVar(&opts.peerQuotaMaxPeers, "peer-quota-max-peers", 100000, "most peer and method pairs -peer-quota keeps count for")
+	fs.Var(opts.slo, "slo", "export the error budget burn rate of a method, as Method=success%, or Method=success%/latency to count slower calls as failed, 99.9/500ms for example (can be repeated)")
+	fs.DurationVar(&opts.sloWindow, "slo-window", time.Hour, "the window -slo burn rates are computed over")
+	fs.Var(opts.logMethod, "log-method", "the level to log a method's successful calls at, as Method=level, or Method=level/N to log only one call in N (can be repeated)")
+	fs.Var(opts.lookupStrategy, "lookup-strategy", "where GetLatestBlock, GetBlock, GetBlockRange, GetBlockHeaders or GetBlockRangeNullifiers look for blocks, as Method=cache-first, cache-only or node-only (can be repeated)")
+	fs.IntVar(&opts.maxConcurrent, "max-concurrent-requests", 0, "calls served at once before new ones are told to retry later (0 for no limit); SubscribeBlocks, SubscribeReorgs and GetBlockRange following the tip don't count")
+	fs.IntVar(&opts.requestMemory, "request-memory-budget", 0, "bytes a single call may buffer before it is aborted with ResourceExhausted (0 for no limit)")
+	fs.DurationVar(&opts.shedQueueWait, "shed-queue-wait", 0, "how long a call waits for a free slot before it is turned away")
+	fs.DurationVar(&opts.shedRetryAfter, "shed-retry-after", 5*time.Second, "how long turned away clients are asked to wait before retrying")
+	fs.DurationVar(&opts.maxConnAge, "max-connection-age", 0, "ask clients to reconnect after this long, to rebalance them across backends (0 for never)")
+	fs.DurationVar(&opts.maxConnAgeGrace, "max-connection-age-grace", 0, "how long calls in flight may run once a connection reached its max age (0 for as long as they need)")
+	fs.DurationVar(&opts.maxConnIdle, "max-connection-idle", 0, "close connections that had no calls for this long (0 for never)")
+	fs.DurationVar(&opts.keepaliveTime, "keepalive-time", 0, "ping clients after this long without activity, to find dead connections and keep NAT mappings open (0 for gRPC's default, 2h)")
+	fs.DurationVar(&opts.keepaliveTimeout, "keepalive-timeout", 0, "close the connection if a ping isn't answered within this long (0 for gRPC's default, 20s)")
+	fs.DurationVar(&opts.keepaliveMinTime, "keepalive-min-time", 0, "the shortest interval clients may ping at; the connections of clients pinging more often are closed (0 for gRPC's default, 5m)")
+	fs.BoolVar(&opts.keepaliveNoStream, "keepalive-permit-without-stream", false, "let clients ping while they have no calls in flight")
+	fs.UintVar(&opts.paramsPort, "params-port", 8090, "the port on which the params server listens")
+	fs.Float64Var(&opts.paramsRate, "params-rate", 0, "params requests per second allowed from each client IP (0 for no limit)")
+	fs.IntVar(&opts.paramsBurst, "params-burst", 10, "params requests a client IP may make at once before -params-rate applies")
+	fs.UintVar(&opts.metricsPort, "metrics-port", 2234, "the port on which to run the prometheus metrics exported")
+	fs.BoolVar(&opts.sharedPort, "shared-port", false, "serve the metrics and params downloads on the gRPC listeners too, instead of on -metrics-port and -params-port")
+	fs.StringVar(&opts.instanceLabel, "instance-label", "", "a name for this instance, added to every log entry and metric (optional)")
+	fs.DurationVar(&opts.metricsGrace, "metrics-shutdown-grace", 5*time.Second, "how long to keep serving metrics after the gRPC server has drained on shutdown")
+	fs.DurationVar(&opts.upgradeTimeout, "upgrade-timeout", 10*time.Minute, "how long the new process started by SIGUSR2 has to get ready before it's stopped and this one carries on")
+
+	return opts
 }
 
 func main() {
-	opts := &Options{}
-	flag.StringVar(&opts.bindAddr, "bind-addr", "127.0.0.1:9067", "the address to listen on")
-	flag.StringVar(&opts.tlsCertPath, "tls-cert", "", "the path to a TLS certificate (optional)")
-	flag.StringVar(&opts.tlsKeyPath, "tls-key", "", "the path to a TLS key file (optional)")
-	flag.BoolVar(&opts.noTLS, "no-tls", false, "Disable TLS, serve un-encrypted traffic.")
-	flag.Uint64Var(&opts.logLevel, "log-level", uint64(logrus.InfoLevel), "log level (logrus 1-7)")
-	flag.StringVar(&opts.logPath, "log-file", "", "log file to write to")
-	flag.StringVar(&opts.zcashConfPath, "conf-file", "", "conf file to pull RPC creds from")
-	flag.IntVar(&opts.cacheSize, "cache-size", 40000, "number of blocks to hold in the cache")
-	flag.UintVar(&opts.paramsPort, "params-port", 8090, "the port on which the params server listens")
-	flag.UintVar(&opts.metricsPort, "metrics-port", 2234, "the port on which to run the prometheus metrics exported")
+	command, args := "serve", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+	switch command {
+	case "serve":
+		serve(args)
+	case "version":
+		printVersion(os.Stdout)
+	case "check-config":
+		if err := checkConfig(args, os.Stdout, newRPCFromConf); err != nil {
+			fmt.Fprintln(os.Stderr, "check-config:", err)
+			os.Exit(1)
+		}
+	case "gen-tls":
+		if err := genTLSCommand(args); err != nil {
+			fmt.Fprintln(os.Stderr, "gen-tls:", err)
+			os.Exit(1)
+		}
+	default:
+		fmt.Fprintf(os.Stderr, usage, os.Args[0])
+		os.Exit(2)
+	}
+}
+
+// newRPCFromConf makes a zcashd RPC client with the settings in the
+// zcash.conf file at confPath.
+func newRPCFromConf(confPath string) (common.RPCClient, error) {
+	return frontend.NewZRPCFromConf(confPath)
+}
+
+// serve runs the server with the flags in args.
+func serve(args []string) {
+	opts := defineFlags(flag.CommandLine)
 
 	// TODO prod metrics
-	// TODO support config from file and env vars
-	flag.Parse()
+	flag.CommandLine.Parse(args)
+	if err := setFlagsFromEnv(flag.CommandLine, os.LookupEnv); err != nil {
+		log.WithFields(logrus.Fields{
+			"error": err,
+		}).Fatal("bad environment variable")
+	}
+	if opts.configPath != "" {
+		if err := loadConfigFile(flag.CommandLine, opts.configPath); err != nil {
+			log.WithFields(logrus.Fields{
+				"error": err,
+			}).Fatal("bad config file")
+		}
+	}
 
 	if opts.zcashConfPath == "" {
 		flag.Usage()
 		os.Exit(1)
 	}
 
-	if !opts.noTLS && (opts.tlsCertPath == "" || opts.tlsKeyPath == "") {
-		println("Please specify a TLS certificate/key to use. You can use a self-signed certificate.")
+	if opts.selfTest {
+		if err := selfTest(opts, os.Stdout, newRPCFromConf); err != nil {
+			fmt.Fprintln(os.Stderr, "self-test:", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	// A process started by an upgrade shares the ports with the old one
+	// until that exits.
+	_, upgrading := os.LookupEnv(upgradeFDsEnv)
+
+	if opts.bindAddr == "" && opts.bindUnix == "" {
+		log.Fatal("no -bind-addr or -bind-unix to listen on")
+	}
+	bindUnixMode, err := parseFileMode(opts.bindUnixMode)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"error": err,
+		}).Fatal("bad -bind-unix-mode")
+	}
+
+	if opts.acmeDomain != "" && (opts.tlsCertPath != "" || opts.tlsKeyPath != "" || opts.tlsOCSPStapling) {
+		log.Fatal("-acme-domain can't be used with -tls-cert, -tls-key or -tls-ocsp-stapling")
+	}
+	if opts.noTLS && opts.tlsClientCA != "" {
+		log.Fatal("-tls-client-ca can't be used with -no-tls")
+	}
+	if opts.tlsSelfSigned && (opts.noTLS || opts.acmeDomain != "" || opts.tlsCertPath != "" || opts.tlsKeyPath != "") {
+		log.Fatal("-tls-self-signed can't be used with -no-tls, -acme-domain, -tls-cert or -tls-key")
+	}
+	if !opts.noTLS && opts.bindAddr != "" && opts.acmeDomain == "" && !opts.tlsSelfSigned && (opts.tlsCertPath == "" || opts.tlsKeyPath == "") {
+		println("Please specify a TLS certificate/key to use, or -tls-self-signed to generate a self-signed one.")
 		println("See 'https://github.com/adityapk00/lightwalletd/blob/master/README.md#running-your-own-zeclite-lightwalletd'")
 		os.Exit(1)
 	}
@@ -157,11 +574,169 @@ func main() {
 
 	logger.SetLevel(logrus.Level(opts.logLevel))
 
+	var registry prometheus.Registerer
+	log, registry = withInstanceLabel(opts.instanceLabel, log, promRegistry)
+	registerMetrics(registry)
+
+	minLatency, err := parseMethodDurations(opts.minLatency)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"error": err,
+		}).Fatal("bad -min-latency")
+	}
+	logMethods, err := parseMethodLogging(opts.logMethod)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"error": err,
+		}).Fatal("bad -log-method")
+	}
+
+	proxies, err := parseTrustedProxies(opts.trustedProxies)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"error": err,
+		}).Fatal("bad -trusted-proxies")
+	}
+
+	peerQuotas, err := parsePeerQuotas(opts.peerQuota)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"error": err,
+		}).Fatal("bad -peer-quota")
+	}
+	quotas := newQuotaLimiter(peerQuotas, opts.peerQuotaMaxPeers, metrics.QuotaRejections)
+
+	if err := checkOperatorInfo(opts); err != nil {
+		log.WithFields(logrus.Fields{
+			"error": err,
+		}).Fatal("bad operator information")
+	}
+	if err := checkSQLite(opts, common.SQLiteSupported); err != nil {
+		log.WithFields(logrus.Fields{
+			"error": err,
+		}).Fatal("bad database settings")
+	}
+
+	clientVersions, err := newClientVersionCheck(opts.minClientVersion, opts.clientUpgradeURL, opts.requireClientVer)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"error": err,
+		}).Fatal("bad -min-client-version")
+	}
+
+	sloTargets, err := parseSLOTargets(opts.slo)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"error": err,
+		}).Fatal("bad -slo")
+	}
+	if opts.sloWindow < time.Minute {
+		log.WithFields(logrus.Fields{
+			"window": opts.sloWindow,
+		}).Fatal("-slo-window must be at least a minute")
+	}
+	slos := newSLOTracker(sloTargets, opts.sloWindow, metrics.SLOBurnRate)
+	if slos != nil {
+		go slos.Run()
+	}
+
+	var shedder *loadShedder
+	if opts.maxConcurrent > 0 {
+		shedder = newLoadShedder(opts.maxConcurrent, opts.shedQueueWait, opts.shedRetryAfter, metrics.ShedRequests)
+	}
+
+	budget := newMemoryBudget(opts.requestMemory, metrics.RequestMemoryAborts)
+
+	if opts.maxRecvMsgSize <= 0 || opts.maxSendMsgSize <= 0 {
+		log.Fatal("-max-recv-msg-size and -max-send-msg-size must be positive")
+	}
+	if opts.maxTxSize == 0 || opts.maxTxSize >= opts.maxSendMsgSize {
+		log.WithFields(logrus.Fields{
+			"max_transaction_size": opts.maxTxSize,
+			"max_send_msg_size":    opts.maxSendMsgSize,
+		}).Warn("GetTransaction may fail on transactions too large to send rather than refuse them; set -max-transaction-size below -max-send-msg-size")
+	}
+
 	// gRPC initialization
-	var server *grpc.Server
+	serverOpts := []grpc.ServerOption{
+		grpc.UnaryInterceptor(chainUnaryInterceptors(
+			clientAddrUnaryInterceptor(proxies),
+			requestIDUnaryInterceptor(opts.requestIDTrailer),
+			logInterceptor(logMethods),
+			slos.unaryInterceptor(),
+			shedder.unaryInterceptor(),
+			quotas.unaryInterceptor(),
+			budget.unaryInterceptor(),
+			clientVersions.unaryInterceptor(),
+			deprecationUnaryInterceptor(opts.deprecated),
+			minLatencyUnaryInterceptor(minLatency),
+		)),
+		grpc.StreamInterceptor(chainStreamInterceptors(
+			clientAddrStreamInterceptor(proxies),
+			requestIDStreamInterceptor(opts.requestIDTrailer),
+			streamLogInterceptor(logMethods),
+			slos.streamInterceptor(),
+			shedder.streamInterceptor(),
+			quotas.streamInterceptor(),
+			budget.streamInterceptor(),
+			clientVersions.streamInterceptor(),
+			deprecationStreamInterceptor(opts.deprecated),
+			minLatencyStreamInterceptor(minLatency),
+		)),
+	}
+	serverOpts = append(serverOpts, messageLimitOptions(opts.maxRecvMsgSize, opts.maxSendMsgSize, opts.maxStreams)...)
+	serverOpts = append(serverOpts, keepaliveOptions(keepalive.ServerParameters{
+		MaxConnectionIdle:     opts.maxConnIdle,
+		MaxConnectionAge:      opts.maxConnAge,
+		MaxConnectionAgeGrace: opts.maxConnAgeGrace,
+		Time:                  opts.keepaliveTime,
+		Timeout:               opts.keepaliveTimeout,
+	}, keepalive.EnforcementPolicy{
+		MinTime:             opts.keepaliveMinTime,
+		PermitWithoutStream: opts.keepaliveNoStream,
+	})...)
+
+	if opts.tlsSelfSigned {
+		created, err := selfSignedCert(opts)
+		if err != nil {
+			log.WithFields(logrus.Fields{
+				"dir":   opts.tlsSelfSignedDir,
+				"error": err,
+			}).Fatal("couldn't set up the self-signed TLS certificate")
+		}
+		fingerprint, err := certFingerprint(opts.tlsCertPath)
+		if err != nil {
+			log.WithFields(logrus.Fields{
+				"cert_file": opts.tlsCertPath,
+				"error":     err,
+			}).Fatal("couldn't read the self-signed TLS certificate")
+		}
+		log.WithFields(logrus.Fields{
+			"cert_file":          opts.tlsCertPath,
+			"created":            created,
+			"fingerprint_sha256": fingerprint,
+		}).Info("serving a self-signed TLS certificate, wallets can pin its fingerprint")
+	}
 
-	if !opts.noTLS && (opts.tlsCertPath != "" && opts.tlsKeyPath != "") {
-		transportCreds, err := credentials.NewServerTLSFromFile(opts.tlsCertPath, opts.tlsKeyPath)
+	var tlsConfig *tls.Config
+	if !opts.noTLS && opts.acmeDomain != "" {
+		manager := newACMEManager(splitList(opts.acmeDomain), opts.acmeCacheDir, opts.acmeEmail)
+		tlsConfig = manager.TLSConfig()
+		restrictSNI(tlsConfig, splitList(opts.tlsAllowedSNI))
+		if opts.acmeHTTPAddr != "" {
+			go func() {
+				listener, err := listenTCP(opts.acmeHTTPAddr, upgrading)
+				if err == nil {
+					err = http.Serve(listener, manager.HTTPHandler(nil))
+				}
+				log.WithFields(logrus.Fields{
+					"acme_http_addr": opts.acmeHTTPAddr,
+					"error":          err,
+				}).Warn("not answering ACME HTTP challenges")
+			}()
+		}
+	} else if !opts.noTLS && (opts.tlsCertPath != "" && opts.tlsKeyPath != "") {
+		tlsConfig, err = newTLSConfig(opts.tlsCertPath, opts.tlsKeyPath, splitList(opts.tlsAllowedSNI))
 		if err != nil {
 			log.WithFields(logrus.Fields{
 				"cert_file": opts.tlsCertPath,
@@ -169,33 +744,91 @@ func main() {
 				"error":     err,
 			}).Fatal("couldn't load TLS credentials")
 		}
-		server = grpc.NewServer(grpc.Creds(transportCreds), LoggingInterceptor())
-	} else {
-		server = grpc.NewServer(LoggingInterceptor())
+		var stapler *ocspStapler
+		if opts.tlsOCSPStapling {
+			stapler, err = newOCSPStapler(tlsConfig, metrics.OCSPStapleValidUntil, log)
+			if err != nil {
+				log.WithFields(logrus.Fields{
+					"cert_file": opts.tlsCertPath,
+					"error":     err,
+				}).Fatal("couldn't set up OCSP stapling")
+			}
+			go stapler.Run(opts.tlsOCSPRefresh)
+		}
+		if opts.tlsReloadInterval > 0 {
+			reloader, err := newCertReloader(tlsConfig, opts.tlsCertPath, opts.tlsKeyPath, stapler, log)
+			if err != nil {
+				log.WithFields(logrus.Fields{
+					"cert_file": opts.tlsCertPath,
+					"key_path":  opts.tlsKeyPath,
+					"error":     err,
+				}).Fatal("couldn't watch the TLS credentials")
+			}
+			go reloader.Run(opts.tlsReloadInterval)
+		}
+	}
+	if tlsConfig != nil && opts.tlsClientCA != "" {
+		if err := requireClientCerts(tlsConfig, opts.tlsClientCA); err != nil {
+			log.WithFields(logrus.Fields{
+				"client_ca_file": opts.tlsClientCA,
+				"error":          err,
+			}).Fatal("couldn't load the TLS client CAs")
+		}
 	}
+	if tlsConfig != nil {
+		if err := applyTLSPolicy(tlsConfig, opts.tlsMinVersion, splitList(opts.tlsCipherSuites), splitList(opts.tlsCurves)); err != nil {
+			log.WithFields(logrus.Fields{
+				"error": err,
+			}).Fatal("bad TLS policy")
+		}
+		log.WithFields(tlsPolicyFields(tlsConfig)).Info("TLS policy")
+
+		// Connections on the Unix socket stay plaintext, and with
+		// -shared-port the TLS is terminated before gRPC.
+		var creds credentials.TransportCredentials = unixPlaintextCreds{credentials.NewTLS(tlsConfig)}
+		if opts.sharedPort {
+			creds = sharedPortCreds{creds}
+		}
+		serverOpts = append(serverOpts, grpc.Creds(creds))
+	}
+
+	server := grpc.NewServer(serverOpts...)
 
 	// Enable reflection for debugging
 	if opts.logLevel >= uint64(logrus.WarnLevel) {
 		reflection.Register(server)
 	}
 
-	// Initialize Zcash RPC client. Right now (Jan 2018) this is only for
-	// sending transactions, but in the future it could back a different type
-	// of block streamer.
-
-	rpcClient, err := frontend.NewZRPCFromConf(opts.zcashConfPath)
-	if err != nil {
-		log.WithFields(logrus.Fields{
-			"error": err,
-		}).Warn("zcash.conf failed, will try empty credentials for rpc")
-
-		rpcClient, err = frontend.NewZRPCFromCreds("127.0.0.1:23811", "", "")
+	// Initialize Zcash RPC client. Read calls are spread over all the
+	// configured backends, transactions go to the primary (the first one).
+	rpcClient := frontend.NewRPCPool(log, metrics)
+	rpcClient.BroadcastAll = opts.broadcastAll
 
+	// Every unreadable conf file falls back to the same local node, which is
+	// only added once.
+	fallback := false
+	for _, confPath := range strings.Split(opts.zcashConfPath, ",") {
+		backend, err := frontend.NewZRPCFromConf(confPath)
 		if err != nil {
 			log.WithFields(logrus.Fields{
-				"error": err,
-			}).Warn("couldn't start rpc conn. won't be able to send transactions")
+				"conf_file": confPath,
+				"error":     err,
+			}).Warn("zcash.conf failed, will try empty credentials for rpc")
+			if fallback {
+				continue
+			}
+			fallback = true
+
+			backend, err = frontend.NewZRPCFromCreds("127.0.0.1:23811", "", "")
+
+			if err != nil {
+				log.WithFields(logrus.Fields{
+					"error": err,
+				}).Warn("couldn't start rpc conn. won't be able to send transactions")
+				continue
+			}
 		}
+		rpcClient.AddBackend(confPath, backend)
 	}
 
 	// Get the sapling activation height from the RPC
@@ -206,60 +839,334 @@ func main() {
 		}).Warn("Unable to get sapling activation height")
 	}
 
+	reportedHeight := saplingHeight
+	if err == nil || opts.saplingHeight > 0 {
+		saplingHeight, err = common.SaplingActivationHeight(chainName, reportedHeight, opts.saplingHeight)
+		if err != nil {
+			log.WithFields(logrus.Fields{
+				"chain": chainName,
+				"error": err,
+			}).Fatal("Unable to determine sapling activation height")
+		}
+	}
+	if opts.saplingHeight > 0 && reportedHeight >= 0 && reportedHeight != opts.saplingHeight {
+		log.WithFields(logrus.Fields{
+			"chain":    chainName,
+			"reported": reportedHeight,
+			"override": opts.saplingHeight,
+			"using":    saplingHeight,
+		}).Warn("-sapling-activation-height doesn't match the node")
+	}
+
 	log.Info("Got sapling height ", saplingHeight, " chain ", chainName, " branchID ", branchID)
 
 	// Initialize the cache
-	cache := common.NewBlockCache(opts.cacheSize, log)
+	var cacheStore common.BlockCacheStore
+	switch opts.cacheStore {
+	case "memory":
+		cacheStore = common.NewMemoryBlockCacheStore()
+	case "mmap":
+		cacheStore, err = common.NewMmapBlockCacheStore(opts.cacheFile)
+		if err != nil {
+			log.WithFields(logrus.Fields{
+				"cache_file": opts.cacheFile,
+				"error":      err,
+			}).Fatal("couldn't create cache store")
+		}
+	default:
+		log.WithFields(logrus.Fields{
+			"cache_store": opts.cacheStore,
+		}).Fatal("unknown cache store")
+	}
+	if opts.cacheWindow > 0 {
+		cacheStore = common.NewTieredBlockCacheStore(opts.cacheWindow, cacheStore, metrics)
+	}
+	cache := common.NewBlockCacheWithStore(opts.cacheSize, log, cacheStore)
+	if opts.cacheHashIndex {
+		cache.EnableHashIndex()
+	}
+	if opts.cacheCompactAfter > 0 {
+		cache.EnableCompaction(opts.cacheCompactAfter, metrics)
+	}
+	switch opts.cacheEviction {
+	case "oldest":
+		cache.Eviction = common.EvictOldest
+	case "recent":
+		cache.Eviction = common.EvictLeastRecent
+	case "activity":
+		cache.Eviction = common.EvictLeastActive
+	default:
+		log.WithFields(logrus.Fields{
+			"cache_eviction": opts.cacheEviction,
+		}).Fatal("unknown cache eviction policy")
+	}
+	if opts.cacheTierDir != "" {
+		tier, err := common.NewDirBlockTier(opts.cacheTierDir)
+		if err != nil {
+			log.WithFields(logrus.Fields{
+				"cache_tier_dir": opts.cacheTierDir,
+				"error":          err,
+			}).Fatal("couldn't create cache tier")
+		}
+		cache.SetEvictionHook(tier)
+	}
+	if opts.coalesceBlocks > 0 {
+		cache.Coalescer = common.NewBlockFetchCoalescer(opts.coalesceBlocks, opts.coalesceLinger, metrics)
+	}
+	if opts.checkpointInterval > 0 {
+		cache.Checkpoints, err = common.NewCheckpointIndex(opts.checkpointInterval, opts.checkpointFile)
+		if err != nil {
+			log.WithFields(logrus.Fields{
+				"checkpoint_file": opts.checkpointFile,
+				"error":           err,
+			}).Fatal("couldn't load checkpoints")
+		}
+	}
+
+	var addressIndex *common.AddressIndex
+	if opts.addressIndexDB != "" {
+		addressIndex, err = common.NewAddressIndex(opts.addressIndexDB, chainName)
+		if err != nil {
+			log.WithFields(logrus.Fields{
+				"address_index_db": opts.addressIndexDB,
+				"error":            err,
+			}).Fatal("couldn't open address index")
+		}
+		defer addressIndex.Close()
+		go addressIndex.Sync(rpcClient, log, opts.ingestInterval)
+	}
+
+	var treeStates *common.TreeStateStore
+	if opts.treeStateMax > 0 {
+		treeStates, err = common.NewTreeStateStore(opts.treeStateDB, opts.treeStateMax)
+		if err != nil {
+			log.WithFields(logrus.Fields{
+				"tree_state_db": opts.treeStateDB,
+				"error":         err,
+			}).Fatal("couldn't open tree state store")
+		}
+		defer treeStates.Close()
+	}
+
+	if opts.memoryLimitMB > 0 {
+		limit := opts.memoryLimitMB << 20
+		if !setMemoryLimit(int64(limit)) {
+			log.Warn("this Go version can't set a runtime memory limit, only the cache will be shrunk")
+		}
+		go common.NewCacheShrinker(cache, limit, opts.cacheMinSize, metrics, log).Run(10 * time.Second)
+	}
 
 	stopChan := make(chan bool, 1)
 
 	// Start the block cache importer at 100 blocks, so that the server is ready immediately.
 	// The remaining blocks are added historically
-	cacheStart := blockHeight - 100
-	if cacheStart < saplingHeight {
-		cacheStart = saplingHeight
+	cacheStart, clamped, err := warmWindowStart(blockHeight, saplingHeight, opts.cacheSize, opts.clampWarmWindow)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"cache_size": opts.cacheSize,
+			"error":      err,
+		}).Fatal("cache too small")
+	}
+	if clamped {
+		log.WithFields(logrus.Fields{
+			"cache_size":  opts.cacheSize,
+			"warm_window": warmWindow,
+		}).Error("-cache-size is smaller than the warm window, only warming the cache with the blocks it can hold")
 	}
 
 	// Start the ingestor
-	go common.BlockIngestor(rpcClient, cache, log, stopChan, cacheStart)
+	go common.BlockIngestor(rpcClient, cache, log, stopChan, cacheStart, common.IngestorOptions{
+		PollInterval: opts.ingestInterval,
+		Prefetch:     opts.ingestPrefetch,
+		Validator:    common.NewBlockValidator(opts.validateSample, metrics, log),
+		AddressIndex: addressIndex,
+	})
 
 	// Add historical blocks also
 	go common.HistoricalBlockIngestor(rpcClient, cache, log, cacheStart-1, opts.cacheSize, saplingHeight)
 
-	// Signal handler for graceful stops
+	// Start the metrics server
+	metricsServer := &http.Server{Addr: fmt.Sprintf(":%d", opts.metricsPort)}
+	http.Handle("/metrics", promhttp.HandlerFor(
+		promRegistry,
+		promhttp.HandlerOpts{},
+	))
+	if opts.statusFile != "" {
+		go common.NewStatusFile(opts.statusFile, rpcClient, cache, log).Run(opts.statusInterval)
+	}
+	if opts.statusPort > 0 {
+		statusAddr := net.JoinHostPort(opts.statusBindAddr, strconv.Itoa(opts.statusPort))
+		go func() {
+			statusListener, err := listenTCP(statusAddr, upgrading)
+			if err != nil {
+				log.WithFields(logrus.Fields{
+					"bind_addr": statusAddr,
+					"error":     err,
+				}).Fatal("couldn't listen on the status port")
+			}
+			common.ServeStatusPort(statusListener, cache)
+		}()
+	}
+
+	if opts.diskProbeInterval > 0 {
+		dir, err := diskProbeDir(opts)
+		if err != nil {
+			log.WithFields(logrus.Fields{
+				"error": err,
+			}).Fatal("bad disk probe settings")
+		}
+		probe := common.NewDiskProbe(dir, opts.diskProbeThreshold, metrics, log)
+		go probe.Run(opts.diskProbeInterval)
+		if opts.diskProbeReadyz {
+			http.HandleFunc("/readyz", probe.ReadyHandler)
+		}
+	}
+	if !opts.sharedPort {
+		go func() {
+			listener, err := listenTCP(metricsServer.Addr, upgrading)
+			if err == nil {
+				err = metricsServer.Serve(listener)
+			}
+			if err != http.ErrServerClosed {
+				log.Fatal(err)
+			}
+		}()
+	}
+
+	// Report the server's health to gRPC health checks, from Kubernetes or
+	// Envoy for example.
+	healthServer := health.NewServer()
+	healthpb.RegisterHealthServer(server, healthServer)
+	go runHealthChecks(healthServer, rpcClient, cache, opts.healthMaxLag, opts.healthInterval, log)
+
+	// Tell systemd, for units of Type=notify, when the server is ready: the
+	// warm window is in the cache and zcashd answers.
+	notifier := newSystemdNotifier(os.LookupEnv, log)
+	go notifier.runWatchdog(os.LookupEnv)
+	ready := func() bool {
+		return serverReady(rpcClient, cache, blockHeight)
+	}
+	go notifier.notifyWhenReady(ready, time.Second)
+	// The process that started this one by an upgrade waits for it too.
+	go notifierFromEnv(os.LookupEnv, upgradeNotifyEnv, log).notifyWhenReady(ready, time.Second)
+
+	// Signal handler for graceful stops, also used once a new process has
+	// taken over after an upgrade.
+	stopped := make(chan bool)
+	upgraded := make(chan struct{})
 	signals := make(chan os.Signal, 1)
 	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
 	go func() {
-		s := <-signals
-		log.WithFields(logrus.Fields{
-			"signal": s.String(),
-		}).Info("caught signal, stopping gRPC server")
-		// Stop the server
-		server.GracefulStop()
+		select {
+		case s := <-signals:
+			log.WithFields(logrus.Fields{
+				"signal": s.String(),
+			}).Info("caught signal, stopping gRPC server")
+			notifier.notify("STOPPING=1")
+		case <-upgraded:
+			log.Info("new process serving, stopping gRPC server")
+		}
 		// Stop the block ingestor
 		stopChan <- true
-	}()
-
-	// Start the metrics server
-	go func() {
-		http.Handle("/metrics", promhttp.HandlerFor(
-			promRegistry,
-			promhttp.HandlerOpts{},
-		))
-		metricsport := fmt.Sprintf(":%d", opts.metricsPort)
-		log.Fatal(http.ListenAndServe(metricsport, nil))
+		// Stop the servers, failing health checks while draining
+		healthServer.Shutdown()
+		shutdown(server, metricsServer, opts.metricsGrace)
+		close(stopped)
 	}()
 
 	// Start the download params handler
 	log.Infof("Starting params handler")
-	paramsport := fmt.Sprintf(":%d", opts.paramsPort)
-	go common.ParamsDownloadHandler(metrics, log, paramsport)
+	paramsLimit := common.RegisterParamsHandler(metrics, log, opts.paramsRate, opts.paramsBurst)
+	if !opts.sharedPort {
+		paramsport := fmt.Sprintf(":%d", opts.paramsPort)
+		go func() {
+			listener, err := listenTCP(paramsport, upgrading)
+			if err == nil {
+				err = http.Serve(listener, nil)
+			}
+			log.WithFields(logrus.Fields{
+				"error": err,
+			}).Warn("params handler stopped")
+		}()
+	}
 
 	// Start the GRPC server
 	log.Infof("Starting gRPC server on %s", opts.bindAddr)
 
 	// Compact transaction service initialization
-	service, err := frontend.NewSQLiteStreamer(rpcClient, cache, log, metrics)
+	sendRetryCodes, err := parseIntList(opts.sendRetryCodes)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"error": err,
+		}).Fatal("bad -send-retry-codes")
+	}
+	lookupStrategies, err := parseLookupStrategies(opts.lookupStrategy)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"error": err,
+		}).Fatal("bad -lookup-strategy")
+	}
+	var notices *common.NoticeFile
+	if opts.noticesFile != "" {
+		notices, err = common.NewNoticeFile(opts.noticesFile, log)
+		if err != nil {
+			log.WithFields(logrus.Fields{
+				"error": err,
+			}).Fatal("bad -notices-file")
+		}
+		if opts.noticesInterval > 0 {
+			go notices.Run(opts.noticesInterval)
+		}
+	}
+	// The node's status is refreshed for the activation height and branch
+	// ID metrics, and used by GetLightdInfo with -lightd-info-cached.
+	nodeStatus := common.NewNodeStatusCache(rpcClient, log)
+	nodeStatus.Metrics = metrics
+	go nodeStatus.Run(opts.nodeStatusInterval)
+	var lightdInfoStatus *common.NodeStatusCache
+	if opts.lightdInfoCached {
+		lightdInfoStatus = nodeStatus
+	}
+
+	service, err := frontend.NewSQLiteStreamer(addressIndex.Client(rpcClient), cache, log, metrics, frontend.Options{
+		SendRequireSynced:           opts.sendRequireSynced,
+		SendMinVerificationProgress: opts.sendMinProgress,
+		SendCheckBranch:             opts.sendCheckBranch,
+		SendDedupWindow:             opts.sendDedupWindow,
+		SendDedupMax:                opts.sendDedupMax,
+		SendMinInputConfirmations:   opts.minInputConfs,
+		DeprecatedMethods:           opts.deprecated.Methods(),
+		SendRetryCodes:              sendRetryCodes,
+		SendRetryBackoff:            opts.sendRetryBackoff,
+		MaxBlockRangeStreams:        opts.maxRangeStreams,
+		MaxFullBlockRequests:        opts.maxFullBlocks,
+		MaxTransactionSize:          opts.maxTxSize,
+		TaddressTxidsPage:           opts.taddrTxidsPage,
+		AddressIndex:                addressIndex,
+		TxCacheSize:                 opts.txCacheSize,
+		TxCacheTTL:                  opts.txCacheTTL,
+		TransactionsBatch:           opts.txBatch,
+		TxStatusWindow:              opts.txStatusWindow,
+		TxStatusMax:                 opts.txStatusMax,
+		MinRangeCheckpointInterval:  opts.rangeCheckpoints,
+		FollowBlockRange:            opts.followBlockRange,
+		EmptyBlockHeartbeat:         opts.emptyHeartbeat,
+		SubscribeKeepalive:          opts.subscribeKeepalive,
+		LookupStrategies:            lookupStrategies,
+		NodeStatus:                  lightdInfoStatus,
+		LightdInfoStaleNodeFields:   opts.lightdInfoStale,
+		SaplingActivationHeight:     opts.saplingHeight,
+		GitCommit:                   gitCommit,
+		BuildDate:                   buildDate,
+		DonationAddress:             opts.donationAddress,
+		DonationTaddress:            opts.donationTaddress,
+		OperatorName:                opts.operatorName,
+		OperatorContact:             opts.operatorContact,
+		PrivacyPolicyURL:            opts.privacyPolicyURL,
+		Notices:                     notices,
+		TreeStates:                  treeStates,
+		ChainName:                   chainName,
+	})
 	if err != nil {
 		log.WithFields(logrus.Fields{
 			"error": err,
@@ -270,19 +1177,248 @@ func main() {
 	// Register service
 	walletrpc.RegisterCompactTxStreamerServer(server, service)
 
-	// Start listening
-	listener, err := net.Listen("tcp", opts.bindAddr)
+	if err := validateMethods(opts.deprecated, server); err != nil {
+		log.WithFields(logrus.Fields{
+			"error": err,
+		}).Fatal("bad -deprecate-method")
+	}
+	if err := validateMethods(opts.minLatency, server); err != nil {
+		log.WithFields(logrus.Fields{
+			"error": err,
+		}).Fatal("bad -min-latency")
+	}
+	if err := validateMethods(opts.logMethod, server); err != nil {
+		log.WithFields(logrus.Fields{
+			"error": err,
+		}).Fatal("bad -log-method")
+	}
+	if err := validateMethods(opts.peerQuota, server); err != nil {
+		log.WithFields(logrus.Fields{
+			"error": err,
+		}).Fatal("bad -peer-quota")
+	}
+	if err := validateMethods(opts.slo, server); err != nil {
+		log.WithFields(logrus.Fields{
+			"error": err,
+		}).Fatal("bad -slo")
+	}
+
+	// Apply the settings that can change without a restart on SIGHUP
+	reload := newReloader(args, flag.CommandLine, opts, server, quotas, paramsLimit, rpcClient)
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	go func() {
+		for range hangups {
+			if err := reload.Reload(); err != nil {
+				log.WithFields(logrus.Fields{
+					"error": err,
+				}).Error("couldn't reload settings, keeping the current ones")
+			}
+		}
+	}()
+
+	// Start listening, on the sockets passed by systemd if it started the
+	// server with socket activation, or by the process this one upgrades.
+	listeners, err := inheritedListeners()
 	if err != nil {
 		log.WithFields(logrus.Fields{
-			"bind_addr": opts.bindAddr,
-			"error":     err,
-		}).Fatal("couldn't create listener")
+			"error": err,
+		}).Fatal("couldn't use the inherited sockets")
+	}
+	if len(listeners) > 0 {
+		log.WithFields(logrus.Fields{
+			"sockets": len(listeners),
+		}).Info("using the inherited sockets, ignoring -bind-addr")
+	} else {
+		if opts.bindAddr != "" {
+			listeners, err = listen(splitList(opts.bindAddr))
+			if err != nil {
+				log.WithFields(logrus.Fields{
+					"bind_addr": opts.bindAddr,
+					"error":     err,
+				}).Fatal("couldn't create listener")
+			}
+		}
+		if opts.bindUnix != "" {
+			listener, err := listenUnix(opts.bindUnix, bindUnixMode)
+			if err != nil {
+				log.WithFields(logrus.Fields{
+					"bind_unix": opts.bindUnix,
+					"error":     err,
+				}).Fatal("couldn't listen on the Unix socket")
+			}
+			listeners = append(listeners, listener)
+		}
 	}
 
-	err = server.Serve(listener)
+	// On SIGUSR2, hand the sockets over to a new process running the
+	// executable, then drain and exit.
+	upgrades := make(chan os.Signal, 1)
+	notifyUpgradeSignal(upgrades)
+	go func() {
+		for range upgrades {
+			if opts.cacheStore == "mmap" {
+				log.Error("can't upgrade with -cache-store mmap, the new process would truncate the cache file in use")
+				continue
+			}
+			executable, err := os.Executable()
+			if err == nil {
+				u := &upgrader{
+					executable: executable,
+					args:       os.Args[1:],
+					listeners:  listeners,
+					timeout:    opts.upgradeTimeout,
+					notifier:   notifier,
+					log:        log,
+				}
+				err = u.upgrade()
+			}
+			if err != nil {
+				log.WithFields(logrus.Fields{
+					"error": err,
+				}).Error("upgrade failed, carrying on")
+				continue
+			}
+			close(upgraded)
+			return
+		}
+	}()
+
+	// The upgrader hands the bare sockets over.
+	serveListeners := listeners
+	if opts.proxyProtocol {
+		serveListeners = withProxyProtocol(listeners, proxyProtoTimeout)
+	}
+	if opts.sharedPort {
+		err = serveShared(server, metricsServer, serveListeners, tlsConfig)
+	} else {
+		err = serveAll(server, serveListeners)
+	}
 	if err != nil {
 		log.WithFields(logrus.Fields{
 			"error": err,
 		}).Fatal("gRPC server exited")
 	}
+
+	// Serve returns as soon as the listener is closed, wait for the
+	// in-flight requests and the metrics server to finish.
+	<-stopped
+}
+
+// shutdown stops the gRPC server, waiting for in-flight requests to complete,
+// and only then stops the metrics server. The metrics server is kept up for
+// metricsGrace after the drain so that a last scrape sees the final values.
+func shutdown(server *grpc.Server, metricsServer *http.Server, metricsGrace time.Duration) {
+	server.GracefulStop()
+
+	log.WithFields(logrus.Fields{
+		"grace": metricsGrace,
+	}).Info("gRPC server drained, stopping metrics server")
+	time.Sleep(metricsGrace)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := metricsServer.Shutdown(ctx); err != nil {
+		log.WithFields(logrus.Fields{
+			"error": err,
+		}).Warn("metrics server didn't shut down cleanly")
+	}
+}
+
+// warmWindow is how many blocks below the tip the cache is filled with before
+// anything else, so that the server is ready immediately.
+const warmWindow = 100
+
+// warmWindowStart returns the height the ingestor starts at: warmWindow
+// blocks below tip, but not below saplingHeight. The blocks from there to tip
+// must fit in a cache of cacheSize blocks; if they don't and clamp is set,
+// the start is moved up so they do, and clamped is returned set; otherwise
+// it's an error.
+func warmWindowStart(tip, saplingHeight, cacheSize int, clamp bool) (start int, clamped bool, err error) {
+	start = tip - warmWindow
+	if start < saplingHeight {
+		start = saplingHeight
+	}
+	if tip-start+1 > cacheSize {
+		if !clamp {
+			return 0, false, fmt.Errorf("-cache-size %d can't hold the %d blocks the cache is warmed with, raise it or set -clamp-warm-window", cacheSize, tip-start+1)
+		}
+		return tip - cacheSize + 1, true, nil
+	}
+	return start, false, nil
+}
+
+// diskProbeDir returns the directory the disk probe watches: -disk-probe-dir,
+// or that of the cache file when the cache is kept on disk. With the cache
+// in memory there's no default, since the server may not write to that
+// directory at all.
+func diskProbeDir(opts *Options) (string, error) {
+	if opts.diskProbeDir != "" {
+		return opts.diskProbeDir, nil
+	}
+	if opts.cacheStore == "mmap" {
+		return filepath.Dir(opts.cacheFile), nil
+	}
+	return "", fmt.Errorf("-disk-probe-interval needs -disk-probe-dir with -cache-store=%s", opts.cacheStore)
+}
+
+// checkSQLite refuses the settings that need SQLite when it isn't
+// supported, as in a build without cgo, rather than failing halfway through
+// starting up.
+func checkSQLite(opts *Options, supported bool) error {
+	if supported {
+		return nil
+	}
+	if opts.addressIndexDB != "" {
+		return fmt.Errorf("-address-index-db needs SQLite, and this lightwalletd was built without cgo")
+	}
+	if opts.treeStateDB != "" && opts.treeStateMax > 0 {
+		return fmt.Errorf("-tree-state-db needs SQLite, and this lightwalletd was built without cgo")
+	}
+	return nil
+}
+
+// parseIntList parses a comma-separated list of integers, such as "-28,-9".
+func parseIntList(s string) ([]int, error) {
+	var list []int
+	for _, field := range splitList(s) {
+		n, err := strconv.Atoi(field)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, n)
+	}
+	return list, nil
+}
+
+// checkOperatorInfo catches the likely mistakes in the operator information
+// served by GetLightdInfo: donation addresses of the wrong kind, and a
+// privacy policy URL that isn't a web address.
+func checkOperatorInfo(opts *Options) error {
+	if opts.donationAddress != "" && !strings.HasPrefix(opts.donationAddress, "z") {
+		return fmt.Errorf("-donation-address %q isn't a shielded address", opts.donationAddress)
+	}
+	if opts.donationTaddress != "" && !strings.HasPrefix(opts.donationTaddress, "t") {
+		return fmt.Errorf("-donation-taddress %q isn't a transparent address", opts.donationTaddress)
+	}
+	if opts.privacyPolicyURL != "" {
+		u, err := url.Parse(opts.privacyPolicyURL)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return fmt.Errorf("-privacy-policy-url %q isn't an http or https URL", opts.privacyPolicyURL)
+		}
+	}
+	return nil
+}
+
+// parseLookupStrategies parses the values of f as lookup strategies.
+func parseLookupStrategies(f methodFlag) (map[string]common.LookupStrategy, error) {
+	strategies := make(map[string]common.LookupStrategy, len(f))
+	for method, value := range f {
+		strategy, err := common.ParseLookupStrategy(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", method, err)
+		}
+		strategies[method] = strategy
+	}
+	return strategies, nil
 }
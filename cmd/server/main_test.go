@@ -0,0 +1,401 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/status"
+
+	"github.com/adityapk00/lightwalletd/walletrpc"
+)
+
+func init() {
+	logger.SetOutput(ioutil.Discard)
+	logger.SetLevel(logrus.PanicLevel)
+}
+
+// slowStreamer blocks GetLatestBlock until released, then counts the call.
+type slowStreamer struct {
+	walletrpc.UnimplementedCompactTxStreamerServer
+	entered chan bool
+	release chan bool
+	counter prometheus.Counter
+}
+
+func (s *slowStreamer) GetLatestBlock(ctx context.Context, in *walletrpc.ChainSpec) (*walletrpc.BlockID, error) {
+	s.entered <- true
+	<-s.release
+	s.counter.Inc()
+	return &walletrpc.BlockID{Height: 1}, nil
+}
+
+// startTestServer serves service on a loopback port and returns a client for it.
+func startTestServer(t *testing.T, server *grpc.Server, service walletrpc.CompactTxStreamerServer) walletrpc.CompactTxStreamerClient {
+	walletrpc.RegisterCompactTxStreamerServer(server, service)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(listener)
+
+	conn, err := grpc.Dial(listener.Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return walletrpc.NewCompactTxStreamerClient(conn)
+}
+
+func scrape(url string) (string, error) {
+	resp, err := http.Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	return string(body), err
+}
+
+func TestShutdownKeepsMetricsForDrainedRequests(t *testing.T) {
+	counter := prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "test_requests_total",
+		Help: "test counter",
+	})
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(counter)
+
+	metricsListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	metricsServer := &http.Server{Handler: promhttp.HandlerFor(registry, promhttp.HandlerOpts{})}
+	go metricsServer.Serve(metricsListener)
+	metricsURL := "http://" + metricsListener.Addr().String() + "/metrics"
+
+	service := &slowStreamer{
+		entered: make(chan bool),
+		release: make(chan bool),
+		counter: counter,
+	}
+	server := grpc.NewServer()
+	client := startTestServer(t, server, service)
+
+	callDone := make(chan error)
+	go func() {
+		_, err := client.GetLatestBlock(context.Background(), &walletrpc.ChainSpec{})
+		callDone <- err
+	}()
+	<-service.entered
+
+	// Start shutting down while the request is still in flight.
+	stopped := make(chan bool)
+	go func() {
+		shutdown(server, metricsServer, 500*time.Millisecond)
+		close(stopped)
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	close(service.release)
+	if err := <-callDone; err != nil {
+		t.Fatal("in-flight request should complete during shutdown:", err)
+	}
+
+	// The drained request must be visible to a scrape after the gRPC server stopped.
+	body, err := scrape(metricsURL)
+	if err != nil {
+		t.Fatal("metrics server should still be up during the grace period:", err)
+	}
+	if !strings.Contains(body, "test_requests_total 1") {
+		t.Errorf("final request not reflected in metrics:\n%s", body)
+	}
+
+	<-stopped
+	if _, err := scrape(metricsURL); err == nil {
+		t.Error("metrics server should be stopped after the grace period")
+	}
+}
+
+// countingListener counts the connections it accepts.
+type countingListener struct {
+	net.Listener
+	accepted chan bool
+}
+
+func (l *countingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err == nil {
+		l.accepted <- true
+	}
+	return conn, err
+}
+
+func TestMaxConnectionAge(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	counting := &countingListener{Listener: listener, accepted: make(chan bool, 10)}
+
+	service := &slowStreamer{
+		entered: make(chan bool),
+		release: make(chan bool),
+		counter: prometheus.NewCounter(prometheus.CounterOpts{Name: "test_requests_total"}),
+	}
+	server := grpc.NewServer(keepaliveOptions(keepalive.ServerParameters{
+		MaxConnectionAge:      200 * time.Millisecond,
+		MaxConnectionAgeGrace: 5 * time.Second,
+	}, keepalive.EnforcementPolicy{})...)
+	walletrpc.RegisterCompactTxStreamerServer(server, service)
+	go server.Serve(counting)
+	defer server.Stop()
+
+	conn, err := grpc.Dial(listener.Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := walletrpc.NewCompactTxStreamerClient(conn)
+
+	// A call in flight when the connection ages out finishes within the grace.
+	callDone := make(chan error)
+	go func() {
+		_, err := client.GetLatestBlock(context.Background(), &walletrpc.ChainSpec{})
+		callDone <- err
+	}()
+	<-service.entered
+	<-counting.accepted
+	time.Sleep(500 * time.Millisecond)
+	service.release <- true
+	if err := <-callDone; err != nil {
+		t.Fatal("in-flight call should finish during the grace period:", err)
+	}
+
+	// The client has been told to reconnect, and later calls use a new connection.
+	go func() {
+		_, err := client.GetLatestBlock(context.Background(), &walletrpc.ChainSpec{})
+		callDone <- err
+	}()
+	<-service.entered
+	service.release <- true
+	if err := <-callDone; err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-counting.accepted:
+	case <-time.After(time.Second):
+		t.Error("client didn't reconnect after the max connection age")
+	}
+}
+
+func TestMaxConnectionIdle(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	counting := &countingListener{Listener: listener, accepted: make(chan bool, 10)}
+	server := grpc.NewServer(keepaliveOptions(keepalive.ServerParameters{
+		MaxConnectionIdle: 100 * time.Millisecond,
+	}, keepalive.EnforcementPolicy{})...)
+	walletrpc.RegisterCompactTxStreamerServer(server, &stubStreamer{})
+	go server.Serve(counting)
+	defer server.Stop()
+
+	conn, err := grpc.Dial(listener.Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := walletrpc.NewCompactTxStreamerClient(conn)
+
+	for i := 0; i < 2; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		_, err := client.GetLatestBlock(ctx, &walletrpc.ChainSpec{}, grpc.WaitForReady(true))
+		cancel()
+		if err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case <-counting.accepted:
+		case <-time.After(time.Second):
+			t.Fatalf("call %d: no new connection after the idle one was closed", i)
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+}
+
+func TestMessageLimits(t *testing.T) {
+	service := &slowStreamer{
+		entered: make(chan bool),
+		release: make(chan bool),
+		counter: prometheus.NewCounter(prometheus.CounterOpts{Name: "test_requests_total"}),
+	}
+	server := grpc.NewServer(messageLimitOptions(1024, 1<<20, 1)...)
+	defer server.Stop()
+	client := startTestServer(t, server, service)
+	ctx := context.Background()
+
+	_, err := client.SendTransaction(ctx, &walletrpc.RawTransaction{Data: make([]byte, 512)})
+	if status.Code(err) != codes.Unimplemented {
+		t.Errorf("a message within the limit didn't reach the service: %v", err)
+	}
+	_, err = client.SendTransaction(ctx, &walletrpc.RawTransaction{Data: make([]byte, 2048)})
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("expected ResourceExhausted for a message over the limit, got %v", err)
+	}
+
+	// A second call on the connection waits for the first to finish.
+	callDone := make(chan error)
+	go func() {
+		_, err := client.GetLatestBlock(ctx, &walletrpc.ChainSpec{})
+		callDone <- err
+	}()
+	<-service.entered
+	short, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	defer cancel()
+	if _, err := client.GetLatestBlock(short, &walletrpc.ChainSpec{}); status.Code(err) != codes.DeadlineExceeded {
+		t.Errorf("expected the second call to wait, got %v", err)
+	}
+	service.release <- true
+	if err := <-callDone; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNoKeepaliveOptionsByDefault(t *testing.T) {
+	if opts := keepaliveOptions(keepalive.ServerParameters{}, keepalive.EnforcementPolicy{}); len(opts) != 0 {
+		t.Error("zero settings should leave gRPC's defaults alone")
+	}
+}
+
+func TestInstanceLabel(t *testing.T) {
+	testLogger, hook := test.NewNullLogger()
+	registry := prometheus.NewRegistry()
+	entry, registerer := withInstanceLabel("eu-west-1", testLogger.WithField("app", "frontend-grpc"), registry)
+
+	entry.Info("hello")
+	if got := hook.LastEntry().Data[instanceLabel]; got != "eu-west-1" {
+		t.Errorf("log entry labelled %v", got)
+	}
+
+	counter := prometheus.NewCounter(prometheus.CounterOpts{Name: "test_requests_total", Help: "test counter"})
+	registerer.MustRegister(counter)
+	counter.Inc()
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	labels := families[0].GetMetric()[0].GetLabel()
+	if len(labels) != 1 || labels[0].GetName() != instanceLabel || labels[0].GetValue() != "eu-west-1" {
+		t.Errorf("metric labelled %v", labels)
+	}
+
+	// Without a label nothing changes.
+	entry, registerer = withInstanceLabel("", testLogger.WithField("app", "frontend-grpc"), registry)
+	entry.Info("hello")
+	if _, ok := hook.LastEntry().Data[instanceLabel]; ok || registerer != prometheus.Registerer(registry) {
+		t.Error("empty instance label was applied")
+	}
+}
+
+func TestWarmWindowStart(t *testing.T) {
+	for _, tt := range []struct {
+		name          string
+		tip, sapling  int
+		cacheSize     int
+		clamp         bool
+		start         int
+		clamped, fail bool
+	}{
+		{"fits", 1000, 0, 40000, true, 900, false, false},
+		{"near sapling", 1050, 1000, 40000, false, 1000, false, false},
+		{"clamped", 1000, 0, 50, true, 951, true, false},
+		{"refused", 1000, 0, 50, false, 0, false, true},
+		{"fits above sapling", 1030, 1000, 50, false, 1000, false, false},
+	} {
+		start, clamped, err := warmWindowStart(tt.tip, tt.sapling, tt.cacheSize, tt.clamp)
+		if (err != nil) != tt.fail {
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+			continue
+		}
+		if start != tt.start || clamped != tt.clamped {
+			t.Errorf("%s: got start %d clamped %v, expected %d %v", tt.name, start, clamped, tt.start, tt.clamped)
+		}
+		if err == nil && tt.tip-start+1 > tt.cacheSize {
+			t.Errorf("%s: %d blocks from %d don't fit a cache of %d", tt.name, tt.tip-start+1, start, tt.cacheSize)
+		}
+	}
+}
+
+func TestDiskProbeDir(t *testing.T) {
+	for _, tt := range []struct {
+		opts Options
+		dir  string
+		ok   bool
+	}{
+		{Options{cacheStore: "mmap", cacheFile: "/var/lib/lightwalletd/cache.dat"}, "/var/lib/lightwalletd", true},
+		{Options{cacheStore: "mmap", cacheFile: "cache.dat", diskProbeDir: "/data"}, "/data", true},
+		{Options{cacheStore: "memory", diskProbeDir: "/data"}, "/data", true},
+		{Options{cacheStore: "memory", cacheFile: "/var/lib/lightwalletd/cache.dat"}, "", false},
+	} {
+		dir, err := diskProbeDir(&tt.opts)
+		if (err == nil) != tt.ok || dir != tt.dir {
+			t.Errorf("%+v: got %q, %v, expected %q, ok %v", tt.opts, dir, err, tt.dir, tt.ok)
+		}
+	}
+}
+
+func TestCheckSQLite(t *testing.T) {
+	// The default settings never need SQLite, so that a build without cgo
+	// starts.
+	defaults := defineFlags(flag.NewFlagSet("test", flag.ContinueOnError))
+	if err := checkSQLite(defaults, false); err != nil {
+		t.Errorf("default settings need SQLite: %v", err)
+	}
+	for _, tt := range []struct {
+		opts      Options
+		supported bool
+		ok        bool
+	}{
+		{Options{treeStateDB: "treestates.db", treeStateMax: 100}, true, true},
+		{Options{treeStateDB: "treestates.db", treeStateMax: 100}, false, false},
+		{Options{treeStateDB: "treestates.db"}, false, true},
+		{Options{treeStateMax: 100}, false, true},
+		{Options{addressIndexDB: "addresses.db"}, true, true},
+		{Options{addressIndexDB: "addresses.db"}, false, false},
+	} {
+		if err := checkSQLite(&tt.opts, tt.supported); (err == nil) != tt.ok {
+			t.Errorf("%+v, supported %v: got %v, expected ok %v", tt.opts, tt.supported, err, tt.ok)
+		}
+	}
+}
+
+func TestCheckOperatorInfo(t *testing.T) {
+	for _, tt := range []struct {
+		opts Options
+		ok   bool
+	}{
+		{Options{}, true},
+		{Options{donationAddress: "zs1donate", donationTaddress: "t1donate", privacyPolicyURL: "https://example.com/privacy"}, true},
+		{Options{donationAddress: "t1donate"}, false},
+		{Options{donationTaddress: "zs1donate"}, false},
+		{Options{privacyPolicyURL: "example.com/privacy"}, false},
+		{Options{privacyPolicyURL: "ftp://example.com/privacy"}, false},
+	} {
+		if err := checkOperatorInfo(&tt.opts); (err == nil) != tt.ok {
+			t.Errorf("%+v: got %v, expected ok %v", tt.opts, err, tt.ok)
+		}
+	}
+}
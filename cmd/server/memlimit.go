@@ -0,0 +1,13 @@
+//go:build go1.19
+// +build go1.19
+
+package main
+
+import "runtime/debug"
+
+// setMemoryLimit sets the Go runtime's soft memory limit, so that the
+// garbage collector works harder as the heap gets close to it.
+func setMemoryLimit(limit int64) bool {
+	debug.SetMemoryLimit(limit)
+	return true
+}
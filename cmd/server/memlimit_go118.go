@@ -0,0 +1,10 @@
+//go:build !go1.19
+// +build !go1.19
+
+package main
+
+// setMemoryLimit is not supported before Go 1.19; the block cache is still
+// shrunk under pressure, but the garbage collector doesn't know the limit.
+func setMemoryLimit(limit int64) bool {
+	return false
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+
+	"github.com/sirupsen/logrus"
+	"github.com/soheilhy/cmux"
+	"golang.org/x/net/http2"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// serveShared serves gRPC and httpServer's handlers on each of listeners,
+// for -shared-port. TLS, if tlsConfig is set, is terminated first, on all
+// but Unix sockets, offering both HTTP/2 and HTTP/1.1 by ALPN. Connections
+// are then told apart by their first request: HTTP/2 with an application/grpc
+// content type goes to server, other HTTP/2 and HTTP/1 to httpServer.
+// server must have been made with sharedPortCreds. It returns as serveAll
+// does.
+func serveShared(server *grpc.Server, httpServer *http.Server, listeners []net.Listener, tlsConfig *tls.Config) error {
+	if tlsConfig != nil && len(tlsConfig.NextProtos) == 0 {
+		// ACME configurations already offer these, and the protocol of
+		// their challenges.
+		tlsConfig = tlsConfig.Clone()
+		tlsConfig.NextProtos = []string{"h2", "http/1.1"}
+	}
+
+	grpcListeners := make([]net.Listener, len(listeners))
+	for i, listener := range listeners {
+		if tlsConfig != nil && listener.Addr().Network() != "unix" {
+			listener = tls.NewListener(listener, tlsConfig)
+		}
+		m := cmux.New(listener)
+		// Some clients wait for the server's SETTINGS before sending
+		// their headers.
+		grpcListeners[i] = tlsTerminatedListener{m.MatchWithWriters(
+			cmux.HTTP2MatchHeaderFieldPrefixSendSettings("content-type", "application/grpc"))}
+		http2Listener := m.Match(cmux.HTTP2())
+		http1Listener := m.Match(cmux.Any())
+
+		go serveHTTP2(http2Listener, httpServer)
+		go httpServer.Serve(http1Listener)
+		go func(addr net.Addr) {
+			if err := m.Serve(); err != nil {
+				log.WithFields(logrus.Fields{
+					"addr":  addr.String(),
+					"error": err,
+				}).Debug("stopped demultiplexing listener")
+			}
+		}(listener.Addr())
+	}
+	return serveAll(server, grpcListeners)
+}
+
+// serveHTTP2 serves httpServer's handler on the HTTP/2 connections from
+// listener, whose TLS, if any, is already terminated.
+func serveHTTP2(listener net.Listener, httpServer *http.Server) {
+	server := &http2.Server{}
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go server.ServeConn(&settingsAckConn{Conn: conn}, &http2.ServeConnOpts{
+			BaseConfig: httpServer,
+			Handler:    httpServer.Handler,
+		})
+	}
+}
+
+// settingsAckConn drops the first SETTINGS acknowledgement the client sends
+// on an HTTP/2 connection. It answers the SETTINGS frame the gRPC matcher
+// wrote, which the server the connection was then handed to didn't send and
+// would take as a protocol error.
+type settingsAckConn struct {
+	net.Conn
+	prefaceRead bool
+	dropped     bool
+	pending     []byte
+}
+
+func (c *settingsAckConn) Read(p []byte) (int, error) {
+	for len(c.pending) == 0 {
+		if c.dropped {
+			return c.Conn.Read(p)
+		}
+		if err := c.readFrame(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+// readFrame reads the client preface, or the next frame, into pending,
+// unless it's the SETTINGS acknowledgement to drop.
+func (c *settingsAckConn) readFrame() error {
+	if !c.prefaceRead {
+		c.pending = make([]byte, len(http2.ClientPreface))
+		c.prefaceRead = true
+		_, err := io.ReadFull(c.Conn, c.pending)
+		return err
+	}
+	frame := make([]byte, 9)
+	if _, err := io.ReadFull(c.Conn, frame); err != nil {
+		return err
+	}
+	length := int(frame[0])<<16 | int(frame[1])<<8 | int(frame[2])
+	if length > http2MaxInitialFrameSize {
+		return errors.New("http2: frame too large")
+	}
+	frame = append(frame, make([]byte, length)...)
+	if _, err := io.ReadFull(c.Conn, frame[9:]); err != nil {
+		return err
+	}
+	if http2.FrameType(frame[3]) == http2.FrameSettings && http2.Flags(frame[4]).Has(http2.FlagSettingsAck) {
+		c.dropped = true
+		return nil
+	}
+	c.pending = frame
+	return nil
+}
+
+// http2MaxInitialFrameSize is the largest frame a client may send before
+// the server's settings allow more.
+const http2MaxInitialFrameSize = 1 << 14
+
+// tlsTerminatedConn is a connection whose TLS handshake is done, with the
+// state it left.
+type tlsTerminatedConn struct {
+	net.Conn
+	state tls.ConnectionState
+}
+
+// tlsTerminatedListener marks the connections it accepts that came through
+// TLS as tlsTerminatedConn, for sharedPortCreds.
+type tlsTerminatedListener struct {
+	net.Listener
+}
+
+func (l tlsTerminatedListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if muxConn, ok := conn.(*cmux.MuxConn); ok {
+		if tlsConn, ok := muxConn.Conn.(*tls.Conn); ok {
+			return tlsTerminatedConn{conn, tlsConn.ConnectionState()}, nil
+		}
+	}
+	return conn, nil
+}
+
+// sharedPortCreds are transport credentials that skip the handshake of
+// connections whose TLS serveShared has terminated already.
+type sharedPortCreds struct {
+	credentials.TransportCredentials
+}
+
+func (c sharedPortCreds) ServerHandshake(conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	if tlsConn, ok := conn.(tlsTerminatedConn); ok {
+		return conn, credentials.TLSInfo{State: tlsConn.state}, nil
+	}
+	return c.TransportCredentials.ServerHandshake(conn)
+}
+
+func (c sharedPortCreds) Clone() credentials.TransportCredentials {
+	return sharedPortCreds{c.TransportCredentials.Clone()}
+}
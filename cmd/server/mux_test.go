@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+
+	"github.com/adityapk00/lightwalletd/walletrpc"
+)
+
+func TestServeShared(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lightwalletd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certPath, keyPath := writeTestCert(t, dir, "localhost")
+	tlsConfig, err := newTLSConfig(certPath, keyPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := grpc.NewServer(grpc.Creds(sharedPortCreds{unixPlaintextCreds{credentials.NewTLS(tlsConfig)}}))
+	walletrpc.RegisterCompactTxStreamerServer(server, &stubStreamer{})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Proto))
+	})
+	httpServer := &http.Server{Handler: mux}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	go serveShared(server, httpServer, []net.Listener{listener}, tlsConfig)
+	defer server.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	clientTLS := &tls.Config{InsecureSkipVerify: true}
+	conn, err := grpc.DialContext(ctx, addr, grpc.WithTransportCredentials(credentials.NewTLS(clientTLS)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := walletrpc.NewCompactTxStreamerClient(conn).GetLatestBlock(ctx, &walletrpc.ChainSpec{}); err != nil {
+		t.Errorf("gRPC call on the shared port failed: %v", err)
+	}
+
+	for _, tt := range []struct {
+		transport *http.Transport
+		want      string
+	}{
+		{&http.Transport{TLSClientConfig: clientTLS, ForceAttemptHTTP2: true}, "HTTP/2.0"},
+		{&http.Transport{TLSClientConfig: clientTLS, TLSNextProto: map[string]func(string, *tls.Conn) http.RoundTripper{}}, "HTTP/1.1"},
+	} {
+		// The second request reuses the connection.
+		client := &http.Client{Transport: tt.transport, Timeout: 5 * time.Second}
+		for i := 0; i < 2; i++ {
+			resp, err := client.Get("https://" + addr + "/metrics")
+			if err != nil {
+				t.Errorf("%s: %v", tt.want, err)
+				continue
+			}
+			body, err := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			if err != nil || string(body) != tt.want {
+				t.Errorf("expected the metrics over %s, got %q, %v", tt.want, body, err)
+			}
+		}
+		tt.transport.CloseIdleConnections()
+	}
+}
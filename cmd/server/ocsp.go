@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ocsp"
+)
+
+// ocspStapler keeps an OCSP response for the server's certificate, fetched
+// from the issuer's responder, and staples it to the handshakes, so that
+// clients can check the certificate wasn't revoked without asking the
+// responder themselves. If the responder can't be reached the last response
+// is stapled until it expires, and after that none: handshakes never fail
+// for want of a staple.
+type ocspStapler struct {
+	cert      tls.Certificate
+	leaf      *x509.Certificate
+	issuer    *x509.Certificate
+	responder string
+	client    *http.Client
+
+	mutex      sync.RWMutex
+	staple     []byte
+	nextUpdate time.Time
+
+	validUntil prometheus.Gauge
+	log        *logrus.Entry
+}
+
+// newOCSPStapler staples OCSP responses to config's certificate, which must
+// come with its issuer's certificate and name an OCSP responder.
+// validUntil is set to the time the current staple expires, zero if there's
+// none.
+func newOCSPStapler(config *tls.Config, validUntil prometheus.Gauge, log *logrus.Entry) (*ocspStapler, error) {
+	if len(config.Certificates) != 1 {
+		return nil, errors.New("OCSP stapling needs exactly one certificate")
+	}
+	s := &ocspStapler{
+		client:     &http.Client{Timeout: 10 * time.Second},
+		validUntil: validUntil,
+		log:        log,
+	}
+	if err := s.setCertificate(config.Certificates[0]); err != nil {
+		return nil, err
+	}
+	config.Certificates = nil
+	config.GetCertificate = s.getCertificate
+	return s, nil
+}
+
+// setCertificate replaces the certificate to staple responses to, which
+// must come with its issuer's certificate and name an OCSP responder. No
+// response is stapled until the next Refresh.
+func (s *ocspStapler) setCertificate(cert tls.Certificate) error {
+	if len(cert.Certificate) < 2 {
+		return errors.New("the certificate file doesn't include the issuer's certificate")
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return err
+	}
+	issuer, err := x509.ParseCertificate(cert.Certificate[1])
+	if err != nil {
+		return err
+	}
+	if len(leaf.OCSPServer) == 0 {
+		return errors.New("the certificate doesn't name an OCSP responder")
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.cert = cert
+	s.leaf = leaf
+	s.issuer = issuer
+	s.responder = leaf.OCSPServer[0]
+	s.staple = nil
+	s.nextUpdate = time.Time{}
+	s.validUntil.Set(0)
+	return nil
+}
+
+// getCertificate is the tls.Config GetCertificate callback.
+func (s *ocspStapler) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	cert := s.cert
+	if time.Now().Before(s.nextUpdate) {
+		cert.OCSPStaple = s.staple
+	}
+	return &cert, nil
+}
+
+// Refresh fetches a new OCSP response. On failure, the previous one is kept.
+func (s *ocspStapler) Refresh() error {
+	s.mutex.RLock()
+	leaf, issuer, responder := s.leaf, s.issuer, s.responder
+	s.mutex.RUnlock()
+
+	staple, resp, err := s.fetch(leaf, issuer, responder)
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.leaf != leaf {
+		// The certificate was replaced meanwhile.
+		return nil
+	}
+	s.staple = staple
+	s.nextUpdate = resp.NextUpdate
+	s.validUntil.Set(float64(resp.NextUpdate.Unix()))
+	return nil
+}
+
+func (s *ocspStapler) fetch(leaf, issuer *x509.Certificate, responder string) ([]byte, *ocsp.Response, error) {
+	req, err := ocsp.CreateRequest(leaf, issuer, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	httpResp, err := s.client.Post(responder, "application/ocsp-request", bytes.NewReader(req))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer httpResp.Body.Close()
+	if httpResp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("OCSP responder returned %s", httpResp.Status)
+	}
+	staple, err := ioutil.ReadAll(httpResp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	resp, err := ocsp.ParseResponseForCert(staple, leaf, issuer)
+	if err != nil {
+		return nil, nil, err
+	}
+	if resp.Status != ocsp.Good {
+		return nil, nil, fmt.Errorf("OCSP responder says the certificate's status is %d, not good", resp.Status)
+	}
+	if !resp.NextUpdate.After(time.Now()) {
+		return nil, nil, errors.New("OCSP response has already expired")
+	}
+	return staple, resp, nil
+}
+
+// Run refreshes the staple every interval, forever.
+func (s *ocspStapler) Run(interval time.Duration) {
+	for {
+		if err := s.Refresh(); err != nil {
+			s.mutex.RLock()
+			s.log.WithFields(logrus.Fields{
+				"responder": s.responder,
+				"error":     err,
+			}).Warn("couldn't refresh the OCSP staple")
+
+			if !time.Now().Before(s.nextUpdate) {
+				s.validUntil.Set(0)
+			}
+			s.mutex.RUnlock()
+		}
+		time.Sleep(interval)
+	}
+}
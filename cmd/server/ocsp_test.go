@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"golang.org/x/crypto/ocsp"
+)
+
+// testOCSPResponder answers OCSP requests for certificates issued by ca,
+// with Good, until failing is set.
+type testOCSPResponder struct {
+	ca    *x509.Certificate
+	caKey *ecdsa.PrivateKey
+
+	mutex   sync.Mutex
+	failing bool
+	served  []byte
+}
+
+func (r *testOCSPResponder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.failing {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	body, _ := ioutil.ReadAll(req.Body)
+	ocspReq, err := ocsp.ParseRequest(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	resp, err := ocsp.CreateResponse(r.ca, r.ca, ocsp.Response{
+		Status:       ocsp.Good,
+		SerialNumber: ocspReq.SerialNumber,
+		ThisUpdate:   time.Now().Add(-time.Minute).Truncate(time.Second),
+		NextUpdate:   time.Now().Add(time.Hour).Truncate(time.Second),
+	}, r.caKey)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	r.served = resp
+	w.Write(resp)
+}
+
+// writeTestChain writes a certificate naming responder as its OCSP responder,
+// followed by the certificate of the CA that issued it, and its key, to dir.
+func writeTestChain(t *testing.T, dir string, responder *testOCSPResponder, url string) (certPath, keyPath string) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	responder.ca, _ = x509.ParseCertificate(caDER)
+	responder.caKey = caKey
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "lightwalletd.example.com"},
+		DNSNames:     []string{"lightwalletd.example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		OCSPServer:   []string{url},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, responder.ca, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var chain bytes.Buffer
+	pem.Encode(&chain, &pem.Block{Type: "CERTIFICATE", Bytes: der})
+	pem.Encode(&chain, &pem.Block{Type: "CERTIFICATE", Bytes: caDER})
+	certPath = filepath.Join(dir, "chain.pem")
+	keyPath = filepath.Join(dir, "key.pem")
+	if err := ioutil.WriteFile(certPath, chain.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certPath, keyPath
+}
+
+// stapledResponse runs a TLS handshake against config and returns the OCSP
+// response the server stapled.
+func stapledResponse(t *testing.T, config *tls.Config) []byte {
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+
+	go func() {
+		server := tls.Server(serverConn, config)
+		server.Handshake()
+		server.Close()
+	}()
+
+	client := tls.Client(clientConn, &tls.Config{ServerName: "lightwalletd.example.com", InsecureSkipVerify: true})
+	if err := client.Handshake(); err != nil {
+		t.Fatalf("handshake failed: %v", err)
+	}
+	return client.ConnectionState().OCSPResponse
+}
+
+func TestOCSPStapling(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lightwalletd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	responder := &testOCSPResponder{}
+	httpServer := httptest.NewServer(responder)
+	defer httpServer.Close()
+	certPath, keyPath := writeTestChain(t, dir, responder, httpServer.URL)
+
+	config, err := newTLSConfig(certPath, keyPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	validUntil := prometheus.NewGauge(prometheus.GaugeOpts{Name: "test_ocsp_valid_until", Help: "test gauge"})
+	stapler, err := newOCSPStapler(config, validUntil, log)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if staple := stapledResponse(t, config); staple != nil {
+		t.Error("stapled a response before fetching one")
+	}
+
+	if err := stapler.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	staple := stapledResponse(t, config)
+	if len(staple) == 0 || !bytes.Equal(staple, responder.served) {
+		t.Fatal("the responder's response wasn't stapled")
+	}
+	resp, err := ocsp.ParseResponseForCert(staple, stapler.leaf, responder.ca)
+	if err != nil || resp.Status != ocsp.Good {
+		t.Errorf("stapled an unusable response: %v", err)
+	}
+	if got := testutil.ToFloat64(validUntil); got != float64(resp.NextUpdate.Unix()) {
+		t.Errorf("metric says the staple is valid until %v, expected %v", got, resp.NextUpdate.Unix())
+	}
+
+	// The last response is kept while the responder is down...
+	responder.mutex.Lock()
+	responder.failing = true
+	responder.mutex.Unlock()
+	if err := stapler.Refresh(); err == nil {
+		t.Error("expected the refresh to fail")
+	}
+	if !bytes.Equal(stapledResponse(t, config), staple) {
+		t.Error("the staple was dropped when the responder went down")
+	}
+
+	// ...until it expires, then handshakes go on without one.
+	stapler.mutex.Lock()
+	stapler.nextUpdate = time.Now().Add(-time.Second)
+	stapler.mutex.Unlock()
+	if stapledResponse(t, config) != nil {
+		t.Error("stapled an expired response")
+	}
+}
+
+func TestOCSPStaplingNeedsIssuer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lightwalletd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certPath, keyPath := writeTestCert(t, dir, "lightwalletd.example.com")
+
+	config, err := newTLSConfig(certPath, keyPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	validUntil := prometheus.NewGauge(prometheus.GaugeOpts{Name: "test_ocsp_valid_until", Help: "test gauge"})
+	if _, err := newOCSPStapler(config, validUntil, log); err == nil {
+		t.Error("expected a certificate without its issuer to be rejected")
+	}
+}
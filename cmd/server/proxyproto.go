@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// proxyProtoV2Signature starts a version 2 PROXY protocol header.
+var proxyProtoV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// proxyProtoV1MaxLength is the longest a version 1 header can be.
+const proxyProtoV1MaxLength = 107
+
+// proxyProtoTimeout is how long a load balancer has to send the header.
+const proxyProtoTimeout = 10 * time.Second
+
+// proxyProtoListener accepts connections from a load balancer that starts
+// each one with a PROXY protocol header, version 1 or 2, naming the client
+// it's relaying. The connections it returns report the client's address as
+// their RemoteAddr, for logging, quotas and metrics. The header is read on
+// first use of the connection, not in Accept, so that a slow load balancer
+// doesn't hold up the others, and it must come within timeout. Connections
+// without a valid header fail.
+type proxyProtoListener struct {
+	net.Listener
+	timeout time.Duration
+}
+
+func (l proxyProtoListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &proxyProtoConn{Conn: conn, reader: bufio.NewReader(conn), timeout: l.timeout}, nil
+}
+
+// withProxyProtocol wraps the TCP listeners with proxyProtoListener. Unix
+// sockets are left alone: they aren't reached through a load balancer.
+func withProxyProtocol(listeners []net.Listener, timeout time.Duration) []net.Listener {
+	wrapped := make([]net.Listener, len(listeners))
+	for i, listener := range listeners {
+		if listener.Addr().Network() == "unix" {
+			wrapped[i] = listener
+		} else {
+			wrapped[i] = proxyProtoListener{listener, timeout}
+		}
+	}
+	return wrapped
+}
+
+// proxyProtoConn is a connection from a load balancer whose PROXY protocol
+// header is read on first use.
+type proxyProtoConn struct {
+	net.Conn
+	reader  *bufio.Reader
+	timeout time.Duration
+
+	once       sync.Once
+	remoteAddr net.Addr
+	err        error
+}
+
+func (c *proxyProtoConn) readHeader() {
+	c.once.Do(func() {
+		c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+		c.remoteAddr, c.err = readProxyHeader(c.reader)
+		c.Conn.SetReadDeadline(time.Time{})
+		if c.err != nil {
+			c.err = fmt.Errorf("bad PROXY protocol header from %s: %v", c.Conn.RemoteAddr(), c.err)
+			c.Conn.Close()
+		}
+	})
+}
+
+func (c *proxyProtoConn) Read(p []byte) (int, error) {
+	c.readHeader()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.reader.Read(p)
+}
+
+// RemoteAddr returns the address of the client the load balancer relays,
+// or of the load balancer itself if the header doesn't name one, as for its
+// health checks.
+func (c *proxyProtoConn) RemoteAddr() net.Addr {
+	c.readHeader()
+	if c.remoteAddr == nil {
+		return c.Conn.RemoteAddr()
+	}
+	return c.remoteAddr
+}
+
+// readProxyHeader reads a PROXY protocol header from r, and returns the
+// source address it gives, nil for a header that gives none.
+func readProxyHeader(r *bufio.Reader) (net.Addr, error) {
+	// Version 1 headers are longer than the signature too.
+	start, err := r.Peek(len(proxyProtoV2Signature))
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case bytes.Equal(start, proxyProtoV2Signature):
+		return readProxyHeaderV2(r)
+	case bytes.HasPrefix(start, []byte("PROXY ")):
+		return readProxyHeaderV1(r)
+	}
+	return nil, errors.New("no PROXY protocol header")
+}
+
+// readProxyHeaderV1 reads a header such as
+// "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n".
+func readProxyHeaderV1(r *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) >= proxyProtoV1MaxLength {
+			return nil, errors.New("version 1 header too long")
+		}
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+	}
+	fields := strings.Fields(string(line))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("bad version 1 header %q", line)
+	}
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if ip == nil || err != nil || (ip.To4() != nil) != (fields[1] == "TCP4") {
+		return nil, fmt.Errorf("bad version 1 header %q", line)
+	}
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// readProxyHeaderV2 reads a binary header.
+func readProxyHeaderV2(r *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	if header[12]>>4 != 2 {
+		return nil, fmt.Errorf("unknown version %d", header[12]>>4)
+	}
+	command, family := header[12]&0xf, header[13]
+	body := make([]byte, binary.BigEndian.Uint16(header[14:]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+
+	switch command {
+	case 0:
+		// LOCAL: the load balancer's own connection.
+		return nil, nil
+	case 1:
+		// PROXY
+	default:
+		return nil, fmt.Errorf("unknown command %d", command)
+	}
+	switch family {
+	case 0x11:
+		// TCP over IPv4: source and destination addresses, then ports.
+		if len(body) < 12 {
+			return nil, errors.New("short IPv4 addresses")
+		}
+		return &net.TCPAddr{IP: net.IP(body[:4]), Port: int(binary.BigEndian.Uint16(body[8:]))}, nil
+	case 0x21:
+		// TCP over IPv6
+		if len(body) < 36 {
+			return nil, errors.New("short IPv6 addresses")
+		}
+		return &net.TCPAddr{IP: net.IP(body[:16]), Port: int(binary.BigEndian.Uint16(body[32:]))}, nil
+	}
+	// Other families, such as UDP or Unix sockets, don't name a TCP client.
+	return nil, nil
+}
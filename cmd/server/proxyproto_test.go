@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// proxyHeaderV2 builds a version 2 header with command, family and body.
+func proxyHeaderV2(command, family byte, body []byte) string {
+	header := append([]byte{}, proxyProtoV2Signature...)
+	header = append(header, 0x20|command, family, 0, 0)
+	binary.BigEndian.PutUint16(header[14:], uint16(len(body)))
+	return string(append(header, body...))
+}
+
+func TestReadProxyHeader(t *testing.T) {
+	ipv4 := []byte{192, 0, 2, 1, 198, 51, 100, 1, 0xdc, 0x04, 0x01, 0xbb}
+	ipv6 := append(append(net.ParseIP("2001:db8::1"), net.ParseIP("2001:db8::2")...), 0xdc, 0x04, 0x01, 0xbb)
+	for _, tt := range []struct {
+		header  string
+		want    string
+		wantErr bool
+	}{
+		{"PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n", "192.0.2.1:56324", false},
+		{"PROXY TCP6 2001:db8::1 2001:db8::2 56324 443\r\n", "[2001:db8::1]:56324", false},
+		{"PROXY UNKNOWN\r\n", "", false},
+		{"PROXY TCP4 2001:db8::1 2001:db8::2 56324 443\r\n", "", true},
+		{"PROXY TCP4 192.0.2.1 198.51.100.1 99999 443\r\n", "", true},
+		{"PROXY TCP4 192.0.2.1 " + strings.Repeat(" ", 100) + "\r\n", "", true},
+		{"GET / HTTP/1.1\r\nHost: example.com\r\n\r\n", "", true},
+		{proxyHeaderV2(1, 0x11, ipv4), "192.0.2.1:56324", false},
+		{proxyHeaderV2(1, 0x21, ipv6), "[2001:db8::1]:56324", false},
+		// TLVs after the addresses are skipped.
+		{proxyHeaderV2(1, 0x11, append(ipv4, 0x04, 0x00, 0x01, 0x00)), "192.0.2.1:56324", false},
+		{proxyHeaderV2(0, 0x00, nil), "", false},
+		{proxyHeaderV2(1, 0x11, ipv4[:8]), "", true},
+		{proxyHeaderV2(2, 0x11, ipv4), "", true},
+	} {
+		r := bufio.NewReader(strings.NewReader(tt.header + "payload"))
+		addr, err := readProxyHeader(r)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%q: %v", tt.header, err)
+			continue
+		}
+		if tt.wantErr {
+			continue
+		}
+		got := ""
+		if addr != nil {
+			got = addr.String()
+		}
+		if got != tt.want {
+			t.Errorf("%q: got address %q, expected %q", tt.header, got, tt.want)
+		}
+		if rest, _ := ioutil.ReadAll(r); string(rest) != "payload" {
+			t.Errorf("%q: the header wasn't consumed exactly, %q left", tt.header, rest)
+		}
+	}
+}
+
+func TestProxyProtoListener(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	wrapped := withProxyProtocol([]net.Listener{listener}, time.Second)[0]
+
+	for _, tt := range []struct {
+		sent     string
+		wantAddr string
+		wantErr  bool
+	}{
+		{"PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\nhello", "192.0.2.1:56324", false},
+		// A load balancer's health check names no client.
+		{"PROXY UNKNOWN\r\nhello", "127.0.0.1", false},
+		{"hello, no header", "", true},
+	} {
+		client, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		client.Write([]byte(tt.sent))
+		conn, err := wrapped.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 5)
+		_, err = conn.Read(buf)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%q: %v", tt.sent, err)
+		}
+		if !tt.wantErr {
+			if !bytes.Equal(buf, []byte("hello")) {
+				t.Errorf("%q: read %q", tt.sent, buf)
+			}
+			if addr := conn.RemoteAddr().String(); !strings.HasPrefix(addr, tt.wantAddr) {
+				t.Errorf("%q: remote address %s, expected %s", tt.sent, addr, tt.wantAddr)
+			}
+		}
+		conn.Close()
+		client.Close()
+	}
+}
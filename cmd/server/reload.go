@@ -0,0 +1,163 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+
+	"github.com/adityapk00/lightwalletd/common"
+	"github.com/adityapk00/lightwalletd/frontend"
+)
+
+// reloadableFlags are the settings a reload applies to the running server.
+// Changes to the others are only reported, they need a restart.
+var reloadableFlags = map[string]bool{
+	"log-level":    true,
+	"peer-quota":   true,
+	"params-rate":  true,
+	"params-burst": true,
+}
+
+// retiredClientGrace is how long a replaced zcashd RPC client is kept open
+// for the calls still using it.
+const retiredClientGrace = time.Minute
+
+// reloader reads the settings again, from the command line, the environment
+// and the config file, as at startup, and applies those that can change
+// while the server runs: the log level, the peer quotas, the params rate
+// limit, and the zcashd RPC credentials, which are read again from the
+// -conf-file files. Nothing is applied unless everything is valid, and calls
+// in progress carry on undisturbed.
+type reloader struct {
+	args      []string
+	confPaths []string
+	settings  map[string]string
+
+	logger *logrus.Logger
+	log    *logrus.Entry
+	server *grpc.Server
+	quotas *quotaLimiter
+	params *common.ParamsLimit
+	rpc    *frontend.RPCPool
+	newRPC func(confPath string) (common.RPCClient, error)
+}
+
+func newReloader(args []string, fs *flag.FlagSet, opts *Options, server *grpc.Server, quotas *quotaLimiter, params *common.ParamsLimit, rpc *frontend.RPCPool) *reloader {
+	return &reloader{
+		args:      args,
+		confPaths: strings.Split(opts.zcashConfPath, ","),
+		settings:  flagValues(fs),
+		logger:    logger,
+		log:       log,
+		server:    server,
+		quotas:    quotas,
+		params:    params,
+		rpc:       rpc,
+		newRPC:    newRPCFromConf,
+	}
+}
+
+// flagValues returns the value of each flag of fs, by name.
+func flagValues(fs *flag.FlagSet) map[string]string {
+	values := make(map[string]string)
+	fs.VisitAll(func(f *flag.Flag) {
+		values[f.Name] = f.Value.String()
+	})
+	return values
+}
+
+// Reload reads and applies the settings, logging each one that changed.
+func (r *reloader) Reload() error {
+	fs := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	opts := defineFlags(fs)
+	if err := fs.Parse(r.args); err != nil {
+		return err
+	}
+	if err := setFlagsFromEnv(fs, os.LookupEnv); err != nil {
+		return err
+	}
+	if opts.configPath != "" {
+		if err := loadConfigFile(fs, opts.configPath); err != nil {
+			return err
+		}
+	}
+
+	if opts.logLevel > uint64(logrus.TraceLevel) {
+		return fmt.Errorf("bad -log-level %d", opts.logLevel)
+	}
+	quotas, err := parsePeerQuotas(opts.peerQuota)
+	if err == nil {
+		err = validateMethods(opts.peerQuota, r.server)
+	}
+	if err != nil {
+		return fmt.Errorf("bad -peer-quota: %v", err)
+	}
+	clients := make([]common.RPCClient, len(r.confPaths))
+	for i, confPath := range r.confPaths {
+		if clients[i], err = r.newRPC(confPath); err != nil {
+			for _, client := range clients[:i] {
+				shutdownClient(client)
+			}
+			return fmt.Errorf("%s: %v", confPath, err)
+		}
+	}
+
+	settings := flagValues(fs)
+	names := make([]string, 0, len(settings))
+	for name := range settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if settings[name] == r.settings[name] {
+			continue
+		}
+		entry := r.log.WithFields(logrus.Fields{
+			"setting": name,
+			"old":     r.settings[name],
+			"new":     settings[name],
+		})
+		if reloadableFlags[name] {
+			entry.Info("setting changed")
+		} else {
+			entry.Warn("setting changed, restart to apply it")
+		}
+	}
+
+	r.logger.SetLevel(logrus.Level(opts.logLevel))
+	r.quotas.setQuotas(quotas)
+	// Changing the params limit refills every client's burst, so it's only
+	// done when the limit changed.
+	if settings["params-rate"] != r.settings["params-rate"] || settings["params-burst"] != r.settings["params-burst"] {
+		r.params.Set(opts.paramsRate, opts.paramsBurst)
+	}
+	for i, confPath := range r.confPaths {
+		old, err := r.rpc.SetBackendClient(confPath, clients[i])
+		if err != nil {
+			shutdownClient(clients[i])
+			continue
+		}
+		time.AfterFunc(retiredClientGrace, func() { shutdownClient(old) })
+	}
+	r.settings = settings
+
+	r.log.WithFields(logrus.Fields{
+		"backends": len(r.confPaths),
+	}).Info("reloaded settings and zcashd RPC credentials")
+	return nil
+}
+
+// shutdownClient closes client's connections, if it has any.
+func shutdownClient(client common.RPCClient) {
+	if closer, ok := client.(interface{ Shutdown() }); ok {
+		closer.Shutdown()
+	}
+}
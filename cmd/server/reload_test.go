@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"google.golang.org/grpc"
+
+	"github.com/adityapk00/lightwalletd/common"
+	"github.com/adityapk00/lightwalletd/frontend"
+	"github.com/adityapk00/lightwalletd/walletrpc"
+)
+
+// credsNode answers every call with the credentials it was made with.
+type credsNode string
+
+func (n credsNode) RawRequest(method string, params []json.RawMessage) (json.RawMessage, error) {
+	return json.Marshal(string(n))
+}
+
+func TestReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lightwalletd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	configPath := filepath.Join(dir, "lightwalletd.yml")
+	writeConfig := func(config string) {
+		if err := ioutil.WriteFile(configPath, []byte(config), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeConfig("log-level: 4\ncache-size: 100\n")
+
+	server := grpc.NewServer()
+	walletrpc.RegisterCompactTxStreamerServer(server, &stubStreamer{})
+	testLogger, hook := test.NewNullLogger()
+	testLogger.SetLevel(logrus.InfoLevel)
+	rpc := frontend.NewRPCPool(testLogger.WithField("app", "test"), common.GetPrometheusMetrics())
+	rpc.AddBackend("zcash.conf", credsNode("old"))
+	quotas := newQuotaLimiter(nil, 100, nil)
+	params := common.NewParamsLimit(0, 10)
+
+	args := []string{"-config", configPath, "-conf-file", "zcash.conf"}
+	fs, opts := flagSetFor(t, args)
+	r := newReloader(args, fs, opts, server, quotas, params, rpc)
+	r.logger, r.log = testLogger, testLogger.WithField("app", "test")
+	creds := "new"
+	r.newRPC = func(confPath string) (common.RPCClient, error) {
+		if creds == "" {
+			return nil, errors.New("rpcpassword not set")
+		}
+		return credsNode(creds), nil
+	}
+
+	writeConfig("log-level: 5\ncache-size: 200\npeer-quota:\n  GetBlock: 10/1h\nparams-rate: 2\nparams-burst: 5\n")
+	if err := r.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if testLogger.GetLevel() != logrus.DebugLevel {
+		t.Errorf("log level is %v, expected debug", testLogger.GetLevel())
+	}
+	if quota := quotas.quotas["GetBlock"]; quota.limit != 10 || quota.window != time.Hour {
+		t.Errorf("peer quotas not applied: %v", quotas.quotas)
+	}
+	if rate, burst := params.Limit(); rate != 2 || burst != 5 {
+		t.Errorf("params limit is %v/s after %d, expected 2/s after 5", rate, burst)
+	}
+	if result, _ := rpc.RawRequest("getinfo", nil); string(result) != `"new"` {
+		t.Errorf("backend still answers with %s credentials", result)
+	}
+	changed := make(map[string]logrus.Level)
+	for _, entry := range hook.AllEntries() {
+		if setting, ok := entry.Data["setting"].(string); ok {
+			changed[setting] = entry.Level
+		}
+	}
+	if len(changed) != 5 || changed["log-level"] != logrus.InfoLevel || changed["peer-quota"] != logrus.InfoLevel ||
+		changed["params-rate"] != logrus.InfoLevel || changed["params-burst"] != logrus.InfoLevel ||
+		changed["cache-size"] != logrus.WarnLevel {
+		t.Errorf("expected log-level, peer-quota and the params limit to be applied and cache-size to need a restart, got %v", changed)
+	}
+
+	// A bad setting, or credentials that can't be read, change nothing.
+	for _, bad := range []struct{ config, creds string }{
+		{"log-level: 4\npeer-quota:\n  GetBlok: 10/1h\n", "new"},
+		{"log-level: 4\n", ""},
+	} {
+		writeConfig(bad.config)
+		creds = bad.creds
+		if err := r.Reload(); err == nil {
+			t.Errorf("%q: expected the reload to fail", bad.config)
+		}
+		if rate, _ := params.Limit(); testLogger.GetLevel() != logrus.DebugLevel || len(quotas.quotas) != 1 || rate != 2 {
+			t.Errorf("%q: failed reload applied some settings", bad.config)
+		}
+	}
+}
+
+// closingNode is a node client that counts its shutdowns.
+type closingNode struct {
+	credsNode
+	shutdowns *int
+}
+
+func (n closingNode) Shutdown() {
+	*n.shutdowns++
+}
+
+func TestReloadShutsDownUnusedClients(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lightwalletd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	configPath := filepath.Join(dir, "lightwalletd.yml")
+	if err := ioutil.WriteFile(configPath, []byte("log-level: 4\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	server := grpc.NewServer()
+	walletrpc.RegisterCompactTxStreamerServer(server, &stubStreamer{})
+	testLogger, _ := test.NewNullLogger()
+	rpc := frontend.NewRPCPool(testLogger.WithField("app", "test"), common.GetPrometheusMetrics())
+	// b.conf has no backend, as when it couldn't be read at startup.
+	rpc.AddBackend("a.conf", credsNode("old"))
+
+	args := []string{"-config", configPath, "-conf-file", "a.conf,b.conf"}
+	fs, opts := flagSetFor(t, args)
+	r := newReloader(args, fs, opts, server, newQuotaLimiter(nil, 100, nil), common.NewParamsLimit(0, 10), rpc)
+	r.logger, r.log = testLogger, testLogger.WithField("app", "test")
+	shutdowns := make(map[string]*int)
+	failing := ""
+	r.newRPC = func(confPath string) (common.RPCClient, error) {
+		if confPath == failing {
+			return nil, errors.New("rpcpassword not set")
+		}
+		shutdowns[confPath] = new(int)
+		return closingNode{credsNode("new"), shutdowns[confPath]}, nil
+	}
+
+	// The client made for a.conf is shut down when b.conf fails.
+	failing = "b.conf"
+	if err := r.Reload(); err == nil {
+		t.Fatal("expected the reload to fail")
+	}
+	if *shutdowns["a.conf"] != 1 {
+		t.Errorf("client for a.conf shut down %d times, expected once", *shutdowns["a.conf"])
+	}
+
+	// The one made for b.conf, which has no backend to install it in, is
+	// shut down; the one installed for a.conf isn't.
+	failing = ""
+	if err := r.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if *shutdowns["a.conf"] != 0 || *shutdowns["b.conf"] != 1 {
+		t.Errorf("clients shut down a.conf %d, b.conf %d times, expected 0 and 1", *shutdowns["a.conf"], *shutdowns["b.conf"])
+	}
+	if result, _ := rpc.RawRequest("getinfo", nil); string(result) != `"new"` {
+		t.Errorf("backend still answers with %s credentials", result)
+	}
+}
+
+// flagSetFor returns the flags and Options as parsed from args at startup.
+func flagSetFor(t *testing.T, args []string) (*flag.FlagSet, *Options) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	opts := defineFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadConfigFile(fs, opts.configPath); err != nil {
+		t.Fatal(err)
+	}
+	return fs, opts
+}
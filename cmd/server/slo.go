@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// sloTarget is a method's service level objective: the fraction of its calls
+// that must be good, and, if set, how fast a call must be to count as good.
+type sloTarget struct {
+	success float64
+	latency time.Duration
+}
+
+// parseSLOTargets parses the values of f as a success rate in percent,
+// optionally followed by a latency threshold: "99.9" or "99.9/500ms".
+func parseSLOTargets(f methodFlag) (map[string]sloTarget, error) {
+	targets := make(map[string]sloTarget, len(f))
+	for method, value := range f {
+		parts := strings.SplitN(value, "/", 2)
+		percent, err := strconv.ParseFloat(strings.TrimSuffix(parts[0], "%"), 64)
+		if err != nil || percent <= 0 || percent >= 100 {
+			return nil, fmt.Errorf("%s: bad success rate %q, expected a percentage below 100", method, parts[0])
+		}
+		target := sloTarget{success: percent / 100}
+		if len(parts) == 2 {
+			target.latency, err = time.ParseDuration(parts[1])
+			if err != nil || target.latency <= 0 {
+				return nil, fmt.Errorf("%s: bad latency threshold %q", method, parts[1])
+			}
+		}
+		targets[method] = target
+	}
+	return targets, nil
+}
+
+// sloBuckets is how many slices an SLO window is counted in; calls leave the
+// window one slice at a time.
+const sloBuckets = 60
+
+type sloBucket struct {
+	slice int64
+	calls int
+	bad   int
+}
+
+// sloWindow counts a method's calls over the last sloBuckets slices.
+type sloWindow [sloBuckets]sloBucket
+
+func (w *sloWindow) add(slice int64, bad bool) {
+	b := &w[slice%sloBuckets]
+	if b.slice != slice {
+		*b = sloBucket{slice: slice}
+	}
+	b.calls++
+	if bad {
+		b.bad++
+	}
+}
+
+func (w *sloWindow) counts(slice int64) (calls, bad int) {
+	for _, b := range w {
+		if b.slice > slice-sloBuckets && b.slice <= slice {
+			calls += b.calls
+			bad += b.bad
+		}
+	}
+	return calls, bad
+}
+
+// sloTracker sets each method's error budget burn rate: the fraction of its
+// calls over the window that were bad, failing with a server side error or
+// slower than the latency threshold, divided by the fraction its target
+// allows. At 1 the budget is used up exactly as fast as the target allows,
+// at 10 ten times faster; with no calls it's 0.
+type sloTracker struct {
+	targets  map[string]sloTarget
+	slice    time.Duration
+	burnRate *prometheus.GaugeVec
+	now      func() time.Time
+
+	mutex sync.Mutex
+	calls map[string]*sloWindow
+}
+
+func newSLOTracker(targets map[string]sloTarget, window time.Duration, burnRate *prometheus.GaugeVec) *sloTracker {
+	if len(targets) == 0 {
+		return nil
+	}
+	t := &sloTracker{
+		targets:  targets,
+		slice:    window / sloBuckets,
+		burnRate: burnRate,
+		now:      time.Now,
+		calls:    make(map[string]*sloWindow),
+	}
+	for method := range targets {
+		t.calls[method] = &sloWindow{}
+		burnRate.WithLabelValues(method).Set(0)
+	}
+	return t
+}
+
+// badCall tells whether a call failing with err is the server's fault, rather
+// than the client's.
+func badCall(err error) bool {
+	switch status.Code(err) {
+	case codes.Unknown, codes.DeadlineExceeded, codes.Internal, codes.Unavailable, codes.DataLoss:
+		return true
+	}
+	return false
+}
+
+// record counts a call to method that took elapsed and returned err.
+func (t *sloTracker) record(method string, elapsed time.Duration, err error) {
+	target, ok := t.targets[method]
+	if !ok {
+		return
+	}
+	bad := badCall(err) || (target.latency > 0 && elapsed > target.latency)
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	slice := t.now().UnixNano() / int64(t.slice)
+	t.calls[method].add(slice, bad)
+	t.update(method, slice)
+}
+
+// update sets method's burn rate. The caller holds the mutex.
+func (t *sloTracker) update(method string, slice int64) {
+	calls, bad := t.calls[method].counts(slice)
+	rate := 0.0
+	if calls > 0 {
+		rate = float64(bad) / float64(calls) / (1 - t.targets[method].success)
+	}
+	t.burnRate.WithLabelValues(method).Set(rate)
+}
+
+// Run keeps the burn rates up to date as calls leave the window, forever.
+func (t *sloTracker) Run() {
+	for range time.Tick(t.slice) {
+		t.mutex.Lock()
+		slice := t.now().UnixNano() / int64(t.slice)
+		for method := range t.calls {
+			t.update(method, slice)
+		}
+		t.mutex.Unlock()
+	}
+}
+
+// unaryInterceptor records calls to the methods with a target; a nil
+// sloTracker records nothing.
+func (t *sloTracker) unaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if t == nil {
+			return handler(ctx, req)
+		}
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		t.record(methodName(info.FullMethod), time.Since(start), err)
+		return resp, err
+	}
+}
+
+// streamInterceptor records streams as unaryInterceptor does calls; a
+// stream's latency is the time until it ends.
+func (t *sloTracker) streamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if t == nil {
+			return handler(srv, ss)
+		}
+		start := time.Now()
+		err := handler(srv, ss)
+		t.record(methodName(info.FullMethod), time.Since(start), err)
+		return err
+	}
+}
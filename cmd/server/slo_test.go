@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestSLOBurnRate(t *testing.T) {
+	targets, err := parseSLOTargets(methodFlag{"GetBlock": "99", "GetLatestBlock": "99.9/100ms"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	burnRate := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "test_slo_burn_rate", Help: "test gauge"}, []string{"method"})
+	tracker := newSLOTracker(targets, time.Hour, burnRate)
+	now := time.Now()
+	tracker.now = func() time.Time { return now }
+
+	// One call in 50 fails, twice the 1% the target allows. Errors the client
+	// caused don't count.
+	interceptor := tracker.unaryInterceptor()
+	info := &grpc.UnaryServerInfo{FullMethod: "/cash.z.wallet.sdk.rpc.CompactTxStreamer/GetBlock"}
+	for i := 0; i < 1000; i++ {
+		interceptor(context.Background(), nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+			switch {
+			case i%50 == 0:
+				return nil, errors.New("node unreachable")
+			case i%50 == 1:
+				return nil, status.Error(codes.InvalidArgument, "bad height")
+			}
+			return nil, nil
+		})
+	}
+	if rate := testutil.ToFloat64(burnRate.WithLabelValues("GetBlock")); rate < 1.99 || rate > 2.01 {
+		t.Errorf("burn rate is %v, expected 2", rate)
+	}
+
+	// Slow calls count against a latency target.
+	for i := 0; i < 1000; i++ {
+		elapsed := time.Millisecond
+		if i == 0 {
+			elapsed = time.Second
+		}
+		tracker.record("GetLatestBlock", elapsed, nil)
+	}
+	if rate := testutil.ToFloat64(burnRate.WithLabelValues("GetLatestBlock")); rate < 0.99 || rate > 1.01 {
+		t.Errorf("burn rate is %v, expected 1", rate)
+	}
+
+	// Calls older than the window are forgotten.
+	now = now.Add(time.Hour)
+	tracker.record("GetBlock", 0, nil)
+	if rate := testutil.ToFloat64(burnRate.WithLabelValues("GetBlock")); rate != 0 {
+		t.Errorf("burn rate is %v once the failures left the window, expected 0", rate)
+	}
+}
+
+func TestParseSLOTargets(t *testing.T) {
+	targets, err := parseSLOTargets(methodFlag{"GetBlock": "99.5%/250ms"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if target := targets["GetBlock"]; target.success != 0.995 || target.latency != 250*time.Millisecond {
+		t.Errorf("parsed %+v", target)
+	}
+	for _, bad := range []string{"", "100", "0", "abc", "99/fast", "99/-1s"} {
+		if _, err := parseSLOTargets(methodFlag{"GetBlock": bad}); err == nil {
+			t.Errorf("accepted %q", bad)
+		}
+	}
+}
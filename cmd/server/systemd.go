@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/adityapk00/lightwalletd/common"
+)
+
+// listenFDsStart is the first file descriptor systemd passes sockets on.
+const listenFDsStart = 3
+
+// listenFDs returns how many sockets were passed to the process with the
+// given pid, as looked up with lookup: by an upgrade, in upgradeFDsEnv, or by
+// systemd, in LISTEN_FDS if LISTEN_PID is pid. It's zero if the process
+// wasn't passed any, or the sockets were meant for another one.
+func listenFDs(lookup func(string) (string, bool), pid int) (int, error) {
+	name := upgradeFDsEnv
+	value, ok := lookup(name)
+	if !ok {
+		listenPID, ok := lookup("LISTEN_PID")
+		if !ok || listenPID != strconv.Itoa(pid) {
+			return 0, nil
+		}
+		name = "LISTEN_FDS"
+		value, _ = lookup(name)
+	}
+	count, err := strconv.Atoi(value)
+	if err != nil || count < 0 {
+		return 0, fmt.Errorf("bad %s %q", name, value)
+	}
+	return count, nil
+}
+
+// inheritedListeners returns the listening sockets passed to the process by
+// systemd socket activation or by an upgrade, or none.
+func inheritedListeners() ([]net.Listener, error) {
+	count, err := listenFDs(os.LookupEnv, os.Getpid())
+	if err != nil {
+		return nil, err
+	}
+	// The sockets are ours, don't pass them on to child processes.
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	os.Unsetenv(upgradeFDsEnv)
+
+	listeners := make([]net.Listener, 0, count)
+	for fd := listenFDsStart; fd < listenFDsStart+count; fd++ {
+		// FileListener takes a copy of the descriptor, the original is
+		// closed so it isn't passed on to child processes.
+		f := os.NewFile(uintptr(fd), "LISTEN_FD_"+strconv.Itoa(fd))
+		listener, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("inherited socket %d: %v", fd, err)
+		}
+		listeners = append(listeners, listener)
+	}
+	return listeners, nil
+}
+
+// systemdNotifier sends service status updates to systemd over the socket
+// named by NOTIFY_SOCKET, for units of Type=notify. A nil systemdNotifier,
+// used when the server isn't run by systemd, sends nothing.
+type systemdNotifier struct {
+	addr *net.UnixAddr
+	log  *logrus.Entry
+}
+
+// newSystemdNotifier returns a notifier for the socket in NOTIFY_SOCKET, as
+// looked up with lookup, or nil if that's not set.
+func newSystemdNotifier(lookup func(string) (string, bool), log *logrus.Entry) *systemdNotifier {
+	return notifierFromEnv(lookup, "NOTIFY_SOCKET", log)
+}
+
+// notifierFromEnv returns a notifier for the socket named by the environment
+// variable env, as looked up with lookup, or nil if that's not set.
+func notifierFromEnv(lookup func(string) (string, bool), env string, log *logrus.Entry) *systemdNotifier {
+	name, ok := lookup(env)
+	if !ok || name == "" {
+		return nil
+	}
+	if name[0] == '@' {
+		// An abstract socket.
+		name = "\x00" + name[1:]
+	}
+	return &systemdNotifier{
+		addr: &net.UnixAddr{Name: name, Net: "unixgram"},
+		log:  log,
+	}
+}
+
+// notify sends state, such as "READY=1", to systemd.
+func (n *systemdNotifier) notify(state string) {
+	if n == nil {
+		return
+	}
+	conn, err := net.DialUnix(n.addr.Net, nil, n.addr)
+	if err == nil {
+		_, err = conn.Write([]byte(state))
+		conn.Close()
+	}
+	if err != nil {
+		n.log.WithFields(logrus.Fields{
+			"state": state,
+			"error": err,
+		}).Warn("couldn't notify systemd")
+	}
+}
+
+// notifyWhenReady sends READY=1 once the server is ready to serve, as
+// reported by ready, which is checked every interval.
+func (n *systemdNotifier) notifyWhenReady(ready func() bool, interval time.Duration) {
+	if n == nil {
+		return
+	}
+	for !ready() {
+		time.Sleep(interval)
+	}
+	n.log.Info("ready, notifying systemd")
+	n.notify("READY=1")
+}
+
+// runWatchdog sends WATCHDOG=1 at half the interval systemd asked for with
+// WATCHDOG_USEC, as looked up with lookup, if it did and the request is for
+// this process.
+func (n *systemdNotifier) runWatchdog(lookup func(string) (string, bool)) {
+	if n == nil {
+		return
+	}
+	interval := watchdogInterval(lookup, os.Getpid())
+	if interval <= 0 {
+		return
+	}
+	for range time.Tick(interval / 2) {
+		n.notify("WATCHDOG=1")
+	}
+}
+
+// watchdogInterval returns the watchdog timeout systemd set for the process
+// with the given pid, or zero if there's none.
+func watchdogInterval(lookup func(string) (string, bool), pid int) time.Duration {
+	if watchdogPID, ok := lookup("WATCHDOG_PID"); ok && watchdogPID != strconv.Itoa(pid) {
+		return 0
+	}
+	value, _ := lookup("WATCHDOG_USEC")
+	usec, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// serverReady reports whether the cache has ingested the blocks up to tip,
+// the node's height when the server started, and zcashd answers.
+func serverReady(rpcClient common.RPCClient, cache *common.BlockCache, tip int) bool {
+	if cache.GetLatestBlock() < tip {
+		return false
+	}
+	_, err := common.GetChainInfo(rpcClient)
+	return err == nil
+}
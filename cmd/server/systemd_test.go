@@ -0,0 +1,117 @@
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/adityapk00/lightwalletd/common"
+	"github.com/adityapk00/lightwalletd/walletrpc"
+)
+
+// envLookup looks variables up in env.
+func envLookup(env map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	}
+}
+
+func TestListenFDs(t *testing.T) {
+	for _, tt := range []struct {
+		env     map[string]string
+		want    int
+		wantErr bool
+	}{
+		{map[string]string{}, 0, false},
+		{map[string]string{"LISTEN_PID": "42", "LISTEN_FDS": "2"}, 2, false},
+		{map[string]string{"LISTEN_PID": "43", "LISTEN_FDS": "2"}, 0, false},
+		{map[string]string{"LISTEN_PID": "42", "LISTEN_FDS": "two"}, 0, true},
+		{map[string]string{"LISTEN_PID": "42"}, 0, true},
+		{map[string]string{upgradeFDsEnv: "1"}, 1, false},
+		{map[string]string{upgradeFDsEnv: "1", "LISTEN_PID": "42", "LISTEN_FDS": "2"}, 1, false},
+	} {
+		count, err := listenFDs(envLookup(tt.env), 42)
+		if count != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("%v: got %d, %v", tt.env, count, err)
+		}
+	}
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	for _, tt := range []struct {
+		env  map[string]string
+		want time.Duration
+	}{
+		{map[string]string{}, 0},
+		{map[string]string{"WATCHDOG_USEC": "30000000"}, 30 * time.Second},
+		{map[string]string{"WATCHDOG_USEC": "30000000", "WATCHDOG_PID": "42"}, 30 * time.Second},
+		{map[string]string{"WATCHDOG_USEC": "30000000", "WATCHDOG_PID": "43"}, 0},
+		{map[string]string{"WATCHDOG_USEC": "soon"}, 0},
+	} {
+		if got := watchdogInterval(envLookup(tt.env), 42); got != tt.want {
+			t.Errorf("%v: got %v, expected %v", tt.env, got, tt.want)
+		}
+	}
+}
+
+func TestSystemdNotifier(t *testing.T) {
+	if newSystemdNotifier(envLookup(map[string]string{}), log) != nil {
+		t.Fatal("expected no notifier outside systemd")
+	}
+	// A nil notifier does nothing.
+	var none *systemdNotifier
+	none.notify("READY=1")
+	none.notifyWhenReady(func() bool { return false }, time.Hour)
+
+	dir, err := ioutil.TempDir("", "lightwalletd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "notify")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	notifier := newSystemdNotifier(envLookup(map[string]string{"NOTIFY_SOCKET": path}), log)
+	checks := 0
+	go notifier.notifyWhenReady(func() bool {
+		checks++
+		return checks == 3
+	}, time.Millisecond)
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 64)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != "READY=1" {
+		t.Errorf("systemd got %q", buf[:n])
+	}
+}
+
+func TestServerReady(t *testing.T) {
+	cache := common.NewBlockCache(10, log)
+	node := chainNode(`{"chain": "main", "blocks": 101}`)
+	if serverReady(node, cache, 101) {
+		t.Error("ready with an empty cache")
+	}
+	for height := 100; height <= 101; height++ {
+		if err, _ := cache.Add(height, &walletrpc.CompactBlock{Height: uint64(height)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !serverReady(node, cache, 101) {
+		t.Error("not ready with the warm window cached")
+	}
+	if serverReady(chainNode(""), cache, 101) {
+		t.Error("ready while zcashd doesn't answer")
+	}
+}
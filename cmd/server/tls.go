@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/acme/autocert"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+// newTLSConfig builds the server's TLS configuration from a certificate and
+// key file. If allowedSNI is not empty, handshakes from clients that didn't
+// ask for one of those hostnames are refused.
+func newTLSConfig(certPath, keyPath string, allowedSNI []string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+	}
+	restrictSNI(config, allowedSNI)
+	return config, nil
+}
+
+// newACMEManager returns a manager that obtains certificates for domains
+// from Let's Encrypt, accepting its terms of service, and renews them before
+// they expire. Certificates and the account key are kept in cacheDir. email,
+// if set, is given to Let's Encrypt for expiry notices.
+func newACMEManager(domains []string, cacheDir, email string) *autocert.Manager {
+	return &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(domains...),
+		Cache:      autocert.DirCache(cacheDir),
+		Email:      email,
+	}
+}
+
+// restrictSNI makes config refuse handshakes from clients that didn't ask
+// for one of the hostnames in allowedSNI, if it's not empty.
+func restrictSNI(config *tls.Config, allowedSNI []string) {
+	if len(allowedSNI) > 0 {
+		allowed := make(map[string]bool, len(allowedSNI))
+		for _, name := range allowedSNI {
+			allowed[strings.ToLower(name)] = true
+		}
+		config.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			if !allowed[strings.ToLower(hello.ServerName)] {
+				return nil, fmt.Errorf("server name %q not allowed", hello.ServerName)
+			}
+			// Carry on with the base configuration.
+			return nil, nil
+		}
+	}
+}
+
+// requireClientCerts makes config refuse handshakes from clients that don't
+// present a certificate issued by one of the CAs in the PEM file caPath.
+func requireClientCerts(config *tls.Config, caPath string) error {
+	pool, err := loadCertPool(caPath)
+	if err != nil {
+		return err
+	}
+	config.ClientCAs = pool
+	config.ClientAuth = tls.RequireAndVerifyClientCert
+	return nil
+}
+
+// loadCertPool reads the PEM certificates in path.
+func loadCertPool(path string) (*x509.CertPool, error) {
+	pem, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.New("no PEM certificates found")
+	}
+	return pool, nil
+}
+
+// clientCertFields returns the log fields naming the client of the call in
+// ctx by its verified certificate, if it presented one: its common name and
+// its subject alternative names.
+func clientCertFields(ctx context.Context) logrus.Fields {
+	peerInfo, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+	tlsInfo, ok := peerInfo.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(tlsInfo.State.VerifiedChains) == 0 {
+		return nil
+	}
+	cert := tlsInfo.State.VerifiedChains[0][0]
+	fields := logrus.Fields{}
+	if cert.Subject.CommonName != "" {
+		fields["client_cn"] = cert.Subject.CommonName
+	}
+	var sans []string
+	sans = append(sans, cert.DNSNames...)
+	sans = append(sans, cert.EmailAddresses...)
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	for _, uri := range cert.URIs {
+		sans = append(sans, uri.String())
+	}
+	if len(sans) > 0 {
+		fields["client_san"] = strings.Join(sans, ",")
+	}
+	return fields
+}
+
+// tlsVersions are the names -tls-min-version takes.
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// tlsCurves are the names -tls-curves takes.
+var tlsCurves = map[string]tls.CurveID{
+	"X25519": tls.X25519,
+	"P256":   tls.CurveP256,
+	"P384":   tls.CurveP384,
+	"P521":   tls.CurveP521,
+}
+
+// applyTLSPolicy sets the minimum TLS version config accepts, the cipher
+// suites it offers for TLS 1.2 and earlier, by their Go names, and the
+// elliptic curves it prefers, in order. Empty lists keep Go's defaults.
+// TLS 1.3 suites can't be chosen, so suites are refused with a minimum of
+// 1.3, where they would be ignored.
+func applyTLSPolicy(config *tls.Config, minVersion string, cipherSuites, curves []string) error {
+	version, ok := tlsVersions[minVersion]
+	if !ok {
+		return fmt.Errorf("unknown TLS version %q", minVersion)
+	}
+	if version == tls.VersionTLS13 && len(cipherSuites) > 0 {
+		return errors.New("cipher suites can't be chosen for TLS 1.3")
+	}
+	config.MinVersion = version
+
+	config.CipherSuites = nil
+	for _, name := range cipherSuites {
+		id, ok := secureCipherSuite(name)
+		if !ok {
+			return fmt.Errorf("unknown or insecure cipher suite %q", name)
+		}
+		config.CipherSuites = append(config.CipherSuites, id)
+	}
+	config.CurvePreferences = nil
+	for _, name := range curves {
+		curve, ok := tlsCurves[name]
+		if !ok {
+			return fmt.Errorf("unknown curve %q", name)
+		}
+		config.CurvePreferences = append(config.CurvePreferences, curve)
+	}
+	return nil
+}
+
+func secureCipherSuite(name string) (uint16, bool) {
+	for _, suite := range tls.CipherSuites() {
+		if suite.Name == name {
+			return suite.ID, true
+		}
+	}
+	return 0, false
+}
+
+// tlsPolicyFields describes the policy applyTLSPolicy set on config, for the
+// log.
+func tlsPolicyFields(config *tls.Config) logrus.Fields {
+	fields := logrus.Fields{
+		"min_version":   "default",
+		"cipher_suites": "default",
+		"curves":        "default",
+	}
+	for name, version := range tlsVersions {
+		if version == config.MinVersion {
+			fields["min_version"] = name
+		}
+	}
+	if len(config.CipherSuites) > 0 {
+		names := make([]string, len(config.CipherSuites))
+		for i, id := range config.CipherSuites {
+			names[i] = tls.CipherSuiteName(id)
+		}
+		fields["cipher_suites"] = strings.Join(names, ",")
+	}
+	if len(config.CurvePreferences) > 0 {
+		names := make([]string, len(config.CurvePreferences))
+		for i, curve := range config.CurvePreferences {
+			for name, id := range tlsCurves {
+				if id == curve {
+					names[i] = name
+				}
+			}
+		}
+		fields["curves"] = strings.Join(names, ",")
+	}
+	return fields
+}
+
+// splitList splits a comma-separated flag value, dropping empty entries.
+func splitList(s string) []string {
+	var list []string
+	for _, field := range strings.Split(s, ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			list = append(list, field)
+		}
+	}
+	return list
+}
+
+// unixPlaintextCreds are TLS credentials that leave connections accepted on
+// a Unix socket unencrypted: those never leave the host, and come from a
+// co-located proxy that has terminated TLS already.
+type unixPlaintextCreds struct {
+	credentials.TransportCredentials
+}
+
+// unixAuthInfo is the AuthInfo of a connection on a Unix socket.
+type unixAuthInfo struct{}
+
+func (unixAuthInfo) AuthType() string {
+	return "unix"
+}
+
+func (c unixPlaintextCreds) ServerHandshake(conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	if conn.LocalAddr().Network() == "unix" {
+		return conn, unixAuthInfo{}, nil
+	}
+	return c.TransportCredentials.ServerHandshake(conn)
+}
+
+func (c unixPlaintextCreds) Clone() credentials.TransportCredentials {
+	return unixPlaintextCreds{c.TransportCredentials.Clone()}
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+// writeTestCert writes a self-signed certificate for names, and its key, to
+// dir.
+func writeTestCert(t *testing.T, dir string, names ...string) (certPath, keyPath string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: names[0]},
+		DNSNames:     names,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certPath = filepath.Join(dir, "cert.pem")
+	keyPath = filepath.Join(dir, "key.pem")
+	if err := ioutil.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certPath, keyPath
+}
+
+// handshake runs a TLS handshake against config, asking for serverName.
+func handshake(config *tls.Config, serverName string) error {
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+
+	go func() {
+		server := tls.Server(serverConn, config)
+		server.Handshake()
+		server.Close()
+	}()
+
+	client := tls.Client(clientConn, &tls.Config{ServerName: serverName, InsecureSkipVerify: true})
+	return client.Handshake()
+}
+
+func TestTLSAllowedSNI(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lightwalletd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certPath, keyPath := writeTestCert(t, dir, "lightwalletd.example.com")
+
+	config, err := newTLSConfig(certPath, keyPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := handshake(config, "anything.example.org"); err != nil {
+		t.Errorf("any SNI should be accepted by default: %v", err)
+	}
+
+	config, err = newTLSConfig(certPath, keyPath, splitList("lightwalletd.example.com, mirror.example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		serverName string
+		allowed    bool
+	}{
+		{"lightwalletd.example.com", true},
+		{"LightwalletD.example.com", true},
+		{"mirror.example.com", true},
+		{"other.example.com", false},
+		{"", false},
+	} {
+		err := handshake(config, tt.serverName)
+		if tt.allowed && err != nil {
+			t.Errorf("%q: unexpected handshake error %v", tt.serverName, err)
+		}
+		if !tt.allowed && err == nil {
+			t.Errorf("%q: handshake should have failed", tt.serverName)
+		}
+	}
+}
+
+func TestACMEManager(t *testing.T) {
+	manager := newACMEManager(splitList("lwd.example.com,mirror.example.com"), "acme-cache", "ops@example.com")
+	for host, allowed := range map[string]bool{
+		"lwd.example.com":    true,
+		"mirror.example.com": true,
+		"other.example.com":  false,
+	} {
+		err := manager.HostPolicy(context.Background(), host)
+		if allowed != (err == nil) {
+			t.Errorf("%s: unexpected host policy result %v", host, err)
+		}
+	}
+	if manager.Cache != autocert.DirCache("acme-cache") || manager.Email != "ops@example.com" {
+		t.Errorf("manager not configured: %v %q", manager.Cache, manager.Email)
+	}
+
+	// The shared port keeps offering the challenge protocol.
+	config := manager.TLSConfig()
+	restrictSNI(config, []string{"lwd.example.com"})
+	found := false
+	for _, proto := range config.NextProtos {
+		found = found || proto == acme.ALPNProto
+	}
+	if !found || config.GetConfigForClient == nil {
+		t.Errorf("unexpected TLS config: %v", config.NextProtos)
+	}
+}
+
+// newTestClientCert returns a certificate for name, issued by ca, or a
+// self-signed one that can issue others if ca is nil.
+func newTestClientCert(t *testing.T, name string, ca *tls.Certificate) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name + ".example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	parent, parentKey := template, interface{}(key)
+	if ca != nil {
+		parent, parentKey = ca.Leaf, ca.PrivateKey
+	} else {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+// clientHandshake runs a TLS handshake against config, presenting
+// clientCert if set, and returns the server's side of the connection.
+func clientHandshake(config *tls.Config, clientCert *tls.Certificate) (tls.ConnectionState, error) {
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+
+	go func() {
+		clientConfig := &tls.Config{InsecureSkipVerify: true}
+		if clientCert != nil {
+			clientConfig.Certificates = []tls.Certificate{*clientCert}
+		}
+		client := tls.Client(clientConn, clientConfig)
+		client.Handshake()
+		// Wait for the server to close the connection.
+		client.Read(make([]byte, 1))
+	}()
+
+	server := tls.Server(serverConn, config)
+	defer server.Close()
+	err := server.Handshake()
+	return server.ConnectionState(), err
+}
+
+func TestTLSClientCA(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lightwalletd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certPath, keyPath := writeTestCert(t, dir, "lightwalletd.example.com")
+
+	ca := newTestClientCert(t, "test CA", nil)
+	caPath := filepath.Join(dir, "ca.pem")
+	if err := ioutil.WriteFile(caPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Certificate[0]}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	config, err := newTLSConfig(certPath, keyPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := requireClientCerts(config, caPath); err != nil {
+		t.Fatal(err)
+	}
+	if err := requireClientCerts(config, certPath+".missing"); err == nil {
+		t.Error("expected a missing CA file to be refused")
+	}
+
+	if _, err := clientHandshake(config, nil); err == nil {
+		t.Error("a client without a certificate was accepted")
+	}
+	stranger := newTestClientCert(t, "stranger", nil)
+	if _, err := clientHandshake(config, &stranger); err == nil {
+		t.Error("a client with a certificate from another CA was accepted")
+	}
+	wallet := newTestClientCert(t, "wallet-1", &ca)
+	state, err := clientHandshake(config, &wallet)
+	if err != nil {
+		t.Fatalf("a client with a certificate from the CA was refused: %v", err)
+	}
+
+	ctx := peer.NewContext(context.Background(), &peer.Peer{AuthInfo: credentials.TLSInfo{State: state}})
+	fields := clientCertFields(ctx)
+	if fields["client_cn"] != "wallet-1" || fields["client_san"] != "wallet-1.example.com" {
+		t.Errorf("logged the client as %v", fields)
+	}
+	if fields := clientCertFields(context.Background()); len(fields) != 0 {
+		t.Errorf("logged %v for a call without a peer", fields)
+	}
+}
+
+func TestApplyTLSPolicy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lightwalletd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certPath, keyPath := writeTestCert(t, dir, "lightwalletd.example.com")
+	config, err := newTLSConfig(certPath, keyPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		minVersion   string
+		cipherSuites []string
+		curves       []string
+	}{
+		{"1.4", nil, nil},
+		{"1.2", []string{"TLS_RSA_WITH_RC4_128_SHA"}, nil},
+		{"1.2", []string{"TLS_NOT_A_SUITE"}, nil},
+		{"1.3", []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"}, nil},
+		{"1.2", nil, []string{"P224"}},
+	} {
+		if err := applyTLSPolicy(config, tt.minVersion, tt.cipherSuites, tt.curves); err == nil {
+			t.Errorf("%v: expected the policy to be refused", tt)
+		}
+	}
+
+	if err := applyTLSPolicy(config, "1.3", nil, []string{"X25519", "P256"}); err != nil {
+		t.Fatal(err)
+	}
+	fields := tlsPolicyFields(config)
+	if fields["min_version"] != "1.3" || fields["cipher_suites"] != "default" || fields["curves"] != "X25519,P256" {
+		t.Errorf("policy logged as %v", fields)
+	}
+	for version, wantErr := range map[uint16]bool{tls.VersionTLS12: true, tls.VersionTLS13: false} {
+		serverConn, clientConn := net.Pipe()
+		go func() {
+			server := tls.Server(serverConn, config)
+			server.Handshake()
+			server.Close()
+		}()
+		client := tls.Client(clientConn, &tls.Config{InsecureSkipVerify: true, MaxVersion: version})
+		if err := client.Handshake(); (err != nil) != wantErr {
+			t.Errorf("handshake with a client up to %x: %v", version, err)
+		}
+		clientConn.Close()
+	}
+
+	if err := applyTLSPolicy(config, "1.2", []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if fields := tlsPolicyFields(config); fields["cipher_suites"] != "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256" || fields["curves"] != "default" {
+		t.Errorf("policy logged as %v", fields)
+	}
+}
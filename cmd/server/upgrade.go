@@ -0,0 +1,168 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// upgradeFDsEnv tells a process started by an upgrade how many
+	// listening sockets it was passed, from descriptor 3 on.
+	upgradeFDsEnv = "LIGHTWALLETD_UPGRADE_FDS"
+	// upgradeNotifyEnv names the socket a process started by an upgrade
+	// sends READY=1 to once it's ready to serve.
+	upgradeNotifyEnv = "LIGHTWALLETD_UPGRADE_NOTIFY"
+)
+
+// upgradeEnvDrop are the environment variables not passed on to the new
+// process: they're set for it, or were meant for this one only.
+var upgradeEnvDrop = []string{
+	"LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES", "WATCHDOG_PID",
+	upgradeFDsEnv, upgradeNotifyEnv,
+}
+
+// upgrader hands the gRPC listening sockets over to a new process running
+// the executable, usually a newer build of this one, with the same
+// arguments. Once the new process is ready to serve, sharing the sockets
+// meanwhile, this one stops accepting connections and drains the calls in
+// progress, such as long GetBlockRange streams, before exiting.
+type upgrader struct {
+	executable string
+	args       []string
+	listeners  []net.Listener
+	timeout    time.Duration
+	notifier   *systemdNotifier
+	log        *logrus.Entry
+}
+
+// upgrade starts the new process and waits until it's ready. An error
+// means the new process didn't get ready, and has been stopped; this one
+// carries on serving.
+func (u *upgrader) upgrade() error {
+	files := make([]*os.File, len(u.listeners))
+	for i, listener := range u.listeners {
+		filer, ok := listener.(interface{ File() (*os.File, error) })
+		if !ok {
+			return fmt.Errorf("can't pass on a listener on %s", listener.Addr())
+		}
+		f, err := filer.File()
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		files[i] = f
+	}
+
+	dir, err := ioutil.TempDir("", "lightwalletd-upgrade")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	notifyPath := filepath.Join(dir, "notify")
+	notify, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: notifyPath, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer notify.Close()
+
+	cmd := exec.Command(u.executable, u.args...)
+	cmd.Env = append(upgradeEnv(os.Environ()),
+		fmt.Sprintf("%s=%d", upgradeFDsEnv, len(files)),
+		upgradeNotifyEnv+"="+notifyPath)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	cmd.ExtraFiles = files
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	u.log.WithFields(logrus.Fields{
+		"pid": cmd.Process.Pid,
+	}).Info("started new process, waiting for it to get ready")
+
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+	ready := make(chan error, 1)
+	go func() {
+		buf := make([]byte, 4096)
+		for {
+			n, err := notify.Read(buf)
+			if err != nil {
+				ready <- err
+				return
+			}
+			for _, line := range strings.Split(string(buf[:n]), "\n") {
+				if line == "READY=1" {
+					ready <- nil
+					return
+				}
+			}
+		}
+	}()
+
+	select {
+	case err := <-ready:
+		if err != nil {
+			cmd.Process.Kill()
+			return err
+		}
+	case err := <-exited:
+		return fmt.Errorf("new process exited: %v", err)
+	case <-time.After(u.timeout):
+		cmd.Process.Kill()
+		return errors.New("new process didn't get ready in time")
+	}
+
+	// The new process serves on the Unix sockets too, they mustn't be
+	// removed when this one closes them.
+	for _, listener := range u.listeners {
+		if unixListener, ok := listener.(*net.UnixListener); ok {
+			unixListener.SetUnlinkOnClose(false)
+		}
+	}
+	// Under systemd, the new process is the service's main process now.
+	u.notifier.notify(fmt.Sprintf("MAINPID=%d", cmd.Process.Pid))
+	u.log.WithFields(logrus.Fields{
+		"pid": cmd.Process.Pid,
+	}).Info("new process ready")
+	return nil
+}
+
+// upgradeEnv returns env without the variables in upgradeEnvDrop.
+func upgradeEnv(env []string) []string {
+	var kept []string
+	for _, variable := range env {
+		drop := false
+		for _, name := range upgradeEnvDrop {
+			if strings.HasPrefix(variable, name+"=") {
+				drop = true
+				break
+			}
+		}
+		if !drop {
+			kept = append(kept, variable)
+		}
+	}
+	return kept
+}
+
+// listenTCP listens on addr. A process started by an upgrade retries while
+// the old one, still draining, holds addr.
+func listenTCP(addr string, upgrading bool) (net.Listener, error) {
+	for {
+		listener, err := net.Listen("tcp", addr)
+		if err == nil || !upgrading {
+			return listener, err
+		}
+		time.Sleep(time.Second)
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// notifyUpgradeSignal relays SIGUSR2, which asks for an upgrade, to c.
+func notifyUpgradeSignal(c chan<- os.Signal) {
+	signal.Notify(c, syscall.SIGUSR2)
+}
@@ -0,0 +1,7 @@
+package main
+
+import "os"
+
+// notifyUpgradeSignal does nothing: upgrades aren't supported on Windows.
+func notifyUpgradeSignal(c chan<- os.Signal) {
+}
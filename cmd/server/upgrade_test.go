@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// upgradeHelperEnv makes TestUpgradeHelper act as the new process of an
+// upgrade: "serve" to get ready and answer one connection, "fail" to exit
+// without getting ready.
+const upgradeHelperEnv = "LWD_TEST_UPGRADE_HELPER"
+
+func TestUpgradeHelper(t *testing.T) {
+	switch os.Getenv(upgradeHelperEnv) {
+	case "serve":
+		listeners, err := inheritedListeners()
+		if err != nil || len(listeners) != 1 {
+			os.Exit(2)
+		}
+		notifierFromEnv(os.LookupEnv, upgradeNotifyEnv, log).notify("READY=1")
+		conn, err := listeners[0].Accept()
+		if err != nil {
+			os.Exit(2)
+		}
+		conn.Write([]byte("new\n"))
+		conn.Close()
+		os.Exit(0)
+	case "fail":
+		os.Exit(1)
+	}
+}
+
+func TestUpgrade(t *testing.T) {
+	defer os.Unsetenv(upgradeHelperEnv)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	u := &upgrader{
+		executable: os.Args[0],
+		args:       []string{"-test.run=^TestUpgradeHelper$"},
+		listeners:  []net.Listener{listener},
+		timeout:    10 * time.Second,
+		log:        log,
+	}
+
+	os.Setenv(upgradeHelperEnv, "fail")
+	if err := u.upgrade(); err == nil {
+		t.Error("expected the upgrade to fail when the new process exits")
+	}
+
+	os.Setenv(upgradeHelperEnv, "serve")
+	if err := u.upgrade(); err != nil {
+		t.Fatal(err)
+	}
+	// The new process answers on the socket once this one stops accepting.
+	listener.Close()
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil || line != "new\n" {
+		t.Errorf("expected the new process to answer, got %q, %v", line, err)
+	}
+}
+
+func TestUpgradeEnv(t *testing.T) {
+	env := upgradeEnv([]string{
+		"PATH=/usr/bin",
+		"LISTEN_PID=42",
+		"LISTEN_FDS=1",
+		"NOTIFY_SOCKET=/run/systemd/notify",
+		"WATCHDOG_PID=42",
+		"WATCHDOG_USEC=30000000",
+		upgradeFDsEnv + "=1",
+	})
+	want := []string{"PATH=/usr/bin", "NOTIFY_SOCKET=/run/systemd/notify", "WATCHDOG_USEC=30000000"}
+	if !reflect.DeepEqual(env, want) {
+		t.Errorf("got %v, expected %v", env, want)
+	}
+}
@@ -0,0 +1,600 @@
+package common
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adityapk00/lightwalletd/parser"
+	"github.com/btcsuite/btcd/btcjson"
+	_ "github.com/mattn/go-sqlite3" // the sqlite3 database/sql driver
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// AddressIndex maps transparent addresses to the transactions and outputs
+// that pay or spend them, for serving the t-address RPCs from a node
+// without -insightexplorer. It is kept in an SQLite database, fed the
+// blocks the BlockIngestor caches and caught up with the node by Sync, from
+// genesis on the first run.
+//
+// All methods may be called on a nil *AddressIndex, which indexes nothing.
+type AddressIndex struct {
+	db       *sql.DB
+	prefixes addressPrefixes
+
+	// The last block indexed, kept to check that the next one follows it.
+	mutex   sync.Mutex
+	tip     int
+	tipHash []byte
+}
+
+// addressIndexVersion is the version of addressIndexSchema, to be raised
+// with any change to it.
+const addressIndexVersion = 1
+
+const addressIndexSchema = `
+CREATE TABLE IF NOT EXISTS blocks (
+	height INTEGER PRIMARY KEY,
+	hash BLOB NOT NULL,
+	time INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS outputs (
+	txid TEXT NOT NULL,
+	idx INTEGER NOT NULL,
+	address TEXT NOT NULL,
+	script BLOB NOT NULL,
+	value INTEGER NOT NULL,
+	height INTEGER NOT NULL,
+	spent_height INTEGER,
+	PRIMARY KEY (txid, idx)
+);
+CREATE INDEX IF NOT EXISTS outputs_address ON outputs (address, spent_height);
+CREATE INDEX IF NOT EXISTS outputs_height ON outputs (height);
+CREATE INDEX IF NOT EXISTS outputs_spent_height ON outputs (spent_height);
+CREATE TABLE IF NOT EXISTS address_txids (
+	address TEXT NOT NULL,
+	height INTEGER NOT NULL,
+	position INTEGER NOT NULL,
+	txid TEXT NOT NULL,
+	PRIMARY KEY (address, height, position)
+);
+CREATE INDEX IF NOT EXISTS address_txids_height ON address_txids (height);
+`
+
+// openSchema creates the tables of schema, of the given version, in db,
+// recording the version as SQLite's user_version. There are no migrations:
+// a database of an older version is emptied and created again, and one of
+// a newer version, written by a later lightwalletd, is refused. Databases
+// from before the version was recorded have version 1's tables.
+func openSchema(db *sql.DB, schema string, version int) error {
+	var current int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&current); err != nil {
+		return err
+	}
+	if current == 0 {
+		var tables int
+		if err := db.QueryRow("SELECT count(*) FROM sqlite_master WHERE type = 'table'").Scan(&tables); err != nil {
+			return err
+		}
+		if tables > 0 {
+			current = 1
+		}
+	}
+	if current > version {
+		return errors.Errorf("database schema version %d is newer than this lightwalletd's, %d", current, version)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if current != 0 && current < version {
+		rows, err := tx.Query("SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%'")
+		if err != nil {
+			return err
+		}
+		var tables []string
+		for rows.Next() {
+			var table string
+			if err := rows.Scan(&table); err != nil {
+				rows.Close()
+				return err
+			}
+			tables = append(tables, table)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		for _, table := range tables {
+			if _, err := tx.Exec(fmt.Sprintf("DROP TABLE %q", table)); err != nil {
+				return err
+			}
+		}
+	}
+	if _, err := tx.Exec(schema); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", version)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// NewAddressIndex opens the index kept in the SQLite database at path,
+// creating it if needed, or again from scratch if it was made for an older
+// schema. chainName, as getblockchaininfo reports it, picks
+// the address encoding.
+func NewAddressIndex(path string, chainName string) (*AddressIndex, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, errors.Wrap(err, "error opening address index")
+	}
+	// SQLite allows a single writer; one connection also keeps an
+	// in-memory database from being opened once per connection.
+	db.SetMaxOpenConns(1)
+	if err := openSchema(db, addressIndexSchema, addressIndexVersion); err != nil {
+		db.Close()
+		return nil, errors.Wrap(err, "error creating address index")
+	}
+
+	idx := &AddressIndex{db: db, prefixes: chainAddressPrefixes(chainName), tip: -1}
+	err = db.QueryRow("SELECT height, hash FROM blocks ORDER BY height DESC LIMIT 1").Scan(&idx.tip, &idx.tipHash)
+	if err != nil && err != sql.ErrNoRows {
+		db.Close()
+		return nil, errors.Wrap(err, "error reading address index")
+	}
+	return idx, nil
+}
+
+// Close closes the database.
+func (idx *AddressIndex) Close() error {
+	if idx == nil {
+		return nil
+	}
+	return idx.db.Close()
+}
+
+// Tip returns the height of the last block indexed, or -1 if there is none.
+func (idx *AddressIndex) Tip() int {
+	if idx == nil {
+		return -1
+	}
+	idx.mutex.Lock()
+	defer idx.mutex.Unlock()
+	return idx.tip
+}
+
+// Add indexes block if it's the one after the tip. Other blocks are
+// ignored, and left to Sync: earlier ones are already indexed, later ones
+// leave a gap. If block doesn't follow the tip, there was a reorg, and the
+// tip is removed instead so that Sync can index the blocks that replace it.
+func (idx *AddressIndex) Add(block *parser.Block) error {
+	if idx == nil {
+		return nil
+	}
+	idx.mutex.Lock()
+	defer idx.mutex.Unlock()
+
+	height := block.GetHeight()
+	if height != idx.tip+1 {
+		return nil
+	}
+	if idx.tip >= 0 && !bytes.Equal(block.GetPrevHash(), idx.tipHash) {
+		return idx.removeAbove(idx.tip - 1)
+	}
+
+	tx, err := idx.db.Begin()
+	if err != nil {
+		return errors.Wrap(err, "error indexing block")
+	}
+	if err := idx.addBlock(tx, height, block); err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "error indexing block")
+	}
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "error indexing block")
+	}
+	idx.tip, idx.tipHash = height, block.GetEncodableHash()
+	return nil
+}
+
+func (idx *AddressIndex) addBlock(tx *sql.Tx, height int, block *parser.Block) error {
+	if _, err := tx.Exec("INSERT INTO blocks (height, hash, time) VALUES (?, ?, ?)", height, block.GetEncodableHash(), block.GetTime()); err != nil {
+		return err
+	}
+	for position, transaction := range block.Transactions() {
+		txid := hex.EncodeToString(transaction.GetDisplayHash())
+		addresses := make(map[string]bool)
+
+		for _, prevout := range transaction.PrevOutpoints() {
+			prevTxid := make([]byte, len(prevout.TxHash))
+			copy(prevTxid, prevout.TxHash)
+			reverseBytes(prevTxid)
+
+			var address string
+			err := tx.QueryRow("SELECT address FROM outputs WHERE txid = ? AND idx = ?",
+				hex.EncodeToString(prevTxid), prevout.Index).Scan(&address)
+			if err == sql.ErrNoRows {
+				// A coinbase input, or an output without an address.
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if _, err := tx.Exec("UPDATE outputs SET spent_height = ? WHERE txid = ? AND idx = ?",
+				height, hex.EncodeToString(prevTxid), prevout.Index); err != nil {
+				return err
+			}
+			addresses[address] = true
+		}
+
+		for i, output := range transaction.TransparentOutputs() {
+			address := idx.prefixes.scriptAddress(output.Script)
+			if address == "" {
+				continue
+			}
+			if _, err := tx.Exec("INSERT INTO outputs (txid, idx, address, script, value, height) VALUES (?, ?, ?, ?, ?, ?)",
+				txid, i, address, output.Script, int64(output.Value), height); err != nil {
+				return err
+			}
+			addresses[address] = true
+		}
+
+		for address := range addresses {
+			if _, err := tx.Exec("INSERT INTO address_txids (address, height, position, txid) VALUES (?, ?, ?, ?)",
+				address, height, position, txid); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// removeAbove forgets the blocks above height.
+func (idx *AddressIndex) removeAbove(height int) error {
+	tx, err := idx.db.Begin()
+	if err != nil {
+		return errors.Wrap(err, "error unwinding address index")
+	}
+	for _, statement := range []string{
+		"DELETE FROM outputs WHERE height > ?",
+		"UPDATE outputs SET spent_height = NULL WHERE spent_height > ?",
+		"DELETE FROM address_txids WHERE height > ?",
+		"DELETE FROM blocks WHERE height > ?",
+	} {
+		if _, err := tx.Exec(statement, height); err != nil {
+			tx.Rollback()
+			return errors.Wrap(err, "error unwinding address index")
+		}
+	}
+	var hash []byte
+	err = tx.QueryRow("SELECT hash FROM blocks WHERE height = ?", height).Scan(&hash)
+	if err != nil && err != sql.ErrNoRows {
+		tx.Rollback()
+		return errors.Wrap(err, "error unwinding address index")
+	}
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "error unwinding address index")
+	}
+	idx.tip, idx.tipHash = height, hash
+	if hash == nil {
+		idx.tip = -1
+	}
+	return nil
+}
+
+// CatchUp indexes the blocks the node has past the tip, and returns the new
+// tip once the node has no more.
+func (idx *AddressIndex) CatchUp(rpcClient RPCClient) (int, error) {
+	if idx == nil {
+		return -1, nil
+	}
+	for {
+		height := idx.Tip() + 1
+		block, err := getParsedBlockFromRPC(rpcClient, height)
+		if err != nil {
+			return height - 1, err
+		}
+		if block == nil {
+			return height - 1, nil
+		}
+		if block.GetHeight() != height {
+			return height - 1, errors.Errorf("block %d has height %d in its coinbase", height, block.GetHeight())
+		}
+		if err := idx.Add(block); err != nil {
+			return height - 1, err
+		}
+	}
+}
+
+// Sync catches the index up with the node every interval, logging once it
+// first has.
+func (idx *AddressIndex) Sync(rpcClient RPCClient, log *logrus.Entry, interval time.Duration) {
+	started := time.Now()
+	logMilestone(log, "address-index-started", logrus.Fields{
+		"height": idx.Tip(),
+	})
+	caughtUp := false
+	for {
+		height, err := idx.CatchUp(rpcClient)
+		if err != nil {
+			log.WithFields(logrus.Fields{
+				"height": height + 1,
+				"error":  err,
+			}).Warn("error indexing addresses")
+		} else if !caughtUp {
+			caughtUp = true
+			logMilestone(log, "address-index-caught-up", logrus.Fields{
+				"height":  height,
+				"elapsed": time.Since(started),
+			})
+		}
+		time.Sleep(interval)
+	}
+}
+
+// Client returns an RPCClient that answers getaddresstxids, getaddressutxos,
+// getaddressbalance and getaddressmempool from the index, the way a node
+// with -insightexplorer would, and passes every other call on to node. The
+// index doesn't see the mempool, so getaddressmempool lists nothing.
+func (idx *AddressIndex) Client(node RPCClient) RPCClient {
+	if idx == nil {
+		return node
+	}
+	return &addressIndexClient{index: idx, node: node}
+}
+
+type addressIndexClient struct {
+	index *AddressIndex
+	node  RPCClient
+}
+
+func (c *addressIndexClient) RawRequest(method string, params []json.RawMessage) (json.RawMessage, error) {
+	var answer func(addressQuery) (interface{}, error)
+	switch method {
+	case "getaddresstxids":
+		answer = c.index.txids
+	case "getaddressutxos":
+		answer = c.index.utxos
+	case "getaddressbalance":
+		answer = c.index.balance
+	case "getaddressmempool":
+		answer = func(addressQuery) (interface{}, error) { return []struct{}{}, nil }
+	default:
+		return c.node.RawRequest(method, params)
+	}
+
+	var query addressQuery
+	if len(params) != 1 || json.Unmarshal(params[0], &query) != nil || len(query.Addresses) == 0 {
+		return nil, &btcjson.RPCError{Code: -5, Message: "Invalid address"}
+	}
+	result, err := answer(query)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(result)
+}
+
+// BatchRequest keeps batching the calls passed on to the node.
+func (c *addressIndexClient) BatchRequest(method string, params [][]json.RawMessage) ([]json.RawMessage, []error, error) {
+	switch method {
+	case "getaddresstxids", "getaddressutxos", "getaddressbalance", "getaddressmempool":
+		results := make([]json.RawMessage, len(params))
+		errs := make([]error, len(params))
+		for i := range params {
+			results[i], errs[i] = c.RawRequest(method, params[i])
+		}
+		return results, errs, nil
+	}
+	return BatchRequest(c.node, method, params)
+}
+
+// addressQuery is the argument of the insightexplorer RPCs.
+type addressQuery struct {
+	Addresses []string
+	Start     int
+	End       int
+}
+
+// placeholders returns the SQL parameters matching the addresses.
+func (q addressQuery) placeholders() (string, []interface{}) {
+	args := make([]interface{}, len(q.Addresses))
+	for i, address := range q.Addresses {
+		args[i] = address
+	}
+	return strings.TrimSuffix(strings.Repeat("?,", len(args)), ","), args
+}
+
+func (idx *AddressIndex) txids(q addressQuery) (interface{}, error) {
+	start, end := q.Start, q.End
+	tip := idx.Tip()
+	if start == 0 && end == 0 {
+		end = tip
+	}
+	if end > tip {
+		// Like a node asked past its tip.
+		return nil, &btcjson.RPCError{Code: -8, Message: "End height is beyond the address index"}
+	}
+
+	in, args := q.placeholders()
+	rows, err := idx.db.Query("SELECT txid FROM address_txids WHERE address IN ("+in+") AND height BETWEEN ? AND ? ORDER BY height, position",
+		append(args, start, end)...)
+	if err != nil {
+		return nil, errors.Wrap(err, "error querying address index")
+	}
+	defer rows.Close()
+
+	txids := []string{}
+	for rows.Next() {
+		var txid string
+		if err := rows.Scan(&txid); err != nil {
+			return nil, errors.Wrap(err, "error querying address index")
+		}
+		// A transaction involving several of the addresses is listed once.
+		if len(txids) == 0 || txids[len(txids)-1] != txid {
+			txids = append(txids, txid)
+		}
+	}
+	return txids, errors.Wrap(rows.Err(), "error querying address index")
+}
+
+type addressUtxo struct {
+	Address     string `json:"address"`
+	Txid        string `json:"txid"`
+	OutputIndex int    `json:"outputIndex"`
+	Script      string `json:"script"`
+	Satoshis    int64  `json:"satoshis"`
+	Height      int    `json:"height"`
+}
+
+func (idx *AddressIndex) utxos(q addressQuery) (interface{}, error) {
+	in, args := q.placeholders()
+	rows, err := idx.db.Query("SELECT address, txid, idx, script, value, height FROM outputs WHERE address IN ("+in+") AND spent_height IS NULL ORDER BY address, height, txid, idx",
+		args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "error querying address index")
+	}
+	defer rows.Close()
+
+	utxos := []addressUtxo{}
+	for rows.Next() {
+		var utxo addressUtxo
+		var script []byte
+		if err := rows.Scan(&utxo.Address, &utxo.Txid, &utxo.OutputIndex, &script, &utxo.Satoshis, &utxo.Height); err != nil {
+			return nil, errors.Wrap(err, "error querying address index")
+		}
+		utxo.Script = hex.EncodeToString(script)
+		utxos = append(utxos, utxo)
+	}
+	return utxos, errors.Wrap(rows.Err(), "error querying address index")
+}
+
+func (idx *AddressIndex) balance(q addressQuery) (interface{}, error) {
+	in, args := q.placeholders()
+	var balance struct {
+		Balance  int64 `json:"balance"`
+		Received int64 `json:"received"`
+	}
+	err := idx.db.QueryRow("SELECT COALESCE(SUM(CASE WHEN spent_height IS NULL THEN value ELSE 0 END), 0), COALESCE(SUM(value), 0) FROM outputs WHERE address IN ("+in+")",
+		args...).Scan(&balance.Balance, &balance.Received)
+	if err != nil {
+		return nil, errors.Wrap(err, "error querying address index")
+	}
+	return balance, nil
+}
+
+// BalanceDelta is the change in the balance of an address in a block.
+type BalanceDelta struct {
+	Height int
+	Time   uint32
+	Delta  int64
+}
+
+// BalanceHistory returns the changes in the balance of address, for each
+// block that changed it, oldest first.
+func (idx *AddressIndex) BalanceHistory(address string) ([]BalanceDelta, error) {
+	if idx == nil {
+		return nil, nil
+	}
+	rows, err := idx.db.Query(`
+		SELECT changes.height, blocks.time, SUM(changes.value) FROM (
+			SELECT height, value FROM outputs WHERE address = ?
+			UNION ALL
+			SELECT spent_height, -value FROM outputs WHERE address = ? AND spent_height IS NOT NULL
+		) AS changes JOIN blocks ON blocks.height = changes.height
+		GROUP BY changes.height ORDER BY changes.height`, address, address)
+	if err != nil {
+		return nil, errors.Wrap(err, "error querying address index")
+	}
+	defer rows.Close()
+
+	var history []BalanceDelta
+	for rows.Next() {
+		var delta BalanceDelta
+		if err := rows.Scan(&delta.Height, &delta.Time, &delta.Delta); err != nil {
+			return nil, errors.Wrap(err, "error querying address index")
+		}
+		// Spending and receiving as much in a block changes nothing.
+		if delta.Delta != 0 {
+			history = append(history, delta)
+		}
+	}
+	return history, errors.Wrap(rows.Err(), "error querying address index")
+}
+
+// addressPrefixes are the two-byte version prefixes of a chain's
+// transparent addresses.
+type addressPrefixes struct {
+	pubKeyHash []byte
+	scriptHash []byte
+}
+
+func chainAddressPrefixes(chainName string) addressPrefixes {
+	if chainName == "main" {
+		// t1 and t3 addresses.
+		return addressPrefixes{pubKeyHash: []byte{0x1c, 0xb8}, scriptHash: []byte{0x1c, 0xbd}}
+	}
+	// tm and t2 addresses, on testnet and regtest.
+	return addressPrefixes{pubKeyHash: []byte{0x1d, 0x25}, scriptHash: []byte{0x1c, 0xba}}
+}
+
+// scriptAddress returns the address a P2PKH or P2SH output script pays, or
+// "" for any other script.
+func (p addressPrefixes) scriptAddress(script []byte) string {
+	switch {
+	case len(script) == 25 && script[0] == 0x76 && script[1] == 0xa9 && script[2] == 20 &&
+		script[23] == 0x88 && script[24] == 0xac:
+		// OP_DUP OP_HASH160 <20 bytes> OP_EQUALVERIFY OP_CHECKSIG
+		return base58Check(p.pubKeyHash, script[3:23])
+	case len(script) == 23 && script[0] == 0xa9 && script[1] == 20 && script[22] == 0x87:
+		// OP_HASH160 <20 bytes> OP_EQUAL
+		return base58Check(p.scriptHash, script[2:22])
+	}
+	return ""
+}
+
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+// base58Check encodes payload after prefix, followed by their checksum.
+func base58Check(prefix, payload []byte) string {
+	data := append(append([]byte{}, prefix...), payload...)
+	checksum := sha256.Sum256(data)
+	checksum = sha256.Sum256(checksum[:])
+	data = append(data, checksum[:4]...)
+
+	var encoded []byte
+	n := new(big.Int).SetBytes(data)
+	radix, digit := big.NewInt(58), new(big.Int)
+	for n.Sign() > 0 {
+		n.DivMod(n, radix, digit)
+		encoded = append(encoded, base58Alphabet[digit.Int64()])
+	}
+	// Each leading zero byte is a leading 1.
+	for _, b := range data {
+		if b != 0 {
+			break
+		}
+		encoded = append(encoded, base58Alphabet[0])
+	}
+	for left, right := 0, len(encoded)-1; left < right; left, right = left+1, right-1 {
+		encoded[left], encoded[right] = encoded[right], encoded[left]
+	}
+	return string(encoded)
+}
+
+// reverseBytes reverses b in place.
+func reverseBytes(b []byte) {
+	for left, right := 0, len(b)-1; left < right; left, right = left+1, right-1 {
+		b[left], b[right] = b[right], b[left]
+	}
+}
@@ -0,0 +1,322 @@
+package common
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/adityapk00/lightwalletd/parser"
+	"github.com/btcsuite/btcd/btcjson"
+)
+
+// p2pkh returns the script paying the public key hash made of b.
+func p2pkh(b byte) []byte {
+	return append(append([]byte{0x76, 0xa9, 20}, bytes.Repeat([]byte{b}, 20)...), 0x88, 0xac)
+}
+
+// testTx serializes a version 1 transaction.
+func testTx(inputs []parser.PrevOutpoint, scriptSig []byte, outputs []parser.TxOutput) []byte {
+	var tx bytes.Buffer
+	binary.Write(&tx, binary.LittleEndian, uint32(1))
+	tx.WriteByte(byte(len(inputs)))
+	for _, input := range inputs {
+		tx.Write(input.TxHash)
+		binary.Write(&tx, binary.LittleEndian, input.Index)
+		tx.WriteByte(byte(len(scriptSig)))
+		tx.Write(scriptSig)
+		binary.Write(&tx, binary.LittleEndian, uint32(0xffffffff))
+	}
+	tx.WriteByte(byte(len(outputs)))
+	for _, output := range outputs {
+		binary.Write(&tx, binary.LittleEndian, output.Value)
+		tx.WriteByte(byte(len(output.Script)))
+		tx.Write(output.Script)
+	}
+	binary.Write(&tx, binary.LittleEndian, uint32(0))
+	return tx.Bytes()
+}
+
+// testChainBlock returns a block at height after prevHash, an hour after
+// the previous one, with a coinbase paying script followed by txs.
+func testChainBlock(t *testing.T, height int, prevHash []byte, script []byte, txs ...[]byte) *parser.Block {
+	var data bytes.Buffer
+	binary.Write(&data, binary.LittleEndian, int32(4))
+	data.Write(prevHash)
+	data.Write(make([]byte, 32+32))
+	binary.Write(&data, binary.LittleEndian, uint32(1600000000+3600*height))
+	data.Write(make([]byte, 4+32))
+	data.WriteByte(0)
+	data.WriteByte(byte(1 + len(txs)))
+
+	coinbaseSig := []byte{0x02, byte(height), byte(height >> 8)}
+	if height == 0 {
+		coinbaseSig = []byte{0x00}
+	}
+	data.Write(testTx([]parser.PrevOutpoint{{TxHash: make([]byte, 32), Index: 0xffffffff}}, coinbaseSig,
+		[]parser.TxOutput{{Value: 50, Script: script}}))
+	for _, tx := range txs {
+		data.Write(tx)
+	}
+
+	block := parser.NewBlock()
+	if _, err := block.ParseFromSlice(data.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	return block
+}
+
+// query asks client for method about addresses, decoding the answer into
+// result.
+func query(t *testing.T, client RPCClient, method string, arg map[string]interface{}, result interface{}) error {
+	param, _ := json.Marshal(arg)
+	answer, err := client.RawRequest(method, []json.RawMessage{param})
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(answer, result); err != nil {
+		t.Fatal(err)
+	}
+	return nil
+}
+
+func TestAddressIndex(t *testing.T) {
+	if !SQLiteSupported {
+		t.Skip("SQLite needs cgo")
+	}
+	dir, err := ioutil.TempDir("", "lightwalletd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "addresses.db")
+
+	idx, err := NewAddressIndex(path, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	prefixes := chainAddressPrefixes("test")
+	addressA, addressB := prefixes.scriptAddress(p2pkh(1)), prefixes.scriptAddress(p2pkh(2))
+
+	genesis := testChainBlock(t, 0, make([]byte, 32), p2pkh(1))
+	coinbase := genesis.Transactions()[0]
+	spend := testTx([]parser.PrevOutpoint{{TxHash: coinbase.GetEncodableHash(), Index: 0}}, []byte{0x51},
+		[]parser.TxOutput{{Value: 30, Script: p2pkh(2)}, {Value: 20, Script: p2pkh(1)}})
+	block1 := testChainBlock(t, 1, genesis.GetEncodableHash(), p2pkh(2), spend)
+
+	// Blocks that don't extend the tip are left to Sync.
+	if err := idx.Add(block1); err != nil || idx.Tip() != -1 {
+		t.Fatalf("block past the tip indexed: %v, tip %d", err, idx.Tip())
+	}
+	for _, block := range []*parser.Block{genesis, block1} {
+		if err := idx.Add(block); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if idx.Tip() != 1 {
+		t.Fatalf("tip %d, expected 1", idx.Tip())
+	}
+
+	node := cannedNode{"getblockcount": "1"}
+	client := idx.Client(node)
+	display := func(tx *parser.Transaction) string { return hex.EncodeToString(tx.GetDisplayHash()) }
+	spendTx := block1.Transactions()[1]
+
+	var txids []string
+	if err := query(t, client, "getaddresstxids", map[string]interface{}{"addresses": []string{addressA}}, &txids); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{display(coinbase), display(spendTx)}; !reflect.DeepEqual(txids, want) {
+		t.Errorf("txids of A %v, expected %v", txids, want)
+	}
+	// The spend pays both; it's listed once.
+	err = query(t, client, "getaddresstxids", map[string]interface{}{"addresses": []string{addressA, addressB}, "start": 1, "end": 1}, &txids)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{display(block1.Transactions()[0]), display(spendTx)}; !reflect.DeepEqual(txids, want) {
+		t.Errorf("txids of A and B %v, expected %v", txids, want)
+	}
+	err = query(t, client, "getaddresstxids", map[string]interface{}{"addresses": []string{addressA}, "start": 0, "end": 2}, &txids)
+	if jsonErr, ok := err.(*btcjson.RPCError); !ok || jsonErr.Code != -8 {
+		t.Errorf("range past the tip: %v", err)
+	}
+
+	var utxos []addressUtxo
+	if err := query(t, client, "getaddressutxos", map[string]interface{}{"addresses": []string{addressA}}, &utxos); err != nil {
+		t.Fatal(err)
+	}
+	want := []addressUtxo{{Address: addressA, Txid: display(spendTx), OutputIndex: 1, Script: hex.EncodeToString(p2pkh(1)), Satoshis: 20, Height: 1}}
+	if !reflect.DeepEqual(utxos, want) {
+		t.Errorf("utxos of A %+v, expected %+v", utxos, want)
+	}
+
+	var balance struct{ Balance, Received int64 }
+	if err := query(t, client, "getaddressbalance", map[string]interface{}{"addresses": []string{addressA}}, &balance); err != nil {
+		t.Fatal(err)
+	}
+	if balance.Balance != 20 || balance.Received != 70 {
+		t.Errorf("balance of A %+v, expected 20 of 70 received", balance)
+	}
+	history, err := idx.BalanceHistory(addressA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantHistory := []BalanceDelta{{Height: 0, Time: 1600000000, Delta: 50}, {Height: 1, Time: 1600003600, Delta: -30}}
+	if !reflect.DeepEqual(history, wantHistory) {
+		t.Errorf("history of A %+v, expected %+v", history, wantHistory)
+	}
+
+	var deltas []interface{}
+	if err := query(t, client, "getaddressmempool", map[string]interface{}{"addresses": []string{addressA}}, &deltas); err != nil || len(deltas) != 0 {
+		t.Errorf("mempool %v: %v", deltas, err)
+	}
+
+	// Other calls go to the node.
+	var count int
+	if err := query(t, client, "getblockcount", nil, &count); err != nil || count != 1 {
+		t.Errorf("getblockcount %d: %v", count, err)
+	}
+
+	// A block that doesn't follow the tip means it was reorged away.
+	if err := idx.Add(testChainBlock(t, 2, make([]byte, 32), p2pkh(3))); err != nil {
+		t.Fatal(err)
+	}
+	if idx.Tip() != 0 {
+		t.Fatalf("tip %d after a reorg, expected 0", idx.Tip())
+	}
+	if err := query(t, client, "getaddressbalance", map[string]interface{}{"addresses": []string{addressA}}, &balance); err != nil {
+		t.Fatal(err)
+	}
+	if balance.Balance != 50 || balance.Received != 50 {
+		t.Errorf("balance of A after the reorg %+v, expected 50 of 50 received", balance)
+	}
+
+	// The index survives a restart.
+	idx.Close()
+	idx, err = NewAddressIndex(path, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idx.Close()
+	if idx.Tip() != 0 {
+		t.Fatalf("tip %d after reopening, expected 0", idx.Tip())
+	}
+	if err := idx.Add(block1); err != nil || idx.Tip() != 1 {
+		t.Errorf("block after reopening not indexed: %v, tip %d", err, idx.Tip())
+	}
+}
+
+func TestAddressIndexCatchUp(t *testing.T) {
+	if !SQLiteSupported {
+		t.Skip("SQLite needs cgo")
+	}
+	node := newFixtureNode(t)
+	idx, err := NewAddressIndex(":memory:", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idx.Close()
+	// Start from the first fixture rather than genesis.
+	idx.tip, idx.tipHash = 289459, node.parsed(t, 289460).GetPrevHash()
+
+	tip, err := idx.CatchUp(node)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tip != 289465 || idx.Tip() != 289465 {
+		t.Fatalf("caught up to %d, expected 289465", tip)
+	}
+
+	// Every fixture block pays the same founders' reward address.
+	var txids []string
+	err = query(t, idx.Client(node), "getaddresstxids", map[string]interface{}{
+		"addresses": []string{"t2Vf4wKcJ3ZFtLj4jezUUKkwYR92BLHn5UT"},
+		"start":     289460,
+		"end":       289465,
+	}, &txids)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(txids) != 6 {
+		t.Errorf("%d txids, expected 6", len(txids))
+	}
+
+	// The nil index passes everything on.
+	var nilIndex *AddressIndex
+	if nilIndex.Client(node) != RPCClient(node) || nilIndex.Add(node.parsed(t, 289460)) != nil {
+		t.Error("nil index isn't empty")
+	}
+}
+
+func TestOpenSchema(t *testing.T) {
+	if !SQLiteSupported {
+		t.Skip("SQLite needs cgo")
+	}
+	dir, err := ioutil.TempDir("", "lightwalletd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "index.db")
+	userVersion := func(db *sql.DB) int {
+		var version int
+		if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+			t.Fatal(err)
+		}
+		return version
+	}
+
+	idx, err := NewAddressIndex(path, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version := userVersion(idx.db); version != addressIndexVersion {
+		t.Errorf("schema version %d, expected %d", version, addressIndexVersion)
+	}
+
+	// A database written by a later lightwalletd is refused.
+	if _, err := idx.db.Exec("PRAGMA user_version = 99"); err != nil {
+		t.Fatal(err)
+	}
+	idx.Close()
+	if _, err := NewAddressIndex(path, "test"); err == nil {
+		t.Error("expected a newer schema to be refused")
+	}
+
+	// Tables from before the version was recorded are version 1's, and kept.
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec("CREATE TABLE old (a INTEGER); INSERT INTO old VALUES (1)"); err != nil {
+		t.Fatal(err)
+	}
+	schemaV1 := "CREATE TABLE IF NOT EXISTS old (a INTEGER);"
+	if err := openSchema(db, schemaV1, 1); err != nil {
+		t.Fatal(err)
+	}
+	var rows int
+	if err := db.QueryRow("SELECT count(*) FROM old").Scan(&rows); err != nil || rows != 1 || userVersion(db) != 1 {
+		t.Errorf("unversioned tables not kept as version 1: %d rows, %v, version %d", rows, err, userVersion(db))
+	}
+
+	// An older version is created again.
+	if err := openSchema(db, "CREATE TABLE IF NOT EXISTS new (b TEXT);", 2); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("SELECT a FROM old"); err == nil {
+		t.Error("version 1's table kept after the upgrade")
+	}
+	if _, err := db.Exec("INSERT INTO new VALUES ('x')"); err != nil || userVersion(db) != 2 {
+		t.Errorf("version 2's table not created: %v, version %d", err, userVersion(db))
+	}
+}
@@ -3,6 +3,7 @@ package common
 import (
 	"bytes"
 	"fmt"
+	"sort"
 	"sync"
 
 	"github.com/adityapk00/lightwalletd/walletrpc"
@@ -11,31 +12,178 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
+// BlockCacheEntry is a serialized compact block along with its hash.
 type BlockCacheEntry struct {
-	data []byte
-	hash []byte
+	Data []byte
+	Hash []byte
 }
 
+// BlockCacheStore holds the entries of a BlockCache, keyed by height. The
+// BlockCache serializes all writes, but Get, Len and Range may be called
+// concurrently with each other.
+type BlockCacheStore interface {
+	// Get returns the entry at height, or nil if there isn't one.
+	Get(height int) *BlockCacheEntry
+	// Put adds or replaces the entry at height.
+	Put(height int, entry *BlockCacheEntry) error
+	// Evict removes the entry at height, if any.
+	Evict(height int)
+	// Len returns the number of entries held.
+	Len() int
+	// Range calls f for each entry, in no particular order, until f returns false.
+	Range(f func(height int, entry *BlockCacheEntry) bool)
+}
+
+// memoryBlockCacheStore is the default BlockCacheStore, a plain map.
+type memoryBlockCacheStore struct {
+	m map[int]*BlockCacheEntry
+}
+
+func NewMemoryBlockCacheStore() BlockCacheStore {
+	return &memoryBlockCacheStore{m: make(map[int]*BlockCacheEntry)}
+}
+
+func (s *memoryBlockCacheStore) Get(height int) *BlockCacheEntry {
+	return s.m[height]
+}
+
+func (s *memoryBlockCacheStore) Put(height int, entry *BlockCacheEntry) error {
+	s.m[height] = entry
+	return nil
+}
+
+func (s *memoryBlockCacheStore) Evict(height int) {
+	delete(s.m, height)
+}
+
+func (s *memoryBlockCacheStore) Len() int {
+	return len(s.m)
+}
+
+func (s *memoryBlockCacheStore) Range(f func(height int, entry *BlockCacheEntry) bool) {
+	for height, entry := range s.m {
+		if !f(height, entry) {
+			return
+		}
+	}
+}
+
+func (s *memoryBlockCacheStore) compacted() BlockCacheStore {
+	m := make(map[int]*BlockCacheEntry, len(s.m))
+	for height, entry := range s.m {
+		m[height] = entry
+	}
+	return &memoryBlockCacheStore{m: m}
+}
+
+// compactableStore is implemented by the stores that can make a copy of
+// themselves without the space their evicted entries left behind. Go maps
+// never shrink, so a store that once held many more blocks than it does now
+// keeps paying for them until it's rebuilt.
+type compactableStore interface {
+	compacted() BlockCacheStore
+}
+
+// compactStore returns a compacted copy of s, or s itself if it can't be
+// compacted.
+func compactStore(s BlockCacheStore) BlockCacheStore {
+	if c, ok := s.(compactableStore); ok {
+		return c.compacted()
+	}
+	return s
+}
+
+// EvictionPolicy decides which block a full BlockCache drops. It must be
+// chosen before any blocks are added.
+type EvictionPolicy int
+
+const (
+	// EvictOldest drops the lowest block, keeping the cache a contiguous
+	// window of the latest MaxEntries blocks.
+	EvictOldest EvictionPolicy = iota
+
+	// EvictLeastActive drops the block with the fewest shielded spends and
+	// outputs, the oldest among equals, so that the blocks a wallet rescan
+	// actually needs stay cached longer than empty ones. The latest
+	// ProtectedTip blocks are never dropped, they are needed to detect reorgs.
+	EvictLeastActive
+
+	// EvictLeastRecent drops the block least recently added or read, sparing
+	// the latest ProtectedTip blocks as EvictLeastActive does.
+	EvictLeastRecent
+)
+
 type BlockCache struct {
 	MaxEntries int
 
 	FirstBlock int
 	LastBlock  int
 
-	m map[int]*BlockCacheEntry
+	store BlockCacheStore
+
+	// Checkpoints, if not nil, is kept up to date by the ingestors.
+	Checkpoints *CheckpointIndex
+
+	Eviction     EvictionPolicy
+	ProtectedTip int
+	activity     map[int]int
+	hashes       map[string]int
+
+	// queue holds the blocks EvictLeastActive may drop, recency the order
+	// EvictLeastRecent drops them in.
+	queue   *activityQueue
+	recency *recencyList
+
+	// Coalescer, if not nil, shares fetches of blocks older than the cache
+	// between concurrent requests.
+	Coalescer *BlockFetchCoalescer
+
+	// evictionHook is passed the blocks evicted to make room.
+	evictionHook EvictionHook
+
+	warmedUp  bool
+	warmUpErr error
+
+	// compactAfter, if positive, is how many blocks reorgs may drop before
+	// the cache is compacted. generation counts changes to the cache, so a
+	// compaction can tell whether the cache changed while it was copying it.
+	compactAfter   int
+	reorgEvictions int
+	compacting     bool
+	generation     uint64
+	metrics        *PrometheusMetrics
+
+	subscribers map[chan *walletrpc.CompactBlock]bool
+
+	// rollback is the lowest height a reorg has rolled the cache back to
+	// since a block was last added, if rolledBack; the reorg subscribers
+	// are told once the new chain is added on top of it.
+	rolledBack       bool
+	rollback         int
+	reorgSubscribers map[chan Reorg]bool
 
 	log   *logrus.Entry
 	mutex sync.RWMutex
 }
 
 func NewBlockCache(maxEntries int, log *logrus.Entry) *BlockCache {
+	return NewBlockCacheWithStore(maxEntries, log, NewMemoryBlockCacheStore())
+}
+
+// NewBlockCacheWithStore creates a BlockCache that keeps its blocks in store.
+func NewBlockCacheWithStore(maxEntries int, log *logrus.Entry, store BlockCacheStore) *BlockCache {
 	return &BlockCache{
-		MaxEntries: maxEntries,
-		FirstBlock: -1,
-		LastBlock:  -1,
-		m:          make(map[int]*BlockCacheEntry),
-		log:        log,
-		mutex:      sync.RWMutex{},
+		MaxEntries:   maxEntries,
+		FirstBlock:   -1,
+		LastBlock:    -1,
+		ProtectedTip: 100,
+		activity:     make(map[int]int),
+		queue:        newActivityQueue(),
+		recency:      newRecencyList(),
+		store:        store,
+		evictionHook: noEvictionHook{},
+		log:          log,
+		mutex:        sync.RWMutex{},
 	}
 }
 
@@ -44,7 +192,7 @@ func (c *BlockCache) AddHistorical(height int, block *walletrpc.CompactBlock) (e
 	defer c.mutex.Unlock()
 
 	// If the cache is full, then we'll ignore this block
-	if c.LastBlock-c.FirstBlock+1 > c.MaxEntries {
+	if c.full() {
 		return nil, true
 	}
 
@@ -61,16 +209,20 @@ func (c *BlockCache) AddHistorical(height int, block *walletrpc.CompactBlock) (e
 		return err, false
 	}
 
-	c.m[height] = &BlockCacheEntry{
-		data: data,
-		hash: block.GetHash(),
+	err = c.store.Put(height, &BlockCacheEntry{
+		Data: data,
+		Hash: block.GetHash(),
+	})
+	if err != nil {
+		return err, false
 	}
 	c.FirstBlock = height
+	c.remember(height, block)
 
 	c.log.WithFields(logrus.Fields{
 		"method": "CacheHistoricalBlock",
 		"block":  height,
-	}).Info("Cache")
+	}).Debug("Cache")
 
 	return nil, false
 }
@@ -91,14 +243,25 @@ func (c *BlockCache) Add(height int, block *walletrpc.CompactBlock) (error, bool
 	// Any outdated blocks returned
 	if height >= c.FirstBlock && height <= c.LastBlock {
 		for i := height; i <= c.LastBlock; i++ {
-			delete(c.m, i)
+			c.forget(i)
+		}
+		c.reorgEvictions += c.LastBlock - height + 1
+		// The blocks now within ProtectedTip of the tip can't be dropped.
+		for i := height - c.ProtectedTip; i <= c.LastBlock-c.ProtectedTip; i++ {
+			c.queue.remove(i)
 		}
 		c.LastBlock = height - 1
+		if !c.rolledBack || c.LastBlock < c.rollback {
+			c.rolledBack, c.rollback = true, c.LastBlock
+		}
+		if err := c.Checkpoints.RemoveAbove(height - 1); err != nil {
+			c.log.Warn("Error removing checkpoints: ", err)
+		}
 	}
 
 	// Don't allow out-of-order blocks. This is more of a sanity check than anything
 	// If there is a reorg, then the ingestor needs to handle it.
-	if c.m[height-1] != nil && !bytes.Equal(block.PrevHash, c.m[height-1].hash) {
+	if prev := c.store.Get(height - 1); prev != nil && !bytes.Equal(block.PrevHash, prev.Hash) {
 		return nil, true
 	}
 
@@ -109,28 +272,318 @@ func (c *BlockCache) Add(height int, block *walletrpc.CompactBlock) (error, bool
 		return err, false
 	}
 
-	c.m[height] = &BlockCacheEntry{
-		data: data,
-		hash: block.GetHash(),
+	err = c.store.Put(height, &BlockCacheEntry{
+		Data: data,
+		Hash: block.GetHash(),
+	})
+	if err != nil {
+		return err, false
 	}
 
 	c.LastBlock = height
+	c.remember(height, block)
+	if c.rolledBack {
+		c.notifyReorg(Reorg{Ancestor: c.rollback, Tip: height, TipHash: block.Hash})
+		c.rolledBack = false
+	}
+	c.notify(block)
 
-	// If the cache is full, remove the oldest block
-	if c.LastBlock-c.FirstBlock+1 > c.MaxEntries {
-		//println("Deleteing at height", c.FirstBlock)
-		delete(c.m, c.FirstBlock)
-		c.FirstBlock = c.FirstBlock + 1
+	// If the cache is full, remove a block
+	if c.full() {
+		c.evict()
+	}
+
+	if c.compactAfter > 0 && c.reorgEvictions >= c.compactAfter && !c.compacting {
+		c.compacting = true
+		go c.compact()
 	}
 
 	c.log.WithFields(logrus.Fields{
 		"method": "CacheLatestBlock",
 		"block":  height,
-	}).Info("Cache")
+	}).Debug("Cache")
 
 	return nil, false
 }
 
+// subscriberBuffer is how many blocks a subscriber may fall behind before it's
+// dropped.
+const subscriberBuffer = 16
+
+// Subscribe returns a channel that receives every block added after the
+// current tip, which is also returned, including the replacements for blocks
+// dropped by a reorg; and a function to stop the subscription. The channel is
+// closed if the subscriber falls more than subscriberBuffer blocks behind.
+func (c *BlockCache) Subscribe() (<-chan *walletrpc.CompactBlock, int, func()) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.subscribers == nil {
+		c.subscribers = make(map[chan *walletrpc.CompactBlock]bool)
+	}
+	ch := make(chan *walletrpc.CompactBlock, subscriberBuffer)
+	c.subscribers[ch] = true
+
+	return ch, c.LastBlock, func() {
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+
+		if c.subscribers[ch] {
+			delete(c.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// notify passes block on to the subscribers. The caller holds the mutex.
+func (c *BlockCache) notify(block *walletrpc.CompactBlock) {
+	for ch := range c.subscribers {
+		select {
+		case ch <- copyBlock(block):
+		default:
+			delete(c.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// Reorg is what SubscribeReorgs reports of a reorg: the height the cache
+// rolled back to, below which the chain didn't change, and the first block
+// added on top of it.
+type Reorg struct {
+	Ancestor int
+	Tip      int
+	TipHash  []byte
+}
+
+// SubscribeReorgs returns a channel that receives every reorg the cache goes
+// through, and a function to stop the subscription. The channel is closed if
+// the subscriber falls more than subscriberBuffer reorgs behind.
+func (c *BlockCache) SubscribeReorgs() (<-chan Reorg, func()) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.reorgSubscribers == nil {
+		c.reorgSubscribers = make(map[chan Reorg]bool)
+	}
+	ch := make(chan Reorg, subscriberBuffer)
+	c.reorgSubscribers[ch] = true
+
+	return ch, func() {
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+
+		if c.reorgSubscribers[ch] {
+			delete(c.reorgSubscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// notifyReorg passes reorg on to the reorg subscribers. The caller holds the
+// mutex.
+func (c *BlockCache) notifyReorg(reorg Reorg) {
+	for ch := range c.reorgSubscribers {
+		select {
+		case ch <- reorg:
+		default:
+			delete(c.reorgSubscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// EnableHashIndex makes the cache keep an index from block hash to height,
+// for GetByHash. It must be called before any blocks are added.
+func (c *BlockCache) EnableHashIndex() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.hashes = make(map[string]int)
+}
+
+// SetEvictionHook passes the blocks the cache evicts to make room to hook. If
+// hook is a BlockTier, LookupBlock looks for blocks missing from the cache in
+// it before asking the node.
+func (c *BlockCache) SetEvictionHook(hook EvictionHook) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.evictionHook = hook
+}
+
+// EnableCompaction makes the cache compact itself in the background once
+// reorgs have dropped after blocks since the last compaction. Compactions are
+// counted in metrics.
+func (c *BlockCache) EnableCompaction(after int, metrics *PrometheusMetrics) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.compactAfter = after
+	c.metrics = metrics
+}
+
+// compact rebuilds the store and the maps the cache keeps beside it, to give
+// back the memory held on to for the blocks evicted by reorgs. The copies are
+// made holding only the read lock, so blocks are served throughout; if a block
+// was added or evicted in the meantime the copies are stale and thrown away,
+// and the next Add tries again. It reports whether the cache was compacted.
+func (c *BlockCache) compact() bool {
+	c.mutex.RLock()
+	generation := c.generation
+	store := compactStore(c.store)
+	activity := make(map[int]int, len(c.activity))
+	for height, a := range c.activity {
+		activity[height] = a
+	}
+	var hashes map[string]int
+	if c.hashes != nil {
+		hashes = make(map[string]int, len(c.hashes))
+		for hash, height := range c.hashes {
+			hashes[hash] = height
+		}
+	}
+	c.mutex.RUnlock()
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.compacting = false
+	if c.generation != generation {
+		return false
+	}
+	c.store = store
+	c.activity = activity
+	c.hashes = hashes
+
+	c.log.WithFields(logrus.Fields{
+		"reorg_evictions": c.reorgEvictions,
+		"blocks":          store.Len(),
+	}).Info("Compacted the block cache")
+
+	c.reorgEvictions = 0
+	if c.metrics != nil {
+		c.metrics.CacheCompactions.Inc()
+	}
+	return true
+}
+
+// remember records what the cache tracks about a block besides the block
+// itself. The caller holds the mutex.
+func (c *BlockCache) remember(height int, block *walletrpc.CompactBlock) {
+	c.generation++
+	c.activity[height] = shieldedActivity(block)
+	if c.hashes != nil {
+		c.hashes[string(block.Hash)] = height
+	}
+	switch c.Eviction {
+	case EvictLeastActive:
+		// Both the block, if it's below the protected tip, and the one the
+		// tip moved away from, may be dropped from now on.
+		for _, h := range []int{height, c.LastBlock - c.ProtectedTip} {
+			if activity, ok := c.activity[h]; ok && h <= c.LastBlock-c.ProtectedTip {
+				c.queue.add(h, activity)
+			}
+		}
+	case EvictLeastRecent:
+		c.recency.touch(height)
+	}
+}
+
+// forget evicts the block at height, and what remember recorded about it. The
+// caller holds the mutex.
+func (c *BlockCache) forget(height int) {
+	c.generation++
+	if c.hashes != nil {
+		if entry := c.store.Get(height); entry != nil && c.hashes[string(entry.Hash)] == height {
+			delete(c.hashes, string(entry.Hash))
+		}
+	}
+	c.store.Evict(height)
+	delete(c.activity, height)
+	c.queue.remove(height)
+	c.recency.remove(height)
+}
+
+// SetMaxEntries resizes the cache, evicting blocks right away if it shrank.
+func (c *BlockCache) SetMaxEntries(maxEntries int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if maxEntries < 1 {
+		maxEntries = 1
+	}
+	c.MaxEntries = maxEntries
+	for c.full() {
+		c.evict()
+	}
+}
+
+// GetMaxEntries returns the current size of the cache.
+func (c *BlockCache) GetMaxEntries() int {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return c.MaxEntries
+}
+
+// full reports whether the cache holds more than MaxEntries blocks. The
+// caller holds the mutex.
+func (c *BlockCache) full() bool {
+	if c.Eviction != EvictOldest {
+		return c.store.Len() > c.MaxEntries
+	}
+	return c.LastBlock-c.FirstBlock+1 > c.MaxEntries
+}
+
+// evict drops one block according to the eviction policy. The caller holds
+// the mutex.
+func (c *BlockCache) evict() {
+	// With only protected blocks left, the lowest goes anyway.
+	victim := c.FirstBlock
+	switch c.Eviction {
+	case EvictLeastActive:
+		if least := c.queue.least(); least >= 0 {
+			victim = least
+		}
+	case EvictLeastRecent:
+		if least := c.recency.leastRecent(func(height int) bool {
+			return height <= c.LastBlock-c.ProtectedTip
+		}); least >= 0 {
+			victim = least
+		}
+	}
+
+	//println("Deleteing at height", victim)
+	entry := c.store.Get(victim)
+	c.forget(victim)
+	if entry != nil {
+		if err := c.evictionHook.Evicted(victim, entry); err != nil {
+			c.log.WithFields(logrus.Fields{
+				"height": victim,
+				"error":  err,
+			}).Warn("eviction hook failed")
+		}
+	}
+
+	// Move FirstBlock up to the lowest block still cached.
+	for c.FirstBlock < c.LastBlock {
+		if _, ok := c.activity[c.FirstBlock]; ok {
+			break
+		}
+		c.FirstBlock++
+	}
+}
+
+// shieldedActivity counts the Sapling spends and outputs in block.
+func shieldedActivity(block *walletrpc.CompactBlock) int {
+	activity := 0
+	for _, tx := range block.Vtx {
+		activity += len(tx.Spends) + len(tx.Outputs)
+	}
+	return activity
+}
+
 func (c *BlockCache) Get(height int) *walletrpc.CompactBlock {
 	c.mutex.RLock()
 	defer c.mutex.RUnlock()
@@ -145,9 +598,17 @@ func (c *BlockCache) Get(height int) *walletrpc.CompactBlock {
 		return nil
 	}
 
+	entry := c.store.Get(height)
+	if entry == nil {
+		return nil
+	}
+	if c.Eviction == EvictLeastRecent {
+		c.recency.touch(height)
+	}
+
 	//println("Cache returned")
 	serialized := &walletrpc.CompactBlock{}
-	err := proto.Unmarshal(c.m[height].data, serialized)
+	err := proto.Unmarshal(entry.Data, serialized)
 	if err != nil {
 		println("Error unmarshalling compact block")
 		return nil
@@ -156,6 +617,119 @@ func (c *BlockCache) Get(height int) *walletrpc.CompactBlock {
 	return serialized
 }
 
+// getFromTier returns the block at height from the cache's BlockTier, or nil
+// if it has none or the block isn't there.
+func (c *BlockCache) getFromTier(height int) *walletrpc.CompactBlock {
+	c.mutex.RLock()
+	tier, ok := c.evictionHook.(BlockTier)
+	c.mutex.RUnlock()
+	if !ok {
+		return nil
+	}
+
+	entry := tier.Get(height)
+	if entry == nil {
+		return nil
+	}
+	block := &walletrpc.CompactBlock{}
+	if err := proto.Unmarshal(entry.Data, block); err != nil || int(block.Height) != height {
+		return nil
+	}
+	return block
+}
+
+// GetByHash returns the cached block with the given hash, or nil if there
+// isn't one or the hash index isn't enabled.
+func (c *BlockCache) GetByHash(hash []byte) *walletrpc.CompactBlock {
+	c.mutex.RLock()
+	height, ok := c.hashes[string(hash)]
+	c.mutex.RUnlock()
+	if !ok {
+		return nil
+	}
+
+	// The block may have been replaced since the lookup.
+	block := c.Get(height)
+	if block == nil || !bytes.Equal(block.Hash, hash) {
+		return nil
+	}
+	return block
+}
+
+// GetByTime returns the height of the first cached block whose time is at
+// least time, or -1 if there isn't one. It's a binary search, which assumes
+// that times increase with height; they only roughly do, so the answer may
+// be a few blocks off where they don't.
+func (c *BlockCache) GetByTime(time uint32) int {
+	first, last := c.GetFirstBlock(), c.GetLatestBlock()
+	if first < 0 {
+		return -1
+	}
+
+	// The cache may have holes, unless it evicts the oldest blocks first, so
+	// each probe looks at the first cached block from its height on.
+	i := sort.Search(last-first+1, func(i int) bool {
+		block, _ := c.cachedFrom(first+i, last)
+		return block == nil || block.Time >= time
+	})
+	block, height := c.cachedFrom(first+i, last)
+	if block == nil || block.Time < time {
+		// Evicted or replaced during the search.
+		return -1
+	}
+	return height
+}
+
+// cachedFrom returns the lowest cached block from height up to last, and its
+// height, or nil and -1 if there isn't one.
+func (c *BlockCache) cachedFrom(height, last int) (*walletrpc.CompactBlock, int) {
+	for ; height <= last; height++ {
+		if block := c.Get(height); block != nil {
+			return block, height
+		}
+	}
+	return nil, -1
+}
+
+// WarmedUp reports whether the historical ingestor has finished filling the
+// cache, or given up, see WarmUpError.
+func (c *BlockCache) WarmedUp() bool {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return c.warmedUp
+}
+
+// WarmUpError returns why the historical ingestor stopped before filling the
+// cache, nil if it didn't or is still running.
+func (c *BlockCache) WarmUpError() error {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return c.warmUpErr
+}
+
+// setWarmedUp marks the historical ingestor finished, err being why it
+// stopped early, if it did.
+func (c *BlockCache) setWarmedUp(err error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.warmedUp = true
+	c.warmUpErr = err
+}
+
+// GetFirstBlock returns the lowest cached block, or -1 if there is none.
+func (c *BlockCache) GetFirstBlock() int {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	if c.LastBlock < c.FirstBlock {
+		return -1
+	}
+	return c.FirstBlock
+}
+
 func (c *BlockCache) GetLatestBlock() int {
 	c.mutex.RLock()
 	defer c.mutex.RUnlock()
@@ -0,0 +1,219 @@
+//go:build !windows
+// +build !windows
+
+package common
+
+import (
+	"os"
+	"sort"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+const mmapInitialSize = 64 * 1024 * 1024
+
+type mmapSlot struct {
+	offset int
+	length int
+	hash   []byte
+}
+
+// mmapSpan is a stretch of the file no block is using.
+type mmapSpan struct {
+	offset int
+	length int
+}
+
+// mmapBlockCacheStore keeps the serialized blocks in a memory-mapped file, so
+// the kernel can page cold blocks out instead of them counting towards the
+// process' resident memory. Only the index (height -> location and hash) is
+// kept on the Go heap.
+//
+// The space of evicted blocks goes on a free list, kept in file order with
+// neighbouring spans merged, and is reused first-fit by later blocks, which
+// are only appended at the tail if none of it fits. The file so stays about
+// as large as the most blocks it ever held at once.
+type mmapBlockCacheStore struct {
+	file  *os.File
+	data  []byte
+	tail  int
+	index map[int]*mmapSlot
+	free  []mmapSpan
+}
+
+// NewMmapBlockCacheStore creates a BlockCacheStore backed by a memory-mapped
+// file at path. Any existing file at path is truncated: the cache is not
+// persistent across restarts.
+func NewMmapBlockCacheStore(path string) (BlockCacheStore, error) {
+	return newMmapBlockCacheStore(path, mmapInitialSize)
+}
+
+func newMmapBlockCacheStore(path string, size int) (*mmapBlockCacheStore, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, errors.Wrap(err, "error opening cache file")
+	}
+
+	s := &mmapBlockCacheStore{
+		file:  file,
+		index: make(map[int]*mmapSlot),
+	}
+	if err := s.remap(size); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// remap grows the file to size bytes and maps it again.
+func (s *mmapBlockCacheStore) remap(size int) error {
+	if s.data != nil {
+		if err := syscall.Munmap(s.data); err != nil {
+			return errors.Wrap(err, "error unmapping cache file")
+		}
+		s.data = nil
+	}
+
+	if err := s.file.Truncate(int64(size)); err != nil {
+		return errors.Wrap(err, "error resizing cache file")
+	}
+
+	data, err := syscall.Mmap(int(s.file.Fd()), 0, size, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	if err != nil {
+		return errors.Wrap(err, "error mapping cache file")
+	}
+	s.data = data
+	return nil
+}
+
+func (s *mmapBlockCacheStore) Get(height int) *BlockCacheEntry {
+	slot, ok := s.index[height]
+	if !ok {
+		return nil
+	}
+
+	// Copy out of the mapping, it may be remapped by a later Put.
+	data := make([]byte, slot.length)
+	copy(data, s.data[slot.offset:slot.offset+slot.length])
+	return &BlockCacheEntry{Data: data, Hash: slot.hash}
+}
+
+func (s *mmapBlockCacheStore) Put(height int, entry *BlockCacheEntry) error {
+	s.Evict(height)
+
+	offset, ok := s.allocate(len(entry.Data))
+	if !ok {
+		if s.tail+len(entry.Data) > len(s.data) {
+			size := len(s.data) * 2
+			for s.tail+len(entry.Data) > size {
+				size *= 2
+			}
+			if err := s.remap(size); err != nil {
+				return err
+			}
+		}
+		offset = s.tail
+		s.tail += len(entry.Data)
+	}
+
+	copy(s.data[offset:], entry.Data)
+	s.index[height] = &mmapSlot{
+		offset: offset,
+		length: len(entry.Data),
+		hash:   entry.Hash,
+	}
+	return nil
+}
+
+// allocate takes length bytes from the first free span big enough, if any.
+func (s *mmapBlockCacheStore) allocate(length int) (int, bool) {
+	for i := range s.free {
+		span := &s.free[i]
+		if span.length < length {
+			continue
+		}
+		offset := span.offset
+		span.offset += length
+		span.length -= length
+		if span.length == 0 {
+			s.free = append(s.free[:i], s.free[i+1:]...)
+		}
+		return offset, true
+	}
+	return 0, false
+}
+
+// release puts the space of slot on the free list, merging it with its
+// neighbours, and gives back the end of the file if it's there.
+func (s *mmapBlockCacheStore) release(slot *mmapSlot) {
+	if slot.length == 0 {
+		return
+	}
+	span := mmapSpan{offset: slot.offset, length: slot.length}
+	i := sort.Search(len(s.free), func(i int) bool { return s.free[i].offset > span.offset })
+	if i > 0 && s.free[i-1].offset+s.free[i-1].length == span.offset {
+		i--
+		span.offset = s.free[i].offset
+		span.length += s.free[i].length
+		s.free = append(s.free[:i], s.free[i+1:]...)
+	}
+	if i < len(s.free) && span.offset+span.length == s.free[i].offset {
+		span.length += s.free[i].length
+		s.free = append(s.free[:i], s.free[i+1:]...)
+	}
+
+	if span.offset+span.length == s.tail {
+		s.tail = span.offset
+		return
+	}
+	s.free = append(s.free, mmapSpan{})
+	copy(s.free[i+1:], s.free[i:])
+	s.free[i] = span
+}
+
+func (s *mmapBlockCacheStore) Evict(height int) {
+	if slot, ok := s.index[height]; ok {
+		s.release(slot)
+		delete(s.index, height)
+	}
+}
+
+func (s *mmapBlockCacheStore) Len() int {
+	return len(s.index)
+}
+
+func (s *mmapBlockCacheStore) Range(f func(height int, entry *BlockCacheEntry) bool) {
+	for height := range s.index {
+		if !f(height, s.Get(height)) {
+			return
+		}
+	}
+}
+
+// compacted returns a store with a fresh copy of the index, which Go never
+// shrinks, over the same file; the file itself needs no compacting, its free
+// space being reused. The copy is only used instead of s, never beside it.
+func (s *mmapBlockCacheStore) compacted() BlockCacheStore {
+	index := make(map[int]*mmapSlot, len(s.index))
+	for height, slot := range s.index {
+		index[height] = slot
+	}
+	return &mmapBlockCacheStore{
+		file:  s.file,
+		data:  s.data,
+		tail:  s.tail,
+		index: index,
+		free:  append([]mmapSpan(nil), s.free...),
+	}
+}
+
+// Close unmaps and removes the backing file.
+func (s *mmapBlockCacheStore) Close() error {
+	if err := syscall.Munmap(s.data); err != nil {
+		return err
+	}
+	s.data = nil
+	s.file.Close()
+	return os.Remove(s.file.Name())
+}
@@ -0,0 +1,8 @@
+package common
+
+import "github.com/pkg/errors"
+
+// NewMmapBlockCacheStore is not supported on Windows.
+func NewMmapBlockCacheStore(path string) (BlockCacheStore, error) {
+	return nil, errors.New("the mmap cache store is not supported on Windows")
+}
@@ -0,0 +1,672 @@
+package common
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+
+	"github.com/adityapk00/lightwalletd/walletrpc"
+)
+
+var testLog *logrus.Entry
+
+func init() {
+	logger := logrus.New()
+	logger.SetOutput(ioutil.Discard)
+	testLog = logger.WithField("app", "test")
+}
+
+type storeFactory func(t *testing.T) BlockCacheStore
+
+// testStores returns a constructor for each BlockCacheStore implementation.
+// The mmap store starts out tiny so that the tests exercise growing it.
+func testStores() map[string]storeFactory {
+	return map[string]storeFactory{
+		"memory": func(t *testing.T) BlockCacheStore {
+			return NewMemoryBlockCacheStore()
+		},
+		"mmap": func(t *testing.T) BlockCacheStore {
+			dir, err := ioutil.TempDir("", "lightwalletd")
+			if err != nil {
+				t.Fatal(err)
+			}
+			s, err := newMmapBlockCacheStore(filepath.Join(dir, "cache.dat"), 16)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return s
+		},
+		"tiered": func(t *testing.T) BlockCacheStore {
+			return NewTieredBlockCacheStore(10, NewMemoryBlockCacheStore(), GetPrometheusMetrics())
+		},
+	}
+}
+
+func cleanupStore(store BlockCacheStore) {
+	if s, ok := store.(*mmapBlockCacheStore); ok {
+		s.Close()
+		os.Remove(filepath.Dir(s.file.Name()))
+	}
+}
+
+func testEntry(height int) *BlockCacheEntry {
+	return &BlockCacheEntry{
+		Data: []byte(fmt.Sprintf("block data for height %d", height)),
+		Hash: []byte{byte(height), byte(height >> 8)},
+	}
+}
+
+func TestBlockCacheStoreConformance(t *testing.T) {
+	for name, newStore := range testStores() {
+		t.Run(name, func(t *testing.T) {
+			store := newStore(t)
+			defer cleanupStore(store)
+
+			if store.Len() != 0 || store.Get(1) != nil {
+				t.Fatal("new store is not empty")
+			}
+
+			for h := 100; h < 200; h++ {
+				if err := store.Put(h, testEntry(h)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if store.Len() != 100 {
+				t.Fatalf("expected 100 entries, got %d", store.Len())
+			}
+			for h := 100; h < 200; h++ {
+				entry := store.Get(h)
+				want := testEntry(h)
+				if entry == nil || !bytes.Equal(entry.Data, want.Data) || !bytes.Equal(entry.Hash, want.Hash) {
+					t.Fatalf("wrong entry at height %d: %v", h, entry)
+				}
+			}
+
+			// Overwrite
+			replacement := &BlockCacheEntry{Data: []byte("replaced"), Hash: []byte{1}}
+			if err := store.Put(150, replacement); err != nil {
+				t.Fatal(err)
+			}
+			if entry := store.Get(150); entry == nil || !bytes.Equal(entry.Data, replacement.Data) {
+				t.Error("overwrite not visible")
+			}
+			if store.Len() != 100 {
+				t.Errorf("overwrite changed Len to %d", store.Len())
+			}
+
+			// Evict
+			store.Evict(100)
+			store.Evict(100)
+			store.Evict(5000)
+			if store.Get(100) != nil {
+				t.Error("evicted entry still present")
+			}
+			if store.Len() != 99 {
+				t.Errorf("expected 99 entries after evict, got %d", store.Len())
+			}
+
+			// Range visits every entry exactly once, and stops when asked to.
+			seen := make(map[int]bool)
+			store.Range(func(height int, entry *BlockCacheEntry) bool {
+				if seen[height] {
+					t.Errorf("height %d visited twice", height)
+				}
+				seen[height] = true
+				if entry == nil || !bytes.Equal(entry.Data, store.Get(height).Data) {
+					t.Errorf("Range returned the wrong entry for height %d", height)
+				}
+				return true
+			})
+			if len(seen) != 99 || seen[100] {
+				t.Errorf("Range visited %d entries", len(seen))
+			}
+
+			visits := 0
+			store.Range(func(height int, entry *BlockCacheEntry) bool {
+				visits++
+				return visits < 10
+			})
+			if visits != 10 {
+				t.Errorf("Range didn't stop early, %d visits", visits)
+			}
+		})
+	}
+}
+
+func testCompactBlock(height int, prevHash []byte) *walletrpc.CompactBlock {
+	return &walletrpc.CompactBlock{
+		Height:   uint64(height),
+		Hash:     []byte(fmt.Sprintf("hash-%d", height)),
+		PrevHash: prevHash,
+	}
+}
+
+func TestMmapStoreReusesSpace(t *testing.T) {
+	store := testStores()["mmap"](t).(*mmapBlockCacheStore)
+	defer cleanupStore(store)
+
+	// A sliding window of blocks of different sizes, as a cache keeping the
+	// latest 50 sees them.
+	entry := func(height int) *BlockCacheEntry {
+		return &BlockCacheEntry{Data: bytes.Repeat([]byte{byte(height)}, 100+height%7*50), Hash: []byte{byte(height)}}
+	}
+	var size int64
+	for h := 0; h < 5000; h++ {
+		if err := store.Put(h, entry(h)); err != nil {
+			t.Fatal(err)
+		}
+		if h >= 50 {
+			store.Evict(h - 50)
+		}
+		if h == 500 {
+			info, err := os.Stat(store.file.Name())
+			if err != nil {
+				t.Fatal(err)
+			}
+			size = info.Size()
+		}
+	}
+	info, err := os.Stat(store.file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != size {
+		t.Errorf("cache file grew from %d to %d bytes holding the same number of blocks", size, info.Size())
+	}
+	for h := 4950; h < 5000; h++ {
+		if got := store.Get(h); got == nil || !bytes.Equal(got.Data, entry(h).Data) {
+			t.Fatalf("wrong entry at height %d after reusing space", h)
+		}
+	}
+
+	// Evicting everything gives the whole file back.
+	for h := 4950; h < 5000; h++ {
+		store.Evict(h)
+	}
+	if store.tail != 0 || len(store.free) != 0 {
+		t.Errorf("empty store has tail %d and free list %v", store.tail, store.free)
+	}
+
+	// Compaction copies the index over the same file.
+	store.Put(1, entry(1))
+	compacted := compactStore(store).(*mmapBlockCacheStore)
+	if compacted == store || compacted.Len() != 1 || !bytes.Equal(compacted.Get(1).Data, entry(1).Data) {
+		t.Error("mmap store not compacted")
+	}
+}
+
+func TestBlockCacheWithStores(t *testing.T) {
+	for name, newStore := range testStores() {
+		t.Run(name, func(t *testing.T) {
+			store := newStore(t)
+			defer cleanupStore(store)
+
+			cache := NewBlockCacheWithStore(10, testLog, store)
+			var prevHash []byte
+			for h := 1000; h < 1020; h++ {
+				block := testCompactBlock(h, prevHash)
+				if err, reorg := cache.Add(h, block); err != nil || reorg {
+					t.Fatalf("adding block %d: err %v reorg %v", h, err, reorg)
+				}
+				prevHash = block.Hash
+			}
+
+			if cache.FirstBlock != 1010 || cache.LastBlock != 1019 || store.Len() != 10 {
+				t.Fatalf("unexpected cache window %d-%d (%d entries)", cache.FirstBlock, cache.LastBlock, store.Len())
+			}
+			if cache.Get(1009) != nil {
+				t.Error("evicted block returned")
+			}
+			if block := cache.Get(1015); block == nil || block.Height != 1015 {
+				t.Errorf("wrong block returned: %v", block)
+			}
+
+			// A block that doesn't connect to its parent is reported as a reorg.
+			if _, reorg := cache.Add(1020, testCompactBlock(1020, []byte("elsewhere"))); !reorg {
+				t.Error("expected a reorg")
+			}
+		})
+	}
+}
+
+func TestTieredBlockCacheStore(t *testing.T) {
+	cold := testStores()["mmap"](t)
+	defer cleanupStore(cold)
+
+	metrics := GetPrometheusMetrics()
+	cache := NewBlockCacheWithStore(100, testLog, NewTieredBlockCacheStore(5, cold, metrics))
+	var prevHash []byte
+	for h := 1000; h < 1050; h++ {
+		block := testCompactBlock(h, prevHash)
+		if err, _ := cache.Add(h, block); err != nil {
+			t.Fatal(err)
+		}
+		prevHash = block.Hash
+	}
+
+	windowHits := metrics.BlockCacheHits.WithLabelValues("window")
+	storeHits := metrics.BlockCacheHits.WithLabelValues("store")
+	// Adding blocks looks up their parent; only count what follows.
+	window, store := testutil.ToFloat64(windowHits), testutil.ToFloat64(storeHits)
+
+	for h := 1045; h < 1050; h++ {
+		if block := cache.Get(h); block == nil || block.Height != uint64(h) {
+			t.Fatalf("wrong block at %d: %v", h, block)
+		}
+	}
+	if got := testutil.ToFloat64(windowHits) - window; got != 5 {
+		t.Errorf("expected 5 window hits for recent blocks, got %v", got)
+	}
+	if testutil.ToFloat64(storeHits) != store {
+		t.Error("recent blocks were served from the store")
+	}
+
+	for h := 1000; h < 1045; h++ {
+		if block := cache.Get(h); block == nil || block.Height != uint64(h) {
+			t.Fatalf("wrong block at %d: %v", h, block)
+		}
+	}
+	if got := testutil.ToFloat64(storeHits) - store; got != 45 {
+		t.Errorf("expected 45 store hits for older blocks, got %v", got)
+	}
+	if got := testutil.ToFloat64(windowHits) - window; got != 5 {
+		t.Errorf("older blocks were served from the window (%v window hits)", got)
+	}
+}
+
+func TestEvictionPolicyHitRate(t *testing.T) {
+	// Every fifth block has shielded outputs; a rescan only needs those.
+	activeBlock := func(height int) bool { return height%5 == 0 }
+	// A wallet following the tip reads each block as it arrives. Every
+	// rescanEvery blocks, if set, a wallet that was away rescans from 1000; a
+	// last one does once the chain stops at 1200. Only the rescans are
+	// counted.
+	hitRate := func(policy EvictionPolicy, rescanEvery int) float64 {
+		cache := NewBlockCache(60, testLog)
+		cache.Eviction = policy
+		cache.ProtectedTip = 10
+
+		hits, requests := 0, 0
+		rescan := func(tip int) {
+			for h := 1000; h <= tip; h++ {
+				if !activeBlock(h) {
+					continue
+				}
+				requests++
+				if block := cache.Get(h); block != nil {
+					if block.Height != uint64(h) {
+						t.Fatalf("wrong block at %d", h)
+					}
+					hits++
+				}
+			}
+		}
+		var prevHash []byte
+		for h := 1000; h < 1200; h++ {
+			block := testCompactBlock(h, prevHash)
+			if activeBlock(h) {
+				block.Vtx = []*walletrpc.CompactTx{{Outputs: []*walletrpc.CompactOutput{{}}}}
+			}
+			if err, reorg := cache.Add(h, block); err != nil || reorg {
+				t.Fatalf("adding block %d: err %v reorg %v", h, err, reorg)
+			}
+			prevHash = block.Hash
+			cache.Get(h)
+			if rescanEvery > 0 && h%rescanEvery == rescanEvery-1 {
+				rescan(h)
+			}
+		}
+		rescan(1199)
+
+		// The tip must stay cached whatever the policy.
+		for h := 1190; h < 1200; h++ {
+			if cache.Get(h) == nil {
+				t.Errorf("protected block %d evicted", h)
+			}
+		}
+		return float64(hits) / float64(requests)
+	}
+
+	// Wallets rescanning often keep the active blocks recently used, so LRU
+	// keeps them as well as weighting by activity does.
+	oldest, recent, active := hitRate(EvictOldest, 20), hitRate(EvictLeastRecent, 20), hitRate(EvictLeastActive, 20)
+	if recent <= oldest || active < recent {
+		t.Errorf("rescans every 20 blocks: expected oldest %v < LRU %v <= activity %v", oldest, recent, active)
+	}
+	// A single rescan, once synced, finds the older blocks LRU dropped for
+	// being old; weighting by activity kept them.
+	oldest, recent, active = hitRate(EvictOldest, 0), hitRate(EvictLeastRecent, 0), hitRate(EvictLeastActive, 0)
+	if recent != oldest || active != 1 {
+		t.Errorf("one rescan: expected LRU %v to do as well as the window %v, and activity %v to hit every block", recent, oldest, active)
+	}
+}
+
+func TestEvictLeastRecent(t *testing.T) {
+	cache := NewBlockCache(10, testLog)
+	cache.Eviction = EvictLeastRecent
+	cache.ProtectedTip = 3
+	add := func(h int, prevHash []byte) []byte {
+		block := testCompactBlock(h, prevHash)
+		if err, reorg := cache.Add(h, block); err != nil || reorg {
+			t.Fatalf("adding block %d: err %v reorg %v", h, err, reorg)
+		}
+		return block.Hash
+	}
+	var prevHash []byte
+	for h := 1000; h < 1010; h++ {
+		prevHash = add(h, prevHash)
+	}
+	// Reading 1000 and 1001 makes 1002 the least recently used.
+	cache.Get(1001)
+	cache.Get(1000)
+	for h := 1010; h < 1013; h++ {
+		prevHash = add(h, prevHash)
+	}
+	for h, cached := range map[int]bool{1000: true, 1001: true, 1002: false, 1003: false, 1004: false, 1005: true} {
+		if (cache.Get(h) != nil) != cached {
+			t.Errorf("block %d: expected cached %v", h, cached)
+		}
+	}
+}
+
+func TestEvictLeastActiveReorg(t *testing.T) {
+	cache := NewBlockCache(10, testLog)
+	cache.Eviction = EvictLeastActive
+	cache.ProtectedTip = 3
+	var prevHash []byte
+	for h := 1000; h < 1010; h++ {
+		block := testCompactBlock(h, prevHash)
+		if h != 1006 {
+			block.Vtx = []*walletrpc.CompactTx{{Outputs: []*walletrpc.CompactOutput{{}}}}
+		}
+		if err, reorg := cache.Add(h, block); err != nil || reorg {
+			t.Fatalf("adding block %d: err %v reorg %v", h, err, reorg)
+		}
+		prevHash = block.Hash
+	}
+	// A reorg back to 1007 puts the empty 1006 within the protected tip.
+	block := testCompactBlock(1008, []byte("hash-1007"))
+	block.Hash = []byte("other-1008")
+	if err, reorg := cache.Add(1008, block); err != nil || reorg {
+		t.Fatalf("replacing block 1008: err %v reorg %v", err, reorg)
+	}
+	if _, queued := cache.queue.index[1006]; queued || cache.queue.Len() != 6 {
+		t.Errorf("expected 1000 to 1005 evictable after the reorg, got %d blocks, 1006 queued %v", cache.queue.Len(), queued)
+	}
+	next := testCompactBlock(1009, block.Hash)
+	next.Vtx = []*walletrpc.CompactTx{{Outputs: []*walletrpc.CompactOutput{{}}}}
+	if err, reorg := cache.Add(1009, next); err != nil || reorg {
+		t.Fatalf("adding block 1009: err %v reorg %v", err, reorg)
+	}
+	// Once the tip is past it again, it's the first to go.
+	next = testCompactBlock(1010, next.Hash)
+	if err, reorg := cache.Add(1010, next); err != nil || reorg {
+		t.Fatalf("adding block 1010: err %v reorg %v", err, reorg)
+	}
+	if cache.Get(1006) != nil || cache.Get(1000) == nil {
+		t.Error("expected the empty block, and only it, to be evicted")
+	}
+}
+
+func TestBlockCacheGetByTime(t *testing.T) {
+	// Blocks a minute apart, every fourth with shielded outputs, in a cache
+	// that drops the others first, leaving holes.
+	cache := NewBlockCache(40, testLog)
+	cache.Eviction = EvictLeastActive
+	cache.ProtectedTip = 10
+	var prevHash []byte
+	for h := 1000; h < 1100; h++ {
+		block := testCompactBlock(h, prevHash)
+		block.Time = uint32(1600000000 + 60*(h-1000))
+		if h%4 == 0 {
+			block.Vtx = []*walletrpc.CompactTx{{Outputs: []*walletrpc.CompactOutput{{}}}}
+		}
+		if err, reorg := cache.Add(h, block); err != nil || reorg {
+			t.Fatalf("adding block %d: err %v reorg %v", h, err, reorg)
+		}
+		prevHash = block.Hash
+	}
+	if cache.Get(1001) != nil || cache.Get(1004) == nil {
+		t.Fatal("expected the inactive blocks to be evicted")
+	}
+
+	for _, tt := range []struct {
+		time   uint32
+		height int
+	}{
+		{1600000000, 1000},
+		{1600000001, 1004},
+		{1600000000 + 60, 1004},
+		{1600000000 + 60*41, 1044},
+		{1600000000 + 60*86, 1086},
+		{1600000000 + 60*90, 1090},
+		{1600000000 + 60*95, 1095},
+		{1600000000 + 60*99, 1099},
+		{1600000000 + 60*99 + 1, -1},
+	} {
+		if height := cache.GetByTime(tt.time); height != tt.height {
+			t.Errorf("time %d: got %d, expected %d", tt.time, height, tt.height)
+		}
+	}
+}
+
+func TestBlockCacheHashIndex(t *testing.T) {
+	for name, newStore := range testStores() {
+		t.Run(name, func(t *testing.T) {
+			store := newStore(t)
+			defer cleanupStore(store)
+
+			cache := NewBlockCacheWithStore(10, testLog, store)
+			cache.EnableHashIndex()
+			var prevHash []byte
+			for h := 1000; h < 1020; h++ {
+				block := testCompactBlock(h, prevHash)
+				if err, _ := cache.Add(h, block); err != nil {
+					t.Fatal(err)
+				}
+				prevHash = block.Hash
+			}
+
+			if block := cache.GetByHash([]byte("hash-1015")); block == nil || block.Height != 1015 {
+				t.Errorf("wrong block for hash-1015: %v", block)
+			}
+			if block := cache.GetByHash([]byte("hash-1005")); block != nil {
+				t.Errorf("evicted block found by hash: %v", block)
+			}
+			if len(cache.hashes) != 10 {
+				t.Errorf("hash index has %d entries for 10 cached blocks", len(cache.hashes))
+			}
+
+			// A reorg replaces the blocks above the fork in the index.
+			replacement := testCompactBlock(1018, []byte("hash-1017"))
+			replacement.Hash = []byte("other-1018")
+			if err, reorg := cache.Add(1018, replacement); err != nil || reorg {
+				t.Fatalf("err %v reorg %v", err, reorg)
+			}
+			for _, hash := range []string{"hash-1018", "hash-1019"} {
+				if block := cache.GetByHash([]byte(hash)); block != nil {
+					t.Errorf("reorged block %s found by hash", hash)
+				}
+			}
+			if block := cache.GetByHash([]byte("other-1018")); block == nil || block.Height != 1018 {
+				t.Errorf("wrong block for the new 1018: %v", block)
+			}
+			if len(cache.hashes) != 9 {
+				t.Errorf("hash index has %d entries for 9 cached blocks", len(cache.hashes))
+			}
+		})
+	}
+}
+
+// heapAlloc returns the bytes allocated on the heap after a full collection.
+func heapAlloc() uint64 {
+	var stats runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&stats)
+	return stats.HeapAlloc
+}
+
+func TestBlockCacheCompaction(t *testing.T) {
+	cache := NewBlockCache(200000, testLog)
+	cache.EnableHashIndex()
+	addChain := func(from, to int) {
+		prevHash := []byte(fmt.Sprintf("hash-%d", from-1))
+		for h := from; h < to; h++ {
+			block := testCompactBlock(h, prevHash)
+			if err, reorg := cache.Add(h, block); err != nil || reorg {
+				t.Fatalf("adding %d: err %v reorg %v", h, err, reorg)
+			}
+			prevHash = block.Hash
+		}
+	}
+
+	// Reorging back to 100 leaves the maps sized for the 100000 blocks
+	// cached before.
+	addChain(0, 100000)
+	addChain(100, 101)
+	if cache.reorgEvictions != 99900 {
+		t.Fatalf("counted %d reorg evictions, expected 99900", cache.reorgEvictions)
+	}
+
+	before := heapAlloc()
+	if !cache.compact() {
+		t.Fatal("compaction didn't happen")
+	}
+	after := heapAlloc()
+	if before < after || before-after < 1<<20 {
+		t.Errorf("compaction reclaimed too little memory, heap went from %d to %d bytes", before, after)
+	}
+	if cache.reorgEvictions != 0 {
+		t.Error("reorg evictions not reset by the compaction")
+	}
+	for h := 0; h <= 100; h += 50 {
+		if block := cache.Get(h); block == nil || block.Height != uint64(h) {
+			t.Errorf("wrong block at %d after compaction: %v", h, block)
+		}
+		if block := cache.GetByHash([]byte(fmt.Sprintf("hash-%d", h))); block == nil {
+			t.Errorf("block %d not found by hash after compaction", h)
+		}
+	}
+	if cache.store.Len() != 101 || len(cache.activity) != 101 || len(cache.hashes) != 101 {
+		t.Errorf("expected 101 blocks, store has %d, activity %d, hashes %d", cache.store.Len(), len(cache.activity), len(cache.hashes))
+	}
+	runtime.KeepAlive(cache)
+}
+
+func TestBlockCacheAutomaticCompaction(t *testing.T) {
+	metrics := GetPrometheusMetrics()
+	cache := NewBlockCache(100, testLog)
+	cache.EnableCompaction(20, metrics)
+
+	prevHash := []byte(nil)
+	for h := 0; h < 30; h++ {
+		block := testCompactBlock(h, prevHash)
+		cache.Add(h, block)
+		prevHash = block.Hash
+	}
+	if testutil.ToFloat64(metrics.CacheCompactions) != 0 {
+		t.Fatal("compacted before any reorg")
+	}
+
+	// Dropping 20 blocks reaches the threshold.
+	cache.Add(10, testCompactBlock(10, []byte("hash-9")))
+	deadline := time.Now().Add(5 * time.Second)
+	for testutil.ToFloat64(metrics.CacheCompactions) != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("cache not compacted after the reorg")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if block := cache.Get(10); block == nil || block.Height != 10 {
+		t.Errorf("wrong block at 10 after compaction: %v", block)
+	}
+}
+
+func TestBlockCacheSubscribe(t *testing.T) {
+	cache := NewBlockCache(100, testLog)
+	if err, _ := cache.Add(1000, testCompactBlock(1000, nil)); err != nil {
+		t.Fatal(err)
+	}
+
+	blocks, tip, unsubscribe := cache.Subscribe()
+	slow, _, _ := cache.Subscribe()
+	if tip != 1000 {
+		t.Errorf("subscribed at tip %d, expected 1000", tip)
+	}
+
+	prevHash := []byte("hash-1000")
+	for h := 1001; h <= 1001+subscriberBuffer; h++ {
+		block := testCompactBlock(h, prevHash)
+		if err, _ := cache.Add(h, block); err != nil {
+			t.Fatal(err)
+		}
+		prevHash = block.Hash
+		if block := <-blocks; block.Height != uint64(h) {
+			t.Fatalf("received block %d, expected %d", block.Height, h)
+		}
+	}
+
+	// The subscriber that never read fell behind and was dropped.
+	for range slow {
+	}
+	if len(cache.subscribers) != 1 {
+		t.Errorf("%d subscribers left, expected 1", len(cache.subscribers))
+	}
+	unsubscribe()
+	if _, ok := <-blocks; ok || len(cache.subscribers) != 0 {
+		t.Error("unsubscribing didn't close the channel")
+	}
+}
+
+func TestBlockCacheSubscribeReorgs(t *testing.T) {
+	cache := NewBlockCache(100, testLog)
+	var prevHash []byte
+	for h := 1000; h <= 1010; h++ {
+		block := testCompactBlock(h, prevHash)
+		if err, _ := cache.Add(h, block); err != nil {
+			t.Fatal(err)
+		}
+		prevHash = block.Hash
+	}
+	reorgs, unsubscribe := cache.SubscribeReorgs()
+	defer unsubscribe()
+
+	// The ingestor walks back until the new chain links up: first to 1008,
+	// where it doesn't, then to 1005, where it does.
+	fork := testCompactBlock(1008, []byte("other"))
+	if err, reorg := cache.Add(1008, fork); err != nil || !reorg {
+		t.Fatalf("expected a reorg at 1008, got %v, %v", err, reorg)
+	}
+	replacement := testCompactBlock(1005, []byte("hash-1004"))
+	replacement.Hash = []byte("new-1005")
+	if err, reorg := cache.Add(1005, replacement); err != nil || reorg {
+		t.Fatalf("expected 1005 to be added, got %v, %v", err, reorg)
+	}
+
+	select {
+	case reorg := <-reorgs:
+		if reorg.Ancestor != 1004 || reorg.Tip != 1005 || string(reorg.TipHash) != "new-1005" {
+			t.Errorf("got reorg %+v, expected back to 1004 and on to new-1005", reorg)
+		}
+	default:
+		t.Fatal("no reorg reported")
+	}
+
+	// Extending the chain isn't a reorg.
+	if err, _ := cache.Add(1006, testCompactBlock(1006, []byte("new-1005"))); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case reorg := <-reorgs:
+		t.Errorf("unexpected reorg %+v", reorg)
+	default:
+	}
+}
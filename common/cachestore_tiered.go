@@ -0,0 +1,81 @@
+package common
+
+// tieredBlockCacheStore keeps the most recent blocks in memory in front of
+// another BlockCacheStore, which holds all of them. Most requests are for
+// blocks near the tip, so this keeps those fast while older blocks stay in
+// the (slower, but cheaper) backing store.
+type tieredBlockCacheStore struct {
+	window  int
+	top     int
+	hot     *memoryBlockCacheStore
+	cold    BlockCacheStore
+	metrics *PrometheusMetrics
+}
+
+// NewTieredBlockCacheStore puts an in-memory window of the latest window
+// blocks in front of cold. Hits in each tier are counted in metrics.
+func NewTieredBlockCacheStore(window int, cold BlockCacheStore, metrics *PrometheusMetrics) BlockCacheStore {
+	return &tieredBlockCacheStore{
+		window:  window,
+		top:     -1,
+		hot:     NewMemoryBlockCacheStore().(*memoryBlockCacheStore),
+		cold:    cold,
+		metrics: metrics,
+	}
+}
+
+func (s *tieredBlockCacheStore) Get(height int) *BlockCacheEntry {
+	if entry := s.hot.Get(height); entry != nil {
+		s.metrics.BlockCacheHits.WithLabelValues("window").Inc()
+		return entry
+	}
+	entry := s.cold.Get(height)
+	if entry != nil {
+		s.metrics.BlockCacheHits.WithLabelValues("store").Inc()
+	}
+	return entry
+}
+
+func (s *tieredBlockCacheStore) Put(height int, entry *BlockCacheEntry) error {
+	if err := s.cold.Put(height, entry); err != nil {
+		return err
+	}
+
+	if height > s.top {
+		s.top = height
+	}
+	if height > s.top-s.window {
+		s.hot.Put(height, entry)
+	}
+	if s.hot.Len() > s.window {
+		for h := range s.hot.m {
+			if h <= s.top-s.window {
+				s.hot.Evict(h)
+			}
+		}
+	}
+	return nil
+}
+
+func (s *tieredBlockCacheStore) Evict(height int) {
+	s.hot.Evict(height)
+	s.cold.Evict(height)
+}
+
+func (s *tieredBlockCacheStore) Len() int {
+	return s.cold.Len()
+}
+
+func (s *tieredBlockCacheStore) Range(f func(height int, entry *BlockCacheEntry) bool) {
+	s.cold.Range(f)
+}
+
+func (s *tieredBlockCacheStore) compacted() BlockCacheStore {
+	return &tieredBlockCacheStore{
+		window:  s.window,
+		top:     s.top,
+		hot:     s.hot.compacted().(*memoryBlockCacheStore),
+		cold:    compactStore(s.cold),
+		metrics: s.metrics,
+	}
+}
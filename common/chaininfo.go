@@ -0,0 +1,240 @@
+package common
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// saplingBranchID is the key of the Sapling network upgrade in
+// getblockchaininfo's upgrades.
+const saplingBranchID = "6f76727a"
+
+// ChainUpgrade is a network upgrade as reported by getblockchaininfo.
+type ChainUpgrade struct {
+	Name             string `json:"name"`
+	ActivationHeight int    `json:"activationheight"`
+	Status           string `json:"status"`
+}
+
+// ValuePool is the value held in a pool ("transparent", "sprout",
+// "sapling") as reported by getblockchaininfo. ChainValueZat is only known
+// if the node monitors the pool.
+type ValuePool struct {
+	ID            string `json:"id"`
+	Monitored     bool   `json:"monitored"`
+	ChainValueZat int64  `json:"chainValueZat"`
+}
+
+// ChainInfo is the part of zcashd's getblockchaininfo used by lightwalletd.
+type ChainInfo struct {
+	Chain                string                  `json:"chain"`
+	Blocks               int                     `json:"blocks"`
+	Headers              int                     `json:"headers"`
+	InitialBlockDownload bool                    `json:"initialblockdownload"`
+	VerificationProgress float64                 `json:"verificationprogress"`
+	EstimatedHeight      int                     `json:"estimatedheight"`
+	Upgrades             map[string]ChainUpgrade `json:"upgrades"`
+	ValuePools           []ValuePool             `json:"valuePools"`
+	ChainSupply          *ValuePool              `json:"chainSupply"` // only reported by newer nodes
+	Consensus            struct {
+		ChainTip  string `json:"chaintip"`
+		NextBlock string `json:"nextblock"`
+	} `json:"consensus"`
+}
+
+// GetChainInfo calls getblockchaininfo.
+func GetChainInfo(rpcClient RPCClient) (*ChainInfo, error) {
+	result, rpcErr := rpcClient.RawRequest("getblockchaininfo", make([]json.RawMessage, 0))
+	if rpcErr != nil {
+		return nil, errors.Wrap(rpcErr, "error requesting blockchain info")
+	}
+
+	info := &ChainInfo{}
+	if err := json.Unmarshal(result, info); err != nil {
+		return nil, errors.Wrap(err, "error reading JSON response")
+	}
+	return info, nil
+}
+
+// SaplingHeight returns the Sapling activation height, or -1 if the node
+// doesn't have Sapling scheduled.
+func (info *ChainInfo) SaplingHeight() int {
+	upgrade, ok := info.Upgrades[saplingBranchID]
+	if !ok {
+		return -1
+	}
+	return upgrade.ActivationHeight
+}
+
+// UpgradeHeight returns the activation height of the network upgrade called
+// name ("nu5" for example, in any case), or -1 if the node doesn't have it
+// scheduled.
+func (info *ChainInfo) UpgradeHeight(name string) int {
+	for _, upgrade := range info.Upgrades {
+		if strings.EqualFold(upgrade.Name, name) {
+			return upgrade.ActivationHeight
+		}
+	}
+	return -1
+}
+
+// supplyPools are the pools that together hold the whole supply, for nodes
+// that don't report it.
+var supplyPools = []string{"transparent", "sprout", "sapling"}
+
+// TotalSupply returns the coins in existence, in zatoshis, and whether
+// they're known: from chainSupply, or else the sum of the supplyPools if the
+// node monitors them all.
+func (info *ChainInfo) TotalSupply() (int64, bool) {
+	if info.ChainSupply != nil {
+		return info.ChainSupply.ChainValueZat, info.ChainSupply.Monitored
+	}
+	var total int64
+	for _, id := range supplyPools {
+		found := false
+		for _, pool := range info.ValuePools {
+			if pool.ID == id && pool.Monitored {
+				total += pool.ChainValueZat
+				found = true
+			}
+		}
+		if !found {
+			return 0, false
+		}
+	}
+	return total, true
+}
+
+// sproutBranchID is the consensus branch ID before any network upgrade.
+const sproutBranchID = "00000000"
+
+// BranchID returns the consensus branch ID in effect at height, from the
+// upgrades the node has scheduled, and the upgrade's name, "" before the
+// first one. Disabled upgrades are ignored.
+func (info *ChainInfo) BranchID(height int) (string, string) {
+	branchID, name, activation := sproutBranchID, "", -1
+	for id, upgrade := range info.Upgrades {
+		if upgrade.Status == "disabled" || upgrade.ActivationHeight > height || upgrade.ActivationHeight < activation {
+			continue
+		}
+		branchID, name, activation = id, upgrade.Name, upgrade.ActivationHeight
+	}
+	return branchID, name
+}
+
+// orchardUpgrade is the network upgrade that activates Orchard.
+const orchardUpgrade = "nu5"
+
+// setChainMetrics sets the activation height and branch ID gauges from info.
+func setChainMetrics(metrics *PrometheusMetrics, info *ChainInfo) {
+	metrics.SaplingActivationHeight.Set(float64(info.SaplingHeight()))
+	metrics.OrchardActivationHeight.Set(float64(info.UpgradeHeight(orchardUpgrade)))
+	if branchID, err := strconv.ParseUint(info.Consensus.ChainTip, 16, 32); err == nil {
+		metrics.ConsensusBranchID.Set(float64(branchID))
+	}
+}
+
+// Synced reports whether the node has finished its initial block download
+// and verified at least minProgress (0 to 1) of the chain.
+func (info *ChainInfo) Synced(minProgress float64) bool {
+	return !info.InitialBlockDownload && info.VerificationProgress >= minProgress
+}
+
+// NodeStatus is what lightwalletd last learned about its node.
+type NodeStatus struct {
+	// Chain is the last getblockchaininfo answer, nil if there was none yet.
+	Chain *ChainInfo
+	// Version, Subversion and MempoolSize are empty if the node didn't
+	// answer getnetworkinfo or getmempoolinfo.
+	Version     int
+	Subversion  string
+	MempoolSize int
+	// Reachable reports whether the node answered the last refresh.
+	Reachable bool
+	Updated   time.Time
+}
+
+// NodeStatusCache keeps the latest NodeStatus, refreshed in the background,
+// so that it can be read without waiting on the node, or while it's down.
+type NodeStatusCache struct {
+	// Metrics, if not nil, has its activation height and branch ID gauges
+	// set on each refresh.
+	Metrics *PrometheusMetrics
+
+	rpcClient RPCClient
+	log       *logrus.Entry
+
+	mutex  sync.RWMutex
+	status NodeStatus
+}
+
+func NewNodeStatusCache(rpcClient RPCClient, log *logrus.Entry) *NodeStatusCache {
+	return &NodeStatusCache{rpcClient: rpcClient, log: log}
+}
+
+// Refresh asks the node for its status. If it can't be reached the previous
+// answers are kept, and the status is marked unreachable.
+func (c *NodeStatusCache) Refresh() error {
+	chain, err := GetChainInfo(c.rpcClient)
+	if err != nil {
+		c.mutex.Lock()
+		c.status.Reachable = false
+		c.mutex.Unlock()
+		return err
+	}
+
+	if c.Metrics != nil {
+		setChainMetrics(c.Metrics, chain)
+	}
+
+	status := NodeStatus{
+		Chain:     chain,
+		Reachable: true,
+		Updated:   time.Now(),
+	}
+	var network struct {
+		Version    int    `json:"version"`
+		Subversion string `json:"subversion"`
+	}
+	if result, err := c.rpcClient.RawRequest("getnetworkinfo", make([]json.RawMessage, 0)); err == nil && json.Unmarshal(result, &network) == nil {
+		status.Version = network.Version
+		status.Subversion = network.Subversion
+	}
+	var mempool struct {
+		Size int `json:"size"`
+	}
+	if result, err := c.rpcClient.RawRequest("getmempoolinfo", make([]json.RawMessage, 0)); err == nil && json.Unmarshal(result, &mempool) == nil {
+		status.MempoolSize = mempool.Size
+	}
+
+	c.mutex.Lock()
+	c.status = status
+	c.mutex.Unlock()
+	return nil
+}
+
+// Run refreshes the status every interval, forever.
+func (c *NodeStatusCache) Run(interval time.Duration) {
+	for {
+		if err := c.Refresh(); err != nil {
+			c.log.WithFields(logrus.Fields{
+				"error": err,
+			}).Warn("node status refresh failed")
+		}
+		time.Sleep(interval)
+	}
+}
+
+// Get returns the latest status.
+func (c *NodeStatusCache) Get() NodeStatus {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return c.status
+}
@@ -0,0 +1,97 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestChainMetrics(t *testing.T) {
+	node := cannedNode{"getblockchaininfo": `{
+		"chain": "main",
+		"upgrades": {
+			"6f76727a": {"name": "Sapling", "activationheight": 419200},
+			"c2d6d0b4": {"name": "NU5", "activationheight": 1687104}
+		},
+		"consensus": {"chaintip": "c2d6d0b4", "nextblock": "c2d6d0b4"}
+	}`}
+	metrics := GetPrometheusMetrics()
+	nodeStatus := NewNodeStatusCache(node, testLog)
+	nodeStatus.Metrics = metrics
+	if err := nodeStatus.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+
+	if h := testutil.ToFloat64(metrics.SaplingActivationHeight); h != 419200 {
+		t.Errorf("sapling activation height gauge is %v", h)
+	}
+	if h := testutil.ToFloat64(metrics.OrchardActivationHeight); h != 1687104 {
+		t.Errorf("orchard activation height gauge is %v", h)
+	}
+	if id := testutil.ToFloat64(metrics.ConsensusBranchID); id != 0xc2d6d0b4 {
+		t.Errorf("branch ID gauge is %x", uint32(id))
+	}
+
+	// Before NU5 is scheduled.
+	node["getblockchaininfo"] = `{
+		"upgrades": {"6f76727a": {"name": "Sapling", "activationheight": 419200}},
+		"consensus": {"chaintip": "e9ff75a6"}
+	}`
+	if err := nodeStatus.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	if h := testutil.ToFloat64(metrics.OrchardActivationHeight); h != -1 {
+		t.Errorf("orchard activation height gauge is %v before NU5 is scheduled", h)
+	}
+	if id := testutil.ToFloat64(metrics.ConsensusBranchID); id != 0xe9ff75a6 {
+		t.Errorf("branch ID gauge is %x", uint32(id))
+	}
+}
+
+func TestBranchID(t *testing.T) {
+	info := &ChainInfo{Upgrades: map[string]ChainUpgrade{
+		"5ba81b19": {Name: "Overwinter", ActivationHeight: 347500, Status: "active"},
+		"6f76727a": {Name: "Sapling", ActivationHeight: 419200, Status: "active"},
+		"c2d6d0b4": {Name: "NU5", ActivationHeight: 1687104, Status: "pending"},
+		"deadbeef": {Name: "Test", ActivationHeight: 1000000, Status: "disabled"},
+	}}
+	for _, tt := range []struct {
+		height   int
+		branchID string
+		name     string
+	}{
+		{0, "00000000", ""},
+		{347499, "00000000", ""},
+		{347500, "5ba81b19", "Overwinter"},
+		{419199, "5ba81b19", "Overwinter"},
+		{1000000, "6f76727a", "Sapling"},
+		{1687104, "c2d6d0b4", "NU5"},
+	} {
+		if branchID, name := info.BranchID(tt.height); branchID != tt.branchID || name != tt.name {
+			t.Errorf("height %d: got %s (%s), expected %s (%s)", tt.height, branchID, name, tt.branchID, tt.name)
+		}
+	}
+}
+
+func TestTotalSupply(t *testing.T) {
+	pools := []ValuePool{
+		{ID: "transparent", Monitored: true, ChainValueZat: 1000},
+		{ID: "sprout", Monitored: true, ChainValueZat: 200},
+		{ID: "sapling", Monitored: true, ChainValueZat: 30},
+	}
+	for _, tt := range []struct {
+		name   string
+		info   ChainInfo
+		supply int64
+		known  bool
+	}{
+		{"all pools", ChainInfo{ValuePools: pools}, 1230, true},
+		{"no transparent pool", ChainInfo{ValuePools: pools[1:]}, 0, false},
+		{"unmonitored pool", ChainInfo{ValuePools: []ValuePool{pools[0], {ID: "sprout"}, pools[2]}}, 0, false},
+		{"chain supply", ChainInfo{ValuePools: pools[1:], ChainSupply: &ValuePool{Monitored: true, ChainValueZat: 1300}}, 1300, true},
+	} {
+		if supply, known := tt.info.TotalSupply(); supply != tt.supply || known != tt.known {
+			t.Errorf("%s: got %d (%v), expected %d (%v)", tt.name, supply, known, tt.supply, tt.known)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package common
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/adityapk00/lightwalletd/parser"
+	"github.com/adityapk00/lightwalletd/walletrpc"
+	"github.com/pkg/errors"
+)
+
+// CheckpointIndex keeps a Checkpoint for every Interval'th block, so that a
+// wallet restoring from a birthday can seek straight to a nearby block instead
+// of scanning from Sapling activation. It is built by the ingestors as blocks
+// arrive, and optionally saved to a file so it survives restarts.
+//
+// All methods may be called on a nil *CheckpointIndex, which is empty.
+type CheckpointIndex struct {
+	Interval int
+
+	path        string
+	checkpoints map[int]*walletrpc.Checkpoint
+	mutex       sync.RWMutex
+}
+
+// NewCheckpointIndex creates an index with a checkpoint every interval blocks.
+// If path is not empty, checkpoints already saved there are loaded, and the
+// index is saved back there whenever it changes.
+func NewCheckpointIndex(interval int, path string) (*CheckpointIndex, error) {
+	if interval <= 0 {
+		return nil, errors.New("checkpoint interval must be positive")
+	}
+
+	idx := &CheckpointIndex{
+		Interval:    interval,
+		path:        path,
+		checkpoints: make(map[int]*walletrpc.Checkpoint),
+	}
+	if path == "" {
+		return idx, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return idx, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading checkpoint file")
+	}
+	var saved []*walletrpc.Checkpoint
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return nil, errors.Wrap(err, "error parsing checkpoint file")
+	}
+	for _, checkpoint := range saved {
+		// The interval may have changed since the file was written.
+		if int(checkpoint.Height)%interval == 0 {
+			idx.checkpoints[int(checkpoint.Height)] = checkpoint
+		}
+	}
+	return idx, nil
+}
+
+// Add records a checkpoint for block if its height falls on the interval.
+func (idx *CheckpointIndex) Add(block *parser.Block) error {
+	if idx == nil || block.GetHeight()%idx.Interval != 0 {
+		return nil
+	}
+
+	idx.mutex.Lock()
+	defer idx.mutex.Unlock()
+
+	idx.checkpoints[block.GetHeight()] = &walletrpc.Checkpoint{
+		Height:          uint64(block.GetHeight()),
+		Hash:            block.GetEncodableHash(),
+		SaplingTreeRoot: block.GetSaplingRoot(),
+	}
+	return idx.save()
+}
+
+// RemoveAbove drops the checkpoints above height, after a reorg.
+func (idx *CheckpointIndex) RemoveAbove(height int) error {
+	if idx == nil {
+		return nil
+	}
+
+	idx.mutex.Lock()
+	defer idx.mutex.Unlock()
+
+	removed := false
+	for h := range idx.checkpoints {
+		if h > height {
+			delete(idx.checkpoints, h)
+			removed = true
+		}
+	}
+	if !removed {
+		return nil
+	}
+	return idx.save()
+}
+
+// Get returns the checkpoint at height, or nil if there isn't one.
+func (idx *CheckpointIndex) Get(height int) *walletrpc.Checkpoint {
+	if idx == nil {
+		return nil
+	}
+
+	idx.mutex.RLock()
+	defer idx.mutex.RUnlock()
+
+	return idx.checkpoints[height]
+}
+
+// Checkpoints returns all the checkpoints in height order.
+func (idx *CheckpointIndex) Checkpoints() []*walletrpc.Checkpoint {
+	if idx == nil {
+		return nil
+	}
+
+	idx.mutex.RLock()
+	defer idx.mutex.RUnlock()
+
+	return idx.sorted()
+}
+
+func (idx *CheckpointIndex) sorted() []*walletrpc.Checkpoint {
+	checkpoints := make([]*walletrpc.Checkpoint, 0, len(idx.checkpoints))
+	for _, checkpoint := range idx.checkpoints {
+		checkpoints = append(checkpoints, checkpoint)
+	}
+	sort.Slice(checkpoints, func(i, j int) bool {
+		return checkpoints[i].Height < checkpoints[j].Height
+	})
+	return checkpoints
+}
+
+// save writes the index to its file, if it has one. The file is replaced
+// atomically so that a crash never leaves a truncated index behind.
+func (idx *CheckpointIndex) save() error {
+	if idx.path == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(idx.sorted())
+	if err != nil {
+		return err
+	}
+	return errors.Wrap(writeFileAtomic(idx.path, data), "error saving checkpoints")
+}
+
+// GetCheckpoint returns the checkpoint for the block at height, whose hash
+// is expected to be hash. It comes from the cache's index if that agrees,
+// otherwise from the full block.
+func GetCheckpoint(rpcClient RPCClient, cache *BlockCache, height int, hash []byte) (*walletrpc.Checkpoint, error) {
+	if checkpoint := cache.Checkpoints.Get(height); checkpoint != nil && bytes.Equal(checkpoint.Hash, hash) {
+		return checkpoint, nil
+	}
+
+	block, err := getParsedBlockFromRPC(rpcClient, height)
+	if err != nil {
+		return nil, err
+	}
+	if block == nil || !bytes.Equal(block.GetEncodableHash(), hash) {
+		return nil, errors.Errorf("block %d changed, reorg in progress?", height)
+	}
+	return &walletrpc.Checkpoint{
+		Height:          uint64(height),
+		Hash:            block.GetEncodableHash(),
+		SaplingTreeRoot: block.GetSaplingRoot(),
+	}, nil
+}
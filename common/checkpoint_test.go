@@ -0,0 +1,149 @@
+package common
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/btcjson"
+
+	"github.com/adityapk00/lightwalletd/parser"
+)
+
+// fixtureNode serves getblock from testdata/compact_blocks.json, taking delay
+// to answer, and counts the requests for each height.
+type fixtureNode struct {
+	blocks map[int]string
+	delay  time.Duration
+
+	mutex sync.Mutex
+	calls map[int]int
+}
+
+func newFixtureNode(t *testing.T) *fixtureNode {
+	var fixtures []struct {
+		Height int    `json:"block"`
+		Full   string `json:"full"`
+	}
+	data, err := ioutil.ReadFile("../testdata/compact_blocks.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, &fixtures); err != nil {
+		t.Fatal(err)
+	}
+
+	node := &fixtureNode{blocks: make(map[int]string), calls: make(map[int]int)}
+	for _, fixture := range fixtures {
+		node.blocks[fixture.Height] = fixture.Full
+	}
+	return node
+}
+
+func (n *fixtureNode) RawRequest(method string, params []json.RawMessage) (json.RawMessage, error) {
+	var arg string
+	json.Unmarshal(params[0], &arg)
+	height, _ := strconv.Atoi(arg)
+
+	n.mutex.Lock()
+	n.calls[height]++
+	block, ok := n.blocks[height]
+	n.mutex.Unlock()
+	time.Sleep(n.delay)
+
+	if method != "getblock" || !ok {
+		return nil, &btcjson.RPCError{Code: -8, Message: "Block height out of range"}
+	}
+	return json.Marshal(block)
+}
+
+func (n *fixtureNode) parsed(t *testing.T, height int) *parser.Block {
+	n.mutex.Lock()
+	data, _ := hex.DecodeString(n.blocks[height])
+	n.mutex.Unlock()
+	block := parser.NewBlock()
+	if _, err := block.ParseFromSlice(data); err != nil {
+		t.Fatal(err)
+	}
+	return block
+}
+
+func TestCheckpointIndexFromIngestor(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lightwalletd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "checkpoints.json")
+
+	node := newFixtureNode(t)
+	cache := NewBlockCache(100, testLog)
+	cache.Checkpoints, err = NewCheckpointIndex(2, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The historical ingestor works back from the tip the live one started at.
+	if err, _ := cache.Add(289465, node.parsed(t, 289465).ToCompact()); err != nil {
+		t.Fatal(err)
+	}
+	HistoricalBlockIngestor(node, cache, testLog, 289464, 100, 289459)
+	if cache.FirstBlock != 289460 {
+		t.Fatalf("historical ingestor stopped at %d", cache.FirstBlock)
+	}
+
+	checkpoints := cache.Checkpoints.Checkpoints()
+	if len(checkpoints) != 3 {
+		t.Fatalf("expected 3 checkpoints, got %d", len(checkpoints))
+	}
+	for i, checkpoint := range checkpoints {
+		height := 289460 + 2*i
+		if checkpoint.Height != uint64(height) {
+			t.Errorf("checkpoint %d at height %d, expected %d", i, checkpoint.Height, height)
+		}
+		block := node.parsed(t, height)
+		if !bytes.Equal(checkpoint.Hash, block.GetEncodableHash()) {
+			t.Errorf("checkpoint %d has the wrong hash", height)
+		}
+		if !bytes.Equal(checkpoint.SaplingTreeRoot, block.GetSaplingRoot()) {
+			t.Errorf("checkpoint %d has the wrong tree root", height)
+		}
+	}
+
+	// Checkpoints survive a restart.
+	reloaded, err := NewCheckpointIndex(2, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reloaded.Checkpoints()) != 3 || !bytes.Equal(reloaded.Checkpoints()[2].Hash, checkpoints[2].Hash) {
+		t.Error("checkpoints not reloaded from file")
+	}
+
+	// A reorg drops the checkpoints above the fork point.
+	if _, reorg := cache.Add(289462, node.parsed(t, 289464).ToCompact()); !reorg {
+		t.Error("expected a reorg")
+	}
+	if checkpoints := cache.Checkpoints.Checkpoints(); len(checkpoints) != 1 || checkpoints[0].Height != 289460 {
+		t.Errorf("unexpected checkpoints after reorg: %v", checkpoints)
+	}
+}
+
+func TestCheckpointIndexNil(t *testing.T) {
+	var idx *CheckpointIndex
+	if err := idx.Add(parser.NewBlock()); err != nil {
+		t.Error(err)
+	}
+	if idx.Checkpoints() != nil {
+		t.Error("nil index has checkpoints")
+	}
+	if _, err := NewCheckpointIndex(0, ""); err == nil {
+		t.Error("expected a zero interval to be rejected")
+	}
+}
@@ -0,0 +1,101 @@
+package common
+
+import (
+	"sync"
+	"time"
+
+	"github.com/adityapk00/lightwalletd/walletrpc"
+)
+
+type blockCall struct {
+	done    chan struct{}
+	block   *walletrpc.CompactBlock
+	err     error
+	expires time.Time
+}
+
+// BlockFetchCoalescer lets concurrent requests for the same uncached block
+// share a single getblock call. During a mass rescan many clients walk
+// overlapping ranges at about the same time; each block is then fetched from
+// zcashd once, and kept for Linger afterwards for the streams that are a
+// little behind.
+//
+// At most MaxBlocks blocks are held, fetched or in flight; beyond that,
+// requests go to zcashd directly. A nil *BlockFetchCoalescer doesn't
+// coalesce anything.
+type BlockFetchCoalescer struct {
+	MaxBlocks int
+	Linger    time.Duration
+
+	metrics *PrometheusMetrics
+	calls   map[int]*blockCall
+	mutex   sync.Mutex
+}
+
+func NewBlockFetchCoalescer(maxBlocks int, linger time.Duration, metrics *PrometheusMetrics) *BlockFetchCoalescer {
+	return &BlockFetchCoalescer{
+		MaxBlocks: maxBlocks,
+		Linger:    linger,
+		metrics:   metrics,
+		calls:     make(map[int]*blockCall),
+	}
+}
+
+// Fetch returns the block at height, calling fetch unless another request
+// for it is in flight or finished within Linger.
+func (c *BlockFetchCoalescer) Fetch(height int, fetch func() (*walletrpc.CompactBlock, error)) (*walletrpc.CompactBlock, error) {
+	if c == nil {
+		return fetch()
+	}
+
+	c.mutex.Lock()
+	if call, ok := c.calls[height]; ok {
+		c.mutex.Unlock()
+		c.metrics.BlockFetchesCoalesced.Inc()
+		<-call.done
+		return copyBlock(call.block), call.err
+	}
+	c.expire()
+	if len(c.calls) >= c.MaxBlocks {
+		c.mutex.Unlock()
+		return fetch()
+	}
+	call := &blockCall{done: make(chan struct{})}
+	c.calls[height] = call
+	c.mutex.Unlock()
+
+	call.block, call.err = fetch()
+
+	c.mutex.Lock()
+	if call.err != nil || call.block == nil {
+		// Don't hold on to failures, the next request should try again.
+		delete(c.calls, height)
+	} else {
+		call.expires = time.Now().Add(c.Linger)
+	}
+	c.mutex.Unlock()
+	close(call.done)
+
+	return copyBlock(call.block), call.err
+}
+
+// expire drops the finished calls past their Linger. The caller holds the
+// mutex.
+func (c *BlockFetchCoalescer) expire() {
+	now := time.Now()
+	for height, call := range c.calls {
+		if !call.expires.IsZero() && now.After(call.expires) {
+			delete(c.calls, height)
+		}
+	}
+}
+
+// copyBlock returns a shallow copy of block, so that callers sharing a
+// fetched block can set its top-level fields independently.
+func copyBlock(block *walletrpc.CompactBlock) *walletrpc.CompactBlock {
+	if block == nil {
+		return nil
+	}
+	copied := *block
+	return &copied
+}
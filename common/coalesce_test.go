@@ -0,0 +1,102 @@
+package common
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/adityapk00/lightwalletd/walletrpc"
+)
+
+func TestCoalesceOverlappingRanges(t *testing.T) {
+	node := newFixtureNode(t)
+	node.delay = 20 * time.Millisecond
+
+	metrics := GetPrometheusMetrics()
+	cache := NewBlockCache(10, testLog)
+	cache.Coalescer = NewBlockFetchCoalescer(100, time.Minute, metrics)
+	// The fixture blocks are all older than the cache.
+	if err, _ := cache.Add(300000, testCompactBlock(300000, nil)); err != nil {
+		t.Fatal(err)
+	}
+
+	ranges := [][2]int{
+		{289460, 289465},
+		{289460, 289463},
+		{289462, 289465},
+		{289461, 289464},
+		{289460, 289465},
+	}
+	var wg sync.WaitGroup
+	for _, r := range ranges {
+		wg.Add(1)
+		go func(start, end int) {
+			defer wg.Done()
+			blockOut := make(chan walletrpc.CompactBlock)
+			errOut := make(chan error)
+			go GetBlockRange(node, cache, blockOut, errOut, start, end, CacheFirst, nil)
+
+			next := start
+			for {
+				select {
+				case err := <-errOut:
+					if err != nil {
+						t.Error(err)
+					}
+					if next != end+1 {
+						t.Errorf("range %d-%d ended at %d", start, end, next)
+					}
+					return
+				case block := <-blockOut:
+					if block.Height != uint64(next) {
+						t.Errorf("range %d-%d: got block %d, expected %d", start, end, block.Height, next)
+					}
+					next++
+				}
+			}
+		}(r[0], r[1])
+	}
+	wg.Wait()
+
+	for height := 289460; height <= 289465; height++ {
+		if calls := node.calls[height]; calls != 1 {
+			t.Errorf("block %d fetched %d times", height, calls)
+		}
+	}
+}
+
+func TestCoalescerBounds(t *testing.T) {
+	metrics := GetPrometheusMetrics()
+	c := NewBlockFetchCoalescer(2, time.Minute, metrics)
+	fetches := 0
+	fetch := func() (*walletrpc.CompactBlock, error) {
+		fetches++
+		return &walletrpc.CompactBlock{Height: 1}, nil
+	}
+
+	c.Fetch(1, fetch)
+	c.Fetch(2, fetch)
+	c.Fetch(3, fetch) // over MaxBlocks: fetched, but not kept
+	c.Fetch(1, fetch)
+	c.Fetch(3, fetch)
+	if fetches != 4 {
+		t.Errorf("expected 4 fetches, got %d", fetches)
+	}
+
+	// Shared blocks can be changed by one caller without affecting the others.
+	block, _ := c.Fetch(1, fetch)
+	block.FullBlock = []byte{1}
+	if block, _ := c.Fetch(1, fetch); block.FullBlock != nil {
+		t.Error("change to a shared block leaked to another caller")
+	}
+
+	// Blocks are dropped after Linger.
+	c.Linger = 0
+	c.Fetch(4, fetch)
+	time.Sleep(time.Millisecond)
+	c.Fetch(5, fetch)
+	c.Fetch(4, fetch)
+	if fetches != 7 {
+		t.Errorf("expected 7 fetches, got %d", fetches)
+	}
+}
@@ -6,53 +6,88 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/adityapk00/lightwalletd/parser"
 	"github.com/adityapk00/lightwalletd/walletrpc"
-	"github.com/btcsuite/btcd/rpcclient"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 )
 
-func GetSaplingInfo(rpcClient *rpcclient.Client) (int, int, string, string, error) {
-	result, rpcErr := rpcClient.RawRequest("getblockchaininfo", make([]json.RawMessage, 0))
+// RPCClient is the subset of the zcashd JSON-RPC client used by lightwalletd.
+// It is satisfied by *rpcclient.Client as well as by frontend.RPCPool.
+type RPCClient interface {
+	RawRequest(method string, params []json.RawMessage) (json.RawMessage, error)
+}
 
-	var err error
-	var errCode int64
+// BatchRPCClient is an RPCClient that can make several calls in one round
+// trip, as a JSON-RPC batch.
+type BatchRPCClient interface {
+	RPCClient
 
-	// For some reason, the error responses are not JSON
-	if rpcErr != nil {
-		errParts := strings.SplitN(rpcErr.Error(), ":", 2)
-		errCode, err = strconv.ParseInt(errParts[0], 10, 32)
-		//Check to see if we are requesting a height the zcashd doesn't have yet
-		if err == nil && errCode == -8 {
-			return -1, -1, "", "", nil
-		}
-		return -1, -1, "", "", errors.Wrap(rpcErr, "error requesting block")
+	// BatchRequest calls method once with each of params, and returns the
+	// result or the error of each call, in order. The error it returns
+	// means the batch as a whole failed.
+	BatchRequest(method string, params [][]json.RawMessage) ([]json.RawMessage, []error, error)
+}
+
+// BatchRequest calls method once with each of params, in one round trip if
+// client is a BatchRPCClient, otherwise one call after the other.
+func BatchRequest(client RPCClient, method string, params [][]json.RawMessage) ([]json.RawMessage, []error, error) {
+	if batcher, ok := client.(BatchRPCClient); ok {
+		return batcher.BatchRequest(method, params)
 	}
+	results := make([]json.RawMessage, len(params))
+	errs := make([]error, len(params))
+	for i := range params {
+		results[i], errs[i] = client.RawRequest(method, params[i])
+	}
+	return results, errs, nil
+}
 
-	var f interface{}
-	err = json.Unmarshal(result, &f)
+func GetSaplingInfo(rpcClient RPCClient) (int, int, string, string, error) {
+	info, err := GetChainInfo(rpcClient)
 	if err != nil {
-		return -1, -1, "", "", errors.Wrap(err, "error reading JSON response")
+		return -1, -1, "", "", err
 	}
+	return info.SaplingHeight(), info.Headers, info.Chain, info.Consensus.NextBlock, nil
+}
 
-	chainName := f.(map[string]interface{})["chain"].(string)
-
-	upgradeJSON := f.(map[string]interface{})["upgrades"]
-	saplingJSON := upgradeJSON.(map[string]interface{})["6f76727a"] // Sapling ID
-	saplingHeight := saplingJSON.(map[string]interface{})["activationheight"].(float64)
-
-	blockHeight := f.(map[string]interface{})["headers"].(float64)
+// SaplingActivationHeight decides the Sapling activation height to use, from
+// the one reported by the node (-1 if none) and the operator's override (0 if
+// none). The override wins on regtest, where the node's answer depends on its
+// own configuration, and when the node doesn't report a height; otherwise the
+// node is trusted.
+func SaplingActivationHeight(chainName string, reported, override int) (int, error) {
+	if override > 0 && (chainName == "regtest" || reported < 0) {
+		return override, nil
+	}
+	if reported < 0 {
+		return -1, errors.New("the node didn't report a Sapling activation height, set one with -sapling-activation-height")
+	}
+	return reported, nil
+}
 
-	consensus := f.(map[string]interface{})["consensus"]
-	branchID := consensus.(map[string]interface{})["nextblock"].(string)
+func getBlockFromRPC(rpcClient RPCClient, height int) (*walletrpc.CompactBlock, error) {
+	block, err := getParsedBlockFromRPC(rpcClient, height)
+	if block == nil {
+		return nil, err
+	}
+	return compactBlock(block), nil
+}
 
-	return int(saplingHeight), int(blockHeight), chainName, branchID, nil
+// compactBlock is the compact block the cache keeps for block: its Sapling
+// data, and the coinbase transaction for the clients that ask for it.
+func compactBlock(block *parser.Block) *walletrpc.CompactBlock {
+	compact := block.ToCompact()
+	compact.Coinbase = block.Coinbase()
+	return compact
 }
 
-func getBlockFromRPC(rpcClient *rpcclient.Client, height int) (*walletrpc.CompactBlock, error) {
+// GetRawBlock returns the serialized full block at height, or nil if the
+// node doesn't have it yet.
+func GetRawBlock(rpcClient RPCClient, height int) ([]byte, error) {
 	params := make([]json.RawMessage, 2)
 	params[0] = json.RawMessage("\"" + strconv.Itoa(height) + "\"")
 	params[1] = json.RawMessage("0")
@@ -82,6 +117,16 @@ func getBlockFromRPC(rpcClient *rpcclient.Client, height int) (*walletrpc.Compac
 	if err != nil {
 		return nil, errors.Wrap(err, "error decoding getblock output")
 	}
+	return blockData, nil
+}
+
+// getParsedBlockFromRPC returns the full block at height, or nil if the node
+// doesn't have it yet.
+func getParsedBlockFromRPC(rpcClient RPCClient, height int) (*parser.Block, error) {
+	blockData, err := GetRawBlock(rpcClient, height)
+	if blockData == nil {
+		return nil, err
+	}
 
 	block := parser.NewBlock()
 	rest, err := block.ParseFromSlice(blockData)
@@ -92,15 +137,22 @@ func getBlockFromRPC(rpcClient *rpcclient.Client, height int) (*walletrpc.Compac
 		return nil, errors.New("received overlong message")
 	}
 
-	return block.ToCompact(), nil
+	return block, nil
 }
 
-// HistoricalBlockIngestor adds historical blocks in reverse order.
-func HistoricalBlockIngestor(rpcClient *rpcclient.Client, cache *BlockCache, log *logrus.Entry,
+// logMilestone logs an ingestion lifecycle event, as a line dashboards and
+// alerts can match on milestone without following the per-block logs.
+func logMilestone(log *logrus.Entry, milestone string, fields logrus.Fields) {
+	log.WithFields(fields).WithField("milestone", milestone).Info("Ingestion milestone")
+}
+
+// HistoricalBlockIngestor adds historical blocks in reverse order. When it
+// stops, having filled the cache or failed, the cache is marked warmed up.
+func HistoricalBlockIngestor(rpcClient RPCClient, cache *BlockCache, log *logrus.Entry,
 	startBlock int, totalBlocks int, saplingHeight int) {
 	// Wait for at least some blocks in the cache
 	for {
-		if cache.FirstBlock == -1 {
+		if cache.GetFirstBlock() == -1 {
 			println("Historical block ingestor sleeping for 2s")
 			time.Sleep(2 * time.Second)
 		} else {
@@ -108,16 +160,37 @@ func HistoricalBlockIngestor(rpcClient *rpcclient.Client, cache *BlockCache, log
 		}
 	}
 
-	log.WithFields(logrus.Fields{
-		"method":     "CacheHistoricalBlock",
-		"op":         "Starting",
+	endBlock := startBlock - totalBlocks + 1
+	if endBlock <= saplingHeight {
+		endBlock = saplingHeight + 1
+	}
+	started := time.Now()
+	logMilestone(log, "backfill-started", logrus.Fields{
 		"startBlock": startBlock,
-		"endBlock":   (startBlock - totalBlocks),
-	}).Info("Cache")
+		"endBlock":   endBlock,
+	})
+	nextPercent := 25
+
+	var backfillErr error
+	defer func() {
+		if backfillErr != nil {
+			logMilestone(log, "backfill-failed", logrus.Fields{
+				"firstBlock": cache.GetFirstBlock(),
+				"error":      backfillErr,
+				"elapsed":    time.Since(started),
+			})
+		} else {
+			logMilestone(log, "backfill-done", logrus.Fields{
+				"firstBlock": cache.GetFirstBlock(),
+				"elapsed":    time.Since(started),
+			})
+		}
+		cache.setWarmedUp(backfillErr)
+	}()
 
 	// We don't have to worry about reorgs, becaue we'll be at least 100 blocks in the history, where there are no reorgs
-	for height := startBlock; height > (startBlock-totalBlocks) && height > saplingHeight; height-- {
-		block, err := getBlockFromRPC(rpcClient, height)
+	for height := startBlock; height >= endBlock; height-- {
+		parsed, err := getParsedBlockFromRPC(rpcClient, height)
 
 		if err != nil {
 			log.WithFields(logrus.Fields{
@@ -125,40 +198,97 @@ func HistoricalBlockIngestor(rpcClient *rpcclient.Client, cache *BlockCache, log
 				"error":  err,
 			}).Warn("error with getblock for historical block")
 
-			break
+			backfillErr = err
+			return
 		}
 
-		if block != nil {
-			err, full := cache.AddHistorical(height, block)
+		if parsed != nil {
+			err, full := cache.AddHistorical(height, compactBlock(parsed))
 			if full {
-				log.WithFields(logrus.Fields{
-					"method": "CacheHistoricalBlock",
-					"op":     "Finished",
-				}).Info("Cache")
 				break
 			}
 
 			if err != nil {
 				log.Error("Error adding historical block to cache: ", err)
-				break
+				backfillErr = err
+				return
+			}
+
+			if err := cache.Checkpoints.Add(parsed); err != nil {
+				log.Warn("Error adding checkpoint: ", err)
+			}
+
+			percent := 100 * (startBlock - height + 1) / (startBlock - endBlock + 1)
+			if percent >= nextPercent && percent < 100 {
+				reached := percent / 25 * 25
+				logMilestone(log, "backfill-progress", logrus.Fields{
+					"percent": reached,
+					"height":  height,
+					"elapsed": time.Since(started),
+				})
+				nextPercent = reached + 25
 			}
 		}
 	}
 }
 
-func BlockIngestor(rpcClient *rpcclient.Client, cache *BlockCache, log *logrus.Entry,
-	stopChan chan bool, startHeight int) {
+// IngestorOptions tunes BlockIngestor.
+type IngestorOptions struct {
+	// PollInterval is how often zcashd is asked for new blocks.
+	PollInterval time.Duration
+
+	// Prefetch is how many blocks past the cache's tip are requested at
+	// once. A new tip is always cached before any client asks for it; a
+	// larger Prefetch also catches up on a burst of blocks in one round
+	// trip instead of one after another. Less than 1 means 1.
+	Prefetch int
+
+	// Validator, if not nil, checks a sample of the blocks once cached.
+	Validator *BlockValidator
+
+	// AddressIndex, if not nil, is given the blocks as they're cached.
+	AddressIndex *AddressIndex
+}
+
+type fetchedBlock struct {
+	block *parser.Block
+	err   error
+}
+
+// fetchBlocks requests the n blocks from height on concurrently, and returns
+// them in order.
+func fetchBlocks(rpcClient RPCClient, height int, n int) []fetchedBlock {
+	fetched := make([]fetchedBlock, n)
+	var wg sync.WaitGroup
+	for i := range fetched {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			fetched[i].block, fetched[i].err = getParsedBlockFromRPC(rpcClient, height+i)
+		}(i)
+	}
+	wg.Wait()
+	return fetched
+}
+
+func BlockIngestor(rpcClient RPCClient, cache *BlockCache, log *logrus.Entry,
+	stopChan chan bool, startHeight int, opts IngestorOptions) {
 	reorgCount := 0
 	height := startHeight
 	timeoutCount := 0
 
+	started := time.Now()
+	caughtUp := false
+	reorgTip := 0
+
 	// Start listening for new blocks
 	for {
 		select {
 		case <-stopChan:
-			break
+			return
 
-		case <-time.After(5 * time.Second):
+		case <-time.After(opts.PollInterval):
+		ingest:
 			for {
 				if reorgCount > 0 {
 					height -= 10
@@ -169,38 +299,59 @@ func BlockIngestor(rpcClient *rpcclient.Client, cache *BlockCache, log *logrus.E
 					return
 				}
 
-				block, err := getBlockFromRPC(rpcClient, height)
+				// Walk back one block at a time while resolving a reorg.
+				n := opts.Prefetch
+				if n < 1 || reorgCount > 0 {
+					n = 1
+				}
 
-				if err != nil {
-					log.WithFields(logrus.Fields{
-						"height": height,
-						"error":  err,
-					}).Warn("error with getblock")
+				for _, fetched := range fetchBlocks(rpcClient, height, n) {
+					parsed, err := fetched.block, fetched.err
 
-					timeoutCount++
-					if timeoutCount == 3 {
+					if err != nil {
 						log.WithFields(logrus.Fields{
-							"timeouts": timeoutCount,
-						}).Warn("unable to issue RPC call to zcashd node 3 times")
-						break
+							"height": height,
+							"error":  err,
+						}).Warn("error with getblock")
+
+						timeoutCount++
+						if timeoutCount == 3 {
+							log.WithFields(logrus.Fields{
+								"timeouts": timeoutCount,
+							}).Warn("unable to issue RPC call to zcashd node 3 times")
+						}
+					}
+
+					if parsed == nil {
+						if err == nil && !caughtUp {
+							caughtUp = true
+							logMilestone(log, "caught-up", logrus.Fields{
+								"startHeight": startHeight,
+								"height":      height - 1,
+								"elapsed":     time.Since(started),
+							})
+						}
+						break ingest
 					}
-				}
 
-				if block != nil {
+					block := compactBlock(parsed)
 					if timeoutCount > 0 {
 						timeoutCount--
 					}
 
-					log.Info("Ingestor adding block to cache: ", height)
+					log.Debug("Ingestor adding block to cache: ", height)
 					err, reorg := cache.Add(height, block)
 
 					if err != nil {
 						log.Error("Error adding block to cache: ", err)
-						continue
+						continue ingest
 					}
 
 					//check for reorgs once we have inital block hash from startup
 					if reorg {
+						if reorgCount == 0 {
+							reorgTip = height
+						}
 						reorgCount++
 
 						log.WithFields(logrus.Fields{
@@ -209,20 +360,95 @@ func BlockIngestor(rpcClient *rpcclient.Client, cache *BlockCache, log *logrus.E
 							"phash":  displayHash(block.PrevHash),
 							"reorg":  reorgCount,
 						}).Warn("REORG")
-					} else {
-						reorgCount = 0
+						continue ingest
+					}
 
-						height++
+					if reorgCount > 0 {
+						logMilestone(log, "reorg-handled", logrus.Fields{
+							"forkHeight": height,
+							"depth":      reorgTip - height,
+						})
+					}
+					reorgCount = 0
+					if err := cache.Checkpoints.Add(parsed); err != nil {
+						log.Warn("Error adding checkpoint: ", err)
 					}
-				} else {
-					break
+					if opts.Validator.sample() {
+						opts.Validator.Validate(parsed, cache.Get(height))
+					}
+					if err := opts.AddressIndex.Add(parsed); err != nil {
+						log.Warn("Error indexing addresses: ", err)
+					}
+
+					height++
 				}
 			}
 		}
 	}
 }
 
-func GetBlock(rpcClient *rpcclient.Client, cache *BlockCache, height int) (*walletrpc.CompactBlock, error) {
+// LookupStrategy is where a request looks for blocks.
+type LookupStrategy int
+
+const (
+	// CacheFirst serves blocks from the cache, and asks the node for the
+	// older ones it doesn't hold. It's the default: fast near the tip, and
+	// blocks fetched from the node are not added to the cache, so deep
+	// history requests don't push recent blocks out.
+	CacheFirst LookupStrategy = iota
+
+	// CacheOnly never asks the node. Blocks the cache doesn't hold are not
+	// found; this protects the node from rescans that reach past the cache,
+	// at the cost of failing them.
+	CacheOnly
+
+	// NodeOnly always asks the node, so that answers reflect the node's
+	// view even while the ingestor lags, at the cost of a getblock for
+	// every block served.
+	NodeOnly
+)
+
+var lookupStrategyNames = map[string]LookupStrategy{
+	"cache-first": CacheFirst,
+	"cache-only":  CacheOnly,
+	"node-only":   NodeOnly,
+}
+
+// ParseLookupStrategy returns the strategy called name: "cache-first",
+// "cache-only" or "node-only".
+func ParseLookupStrategy(name string) (LookupStrategy, error) {
+	strategy, ok := lookupStrategyNames[name]
+	if !ok {
+		return CacheFirst, errors.Errorf("unknown lookup strategy %q", name)
+	}
+	return strategy, nil
+}
+
+// GetBlock looks up the block at height with the CacheFirst strategy.
+func GetBlock(rpcClient RPCClient, cache *BlockCache, height int) (*walletrpc.CompactBlock, error) {
+	return LookupBlock(rpcClient, cache, height, CacheFirst)
+}
+
+// LookupBlock returns the block at height, looking for it according to
+// strategy.
+func LookupBlock(rpcClient RPCClient, cache *BlockCache, height int, strategy LookupStrategy) (*walletrpc.CompactBlock, error) {
+	switch strategy {
+	case CacheOnly:
+		if block := cache.Get(height); block != nil {
+			return block, nil
+		}
+		return nil, errors.Errorf("block %d is not in the cache", height)
+
+	case NodeOnly:
+		block, err := cache.Coalescer.Fetch(height, func() (*walletrpc.CompactBlock, error) {
+			return getBlockFromRPC(rpcClient, height)
+		})
+		if err == nil && block == nil {
+			err = errors.Errorf("block %d not found", height)
+		}
+		return block, err
+	}
+
 	// First, check the cache to see if we have the block
 	block := cache.Get(height)
 	if block != nil {
@@ -243,7 +469,18 @@ func GetBlock(rpcClient *rpcclient.Client, cache *BlockCache, height int) (*wall
 				height, cache.GetLatestBlock()))
 	}
 
-	block, err := getBlockFromRPC(rpcClient, height)
+	// Blocks evicted to a tier are served from there rather than the node.
+	if block := cache.getFromTier(height); block != nil {
+		cache.log.WithFields(logrus.Fields{
+			"method": "CacheTierHit",
+			"height": height,
+		}).Debug("Cache")
+		return block, nil
+	}
+
+	block, err := cache.Coalescer.Fetch(height, func() (*walletrpc.CompactBlock, error) {
+		return getBlockFromRPC(rpcClient, height)
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -255,21 +492,39 @@ func GetBlock(rpcClient *rpcclient.Client, cache *BlockCache, height int) (*wall
 	return block, nil
 }
 
-func GetBlockRange(rpcClient *rpcclient.Client, cache *BlockCache,
-	blockOut chan<- walletrpc.CompactBlock, errOut chan<- error, start, end int) {
-
-	// Go over [start, end] inclusive
-	for i := start; i <= end; i++ {
-		block, err := GetBlock(rpcClient, cache, i)
+// GetBlockRange sends the blocks from start to end on blockOut, then nil or
+// the first error on errOut. Once done is closed it gives up without sending
+// anything more, so that it doesn't outlive a reader that stopped reading; a
+// nil done never is.
+func GetBlockRange(rpcClient RPCClient, cache *BlockCache,
+	blockOut chan<- walletrpc.CompactBlock, errOut chan<- error, start, end int, strategy LookupStrategy, done <-chan struct{}) {
+
+	// Go over [start, end] inclusive, downwards if end is below start
+	step := 1
+	if end < start {
+		step = -1
+	}
+	for i := start; i != end+step; i += step {
+		block, err := LookupBlock(rpcClient, cache, i, strategy)
 		if err != nil {
-			errOut <- err
+			select {
+			case errOut <- err:
+			case <-done:
+			}
 			return
 		}
 
-		blockOut <- *block
+		select {
+		case blockOut <- *block:
+		case <-done:
+			return
+		}
 	}
 
-	errOut <- nil
+	select {
+	case errOut <- nil:
+	case <-done:
+	}
 }
 
 func displayHash(hash []byte) string {
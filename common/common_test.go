@@ -0,0 +1,247 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adityapk00/lightwalletd/walletrpc"
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+// cannedNode answers each method with a fixed JSON result.
+type cannedNode map[string]string
+
+func (n cannedNode) RawRequest(method string, params []json.RawMessage) (json.RawMessage, error) {
+	result, ok := n[method]
+	if !ok {
+		return nil, &btcjson.RPCError{Code: -32601, Message: "Method not found"}
+	}
+	return json.RawMessage(result), nil
+}
+
+func TestSaplingActivationHeightOverride(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		info     string
+		override int
+		want     int
+	}{
+		{
+			name:     "regtest without sapling scheduled",
+			info:     `{"chain": "regtest", "headers": 10, "upgrades": {}, "consensus": {"nextblock": "00000000"}}`,
+			override: 5,
+			want:     5,
+		},
+		{
+			name:     "regtest with sapling scheduled",
+			info:     `{"chain": "regtest", "headers": 10, "upgrades": {"6f76727a": {"activationheight": 1}}, "consensus": {"nextblock": "76b809bb"}}`,
+			override: 5,
+			want:     5,
+		},
+		{
+			name:     "mainnet",
+			info:     `{"chain": "main", "headers": 900000, "upgrades": {"6f76727a": {"activationheight": 419200}}, "consensus": {"nextblock": "2bb40e60"}}`,
+			override: 5,
+			want:     419200,
+		},
+		{
+			name: "mainnet without override",
+			info: `{"chain": "main", "headers": 900000, "upgrades": {"6f76727a": {"activationheight": 419200}}, "consensus": {"nextblock": "2bb40e60"}}`,
+			want: 419200,
+		},
+	} {
+		saplingHeight, _, chainName, _, err := GetSaplingInfo(cannedNode{"getblockchaininfo": tt.info})
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		height, err := SaplingActivationHeight(chainName, saplingHeight, tt.override)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		} else if height != tt.want {
+			t.Errorf("%s: got height %d, expected %d", tt.name, height, tt.want)
+		}
+	}
+
+	// Without an override there is nothing to fall back on.
+	if _, err := SaplingActivationHeight("regtest", -1, 0); err == nil {
+		t.Error("expected an error when no height is known")
+	}
+}
+
+func TestBlockIngestorPrefetch(t *testing.T) {
+	node := newFixtureNode(t)
+	tip := node.blocks[289465]
+	delete(node.blocks, 289465)
+
+	cache := NewBlockCache(100, testLog)
+	if err, _ := cache.Add(289460, node.parsed(t, 289460).ToCompact()); err != nil {
+		t.Fatal(err)
+	}
+
+	stopChan := make(chan bool, 1)
+	defer func() { stopChan <- true }()
+	go BlockIngestor(node, cache, testLog, stopChan, 289461, IngestorOptions{
+		PollInterval: 10 * time.Millisecond,
+		Prefetch:     3,
+	})
+
+	waitForTip := func(height int) {
+		deadline := time.Now().Add(5 * time.Second)
+		for cache.GetLatestBlock() != height {
+			if time.Now().After(deadline) {
+				t.Fatalf("ingestor stopped at %d, expected %d", cache.GetLatestBlock(), height)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+	waitForTip(289464)
+
+	// The node announces a new block; it's cached without any client asking.
+	node.mutex.Lock()
+	node.blocks[289465] = tip
+	node.mutex.Unlock()
+	waitForTip(289465)
+
+	node.mutex.Lock()
+	calls := node.calls[289465]
+	node.mutex.Unlock()
+	block, err := GetBlock(node, cache, 289465)
+	if err != nil || block == nil {
+		t.Fatalf("new tip not served: %v", err)
+	}
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
+	if node.calls[289465] != calls {
+		t.Error("new tip wasn't a cache hit")
+	}
+}
+
+// milestones returns the milestone field of the entries logged to hook.
+func milestones(hook *test.Hook) []string {
+	var found []string
+	for _, entry := range hook.AllEntries() {
+		if milestone, ok := entry.Data["milestone"]; ok {
+			if percent, ok := entry.Data["percent"]; ok {
+				milestone = fmt.Sprintf("%s %d", milestone, percent)
+			}
+			found = append(found, milestone.(string))
+		}
+	}
+	return found
+}
+
+func TestIngestionMilestones(t *testing.T) {
+	node := newFixtureNode(t)
+	logger, hook := test.NewNullLogger()
+	logger.SetLevel(logrus.DebugLevel)
+	log := logger.WithField("app", "test")
+
+	// Five blocks of backfill cross each quarter once.
+	cache := NewBlockCache(100, log)
+	if err, _ := cache.Add(289465, node.parsed(t, 289465).ToCompact()); err != nil {
+		t.Fatal(err)
+	}
+	hook.Reset()
+	HistoricalBlockIngestor(node, cache, log, 289464, 100, 289459)
+	want := []string{"backfill-started", "backfill-progress 25", "backfill-progress 50", "backfill-progress 75", "backfill-done"}
+	if got := milestones(hook); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("backfill milestones %v, expected %v", got, want)
+	}
+
+	// Per-block lines are logged below Info.
+	for _, entry := range hook.AllEntries() {
+		if _, ok := entry.Data["milestone"]; !ok && entry.Level <= logrus.InfoLevel {
+			t.Errorf("per-block line logged at %s: %s", entry.Level, entry.Message)
+		}
+	}
+
+	// The live ingestor reports catching up once.
+	cache = NewBlockCache(100, log)
+	if err, _ := cache.Add(289460, node.parsed(t, 289460).ToCompact()); err != nil {
+		t.Fatal(err)
+	}
+	hook.Reset()
+	stopChan := make(chan bool, 1)
+	defer func() { stopChan <- true }()
+	go BlockIngestor(node, cache, log, stopChan, 289461, IngestorOptions{PollInterval: 10 * time.Millisecond})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(milestones(hook)) == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if got := milestones(hook); len(got) != 1 || got[0] != "caught-up" {
+		t.Errorf("live milestones %v, expected [caught-up]", got)
+	}
+	for _, entry := range hook.AllEntries() {
+		if entry.Data["milestone"] == "caught-up" && entry.Data["height"] != 289465 {
+			t.Errorf("caught up at %v, expected 289465", entry.Data["height"])
+		}
+	}
+}
+
+func TestGetBlockRangeDone(t *testing.T) {
+	node := newFixtureNode(t)
+	cache := NewBlockCache(100, testLog)
+	// The fixture blocks are all older than the cache.
+	if err, _ := cache.Add(300000, testCompactBlock(300000, nil)); err != nil {
+		t.Fatal(err)
+	}
+	blockOut := make(chan walletrpc.CompactBlock)
+	errOut := make(chan error)
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		GetBlockRange(node, cache, blockOut, errOut, 289460, 289465, CacheFirst, done)
+		close(finished)
+	}()
+
+	select {
+	case block := <-blockOut:
+		if block.Height != 289460 {
+			t.Fatalf("received block %d, expected 289460", block.Height)
+		}
+	case err := <-errOut:
+		t.Fatalf("range ended early: %v", err)
+	case <-time.After(time.Second):
+		t.Fatal("no block received")
+	}
+	// The reader stops reading.
+	close(done)
+	select {
+	case <-finished:
+	case <-time.After(time.Second):
+		t.Fatal("GetBlockRange still running after done was closed")
+	}
+}
+
+func TestHistoricalBlockIngestorFailure(t *testing.T) {
+	node := newFixtureNode(t)
+	node.blocks[289462] = "00"
+	logger, hook := test.NewNullLogger()
+	log := logger.WithField("app", "test")
+
+	cache := NewBlockCache(100, log)
+	if err, _ := cache.Add(289465, node.parsed(t, 289465).ToCompact()); err != nil {
+		t.Fatal(err)
+	}
+	hook.Reset()
+	HistoricalBlockIngestor(node, cache, log, 289464, 100, 289459)
+
+	// Warm-up is over, if short, so readiness doesn't wait on it forever.
+	if !cache.WarmedUp() || cache.WarmUpError() == nil {
+		t.Errorf("expected warm-up to have failed, warmed up %v, error %v", cache.WarmedUp(), cache.WarmUpError())
+	}
+	if first := cache.GetFirstBlock(); first != 289463 {
+		t.Errorf("cache starts at %d, expected 289463", first)
+	}
+	want := []string{"backfill-started", "backfill-progress 25", "backfill-failed"}
+	if got := milestones(hook); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("backfill milestones %v, expected %v", got, want)
+	}
+}
@@ -0,0 +1,45 @@
+package common
+
+import (
+	"fmt"
+	"strings"
+)
+
+// TxVersion is a transaction format: its version and version group ID.
+type TxVersion struct {
+	Version        uint32
+	VersionGroupID uint32
+}
+
+func (v TxVersion) String() string {
+	return fmt.Sprintf("version %d (group 0x%08x)", v.Version, v.VersionGroupID)
+}
+
+var (
+	overwinterTxVersion = TxVersion{3, 0x03c48270}
+	saplingTxVersion    = TxVersion{4, 0x892f2085}
+	nu5TxVersion        = TxVersion{5, 0x26a7270a}
+)
+
+// upgradeTxVersions lists the transaction formats accepted after each network
+// upgrade, by its lowercased name in getblockchaininfo. Forks of zcashd
+// renumber the branch IDs but keep the names.
+var upgradeTxVersions = map[string][]TxVersion{
+	"overwinter": {overwinterTxVersion},
+	"sapling":    {saplingTxVersion},
+	"blossom":    {saplingTxVersion},
+	"heartwood":  {saplingTxVersion},
+	"canopy":     {saplingTxVersion},
+	"nu5":        {saplingTxVersion, nu5TxVersion},
+}
+
+// NextBlockTxVersions returns the transaction formats that can be mined in
+// the next block, or nil if its consensus branch isn't one of the upgrades
+// known here.
+func (info *ChainInfo) NextBlockTxVersions() []TxVersion {
+	upgrade, ok := info.Upgrades[info.Consensus.NextBlock]
+	if !ok {
+		return nil
+	}
+	return upgradeTxVersions[strings.ToLower(upgrade.Name)]
+}
@@ -0,0 +1,120 @@
+package common
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// diskProbeSize is the amount of data written and read back by each probe.
+const diskProbeSize = 64 * 1024
+
+// DiskProbe times a small synced write and read on the volume holding the
+// block cache, so that a degrading disk shows up in the logs and metrics
+// before it stalls ingestion.
+type DiskProbe struct {
+	Threshold time.Duration
+
+	probe   func() error
+	metrics *PrometheusMetrics
+	log     *logrus.Entry
+
+	mutex   sync.RWMutex
+	slow    bool
+	latency time.Duration
+}
+
+// NewDiskProbe creates a probe that writes its test file in dir, and considers
+// the disk slow when a probe takes longer than threshold.
+func NewDiskProbe(dir string, threshold time.Duration, metrics *PrometheusMetrics, log *logrus.Entry) *DiskProbe {
+	return &DiskProbe{
+		Threshold: threshold,
+		probe:     func() error { return probeDir(dir) },
+		metrics:   metrics,
+		log:       log,
+	}
+}
+
+func probeDir(dir string) error {
+	f, err := ioutil.TempFile(dir, "lightwalletd-probe")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	data := make([]byte, diskProbeSize)
+	if _, err := f.Write(data); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	if _, err := f.ReadAt(data, 0); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Check runs the probe once and returns how long it took.
+func (p *DiskProbe) Check() (time.Duration, error) {
+	start := time.Now()
+	err := p.probe()
+	latency := time.Since(start)
+
+	if err != nil {
+		err = errors.Wrap(err, "disk probe failed")
+	}
+	slow := err != nil || latency > p.Threshold
+
+	p.mutex.Lock()
+	p.slow = slow
+	p.latency = latency
+	p.mutex.Unlock()
+
+	p.metrics.DiskProbeLatency.Set(latency.Seconds())
+	if slow {
+		p.metrics.DiskProbeSlow.Inc()
+		p.log.WithFields(logrus.Fields{
+			"latency":   latency,
+			"threshold": p.Threshold,
+			"error":     err,
+		}).Warn("disk is slow")
+	}
+	return latency, err
+}
+
+// Run checks the disk every interval, forever.
+func (p *DiskProbe) Run(interval time.Duration) {
+	for {
+		p.Check()
+		time.Sleep(interval)
+	}
+}
+
+// Healthy reports whether the last probe completed within the threshold.
+func (p *DiskProbe) Healthy() bool {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	return !p.slow
+}
+
+// ReadyHandler answers 503 while the disk is slow, and 200 otherwise, so that
+// a load balancer can take the instance out of rotation.
+func (p *DiskProbe) ReadyHandler(w http.ResponseWriter, req *http.Request) {
+	if !p.Healthy() {
+		p.mutex.RLock()
+		latency := p.latency
+		p.mutex.RUnlock()
+
+		http.Error(w, "disk is slow: "+latency.String(), http.StatusServiceUnavailable)
+		return
+	}
+	w.Write([]byte("ok\n"))
+}
@@ -0,0 +1,79 @@
+package common
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestDiskProbe(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lightwalletd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	metrics := GetPrometheusMetrics()
+	p := NewDiskProbe(dir, time.Minute, metrics, testLog)
+
+	// The real probe, on a healthy disk.
+	if _, err := p.Check(); err != nil {
+		t.Fatal(err)
+	}
+	if !p.Healthy() {
+		t.Error("disk reported slow")
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 0 {
+		t.Error("probe file left behind")
+	}
+
+	readyz := func() int {
+		w := httptest.NewRecorder()
+		p.ReadyHandler(w, httptest.NewRequest("GET", "/readyz", nil))
+		return w.Code
+	}
+	if code := readyz(); code != http.StatusOK {
+		t.Errorf("readyz returned %d on a healthy disk", code)
+	}
+
+	// Injected latency above the threshold.
+	p.Threshold = 10 * time.Millisecond
+	p.probe = func() error {
+		time.Sleep(20 * time.Millisecond)
+		return nil
+	}
+	if latency, _ := p.Check(); latency < 20*time.Millisecond {
+		t.Errorf("probe took %v, expected at least 20ms", latency)
+	}
+	if p.Healthy() {
+		t.Error("slow disk reported healthy")
+	}
+	if testutil.ToFloat64(metrics.DiskProbeSlow) != 1 {
+		t.Error("slow probe not counted")
+	}
+	if code := readyz(); code != http.StatusServiceUnavailable {
+		t.Errorf("readyz returned %d on a slow disk", code)
+	}
+
+	// A failing disk counts as slow too.
+	p.probe = func() error { return errors.New("I/O error") }
+	if _, err := p.Check(); err == nil || p.Healthy() {
+		t.Error("failed probe reported healthy")
+	}
+
+	// And it recovers.
+	p.probe = func() error { return nil }
+	p.Check()
+	if !p.Healthy() || readyz() != http.StatusOK {
+		t.Error("probe didn't recover")
+	}
+	if testutil.ToFloat64(metrics.DiskProbeSlow) != 2 {
+		t.Errorf("expected 2 slow probes, got %v", testutil.ToFloat64(metrics.DiskProbeSlow))
+	}
+}
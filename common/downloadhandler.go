@@ -1,8 +1,11 @@
 package common
 
 import (
+	"net"
 	"net/http"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/sirupsen/logrus"
 )
@@ -49,12 +52,157 @@ func paramsHandler(w http.ResponseWriter, req *http.Request) {
 	http.Error(w, "Not Found", 404)
 }
 
-// ParamsDownloadHandler Listens on port 8090 for download requests for params
-func ParamsDownloadHandler(prommetrics *PrometheusMetrics, logger *logrus.Entry, port string) {
-	metrics = prommetrics
-	log = logger
+// paramsRateLimiter lets each client IP make burst params requests at once,
+// and rate more per second after that, so that a single client can't keep the
+// endpoint busy. A nil *paramsRateLimiter allows everything.
+type paramsRateLimiter struct {
+	rate  float64
+	burst float64
+
+	mutex     sync.Mutex
+	clients   map[string]*paramsBucket
+	lastPrune time.Time
+}
+
+type paramsBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newParamsRateLimiter(rate float64, burst int) *paramsRateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return &paramsRateLimiter{
+		rate:    rate,
+		burst:   float64(burst),
+		clients: make(map[string]*paramsBucket),
+	}
+}
+
+// allow takes a token from ip's bucket at time now, if there's one left.
+func (l *paramsRateLimiter) allow(ip string, now time.Time) bool {
+	if l == nil {
+		return true
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	// Forget the clients whose buckets have filled up again.
+	full := time.Duration(l.burst / l.rate * float64(time.Second))
+	if now.Sub(l.lastPrune) > full {
+		for client, bucket := range l.clients {
+			if now.Sub(bucket.last) > full {
+				delete(l.clients, client)
+			}
+		}
+		l.lastPrune = now
+	}
+
+	bucket, ok := l.clients[ip]
+	if !ok {
+		bucket = &paramsBucket{tokens: l.burst, last: now}
+		l.clients[ip] = bucket
+	}
+	bucket.tokens += now.Sub(bucket.last).Seconds() * l.rate
+	if bucket.tokens > l.burst {
+		bucket.tokens = l.burst
+	}
+	bucket.last = now
+
+	if bucket.tokens < 1 {
+		return false
+	}
+	bucket.tokens--
+	return true
+}
+
+// rateLimited answers 429 Too Many Requests instead of calling handler when
+// the client is over its limit.
+func (l *paramsRateLimiter) rateLimited(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		ip, _, err := net.SplitHostPort(req.RemoteAddr)
+		if err != nil {
+			ip = req.RemoteAddr
+		}
+		if !l.allow(ip, time.Now()) {
+			metrics.ParamsThrottled.Inc()
+			log.WithFields(logrus.Fields{
+				"method":    "params",
+				"peer_addr": ip,
+			}).Warn("ParamsHandler throttled")
+
+			http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+			return
+		}
+		handler(w, req)
+	}
+}
 
-	http.HandleFunc("/params/", paramsHandler)
+// ParamsLimit is the params rate limit in effect, which can be changed while
+// the server runs.
+type ParamsLimit struct {
+	mutex   sync.RWMutex
+	rate    float64
+	burst   int
+	limiter *paramsRateLimiter
+}
+
+// NewParamsLimit returns a ParamsLimit of rate requests per second from each
+// client IP after a burst of burst, or no limit if rate isn't positive.
+func NewParamsLimit(rate float64, burst int) *ParamsLimit {
+	p := &ParamsLimit{}
+	p.Set(rate, burst)
+	return p
+}
+
+// Set changes the limit. Clients start again with a full burst.
+func (p *ParamsLimit) Set(rate float64, burst int) {
+	limiter := newParamsRateLimiter(rate, burst)
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.rate, p.burst, p.limiter = rate, burst, limiter
+}
+
+// Limit returns the rate and burst in effect.
+func (p *ParamsLimit) Limit() (float64, int) {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	return p.rate, p.burst
+}
+
+// rateLimited is paramsRateLimiter.rateLimited with the limit in effect at
+// each request.
+func (p *ParamsLimit) rateLimited(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		p.mutex.RLock()
+		limiter := p.limiter
+		p.mutex.RUnlock()
+		limiter.rateLimited(handler)(w, req)
+	}
+}
+
+// ParamsDownloadHandler Listens on port 8090 for download requests for params.
+// If rate is positive, each client IP is limited to burst requests at once
+// and rate requests per second after that.
+func ParamsDownloadHandler(prommetrics *PrometheusMetrics, logger *logrus.Entry, port string, rate float64, burst int) {
+	RegisterParamsHandler(prommetrics, logger, rate, burst)
 
 	http.ListenAndServe(port, nil)
 }
+
+// RegisterParamsHandler adds the params download handler to
+// http.DefaultServeMux, limited as for ParamsDownloadHandler, without
+// listening for it. It returns the limit, to change it later.
+func RegisterParamsHandler(prommetrics *PrometheusMetrics, logger *logrus.Entry, rate float64, burst int) *ParamsLimit {
+	metrics = prommetrics
+	log = logger
+
+	limit := NewParamsLimit(rate, burst)
+	http.HandleFunc("/params/", limit.rateLimited(paramsHandler))
+	return limit
+}
@@ -0,0 +1,59 @@
+package common
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestParamsRateLimit(t *testing.T) {
+	metrics = GetPrometheusMetrics()
+	log = testLog
+	handler := newParamsRateLimiter(1, 2).rateLimited(paramsHandler)
+
+	get := func(remoteAddr string) int {
+		req := httptest.NewRequest("GET", "/params/sapling-output.params", nil)
+		req.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w.Code
+	}
+
+	for i, want := range []int{http.StatusMovedPermanently, http.StatusMovedPermanently, http.StatusTooManyRequests} {
+		if code := get("192.0.2.1:1234"); code != want {
+			t.Errorf("request %d: got status %d, expected %d", i, code, want)
+		}
+	}
+	// The limit is per IP, whatever the port.
+	if code := get("192.0.2.1:5678"); code != http.StatusTooManyRequests {
+		t.Errorf("same IP on another port: got status %d", code)
+	}
+	if code := get("192.0.2.2:1234"); code != http.StatusMovedPermanently {
+		t.Errorf("other client throttled: got status %d", code)
+	}
+	if throttled := testutil.ToFloat64(metrics.ParamsThrottled); throttled != 2 {
+		t.Errorf("counted %v throttled requests, expected 2", throttled)
+	}
+}
+
+func TestParamsRateLimiterRefill(t *testing.T) {
+	limiter := newParamsRateLimiter(2, 1)
+	now := time.Now()
+	if !limiter.allow("a", now) || limiter.allow("a", now) {
+		t.Fatal("expected a burst of 1")
+	}
+	if limiter.allow("a", now.Add(100*time.Millisecond)) {
+		t.Error("bucket refilled too early")
+	}
+	if !limiter.allow("a", now.Add(600*time.Millisecond)) {
+		t.Error("bucket didn't refill at the configured rate")
+	}
+
+	var unlimited *paramsRateLimiter
+	if newParamsRateLimiter(0, 10) != nil || !unlimited.allow("a", now) {
+		t.Error("a zero rate should not limit")
+	}
+}
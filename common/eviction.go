@@ -0,0 +1,131 @@
+package common
+
+import (
+	"container/heap"
+	"container/list"
+	"sync"
+)
+
+// activityQueue orders the cached blocks EvictLeastActive may drop, those
+// below the protected tip, by shielded activity, then height, so that the
+// next one to drop is found without going through the whole cache. It's a
+// heap; the caller serializes access.
+type activityQueue struct {
+	entries []*activityEntry
+	index   map[int]*activityEntry
+}
+
+type activityEntry struct {
+	height   int
+	activity int
+	position int
+}
+
+func newActivityQueue() *activityQueue {
+	return &activityQueue{index: make(map[int]*activityEntry)}
+}
+
+func (q *activityQueue) Len() int { return len(q.entries) }
+
+func (q *activityQueue) Less(i, j int) bool {
+	if q.entries[i].activity != q.entries[j].activity {
+		return q.entries[i].activity < q.entries[j].activity
+	}
+	return q.entries[i].height < q.entries[j].height
+}
+
+func (q *activityQueue) Swap(i, j int) {
+	q.entries[i], q.entries[j] = q.entries[j], q.entries[i]
+	q.entries[i].position = i
+	q.entries[j].position = j
+}
+
+func (q *activityQueue) Push(x interface{}) {
+	entry := x.(*activityEntry)
+	entry.position = len(q.entries)
+	q.entries = append(q.entries, entry)
+}
+
+func (q *activityQueue) Pop() interface{} {
+	last := q.entries[len(q.entries)-1]
+	q.entries[len(q.entries)-1] = nil
+	q.entries = q.entries[:len(q.entries)-1]
+	return last
+}
+
+// add queues the block at height, unless it's queued already.
+func (q *activityQueue) add(height, activity int) {
+	if _, ok := q.index[height]; ok {
+		return
+	}
+	entry := &activityEntry{height: height, activity: activity}
+	heap.Push(q, entry)
+	q.index[height] = entry
+}
+
+// remove takes the block at height out of the queue, if it's there.
+func (q *activityQueue) remove(height int) {
+	if entry, ok := q.index[height]; ok {
+		heap.Remove(q, entry.position)
+		delete(q.index, height)
+	}
+}
+
+// least returns the height of the least active block queued, or -1 if the
+// queue is empty.
+func (q *activityQueue) least() int {
+	if len(q.entries) == 0 {
+		return -1
+	}
+	return q.entries[0].height
+}
+
+// recencyList orders the cached blocks by when they were last added or
+// read, for EvictLeastRecent. It has a mutex of its own, since blocks are
+// read holding only the cache's read lock.
+type recencyList struct {
+	mutex    sync.Mutex
+	order    *list.List // of heights, the most recently used first
+	elements map[int]*list.Element
+}
+
+func newRecencyList() *recencyList {
+	return &recencyList{order: list.New(), elements: make(map[int]*list.Element)}
+}
+
+// touch marks the block at height as just used.
+func (r *recencyList) touch(height int) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if element, ok := r.elements[height]; ok {
+		r.order.MoveToFront(element)
+		return
+	}
+	r.elements[height] = r.order.PushFront(height)
+}
+
+// remove forgets the block at height.
+func (r *recencyList) remove(height int) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if element, ok := r.elements[height]; ok {
+		r.order.Remove(element)
+		delete(r.elements, height)
+	}
+}
+
+// leastRecent returns the height of the least recently used block for which
+// evictable is true, or -1 if there's none.
+func (r *recencyList) leastRecent(evictable func(height int) bool) int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for element := r.order.Back(); element != nil; element = element.Prev() {
+		if height := element.Value.(int); evictable(height) {
+			return height
+		}
+	}
+	return -1
+}
@@ -0,0 +1,97 @@
+package common
+
+import (
+	"runtime"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// shrinkAbove and growBelow are the fractions of the memory limit above
+	// which the cache shrinks, and below which it grows back.
+	shrinkAbove = 0.9
+	growBelow   = 0.7
+)
+
+// CacheShrinker resizes a BlockCache to keep the heap under a soft memory
+// limit: while the heap is close to the limit the cache loses a quarter of its
+// blocks at each check, down to MinEntries, and once the pressure is gone it
+// grows back to the size it was created with.
+type CacheShrinker struct {
+	Limit      uint64
+	MinEntries int
+
+	cache      *BlockCache
+	maxEntries int
+	heapInUse  func() uint64
+	metrics    *PrometheusMetrics
+	log        *logrus.Entry
+}
+
+// NewCacheShrinker keeps the heap under limit bytes by resizing cache, to no
+// fewer than minEntries blocks.
+func NewCacheShrinker(cache *BlockCache, limit uint64, minEntries int, metrics *PrometheusMetrics, log *logrus.Entry) *CacheShrinker {
+	metrics.MemoryLimit.Set(float64(limit))
+	metrics.CacheMaxEntries.Set(float64(cache.GetMaxEntries()))
+	return &CacheShrinker{
+		Limit:      limit,
+		MinEntries: minEntries,
+		cache:      cache,
+		maxEntries: cache.GetMaxEntries(),
+		heapInUse:  readHeapInUse,
+		metrics:    metrics,
+		log:        log,
+	}
+}
+
+func readHeapInUse() uint64 {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	return stats.HeapInuse
+}
+
+// Check compares the heap to the limit once, resizes the cache if needed, and
+// returns its new size.
+func (s *CacheShrinker) Check() int {
+	heap := s.heapInUse()
+	size := s.cache.GetMaxEntries()
+
+	newSize := size
+	switch {
+	case float64(heap) > shrinkAbove*float64(s.Limit) && size > s.MinEntries:
+		newSize = size * 3 / 4
+		if newSize < s.MinEntries {
+			newSize = s.MinEntries
+		}
+	case float64(heap) < growBelow*float64(s.Limit) && size < s.maxEntries:
+		newSize = size * 5 / 4
+		if newSize <= size {
+			newSize = size + 1
+		}
+		if newSize > s.maxEntries {
+			newSize = s.maxEntries
+		}
+	}
+	if newSize == size {
+		return size
+	}
+
+	s.log.WithFields(logrus.Fields{
+		"heap":     heap,
+		"limit":    s.Limit,
+		"old_size": size,
+		"new_size": newSize,
+	}).Warn("Resizing block cache for memory pressure")
+	s.cache.SetMaxEntries(newSize)
+	s.metrics.CacheMaxEntries.Set(float64(newSize))
+	return newSize
+}
+
+// Run checks every interval, forever.
+func (s *CacheShrinker) Run(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		s.Check()
+	}
+}
@@ -0,0 +1,56 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCacheShrinker(t *testing.T) {
+	cache := NewBlockCache(100, testLog)
+	var prevHash []byte
+	for h := 1000; h < 1100; h++ {
+		block := testCompactBlock(h, prevHash)
+		if err, _ := cache.Add(h, block); err != nil {
+			t.Fatal(err)
+		}
+		prevHash = block.Hash
+	}
+
+	metrics := GetPrometheusMetrics()
+	shrinker := NewCacheShrinker(cache, 1000, 40, metrics, testLog)
+	heap := uint64(950)
+	shrinker.heapInUse = func() uint64 { return heap }
+
+	// Under pressure the cache sheds its oldest blocks, down to the minimum.
+	for _, want := range []int{75, 56, 42, 40, 40} {
+		if size := shrinker.Check(); size != want {
+			t.Fatalf("cache resized to %d, expected %d", size, want)
+		}
+	}
+	if cache.FirstBlock != 1060 || cache.Get(1059) != nil || cache.Get(1060) == nil {
+		t.Errorf("expected blocks 1060 to 1099 to be left, first block is %d", cache.FirstBlock)
+	}
+	if got := testutil.ToFloat64(metrics.CacheMaxEntries); got != 40 {
+		t.Errorf("cache size metric is %v", got)
+	}
+	if got := testutil.ToFloat64(metrics.MemoryLimit); got != 1000 {
+		t.Errorf("memory limit metric is %v", got)
+	}
+
+	// In between the thresholds nothing changes.
+	heap = 800
+	if size := shrinker.Check(); size != 40 {
+		t.Errorf("cache resized to %d without pressure changing", size)
+	}
+
+	// Once the pressure is gone it grows back to its configured size.
+	heap = 100
+	size := 0
+	for i := 0; i < 10; i++ {
+		size = shrinker.Check()
+	}
+	if size != 100 || cache.GetMaxEntries() != 100 {
+		t.Errorf("cache grew back to %d, expected 100", size)
+	}
+}
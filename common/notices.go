@@ -0,0 +1,135 @@
+package common
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// Notice is an announcement of the operator's for wallets, such as a
+// maintenance window or a move to another address, shown between From and
+// Until; either may be left out.
+type Notice struct {
+	ID      string    `json:"id"`
+	Level   string    `json:"level"`
+	Message string    `json:"message"`
+	URL     string    `json:"url"`
+	From    time.Time `json:"from"`
+	Until   time.Time `json:"until"`
+}
+
+// noticeLevels are the levels a notice may have; the default is "info".
+var noticeLevels = map[string]bool{"info": true, "warning": true, "critical": true}
+
+// Active reports whether the notice is to be shown at now.
+func (n *Notice) Active(now time.Time) bool {
+	return (n.From.IsZero() || !now.Before(n.From)) && (n.Until.IsZero() || now.Before(n.Until))
+}
+
+// NoticeFile serves the notices kept in a JSON file, a list of Notice
+// objects, loading them again when the file changes. Changes are noticed by
+// the file's modification time.
+//
+// All methods may be called on a nil *NoticeFile, which has no notices.
+type NoticeFile struct {
+	path string
+	log  *logrus.Entry
+
+	mutex   sync.RWMutex
+	mod     time.Time
+	notices []Notice
+}
+
+// NewNoticeFile loads the notices in the file at path.
+func NewNoticeFile(path string, log *logrus.Entry) (*NoticeFile, error) {
+	f := &NoticeFile{path: path, log: log}
+	if _, err := f.Reload(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Reload loads the notices again if the file changed since they were last
+// loaded, and reports whether it did. If the file can't be read or is
+// invalid, the notices are kept.
+func (f *NoticeFile) Reload() (bool, error) {
+	if f == nil {
+		return false, nil
+	}
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return false, errors.Wrap(err, "error reading notices")
+	}
+	f.mutex.RLock()
+	unchanged := info.ModTime().Equal(f.mod)
+	f.mutex.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	data, err := ioutil.ReadFile(f.path)
+	if err != nil {
+		return false, errors.Wrap(err, "error reading notices")
+	}
+	var notices []Notice
+	if err := json.Unmarshal(data, &notices); err != nil {
+		return false, errors.Wrap(err, "error parsing notices")
+	}
+	for i := range notices {
+		if notices[i].Message == "" {
+			return false, errors.Errorf("notice %d has no message", i)
+		}
+		if notices[i].Level == "" {
+			notices[i].Level = "info"
+		}
+		if !noticeLevels[notices[i].Level] {
+			return false, errors.Errorf("notice %d has unknown level %q", i, notices[i].Level)
+		}
+	}
+
+	f.mutex.Lock()
+	f.notices, f.mod = notices, info.ModTime()
+	f.mutex.Unlock()
+	return true, nil
+}
+
+// Run checks the file for changes every interval, forever.
+func (f *NoticeFile) Run(interval time.Duration) {
+	for range time.Tick(interval) {
+		reloaded, err := f.Reload()
+		if err != nil {
+			f.log.WithFields(logrus.Fields{
+				"notices_file": f.path,
+				"error":        err,
+			}).Warn("couldn't reload the notices, still serving the previous ones")
+			continue
+		}
+		if reloaded {
+			f.log.WithFields(logrus.Fields{
+				"notices_file": f.path,
+			}).Info("reloaded the notices")
+		}
+	}
+}
+
+// Current returns the notices active at now, in the file's order.
+func (f *NoticeFile) Current(now time.Time) []Notice {
+	if f == nil {
+		return nil
+	}
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+
+	var current []Notice
+	for _, notice := range f.notices {
+		if notice.Active(now) {
+			current = append(current, notice)
+		}
+	}
+	return current
+}
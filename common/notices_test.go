@@ -0,0 +1,70 @@
+package common
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNoticeFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lightwalletd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "notices.json")
+	write := func(content string, mod time.Time) {
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, mod, mod); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write(`[
+		{"id": "maintenance", "level": "warning", "message": "Down for maintenance on Sunday",
+		 "from": "2020-06-01T00:00:00Z", "until": "2020-06-07T12:00:00Z"},
+		{"message": "Welcome"}
+	]`, time.Unix(1000, 0))
+	f, err := NewNoticeFile(path, testLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	during := time.Date(2020, 6, 3, 0, 0, 0, 0, time.UTC)
+	if notices := f.Current(during); len(notices) != 2 || notices[0].ID != "maintenance" || notices[1].Level != "info" {
+		t.Errorf("unexpected notices %+v", notices)
+	}
+	after := time.Date(2020, 6, 7, 12, 0, 0, 0, time.UTC)
+	if notices := f.Current(after); len(notices) != 1 || notices[0].Message != "Welcome" {
+		t.Errorf("unexpected notices after the window %+v", notices)
+	}
+
+	// A bad file keeps the notices.
+	write(`[{"level": "urgent", "message": "Moving"}]`, time.Unix(2000, 0))
+	if _, err := f.Reload(); err == nil {
+		t.Error("expected an unknown level to be refused")
+	}
+	if notices := f.Current(during); len(notices) != 2 {
+		t.Errorf("notices lost to a bad file: %+v", notices)
+	}
+
+	write(`[{"message": "Moving to lwd.example.com", "url": "https://example.com/move"}]`, time.Unix(3000, 0))
+	if reloaded, err := f.Reload(); !reloaded || err != nil {
+		t.Fatalf("changed file not reloaded: %v", err)
+	}
+	if reloaded, err := f.Reload(); reloaded || err != nil {
+		t.Errorf("unchanged file reloaded: %v", err)
+	}
+	if notices := f.Current(during); len(notices) != 1 || notices[0].URL != "https://example.com/move" {
+		t.Errorf("unexpected notices after the reload %+v", notices)
+	}
+
+	var none *NoticeFile
+	if none.Current(during) != nil {
+		t.Error("nil notice file has notices")
+	}
+}
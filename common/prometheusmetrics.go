@@ -4,12 +4,38 @@ import "github.com/prometheus/client_golang/prometheus"
 
 // PrometheusMetrics is a list of collected Prometheus Counters and Guages that will be exported
 type PrometheusMetrics struct {
-	LatestBlockCounter        prometheus.Counter
-	TotalBlocksServedConter   prometheus.Counter
-	SendTransactionsCounter   prometheus.Counter
-	TotalErrors               prometheus.Counter
-	TotalSaplingParamsCounter prometheus.Counter
-	TotalSproutParamsCounter  prometheus.Counter
+	LatestBlockCounter            prometheus.Counter
+	TotalBlocksServedConter       prometheus.Counter
+	SendTransactionsCounter       prometheus.Counter
+	SendTransactionRetrySuccesses prometheus.Counter
+	SendTransactionDedupHits      prometheus.Counter
+	TransactionCacheHits          prometheus.Counter
+	TotalErrors                   prometheus.Counter
+	TotalSaplingParamsCounter     prometheus.Counter
+	TotalSproutParamsCounter      prometheus.Counter
+	ParamsThrottled               prometheus.Counter
+	BlockRangeStreams             prometheus.Gauge
+	BlockRangeStreamsLimit        prometheus.Gauge
+	BlockCacheHits                *prometheus.CounterVec
+	BlockFetchesCoalesced         prometheus.Counter
+	ShedRequests                  prometheus.Counter
+	QuotaRejections               *prometheus.CounterVec
+	SLOBurnRate                   *prometheus.GaugeVec
+	RequestMemoryAborts           *prometheus.CounterVec
+	MemoryLimit                   prometheus.Gauge
+	CacheMaxEntries               prometheus.Gauge
+	CacheCompactions              prometheus.Counter
+	BlockValidations              prometheus.Counter
+	BlockValidationMismatches     prometheus.Counter
+	OCSPStapleValidUntil          prometheus.Gauge
+	DiskProbeLatency              prometheus.Gauge
+	DiskProbeSlow                 prometheus.Counter
+	RPCBackendRequests            *prometheus.CounterVec
+	RPCBackendErrors              *prometheus.CounterVec
+	RPCBackendUp                  *prometheus.GaugeVec
+	SaplingActivationHeight       prometheus.Gauge
+	OrchardActivationHeight       prometheus.Gauge
+	ConsensusBranchID             prometheus.Gauge
 }
 
 func GetPrometheusMetrics() *PrometheusMetrics {
@@ -29,6 +55,21 @@ func GetPrometheusMetrics() *PrometheusMetrics {
 		Help: "Total number of transactions broadcasted by lightwalletd",
 	})
 
+	m.SendTransactionRetrySuccesses = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "lightwalletd_send_transaction_retry_successes",
+		Help: "Number of transactions broadcast successfully after retrying a transient error",
+	})
+
+	m.SendTransactionDedupHits = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "lightwalletd_send_transaction_dedup_hits",
+		Help: "Number of SendTransaction calls answered from an earlier submission of the same transaction",
+	})
+
+	m.TransactionCacheHits = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "lightwalletd_transaction_cache_hits",
+		Help: "Number of GetTransaction calls answered from the recently served transactions",
+	})
+
 	m.TotalErrors = prometheus.NewCounter(prometheus.CounterOpts{
 		Name: "lightwalletd_total_errors",
 		Help: "Total number of errors seen by lightwalletd",
@@ -44,5 +85,120 @@ func GetPrometheusMetrics() *PrometheusMetrics {
 		Help: "Total number of params downloasd for sprout params",
 	})
 
+	m.BlockRangeStreams = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "lightwalletd_block_range_streams",
+		Help: "Number of GetBlockRange streams currently being served",
+	})
+
+	m.BlockRangeStreamsLimit = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "lightwalletd_block_range_streams_limit",
+		Help: "Maximum number of concurrent GetBlockRange streams (0 if unlimited)",
+	})
+
+	m.BlockCacheHits = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "lightwalletd_block_cache_hits",
+		Help: "Number of cached blocks served from the in-memory recent window or from the backing store",
+	}, []string{"tier"})
+
+	m.BlockFetchesCoalesced = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "lightwalletd_block_fetches_coalesced",
+		Help: "Number of uncached block requests answered by another request's getblock call",
+	})
+
+	m.ParamsThrottled = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "lightwalletd_params_throttled",
+		Help: "Number of params requests refused because the client exceeded its rate limit",
+	})
+
+	m.ShedRequests = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "lightwalletd_shed_requests",
+		Help: "Number of calls turned away because the server was at its concurrency limit",
+	})
+
+	m.QuotaRejections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "lightwalletd_peer_quota_rejections",
+		Help: "Number of calls refused because the peer used up its quota for the method",
+	}, []string{"method"})
+
+	m.SLOBurnRate = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "lightwalletd_slo_burn_rate",
+		Help: "Rate each method with an SLO target is using up its error budget over the SLO window, 1 being as fast as the target allows",
+	}, []string{"method"})
+
+	m.RequestMemoryAborts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "lightwalletd_request_memory_aborts",
+		Help: "Number of calls aborted for needing more memory than the per-request budget",
+	}, []string{"method"})
+
+	m.MemoryLimit = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "lightwalletd_memory_limit_bytes",
+		Help: "Soft memory limit the block cache is shrunk to stay under, 0 if none",
+	})
+
+	m.CacheMaxEntries = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "lightwalletd_cache_max_entries",
+		Help: "Number of blocks the cache may currently hold",
+	})
+
+	m.CacheCompactions = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "lightwalletd_cache_compactions",
+		Help: "Number of times the block cache was rebuilt to give back the memory of blocks dropped by reorgs",
+	})
+
+	m.BlockValidations = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "lightwalletd_block_validations",
+		Help: "Number of sampled cached blocks compared with the full block they were made from",
+	})
+
+	m.BlockValidationMismatches = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "lightwalletd_block_validation_mismatches",
+		Help: "Number of sampled cached blocks that didn't match their full block",
+	})
+
+	m.OCSPStapleValidUntil = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "lightwalletd_ocsp_staple_valid_until_seconds",
+		Help: "Unix time the stapled OCSP response expires, 0 if none is stapled",
+	})
+
+	m.DiskProbeLatency = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "lightwalletd_disk_probe_latency_seconds",
+		Help: "Time taken by the last write and read probe of the cache volume",
+	})
+
+	m.DiskProbeSlow = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "lightwalletd_disk_probe_slow",
+		Help: "Number of disk probes that failed or exceeded the latency threshold",
+	})
+
+	m.RPCBackendRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "lightwalletd_rpc_backend_requests",
+		Help: "Number of JSON-RPC requests sent to each zcashd backend",
+	}, []string{"backend"})
+
+	m.RPCBackendErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "lightwalletd_rpc_backend_errors",
+		Help: "Number of JSON-RPC requests that couldn't reach each zcashd backend",
+	}, []string{"backend"})
+
+	m.RPCBackendUp = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "lightwalletd_rpc_backend_up",
+		Help: "Whether each zcashd backend is currently considered healthy (1) or not (0)",
+	}, []string{"backend"})
+
+	m.SaplingActivationHeight = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "lightwalletd_sapling_activation_height",
+		Help: "Sapling activation height reported by zcashd, -1 if not scheduled",
+	})
+
+	m.OrchardActivationHeight = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "lightwalletd_orchard_activation_height",
+		Help: "Orchard (NU5) activation height reported by zcashd, -1 if not scheduled",
+	})
+
+	m.ConsensusBranchID = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "lightwalletd_consensus_branch_id",
+		Help: "Consensus branch ID of zcashd's chain tip",
+	})
+
 	return m
 }
@@ -0,0 +1,82 @@
+package common
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// RequestMemory accounts for the memory a single call holds on to, against
+// a budget, so that one call can't take more than its share. What the call
+// buffers is reserved before it's allocated, and released once it's let go;
+// the first reservation past the budget fails, and the call is meant to give
+// up. A nil *RequestMemory has no budget.
+type RequestMemory struct {
+	limit int
+
+	mutex    sync.Mutex
+	used     int
+	exceeded bool
+}
+
+// NewRequestMemory returns an account with a budget of limit bytes.
+func NewRequestMemory(limit int) *RequestMemory {
+	return &RequestMemory{limit: limit}
+}
+
+type requestMemoryKey struct{}
+
+// WithRequestMemory attaches m to ctx, for the call's handlers to reserve
+// from.
+func WithRequestMemory(ctx context.Context, m *RequestMemory) context.Context {
+	return context.WithValue(ctx, requestMemoryKey{}, m)
+}
+
+// RequestMemoryFromContext returns the account attached to ctx, or nil if
+// there's none.
+func RequestMemoryFromContext(ctx context.Context) *RequestMemory {
+	m, _ := ctx.Value(requestMemoryKey{}).(*RequestMemory)
+	return m
+}
+
+// Reserve accounts for n more bytes, or fails if that goes over the budget.
+func (m *RequestMemory) Reserve(n int) error {
+	if m == nil {
+		return nil
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.used+n > m.limit {
+		m.exceeded = true
+		return errors.Errorf("call needs more than its memory budget of %d bytes", m.limit)
+	}
+	m.used += n
+	return nil
+}
+
+// Release gives back n bytes reserved earlier.
+func (m *RequestMemory) Release(n int) {
+	if m == nil {
+		return
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.used -= n
+}
+
+// Exceeded reports whether a reservation ever failed.
+func (m *RequestMemory) Exceeded() bool {
+	if m == nil {
+		return false
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.exceeded
+}
@@ -0,0 +1,8 @@
+//go:build !cgo
+// +build !cgo
+
+package common
+
+// SQLiteSupported is whether this build can open SQLite databases, which
+// takes cgo: the address index and a persistent tree state store need it.
+const SQLiteSupported = false
@@ -0,0 +1,125 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Status is the sync state written to the status file, for tooling that
+// would rather read a file than scrape metrics.
+type Status struct {
+	// CacheHeight is the latest block in the cache.
+	CacheHeight int `json:"cacheHeight"`
+	// NodeHeight is zcashd's latest block, zero if it couldn't be reached.
+	NodeHeight int `json:"nodeHeight"`
+	// Lag is how many blocks the cache is behind zcashd.
+	Lag int `json:"lag"`
+	// WarmedUp is set once the historical ingestor has filled the cache, or
+	// given up, with WarmUpError saying why.
+	WarmedUp      bool      `json:"warmedUp"`
+	WarmUpError   string    `json:"warmUpError,omitempty"`
+	NodeConnected bool      `json:"nodeConnected"`
+	Updated       time.Time `json:"updated"`
+}
+
+// StatusFile periodically writes the Status of the cache and node to a file.
+type StatusFile struct {
+	path      string
+	rpcClient RPCClient
+	cache     *BlockCache
+	log       *logrus.Entry
+}
+
+func NewStatusFile(path string, rpcClient RPCClient, cache *BlockCache, log *logrus.Entry) *StatusFile {
+	return &StatusFile{
+		path:      path,
+		rpcClient: rpcClient,
+		cache:     cache,
+		log:       log,
+	}
+}
+
+// Update writes the current status.
+func (f *StatusFile) Update() error {
+	status := &Status{
+		CacheHeight: f.cache.GetLatestBlock(),
+		WarmedUp:    f.cache.WarmedUp(),
+		Updated:     time.Now().UTC(),
+	}
+	if err := f.cache.WarmUpError(); err != nil {
+		status.WarmUpError = err.Error()
+	}
+	if info, err := GetChainInfo(f.rpcClient); err == nil {
+		status.NodeConnected = true
+		status.NodeHeight = info.Blocks
+		status.Lag = info.Blocks - status.CacheHeight
+	}
+
+	data, err := json.Marshal(status)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(f.path, data)
+}
+
+// Run updates the status file every interval, forever.
+func (f *StatusFile) Run(interval time.Duration) {
+	for {
+		if err := f.Update(); err != nil {
+			f.log.WithFields(logrus.Fields{
+				"path":  f.path,
+				"error": err,
+			}).Warn("couldn't write status file")
+		}
+		time.Sleep(interval)
+	}
+}
+
+// StatusLine is what ServeStatusPort writes: the cache's tip, and whether the
+// historical ingestor has filled the cache.
+func StatusLine(cache *BlockCache) string {
+	return fmt.Sprintf("height=%d synced=%t\n", cache.GetLatestBlock(), cache.WarmedUp())
+}
+
+// ServeStatusPort writes the StatusLine to each connection accepted by
+// listener, then closes it, for probes that can only open a socket. It
+// returns when listener is closed.
+func ServeStatusPort(listener net.Listener, cache *BlockCache) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			defer conn.Close()
+			conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+			conn.Write([]byte(StatusLine(cache)))
+		}()
+	}
+}
+
+// writeFileAtomic replaces the file at path with data, so that readers see
+// either the old or the new contents in full.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
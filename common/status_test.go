@@ -0,0 +1,112 @@
+package common
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// heightNode reports blocks from getblockchaininfo, or fails while down.
+type heightNode struct {
+	mutex  sync.Mutex
+	blocks int
+	down   bool
+}
+
+func (n *heightNode) RawRequest(method string, params []json.RawMessage) (json.RawMessage, error) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	if n.down {
+		return nil, errors.New("connection refused")
+	}
+	return json.RawMessage(fmt.Sprintf(`{"blocks": %d}`, n.blocks)), nil
+}
+
+func TestStatusFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lightwalletd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "status.json")
+
+	blocks := newFixtureNode(t)
+	cache := NewBlockCache(100, testLog)
+	for height := 289460; height <= 289462; height++ {
+		if err, _ := cache.Add(height, blocks.parsed(t, height).ToCompact()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	node := &heightNode{blocks: 289465}
+	go NewStatusFile(path, node, cache, testLog).Run(10 * time.Millisecond)
+
+	// waitFor reads the status file until it matches want.
+	waitFor := func(want Status) {
+		var status Status
+		deadline := time.Now().Add(5 * time.Second)
+		for time.Now().Before(deadline) {
+			if data, err := ioutil.ReadFile(path); err == nil {
+				if err := json.Unmarshal(data, &status); err != nil {
+					t.Fatalf("partial status file: %v", err)
+				}
+				status.Updated = time.Time{}
+				if status == want {
+					return
+				}
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		t.Fatalf("status file has %+v, expected %+v", status, want)
+	}
+	waitFor(Status{CacheHeight: 289462, NodeHeight: 289465, Lag: 3, NodeConnected: true})
+
+	if err, _ := cache.Add(289463, blocks.parsed(t, 289463).ToCompact()); err != nil {
+		t.Fatal(err)
+	}
+	cache.setWarmedUp(nil)
+	waitFor(Status{CacheHeight: 289463, NodeHeight: 289465, Lag: 2, WarmedUp: true, NodeConnected: true})
+
+	node.mutex.Lock()
+	node.down = true
+	node.mutex.Unlock()
+	waitFor(Status{CacheHeight: 289463, WarmedUp: true})
+}
+
+func TestServeStatusPort(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	cache := NewBlockCache(100, testLog)
+	go ServeStatusPort(listener, cache)
+
+	probe := func() string {
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		line, err := ioutil.ReadAll(conn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(line)
+	}
+
+	if line := probe(); line != "height=-1 synced=false\n" {
+		t.Errorf("unexpected status line for an empty cache: %q", line)
+	}
+	cache.Add(289460, testCompactBlock(289460, nil))
+	cache.setWarmedUp(nil)
+	if line := probe(); line != "height=289460 synced=true\n" {
+		t.Errorf("unexpected status line: %q", line)
+	}
+}
@@ -0,0 +1,74 @@
+package common
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/adityapk00/lightwalletd/walletrpc"
+	"github.com/golang/protobuf/proto"
+)
+
+// EvictionHook is told about each block a BlockCache drops to make room, so
+// that it can be kept somewhere else. Blocks dropped by reorgs are no longer
+// on the chain and aren't passed on. Hooks are called with the cache locked.
+type EvictionHook interface {
+	Evicted(height int, entry *BlockCacheEntry) error
+}
+
+// BlockTier is an EvictionHook that keeps the blocks it's given, so that a
+// block missing from the cache can be served from the tier instead of
+// fetched from the node again.
+type BlockTier interface {
+	EvictionHook
+	// Get returns the entry kept for height, or nil if there isn't one.
+	Get(height int) *BlockCacheEntry
+}
+
+// noEvictionHook is the default EvictionHook, which lets evicted blocks go.
+type noEvictionHook struct{}
+
+func (noEvictionHook) Evicted(height int, entry *BlockCacheEntry) error {
+	return nil
+}
+
+// dirBlockTier is a BlockTier keeping each block in a file of its own in a
+// local directory.
+type dirBlockTier struct {
+	dir string
+}
+
+// NewDirBlockTier returns a BlockTier keeping evicted blocks in dir, which is
+// created if needed.
+func NewDirBlockTier(dir string) (BlockTier, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &dirBlockTier{dir: dir}, nil
+}
+
+func (t *dirBlockTier) path(height int) string {
+	return filepath.Join(t.dir, strconv.Itoa(height)+".block")
+}
+
+func (t *dirBlockTier) Evicted(height int, entry *BlockCacheEntry) error {
+	// Written aside and renamed, so that a crash never leaves half a block.
+	tmp := t.path(height) + ".tmp"
+	if err := ioutil.WriteFile(tmp, entry.Data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, t.path(height))
+}
+
+func (t *dirBlockTier) Get(height int) *BlockCacheEntry {
+	data, err := ioutil.ReadFile(t.path(height))
+	if err != nil {
+		return nil
+	}
+	block := &walletrpc.CompactBlock{}
+	if err := proto.Unmarshal(data, block); err != nil {
+		return nil
+	}
+	return &BlockCacheEntry{Data: data, Hash: block.Hash}
+}
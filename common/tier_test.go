@@ -0,0 +1,91 @@
+package common
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// memoryTier is a BlockTier in a map.
+type memoryTier map[int]*BlockCacheEntry
+
+func (t memoryTier) Evicted(height int, entry *BlockCacheEntry) error {
+	t[height] = entry
+	return nil
+}
+
+func (t memoryTier) Get(height int) *BlockCacheEntry {
+	return t[height]
+}
+
+func TestBlockCacheTier(t *testing.T) {
+	tier := memoryTier{}
+	cache := NewBlockCache(3, testLog)
+	cache.SetEvictionHook(tier)
+
+	var prevHash []byte
+	for height := 100; height < 106; height++ {
+		block := testCompactBlock(height, prevHash)
+		if err, _ := cache.Add(height, block); err != nil {
+			t.Fatal(err)
+		}
+		prevHash = block.Hash
+	}
+	if len(tier) != 3 || tier[100] == nil || tier[102] == nil {
+		t.Fatalf("expected blocks 100 to 102 in the tier, got %d blocks", len(tier))
+	}
+
+	// Blocks dropped by a reorg aren't on the chain any more.
+	if err, _ := cache.Add(104, testCompactBlock(104, []byte("hash-103"))); err != nil {
+		t.Fatal(err)
+	}
+	if tier[105] != nil {
+		t.Error("block dropped by a reorg was tiered")
+	}
+
+	// The node has no blocks at all: evicted ones come back from the tier.
+	node := cannedNode{}
+	block, err := LookupBlock(node, cache, 101, CacheFirst)
+	if err != nil {
+		t.Fatalf("evicted block wasn't rehydrated: %v", err)
+	}
+	if block.Height != 101 || !bytes.Equal(block.Hash, []byte("hash-101")) {
+		t.Errorf("rehydrated the wrong block: %v", block)
+	}
+	if _, err := LookupBlock(node, cache, 99, CacheFirst); err == nil {
+		t.Error("expected a block in neither the cache nor the tier to go to the node")
+	}
+}
+
+func TestDirBlockTier(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lightwalletd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tier, err := NewDirBlockTier(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache := NewBlockCache(1, testLog)
+	cache.SetEvictionHook(tier)
+	if err, _ := cache.Add(7, testCompactBlock(7, nil)); err != nil {
+		t.Fatal(err)
+	}
+	if err, _ := cache.Add(8, testCompactBlock(8, []byte("hash-7"))); err != nil {
+		t.Fatal(err)
+	}
+
+	if tier.Get(8) != nil {
+		t.Error("found a block that wasn't evicted")
+	}
+	entry := tier.Get(7)
+	if entry == nil || !bytes.Equal(entry.Hash, []byte("hash-7")) {
+		t.Fatalf("evicted block not kept, got %v", entry)
+	}
+	if block := cache.getFromTier(7); block == nil || block.Height != 7 {
+		t.Errorf("couldn't rehydrate the block, got %v", block)
+	}
+}
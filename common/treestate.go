@@ -0,0 +1,142 @@
+package common
+
+import (
+	"container/list"
+	"database/sql"
+	"sync"
+
+	"github.com/adityapk00/lightwalletd/walletrpc"
+	"github.com/golang/protobuf/proto"
+	"github.com/pkg/errors"
+)
+
+// TreeStateStore keeps the commitment tree states the node returned, by the
+// hash of their block, so that wallets asking for the same checkpoint again
+// don't cost a call to the node. A block's tree state never changes, so
+// they are only dropped to stay within the store's size, oldest first. The
+// store is kept in an SQLite database, to survive restarts, or else in
+// memory, which doesn't need SQLite or cgo.
+//
+// All methods may be called on a nil *TreeStateStore, which keeps nothing.
+type TreeStateStore struct {
+	db  *sql.DB
+	max int
+
+	// Without a database, the marshaled tree states, by hash, and their
+	// hashes, oldest first.
+	mutex  sync.Mutex
+	states map[string]*list.Element
+	order  *list.List
+}
+
+// treeStateEntry is a tree state kept in memory.
+type treeStateEntry struct {
+	hash  string
+	state []byte
+}
+
+// treeStateVersion is the version of treeStateSchema, to be raised with any
+// change to it.
+const treeStateVersion = 1
+
+const treeStateSchema = `
+CREATE TABLE IF NOT EXISTS tree_states (
+	hash BLOB PRIMARY KEY,
+	state BLOB NOT NULL
+);
+`
+
+// NewTreeStateStore opens the store kept in the SQLite database at path,
+// creating it if needed, or again empty if it was made for an older schema,
+// which keeps at most max tree states. With no path, the store is kept in
+// memory.
+func NewTreeStateStore(path string, max int) (*TreeStateStore, error) {
+	if max <= 0 {
+		return nil, errors.New("tree state store size must be positive")
+	}
+	if path == "" {
+		return &TreeStateStore{max: max, states: make(map[string]*list.Element), order: list.New()}, nil
+	}
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, errors.Wrap(err, "error opening tree state store")
+	}
+	db.SetMaxOpenConns(1)
+	if err := openSchema(db, treeStateSchema, treeStateVersion); err != nil {
+		db.Close()
+		return nil, errors.Wrap(err, "error creating tree state store")
+	}
+	return &TreeStateStore{db: db, max: max}, nil
+}
+
+// Close closes the database.
+func (st *TreeStateStore) Close() error {
+	if st == nil || st.db == nil {
+		return nil
+	}
+	return st.db.Close()
+}
+
+// Get returns the tree state as of the block with hash, in little-endian
+// wire order, or nil if it isn't kept.
+func (st *TreeStateStore) Get(hash []byte) *walletrpc.TreeState {
+	if st == nil {
+		return nil
+	}
+	var data []byte
+	if st.db == nil {
+		st.mutex.Lock()
+		if element, ok := st.states[string(hash)]; ok {
+			data = element.Value.(*treeStateEntry).state
+		}
+		st.mutex.Unlock()
+		if data == nil {
+			return nil
+		}
+	} else if err := st.db.QueryRow("SELECT state FROM tree_states WHERE hash = ?", hash).Scan(&data); err != nil {
+		return nil
+	}
+	state := &walletrpc.TreeState{}
+	if err := proto.Unmarshal(data, state); err != nil {
+		return nil
+	}
+	return state
+}
+
+// Add keeps state, the tree state as of the block with hash, dropping the
+// oldest kept if the store is full.
+func (st *TreeStateStore) Add(hash []byte, state *walletrpc.TreeState) error {
+	if st == nil {
+		return nil
+	}
+	data, err := proto.Marshal(state)
+	if err != nil {
+		return err
+	}
+	if st.db == nil {
+		st.addToMemory(string(hash), data)
+		return nil
+	}
+	if _, err := st.db.Exec("INSERT OR REPLACE INTO tree_states (hash, state) VALUES (?, ?)", hash, data); err != nil {
+		return errors.Wrap(err, "error adding tree state")
+	}
+	_, err = st.db.Exec(`DELETE FROM tree_states WHERE rowid NOT IN
+		(SELECT rowid FROM tree_states ORDER BY rowid DESC LIMIT ?)`, st.max)
+	return errors.Wrap(err, "error trimming tree states")
+}
+
+// addToMemory keeps the marshaled state of the block with hash in memory, as
+// the newest, dropping the oldest if there are too many.
+func (st *TreeStateStore) addToMemory(hash string, state []byte) {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+	if element, ok := st.states[hash]; ok {
+		st.order.Remove(element)
+	}
+	st.states[hash] = st.order.PushBack(&treeStateEntry{hash: hash, state: state})
+	for st.order.Len() > st.max {
+		oldest := st.order.Front()
+		st.order.Remove(oldest)
+		delete(st.states, oldest.Value.(*treeStateEntry).hash)
+	}
+}
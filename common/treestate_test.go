@@ -0,0 +1,99 @@
+package common
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/adityapk00/lightwalletd/walletrpc"
+)
+
+func TestTreeStateStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lightwalletd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if !SQLiteSupported {
+		t.Skip("SQLite needs cgo")
+	}
+	path := filepath.Join(dir, "treestates.db")
+
+	store, err := NewTreeStateStore(path, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for height := 1; height <= 3; height++ {
+		state := &walletrpc.TreeState{Height: uint64(height), Tree: "00"}
+		if err := store.Add([]byte{byte(height)}, state); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The oldest was dropped to make room.
+	if state := store.Get([]byte{1}); state != nil {
+		t.Errorf("expected the first tree state to be dropped, got %v", state)
+	}
+	store.Close()
+
+	// The others survive a restart.
+	store, err = NewTreeStateStore(path, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	for height := 2; height <= 3; height++ {
+		if state := store.Get([]byte{byte(height)}); state == nil || state.Height != uint64(height) {
+			t.Errorf("tree state %d: got %v", height, state)
+		}
+	}
+
+	var version int
+	if err := store.db.QueryRow("PRAGMA user_version").Scan(&version); err != nil || version != treeStateVersion {
+		t.Errorf("schema version %d, expected %d: %v", version, treeStateVersion, err)
+	}
+
+	// A database written by a later lightwalletd is refused.
+	if _, err := store.db.Exec("PRAGMA user_version = 99"); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+	if _, err := NewTreeStateStore(path, 2); err == nil {
+		t.Error("expected a newer schema to be refused")
+	}
+
+	var none *TreeStateStore
+	if none.Add([]byte{1}, &walletrpc.TreeState{}) != nil || none.Get([]byte{1}) != nil {
+		t.Error("nil store isn't empty")
+	}
+}
+
+func TestTreeStateStoreMemory(t *testing.T) {
+	// Without a path, tree states are kept in memory, without SQLite.
+	store, err := NewTreeStateStore("", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if store.db != nil {
+		t.Error("expected no database without a path")
+	}
+	for height := 1; height <= 3; height++ {
+		state := &walletrpc.TreeState{Height: uint64(height), Tree: "00"}
+		if err := store.Add([]byte{byte(height)}, state); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Adding one kept already makes it the newest.
+	if err := store.Add([]byte{2}, &walletrpc.TreeState{Height: 2, Tree: "00"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Add([]byte{4}, &walletrpc.TreeState{Height: 4, Tree: "00"}); err != nil {
+		t.Fatal(err)
+	}
+	for height, kept := range map[int]bool{1: false, 2: true, 3: false, 4: true} {
+		if state := store.Get([]byte{byte(height)}); (state != nil) != kept || (kept && state.Height != uint64(height)) {
+			t.Errorf("in memory, tree state %d: got %v, expected kept %v", height, state, kept)
+		}
+	}
+}
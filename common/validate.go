@@ -0,0 +1,102 @@
+package common
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/adityapk00/lightwalletd/parser"
+	"github.com/adityapk00/lightwalletd/walletrpc"
+	"github.com/golang/protobuf/proto"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// BlockValidator checks a random sample of the ingested blocks: the compact
+// block the cache serves is compared with the full block it was made from,
+// to catch blocks mangled on their way into, or while in, the cache. A nil
+// *BlockValidator checks nothing.
+type BlockValidator struct {
+	// Rate is the fraction of blocks checked, 1 for all of them.
+	Rate float64
+
+	mutex   sync.Mutex
+	random  func() float64
+	metrics *PrometheusMetrics
+	log     *logrus.Entry
+}
+
+// NewBlockValidator returns a validator checking rate of the blocks, or nil
+// if rate isn't positive.
+func NewBlockValidator(rate float64, metrics *PrometheusMetrics, log *logrus.Entry) *BlockValidator {
+	if rate <= 0 {
+		return nil
+	}
+	return &BlockValidator{
+		Rate:    rate,
+		random:  rand.New(rand.NewSource(time.Now().UnixNano())).Float64,
+		metrics: metrics,
+		log:     log,
+	}
+}
+
+// sample decides whether the next block is checked.
+func (v *BlockValidator) sample() bool {
+	if v == nil {
+		return false
+	}
+	if v.Rate >= 1 {
+		return true
+	}
+
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	return v.random() < v.Rate
+}
+
+// Validate compares cached, the compact block served at full's height, with
+// full. Mismatches are logged and counted, and returned.
+func (v *BlockValidator) Validate(full *parser.Block, cached *walletrpc.CompactBlock) error {
+	v.metrics.BlockValidations.Inc()
+
+	err := compareCompactBlocks(compactBlock(full), cached)
+	if err != nil {
+		v.metrics.BlockValidationMismatches.Inc()
+		v.log.WithFields(logrus.Fields{
+			"height": full.GetHeight(),
+			"hash":   displayHash(full.GetEncodableHash()),
+			"error":  err,
+		}).Error("cached compact block doesn't match the full block")
+	}
+	return err
+}
+
+// compareCompactBlocks describes the first difference between want and got.
+func compareCompactBlocks(want, got *walletrpc.CompactBlock) error {
+	switch {
+	case got == nil:
+		return errors.New("block missing from the cache")
+	case got.Height != want.Height:
+		return fmt.Errorf("height is %d, expected %d", got.Height, want.Height)
+	case !bytes.Equal(got.Hash, want.Hash):
+		return fmt.Errorf("hash is %s, expected %s", displayHash(got.Hash), displayHash(want.Hash))
+	case !bytes.Equal(got.PrevHash, want.PrevHash):
+		return fmt.Errorf("previous hash is %s, expected %s", displayHash(got.PrevHash), displayHash(want.PrevHash))
+	case got.Time != want.Time:
+		return fmt.Errorf("time is %d, expected %d", got.Time, want.Time)
+	case len(got.Vtx) != len(want.Vtx):
+		return fmt.Errorf("%d shielded transactions, expected %d", len(got.Vtx), len(want.Vtx))
+	}
+	for i, tx := range want.Vtx {
+		if !proto.Equal(got.Vtx[i], tx) {
+			return fmt.Errorf("transaction %d (%s) differs", tx.Index, displayHash(tx.Hash))
+		}
+	}
+	if !proto.Equal(got, want) {
+		return errors.New("blocks differ")
+	}
+	return nil
+}
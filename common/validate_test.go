@@ -0,0 +1,110 @@
+package common
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/adityapk00/lightwalletd/walletrpc"
+	"github.com/golang/protobuf/proto"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestBlockValidatorSampling(t *testing.T) {
+	if v := NewBlockValidator(0, GetPrometheusMetrics(), testLog); v != nil || v.sample() {
+		t.Error("a zero rate should disable validation")
+	}
+
+	all := NewBlockValidator(1, GetPrometheusMetrics(), testLog)
+	for i := 0; i < 1000; i++ {
+		if !all.sample() {
+			t.Fatal("a rate of 1 skipped a block")
+		}
+	}
+
+	some := NewBlockValidator(0.01, GetPrometheusMetrics(), testLog)
+	some.random = rand.New(rand.NewSource(1)).Float64
+	sampled := 0
+	for i := 0; i < 100000; i++ {
+		if some.sample() {
+			sampled++
+		}
+	}
+	if sampled < 800 || sampled > 1200 {
+		t.Errorf("sampled %d of 100000 blocks at a rate of 1%%", sampled)
+	}
+}
+
+func TestBlockValidatorMismatch(t *testing.T) {
+	node := newFixtureNode(t)
+	full := node.parsed(t, 289463)
+	metrics := GetPrometheusMetrics()
+	v := NewBlockValidator(1, metrics, testLog)
+
+	if err := v.Validate(full, compactBlock(full)); err != nil {
+		t.Errorf("matching block rejected: %v", err)
+	}
+	wrongTime := compactBlock(full)
+	wrongTime.Time++
+	if err := v.Validate(full, wrongTime); err == nil {
+		t.Error("block with the wrong time accepted")
+	}
+	if err := v.Validate(full, nil); err == nil {
+		t.Error("missing block accepted")
+	}
+
+	if n := testutil.ToFloat64(metrics.BlockValidations); n != 3 {
+		t.Errorf("counted %v validations, expected 3", n)
+	}
+	if n := testutil.ToFloat64(metrics.BlockValidationMismatches); n != 2 {
+		t.Errorf("counted %v mismatches, expected 2", n)
+	}
+}
+
+// corruptingStore changes the time of the block at height as it's stored.
+type corruptingStore struct {
+	BlockCacheStore
+	height int
+}
+
+func (s *corruptingStore) Put(height int, entry *BlockCacheEntry) error {
+	if height == s.height {
+		block := &walletrpc.CompactBlock{}
+		if err := proto.Unmarshal(entry.Data, block); err != nil {
+			return err
+		}
+		block.Time++
+		data, err := proto.Marshal(block)
+		if err != nil {
+			return err
+		}
+		entry = &BlockCacheEntry{Data: data, Hash: entry.Hash}
+	}
+	return s.BlockCacheStore.Put(height, entry)
+}
+
+func TestBlockIngestorValidation(t *testing.T) {
+	node := newFixtureNode(t)
+	metrics := GetPrometheusMetrics()
+	store := &corruptingStore{BlockCacheStore: NewMemoryBlockCacheStore(), height: 289463}
+	cache := NewBlockCacheWithStore(100, testLog, store)
+
+	stopChan := make(chan bool, 1)
+	defer func() { stopChan <- true }()
+	go BlockIngestor(node, cache, testLog, stopChan, 289460, IngestorOptions{
+		PollInterval: 10 * time.Millisecond,
+		Prefetch:     1,
+		Validator:    NewBlockValidator(1, metrics, testLog),
+	})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for testutil.ToFloat64(metrics.BlockValidations) != 6 {
+		if time.Now().After(deadline) {
+			t.Fatalf("validated %v blocks, expected 6", testutil.ToFloat64(metrics.BlockValidations))
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if n := testutil.ToFloat64(metrics.BlockValidationMismatches); n != 1 {
+		t.Errorf("counted %v mismatches, expected the corrupted block only", n)
+	}
+}
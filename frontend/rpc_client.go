@@ -1,14 +1,35 @@
 package frontend
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
 	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/btcsuite/btcd/btcjson"
 	"github.com/btcsuite/btcd/rpcclient"
 	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
 	ini "gopkg.in/ini.v1"
+
+	"github.com/adityapk00/lightwalletd/common"
 )
 
-func NewZRPCFromConf(confPath string) (*rpcclient.Client, error) {
+// NewZRPCFromConf makes a client for the zcashd RPC server described by the
+// zcash.conf file at confPath. With rpcssl=1 the connection uses TLS,
+// verifying the server against the CAs in the PEM file rpcsslcafile, or the
+// system's if it's not set, and, if rpcsslpin is set, checking that the
+// SHA-256 fingerprint of its certificate is that hex string.
+func NewZRPCFromConf(confPath string) (common.RPCClient, error) {
 	cfg, err := ini.Load(confPath)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to read config file")
@@ -34,7 +55,29 @@ func NewZRPCFromConf(confPath string) (*rpcclient.Client, error) {
 		return nil, errors.Wrap(err, "username and/or password are not set in config file")
 	}
 
-	return NewZRPCFromCreds(net.JoinHostPort(rpcaddr, rpcport), username, password)
+	addr := net.JoinHostPort(rpcaddr, rpcport)
+	caPath := cfg.Section("").Key("rpcsslcafile").String()
+	pin := cfg.Section("").Key("rpcsslpin").String()
+	if !cfg.Section("").Key("rpcssl").MustBool(false) {
+		if caPath != "" || pin != "" {
+			return nil, errors.New("rpcsslcafile and rpcsslpin need rpcssl=1")
+		}
+		client, err := NewZRPCFromCreds(addr, username, password)
+		if err != nil {
+			return nil, err
+		}
+		return batchingRPCClient{client, &httpRPCClient{
+			url:      "http://" + addr,
+			username: username,
+			password: password,
+			client:   &http.Client{},
+		}}, nil
+	}
+	tlsConfig, err := newRPCTLSConfig(caPath, pin)
+	if err != nil {
+		return nil, err
+	}
+	return NewZRPCFromCredsTLS(addr, username, password, tlsConfig), nil
 }
 
 func NewZRPCFromCreds(addr, username, password string) (*rpcclient.Client, error) {
@@ -50,3 +93,393 @@ func NewZRPCFromCreds(addr, username, password string) (*rpcclient.Client, error
 	// not supported in HTTP POST mode.
 	return rpcclient.New(connCfg, nil)
 }
+
+// NewZRPCFromCredsTLS returns a client for the zcashd RPC server at addr,
+// connecting with tlsConfig.
+func NewZRPCFromCredsTLS(addr, username, password string, tlsConfig *tls.Config) common.RPCClient {
+	return &httpRPCClient{
+		url:      "https://" + addr,
+		username: username,
+		password: password,
+		client: &http.Client{
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: tlsConfig,
+			},
+		},
+	}
+}
+
+// newRPCTLSConfig returns the TLS configuration for a zcashd RPC server
+// whose certificate is issued by one of the CAs in the PEM file caPath, or
+// by one the system trusts if it's empty, and, if pin is set, has that hex
+// SHA-256 fingerprint. A pinned certificate is trusted whatever its issuer,
+// unless caPath is set too.
+func newRPCTLSConfig(caPath, pin string) (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caPath != "" {
+		pem, err := ioutil.ReadFile(caPath)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read rpcsslcafile")
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no PEM certificates found in rpcsslcafile")
+		}
+	}
+	if pin == "" {
+		return config, nil
+	}
+
+	// Fingerprints are often written with colons, as openssl prints them.
+	want, err := hex.DecodeString(strings.Replace(pin, ":", "", -1))
+	if err != nil || len(want) != sha256.Size {
+		return nil, errors.New("rpcsslpin is not a hex SHA-256 fingerprint")
+	}
+	config.InsecureSkipVerify = caPath == ""
+	config.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return errors.New("zcashd presented no certificate")
+		}
+		got := sha256.Sum256(rawCerts[0])
+		if !bytes.Equal(got[:], want) {
+			return errors.Errorf("zcashd's certificate fingerprint %x doesn't match rpcsslpin", got)
+		}
+		return nil
+	}
+	return config, nil
+}
+
+// httpRPCClient calls zcashd's JSON-RPC interface with HTTP POSTs, as
+// rpcclient does in HTTP POST mode. It's used over HTTPS, as rpcclient can't
+// check the server's certificate against a pinned one, and for batches,
+// which rpcclient can't send.
+type httpRPCClient struct {
+	url      string
+	username string
+	password string
+	client   *http.Client
+	nextID   uint64
+}
+
+type rpcReply struct {
+	Result json.RawMessage   `json:"result"`
+	Error  *btcjson.RPCError `json:"error"`
+	ID     uint64            `json:"id"`
+}
+
+func (c *httpRPCClient) request(method string, params []json.RawMessage) *btcjson.Request {
+	if params == nil {
+		params = []json.RawMessage{}
+	}
+	return &btcjson.Request{
+		Jsonrpc: "1.0",
+		ID:      atomic.AddUint64(&c.nextID, 1),
+		Method:  method,
+		Params:  params,
+	}
+}
+
+// post sends body, and decodes the answer into reply.
+func (c *httpRPCClient) post(body, reply interface{}) error {
+	reqBody, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", c.url, bytes.NewReader(reqBody))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.SetBasicAuth(c.username, c.password)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(respBody, reply); err != nil {
+		return errors.Errorf("status code: %d, response: %q", resp.StatusCode, respBody)
+	}
+	return nil
+}
+
+func (c *httpRPCClient) RawRequest(method string, params []json.RawMessage) (json.RawMessage, error) {
+	var reply rpcReply
+	if err := c.post(c.request(method, params), &reply); err != nil {
+		return nil, err
+	}
+	if reply.Error != nil {
+		return nil, reply.Error
+	}
+	return reply.Result, nil
+}
+
+func (c *httpRPCClient) BatchRequest(method string, params [][]json.RawMessage) ([]json.RawMessage, []error, error) {
+	requests := make([]*btcjson.Request, len(params))
+	index := make(map[uint64]int, len(params))
+	for i := range params {
+		requests[i] = c.request(method, params[i])
+		index[requests[i].ID.(uint64)] = i
+	}
+	var replies []rpcReply
+	if err := c.post(requests, &replies); err != nil {
+		return nil, nil, err
+	}
+
+	results := make([]json.RawMessage, len(params))
+	errs := make([]error, len(params))
+	for i := range errs {
+		errs[i] = errors.New("no reply to the call in the batch")
+	}
+	for _, reply := range replies {
+		i, ok := index[reply.ID]
+		if !ok {
+			continue
+		}
+		results[i], errs[i] = reply.Result, nil
+		if reply.Error != nil {
+			errs[i] = reply.Error
+		}
+	}
+	return results, errs, nil
+}
+
+// batchingRPCClient makes single calls with rpcclient, and sends batches
+// itself, over plain HTTP.
+type batchingRPCClient struct {
+	*rpcclient.Client
+	batcher *httpRPCClient
+}
+
+func (c batchingRPCClient) BatchRequest(method string, params [][]json.RawMessage) ([]json.RawMessage, []error, error) {
+	return c.batcher.BatchRequest(method, params)
+}
+
+// broadcastMethods are the RPCs that must not be load-balanced: a transaction
+// is always sent to the primary backend (or to every backend if configured).
+var broadcastMethods = map[string]bool{
+	"sendrawtransaction": true,
+}
+
+type rpcBackend struct {
+	name      string
+	client    common.RPCClient
+	mutex     sync.Mutex
+	downUntil time.Time
+}
+
+func (b *rpcBackend) getClient() common.RPCClient {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return b.client
+}
+
+func (b *rpcBackend) healthy(now time.Time) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return !now.Before(b.downUntil)
+}
+
+// RPCPool distributes zcashd JSON-RPC calls over several backends. Read calls
+// are sent round-robin to the healthy backends, and fail over to the next one
+// if a backend can't be reached. Broadcasts stick to the first (primary)
+// backend unless BroadcastAll is set.
+type RPCPool struct {
+	// BroadcastAll sends transactions to every backend instead of only the primary.
+	BroadcastAll bool
+
+	// RetryInterval is how long a failed backend is skipped before it is tried again.
+	RetryInterval time.Duration
+
+	backends []*rpcBackend
+	next     uint32
+	metrics  *common.PrometheusMetrics
+	log      *logrus.Entry
+}
+
+func NewRPCPool(log *logrus.Entry, metrics *common.PrometheusMetrics) *RPCPool {
+	return &RPCPool{
+		RetryInterval: 30 * time.Second,
+		metrics:       metrics,
+		log:           log,
+	}
+}
+
+// AddBackend adds a backend to the pool. The first backend added is the primary.
+func (p *RPCPool) AddBackend(name string, client common.RPCClient) {
+	p.backends = append(p.backends, &rpcBackend{name: name, client: client})
+	p.metrics.RPCBackendUp.WithLabelValues(name).Set(1)
+}
+
+// SetBackendClient replaces the client of the backend called name, for
+// instance with one using new credentials, and returns the one it replaced.
+// Calls already made with the old client carry on with it.
+func (p *RPCPool) SetBackendClient(name string, client common.RPCClient) (common.RPCClient, error) {
+	for _, b := range p.backends {
+		if b.name == name {
+			b.mutex.Lock()
+			defer b.mutex.Unlock()
+
+			old := b.client
+			b.client = client
+			return old, nil
+		}
+	}
+	return nil, errors.Errorf("no backend called %s", name)
+}
+
+func (p *RPCPool) RawRequest(method string, params []json.RawMessage) (json.RawMessage, error) {
+	if len(p.backends) == 0 {
+		return nil, errors.New("no zcashd RPC backends configured")
+	}
+
+	if broadcastMethods[method] {
+		return p.broadcast(method, params)
+	}
+
+	var result json.RawMessage
+	err := p.pick(func(b *rpcBackend) error {
+		var err error
+		result, err = p.call(b, method, params)
+		return err
+	})
+	return result, err
+}
+
+// BatchRequest sends the batch to one backend, picked as RawRequest does,
+// in one round trip if it supports batches.
+func (p *RPCPool) BatchRequest(method string, params [][]json.RawMessage) ([]json.RawMessage, []error, error) {
+	if len(p.backends) == 0 {
+		return nil, nil, errors.New("no zcashd RPC backends configured")
+	}
+
+	var results []json.RawMessage
+	var errs []error
+	err := p.pick(func(b *rpcBackend) error {
+		p.metrics.RPCBackendRequests.WithLabelValues(b.name).Inc()
+
+		var err error
+		results, errs, err = common.BatchRequest(b.getClient(), method, params)
+		if err != nil {
+			p.metrics.RPCBackendErrors.WithLabelValues(b.name).Inc()
+			p.markDown(b, err)
+		} else {
+			p.markUp(b)
+		}
+		return err
+	})
+	return results, errs, err
+}
+
+// pick calls try with a backend, starting at the next one in round-robin
+// order, and walks the list until a healthy backend answers, that is until
+// try doesn't return a transport error. If none of them are marked healthy,
+// it tries them all anyway rather than failing outright. Each backend is
+// tried at most once.
+func (p *RPCPool) pick(try func(b *rpcBackend) error) error {
+	start := int(atomic.AddUint32(&p.next, 1)-1) % len(p.backends)
+	now := time.Now()
+	tried := make([]bool, len(p.backends))
+
+	var lastErr error
+	for _, onlyHealthy := range []bool{true, false} {
+		for i := 0; i < len(p.backends); i++ {
+			n := (start + i) % len(p.backends)
+			b := p.backends[n]
+			if tried[n] || b.healthy(now) != onlyHealthy {
+				continue
+			}
+			tried[n] = true
+
+			err := try(b)
+			if isTransportError(err) {
+				lastErr = err
+				continue
+			}
+			return err
+		}
+	}
+
+	return lastErr
+}
+
+func (p *RPCPool) broadcast(method string, params []json.RawMessage) (json.RawMessage, error) {
+	primary := p.backends[0]
+	if !p.BroadcastAll {
+		return p.call(primary, method, params)
+	}
+
+	// The primary's answer is the one returned to the caller. The others are
+	// sent concurrently and only logged.
+	var wg sync.WaitGroup
+	for _, b := range p.backends[1:] {
+		wg.Add(1)
+		go func(b *rpcBackend) {
+			defer wg.Done()
+			if _, err := p.call(b, method, params); err != nil {
+				p.log.WithFields(logrus.Fields{
+					"backend": b.name,
+					"method":  method,
+					"error":   err,
+				}).Warn("broadcast to secondary backend failed")
+			}
+		}(b)
+	}
+
+	result, err := p.call(primary, method, params)
+	wg.Wait()
+
+	return result, err
+}
+
+func (p *RPCPool) call(b *rpcBackend, method string, params []json.RawMessage) (json.RawMessage, error) {
+	p.metrics.RPCBackendRequests.WithLabelValues(b.name).Inc()
+
+	result, err := b.getClient().RawRequest(method, params)
+	if isTransportError(err) {
+		p.metrics.RPCBackendErrors.WithLabelValues(b.name).Inc()
+		p.markDown(b, err)
+	} else {
+		p.markUp(b)
+	}
+
+	return result, err
+}
+
+func (p *RPCPool) markDown(b *rpcBackend, err error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.downUntil = time.Now().Add(p.RetryInterval)
+	p.metrics.RPCBackendUp.WithLabelValues(b.name).Set(0)
+
+	p.log.WithFields(logrus.Fields{
+		"backend": b.name,
+		"error":   err,
+	}).Warn("zcashd RPC backend unreachable, routing around it")
+}
+
+func (p *RPCPool) markUp(b *rpcBackend) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.downUntil = time.Time{}
+	p.metrics.RPCBackendUp.WithLabelValues(b.name).Set(1)
+}
+
+// isTransportError reports whether err means the backend couldn't be reached,
+// as opposed to the backend answering with a JSON-RPC error.
+func isTransportError(err error) bool {
+	if err == nil {
+		return false
+	}
+	_, ok := err.(*btcjson.RPCError)
+	return !ok
+}
@@ -0,0 +1,328 @@
+package frontend
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/sirupsen/logrus"
+
+	"github.com/adityapk00/lightwalletd/common"
+)
+
+// mockBackend is a zcashd stand-in that counts the calls it receives.
+type mockBackend struct {
+	mutex sync.Mutex
+	calls map[string]int
+	down  bool
+}
+
+func newMockBackend() *mockBackend {
+	return &mockBackend{calls: make(map[string]int)}
+}
+
+func (m *mockBackend) RawRequest(method string, params []json.RawMessage) (json.RawMessage, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.calls[method]++
+	if m.down {
+		return nil, errors.New("connection refused")
+	}
+	if method == "getblock" && string(params[0]) == "\"-1\"" {
+		return nil, &btcjson.RPCError{Code: -8, Message: "Block height out of range"}
+	}
+	return json.RawMessage("\"ok\""), nil
+}
+
+func (m *mockBackend) count(method string) int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.calls[method]
+}
+
+func (m *mockBackend) setDown(down bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.down = down
+}
+
+func newTestPool(backends ...*mockBackend) *RPCPool {
+	logger := logrus.New()
+	logger.SetOutput(ioutil.Discard)
+
+	pool := NewRPCPool(logger.WithField("app", "test"), common.GetPrometheusMetrics())
+	for i, b := range backends {
+		pool.AddBackend(string('a'+rune(i)), b)
+	}
+	return pool
+}
+
+func TestRPCPoolRoundRobin(t *testing.T) {
+	a, b, c := newMockBackend(), newMockBackend(), newMockBackend()
+	pool := newTestPool(a, b, c)
+
+	for i := 0; i < 30; i++ {
+		if _, err := pool.RawRequest("getblockchaininfo", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i, m := range []*mockBackend{a, b, c} {
+		if n := m.count("getblockchaininfo"); n != 10 {
+			t.Errorf("backend %d got %d calls, expected 10", i, n)
+		}
+	}
+}
+
+func TestRPCPoolFailover(t *testing.T) {
+	a, b := newMockBackend(), newMockBackend()
+	pool := newTestPool(a, b)
+
+	a.setDown(true)
+	for i := 0; i < 10; i++ {
+		if _, err := pool.RawRequest("getblockchaininfo", nil); err != nil {
+			t.Fatal("expected failover to the healthy backend:", err)
+		}
+	}
+
+	// The failed backend is tried once, then skipped until RetryInterval passes.
+	if n := a.count("getblockchaininfo"); n != 1 {
+		t.Errorf("down backend got %d calls, expected 1", n)
+	}
+	if n := b.count("getblockchaininfo"); n != 10 {
+		t.Errorf("healthy backend got %d calls, expected 10", n)
+	}
+
+	// With every backend down, the pool still tries them, each once, and
+	// reports the error.
+	b.setDown(true)
+	if _, err := pool.RawRequest("getblockchaininfo", nil); err == nil {
+		t.Error("expected an error with all backends down")
+	}
+	if n := b.count("getblockchaininfo"); n != 11 {
+		t.Errorf("backend that just went down got %d calls, expected 11", n)
+	}
+
+	// Once a backend comes back it is used again.
+	a.setDown(false)
+	if _, err := pool.RawRequest("getblockchaininfo", nil); err != nil {
+		t.Error("expected the recovered backend to answer:", err)
+	}
+}
+
+func TestRPCPoolOneBackendDown(t *testing.T) {
+	a := newMockBackend()
+	pool := newTestPool(a)
+
+	// A backend that fails while marked healthy isn't tried again as an
+	// unhealthy one in the same call.
+	a.setDown(true)
+	if _, err := pool.RawRequest("getblockchaininfo", nil); err == nil {
+		t.Error("expected an error with the backend down")
+	}
+	if n := a.count("getblockchaininfo"); n != 1 {
+		t.Errorf("down backend got %d calls, expected 1", n)
+	}
+}
+
+func TestRPCPoolRPCErrorIsNotFailover(t *testing.T) {
+	a, b := newMockBackend(), newMockBackend()
+	pool := newTestPool(a, b)
+
+	_, err := pool.RawRequest("getblock", []json.RawMessage{json.RawMessage("\"-1\"")})
+	if _, ok := err.(*btcjson.RPCError); !ok {
+		t.Fatalf("expected the backend's RPC error, got %v", err)
+	}
+	if a.count("getblock")+b.count("getblock") != 1 {
+		t.Error("an RPC error from a healthy backend should not be retried elsewhere")
+	}
+}
+
+func TestRPCPoolBroadcast(t *testing.T) {
+	a, b, c := newMockBackend(), newMockBackend(), newMockBackend()
+	pool := newTestPool(a, b, c)
+
+	for i := 0; i < 3; i++ {
+		if _, err := pool.RawRequest("sendrawtransaction", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if a.count("sendrawtransaction") != 3 || b.count("sendrawtransaction") != 0 || c.count("sendrawtransaction") != 0 {
+		t.Error("transactions should only be sent to the primary")
+	}
+
+	pool.BroadcastAll = true
+	if _, err := pool.RawRequest("sendrawtransaction", nil); err != nil {
+		t.Fatal(err)
+	}
+	if a.count("sendrawtransaction") != 4 || b.count("sendrawtransaction") != 1 || c.count("sendrawtransaction") != 1 {
+		t.Error("expected the transaction to be sent to every backend")
+	}
+}
+
+func TestNewZRPCFromConfTLS(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req btcjson.Request
+		if user, pass, _ := r.BasicAuth(); user != "user" || pass != "pass" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.Method != "getinfo" {
+			fmt.Fprintf(w, `{"result":null,"error":{"code":-32601,"message":"Method not found"},"id":%v}`, req.ID)
+			return
+		}
+		fmt.Fprintf(w, `{"result":{"blocks":42},"error":null,"id":%v}`, req.ID)
+	}))
+	// The failed handshakes are expected.
+	server.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+	server.StartTLS()
+	defer server.Close()
+	host, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+
+	dir, err := ioutil.TempDir("", "lightwalletd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	caPath := filepath.Join(dir, "ca.pem")
+	if err := ioutil.WriteFile(caPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	fingerprint := sha256.Sum256(server.Certificate().Raw)
+	pin := hex.EncodeToString(fingerprint[:])
+
+	for _, tt := range []struct {
+		conf    string
+		wantErr bool
+	}{
+		// The test server's certificate isn't trusted by the system.
+		{"rpcssl=1", true},
+		{"rpcssl=1\nrpcsslcafile=" + caPath, false},
+		{"rpcssl=1\nrpcsslpin=" + pin, false},
+		{"rpcssl=1\nrpcsslcafile=" + caPath + "\nrpcsslpin=" + pin, false},
+		{"rpcssl=1\nrpcsslpin=" + pin[:len(pin)-2] + "00", true},
+		// The server doesn't speak plain HTTP.
+		{"rpcssl=0", true},
+	} {
+		confPath := filepath.Join(dir, "zcash.conf")
+		conf := fmt.Sprintf("rpcbind=%s\nrpcport=%s\nrpcuser=user\nrpcpassword=pass\n%s\n", host, port, tt.conf)
+		if err := ioutil.WriteFile(confPath, []byte(conf), 0600); err != nil {
+			t.Fatal(err)
+		}
+		client, err := NewZRPCFromConf(confPath)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.conf, err)
+		}
+		result, err := client.RawRequest("getinfo", nil)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%q: got %s, %v", tt.conf, result, err)
+		}
+		if !tt.wantErr && string(result) != `{"blocks":42}` {
+			t.Errorf("%q: got %s", tt.conf, result)
+		}
+	}
+
+	client := NewZRPCFromCredsTLS(server.Listener.Addr().String(), "user", "pass", server.Client().Transport.(*http.Transport).TLSClientConfig)
+	_, err = client.RawRequest("getbogus", nil)
+	if rpcErr, ok := err.(*btcjson.RPCError); !ok || rpcErr.Code != -32601 {
+		t.Errorf("expected the RPC error to be returned as is, got %v", err)
+	}
+
+	for _, conf := range []string{
+		"rpcsslpin=" + pin,
+		"rpcssl=1\nrpcsslpin=nothex",
+		"rpcssl=1\nrpcsslcafile=" + filepath.Join(dir, "missing.pem"),
+	} {
+		confPath := filepath.Join(dir, "zcash.conf")
+		if err := ioutil.WriteFile(confPath, []byte("rpcuser=user\nrpcpassword=pass\n"+conf+"\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := NewZRPCFromConf(confPath); err == nil {
+			t.Errorf("%q: expected the conf to be refused", conf)
+		}
+	}
+}
+
+func TestBatchRequest(t *testing.T) {
+	batches := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var reqs []btcjson.Request
+		if err := json.NewDecoder(r.Body).Decode(&reqs); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		batches++
+		// The replies to a batch may come in any order.
+		var replies []string
+		for i := len(reqs) - 1; i >= 0; i-- {
+			if string(reqs[i].Params[0]) == `"missing"` {
+				replies = append(replies, fmt.Sprintf(`{"result":null,"error":{"code":-5,"message":"No such transaction"},"id":%v}`, reqs[i].ID))
+			} else {
+				replies = append(replies, fmt.Sprintf(`{"result":%s,"error":null,"id":%v}`, reqs[i].Params[0], reqs[i].ID))
+			}
+		}
+		fmt.Fprintf(w, "[%s]", strings.Join(replies, ","))
+	}))
+	defer server.Close()
+	host, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+
+	dir, err := ioutil.TempDir("", "lightwalletd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	confPath := filepath.Join(dir, "zcash.conf")
+	conf := fmt.Sprintf("rpcbind=%s\nrpcport=%s\nrpcuser=user\nrpcpassword=pass\n", host, port)
+	if err := ioutil.WriteFile(confPath, []byte(conf), 0600); err != nil {
+		t.Fatal(err)
+	}
+	client, err := NewZRPCFromConf(confPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := newTestPool()
+	pool.AddBackend("batching", client)
+
+	params := [][]json.RawMessage{{json.RawMessage(`"a"`)}, {json.RawMessage(`"missing"`)}, {json.RawMessage(`"b"`)}}
+	results, errs, err := common.BatchRequest(pool, "getrawtransaction", params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if batches != 1 {
+		t.Errorf("expected one batch, sent %d", batches)
+	}
+	if string(results[0]) != `"a"` || errs[0] != nil || string(results[2]) != `"b"` || errs[2] != nil {
+		t.Errorf("expected the results in the order of the calls, got %s, %v", results, errs)
+	}
+	if rpcErr, ok := errs[1].(*btcjson.RPCError); !ok || rpcErr.Code != -5 {
+		t.Errorf("expected the RPC error of the second call, got %v", errs[1])
+	}
+
+	// Backends that can't batch are called once per call.
+	backend := newMockBackend()
+	results, errs, err = common.BatchRequest(newTestPool(backend), "getrawtransaction", params)
+	if err != nil || len(results) != 3 || errs[0] != nil || backend.count("getrawtransaction") != 3 {
+		t.Errorf("expected three calls, made %d: %v, %v", backend.count("getrawtransaction"), errs, err)
+	}
+}
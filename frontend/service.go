@@ -1,24 +1,34 @@
 package frontend
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"math"
 	"net"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
-	"github.com/btcsuite/btcd/rpcclient"
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/golang/protobuf/ptypes"
 	"github.com/sirupsen/logrus"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
 
 	"github.com/adityapk00/lightwalletd/common"
+	"github.com/adityapk00/lightwalletd/parser"
 	"github.com/adityapk00/lightwalletd/walletrpc"
 )
 
@@ -26,24 +36,300 @@ var (
 	ErrUnspecified = errors.New("request for unspecified identifier")
 )
 
+// transparentAddress matches a single t-address.
+var transparentAddress = regexp.MustCompile("^t[a-zA-Z0-9]{34}$")
+
+// maxBalanceAddresses is the most addresses GetTaddressBalance and
+// GetTaddressBalanceStream total in one call.
+const maxBalanceAddresses = 1000
+
+// Version is the server version reported by GetLightdInfo.
+const Version = "0.1-zeclightd"
+
+// compactFormatHeader is the request metadata key with which a client asks
+// for a particular version of the CompactBlock wire format.
+const compactFormatHeader = "compact-format-version"
+
+const (
+	// compactFormatV1 is the original CompactBlock, fields 1 to 7.
+	compactFormatV1 = 1
+	// compactFormatV2 adds fullBlock, checkpoint and coinbase.
+	compactFormatV2 = 2
+)
+
+// compactFormats are the CompactBlock versions clients can ask for, the
+// last being the one served by default.
+var compactFormats = []uint32{compactFormatV1, compactFormatV2}
+
+// CompactFormatVersions returns the CompactBlock versions served, the last
+// being the default.
+func CompactFormatVersions() []uint32 {
+	return append([]uint32(nil), compactFormats...)
+}
+
+// protocolVersionHeader is the request metadata key with which a client
+// gives the newest version of the service protocol it understands.
+const protocolVersionHeader = "protocol-version"
+
+const (
+	// protocolV1 is the original service, serving CompactBlocks in format 1.
+	protocolV1 = 1
+	// protocolV2 serves CompactBlocks in format 2.
+	protocolV2 = 2
+)
+
+// ProtocolVersion is the newest service protocol version, reported by
+// GetLightdInfo. A call is answered in the older of the client's and this.
+const ProtocolVersion = protocolV2
+
+// protocolCompactFormats is the CompactBlock version served at each protocol
+// version, unless the client asks for one with compactFormatHeader.
+var protocolCompactFormats = map[uint32]uint32{
+	protocolV1: compactFormatV1,
+	protocolV2: compactFormatV2,
+}
+
 type latencyCacheEntry struct {
 	timeNanos   int64
 	lastBlock   uint64
 	totalBlocks uint64
 }
 
+// Options are the operator-tunable behaviours of the service. The zero value
+// turns all the optional behaviours off.
+type Options struct {
+	// SendRequireSynced makes SendTransaction refuse to broadcast while the
+	// node is in initial block download or has verified less than
+	// SendMinVerificationProgress of the chain.
+	SendRequireSynced           bool
+	SendMinVerificationProgress float64
+
+	// SendCheckBranch makes SendTransaction refuse transactions whose
+	// version isn't valid on the node's next-block consensus branch.
+	SendCheckBranch bool
+
+	// SendDedupWindow, if non-zero, is how long SendTransaction remembers a
+	// transaction it broadcast successfully, answering resubmissions of it
+	// with the same result without asking the node again. At most
+	// SendDedupMax transactions are remembered.
+	SendDedupWindow time.Duration
+	SendDedupMax    int
+
+	// SendMinInputConfirmations, if non-zero, makes SendTransaction reject
+	// transactions that spend transparent outputs with fewer confirmations.
+	// The node needs to run with txindex.
+	SendMinInputConfirmations int
+
+	// DeprecatedMethods are advertised to clients by GetLightdInfo.
+	DeprecatedMethods []string
+
+	// SendRetryCodes are sendrawtransaction error codes on which
+	// SendTransaction tries once more, after SendRetryBackoff. Codes meaning
+	// the transaction itself was refused are not allowed.
+	SendRetryCodes   []int
+	SendRetryBackoff time.Duration
+
+	// MaxBlockRangeStreams, if non-zero, limits how many GetBlockRange
+	// streams are served at once. Further streams are refused with
+	// ResourceExhausted until one finishes.
+	MaxBlockRangeStreams int
+
+	// MaxFullBlockRequests, if non-zero, lets GetBlock callers ask for the
+	// full block with BlockID.includeFull, with at most this many such
+	// requests in flight at once.
+	MaxFullBlockRequests int
+
+	// MaxTransactionSize, if non-zero, is the size in bytes of the largest
+	// transaction GetTransaction returns. Larger ones are refused with
+	// OutOfRange, rather than failing on the client's message size limit.
+	MaxTransactionSize int
+
+	// TxCacheSize, if non-zero, is how many mined transactions
+	// GetTransaction remembers, for TxCacheTTL, so that a wallet fetching
+	// again a transaction it just fetched, or several wallets fetching the
+	// same one, don't each cost calls to the node. Transactions in the
+	// mempool aren't remembered, as their height isn't known yet.
+	TxCacheSize int
+	TxCacheTTL  time.Duration
+
+	// TxStatusWindow, if non-zero, is how long GetTxStatus remembers the
+	// inputs of the transactions SendTransaction broadcast, at most
+	// TxStatusMax of them, so that it can report those that lost to a
+	// conflicting transaction, and find them mined in the block cache.
+	TxStatusWindow time.Duration
+	TxStatusMax    int
+
+	// TransactionsBatch is the most transactions GetTransactions asks the
+	// node for in one batch of calls. Zero fetches them one at a time.
+	TransactionsBatch int
+
+	// TaddressTxidsPage is how many blocks GetTaddressTxids asks the node
+	// about at once, so that a long range doesn't become one large answer.
+	// Zero asks about the whole range at once.
+	TaddressTxidsPage int
+
+	// AddressIndex, if not nil, answers GetTaddressBalanceHistory.
+	AddressIndex *common.AddressIndex
+
+	// MinRangeCheckpointInterval is the smallest BlockRange.checkpointInterval
+	// honoured; smaller ones are rounded up to it. Zero turns off checkpoints
+	// in GetBlockRange.
+	MinRangeCheckpointInterval int
+
+	// FollowBlockRange lets GetBlockRange keep the stream open past the
+	// tip, sending each block as it's ingested. Following streams hold
+	// their MaxBlockRangeStreams slot for as long as they're open.
+	FollowBlockRange bool
+
+	// EmptyBlockHeartbeat is the most blocks without transactions in a row
+	// that GetBlockRange leaves out when asked to skip them; the next one
+	// is sent regardless, to show progress. Zero leaves them all out.
+	EmptyBlockHeartbeat int
+
+	// SubscribeKeepalive is how long a SubscribeBlocks or SubscribeReorgs
+	// stream may go without an update before it's sent a ping. Zero sends
+	// none.
+	SubscribeKeepalive time.Duration
+
+	// LookupStrategies sets where GetLatestBlock, GetBlock, GetBlockRange,
+	// GetBlockHeaders and GetBlockRangeNullifiers look for blocks, by method
+	// name. The methods not listed use common.CacheFirst.
+	LookupStrategies map[string]common.LookupStrategy
+
+	// NodeStatus, if set, is where GetLightdInfo gets the node's state,
+	// so that it answers without a call to the node, even while it's down.
+	// The node's version, subversion and mempool size are left out while
+	// it's unreachable, unless LightdInfoStaleNodeFields is set.
+	NodeStatus                *common.NodeStatusCache
+	LightdInfoStaleNodeFields bool
+
+	// SaplingActivationHeight overrides the height reported by the node, see
+	// common.SaplingActivationHeight.
+	SaplingActivationHeight int
+
+	// GitCommit and BuildDate describe the build, and the rest who runs
+	// the server and how to support them, for GetLightdInfo.
+	GitCommit        string
+	BuildDate        string
+	DonationAddress  string
+	DonationTaddress string
+	OperatorName     string
+	OperatorContact  string
+	PrivacyPolicyURL string
+
+	// Notices, if not nil, has the operator's notices for wallets, served by
+	// GetServerNotices and GetLightdInfo.
+	Notices *common.NoticeFile
+
+	// TreeStates, if not nil, keeps the tree states GetTreeState got from
+	// the node, and ChainName is the network it reports them on.
+	TreeStates *common.TreeStateStore
+	ChainName  string
+}
+
+// blockRangeRetryDelay is the retry hint given to clients turned away by
+// MaxBlockRangeStreams.
+const blockRangeRetryDelay = 5 * time.Second
+
+// sendNeverRetry are the sendrawtransaction error codes that mean the node
+// looked at the transaction and refused it, so sending it again can't help.
+var sendNeverRetry = map[int]string{
+	-25: "RPC_VERIFY_ERROR",
+	-26: "RPC_VERIFY_REJECTED",
+	-27: "RPC_VERIFY_ALREADY_IN_CHAIN",
+}
+
 // the service type
 type SqlStreamer struct {
 	cache        *common.BlockCache
-	client       *rpcclient.Client
+	client       common.RPCClient
 	log          *logrus.Entry
 	metrics      *common.PrometheusMetrics
+	opts         Options
+	rangeSlots   chan struct{}
+	sent         *sendDedup
+	txs          *txCache
+	tracked      *sentTracker
+	fullSlots    chan struct{}
 	latencyCache map[string]*latencyCacheEntry
 	latencyMutex sync.RWMutex
+
+	// upgrades is the node's last getblockchaininfo, for the network
+	// upgrades, when there's no NodeStatus.
+	upgrades        *common.ChainInfo
+	upgradesFetched time.Time
+	upgradesMutex   sync.Mutex
+
+	// valuePools is the node's last getblockchaininfo, for the value pools,
+	// kept until the cache has a newer block.
+	valuePools      *common.ChainInfo
+	valuePoolsMutex sync.Mutex
+}
+
+func NewSQLiteStreamer(client common.RPCClient, cache *common.BlockCache, log *logrus.Entry, metrics *common.PrometheusMetrics, opts Options) (walletrpc.CompactTxStreamerServer, error) {
+	for _, code := range opts.SendRetryCodes {
+		if name, ok := sendNeverRetry[code]; ok {
+			return nil, fmt.Errorf("error code %d (%s) can't be retried", code, name)
+		}
+	}
+
+	for method := range opts.LookupStrategies {
+		if !lookupMethods[method] {
+			return nil, fmt.Errorf("%s doesn't take a lookup strategy", method)
+		}
+	}
+
+	s := &SqlStreamer{
+		cache:        cache,
+		client:       client,
+		log:          log,
+		metrics:      metrics,
+		opts:         opts,
+		latencyCache: make(map[string]*latencyCacheEntry),
+	}
+	if opts.SendDedupWindow > 0 {
+		s.sent = newSendDedup(opts.SendDedupWindow, opts.SendDedupMax)
+	}
+	if opts.TxStatusWindow > 0 {
+		s.tracked = newSentTracker(opts.TxStatusWindow, opts.TxStatusMax)
+	}
+	if opts.TxCacheSize > 0 && opts.TxCacheTTL > 0 {
+		s.txs = newTxCache(opts.TxCacheTTL, opts.TxCacheSize)
+	}
+	if opts.MaxFullBlockRequests > 0 {
+		s.fullSlots = make(chan struct{}, opts.MaxFullBlockRequests)
+	}
+	if opts.MaxBlockRangeStreams > 0 {
+		s.rangeSlots = make(chan struct{}, opts.MaxBlockRangeStreams)
+		metrics.BlockRangeStreamsLimit.Set(float64(opts.MaxBlockRangeStreams))
+	}
+	return s, nil
+}
+
+// lookupMethods are the methods that take a lookup strategy.
+var lookupMethods = map[string]bool{
+	"GetLatestBlock":          true,
+	"GetBlock":                true,
+	"GetBlockRange":           true,
+	"GetBlockHeaders":         true,
+	"GetBlockRangeNullifiers": true,
+}
+
+// lookupStrategy returns the lookup strategy configured for method.
+func (s *SqlStreamer) lookupStrategy(method string) common.LookupStrategy {
+	if strategy, ok := s.opts.LookupStrategies[method]; ok {
+		return strategy
+	}
+	return common.CacheFirst
 }
 
-func NewSQLiteStreamer(client *rpcclient.Client, cache *common.BlockCache, log *logrus.Entry, metrics *common.PrometheusMetrics) (walletrpc.CompactTxStreamerServer, error) {
-	return &SqlStreamer{cache, client, log, metrics, make(map[string]*latencyCacheEntry), sync.RWMutex{}}, nil
+// lowestBlock returns the lowest block a lookup with strategy can find, or -1
+// if there's no such limit: only the cache-only strategy has one.
+func (s *SqlStreamer) lowestBlock(strategy common.LookupStrategy) int {
+	if strategy != common.CacheOnly {
+		return -1
+	}
+	return s.cache.GetFirstBlock()
 }
 
 func (s *SqlStreamer) GracefulStop() error {
@@ -55,6 +341,16 @@ func (s *SqlStreamer) GetCache() *common.BlockCache {
 }
 
 func (s *SqlStreamer) GetLatestBlock(ctx context.Context, placeholder *walletrpc.ChainSpec) (*walletrpc.BlockID, error) {
+	if s.lookupStrategy("GetLatestBlock") == common.NodeOnly {
+		info, err := common.GetChainInfo(s.client)
+		if err != nil {
+			s.metrics.TotalErrors.Inc()
+			return nil, status.Errorf(codes.Unavailable, "couldn't get the latest block: %v", err)
+		}
+		s.metrics.LatestBlockCounter.Inc()
+		return &walletrpc.BlockID{Height: uint64(info.Blocks)}, nil
+	}
+
 	latestBlock := s.cache.GetLatestBlock()
 
 	if latestBlock == -1 {
@@ -151,19 +447,477 @@ func (s *SqlStreamer) GetAddressTxids(addressBlockFilter *walletrpc.TransparentA
 	return nil
 }
 
-func (s *SqlStreamer) peerIPFromContext(ctx context.Context) string {
-	if xRealIP, ok := metadata.FromIncomingContext(ctx); ok {
-		realIP := xRealIP.Get("x-real-ip")
-		if len(realIP) > 0 {
-			return realIP[0]
+// GetTaddressTxids streams the transactions touching a transparent address
+// in a block range, asking the node with getaddresstxids for at most
+// Options.TaddressTxidsPage blocks at a time.
+func (s *SqlStreamer) GetTaddressTxids(filter *walletrpc.TransparentAddressBlockFilter, resp walletrpc.CompactTxStreamer_GetTaddressTxidsServer) error {
+	if filter == nil || filter.Range == nil || filter.Range.Start == nil || filter.Range.End == nil {
+		return status.Error(codes.InvalidArgument, "an address and a block range are required")
+	}
+	if !transparentAddress.MatchString(filter.Address) {
+		return status.Errorf(codes.InvalidArgument, "%q is not a transparent address", filter.Address)
+	}
+	start, end := filter.Range.Start.Height, filter.Range.End.Height
+	if start > end {
+		return status.Errorf(codes.InvalidArgument, "range start %d is after its end %d", start, end)
+	}
+
+	page := end - start
+	if s.opts.TaddressTxidsPage > 0 {
+		page = uint64(s.opts.TaddressTxidsPage) - 1
+	}
+	sent := 0
+	for pageStart := start; ; pageStart += page + 1 {
+		pageEnd := end
+		if end-pageStart > page {
+			pageEnd = pageStart + page
+		}
+		txids, err := s.addressTxids(filter.Address, pageStart, pageEnd)
+		if err != nil {
+			return err
+		}
+		for _, txid := range txids {
+			tx, err := s.GetTransaction(resp.Context(), &walletrpc.TxFilter{Hash: txid})
+			if err != nil {
+				return err
+			}
+			if tx == nil {
+				return status.Errorf(codes.NotFound, "the node didn't return transaction %x", txid)
+			}
+			if err := resp.Send(tx); err != nil {
+				return err
+			}
+			sent++
+		}
+		if pageEnd == end {
+			break
+		}
+	}
+
+	s.log.WithFields(logrus.Fields{
+		"method":       "GetTaddressTxids",
+		"address":      filter.Address,
+		"start":        start,
+		"end":          end,
+		"transactions": sent,
+	}).Info("Service")
+	return nil
+}
+
+// addressTxids returns the IDs of the transactions touching address from
+// start to end, inclusive, in the byte order of TxFilter.hash.
+func (s *SqlStreamer) addressTxids(address string, start, end uint64) ([][]byte, error) {
+	param, err := json.Marshal(map[string]interface{}{
+		"addresses": []string{address},
+		"start":     start,
+		"end":       end,
+	})
+	if err != nil {
+		return nil, err
+	}
+	result, rpcErr := s.client.RawRequest("getaddresstxids", []json.RawMessage{param})
+	if rpcErr != nil {
+		s.metrics.TotalErrors.Inc()
+		if jsonErr, ok := rpcErr.(*btcjson.RPCError); ok && jsonErr.Code == -8 {
+			// The node doesn't have the range yet.
+			return nil, status.Error(codes.OutOfRange, jsonErr.Message)
+		}
+		return nil, status.Errorf(codes.Unavailable, "getaddresstxids failed: %v", rpcErr)
+	}
+
+	var txidStrings []string
+	if err := json.Unmarshal(result, &txidStrings); err != nil {
+		return nil, status.Errorf(codes.Internal, "bad getaddresstxids answer: %v", err)
+	}
+	txids := make([][]byte, len(txidStrings))
+	for i, txidString := range txidStrings {
+		txid, err := hex.DecodeString(txidString)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "bad txid %q from getaddresstxids", txidString)
+		}
+		// The node gives txids big-endian; TxFilter.hash is little-endian.
+		reverseBytes(txid)
+		txids[i] = txid
+	}
+	return txids, nil
+}
+
+// reverseBytes reverses b in place.
+func reverseBytes(b []byte) {
+	for left, right := 0, len(b)-1; left < right; left, right = left+1, right-1 {
+		b[left], b[right] = b[right], b[left]
+	}
+}
+
+// GetAddressUtxos returns the unspent outputs of transparent addresses, as
+// listed by the node's getaddressutxos, which needs insightexplorer.
+func (s *SqlStreamer) GetAddressUtxos(ctx context.Context, arg *walletrpc.GetAddressUtxosArg) (*walletrpc.GetAddressUtxosReplyList, error) {
+	utxos, err := s.addressUtxos(arg)
+	if err != nil {
+		return nil, err
+	}
+	s.log.WithFields(logrus.Fields{
+		"method":    "GetAddressUtxos",
+		"addresses": len(arg.Addresses),
+		"utxos":     len(utxos),
+	}).Info("Service")
+	return &walletrpc.GetAddressUtxosReplyList{AddressUtxos: utxos}, nil
+}
+
+// addressUtxosBatch is how many addresses GetAddressUtxosStream asks the node
+// for at a time.
+const addressUtxosBatch = 100
+
+// GetAddressUtxosStream streams the outputs GetAddressUtxos would return, one
+// at a time. The node is asked for addressUtxosBatch addresses at a time,
+// and each batch is sent as it arrives, so only one is held in memory. The
+// outputs come address by address, in the order given, and for each address
+// in the order of their height, then txid and index, so a client that was
+// cut off can resume by asking for the address of the last output it
+// received from that height, as startHeight, and for the addresses after it
+// as before; the outputs at that height are sent again.
+func (s *SqlStreamer) GetAddressUtxosStream(arg *walletrpc.GetAddressUtxosArg, resp walletrpc.CompactTxStreamer_GetAddressUtxosStreamServer) error {
+	if err := checkUtxoAddresses(arg); err != nil {
+		return err
+	}
+	order := make(map[string]int, len(arg.Addresses))
+	for i, address := range arg.Addresses {
+		if _, ok := order[address]; !ok {
+			order[address] = i
+		}
+	}
+
+	memory := common.RequestMemoryFromContext(resp.Context())
+	sent := 0
+	for start := 0; start < len(arg.Addresses); start += addressUtxosBatch {
+		end := start + addressUtxosBatch
+		if end > len(arg.Addresses) {
+			end = len(arg.Addresses)
+		}
+		utxos, size, err := s.nodeUtxos(arg.Addresses[start:end])
+		if err != nil {
+			return err
+		}
+		if err := memory.Reserve(size); err != nil {
+			return err
+		}
+		sort.Slice(utxos, func(i, j int) bool {
+			if utxos[i].Address != utxos[j].Address {
+				return order[utxos[i].Address] < order[utxos[j].Address]
+			}
+			return utxos[i].before(&utxos[j])
+		})
+		for i := range utxos {
+			if utxos[i].Height < arg.StartHeight {
+				continue
+			}
+			if arg.MaxEntries > 0 && sent == int(arg.MaxEntries) {
+				break
+			}
+			reply, err := utxos[i].reply()
+			if err != nil {
+				return err
+			}
+			if err := resp.Send(reply); err != nil {
+				return err
+			}
+			sent++
+		}
+		memory.Release(size)
+		if arg.MaxEntries > 0 && sent == int(arg.MaxEntries) {
+			break
+		}
+	}
+	s.log.WithFields(logrus.Fields{
+		"method":    "GetAddressUtxosStream",
+		"addresses": len(arg.Addresses),
+		"utxos":     sent,
+	}).Info("Service")
+	return nil
+}
+
+// nodeUtxo is an unspent output as getaddressutxos lists it.
+type nodeUtxo struct {
+	Address     string
+	Txid        string
+	OutputIndex int32
+	Script      string
+	Satoshis    int64
+	Height      uint64
+}
+
+// before orders outputs by height, then txid and index.
+func (u *nodeUtxo) before(other *nodeUtxo) bool {
+	if u.Height != other.Height {
+		return u.Height < other.Height
+	}
+	if u.Txid != other.Txid {
+		return u.Txid < other.Txid
+	}
+	return u.OutputIndex < other.OutputIndex
+}
+
+// reply returns the output as GetAddressUtxos returns it.
+func (u *nodeUtxo) reply() (*walletrpc.GetAddressUtxosReply, error) {
+	txid, err := hex.DecodeString(u.Txid)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "bad txid %q from getaddressutxos", u.Txid)
+	}
+	reverseBytes(txid)
+	script, err := hex.DecodeString(u.Script)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "bad script %q from getaddressutxos", u.Script)
+	}
+	return &walletrpc.GetAddressUtxosReply{
+		Txid:     txid,
+		Index:    u.OutputIndex,
+		Script:   script,
+		ValueZat: u.Satoshis,
+		Height:   u.Height,
+		Address:  u.Address,
+	}, nil
+}
+
+// checkUtxoAddresses checks that arg asks for the outputs of transparent
+// addresses.
+func checkUtxoAddresses(arg *walletrpc.GetAddressUtxosArg) error {
+	if arg == nil || len(arg.Addresses) == 0 {
+		return status.Error(codes.InvalidArgument, "at least one address is required")
+	}
+	for _, address := range arg.Addresses {
+		if !transparentAddress.MatchString(address) {
+			return status.Errorf(codes.InvalidArgument, "%q is not a transparent address", address)
+		}
+	}
+	return nil
+}
+
+// nodeUtxos returns the unspent outputs of addresses from the node's
+// getaddressutxos, and the size of its answer.
+func (s *SqlStreamer) nodeUtxos(addresses []string) ([]nodeUtxo, int, error) {
+	param, err := json.Marshal(map[string]interface{}{"addresses": addresses})
+	if err != nil {
+		return nil, 0, err
+	}
+	result, rpcErr := s.client.RawRequest("getaddressutxos", []json.RawMessage{param})
+	if rpcErr != nil {
+		s.metrics.TotalErrors.Inc()
+		return nil, 0, status.Errorf(codes.Unavailable, "getaddressutxos failed: %v", rpcErr)
+	}
+
+	var utxos []nodeUtxo
+	if err := json.Unmarshal(result, &utxos); err != nil {
+		return nil, 0, status.Errorf(codes.Internal, "bad getaddressutxos answer: %v", err)
+	}
+	return utxos, len(result), nil
+}
+
+// addressUtxos returns the unspent outputs arg asks for, ordered by height,
+// then txid and index.
+func (s *SqlStreamer) addressUtxos(arg *walletrpc.GetAddressUtxosArg) ([]*walletrpc.GetAddressUtxosReply, error) {
+	if err := checkUtxoAddresses(arg); err != nil {
+		return nil, err
+	}
+	utxos, _, err := s.nodeUtxos(arg.Addresses)
+	if err != nil {
+		return nil, err
+	}
+	// The node lists them by address; wallets spend oldest first.
+	sort.Slice(utxos, func(i, j int) bool { return utxos[i].before(&utxos[j]) })
+
+	var replies []*walletrpc.GetAddressUtxosReply
+	for i := range utxos {
+		if utxos[i].Height < arg.StartHeight {
+			continue
+		}
+		if arg.MaxEntries > 0 && len(replies) == int(arg.MaxEntries) {
+			break
+		}
+		reply, err := utxos[i].reply()
+		if err != nil {
+			return nil, err
+		}
+		replies = append(replies, reply)
+	}
+	return replies, nil
+}
+
+// GetTaddressBalance returns the confirmed and unconfirmed balance of a
+// list of transparent addresses.
+func (s *SqlStreamer) GetTaddressBalance(ctx context.Context, list *walletrpc.AddressList) (*walletrpc.Balance, error) {
+	if list == nil {
+		return nil, status.Error(codes.InvalidArgument, "at least one address is required")
+	}
+	return s.taddressBalance("GetTaddressBalance", list.Addresses)
+}
+
+// GetTaddressBalanceStream returns the balance of the transparent addresses
+// the client sends, once it has sent them all.
+func (s *SqlStreamer) GetTaddressBalanceStream(stream walletrpc.CompactTxStreamer_GetTaddressBalanceStreamServer) error {
+	var addresses []string
+	for {
+		address, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if len(addresses) == maxBalanceAddresses {
+			return status.Errorf(codes.InvalidArgument, "more than %d addresses", maxBalanceAddresses)
+		}
+		addresses = append(addresses, address.Address)
+	}
+	balance, err := s.taddressBalance("GetTaddressBalanceStream", addresses)
+	if err != nil {
+		return err
+	}
+	return stream.SendAndClose(balance)
+}
+
+// taddressBalance totals the balance of addresses with the node's
+// getaddressbalance, and their unconfirmed change with getaddressmempool.
+func (s *SqlStreamer) taddressBalance(method string, addresses []string) (*walletrpc.Balance, error) {
+	if len(addresses) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "at least one address is required")
+	}
+	if len(addresses) > maxBalanceAddresses {
+		return nil, status.Errorf(codes.InvalidArgument, "more than %d addresses", maxBalanceAddresses)
+	}
+	// The node would count an address given twice twice.
+	seen := make(map[string]bool, len(addresses))
+	unique := make([]string, 0, len(addresses))
+	for _, address := range addresses {
+		if !transparentAddress.MatchString(address) {
+			return nil, status.Errorf(codes.InvalidArgument, "%q is not a transparent address", address)
+		}
+		if !seen[address] {
+			seen[address] = true
+			unique = append(unique, address)
+		}
+	}
+	param, err := json.Marshal(map[string]interface{}{"addresses": unique})
+	if err != nil {
+		return nil, err
+	}
+
+	result, rpcErr := s.client.RawRequest("getaddressbalance", []json.RawMessage{param})
+	if rpcErr != nil {
+		s.metrics.TotalErrors.Inc()
+		return nil, status.Errorf(codes.Unavailable, "getaddressbalance failed: %v", rpcErr)
+	}
+	var confirmed struct {
+		Balance int64
+	}
+	if err := json.Unmarshal(result, &confirmed); err != nil {
+		return nil, status.Errorf(codes.Internal, "bad getaddressbalance answer: %v", err)
+	}
+
+	result, rpcErr = s.client.RawRequest("getaddressmempool", []json.RawMessage{param})
+	if rpcErr != nil {
+		s.metrics.TotalErrors.Inc()
+		return nil, status.Errorf(codes.Unavailable, "getaddressmempool failed: %v", rpcErr)
+	}
+	var deltas []struct {
+		Satoshis int64
+	}
+	if err := json.Unmarshal(result, &deltas); err != nil {
+		return nil, status.Errorf(codes.Internal, "bad getaddressmempool answer: %v", err)
+	}
+	balance := &walletrpc.Balance{ConfirmedZat: confirmed.Balance}
+	for _, delta := range deltas {
+		balance.UnconfirmedZat += delta.Satoshis
+	}
+
+	s.log.WithFields(logrus.Fields{
+		"method":    method,
+		"addresses": len(unique),
+	}).Info("Service")
+	return balance, nil
+}
+
+// secondsPerDay is the length of the days GetTaddressBalanceHistory sums
+// changes over, which start at midnight UTC.
+const secondsPerDay = 24 * 60 * 60
+
+// GetTaddressBalanceHistory streams the changes in the balance of a
+// transparent address from the address index, summed per block or per day.
+func (s *SqlStreamer) GetTaddressBalanceHistory(arg *walletrpc.BalanceHistoryArg, resp walletrpc.CompactTxStreamer_GetTaddressBalanceHistoryServer) error {
+	if s.opts.AddressIndex == nil {
+		return status.Error(codes.FailedPrecondition, "balance history is not enabled on this server")
+	}
+	if arg == nil || !transparentAddress.MatchString(arg.Address) {
+		return status.Errorf(codes.InvalidArgument, "%q is not a transparent address", arg.GetAddress())
+	}
+	daily := false
+	switch arg.Granularity {
+	case walletrpc.BalanceHistoryArg_BLOCK:
+	case walletrpc.BalanceHistoryArg_DAY:
+		daily = true
+	default:
+		return status.Errorf(codes.InvalidArgument, "unknown granularity %d", arg.Granularity)
+	}
+
+	history, err := s.opts.AddressIndex.BalanceHistory(arg.Address)
+	if err != nil {
+		s.metrics.TotalErrors.Inc()
+		return status.Errorf(codes.Internal, "reading balance history: %v", err)
+	}
+
+	var balance int64
+	var sent int
+	var pending *walletrpc.BalanceDelta
+	send := func() error {
+		if pending == nil || pending.DeltaZat == 0 {
+			return nil
+		}
+		sent++
+		return resp.Send(pending)
+	}
+	for _, change := range history {
+		balance += change.Delta
+		if daily {
+			day := change.Time - change.Time%secondsPerDay
+			if pending != nil && pending.Time == day {
+				pending.Height = uint64(change.Height)
+				pending.DeltaZat += change.Delta
+				pending.BalanceZat = balance
+				continue
+			}
+			change.Time = day
+		}
+		if err := send(); err != nil {
+			return err
 		}
+		pending = &walletrpc.BalanceDelta{
+			Height:     uint64(change.Height),
+			Time:       change.Time,
+			DeltaZat:   change.Delta,
+			BalanceZat: balance,
+		}
+	}
+	if err := send(); err != nil {
+		return err
 	}
 
+	s.log.WithFields(logrus.Fields{
+		"method":      "GetTaddressBalanceHistory",
+		"granularity": arg.Granularity.String(),
+		"deltas":      sent,
+	}).Info("Service")
+	return nil
+}
+
+// peerIPFromContext returns the IP address the call in ctx came from. The
+// server has already replaced the peer of calls relayed by a trusted proxy
+// with the client it named.
+func (s *SqlStreamer) peerIPFromContext(ctx context.Context) string {
 	if peerInfo, ok := peer.FromContext(ctx); ok {
 		ip, _, err := net.SplitHostPort(peerInfo.Addr.String())
 		if err == nil {
 			return ip
 		}
+		return peerInfo.Addr.String()
 	}
 
 	return "unknown"
@@ -191,37 +945,438 @@ func (s *SqlStreamer) GetBlock(ctx context.Context, id *walletrpc.BlockID) (*wal
 		"end":    id.Height,
 	}).Info("Service")
 
-	// Log a daily active user if the user requests the day's "key block"
-	go func() {
-		s.dailyActiveBlock(id.Height, s.peerIPFromContext(ctx))
-	}()
+	format, err := compactFormatFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
 
 	// Precedence: a hash is more specific than a height. If we have it, use it first.
+	var cBlock *walletrpc.CompactBlock
 	if id.Hash != nil {
-		// TODO: Get block by hash
-		s.metrics.TotalErrors.Inc()
-
-		return nil, errors.New("GetBlock by Hash is not yet implemented")
+		cBlock, err = s.blockByHash(id.Hash, s.lookupStrategy("GetBlock"))
 	} else {
-		cBlock, err := common.GetBlock(s.client, s.cache, int(id.Height))
+		// Log a daily active user if the user requests the day's "key block"
+		go func() {
+			s.dailyActiveBlock(id.Height, s.peerIPFromContext(ctx))
+		}()
+
+		cBlock, err = common.LookupBlock(s.client, s.cache, int(id.Height), s.lookupStrategy("GetBlock"))
+	}
+	if err != nil {
+		return nil, err
+	}
 
+	if id.IncludeFull && format >= compactFormatV2 {
+		cBlock.FullBlock, err = s.getFullBlock(int(cBlock.Height))
 		if err != nil {
+			s.metrics.TotalErrors.Inc()
 			return nil, err
 		}
-
-		s.metrics.TotalBlocksServedConter.Inc()
-		return cBlock, err
+		if err := common.RequestMemoryFromContext(ctx).Reserve(len(cBlock.FullBlock)); err != nil {
+			return nil, err
+		}
+	}
+	if !id.IncludeCoinbase {
+		cBlock.Coinbase = nil
 	}
+	projectCompactBlock(cBlock, format)
 
+	s.metrics.TotalBlocksServedConter.Inc()
+	return cBlock, err
 }
 
-func (s *SqlStreamer) GetBlockRange(span *walletrpc.BlockRange, resp walletrpc.CompactTxStreamer_GetBlockRangeServer) error {
-	if span == nil || span.Start == nil || span.End == nil {
-		return ErrUnspecified
+// blockByHash returns the block on the best chain with hash, in
+// little-endian wire order, looking it up by height with strategy unless
+// the cache's hash index has it. A block that was reorged away is not
+// found, so that a wallet can tell whether the block it holds still counts.
+func (s *SqlStreamer) blockByHash(hash []byte, strategy common.LookupStrategy) (*walletrpc.CompactBlock, error) {
+	if len(hash) != 32 {
+		return nil, status.Errorf(codes.InvalidArgument, "block hash is %d bytes, expected 32", len(hash))
+	}
+	if block := s.cache.GetByHash(hash); block != nil {
+		return block, nil
+	}
+	displayHash := make([]byte, len(hash))
+	copy(displayHash, hash)
+	reverseBytes(displayHash)
+	if strategy == common.CacheOnly {
+		return nil, status.Errorf(codes.NotFound, "block %x is not in the cache", displayHash)
 	}
 
-	blockChan := make(chan walletrpc.CompactBlock)
-	errChan := make(chan error)
+	height, err := s.blockHeight(hash)
+	if err != nil {
+		return nil, err
+	}
+	block, err := common.LookupBlock(s.client, s.cache, height, strategy)
+	if err != nil {
+		return nil, err
+	}
+	// The node knows the height of blocks off the best chain too.
+	if block == nil || !bytes.Equal(block.Hash, hash) {
+		return nil, status.Errorf(codes.NotFound, "block %x is not on the best chain", displayHash)
+	}
+	return block, nil
+}
+
+// protocolVersionFromContext returns the protocol version negotiated for the
+// call: the older of the one in its metadata and ProtocolVersion, which
+// clients that don't give one get.
+func protocolVersionFromContext(ctx context.Context) (uint32, error) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok || len(md.Get(protocolVersionHeader)) == 0 {
+		return ProtocolVersion, nil
+	}
+
+	requested := md.Get(protocolVersionHeader)[0]
+	version, err := strconv.ParseUint(requested, 10, 32)
+	if err != nil || version < protocolV1 {
+		return 0, status.Errorf(codes.InvalidArgument, "unsupported protocol version %q, the oldest supported is %d",
+			requested, protocolV1)
+	}
+	if version > ProtocolVersion {
+		return ProtocolVersion, nil
+	}
+	return uint32(version), nil
+}
+
+// compactFormatFromContext returns the CompactBlock version requested in the
+// call's metadata, or if there's none the one of the negotiated protocol
+// version.
+func compactFormatFromContext(ctx context.Context) (uint32, error) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok || len(md.Get(compactFormatHeader)) == 0 {
+		protocol, err := protocolVersionFromContext(ctx)
+		if err != nil {
+			return 0, err
+		}
+		return protocolCompactFormats[protocol], nil
+	}
+
+	requested := md.Get(compactFormatHeader)[0]
+	version, err := strconv.ParseUint(requested, 10, 32)
+	if err == nil {
+		for _, supported := range compactFormats {
+			if uint32(version) == supported {
+				return supported, nil
+			}
+		}
+	}
+	return 0, status.Errorf(codes.InvalidArgument, "unsupported compact block format version %q, supported versions are %v",
+		requested, compactFormats)
+}
+
+// projectCompactBlock converts block, built in the latest format, to version.
+func projectCompactBlock(block *walletrpc.CompactBlock, version uint32) {
+	block.ProtoVersion = version
+	if version < compactFormatV2 {
+		block.FullBlock = nil
+		block.Checkpoint = nil
+		block.Coinbase = nil
+	}
+}
+
+// getFullBlock fetches the raw block at height from the node, subject to
+// MaxFullBlockRequests.
+func (s *SqlStreamer) getFullBlock(height int) ([]byte, error) {
+	if s.fullSlots == nil {
+		return nil, status.Error(codes.FailedPrecondition, "full blocks are not enabled on this server")
+	}
+
+	select {
+	case s.fullSlots <- struct{}{}:
+		defer func() { <-s.fullSlots }()
+	default:
+		return nil, status.Error(codes.ResourceExhausted, "too many concurrent full block requests, try again later")
+	}
+
+	block, err := common.GetRawBlock(s.client, height)
+	if err != nil {
+		return nil, err
+	}
+	if block == nil {
+		return nil, status.Errorf(codes.NotFound, "block %d not found", height)
+	}
+	return block, nil
+}
+
+// GetCheckpointIndex returns the checkpoints recorded so far, which let a
+// wallet start scanning close to its birthday.
+func (s *SqlStreamer) GetCheckpointIndex(ctx context.Context, in *walletrpc.Empty) (*walletrpc.CheckpointIndex, error) {
+	if s.cache.Checkpoints == nil {
+		return nil, status.Error(codes.Unimplemented, "checkpoint index is disabled")
+	}
+	return &walletrpc.CheckpointIndex{
+		Interval:    uint64(s.cache.Checkpoints.Interval),
+		Checkpoints: s.cache.Checkpoints.Checkpoints(),
+	}, nil
+}
+
+// maxTreeStateSkips is how many times GetTreeState follows the node back to
+// the block that last changed the tree, when the block asked about didn't.
+const maxTreeStateSkips = 10
+
+// GetTreeState returns the Sapling commitment tree as of a block, from the
+// node's z_gettreestate, or from TreeStates if it was asked for before.
+func (s *SqlStreamer) GetTreeState(ctx context.Context, id *walletrpc.BlockID) (*walletrpc.TreeState, error) {
+	if id == nil || (id.Height == 0 && id.Hash == nil) {
+		return nil, status.Error(codes.InvalidArgument, "a block height or hash is required")
+	}
+
+	// The store is by hash; a cached block gives the hash of a height.
+	hash := id.Hash
+	if hash != nil && len(hash) != 32 {
+		return nil, status.Errorf(codes.InvalidArgument, "block hash is %d bytes, expected 32", len(hash))
+	}
+	if hash == nil {
+		if block := s.cache.Get(int(id.Height)); block != nil {
+			hash = block.Hash
+		}
+	}
+	if state := s.opts.TreeStates.Get(hash); state != nil {
+		s.log.WithFields(logrus.Fields{
+			"method": "GetTreeState",
+			"height": state.Height,
+			"cached": true,
+		}).Info("Service")
+		return state, nil
+	}
+
+	arg := strconv.FormatUint(id.Height, 10)
+	if hash != nil {
+		displayHash := make([]byte, len(hash))
+		copy(displayHash, hash)
+		reverseBytes(displayHash)
+		arg = hex.EncodeToString(displayHash)
+	}
+	state, err := s.treeState(arg)
+	if err != nil {
+		return nil, err
+	}
+
+	if hash, err = hex.DecodeString(state.Hash); err != nil {
+		return nil, status.Errorf(codes.Internal, "bad block hash %q from z_gettreestate", state.Hash)
+	}
+	reverseBytes(hash)
+	if err := s.opts.TreeStates.Add(hash, state); err != nil {
+		s.log.WithFields(logrus.Fields{
+			"error": err,
+		}).Warn("couldn't keep tree state")
+	}
+	s.log.WithFields(logrus.Fields{
+		"method": "GetTreeState",
+		"height": state.Height,
+		"cached": false,
+	}).Info("Service")
+	return state, nil
+}
+
+// treeState asks the node for the tree state as of the block with the
+// height or display-order hex hash arg.
+func (s *SqlStreamer) treeState(arg string) (*walletrpc.TreeState, error) {
+	var state *walletrpc.TreeState
+	for skips := 0; ; skips++ {
+		result, rpcErr := s.client.RawRequest("z_gettreestate", []json.RawMessage{json.RawMessage(strconv.Quote(arg))})
+		if rpcErr != nil {
+			s.metrics.TotalErrors.Inc()
+			if jsonErr, ok := rpcErr.(*btcjson.RPCError); ok {
+				switch jsonErr.Code {
+				case -8:
+					return nil, status.Error(codes.OutOfRange, jsonErr.Message)
+				case -5:
+					return nil, status.Error(codes.NotFound, jsonErr.Message)
+				case -32601:
+					return nil, status.Error(codes.Unimplemented, "the node doesn't support z_gettreestate")
+				}
+			}
+			return nil, status.Errorf(codes.Unavailable, "z_gettreestate failed: %v", rpcErr)
+		}
+
+		var reply struct {
+			Hash    string
+			Height  uint64
+			Time    uint32
+			Sapling struct {
+				Commitments struct {
+					FinalState string
+				}
+				SkipHash string
+			}
+		}
+		if err := json.Unmarshal(result, &reply); err != nil {
+			return nil, status.Errorf(codes.Internal, "bad z_gettreestate answer: %v", err)
+		}
+		if state == nil {
+			state = &walletrpc.TreeState{
+				Network: s.opts.ChainName,
+				Height:  reply.Height,
+				Hash:    reply.Hash,
+				Time:    reply.Time,
+			}
+		}
+		if reply.Sapling.Commitments.FinalState != "" {
+			state.Tree = reply.Sapling.Commitments.FinalState
+			return state, nil
+		}
+		// The tree is the same as at the block skipHash names.
+		if reply.Sapling.SkipHash == "" || skips == maxTreeStateSkips {
+			return nil, status.Errorf(codes.Internal, "z_gettreestate gave no Sapling tree for block %s", state.Hash)
+		}
+		arg = reply.Sapling.SkipHash
+	}
+}
+
+// GetSubtreeRoots streams the roots of the completed note commitment
+// subtrees arg asks for, as the node's z_getsubtreesbyindex lists them.
+func (s *SqlStreamer) GetSubtreeRoots(arg *walletrpc.GetSubtreeRootsArg, resp walletrpc.CompactTxStreamer_GetSubtreeRootsServer) error {
+	if arg == nil {
+		return status.Error(codes.InvalidArgument, "a subtree range is required")
+	}
+	pool, ok := walletrpc.ShieldedProtocol_name[int32(arg.ShieldedProtocol)]
+	if !ok {
+		return status.Errorf(codes.InvalidArgument, "unknown shielded protocol %d", arg.ShieldedProtocol)
+	}
+	params := []json.RawMessage{
+		json.RawMessage(strconv.Quote(pool)),
+		json.RawMessage(strconv.FormatUint(uint64(arg.StartIndex), 10)),
+	}
+	if arg.MaxEntries > 0 {
+		params = append(params, json.RawMessage(strconv.FormatUint(uint64(arg.MaxEntries), 10)))
+	}
+
+	result, rpcErr := s.client.RawRequest("z_getsubtreesbyindex", params)
+	if rpcErr != nil {
+		s.metrics.TotalErrors.Inc()
+		if jsonErr, ok := rpcErr.(*btcjson.RPCError); ok {
+			switch jsonErr.Code {
+			case -8:
+				return status.Error(codes.InvalidArgument, jsonErr.Message)
+			case -32601:
+				return status.Error(codes.Unimplemented, "the node doesn't support z_getsubtreesbyindex")
+			}
+		}
+		return status.Errorf(codes.Unavailable, "z_getsubtreesbyindex failed: %v", rpcErr)
+	}
+	var reply struct {
+		Subtrees []struct {
+			Root      string
+			EndHash   string `json:"end_hash"`
+			EndHeight uint64 `json:"end_height"`
+		}
+	}
+	if err := json.Unmarshal(result, &reply); err != nil {
+		return status.Errorf(codes.Internal, "bad z_getsubtreesbyindex answer: %v", err)
+	}
+
+	for _, subtree := range reply.Subtrees {
+		root, err := hex.DecodeString(subtree.Root)
+		if err != nil {
+			return status.Errorf(codes.Internal, "bad subtree root %q from z_getsubtreesbyindex", subtree.Root)
+		}
+		blockHash, err := hex.DecodeString(subtree.EndHash)
+		if err != nil {
+			return status.Errorf(codes.Internal, "bad block hash %q from z_getsubtreesbyindex", subtree.EndHash)
+		}
+		// The node gives the hash big-endian.
+		reverseBytes(blockHash)
+		if err := resp.Send(&walletrpc.SubtreeRoot{
+			RootHash:              root,
+			CompletingBlockHash:   blockHash,
+			CompletingBlockHeight: subtree.EndHeight,
+		}); err != nil {
+			return err
+		}
+	}
+
+	s.log.WithFields(logrus.Fields{
+		"method":   "GetSubtreeRoots",
+		"pool":     pool,
+		"start":    arg.StartIndex,
+		"subtrees": len(reply.Subtrees),
+	}).Info("Service")
+	return nil
+}
+
+func (s *SqlStreamer) GetBlockRange(span *walletrpc.BlockRange, resp walletrpc.CompactTxStreamer_GetBlockRangeServer) error {
+	if span == nil || span.Start == nil || (span.End == nil && !span.Follow) {
+		return ErrUnspecified
+	}
+
+	// A range that ends below its start is streamed from the top down.
+	// Following ranges ignore the end.
+	descending := !span.Follow && span.End.Height < span.Start.Height
+	bottomField := "start.height"
+	if descending {
+		bottomField = "end.height"
+	}
+
+	// Refuse a range that can't be served in full before sending anything,
+	// telling the client where it can start instead.
+	strategy := s.lookupStrategy("GetBlockRange")
+	if lowest := s.lowestBlock(strategy); lowest >= 0 && rangeBottom(span) < uint64(lowest) {
+		st := status.Newf(codes.OutOfRange, "%s %d is below the lowest available block, %d", bottomField, rangeBottom(span), lowest)
+		if detailed, err := st.WithDetails(&errdetails.BadRequest{
+			FieldViolations: []*errdetails.BadRequest_FieldViolation{{
+				Field:       bottomField,
+				Description: fmt.Sprintf("lowest available height is %d", lowest),
+			}},
+		}); err == nil {
+			st = detailed
+		}
+		return st.Err()
+	}
+
+	// A following stream goes up to the tip it subscribed at, then carries on
+	// with each block as the ingestor adds it. One that starts past the tip
+	// has nothing to catch up on, and waits for its first block.
+	var following <-chan *walletrpc.CompactBlock
+	catchUp := true
+	if span.Follow {
+		if !s.opts.FollowBlockRange {
+			return status.Error(codes.FailedPrecondition, "following the tip in GetBlockRange is not enabled on this server")
+		}
+		var tip int
+		var unsubscribe func()
+		following, tip, unsubscribe = s.cache.Subscribe()
+		defer unsubscribe()
+		catchUp = int(span.Start.Height) <= tip
+		end := span.Start.Height
+		if catchUp {
+			end = uint64(tip)
+		}
+		span = &walletrpc.BlockRange{
+			Start:              span.Start,
+			End:                &walletrpc.BlockID{Height: end},
+			CheckpointInterval: span.CheckpointInterval,
+			Follow:             true,
+			IncludeCoinbase:    span.IncludeCoinbase,
+			SkipEmpty:          span.SkipEmpty,
+		}
+	}
+
+	format, err := compactFormatFromContext(resp.Context())
+	if err != nil {
+		return err
+	}
+
+	interval := span.CheckpointInterval
+	if format < compactFormatV2 {
+		interval = 0
+	}
+	if interval > 0 {
+		if s.opts.MinRangeCheckpointInterval == 0 {
+			return status.Error(codes.FailedPrecondition, "checkpoints in GetBlockRange are not enabled on this server")
+		}
+		if interval < uint64(s.opts.MinRangeCheckpointInterval) {
+			interval = uint64(s.opts.MinRangeCheckpointInterval)
+		}
+	}
+
+	release, err := s.acquireRangeSlot()
+	if err != nil {
+		s.metrics.TotalErrors.Inc()
+		return err
+	}
+	defer release()
 
 	peerip := s.peerIPFromContext(resp.Context())
 
@@ -232,8 +1387,13 @@ func (s *SqlStreamer) GetBlockRange(span *walletrpc.BlockRange, resp walletrpc.C
 			return
 		}
 
+		// Continuous scans go up
+		if descending {
+			return
+		}
+
 		// Log only if bulk requesting blocks
-		if span.End.Height-span.Start.Height < 100 {
+		if span.End.Height < span.Start.Height+100 {
 			return
 		}
 
@@ -272,7 +1432,11 @@ func (s *SqlStreamer) GetBlockRange(span *walletrpc.BlockRange, resp walletrpc.C
 
 	// Log a daily active user if the user requests the day's "key block"
 	go func() {
-		for height := span.Start.Height; height <= span.End.Height; height++ {
+		bottom, top := span.Start.Height, span.End.Height
+		if descending {
+			bottom, top = top, bottom
+		}
+		for height := bottom; height <= top; height++ {
 			s.dailyActiveBlock(height, peerip)
 		}
 	}()
@@ -284,90 +1448,473 @@ func (s *SqlStreamer) GetBlockRange(span *walletrpc.BlockRange, resp walletrpc.C
 		"peer_addr": peerip,
 	}).Info("Service")
 
-	go common.GetBlockRange(s.client, s.cache, blockChan, errChan, int(span.Start.Height), int(span.End.Height))
+	lastHeight := int(span.Start.Height) - 1
+	var lastHash []byte
+	skipped := 0
+	send := func(cBlock *walletrpc.CompactBlock) error {
+		if span.SkipEmpty && len(cBlock.Vtx) == 0 && cBlock.Height != span.End.Height &&
+			(interval == 0 || cBlock.Height%interval != 0) &&
+			(s.opts.EmptyBlockHeartbeat == 0 || skipped < s.opts.EmptyBlockHeartbeat) {
+			skipped++
+			lastHeight, lastHash = int(cBlock.Height), cBlock.Hash
+			return nil
+		}
+		skipped = 0
 
-	for {
+		var err error
+		if interval > 0 && cBlock.Height%interval == 0 {
+			cBlock.Checkpoint, err = common.GetCheckpoint(s.client, s.cache, int(cBlock.Height), cBlock.Hash)
+			if err != nil {
+				s.metrics.TotalErrors.Inc()
+				return err
+			}
+		}
+		if !span.IncludeCoinbase {
+			cBlock.Coinbase = nil
+		}
+		projectCompactBlock(cBlock, format)
+		s.metrics.TotalBlocksServedConter.Inc()
+		if err := resp.Send(cBlock); err != nil {
+			return err
+		}
+		lastHeight, lastHash = int(cBlock.Height), cBlock.Hash
+		return nil
+	}
+
+	var blockChan <-chan walletrpc.CompactBlock
+	var errChan <-chan error
+	if catchUp {
+		var stop func()
+		blockChan, errChan, stop = s.lookupBlockRange(span, strategy)
+		defer stop()
+	}
+
+	for done := !catchUp; !done; {
 		select {
 		case err := <-errChan:
 			// this will also catch context.DeadlineExceeded from the timeout
-			s.metrics.TotalErrors.Inc()
-			return err
+			if err != nil || following == nil {
+				s.metrics.TotalErrors.Inc()
+				return err
+			}
+			done = true
 		case cBlock := <-blockChan:
-			s.metrics.TotalBlocksServedConter.Inc()
-			err := resp.Send(&cBlock)
-			if err != nil {
+			if err := send(&cBlock); err != nil {
 				return err
 			}
 		}
 	}
 
+	for {
+		select {
+		case <-resp.Context().Done():
+			return resp.Context().Err()
+		case cBlock, ok := <-following:
+			if !ok {
+				return status.Errorf(codes.Unavailable, "fell behind while following the tip after height %d, resume from there", lastHeight)
+			}
+			height := int(cBlock.Height)
+			if lastHash == nil && height <= lastHeight {
+				// Before the start of the range.
+				continue
+			}
+			if height != lastHeight+1 || (lastHash != nil && !bytes.Equal(cBlock.PrevHash, lastHash)) {
+				return status.Errorf(codes.Aborted, "chain reorganized at height %d, resume from an earlier block", height)
+			}
+			if err := send(cBlock); err != nil {
+				return err
+			}
+		}
+	}
 }
 
-func (s *SqlStreamer) GetTransaction(ctx context.Context, txf *walletrpc.TxFilter) (*walletrpc.RawTransaction, error) {
-
-	var txBytes []byte
-	var txHeight float64
-
-	if txf == nil {
-		return nil, ErrUnspecified
+// GetBlockHeaders streams the height, hash, previous hash and time of the
+// blocks in a range, without their transactions, so that a client can check
+// that they link up before asking for the compact blocks.
+func (s *SqlStreamer) GetBlockHeaders(span *walletrpc.BlockRange, resp walletrpc.CompactTxStreamer_GetBlockHeadersServer) error {
+	if span == nil || span.Start == nil || span.End == nil {
+		return ErrUnspecified
 	}
+	span = &walletrpc.BlockRange{Start: span.Start, End: span.End}
 
-	if txf.Hash != nil {
-		txid := txf.Hash
-		for left, right := 0, len(txid)-1; left < right; left, right = left+1, right-1 {
-			txid[left], txid[right] = txid[right], txid[left]
-		}
-		leHashString := hex.EncodeToString(txid)
+	strategy := s.lookupStrategy("GetBlockHeaders")
+	if lowest := s.lowestBlock(strategy); lowest >= 0 && rangeBottom(span) < uint64(lowest) {
+		return status.Errorf(codes.OutOfRange, "height %d is below the lowest available block, %d", rangeBottom(span), lowest)
+	}
 
-		// First call to get the raw transaction bytes
-		params := make([]json.RawMessage, 1)
-		params[0] = json.RawMessage("\"" + leHashString + "\"")
+	release, err := s.acquireRangeSlot()
+	if err != nil {
+		s.metrics.TotalErrors.Inc()
+		return err
+	}
+	defer release()
 
-		result, rpcErr := s.client.RawRequest("getrawtransaction", params)
+	s.log.WithFields(logrus.Fields{
+		"method":    "GetBlockHeaders",
+		"start":     span.Start.Height,
+		"end":       span.End.Height,
+		"peer_addr": s.peerIPFromContext(resp.Context()),
+	}).Info("Service")
 
-		var err error
-		var errCode int64
-		// For some reason, the error responses are not JSON
-		if rpcErr != nil {
-			s.metrics.TotalErrors.Inc()
+	blockChan, errChan, stop := s.lookupBlockRange(span, strategy)
+	defer stop()
 
-			s.log.Errorf("Got error: %s", rpcErr.Error())
-			errParts := strings.SplitN(rpcErr.Error(), ":", 2)
-			errCode, err = strconv.ParseInt(errParts[0], 10, 32)
-			//Check to see if we are requesting a height the zcashd doesn't have yet
-			if err == nil && errCode == -8 {
-				return nil, err
+	for {
+		select {
+		case err := <-errChan:
+			if err != nil {
+				s.metrics.TotalErrors.Inc()
+			}
+			return err
+		case cBlock := <-blockChan:
+			if err := resp.Send(&walletrpc.BlockHeader{
+				Height:   cBlock.Height,
+				Hash:     cBlock.Hash,
+				PrevHash: cBlock.PrevHash,
+				Time:     cBlock.Time,
+			}); err != nil {
+				return err
 			}
-			return nil, err
 		}
+	}
+}
 
-		var txhex string
-		err = json.Unmarshal(result, &txhex)
-		if err != nil {
-			return nil, err
-		}
+// GetBlockRangeNullifiers streams the blocks in a range with only their
+// spends: transactions without any are left out, and the rest keep their
+// index and hash, but not their outputs.
+func (s *SqlStreamer) GetBlockRangeNullifiers(span *walletrpc.BlockRange, resp walletrpc.CompactTxStreamer_GetBlockRangeNullifiersServer) error {
+	if span == nil || span.Start == nil || span.End == nil {
+		return ErrUnspecified
+	}
+	span = &walletrpc.BlockRange{Start: span.Start, End: span.End}
 
-		txBytes, err = hex.DecodeString(txhex)
-		if err != nil {
-			return nil, err
-		}
+	strategy := s.lookupStrategy("GetBlockRangeNullifiers")
+	if lowest := s.lowestBlock(strategy); lowest >= 0 && rangeBottom(span) < uint64(lowest) {
+		return status.Errorf(codes.OutOfRange, "height %d is below the lowest available block, %d", rangeBottom(span), lowest)
+	}
 
-		// Second call to get height
-		params = make([]json.RawMessage, 2)
-		params[0] = json.RawMessage("\"" + leHashString + "\"")
-		params[1] = json.RawMessage("1")
+	format, err := compactFormatFromContext(resp.Context())
+	if err != nil {
+		return err
+	}
 
-		result, rpcErr = s.client.RawRequest("getrawtransaction", params)
+	release, err := s.acquireRangeSlot()
+	if err != nil {
+		s.metrics.TotalErrors.Inc()
+		return err
+	}
+	defer release()
 
-		// For some reason, the error responses are not JSON
-		if rpcErr != nil {
-			s.metrics.TotalErrors.Inc()
+	s.log.WithFields(logrus.Fields{
+		"method":    "GetBlockRangeNullifiers",
+		"start":     span.Start.Height,
+		"end":       span.End.Height,
+		"peer_addr": s.peerIPFromContext(resp.Context()),
+	}).Info("Service")
 
-			s.log.Errorf("Got error: %s", rpcErr.Error())
-			errParts := strings.SplitN(rpcErr.Error(), ":", 2)
-			errCode, err = strconv.ParseInt(errParts[0], 10, 32)
-			//Check to see if we are requesting a height the zcashd doesn't have yet
-			if err == nil && errCode == -8 {
+	blockChan, errChan, stop := s.lookupBlockRange(span, strategy)
+	defer stop()
+
+	for {
+		select {
+		case err := <-errChan:
+			if err != nil {
+				s.metrics.TotalErrors.Inc()
+			}
+			return err
+		case cBlock := <-blockChan:
+			// The transactions may be the cache's own, so they're copied
+			// rather than trimmed.
+			var spends []*walletrpc.CompactTx
+			for _, tx := range cBlock.Vtx {
+				if len(tx.Spends) > 0 {
+					spends = append(spends, &walletrpc.CompactTx{Index: tx.Index, Hash: tx.Hash, Spends: tx.Spends})
+				}
+			}
+			block := &walletrpc.CompactBlock{
+				ProtoVersion: format,
+				Height:       cBlock.Height,
+				Hash:         cBlock.Hash,
+				PrevHash:     cBlock.PrevHash,
+				Time:         cBlock.Time,
+				Vtx:          spends,
+			}
+			s.metrics.TotalBlocksServedConter.Inc()
+			if err := resp.Send(block); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// GetBlockByTime returns the header of the first cached block at or after
+// the time in arg. A time before the first cached block is out of range,
+// unless the cache goes back to genesis, since an earlier block might be
+// the answer.
+func (s *SqlStreamer) GetBlockByTime(ctx context.Context, arg *walletrpc.BlockTimeArg) (*walletrpc.BlockHeader, error) {
+	if arg == nil {
+		return nil, status.Error(codes.InvalidArgument, "a time is required")
+	}
+
+	height := s.cache.GetByTime(arg.Time)
+	if height < 0 {
+		return nil, status.Errorf(codes.NotFound, "no block at or after time %d yet", arg.Time)
+	}
+	block := s.cache.Get(height)
+	if block == nil {
+		return nil, status.Errorf(codes.NotFound, "no block at or after time %d yet", arg.Time)
+	}
+	if height == s.cache.GetFirstBlock() && height > 0 && block.Time > arg.Time {
+		return nil, status.Errorf(codes.OutOfRange, "time %d is before the first cached block, %d", arg.Time, height)
+	}
+
+	s.log.WithFields(logrus.Fields{
+		"method": "GetBlockByTime",
+		"time":   arg.Time,
+		"height": height,
+	}).Info("Service")
+	return &walletrpc.BlockHeader{
+		Height:   block.Height,
+		Hash:     block.Hash,
+		PrevHash: block.PrevHash,
+		Time:     block.Time,
+	}, nil
+}
+
+// SubscribeBlocks sends the tip, then each block as it's added to the cache,
+// until the client goes away, with pings in between when the chain is quiet.
+func (s *SqlStreamer) SubscribeBlocks(arg *walletrpc.SubscribeBlocksArg, resp walletrpc.CompactTxStreamer_SubscribeBlocksServer) error {
+	blocks, tip, unsubscribe := s.cache.Subscribe()
+	defer unsubscribe()
+
+	s.log.WithFields(logrus.Fields{
+		"method":    "SubscribeBlocks",
+		"tip":       tip,
+		"peer_addr": s.peerIPFromContext(resp.Context()),
+	}).Info("Service")
+
+	includeBlocks := arg != nil && arg.IncludeBlocks
+	update := func(block *walletrpc.CompactBlock) *walletrpc.BlockUpdate {
+		u := &walletrpc.BlockUpdate{Tip: &walletrpc.BlockID{Height: block.Height, Hash: block.Hash}}
+		if includeBlocks {
+			block.Coinbase = nil
+			u.Block = block
+		}
+		return u
+	}
+
+	if tip >= 0 {
+		if block := s.cache.Get(tip); block != nil {
+			if err := resp.Send(update(block)); err != nil {
+				return err
+			}
+		}
+	}
+
+	var pings <-chan time.Time
+	if s.opts.SubscribeKeepalive > 0 {
+		ticker := time.NewTicker(s.opts.SubscribeKeepalive)
+		defer ticker.Stop()
+		pings = ticker.C
+	}
+	lastSent := time.Now()
+
+	for {
+		select {
+		case <-resp.Context().Done():
+			return resp.Context().Err()
+		case block, ok := <-blocks:
+			if !ok {
+				return status.Error(codes.Unavailable, "fell behind the tip, subscribe again")
+			}
+			if err := resp.Send(update(block)); err != nil {
+				return err
+			}
+			lastSent = time.Now()
+		case now := <-pings:
+			if now.Sub(lastSent) < s.opts.SubscribeKeepalive {
+				continue
+			}
+			if err := resp.Send(&walletrpc.BlockUpdate{}); err != nil {
+				return err
+			}
+			lastSent = now
+		}
+	}
+}
+
+// SubscribeReorgs sends an event for each reorg of the cache until the client
+// goes away, with pings in between.
+func (s *SqlStreamer) SubscribeReorgs(placeholder *walletrpc.Empty, resp walletrpc.CompactTxStreamer_SubscribeReorgsServer) error {
+	reorgs, unsubscribe := s.cache.SubscribeReorgs()
+	defer unsubscribe()
+
+	s.log.WithFields(logrus.Fields{
+		"method":    "SubscribeReorgs",
+		"peer_addr": s.peerIPFromContext(resp.Context()),
+	}).Info("Service")
+
+	var pings <-chan time.Time
+	if s.opts.SubscribeKeepalive > 0 {
+		ticker := time.NewTicker(s.opts.SubscribeKeepalive)
+		defer ticker.Stop()
+		pings = ticker.C
+	}
+	lastSent := time.Now()
+
+	for {
+		select {
+		case <-resp.Context().Done():
+			return resp.Context().Err()
+		case reorg, ok := <-reorgs:
+			if !ok {
+				return status.Error(codes.Unavailable, "fell behind the reorgs, subscribe again")
+			}
+			if err := resp.Send(&walletrpc.ReorgEvent{
+				AncestorHeight: uint64(reorg.Ancestor),
+				NewTip:         &walletrpc.BlockID{Height: uint64(reorg.Tip), Hash: reorg.TipHash},
+			}); err != nil {
+				return err
+			}
+			lastSent = time.Now()
+		case now := <-pings:
+			if now.Sub(lastSent) < s.opts.SubscribeKeepalive {
+				continue
+			}
+			if err := resp.Send(&walletrpc.ReorgEvent{}); err != nil {
+				return err
+			}
+			lastSent = now
+		}
+	}
+}
+
+// rangeBottom returns the lowest height in span, whichever way it goes.
+func rangeBottom(span *walletrpc.BlockRange) uint64 {
+	if !span.Follow && span.End.Height < span.Start.Height {
+		return span.End.Height
+	}
+	return span.Start.Height
+}
+
+// lookupBlockRange looks up the blocks in span with strategy in the
+// background, sending them on the first channel and then nil or the error
+// on the second. The caller must call stop when it returns, which ends the
+// lookups if it returns early, on a failed Send for example.
+func (s *SqlStreamer) lookupBlockRange(span *walletrpc.BlockRange, strategy common.LookupStrategy) (<-chan walletrpc.CompactBlock, <-chan error, func()) {
+	blockChan := make(chan walletrpc.CompactBlock)
+	errChan := make(chan error)
+	done := make(chan struct{})
+	go common.GetBlockRange(s.client, s.cache, blockChan, errChan, int(span.Start.Height), int(span.End.Height), strategy, done)
+	return blockChan, errChan, func() { close(done) }
+}
+
+// acquireRangeSlot reserves one of the MaxBlockRangeStreams slots, returning
+// a function to give it back, or a ResourceExhausted error with a retry hint
+// if they're all taken.
+func (s *SqlStreamer) acquireRangeSlot() (func(), error) {
+	if s.rangeSlots == nil {
+		return func() {}, nil
+	}
+
+	select {
+	case s.rangeSlots <- struct{}{}:
+	default:
+		st := status.New(codes.ResourceExhausted, "too many concurrent GetBlockRange streams, try again later")
+		if detailed, err := st.WithDetails(&errdetails.RetryInfo{
+			RetryDelay: ptypes.DurationProto(blockRangeRetryDelay),
+		}); err == nil {
+			st = detailed
+		}
+		return nil, st.Err()
+	}
+
+	s.metrics.BlockRangeStreams.Inc()
+	return func() {
+		s.metrics.BlockRangeStreams.Dec()
+		<-s.rangeSlots
+	}, nil
+}
+
+func (s *SqlStreamer) GetTransaction(ctx context.Context, txf *walletrpc.TxFilter) (*walletrpc.RawTransaction, error) {
+
+	var txBytes []byte
+	var txHeight float64
+
+	if txf == nil {
+		return nil, ErrUnspecified
+	}
+
+	if txf.Hash != nil {
+		txid := txf.Hash
+		for left, right := 0, len(txid)-1; left < right; left, right = left+1, right-1 {
+			txid[left], txid[right] = txid[right], txid[left]
+		}
+		leHashString := hex.EncodeToString(txid)
+		var key [32]byte
+		copy(key[:], txid)
+		if tx := s.txs.get(key); tx != nil {
+			s.metrics.TransactionCacheHits.Inc()
+			return tx, nil
+		}
+
+		// First call to get the raw transaction bytes
+		params := make([]json.RawMessage, 1)
+		params[0] = json.RawMessage("\"" + leHashString + "\"")
+
+		result, rpcErr := s.client.RawRequest("getrawtransaction", params)
+
+		var err error
+		var errCode int64
+		// For some reason, the error responses are not JSON
+		if rpcErr != nil {
+			s.metrics.TotalErrors.Inc()
+
+			s.log.Errorf("Got error: %s", rpcErr.Error())
+			errParts := strings.SplitN(rpcErr.Error(), ":", 2)
+			errCode, err = strconv.ParseInt(errParts[0], 10, 32)
+			//Check to see if we are requesting a height the zcashd doesn't have yet
+			if err == nil && errCode == -8 {
+				return nil, err
+			}
+			return nil, err
+		}
+
+		var txhex string
+		err = json.Unmarshal(result, &txhex)
+		if err != nil {
+			return nil, err
+		}
+		if size := len(txhex) / 2; s.opts.MaxTransactionSize > 0 && size > s.opts.MaxTransactionSize {
+			return nil, status.Errorf(codes.OutOfRange,
+				"transaction %s is %d bytes, more than the %d this server returns; fetch it from a full node instead",
+				leHashString, size, s.opts.MaxTransactionSize)
+		}
+		if err := common.RequestMemoryFromContext(ctx).Reserve(len(txhex) / 2); err != nil {
+			return nil, err
+		}
+
+		txBytes, err = hex.DecodeString(txhex)
+		if err != nil {
+			return nil, err
+		}
+
+		// Second call to get height
+		params = make([]json.RawMessage, 2)
+		params[0] = json.RawMessage("\"" + leHashString + "\"")
+		params[1] = json.RawMessage("1")
+
+		result, rpcErr = s.client.RawRequest("getrawtransaction", params)
+
+		// For some reason, the error responses are not JSON
+		if rpcErr != nil {
+			s.metrics.TotalErrors.Inc()
+
+			s.log.Errorf("Got error: %s", rpcErr.Error())
+			errParts := strings.SplitN(rpcErr.Error(), ":", 2)
+			errCode, err = strconv.ParseInt(errParts[0], 10, 32)
+			//Check to see if we are requesting a height the zcashd doesn't have yet
+			if err == nil && errCode == -8 {
 				return nil, err
 			}
 			return nil, err
@@ -378,7 +1925,8 @@ func (s *SqlStreamer) GetTransaction(ctx context.Context, txf *walletrpc.TxFilte
 		if err != nil {
 			return nil, err
 		}
-		txHeight = txinfo.(map[string]interface{})["height"].(float64)
+		// Transactions in the mempool have no height yet.
+		txHeight, _ = txinfo.(map[string]interface{})["height"].(float64)
 
 		go func() {
 			peerip := s.peerIPFromContext(ctx)
@@ -390,7 +1938,11 @@ func (s *SqlStreamer) GetTransaction(ctx context.Context, txf *walletrpc.TxFilte
 			}).Info("Service")
 		}()
 
-		return &walletrpc.RawTransaction{Data: txBytes, Height: uint64(txHeight)}, nil
+		tx := &walletrpc.RawTransaction{Data: txBytes, Height: uint64(txHeight)}
+		if txHeight > 0 {
+			s.txs.add(key, tx)
+		}
+		return tx, nil
 	}
 
 	if txf.Block.Hash != nil {
@@ -403,10 +1955,453 @@ func (s *SqlStreamer) GetTransaction(ctx context.Context, txf *walletrpc.TxFilte
 	return &walletrpc.RawTransaction{Data: txBytes, Height: uint64(txHeight)}, nil
 }
 
+// GetTransactions answers each txid the client streams with the raw
+// transaction, in order. The txids that arrive while a batch is being
+// fetched are fetched together next, in one round trip to the node.
+func (s *SqlStreamer) GetTransactions(stream walletrpc.CompactTxStreamer_GetTransactionsServer) error {
+	batchSize := s.opts.TransactionsBatch
+	if batchSize < 1 {
+		batchSize = 1
+	}
+	ctx := stream.Context()
+
+	txids := make(chan []byte, batchSize)
+	recvErr := make(chan error, 1)
+	go func() {
+		defer close(txids)
+		for {
+			filter, err := stream.Recv()
+			if err == io.EOF {
+				return
+			}
+			if err == nil && (filter.Hash == nil || len(filter.Hash) != 32) {
+				err = status.Error(codes.InvalidArgument, "GetTransactions needs the txid of each transaction")
+			}
+			if err != nil {
+				recvErr <- err
+				return
+			}
+			select {
+			case txids <- filter.Hash:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	sent := 0
+	for txid := range txids {
+		batch := [][]byte{txid}
+	drain:
+		for len(batch) < batchSize {
+			select {
+			case txid, ok := <-txids:
+				if !ok {
+					break drain
+				}
+				batch = append(batch, txid)
+			default:
+				break drain
+			}
+		}
+
+		txs, err := s.transactions(batch)
+		if err != nil {
+			return err
+		}
+		size := 0
+		for _, tx := range txs {
+			size += len(tx.Data)
+		}
+		memory := common.RequestMemoryFromContext(ctx)
+		if err := memory.Reserve(size); err != nil {
+			return err
+		}
+		for _, tx := range txs {
+			if err := stream.Send(tx); err != nil {
+				return err
+			}
+		}
+		memory.Release(size)
+		sent += len(txs)
+	}
+	select {
+	case err := <-recvErr:
+		return err
+	default:
+	}
+
+	s.log.WithFields(logrus.Fields{
+		"method":       "GetTransactions",
+		"transactions": sent,
+	}).Info("Service")
+	return nil
+}
+
+// transactions returns the transactions with txids, given in the byte order
+// of TxFilter.hash, from the transactions GetTransaction remembers or else
+// from the node, in one batch of calls.
+func (s *SqlStreamer) transactions(txids [][]byte) ([]*walletrpc.RawTransaction, error) {
+	txs := make([]*walletrpc.RawTransaction, len(txids))
+	// The node and the cache go by the big-endian txid.
+	keys := make([][32]byte, len(txids))
+	var missing []int
+	var params [][]json.RawMessage
+	for i, txid := range txids {
+		copy(keys[i][:], txid)
+		reverseBytes(keys[i][:])
+		if txs[i] = s.txs.get(keys[i]); txs[i] != nil {
+			s.metrics.TransactionCacheHits.Inc()
+			continue
+		}
+		missing = append(missing, i)
+		params = append(params, []json.RawMessage{
+			json.RawMessage(`"` + hex.EncodeToString(keys[i][:]) + `"`),
+			json.RawMessage("1"),
+		})
+	}
+	if len(missing) == 0 {
+		return txs, nil
+	}
+
+	results, errs, err := common.BatchRequest(s.client, "getrawtransaction", params)
+	if err != nil {
+		s.metrics.TotalErrors.Inc()
+		return nil, status.Errorf(codes.Unavailable, "getrawtransaction failed: %v", err)
+	}
+	for j, i := range missing {
+		txidString := hex.EncodeToString(keys[i][:])
+		if errs[j] != nil {
+			s.metrics.TotalErrors.Inc()
+			if jsonErr, ok := errs[j].(*btcjson.RPCError); ok && jsonErr.Code == -5 {
+				return nil, status.Errorf(codes.NotFound, "transaction %s: %s", txidString, jsonErr.Message)
+			}
+			return nil, status.Errorf(codes.Unavailable, "getrawtransaction %s failed: %v", txidString, errs[j])
+		}
+		var txinfo struct {
+			Hex    string
+			Height uint64
+		}
+		if err := json.Unmarshal(results[j], &txinfo); err != nil {
+			return nil, status.Errorf(codes.Internal, "bad getrawtransaction answer for %s: %v", txidString, err)
+		}
+		if size := len(txinfo.Hex) / 2; s.opts.MaxTransactionSize > 0 && size > s.opts.MaxTransactionSize {
+			return nil, status.Errorf(codes.OutOfRange,
+				"transaction %s is %d bytes, more than the %d this server returns; fetch it from a full node instead",
+				txidString, size, s.opts.MaxTransactionSize)
+		}
+		data, err := hex.DecodeString(txinfo.Hex)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "bad getrawtransaction answer for %s: %v", txidString, err)
+		}
+		txs[i] = &walletrpc.RawTransaction{Data: data, Height: txinfo.Height}
+		if txinfo.Height > 0 {
+			s.txs.add(keys[i], txs[i])
+		}
+	}
+	return txs, nil
+}
+
+// GetTxStatus reports whether the transaction with txid TxFilter.hash was
+// mined, is in the node's mempool, or neither. Transactions SendTransaction
+// broadcast recently are looked for in the block cache first, and reported
+// as conflicted once they're gone from the mempool if another transaction
+// spent one of their inputs.
+func (s *SqlStreamer) GetTxStatus(ctx context.Context, txf *walletrpc.TxFilter) (*walletrpc.TxStatus, error) {
+	if txf == nil || len(txf.Hash) != 32 {
+		return nil, status.Error(codes.InvalidArgument, "GetTxStatus needs the txid of the transaction")
+	}
+	// The node goes by the big-endian txid.
+	var key [32]byte
+	copy(key[:], txf.Hash)
+	reverseBytes(key[:])
+
+	tracked := s.tracked.get(key)
+	if tracked != nil {
+		if height := s.findCachedTx(txf.Hash, tracked.height); height > 0 {
+			return minedStatus(uint64(height), uint64(s.cache.GetLatestBlock()-height+1)), nil
+		}
+	}
+
+	txidString := hex.EncodeToString(key[:])
+	result, rpcErr := s.client.RawRequest("getrawtransaction", []json.RawMessage{
+		json.RawMessage(`"` + txidString + `"`),
+		json.RawMessage("1"),
+	})
+	if rpcErr == nil {
+		var txinfo struct {
+			Height        uint64
+			Confirmations uint64
+		}
+		if err := json.Unmarshal(result, &txinfo); err != nil {
+			return nil, status.Errorf(codes.Internal, "bad getrawtransaction answer: %v", err)
+		}
+		// Transactions in the mempool have no height yet.
+		if txinfo.Height == 0 {
+			return &walletrpc.TxStatus{Status: walletrpc.TxStatus_IN_MEMPOOL}, nil
+		}
+		return minedStatus(txinfo.Height, txinfo.Confirmations), nil
+	}
+	if jsonErr, ok := rpcErr.(*btcjson.RPCError); !ok || jsonErr.Code != -5 {
+		s.metrics.TotalErrors.Inc()
+		return nil, status.Errorf(codes.Unavailable, "getrawtransaction failed: %v", rpcErr)
+	}
+
+	if tracked != nil {
+		conflicted, err := s.conflicted(tracked)
+		if err != nil {
+			s.metrics.TotalErrors.Inc()
+			return nil, err
+		}
+		if conflicted {
+			return &walletrpc.TxStatus{Status: walletrpc.TxStatus_CONFLICTED}, nil
+		}
+	}
+	return &walletrpc.TxStatus{Status: walletrpc.TxStatus_UNKNOWN}, nil
+}
+
+// GetTxProof returns the proof, from the node's gettxoutproof, that the
+// transaction with txid TxFilter.hash is in the block that mined it. The
+// proof is checked before it's returned.
+func (s *SqlStreamer) GetTxProof(ctx context.Context, txf *walletrpc.TxFilter) (*walletrpc.TxProof, error) {
+	if txf == nil || len(txf.Hash) != 32 {
+		return nil, status.Error(codes.InvalidArgument, "GetTxProof needs the txid of the transaction")
+	}
+	// The node goes by the big-endian txid.
+	txid := make([]byte, 32)
+	copy(txid, txf.Hash)
+	reverseBytes(txid)
+	txidString := hex.EncodeToString(txid)
+
+	result, rpcErr := s.client.RawRequest("gettxoutproof", []json.RawMessage{
+		json.RawMessage(`["` + txidString + `"]`),
+	})
+	if rpcErr != nil {
+		s.metrics.TotalErrors.Inc()
+		if jsonErr, ok := rpcErr.(*btcjson.RPCError); ok && jsonErr.Code == -5 {
+			// Unknown, or not mined yet.
+			return nil, status.Errorf(codes.NotFound, "transaction %s: %s", txidString, jsonErr.Message)
+		}
+		return nil, status.Errorf(codes.Unavailable, "gettxoutproof failed: %v", rpcErr)
+	}
+	var proofHex string
+	if err := json.Unmarshal(result, &proofHex); err != nil {
+		return nil, status.Errorf(codes.Internal, "bad gettxoutproof answer: %v", err)
+	}
+	proofBytes, err := hex.DecodeString(proofHex)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "bad gettxoutproof answer: %v", err)
+	}
+	mb := parser.NewMerkleBlock()
+	if _, err := mb.ParseFromSlice(proofBytes); err != nil {
+		return nil, status.Errorf(codes.Internal, "bad gettxoutproof answer: %v", err)
+	}
+	matched, index, branch, err := mb.Branch()
+	if err == nil && !bytes.Equal(matched, txf.Hash) {
+		err = errors.New("the proof is for another transaction")
+	}
+	if err != nil {
+		s.metrics.TotalErrors.Inc()
+		return nil, status.Errorf(codes.Internal, "bad gettxoutproof answer: %v", err)
+	}
+
+	height, err := s.blockHeight(mb.GetEncodableHash())
+	if err != nil {
+		return nil, err
+	}
+	s.log.WithFields(logrus.Fields{
+		"method": "GetTxProof",
+		"hash":   txidString,
+	}).Info("Service")
+	return &walletrpc.TxProof{
+		Header: mb.Header(),
+		Height: uint64(height),
+		Index:  index,
+		Branch: branch,
+	}, nil
+}
+
+// blockHeight returns the height of the block with hash, in little-endian
+// wire order, from the block cache if it's there, or else from the node.
+func (s *SqlStreamer) blockHeight(hash []byte) (int, error) {
+	if block := s.cache.GetByHash(hash); block != nil {
+		return int(block.Height), nil
+	}
+	displayHash := make([]byte, len(hash))
+	copy(displayHash, hash)
+	reverseBytes(displayHash)
+	result, rpcErr := s.client.RawRequest("getblockheader", []json.RawMessage{
+		json.RawMessage(`"` + hex.EncodeToString(displayHash) + `"`),
+	})
+	if rpcErr != nil {
+		s.metrics.TotalErrors.Inc()
+		if jsonErr, ok := rpcErr.(*btcjson.RPCError); ok && jsonErr.Code == -5 {
+			return 0, status.Errorf(codes.NotFound, "block %x not found", displayHash)
+		}
+		return 0, status.Errorf(codes.Unavailable, "getblockheader failed: %v", rpcErr)
+	}
+	var header struct {
+		Height int
+	}
+	if err := json.Unmarshal(result, &header); err != nil {
+		return 0, status.Errorf(codes.Internal, "bad getblockheader answer: %v", err)
+	}
+	return header.Height, nil
+}
+
+func minedStatus(height, confirmations uint64) *walletrpc.TxStatus {
+	return &walletrpc.TxStatus{
+		Status:        walletrpc.TxStatus_MINED,
+		Height:        height,
+		Confirmations: confirmations,
+	}
+}
+
+// findCachedTx returns the height of the cached block from height from on
+// that has the transaction with hash, or 0 if there's none. Only
+// transactions with shielded parts are in compact blocks.
+func (s *SqlStreamer) findCachedTx(hash []byte, from int) int {
+	if first := s.cache.GetFirstBlock(); from < first {
+		from = first
+	}
+	for height := s.cache.GetLatestBlock(); height >= from && height >= 0; height-- {
+		block := s.cache.Get(height)
+		if block == nil {
+			continue
+		}
+		for _, tx := range block.Vtx {
+			if bytes.Equal(tx.Hash, hash) {
+				return height
+			}
+		}
+	}
+	return 0
+}
+
+// conflicted reports whether another transaction spent one of tracked's
+// inputs: a shielded one in a cached block mined since it was sent, or a
+// transparent one in the chain or the mempool.
+func (s *SqlStreamer) conflicted(tracked *trackedTx) (bool, error) {
+	if len(tracked.nullifiers) > 0 {
+		spent := make(map[string]bool, len(tracked.nullifiers))
+		for _, nf := range tracked.nullifiers {
+			spent[string(nf)] = true
+		}
+		from := tracked.height
+		if first := s.cache.GetFirstBlock(); from < first {
+			from = first
+		}
+		for height := from; height >= 0 && height <= s.cache.GetLatestBlock(); height++ {
+			block := s.cache.Get(height)
+			if block == nil {
+				continue
+			}
+			for _, tx := range block.Vtx {
+				for _, spend := range tx.Spends {
+					if spent[string(spend.Nf)] {
+						return true, nil
+					}
+				}
+			}
+		}
+	}
+
+	for _, outpoint := range tracked.prevouts {
+		txid := make([]byte, len(outpoint.TxHash))
+		copy(txid, outpoint.TxHash)
+		reverseBytes(txid)
+		result, rpcErr := s.client.RawRequest("gettxout", []json.RawMessage{
+			json.RawMessage(`"` + hex.EncodeToString(txid) + `"`),
+			json.RawMessage(strconv.FormatUint(uint64(outpoint.Index), 10)),
+			json.RawMessage("true"),
+		})
+		if rpcErr != nil {
+			return false, status.Errorf(codes.Unavailable, "gettxout failed: %v", rpcErr)
+		}
+		// gettxout answers null for an output that's spent, including by a
+		// transaction in the mempool.
+		if string(result) == "null" {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// maxPingPayload is the largest payload Ping echoes.
+const maxPingPayload = 1024
+
+// Ping echoes the payload in req with timestamps and the latest cached block,
+// without calling the node.
+func (s *SqlStreamer) Ping(ctx context.Context, req *walletrpc.PingRequest) (*walletrpc.PingResponse, error) {
+	receivedAt := time.Now()
+	if req == nil {
+		req = &walletrpc.PingRequest{}
+	}
+	if len(req.Payload) > maxPingPayload {
+		return nil, status.Errorf(codes.InvalidArgument, "ping payload of %d bytes, the most is %d", len(req.Payload), maxPingPayload)
+	}
+
+	resp := &walletrpc.PingResponse{
+		Payload:    req.Payload,
+		ReceivedAt: receivedAt.UnixNano(),
+	}
+	if latest := s.cache.GetLatestBlock(); latest >= 0 {
+		if block := s.cache.Get(latest); block != nil {
+			resp.LatestBlock = block.Height
+			resp.LatestBlockTime = block.Time
+		}
+	}
+	resp.SentAt = time.Now().UnixNano()
+	return resp, nil
+}
+
+// serverNotices returns the operator's notices active now.
+func (s *SqlStreamer) serverNotices() []*walletrpc.ServerNotice {
+	var notices []*walletrpc.ServerNotice
+	for _, notice := range s.opts.Notices.Current(time.Now()) {
+		n := &walletrpc.ServerNotice{
+			Id:      notice.ID,
+			Level:   notice.Level,
+			Message: notice.Message,
+			Url:     notice.URL,
+		}
+		if !notice.From.IsZero() {
+			n.From = notice.From.Unix()
+		}
+		if !notice.Until.IsZero() {
+			n.Until = notice.Until.Unix()
+		}
+		notices = append(notices, n)
+	}
+	return notices
+}
+
+// GetServerNotices returns the operator's current notices, which don't
+// depend on the node.
+func (s *SqlStreamer) GetServerNotices(ctx context.Context, in *walletrpc.Empty) (*walletrpc.ServerNotices, error) {
+	return &walletrpc.ServerNotices{Notices: s.serverNotices()}, nil
+}
+
 // GetLightdInfo gets the LightWalletD (this server) info
 func (s *SqlStreamer) GetLightdInfo(ctx context.Context, in *walletrpc.Empty) (*walletrpc.LightdInfo, error) {
 
-	saplingHeight, blockHeight, chainName, consensusBranchId, err := common.GetSaplingInfo(s.client)
+	var node common.NodeStatus
+	var err error
+	if s.opts.NodeStatus != nil {
+		node = s.opts.NodeStatus.Get()
+		if node.Chain == nil {
+			err = errors.New("the node's status is not known yet")
+		}
+	} else {
+		node.Chain, err = common.GetChainInfo(s.client)
+		node.Reachable = err == nil
+	}
+
+	info := node.Chain
+	saplingHeight := -1
+	if err == nil {
+		saplingHeight, err = common.SaplingActivationHeight(info.Chain, info.SaplingHeight(), s.opts.SaplingActivationHeight)
+	}
 
 	if err != nil {
 		s.log.WithFields(logrus.Fields{
@@ -417,19 +2412,166 @@ func (s *SqlStreamer) GetLightdInfo(ctx context.Context, in *walletrpc.Empty) (*
 		return nil, err
 	}
 
+	// The cache may have moved on since the node's status was taken.
+	blockHeight := info.Headers
+	if latest := s.cache.GetLatestBlock(); latest > blockHeight {
+		blockHeight = latest
+	}
+	// Nodes that don't estimate the network's height, or are past their
+	// estimate, report the height they know.
+	estimatedHeight := info.EstimatedHeight
+	if estimatedHeight < blockHeight {
+		estimatedHeight = blockHeight
+	}
+
 	// TODO these are called Error but they aren't at the moment.
 	// A success will return code 0 and message txhash.
-	return &walletrpc.LightdInfo{
-		Version:                 "0.1-zeclightd",
+	resp := &walletrpc.LightdInfo{
+		Version:                 Version,
 		Vendor:                  "ZecWallet LightWalletD",
 		TaddrSupport:            true,
-		ChainName:               chainName,
+		ChainName:               info.Chain,
 		SaplingActivationHeight: uint64(saplingHeight),
-		ConsensusBranchId:       consensusBranchId,
+		ConsensusBranchId:       info.Consensus.NextBlock,
 		BlockHeight:             uint64(blockHeight),
+		DeprecatedMethods:       s.opts.DeprecatedMethods,
+		SendReady:               node.Reachable && info.Synced(s.opts.SendMinVerificationProgress),
+		CompactFormatVersions:   compactFormats,
+		NodeReachable:           node.Reachable,
+		GitCommit:               s.opts.GitCommit,
+		BuildDate:               s.opts.BuildDate,
+		EstimatedHeight:         uint64(estimatedHeight),
+		DonationAddress:         s.opts.DonationAddress,
+		DonationTaddress:        s.opts.DonationTaddress,
+		OperatorName:            s.opts.OperatorName,
+		OperatorContact:         s.opts.OperatorContact,
+		PrivacyPolicyUrl:        s.opts.PrivacyPolicyURL,
+		Upgrades:                networkUpgrades(info),
+		Notices:                 s.serverNotices(),
+		ProtocolVersion:         ProtocolVersion,
+		MinProtocolVersion:      protocolV1,
+	}
+	if node.Reachable || s.opts.LightdInfoStaleNodeFields {
+		resp.NodeVersion = uint64(node.Version)
+		resp.NodeSubversion = node.Subversion
+		resp.MempoolSize = uint64(node.MempoolSize)
+	}
+	return resp, nil
+}
+
+// upgradeTableTTL is how long GetConsensusBranch keeps the node's network
+// upgrades before asking for them again, when there's no NodeStatus.
+const upgradeTableTTL = 10 * time.Minute
+
+// GetConsensusBranch returns the consensus branch in effect at id.Height,
+// worked out from the node's network upgrades.
+func (s *SqlStreamer) GetConsensusBranch(ctx context.Context, id *walletrpc.BlockID) (*walletrpc.ConsensusBranch, error) {
+	if id == nil {
+		return nil, status.Error(codes.InvalidArgument, "a height is required")
+	}
+	info, err := s.upgradeTable()
+	if err != nil {
+		s.metrics.TotalErrors.Inc()
+		return nil, status.Errorf(codes.Unavailable, "couldn't get the network upgrades: %v", err)
+	}
+	branchID, name := info.BranchID(int(id.Height))
+	return &walletrpc.ConsensusBranch{
+		Height:      id.Height,
+		BranchId:    branchID,
+		UpgradeName: name,
 	}, nil
 }
 
+// upgradeTable returns the node's network upgrades, from NodeStatus if set,
+// otherwise as last asked at most upgradeTableTTL ago. If the node can't be
+// asked, the last answer is used.
+func (s *SqlStreamer) upgradeTable() (*common.ChainInfo, error) {
+	if s.opts.NodeStatus != nil {
+		if info := s.opts.NodeStatus.Get().Chain; info != nil {
+			return info, nil
+		}
+		return nil, errors.New("the node's status is not known yet")
+	}
+
+	s.upgradesMutex.Lock()
+	defer s.upgradesMutex.Unlock()
+	if s.upgrades == nil || time.Since(s.upgradesFetched) > upgradeTableTTL {
+		info, err := common.GetChainInfo(s.client)
+		if err != nil {
+			if s.upgrades != nil {
+				return s.upgrades, nil
+			}
+			return nil, err
+		}
+		s.upgrades, s.upgradesFetched = info, time.Now()
+	}
+	return s.upgrades, nil
+}
+
+// GetValuePools returns the value in each pool and the total supply at the
+// node's tip.
+func (s *SqlStreamer) GetValuePools(ctx context.Context, in *walletrpc.Empty) (*walletrpc.ValuePools, error) {
+	info, err := s.valuePoolsInfo()
+	if err != nil {
+		s.metrics.TotalErrors.Inc()
+		return nil, status.Errorf(codes.Unavailable, "couldn't get the value pools: %v", err)
+	}
+	if len(info.ValuePools) == 0 {
+		return nil, status.Error(codes.Unimplemented, "the node doesn't report its value pools")
+	}
+
+	resp := &walletrpc.ValuePools{Height: uint64(info.Blocks)}
+	for _, pool := range info.ValuePools {
+		resp.Pools = append(resp.Pools, &walletrpc.ValuePool{
+			Id:        pool.ID,
+			ValueZat:  pool.ChainValueZat,
+			Monitored: pool.Monitored,
+		})
+	}
+	resp.TotalSupplyZat, resp.SupplyKnown = info.TotalSupply()
+	return resp, nil
+}
+
+// valuePoolsInfo returns the node's getblockchaininfo for its value pools,
+// asking again once the cache has a block past the last answer. If the node
+// can't be reached, the last answer is returned.
+func (s *SqlStreamer) valuePoolsInfo() (*common.ChainInfo, error) {
+	s.valuePoolsMutex.Lock()
+	defer s.valuePoolsMutex.Unlock()
+	if s.valuePools == nil || s.cache.GetLatestBlock() > s.valuePools.Blocks {
+		info, err := common.GetChainInfo(s.client)
+		if err != nil {
+			if s.valuePools != nil {
+				return s.valuePools, nil
+			}
+			return nil, err
+		}
+		s.valuePools = info
+	}
+	return s.valuePools, nil
+}
+
+// networkUpgrades lists the network upgrades in info in the order they
+// activate.
+func networkUpgrades(info *common.ChainInfo) []*walletrpc.NetworkUpgrade {
+	upgrades := make([]*walletrpc.NetworkUpgrade, 0, len(info.Upgrades))
+	for branchID, upgrade := range info.Upgrades {
+		upgrades = append(upgrades, &walletrpc.NetworkUpgrade{
+			Name:             upgrade.Name,
+			BranchId:         branchID,
+			ActivationHeight: uint64(upgrade.ActivationHeight),
+			Status:           upgrade.Status,
+		})
+	}
+	sort.Slice(upgrades, func(i, j int) bool {
+		if upgrades[i].ActivationHeight != upgrades[j].ActivationHeight {
+			return upgrades[i].ActivationHeight < upgrades[j].ActivationHeight
+		}
+		return upgrades[i].BranchId < upgrades[j].BranchId
+	})
+	return upgrades
+}
+
 // SendTransaction forwards raw transaction bytes to a zcashd instance over JSON-RPC
 func (s *SqlStreamer) SendTransaction(ctx context.Context, rawtx *walletrpc.RawTransaction) (*walletrpc.SendResponse, error) {
 	// sendrawtransaction "hexstring" ( allowhighfees )
@@ -449,28 +2591,60 @@ func (s *SqlStreamer) SendTransaction(ctx context.Context, rawtx *walletrpc.RawT
 		return nil, ErrUnspecified
 	}
 
+	dedupKey := sha256.Sum256(rawtx.Data)
+	if resp := s.sent.get(dedupKey); resp != nil {
+		s.metrics.SendTransactionDedupHits.Inc()
+		return resp, nil
+	}
+
+	if s.opts.SendRequireSynced {
+		if err := s.checkNodeSynced(); err != nil {
+			s.metrics.TotalErrors.Inc()
+			return nil, err
+		}
+	}
+
+	if s.opts.SendCheckBranch {
+		if err := s.checkConsensusBranch(rawtx.Data); err != nil {
+			s.metrics.TotalErrors.Inc()
+			return nil, err
+		}
+	}
+
+	if s.opts.SendMinInputConfirmations > 0 {
+		if err := s.checkInputConfirmations(rawtx.Data); err != nil {
+			s.metrics.TotalErrors.Inc()
+			return nil, err
+		}
+	}
+
 	// Construct raw JSON-RPC params
 	params := make([]json.RawMessage, 1)
 	txHexString := hex.EncodeToString(rawtx.Data)
 	params[0] = json.RawMessage("\"" + txHexString + "\"")
-	result, rpcErr := s.client.RawRequest("sendrawtransaction", params)
 
-	var err error
-	var errCode int64
-	var errMsg string
+	errCode, errMsg, err := s.sendRawTransaction(params)
+	if err != nil {
+		return nil, err
+	}
+	if s.shouldRetrySend(errCode) {
+		s.log.WithFields(logrus.Fields{
+			"code":  errCode,
+			"error": errMsg,
+		}).Info("retrying sendrawtransaction")
 
-	// For some reason, the error responses are not JSON
-	if rpcErr != nil {
-		errParts := strings.SplitN(rpcErr.Error(), ":", 2)
-		errMsg = strings.TrimSpace(errParts[1])
-		errCode, err = strconv.ParseInt(errParts[0], 10, 32)
+		select {
+		case <-ctx.Done():
+			return nil, status.FromContextError(ctx.Err()).Err()
+		case <-time.After(s.opts.SendRetryBackoff):
+		}
+		errCode, errMsg, err = s.sendRawTransaction(params)
 		if err != nil {
-			// This should never happen. We can't panic here, but it's that class of error.
-			// This is why we need integration testing to work better than regtest currently does. TODO.
-			return nil, errors.New("SendTransaction couldn't parse error code")
+			return nil, err
+		}
+		if errCode == 0 {
+			s.metrics.SendTransactionRetrySuccesses.Inc()
 		}
-	} else {
-		errMsg = string(result)
 	}
 
 	// TODO these are called Error but they aren't at the moment.
@@ -479,8 +2653,368 @@ func (s *SqlStreamer) SendTransaction(ctx context.Context, rawtx *walletrpc.RawT
 		ErrorCode:    int32(errCode),
 		ErrorMessage: errMsg,
 	}
+	if errCode == 0 {
+		s.sent.add(dedupKey, resp)
+		s.tracked.add(rawtx.Data, s.cache.GetLatestBlock())
+	}
 
 	s.metrics.SendTransactionsCounter.Inc()
 
 	return resp, nil
 }
+
+// checkInputConfirmations makes sure every transparent output spent by the
+// transaction has at least SendMinInputConfirmations confirmations.
+func (s *SqlStreamer) checkInputConfirmations(txBytes []byte) error {
+	tx := parser.NewTransaction()
+	if _, err := tx.ParseFromSlice(txBytes); err != nil {
+		return status.Errorf(codes.InvalidArgument, "couldn't parse transaction: %v", err)
+	}
+
+	for _, outpoint := range tx.PrevOutpoints() {
+		txid := make([]byte, len(outpoint.TxHash))
+		for i := range outpoint.TxHash {
+			txid[i] = outpoint.TxHash[len(outpoint.TxHash)-1-i]
+		}
+		input := hex.EncodeToString(txid) + ":" + strconv.FormatUint(uint64(outpoint.Index), 10)
+
+		params := make([]json.RawMessage, 2)
+		params[0] = json.RawMessage("\"" + hex.EncodeToString(txid) + "\"")
+		params[1] = json.RawMessage("1")
+		result, rpcErr := s.client.RawRequest("getrawtransaction", params)
+		if rpcErr != nil {
+			s.log.WithFields(logrus.Fields{
+				"input": input,
+				"error": rpcErr,
+			}).Warn("couldn't look up transaction input")
+			return status.Errorf(codes.FailedPrecondition, "input %s not found", input)
+		}
+
+		// Mempool transactions have no confirmations field
+		var prevTx struct {
+			Confirmations int `json:"confirmations"`
+		}
+		if err := json.Unmarshal(result, &prevTx); err != nil {
+			return err
+		}
+
+		if prevTx.Confirmations < s.opts.SendMinInputConfirmations {
+			return status.Errorf(codes.FailedPrecondition,
+				"input %s has %d confirmations, at least %d are required",
+				input, prevTx.Confirmations, s.opts.SendMinInputConfirmations)
+		}
+	}
+
+	return nil
+}
+
+// checkNodeSynced returns a FailedPrecondition error if the node is still
+// catching up with the chain, in which case a broadcast wouldn't reach the
+// network in any useful way.
+func (s *SqlStreamer) checkNodeSynced() error {
+	info, err := common.GetChainInfo(s.client)
+	if err != nil {
+		return status.Errorf(codes.Unavailable, "couldn't check whether the node is synced: %v", err)
+	}
+	if !info.Synced(s.opts.SendMinVerificationProgress) {
+		s.log.WithFields(logrus.Fields{
+			"initialblockdownload": info.InitialBlockDownload,
+			"verificationprogress": info.VerificationProgress,
+		}).Warn("refusing to broadcast, node not synced")
+		return status.Errorf(codes.FailedPrecondition, "node not synced (verification progress %.4f)", info.VerificationProgress)
+	}
+	return nil
+}
+
+// checkConsensusBranch returns a FailedPrecondition error naming the expected
+// format if the transaction's version can't be mined on the node's next
+// block, which usually means the wallet built it for an earlier network
+// upgrade.
+func (s *SqlStreamer) checkConsensusBranch(txData []byte) error {
+	tx := parser.NewTransaction()
+	if _, err := tx.ParseFromSlice(txData); err != nil {
+		return status.Errorf(codes.InvalidArgument, "couldn't parse transaction: %v", err)
+	}
+	info, err := common.GetChainInfo(s.client)
+	if err != nil {
+		return status.Errorf(codes.Unavailable, "couldn't get the consensus branch: %v", err)
+	}
+
+	expected := info.NextBlockTxVersions()
+	if expected == nil {
+		// An upgrade this server doesn't know about, leave it to the node.
+		return nil
+	}
+	version := common.TxVersion{Version: tx.GetVersion(), VersionGroupID: tx.GetVersionGroupID()}
+	for _, v := range expected {
+		if v == version {
+			return nil
+		}
+	}
+
+	names := make([]string, len(expected))
+	for i, v := range expected {
+		names[i] = v.String()
+	}
+	return status.Errorf(codes.FailedPrecondition, "transaction %s is not valid on consensus branch %s, expected %s",
+		version, info.Consensus.NextBlock, strings.Join(names, " or "))
+}
+
+type sentTx struct {
+	resp    *walletrpc.SendResponse
+	expires time.Time
+}
+
+// sendDedup remembers the transactions SendTransaction broadcast for ttl,
+// keyed by the hash of their raw bytes, so that a client retrying in a loop
+// doesn't send each attempt on to the node. A nil *sendDedup remembers
+// nothing.
+type sendDedup struct {
+	ttl time.Duration
+	max int
+
+	mutex sync.Mutex
+	sent  map[[sha256.Size]byte]sentTx
+}
+
+func newSendDedup(ttl time.Duration, max int) *sendDedup {
+	if max < 1 {
+		max = 1
+	}
+	return &sendDedup{
+		ttl:  ttl,
+		max:  max,
+		sent: make(map[[sha256.Size]byte]sentTx),
+	}
+}
+
+// get returns the response to an earlier submission of the transaction with
+// key, or nil.
+func (d *sendDedup) get(key [sha256.Size]byte) *walletrpc.SendResponse {
+	if d == nil {
+		return nil
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	tx, ok := d.sent[key]
+	if !ok || time.Now().After(tx.expires) {
+		return nil
+	}
+	return tx.resp
+}
+
+// add remembers resp for key, making room by forgetting the expired
+// transactions, or if there are none the one closest to expiring.
+func (d *sendDedup) add(key [sha256.Size]byte, resp *walletrpc.SendResponse) {
+	if d == nil {
+		return
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	now := time.Now()
+	if len(d.sent) >= d.max {
+		var oldest [sha256.Size]byte
+		var oldestExpiry time.Time
+		for k, tx := range d.sent {
+			if now.After(tx.expires) {
+				delete(d.sent, k)
+			} else if oldestExpiry.IsZero() || tx.expires.Before(oldestExpiry) {
+				oldest, oldestExpiry = k, tx.expires
+			}
+		}
+		if len(d.sent) >= d.max {
+			delete(d.sent, oldest)
+		}
+	}
+	d.sent[key] = sentTx{resp: resp, expires: now.Add(d.ttl)}
+}
+
+type trackedTx struct {
+	nullifiers [][]byte
+	prevouts   []parser.PrevOutpoint
+	// height is the height of the cache's latest block when it was sent.
+	height  int
+	expires time.Time
+}
+
+// sentTracker remembers the inputs of the transactions SendTransaction
+// broadcast for ttl, keyed by txid, for GetTxStatus. A nil *sentTracker
+// remembers nothing.
+type sentTracker struct {
+	ttl time.Duration
+	max int
+
+	mutex sync.Mutex
+	txs   map[[32]byte]*trackedTx
+}
+
+func newSentTracker(ttl time.Duration, max int) *sentTracker {
+	if max < 1 {
+		max = 1
+	}
+	return &sentTracker{
+		ttl: ttl,
+		max: max,
+		txs: make(map[[32]byte]*trackedTx),
+	}
+}
+
+// get returns what's remembered of the transaction with the big-endian txid
+// key, or nil.
+func (t *sentTracker) get(key [32]byte) *trackedTx {
+	if t == nil {
+		return nil
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	tx, ok := t.txs[key]
+	if !ok || time.Now().After(tx.expires) {
+		return nil
+	}
+	return tx
+}
+
+// add remembers the inputs of the raw transaction txBytes, sent when the
+// cache's latest block was at height, making room as sendDedup.add does.
+// Transactions that don't parse aren't remembered.
+func (t *sentTracker) add(txBytes []byte, height int) {
+	if t == nil {
+		return
+	}
+	tx := parser.NewTransaction()
+	if _, err := tx.ParseFromSlice(txBytes); err != nil {
+		return
+	}
+	tracked := &trackedTx{prevouts: tx.PrevOutpoints(), height: height}
+	for _, spend := range tx.ToCompact(0).Spends {
+		tracked.nullifiers = append(tracked.nullifiers, spend.Nf)
+	}
+	var key [32]byte
+	copy(key[:], tx.GetDisplayHash())
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	now := time.Now()
+	if _, ok := t.txs[key]; !ok && len(t.txs) >= t.max {
+		var oldest [32]byte
+		var oldestExpiry time.Time
+		for k, tx := range t.txs {
+			if now.After(tx.expires) {
+				delete(t.txs, k)
+			} else if oldestExpiry.IsZero() || tx.expires.Before(oldestExpiry) {
+				oldest, oldestExpiry = k, tx.expires
+			}
+		}
+		if len(t.txs) >= t.max {
+			delete(t.txs, oldest)
+		}
+	}
+	tracked.expires = now.Add(t.ttl)
+	t.txs[key] = tracked
+}
+
+type cachedTx struct {
+	tx      *walletrpc.RawTransaction
+	expires time.Time
+}
+
+// txCache remembers the mined transactions GetTransaction served for ttl,
+// keyed by txid. A nil *txCache remembers nothing.
+type txCache struct {
+	ttl time.Duration
+	max int
+
+	mutex sync.Mutex
+	txs   map[[32]byte]cachedTx
+}
+
+func newTxCache(ttl time.Duration, max int) *txCache {
+	return &txCache{
+		ttl: ttl,
+		max: max,
+		txs: make(map[[32]byte]cachedTx),
+	}
+}
+
+// get returns the transaction with txid key, or nil if it isn't remembered.
+func (c *txCache) get(key [32]byte) *walletrpc.RawTransaction {
+	if c == nil {
+		return nil
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	cached, ok := c.txs[key]
+	if !ok || time.Now().After(cached.expires) {
+		return nil
+	}
+	return cached.tx
+}
+
+// add remembers tx, making room as sendDedup.add does.
+func (c *txCache) add(key [32]byte, tx *walletrpc.RawTransaction) {
+	if c == nil {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	now := time.Now()
+	if _, ok := c.txs[key]; !ok && len(c.txs) >= c.max {
+		var oldest [32]byte
+		var oldestExpiry time.Time
+		for k, cached := range c.txs {
+			if now.After(cached.expires) {
+				delete(c.txs, k)
+			} else if oldestExpiry.IsZero() || cached.expires.Before(oldestExpiry) {
+				oldest, oldestExpiry = k, cached.expires
+			}
+		}
+		if len(c.txs) >= c.max {
+			delete(c.txs, oldest)
+		}
+	}
+	c.txs[key] = cachedTx{tx: tx, expires: now.Add(c.ttl)}
+}
+
+// sendRawTransaction returns the node's error code and message, or code 0 and
+// the txid on success.
+func (s *SqlStreamer) sendRawTransaction(params []json.RawMessage) (int64, string, error) {
+	result, rpcErr := s.client.RawRequest("sendrawtransaction", params)
+
+	// For some reason, the error responses are not JSON
+	if rpcErr != nil {
+		errParts := strings.SplitN(rpcErr.Error(), ":", 2)
+		errCode, err := strconv.ParseInt(errParts[0], 10, 32)
+		if err != nil || len(errParts) != 2 {
+			// This should never happen. We can't panic here, but it's that class of error.
+			// This is why we need integration testing to work better than regtest currently does. TODO.
+			return 0, "", errors.New("SendTransaction couldn't parse error code")
+		}
+		return errCode, strings.TrimSpace(errParts[1]), nil
+	}
+	return 0, string(result), nil
+}
+
+// shouldRetrySend reports whether errCode is on the operator's list of
+// transient sendrawtransaction errors.
+func (s *SqlStreamer) shouldRetrySend(errCode int64) bool {
+	if errCode == 0 {
+		return false
+	}
+	for _, code := range s.opts.SendRetryCodes {
+		if int64(code) == errCode {
+			return true
+		}
+	}
+	return false
+}
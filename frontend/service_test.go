@@ -0,0 +1,2657 @@
+package frontend
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/adityapk00/lightwalletd/common"
+	"github.com/adityapk00/lightwalletd/parser"
+	"github.com/adityapk00/lightwalletd/walletrpc"
+)
+
+// fakeZcashd answers JSON-RPC requests from per-method handlers.
+type fakeZcashd struct {
+	mutex    sync.Mutex
+	handlers map[string]func(params []json.RawMessage) (interface{}, error)
+	calls    map[string]int
+}
+
+func newFakeZcashd() *fakeZcashd {
+	return &fakeZcashd{
+		handlers: make(map[string]func(params []json.RawMessage) (interface{}, error)),
+		calls:    make(map[string]int),
+	}
+}
+
+func (f *fakeZcashd) handle(method string, handler func(params []json.RawMessage) (interface{}, error)) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.handlers[method] = handler
+}
+
+func (f *fakeZcashd) count(method string) int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	return f.calls[method]
+}
+
+func (f *fakeZcashd) RawRequest(method string, params []json.RawMessage) (json.RawMessage, error) {
+	f.mutex.Lock()
+	f.calls[method]++
+	handler, ok := f.handlers[method]
+	f.mutex.Unlock()
+
+	if !ok {
+		return nil, &btcjson.RPCError{Code: -32601, Message: "Method not found"}
+	}
+	result, err := handler(params)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(result)
+}
+
+func newTestStreamer(t *testing.T, zcashd common.RPCClient, opts Options) *SqlStreamer {
+	logger := logrus.New()
+	logger.SetOutput(ioutil.Discard)
+	log := logger.WithField("app", "test")
+
+	service, err := NewSQLiteStreamer(zcashd, common.NewBlockCache(100, log), log, common.GetPrometheusMetrics(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return service.(*SqlStreamer)
+}
+
+// testTxWithInputs returns a raw transaction from the test vectors that
+// spends transparent outputs.
+func testTxWithInputs(t *testing.T) ([]byte, *parser.Transaction) {
+	testData, err := os.Open("../testdata/zip243_raw_tx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer testData.Close()
+
+	scan := bufio.NewScanner(testData)
+	for scan.Scan() {
+		if strings.HasPrefix(scan.Text(), "#") {
+			continue
+		}
+		txData, err := hex.DecodeString(scan.Text())
+		if err != nil {
+			t.Fatal(err)
+		}
+		tx := parser.NewTransaction()
+		if _, err := tx.ParseFromSlice(txData); err != nil {
+			t.Fatal(err)
+		}
+		if len(tx.PrevOutpoints()) > 0 {
+			return txData, tx
+		}
+	}
+	t.Fatal("no test transaction with transparent inputs")
+	return nil, nil
+}
+
+func TestSendTransactionMinInputConfirmations(t *testing.T) {
+	txData, _ := testTxWithInputs(t)
+
+	for _, tt := range []struct {
+		confirmations int
+		accepted      bool
+	}{
+		{0, false},
+		{5, false},
+		{6, true},
+		{100, true},
+	} {
+		zcashd := newFakeZcashd()
+		zcashd.handle("getrawtransaction", func(params []json.RawMessage) (interface{}, error) {
+			return map[string]interface{}{"confirmations": tt.confirmations}, nil
+		})
+		zcashd.handle("sendrawtransaction", func(params []json.RawMessage) (interface{}, error) {
+			return "txid", nil
+		})
+		s := newTestStreamer(t, zcashd, Options{SendMinInputConfirmations: 6})
+
+		_, err := s.SendTransaction(context.Background(), &walletrpc.RawTransaction{Data: txData})
+		if tt.accepted {
+			if err != nil {
+				t.Errorf("%d confirmations: unexpected error %v", tt.confirmations, err)
+			}
+			if zcashd.count("sendrawtransaction") != 1 {
+				t.Errorf("%d confirmations: transaction not broadcast", tt.confirmations)
+			}
+			continue
+		}
+
+		if status.Code(err) != codes.FailedPrecondition || !strings.Contains(err.Error(), "input ") {
+			t.Errorf("%d confirmations: expected FailedPrecondition naming the input, got %v", tt.confirmations, err)
+		}
+		if zcashd.count("sendrawtransaction") != 0 {
+			t.Errorf("%d confirmations: shallow transaction was broadcast", tt.confirmations)
+		}
+	}
+}
+
+func TestSendTransactionInputConfirmationsDisabled(t *testing.T) {
+	txData, _ := testTxWithInputs(t)
+
+	zcashd := newFakeZcashd()
+	zcashd.handle("sendrawtransaction", func(params []json.RawMessage) (interface{}, error) {
+		return "txid", nil
+	})
+	s := newTestStreamer(t, zcashd, Options{})
+
+	if _, err := s.SendTransaction(context.Background(), &walletrpc.RawTransaction{Data: txData}); err != nil {
+		t.Fatal(err)
+	}
+	if zcashd.count("getrawtransaction") != 0 {
+		t.Error("inputs looked up with the check disabled")
+	}
+}
+
+func TestSendTransactionRetry(t *testing.T) {
+	txData, _ := testTxWithInputs(t)
+
+	for _, tt := range []struct {
+		code    int
+		retried bool
+	}{
+		{-28, true},  // RPC_IN_WARMUP, on the allowlist
+		{-9, true},   // RPC_CLIENT_NOT_CONNECTED, on the allowlist
+		{-1, false},  // RPC_MISC_ERROR, not on the allowlist
+		{-25, false}, // RPC_VERIFY_ERROR
+		{-26, false}, // RPC_VERIFY_REJECTED
+		{-27, false}, // RPC_VERIFY_ALREADY_IN_CHAIN
+	} {
+		zcashd := newFakeZcashd()
+		zcashd.handle("sendrawtransaction", func(params []json.RawMessage) (interface{}, error) {
+			if zcashd.count("sendrawtransaction") == 1 {
+				return nil, &btcjson.RPCError{Code: btcjson.RPCErrorCode(tt.code), Message: "failed"}
+			}
+			return "txid", nil
+		})
+		s := newTestStreamer(t, zcashd, Options{SendRetryCodes: []int{-28, -9}})
+		retries := testutil.ToFloat64(s.metrics.SendTransactionRetrySuccesses)
+
+		resp, err := s.SendTransaction(context.Background(), &walletrpc.RawTransaction{Data: txData})
+		if err != nil {
+			t.Fatalf("code %d: %v", tt.code, err)
+		}
+		if tt.retried {
+			if zcashd.count("sendrawtransaction") != 2 || resp.ErrorCode != 0 {
+				t.Errorf("code %d: expected a successful retry, got %v", tt.code, resp)
+			}
+			if testutil.ToFloat64(s.metrics.SendTransactionRetrySuccesses) != retries+1 {
+				t.Errorf("code %d: retry success not counted", tt.code)
+			}
+			continue
+		}
+		if zcashd.count("sendrawtransaction") != 1 || resp.ErrorCode != int32(tt.code) {
+			t.Errorf("code %d: expected no retry, got %v", tt.code, resp)
+		}
+	}
+}
+
+func TestSendTransactionRetryRefusesFinalCodes(t *testing.T) {
+	log := logrus.NewEntry(logrus.New())
+	_, err := NewSQLiteStreamer(newFakeZcashd(), common.NewBlockCache(1, log), log, common.GetPrometheusMetrics(), Options{
+		SendRetryCodes: []int{-28, -27},
+	})
+	if err == nil {
+		t.Error("expected retrying RPC_VERIFY_ALREADY_IN_CHAIN to be refused")
+	}
+}
+
+// fillCache adds a chain of placeholder blocks to the streamer's cache.
+func fillCache(t *testing.T, s *SqlStreamer, start, end int) {
+	var prevHash []byte
+	for height := start; height <= end; height++ {
+		hash := []byte(fmt.Sprintf("hash-%d", height))
+		if err, _ := s.cache.Add(height, &walletrpc.CompactBlock{Height: uint64(height), Hash: hash, PrevHash: prevHash}); err != nil {
+			t.Fatal(err)
+		}
+		prevHash = hash
+	}
+}
+
+// testRangeStream collects the blocks sent on a GetBlockRange stream. If
+// block is not nil, each Send waits for it first.
+type testRangeStream struct {
+	grpc.ServerStream
+	ctx    context.Context
+	block  chan struct{}
+	blocks []*walletrpc.CompactBlock
+}
+
+func (s *testRangeStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *testRangeStream) Send(block *walletrpc.CompactBlock) error {
+	if s.block != nil {
+		<-s.block
+	}
+	s.blocks = append(s.blocks, block)
+	return nil
+}
+
+func blockRange(start, end uint64) *walletrpc.BlockRange {
+	return &walletrpc.BlockRange{
+		Start: &walletrpc.BlockID{Height: start},
+		End:   &walletrpc.BlockID{Height: end},
+	}
+}
+
+// followStream passes the blocks sent on a GetBlockRange stream to sent.
+type followStream struct {
+	grpc.ServerStream
+	ctx  context.Context
+	sent chan *walletrpc.CompactBlock
+}
+
+func (s *followStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *followStream) Send(block *walletrpc.CompactBlock) error {
+	s.sent <- block
+	return nil
+}
+
+// readyFollowStream is a followStream that closes ready when the handler
+// first asks for its context, which GetBlockRange does once it's subscribed.
+type readyFollowStream struct {
+	*followStream
+	once  sync.Once
+	ready chan struct{}
+}
+
+func (s *readyFollowStream) Context() context.Context {
+	s.once.Do(func() { close(s.ready) })
+	return s.followStream.Context()
+}
+
+func TestGetBlockRangeFollowFromNextBlock(t *testing.T) {
+	s := newTestStreamer(t, newFakeZcashd(), Options{FollowBlockRange: true})
+	fillCache(t, s, 1000, 1010)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stream := &readyFollowStream{
+		followStream: &followStream{ctx: ctx, sent: make(chan *walletrpc.CompactBlock)},
+		ready:        make(chan struct{}),
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- s.GetBlockRange(&walletrpc.BlockRange{Start: &walletrpc.BlockID{Height: 1011}, Follow: true}, stream)
+	}()
+	select {
+	case <-stream.ready:
+	case err := <-done:
+		t.Fatalf("stream ended before the next block: %v", err)
+	}
+
+	if err, _ := s.cache.Add(1011, &walletrpc.CompactBlock{Height: 1011, Hash: []byte("hash-1011"), PrevHash: []byte("hash-1010")}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case block := <-stream.sent:
+		if block.Height != 1011 {
+			t.Errorf("received block %d, expected 1011", block.Height)
+		}
+	case err := <-done:
+		t.Fatalf("stream ended before block 1011: %v", err)
+	case <-time.After(time.Second):
+		t.Fatal("block 1011 not received")
+	}
+}
+
+func TestGetBlockRangeFollow(t *testing.T) {
+	s := newTestStreamer(t, newFakeZcashd(), Options{FollowBlockRange: true})
+	fillCache(t, s, 1000, 1010)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stream := &followStream{ctx: ctx, sent: make(chan *walletrpc.CompactBlock)}
+	done := make(chan error)
+	go func() {
+		done <- s.GetBlockRange(&walletrpc.BlockRange{Start: &walletrpc.BlockID{Height: 1008}, Follow: true}, stream)
+	}()
+
+	receive := func(height uint64) {
+		select {
+		case block := <-stream.sent:
+			if block.Height != height {
+				t.Fatalf("received block %d, expected %d", block.Height, height)
+			}
+		case err := <-done:
+			t.Fatalf("stream ended before block %d: %v", height, err)
+		case <-time.After(time.Second):
+			t.Fatalf("block %d not received", height)
+		}
+	}
+
+	// Up to the tip, then each new block as soon as it's ingested.
+	for height := uint64(1008); height <= 1010; height++ {
+		receive(height)
+	}
+	for height := 1011; height <= 1012; height++ {
+		block := &walletrpc.CompactBlock{
+			Height:   uint64(height),
+			Hash:     []byte(fmt.Sprintf("hash-%d", height)),
+			PrevHash: []byte(fmt.Sprintf("hash-%d", height-1)),
+		}
+		if err, _ := s.cache.Add(height, block); err != nil {
+			t.Fatal(err)
+		}
+		receive(uint64(height))
+	}
+
+	// A reorg under the client ends the stream.
+	if err, _ := s.cache.Add(1011, &walletrpc.CompactBlock{Height: 1011, Hash: []byte("other"), PrevHash: []byte("hash-1010")}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-done:
+		if status.Code(err) != codes.Aborted {
+			t.Errorf("expected Aborted after a reorg, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("stream not ended by the reorg")
+	}
+
+	// Not unless the server allows it.
+	s = newTestStreamer(t, newFakeZcashd(), Options{})
+	err := s.GetBlockRange(&walletrpc.BlockRange{Start: &walletrpc.BlockID{Height: 1008}, Follow: true}, stream)
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("expected FailedPrecondition, got %v", err)
+	}
+}
+
+func TestMaxBlockRangeStreams(t *testing.T) {
+	s := newTestStreamer(t, newFakeZcashd(), Options{MaxBlockRangeStreams: 2})
+	fillCache(t, s, 1000, 1010)
+	ctx := context.Background()
+
+	// Saturate the limit with streams stuck sending.
+	unblock := make(chan struct{})
+	done := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			done <- s.GetBlockRange(blockRange(1000, 1001), &testRangeStream{ctx: ctx, block: unblock})
+		}()
+	}
+	for testutil.ToFloat64(s.metrics.BlockRangeStreams) != 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	err := s.GetBlockRange(blockRange(1000, 1001), &testRangeStream{ctx: ctx})
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("expected ResourceExhausted, got %v", err)
+	}
+	var retryInfo *errdetails.RetryInfo
+	for _, detail := range status.Convert(err).Details() {
+		if info, ok := detail.(*errdetails.RetryInfo); ok {
+			retryInfo = info
+		}
+	}
+	if retryInfo == nil || retryInfo.RetryDelay == nil {
+		t.Error("expected a retry hint")
+	}
+
+	// Other methods are unaffected.
+	if _, err := s.GetBlock(ctx, &walletrpc.BlockID{Height: 1005}); err != nil {
+		t.Errorf("GetBlock failed while range streams were saturated: %v", err)
+	}
+	if _, err := s.GetLatestBlock(ctx, &walletrpc.ChainSpec{}); err != nil {
+		t.Errorf("GetLatestBlock failed while range streams were saturated: %v", err)
+	}
+
+	close(unblock)
+	for i := 0; i < 2; i++ {
+		if err := <-done; err != nil {
+			t.Error(err)
+		}
+	}
+
+	// The slots are given back once the streams finish.
+	stream := &testRangeStream{ctx: ctx}
+	if err := s.GetBlockRange(blockRange(1000, 1010), stream); err != nil {
+		t.Fatal(err)
+	}
+	if len(stream.blocks) != 11 {
+		t.Errorf("expected 11 blocks, got %d", len(stream.blocks))
+	}
+	if testutil.ToFloat64(s.metrics.BlockRangeStreams) != 0 {
+		t.Error("stream slots leaked")
+	}
+}
+
+func TestGetBlockIncludeFull(t *testing.T) {
+	ctx := context.Background()
+	newStreamer := func(opts Options) (*SqlStreamer, *fakeZcashd) {
+		zcashd := newFakeZcashd()
+		zcashd.handle("getblock", func(params []json.RawMessage) (interface{}, error) {
+			return "deadbeef", nil
+		})
+		s := newTestStreamer(t, zcashd, opts)
+		fillCache(t, s, 1000, 1010)
+		return s, zcashd
+	}
+
+	// Compact-only by default, and without asking.
+	s, zcashd := newStreamer(Options{MaxFullBlockRequests: 1})
+	block, err := s.GetBlock(ctx, &walletrpc.BlockID{Height: 1005})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if block.Height != 1005 || block.FullBlock != nil || zcashd.count("getblock") != 0 {
+		t.Errorf("expected a compact block only, got %v", block)
+	}
+
+	// Full block included on request.
+	block, err = s.GetBlock(ctx, &walletrpc.BlockID{Height: 1005, IncludeFull: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if block.Height != 1005 || hex.EncodeToString(block.FullBlock) != "deadbeef" {
+		t.Errorf("expected the full block alongside the compact one, got %v", block)
+	}
+
+	// Refused unless the server allows it.
+	s, zcashd = newStreamer(Options{})
+	_, err = s.GetBlock(ctx, &walletrpc.BlockID{Height: 1005, IncludeFull: true})
+	if status.Code(err) != codes.FailedPrecondition || zcashd.count("getblock") != 0 {
+		t.Errorf("expected FailedPrecondition, got %v", err)
+	}
+}
+
+func TestGetBlockByHash(t *testing.T) {
+	ctx := context.Background()
+	hash := func(height int) []byte { return bytes.Repeat([]byte{byte(height)}, 32) }
+	reorged := bytes.Repeat([]byte{0xff}, 32)
+
+	zcashd := newFakeZcashd()
+	zcashd.handle("getblockheader", func(params []json.RawMessage) (interface{}, error) {
+		var displayHash string
+		json.Unmarshal(params[0], &displayHash)
+		switch displayHash {
+		case hex.EncodeToString(hash(1001)):
+			return map[string]interface{}{"height": 1001}, nil
+		case hex.EncodeToString(reorged):
+			// Reorged away, but still known to the node.
+			return map[string]interface{}{"height": 1002, "confirmations": -1}, nil
+		}
+		return nil, &btcjson.RPCError{Code: -5, Message: "Block not found"}
+	})
+	newStreamer := func(opts Options, hashIndex bool) *SqlStreamer {
+		s := newTestStreamer(t, zcashd, opts)
+		if hashIndex {
+			s.cache.EnableHashIndex()
+		}
+		var prevHash []byte
+		for height := 1000; height <= 1003; height++ {
+			if err, _ := s.cache.Add(height, &walletrpc.CompactBlock{Height: uint64(height), Hash: hash(height), PrevHash: prevHash}); err != nil {
+				t.Fatal(err)
+			}
+			prevHash = hash(height)
+		}
+		return s
+	}
+
+	// Found in the cache's hash index, without asking the node.
+	s := newStreamer(Options{}, true)
+	block, err := s.GetBlock(ctx, &walletrpc.BlockID{Hash: hash(1002)})
+	if err != nil || block.Height != 1002 || zcashd.count("getblockheader") != 0 {
+		t.Fatalf("expected block 1002 from the cache, got %v, %v", block, err)
+	}
+
+	// Otherwise the node gives the height.
+	s = newStreamer(Options{}, false)
+	block, err = s.GetBlock(ctx, &walletrpc.BlockID{Hash: hash(1001)})
+	if err != nil || block.Height != 1001 || zcashd.count("getblockheader") != 1 {
+		t.Fatalf("expected block 1001 by way of the node, got %v, %v", block, err)
+	}
+
+	for _, tt := range []struct {
+		name string
+		hash []byte
+		code codes.Code
+	}{
+		{"reorged away", reorged, codes.NotFound},
+		{"unknown", hash(7), codes.NotFound},
+		{"short", []byte{1, 2, 3}, codes.InvalidArgument},
+	} {
+		if _, err := s.GetBlock(ctx, &walletrpc.BlockID{Hash: tt.hash}); status.Code(err) != tt.code {
+			t.Errorf("%s hash: expected %v, got %v", tt.name, tt.code, err)
+		}
+	}
+
+	// The cache-only strategy doesn't ask the node.
+	s = newStreamer(Options{LookupStrategies: map[string]common.LookupStrategy{"GetBlock": common.CacheOnly}}, false)
+	calls := zcashd.count("getblockheader")
+	if _, err := s.GetBlock(ctx, &walletrpc.BlockID{Hash: hash(1001)}); status.Code(err) != codes.NotFound || zcashd.count("getblockheader") != calls {
+		t.Errorf("expected NotFound from the cache alone, got %v", err)
+	}
+}
+
+func TestGetBlockCompactFormatVersions(t *testing.T) {
+	zcashd := newFakeZcashd()
+	zcashd.handle("getblock", func(params []json.RawMessage) (interface{}, error) {
+		return "deadbeef", nil
+	})
+	s := newTestStreamer(t, zcashd, Options{MaxFullBlockRequests: 1})
+	fillCache(t, s, 1000, 1010)
+	withFormat := func(version string) context.Context {
+		return metadata.NewIncomingContext(context.Background(), metadata.Pairs(compactFormatHeader, version))
+	}
+	id := &walletrpc.BlockID{Height: 1005, IncludeFull: true}
+
+	latest, err := s.GetBlock(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if latest.ProtoVersion != compactFormatV2 || latest.FullBlock == nil {
+		t.Errorf("expected the latest format by default, got %v", latest)
+	}
+
+	v2, err := s.GetBlock(withFormat("2"), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v1, err := s.GetBlock(withFormat("1"), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v2.ProtoVersion != 2 || hex.EncodeToString(v2.FullBlock) != "deadbeef" {
+		t.Errorf("unexpected version 2 block %v", v2)
+	}
+	if v1.ProtoVersion != 1 || v1.FullBlock != nil {
+		t.Errorf("unexpected version 1 block %v", v1)
+	}
+	if v1.Height != v2.Height || !bytes.Equal(v1.Hash, v2.Hash) || len(v1.Vtx) != len(v2.Vtx) {
+		t.Error("the two versions describe different blocks")
+	}
+
+	for _, version := range []string{"0", "3", "latest"} {
+		if _, err := s.GetBlock(withFormat(version), id); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("version %s: expected InvalidArgument, got %v", version, err)
+		}
+	}
+}
+
+func TestProtocolVersion(t *testing.T) {
+	zcashd := newFakeZcashd()
+	zcashd.handle("getblock", func(params []json.RawMessage) (interface{}, error) {
+		return "deadbeef", nil
+	})
+	s := newTestStreamer(t, zcashd, Options{MaxFullBlockRequests: 1})
+	fillCache(t, s, 1000, 1010)
+	withHeaders := func(kv ...string) context.Context {
+		return metadata.NewIncomingContext(context.Background(), metadata.Pairs(kv...))
+	}
+	id := &walletrpc.BlockID{Height: 1005, IncludeFull: true}
+
+	for _, tt := range []struct {
+		headers []string
+		format  uint32
+	}{
+		{[]string{protocolVersionHeader, "1"}, compactFormatV1},
+		{[]string{protocolVersionHeader, "2"}, compactFormatV2},
+		// A newer client gets the server's version.
+		{[]string{protocolVersionHeader, "9"}, compactFormatV2},
+		// An explicit format wins.
+		{[]string{protocolVersionHeader, "1", compactFormatHeader, "2"}, compactFormatV2},
+	} {
+		block, err := s.GetBlock(withHeaders(tt.headers...), id)
+		if err != nil {
+			t.Fatalf("%v: %v", tt.headers, err)
+		}
+		if block.ProtoVersion != tt.format || (block.FullBlock != nil) != (tt.format >= compactFormatV2) {
+			t.Errorf("%v: unexpected block %v", tt.headers, block)
+		}
+	}
+
+	for _, version := range []string{"0", "v2"} {
+		if _, err := s.GetBlock(withHeaders(protocolVersionHeader, version), id); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("version %s: expected InvalidArgument, got %v", version, err)
+		}
+	}
+
+	zcashd.handle("getblockchaininfo", chainInfoHandler(false, 1))
+	info, err := s.GetLightdInfo(context.Background(), &walletrpc.Empty{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.ProtocolVersion != ProtocolVersion || info.MinProtocolVersion != protocolV1 {
+		t.Errorf("unexpected protocol versions %d to %d", info.MinProtocolVersion, info.ProtocolVersion)
+	}
+}
+
+func TestLookupStrategies(t *testing.T) {
+	ctx := context.Background()
+	for _, tt := range []struct {
+		strategy    string
+		cachedCalls int // getblock calls for a block in the cache
+		olderCalls  int // and for one older than the cache
+		olderFound  bool
+		rangeCalls  int // for 289460 to 289465, of which 289463 up are cached
+		rangeErr    bool
+	}{
+		{"cache-first", 0, 1, true, 3, false},
+		{"cache-only", 0, 0, false, 0, true},
+		{"node-only", 1, 1, true, 6, false},
+	} {
+		strategy, err := common.ParseLookupStrategy(tt.strategy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		newStreamer := func(method string) (*SqlStreamer, *fakeZcashd) {
+			zcashd := newFakeZcashd()
+			s := newTestStreamer(t, zcashd, Options{LookupStrategies: map[string]common.LookupStrategy{method: strategy}})
+			fixtureBlocks(t, s, zcashd)
+			s.cache.SetMaxEntries(3)
+			return s, zcashd
+		}
+
+		s, zcashd := newStreamer("GetBlock")
+		if block, err := s.GetBlock(ctx, &walletrpc.BlockID{Height: 289465}); err != nil || block.Height != 289465 {
+			t.Errorf("%s: cached block not served: %v", tt.strategy, err)
+		}
+		if calls := zcashd.count("getblock"); calls != tt.cachedCalls {
+			t.Errorf("%s: %d getblock calls for a cached block, expected %d", tt.strategy, calls, tt.cachedCalls)
+		}
+		block, err := s.GetBlock(ctx, &walletrpc.BlockID{Height: 289461})
+		if found := err == nil && block.Height == 289461; found != tt.olderFound {
+			t.Errorf("%s: older block found %v, expected %v (%v)", tt.strategy, found, tt.olderFound, err)
+		}
+		if calls := zcashd.count("getblock") - tt.cachedCalls; calls != tt.olderCalls {
+			t.Errorf("%s: %d getblock calls for an older block, expected %d", tt.strategy, calls, tt.olderCalls)
+		}
+
+		s, zcashd = newStreamer("GetBlockRange")
+		err = s.GetBlockRange(blockRange(289460, 289465), &testRangeStream{ctx: ctx})
+		if (err != nil) != tt.rangeErr {
+			t.Errorf("%s: GetBlockRange returned %v", tt.strategy, err)
+		}
+		if calls := zcashd.count("getblock"); calls != tt.rangeCalls {
+			t.Errorf("%s: %d getblock calls for a range, expected %d", tt.strategy, calls, tt.rangeCalls)
+		}
+
+		s, zcashd = newStreamer("GetBlockHeaders")
+		err = s.GetBlockHeaders(blockRange(289460, 289465), &testHeaderStream{})
+		if (err != nil) != tt.rangeErr {
+			t.Errorf("%s: GetBlockHeaders returned %v", tt.strategy, err)
+		}
+		if calls := zcashd.count("getblock"); calls != tt.rangeCalls {
+			t.Errorf("%s: %d getblock calls for headers, expected %d", tt.strategy, calls, tt.rangeCalls)
+		}
+
+		s, zcashd = newStreamer("GetBlockRangeNullifiers")
+		err = s.GetBlockRangeNullifiers(blockRange(289460, 289465), &testRangeStream{ctx: ctx})
+		if (err != nil) != tt.rangeErr {
+			t.Errorf("%s: GetBlockRangeNullifiers returned %v", tt.strategy, err)
+		}
+		if calls := zcashd.count("getblock"); calls != tt.rangeCalls {
+			t.Errorf("%s: %d getblock calls for nullifiers, expected %d", tt.strategy, calls, tt.rangeCalls)
+		}
+
+		s, zcashd = newStreamer("GetLatestBlock")
+		zcashd.handle("getblockchaininfo", func(params []json.RawMessage) (interface{}, error) {
+			return map[string]interface{}{"blocks": 289466}, nil
+		})
+		latest, err := s.GetLatestBlock(ctx, &walletrpc.ChainSpec{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tt.strategy == "node-only" {
+			if latest.Height != 289466 {
+				t.Errorf("%s: latest block %d, expected the node's", tt.strategy, latest.Height)
+			}
+		} else if latest.Height != 289465 || zcashd.count("getblockchaininfo") != 0 {
+			t.Errorf("%s: latest block %d, expected the cache's", tt.strategy, latest.Height)
+		}
+	}
+
+	if _, err := NewSQLiteStreamer(newFakeZcashd(), nil, nil, nil, Options{
+		LookupStrategies: map[string]common.LookupStrategy{"SendTransaction": common.NodeOnly},
+	}); err == nil {
+		t.Error("expected a lookup strategy for SendTransaction to be rejected")
+	}
+	for _, method := range []string{"GetLatestBlock", "GetBlock", "GetBlockRange", "GetBlockHeaders", "GetBlockRangeNullifiers"} {
+		if _, err := NewSQLiteStreamer(newFakeZcashd(), nil, nil, nil, Options{
+			LookupStrategies: map[string]common.LookupStrategy{method: common.NodeOnly},
+		}); err != nil {
+			t.Errorf("lookup strategy for %s rejected: %v", method, err)
+		}
+	}
+}
+
+// fixtureBlocks returns the testnet blocks in testdata/compact_blocks.json by
+// height, serves them from zcashd's getblock, and adds them to the cache.
+func fixtureBlocks(t *testing.T, s *SqlStreamer, zcashd *fakeZcashd) map[int]*parser.Block {
+	var fixtures []struct {
+		Height int    `json:"block"`
+		Full   string `json:"full"`
+	}
+	data, err := ioutil.ReadFile("../testdata/compact_blocks.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, &fixtures); err != nil {
+		t.Fatal(err)
+	}
+
+	raw := make(map[int]string)
+	blocks := make(map[int]*parser.Block)
+	for _, fixture := range fixtures {
+		blockData, _ := hex.DecodeString(fixture.Full)
+		block := parser.NewBlock()
+		if _, err := block.ParseFromSlice(blockData); err != nil {
+			t.Fatal(err)
+		}
+		// Cached the way the ingestor does, with the coinbase.
+		compact := block.ToCompact()
+		compact.Coinbase = block.Coinbase()
+		if err, _ := s.cache.Add(fixture.Height, compact); err != nil {
+			t.Fatal(err)
+		}
+		raw[fixture.Height] = fixture.Full
+		blocks[fixture.Height] = block
+	}
+
+	zcashd.handle("getblock", func(params []json.RawMessage) (interface{}, error) {
+		var height string
+		json.Unmarshal(params[0], &height)
+		h, _ := strconv.Atoi(height)
+		if block, ok := raw[h]; ok {
+			return block, nil
+		}
+		return nil, &btcjson.RPCError{Code: -8, Message: "Block height out of range"}
+	})
+	return blocks
+}
+
+func TestCompactBlockCoinbase(t *testing.T) {
+	zcashd := newFakeZcashd()
+	s := newTestStreamer(t, zcashd, Options{})
+	blocks := fixtureBlocks(t, s, zcashd)
+	ctx := context.Background()
+
+	// Left out unless asked for.
+	block, err := s.GetBlock(ctx, &walletrpc.BlockID{Height: 289463})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if block.Coinbase != nil {
+		t.Error("coinbase returned without asking")
+	}
+	block, err = s.GetBlock(ctx, &walletrpc.BlockID{Height: 289463, IncludeCoinbase: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(block.Coinbase) == 0 || !bytes.Equal(block.Coinbase, blocks[289463].Coinbase()) {
+		t.Error("expected the block's coinbase transaction")
+	}
+
+	for _, include := range []bool{false, true} {
+		span := blockRange(289460, 289465)
+		span.IncludeCoinbase = include
+		stream := &testRangeStream{ctx: ctx}
+		if err := s.GetBlockRange(span, stream); err != nil {
+			t.Fatal(err)
+		}
+		if len(stream.blocks) != 6 {
+			t.Fatalf("expected 6 blocks, got %d", len(stream.blocks))
+		}
+		for _, cBlock := range stream.blocks {
+			want := []byte(nil)
+			if include {
+				want = blocks[int(cBlock.Height)].Coinbase()
+			}
+			if !bytes.Equal(cBlock.Coinbase, want) || (cBlock.Coinbase == nil) == include {
+				t.Errorf("includeCoinbase %t: wrong coinbase at %d", include, cBlock.Height)
+			}
+		}
+	}
+}
+
+func TestGetBlockRangeCheckpoints(t *testing.T) {
+	zcashd := newFakeZcashd()
+	s := newTestStreamer(t, zcashd, Options{MinRangeCheckpointInterval: 2})
+	blocks := fixtureBlocks(t, s, zcashd)
+	ctx := context.Background()
+
+	for _, requested := range []uint64{1, 2} { // 1 is below the server's minimum
+		span := blockRange(289460, 289465)
+		span.CheckpointInterval = requested
+		stream := &testRangeStream{ctx: ctx}
+		if err := s.GetBlockRange(span, stream); err != nil {
+			t.Fatal(err)
+		}
+		if len(stream.blocks) != 6 {
+			t.Fatalf("expected 6 blocks, got %d", len(stream.blocks))
+		}
+
+		for _, cBlock := range stream.blocks {
+			if cBlock.Height%2 != 0 {
+				if cBlock.Checkpoint != nil {
+					t.Errorf("interval %d: unexpected checkpoint at %d", requested, cBlock.Height)
+				}
+				continue
+			}
+			block := blocks[int(cBlock.Height)]
+			checkpoint := cBlock.Checkpoint
+			if checkpoint == nil {
+				t.Errorf("interval %d: missing checkpoint at %d", requested, cBlock.Height)
+				continue
+			}
+			if checkpoint.Height != cBlock.Height ||
+				!bytes.Equal(checkpoint.Hash, block.GetEncodableHash()) ||
+				!bytes.Equal(checkpoint.SaplingTreeRoot, block.GetSaplingRoot()) {
+				t.Errorf("interval %d: checkpoint at %d doesn't match the block", requested, cBlock.Height)
+			}
+		}
+	}
+
+	// Plain ranges carry no checkpoints.
+	stream := &testRangeStream{ctx: ctx}
+	if err := s.GetBlockRange(blockRange(289460, 289465), stream); err != nil {
+		t.Fatal(err)
+	}
+	for _, cBlock := range stream.blocks {
+		if cBlock.Checkpoint != nil {
+			t.Errorf("unrequested checkpoint at %d", cBlock.Height)
+		}
+	}
+}
+
+func chainInfoHandler(ibd bool, progress float64) func(params []json.RawMessage) (interface{}, error) {
+	return func(params []json.RawMessage) (interface{}, error) {
+		return map[string]interface{}{
+			"chain":                "main",
+			"headers":              900000,
+			"initialblockdownload": ibd,
+			"verificationprogress": progress,
+			"upgrades": map[string]interface{}{
+				"6f76727a": map[string]interface{}{"name": "Sapling", "activationheight": 419200, "status": "active"},
+				"5ba81b19": map[string]interface{}{"name": "Overwinter", "activationheight": 347500, "status": "active"},
+			},
+			"consensus": map[string]interface{}{"nextblock": "2bb40e60"},
+		}, nil
+	}
+}
+
+func TestSendTransactionNodeNotSynced(t *testing.T) {
+	txData, _ := testTxWithInputs(t)
+
+	for _, tt := range []struct {
+		name     string
+		ibd      bool
+		progress float64
+		synced   bool
+	}{
+		{"initial block download", true, 0.9999999, false},
+		{"low verification progress", false, 0.42, false},
+		{"synced", false, 0.9999999, true},
+	} {
+		zcashd := newFakeZcashd()
+		zcashd.handle("getblockchaininfo", chainInfoHandler(tt.ibd, tt.progress))
+		zcashd.handle("sendrawtransaction", func(params []json.RawMessage) (interface{}, error) {
+			return "txid", nil
+		})
+		s := newTestStreamer(t, zcashd, Options{SendRequireSynced: true, SendMinVerificationProgress: 0.9999})
+
+		_, err := s.SendTransaction(context.Background(), &walletrpc.RawTransaction{Data: txData})
+		if tt.synced {
+			if err != nil || zcashd.count("sendrawtransaction") != 1 {
+				t.Errorf("%s: expected the transaction to be broadcast, got %v", tt.name, err)
+			}
+		} else {
+			if status.Code(err) != codes.FailedPrecondition || !strings.Contains(err.Error(), "node not synced") {
+				t.Errorf("%s: expected FailedPrecondition, got %v", tt.name, err)
+			}
+			if zcashd.count("sendrawtransaction") != 0 {
+				t.Errorf("%s: transaction broadcast through an unsynced node", tt.name)
+			}
+		}
+
+		info, err := s.GetLightdInfo(context.Background(), &walletrpc.Empty{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.SendReady != tt.synced {
+			t.Errorf("%s: GetLightdInfo advertised sendReady %v", tt.name, info.SendReady)
+		}
+	}
+}
+
+func TestSendTransactionConsensusBranch(t *testing.T) {
+	saplingTx, _ := testTxWithInputs(t)
+	// An empty Overwinter (v3) transaction, as built by a wallet that missed
+	// the Sapling upgrade.
+	overwinterTx, _ := hex.DecodeString("030000807082c4030000000000000000000000")
+
+	upgrades := map[string]interface{}{
+		"5ba81b19": map[string]interface{}{"name": "Overwinter"},
+		"6f76727a": map[string]interface{}{"name": "Sapling"},
+		"2bb40e60": map[string]interface{}{"name": "Blossom"},
+	}
+	for _, tt := range []struct {
+		name      string
+		nextBlock string
+		tx        []byte
+		broadcast bool
+	}{
+		{"current", "2bb40e60", saplingTx, true},
+		{"stale", "2bb40e60", overwinterTx, false},
+		{"not yet activated", "5ba81b19", saplingTx, false},
+		{"unknown branch", "deadbeef", overwinterTx, true},
+	} {
+		zcashd := newFakeZcashd()
+		zcashd.handle("getblockchaininfo", func(params []json.RawMessage) (interface{}, error) {
+			return map[string]interface{}{
+				"upgrades":  upgrades,
+				"consensus": map[string]interface{}{"nextblock": tt.nextBlock},
+			}, nil
+		})
+		zcashd.handle("sendrawtransaction", func(params []json.RawMessage) (interface{}, error) {
+			return "txid", nil
+		})
+		s := newTestStreamer(t, zcashd, Options{SendCheckBranch: true})
+
+		_, err := s.SendTransaction(context.Background(), &walletrpc.RawTransaction{Data: tt.tx})
+		if tt.broadcast {
+			if err != nil || zcashd.count("sendrawtransaction") != 1 {
+				t.Errorf("%s: expected the transaction to be broadcast, got %v", tt.name, err)
+			}
+			continue
+		}
+		if status.Code(err) != codes.FailedPrecondition || !strings.Contains(err.Error(), tt.nextBlock) {
+			t.Errorf("%s: expected FailedPrecondition naming the branch, got %v", tt.name, err)
+		}
+		if zcashd.count("sendrawtransaction") != 0 {
+			t.Errorf("%s: transaction broadcast on the wrong branch", tt.name)
+		}
+	}
+}
+
+func TestSendTransactionDedup(t *testing.T) {
+	txData, _ := testTxWithInputs(t)
+	ctx := context.Background()
+	zcashd := newFakeZcashd()
+	rejected := true
+	zcashd.handle("sendrawtransaction", func(params []json.RawMessage) (interface{}, error) {
+		if rejected {
+			return nil, &btcjson.RPCError{Code: -26, Message: "tx-expiring-soon"}
+		}
+		return "txid", nil
+	})
+	s := newTestStreamer(t, zcashd, Options{SendDedupWindow: 100 * time.Millisecond, SendDedupMax: 10})
+
+	// Failures aren't remembered.
+	for i := 0; i < 2; i++ {
+		if resp, err := s.SendTransaction(ctx, &walletrpc.RawTransaction{Data: txData}); err != nil || resp.ErrorCode != -26 {
+			t.Fatalf("expected the node's rejection, got %v, %v", resp, err)
+		}
+	}
+	if zcashd.count("sendrawtransaction") != 2 {
+		t.Fatal("rejected transaction was deduplicated")
+	}
+
+	// A successful broadcast is, for the window.
+	rejected = false
+	first, err := s.SendTransaction(ctx, &walletrpc.RawTransaction{Data: txData})
+	if err != nil || first.ErrorCode != 0 {
+		t.Fatalf("expected a successful broadcast, got %v, %v", first, err)
+	}
+	for i := 1; i < 3; i++ {
+		resp, err := s.SendTransaction(ctx, &walletrpc.RawTransaction{Data: txData})
+		if err != nil || resp.ErrorCode != 0 || resp.ErrorMessage != first.ErrorMessage {
+			t.Fatalf("submission %d: unexpected response %v, %v", i, resp, err)
+		}
+	}
+	if calls := zcashd.count("sendrawtransaction"); calls != 3 {
+		t.Errorf("duplicates reached the node, %d calls", calls-2)
+	}
+	if hits := testutil.ToFloat64(s.metrics.SendTransactionDedupHits); hits != 2 {
+		t.Errorf("counted %v dedup hits, expected 2", hits)
+	}
+
+	time.Sleep(150 * time.Millisecond)
+	if _, err := s.SendTransaction(ctx, &walletrpc.RawTransaction{Data: txData}); err != nil {
+		t.Fatal(err)
+	}
+	if zcashd.count("sendrawtransaction") != 4 {
+		t.Error("transaction still deduplicated after the window")
+	}
+}
+
+func TestSendDedupBounded(t *testing.T) {
+	d := newSendDedup(time.Minute, 2)
+	for i := byte(0); i < 3; i++ {
+		d.add([sha256.Size]byte{i}, &walletrpc.SendResponse{ErrorMessage: fmt.Sprint(i)})
+	}
+	if len(d.sent) != 2 || d.get([sha256.Size]byte{0}) != nil || d.get([sha256.Size]byte{2}) == nil {
+		t.Errorf("expected the oldest of 3 transactions to be dropped, have %v", d.sent)
+	}
+}
+
+func TestGetLightdInfoNodeDown(t *testing.T) {
+	zcashd := newFakeZcashd()
+	zcashd.handle("getblockchaininfo", chainInfoHandler(false, 0.9999999))
+	zcashd.handle("getnetworkinfo", func(params []json.RawMessage) (interface{}, error) {
+		return map[string]interface{}{"version": 2010050, "subversion": "/MagicBean:2.1.0/"}, nil
+	})
+	zcashd.handle("getmempoolinfo", func(params []json.RawMessage) (interface{}, error) {
+		return map[string]interface{}{"size": 3}, nil
+	})
+	logger := logrus.New()
+	logger.SetOutput(ioutil.Discard)
+	nodeStatus := common.NewNodeStatusCache(zcashd, logger.WithField("app", "test"))
+	s := newTestStreamer(t, zcashd, Options{
+		NodeStatus:                  nodeStatus,
+		SendMinVerificationProgress: 0.9999,
+		GitCommit:                   "abc1234",
+		BuildDate:                   "2020-06-01",
+		DonationAddress:             "zs1donate",
+		OperatorName:                "Example Org",
+		PrivacyPolicyURL:            "https://example.com/privacy",
+	})
+
+	if _, err := s.GetLightdInfo(context.Background(), &walletrpc.Empty{}); err == nil {
+		t.Error("expected an error before the node's status is known")
+	}
+
+	if err := nodeStatus.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	info, err := s.GetLightdInfo(context.Background(), &walletrpc.Empty{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.NodeReachable || !info.SendReady || info.NodeVersion != 2010050 || info.NodeSubversion != "/MagicBean:2.1.0/" || info.MempoolSize != 3 {
+		t.Errorf("unexpected info with the node up: %v", info)
+	}
+	if info.GitCommit != "abc1234" || info.BuildDate != "2020-06-01" || info.DonationAddress != "zs1donate" ||
+		info.OperatorName != "Example Org" || info.PrivacyPolicyUrl != "https://example.com/privacy" ||
+		!info.TaddrSupport || info.ConsensusBranchId != "2bb40e60" {
+		t.Errorf("unexpected server fields: %v", info)
+	}
+	if len(info.Upgrades) != 2 || info.Upgrades[0].Name != "Overwinter" || info.Upgrades[1].BranchId != "6f76727a" ||
+		info.Upgrades[1].ActivationHeight != 419200 || info.Upgrades[1].Status != "active" {
+		t.Errorf("expected Overwinter then Sapling, got %v", info.Upgrades)
+	}
+	// The node doesn't estimate the network's height.
+	if info.EstimatedHeight != 900000 {
+		t.Errorf("estimated height %d, expected the node's 900000", info.EstimatedHeight)
+	}
+	if zcashd.count("getblockchaininfo") != 1 {
+		t.Error("GetLightdInfo contacted the node")
+	}
+
+	down := func(params []json.RawMessage) (interface{}, error) {
+		return nil, errors.New("connection refused")
+	}
+	for _, method := range []string{"getblockchaininfo", "getnetworkinfo", "getmempoolinfo"} {
+		zcashd.handle(method, down)
+	}
+	if err := nodeStatus.Refresh(); err == nil {
+		t.Fatal("expected the refresh to fail with the node down")
+	}
+	info, err = s.GetLightdInfo(context.Background(), &walletrpc.Empty{})
+	if err != nil {
+		t.Fatalf("GetLightdInfo failed with the node down: %v", err)
+	}
+	if info.NodeReachable || info.SendReady || info.ChainName != "main" || info.SaplingActivationHeight != 419200 {
+		t.Errorf("unexpected info with the node down: %v", info)
+	}
+	if info.NodeVersion != 0 || info.NodeSubversion != "" || info.MempoolSize != 0 {
+		t.Errorf("stale node fields reported: %v", info)
+	}
+
+	s.opts.LightdInfoStaleNodeFields = true
+	info, err = s.GetLightdInfo(context.Background(), &walletrpc.Empty{})
+	if err != nil || info.NodeSubversion != "/MagicBean:2.1.0/" || info.MempoolSize != 3 {
+		t.Errorf("expected the last known node fields, got %v, %v", info, err)
+	}
+}
+
+func TestGetConsensusBranch(t *testing.T) {
+	ctx := context.Background()
+	zcashd := newFakeZcashd()
+	zcashd.handle("getblockchaininfo", chainInfoHandler(false, 1))
+	s := newTestStreamer(t, zcashd, Options{})
+
+	for _, tt := range []struct {
+		height   uint64
+		branchID string
+		name     string
+	}{
+		{1, "00000000", ""},
+		{347500, "5ba81b19", "Overwinter"},
+		{1000000, "6f76727a", "Sapling"},
+	} {
+		branch, err := s.GetConsensusBranch(ctx, &walletrpc.BlockID{Height: tt.height})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if branch.Height != tt.height || branch.BranchId != tt.branchID || branch.UpgradeName != tt.name {
+			t.Errorf("height %d: got %v, expected %s (%s)", tt.height, branch, tt.branchID, tt.name)
+		}
+	}
+	if calls := zcashd.count("getblockchaininfo"); calls != 1 {
+		t.Errorf("%d getblockchaininfo calls, expected the upgrades to be asked for once", calls)
+	}
+
+	// The last known upgrades outlive the node.
+	s.upgradesFetched = time.Now().Add(-2 * upgradeTableTTL)
+	zcashd.handle("getblockchaininfo", func(params []json.RawMessage) (interface{}, error) {
+		return nil, errors.New("connection refused")
+	})
+	if branch, err := s.GetConsensusBranch(ctx, &walletrpc.BlockID{Height: 1000000}); err != nil || branch.BranchId != "6f76727a" {
+		t.Errorf("expected the last known upgrades, got %v, %v", branch, err)
+	}
+
+	s = newTestStreamer(t, zcashd, Options{})
+	if _, err := s.GetConsensusBranch(ctx, &walletrpc.BlockID{Height: 1}); status.Code(err) != codes.Unavailable {
+		t.Errorf("expected Unavailable without the node, got %v", err)
+	}
+}
+
+func TestPing(t *testing.T) {
+	ctx := context.Background()
+	zcashd := newFakeZcashd()
+	s := newTestStreamer(t, zcashd, Options{})
+
+	before := time.Now().UnixNano()
+	resp, err := s.Ping(ctx, &walletrpc.PingRequest{Payload: []byte("hello")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(resp.Payload) != "hello" || resp.ReceivedAt < before || resp.SentAt < resp.ReceivedAt || resp.LatestBlock != 0 {
+		t.Errorf("unexpected response from an empty cache: %v", resp)
+	}
+
+	if err, _ := s.cache.Add(1000, &walletrpc.CompactBlock{Height: 1000, Hash: []byte("hash-1000"), Time: 1600000000}); err != nil {
+		t.Fatal(err)
+	}
+	resp, err = s.Ping(ctx, &walletrpc.PingRequest{})
+	if err != nil || resp.LatestBlock != 1000 || resp.LatestBlockTime != 1600000000 {
+		t.Errorf("expected the latest block, got %v, %v", resp, err)
+	}
+	if len(zcashd.calls) != 0 {
+		t.Errorf("Ping called the node: %v", zcashd.calls)
+	}
+
+	if _, err := s.Ping(ctx, &walletrpc.PingRequest{Payload: make([]byte, maxPingPayload+1)}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected InvalidArgument for a large payload, got %v", err)
+	}
+}
+
+func TestGetServerNotices(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "lightwalletd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := dir + "/notices.json"
+	content := `[
+		{"id": "past", "message": "Over", "until": "2020-01-01T00:00:00Z"},
+		{"id": "move", "level": "warning", "message": "Moving", "url": "https://example.com", "from": "2020-01-01T00:00:00Z"}
+	]`
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	notices, err := common.NewNoticeFile(path, logrus.NewEntry(logrus.New()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	zcashd := newFakeZcashd()
+	zcashd.handle("getblockchaininfo", chainInfoHandler(false, 1))
+	s := newTestStreamer(t, zcashd, Options{Notices: notices})
+	resp, err := s.GetServerNotices(ctx, &walletrpc.Empty{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Notices) != 1 {
+		t.Fatalf("expected only the current notice, got %v", resp.Notices)
+	}
+	if n := resp.Notices[0]; n.Id != "move" || n.Level != "warning" || n.Url != "https://example.com" || n.From != 1577836800 || n.Until != 0 {
+		t.Errorf("unexpected notice %v", n)
+	}
+
+	info, err := s.GetLightdInfo(ctx, &walletrpc.Empty{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(info.Notices) != 1 || info.Notices[0].Id != "move" {
+		t.Errorf("unexpected notices in LightdInfo %v", info.Notices)
+	}
+
+	s = newTestStreamer(t, zcashd, Options{})
+	if resp, err := s.GetServerNotices(ctx, &walletrpc.Empty{}); err != nil || len(resp.Notices) != 0 {
+		t.Errorf("expected no notices without a file, got %v, %v", resp, err)
+	}
+}
+
+func TestGetValuePools(t *testing.T) {
+	ctx := context.Background()
+	zcashd := newFakeZcashd()
+	height := 1005
+	zcashd.handle("getblockchaininfo", func(params []json.RawMessage) (interface{}, error) {
+		return map[string]interface{}{
+			"blocks": height,
+			"valuePools": []interface{}{
+				map[string]interface{}{"id": "transparent", "monitored": true, "chainValueZat": 1000},
+				map[string]interface{}{"id": "sprout", "monitored": true, "chainValueZat": 200},
+				map[string]interface{}{"id": "sapling", "monitored": true, "chainValueZat": 30 + height},
+			},
+		}, nil
+	})
+	s := newTestStreamer(t, zcashd, Options{})
+	fillCache(t, s, 1000, 1005)
+
+	resp, err := s.GetValuePools(ctx, &walletrpc.Empty{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Height != 1005 || len(resp.Pools) != 3 || resp.Pools[2].Id != "sapling" || resp.Pools[2].ValueZat != 1035 ||
+		!resp.SupplyKnown || resp.TotalSupplyZat != 2235 {
+		t.Errorf("unexpected value pools %v", resp)
+	}
+
+	// The answer is kept until there's a new block.
+	if _, err := s.GetValuePools(ctx, &walletrpc.Empty{}); err != nil || zcashd.count("getblockchaininfo") != 1 {
+		t.Errorf("expected the cached answer, got %v after %d calls", err, zcashd.count("getblockchaininfo"))
+	}
+	height = 1006
+	if err, _ := s.cache.Add(1006, &walletrpc.CompactBlock{Height: 1006, Hash: []byte("hash-1006"), PrevHash: []byte("hash-1005")}); err != nil {
+		t.Fatal(err)
+	}
+	resp, err = s.GetValuePools(ctx, &walletrpc.Empty{})
+	if err != nil || resp.Height != 1006 || resp.TotalSupplyZat != 2236 {
+		t.Errorf("expected the new tip's pools, got %v, %v", resp, err)
+	}
+
+	zcashd = newFakeZcashd()
+	zcashd.handle("getblockchaininfo", chainInfoHandler(false, 1))
+	s = newTestStreamer(t, zcashd, Options{})
+	if _, err := s.GetValuePools(ctx, &walletrpc.Empty{}); status.Code(err) != codes.Unimplemented {
+		t.Errorf("expected Unimplemented from a node without value pools, got %v", err)
+	}
+	s = newTestStreamer(t, newFakeZcashd(), Options{})
+	if _, err := s.GetValuePools(ctx, &walletrpc.Empty{}); status.Code(err) != codes.Unavailable {
+		t.Errorf("expected Unavailable without a node, got %v", err)
+	}
+}
+
+func TestGetTransactionMaxSize(t *testing.T) {
+	zcashd := newFakeZcashd()
+	zcashd.handle("getrawtransaction", func(params []json.RawMessage) (interface{}, error) {
+		if len(params) == 2 {
+			return map[string]interface{}{"height": 289460}, nil
+		}
+		return strings.Repeat("ab", 200), nil
+	})
+	hash := make([]byte, 32)
+
+	s := newTestStreamer(t, zcashd, Options{MaxTransactionSize: 100})
+	_, err := s.GetTransaction(context.Background(), &walletrpc.TxFilter{Hash: hash})
+	if status.Code(err) != codes.OutOfRange || !strings.Contains(err.Error(), "200 bytes") {
+		t.Errorf("expected OutOfRange for a 200 byte transaction, got %v", err)
+	}
+	if zcashd.count("getrawtransaction") != 1 {
+		t.Error("looked up the height of a transaction too large to return")
+	}
+
+	s = newTestStreamer(t, zcashd, Options{MaxTransactionSize: 200})
+	tx, err := s.GetTransaction(context.Background(), &walletrpc.TxFilter{Hash: hash})
+	if err != nil || len(tx.Data) != 200 || tx.Height != 289460 {
+		t.Errorf("expected the 200 byte transaction at 289460, got %v, %v", tx, err)
+	}
+}
+
+func TestGetTransactionMemoryBudget(t *testing.T) {
+	zcashd := newFakeZcashd()
+	zcashd.handle("getrawtransaction", func(params []json.RawMessage) (interface{}, error) {
+		if len(params) == 2 {
+			return map[string]interface{}{"height": 289460}, nil
+		}
+		return strings.Repeat("ab", 200), nil
+	})
+	s := newTestStreamer(t, zcashd, Options{})
+
+	memory := common.NewRequestMemory(100)
+	ctx := common.WithRequestMemory(context.Background(), memory)
+	if _, err := s.GetTransaction(ctx, &walletrpc.TxFilter{Hash: make([]byte, 32)}); err == nil || !memory.Exceeded() {
+		t.Errorf("expected a 200 byte transaction to exceed a 100 byte budget, got %v", err)
+	}
+	if zcashd.count("getrawtransaction") != 1 {
+		t.Error("carried on with a call over its budget")
+	}
+}
+
+func TestGetBlockRangeBelowFloor(t *testing.T) {
+	zcashd := newFakeZcashd()
+	s := newTestStreamer(t, zcashd, Options{LookupStrategies: map[string]common.LookupStrategy{"GetBlockRange": common.CacheOnly}})
+	fixtureBlocks(t, s, zcashd)
+	s.cache.SetMaxEntries(3)
+
+	stream := &testRangeStream{ctx: context.Background()}
+	err := s.GetBlockRange(blockRange(289461, 289465), stream)
+	if status.Code(err) != codes.OutOfRange || !strings.Contains(err.Error(), "289463") {
+		t.Fatalf("expected OutOfRange naming 289463, got %v", err)
+	}
+	if len(stream.blocks) != 0 {
+		t.Errorf("%d blocks streamed before the error", len(stream.blocks))
+	}
+	var lowest string
+	for _, detail := range status.Convert(err).Details() {
+		if badRequest, ok := detail.(*errdetails.BadRequest); ok {
+			for _, violation := range badRequest.FieldViolations {
+				if violation.Field == "start.height" {
+					lowest = violation.Description
+				}
+			}
+		}
+	}
+	if lowest != "lowest available height is 289463" {
+		t.Errorf("expected the lowest height in the error details, got %q", lowest)
+	}
+
+	// From the floor up, the range is served.
+	stream = &testRangeStream{ctx: context.Background()}
+	if err := s.GetBlockRange(blockRange(289463, 289465), stream); err != nil || len(stream.blocks) != 3 {
+		t.Errorf("expected 3 blocks from the floor, got %d, %v", len(stream.blocks), err)
+	}
+
+	// Strategies that can ask the node have no floor.
+	s.opts.LookupStrategies = nil
+	stream = &testRangeStream{ctx: context.Background()}
+	if err := s.GetBlockRange(blockRange(289461, 289465), stream); err != nil || len(stream.blocks) != 5 {
+		t.Errorf("expected 5 blocks with cache-first, got %d, %v", len(stream.blocks), err)
+	}
+}
+
+func TestGetBlockRangeDescending(t *testing.T) {
+	zcashd := newFakeZcashd()
+	s := newTestStreamer(t, zcashd, Options{FollowBlockRange: true})
+	fillCache(t, s, 1000, 1010)
+
+	stream := &testRangeStream{ctx: context.Background()}
+	if err := s.GetBlockRange(blockRange(1005, 1002), stream); err != nil {
+		t.Fatal(err)
+	}
+	var heights []uint64
+	for _, block := range stream.blocks {
+		heights = append(heights, block.Height)
+	}
+	if fmt.Sprint(heights) != "[1005 1004 1003 1002]" {
+		t.Errorf("expected 1005 down to 1002, got %v", heights)
+	}
+
+	// Following ranges ignore the end, and go up.
+	span := blockRange(1005, 1002)
+	span.Follow = true
+	ctx, cancel := context.WithCancel(context.Background())
+	stream = &testRangeStream{ctx: ctx}
+	done := make(chan error)
+	go func() { done <- s.GetBlockRange(span, stream) }()
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Fatalf("expected the canceled stream to end, got %v", err)
+	}
+	if len(stream.blocks) != 6 || stream.blocks[0].Height != 1005 {
+		t.Errorf("expected 1005 up to 1010, got %d blocks", len(stream.blocks))
+	}
+
+	// The floor applies to the end of a descending range.
+	s.opts.LookupStrategies = map[string]common.LookupStrategy{"GetBlockRange": common.CacheOnly}
+	stream = &testRangeStream{ctx: context.Background()}
+	err := s.GetBlockRange(blockRange(1005, 990), stream)
+	if status.Code(err) != codes.OutOfRange || len(stream.blocks) != 0 {
+		t.Errorf("expected OutOfRange before any block, got %v after %d blocks", err, len(stream.blocks))
+	}
+}
+
+func TestGetBlockRangeSkipEmpty(t *testing.T) {
+	s := newTestStreamer(t, newFakeZcashd(), Options{EmptyBlockHeartbeat: 3})
+	var prevHash []byte
+	for height := 1000; height <= 1012; height++ {
+		block := &walletrpc.CompactBlock{Height: uint64(height), Hash: []byte(fmt.Sprintf("hash-%d", height)), PrevHash: prevHash}
+		if height == 1002 || height == 1009 {
+			block.Vtx = []*walletrpc.CompactTx{{Index: 1}}
+		}
+		if err, _ := s.cache.Add(height, block); err != nil {
+			t.Fatal(err)
+		}
+		prevHash = block.Hash
+	}
+
+	streamed := func(span *walletrpc.BlockRange) string {
+		stream := &testRangeStream{ctx: context.Background()}
+		if err := s.GetBlockRange(span, stream); err != nil {
+			t.Fatal(err)
+		}
+		var heights []uint64
+		for _, block := range stream.blocks {
+			heights = append(heights, block.Height)
+		}
+		return fmt.Sprint(heights)
+	}
+
+	span := blockRange(1000, 1012)
+	if got := streamed(span); got != "[1000 1001 1002 1003 1004 1005 1006 1007 1008 1009 1010 1011 1012]" {
+		t.Errorf("expected every block by default, got %v", got)
+	}
+	// Blocks with transactions, a heartbeat after three empty ones, and
+	// the end of the range.
+	span.SkipEmpty = true
+	if got := streamed(span); got != "[1002 1006 1009 1012]" {
+		t.Errorf("expected heartbeats among the blocks with transactions, got %v", got)
+	}
+	s.opts.EmptyBlockHeartbeat = 0
+	if got := streamed(span); got != "[1002 1009 1012]" {
+		t.Errorf("expected only the blocks with transactions and the last, got %v", got)
+	}
+}
+
+type testHeaderStream struct {
+	grpc.ServerStream
+	headers []*walletrpc.BlockHeader
+}
+
+func (s *testHeaderStream) Context() context.Context {
+	return context.Background()
+}
+
+func (s *testHeaderStream) Send(header *walletrpc.BlockHeader) error {
+	s.headers = append(s.headers, header)
+	return nil
+}
+
+func TestGetBlockHeaders(t *testing.T) {
+	s := newTestStreamer(t, newFakeZcashd(), Options{})
+	fillCache(t, s, 1000, 1010)
+
+	for _, span := range []*walletrpc.BlockRange{blockRange(1002, 1005), blockRange(1005, 1002)} {
+		stream := &testHeaderStream{}
+		if err := s.GetBlockHeaders(span, stream); err != nil {
+			t.Fatal(err)
+		}
+		if len(stream.headers) != 4 {
+			t.Fatalf("expected 4 headers from %d to %d, got %d", span.Start.Height, span.End.Height, len(stream.headers))
+		}
+		for i, header := range stream.headers {
+			height := span.Start.Height + uint64(i)
+			if span.End.Height < span.Start.Height {
+				height = span.Start.Height - uint64(i)
+			}
+			if header.Height != height || string(header.Hash) != fmt.Sprintf("hash-%d", height) ||
+				string(header.PrevHash) != fmt.Sprintf("hash-%d", height-1) {
+				t.Errorf("header %d: got %v, expected block %d", i, header, height)
+			}
+		}
+	}
+
+	if err := s.GetBlockHeaders(&walletrpc.BlockRange{Start: &walletrpc.BlockID{Height: 1000}}, &testHeaderStream{}); err != ErrUnspecified {
+		t.Errorf("expected a range without an end to be refused, got %v", err)
+	}
+}
+
+// errClientGone is what a Send to a client that went away returns.
+var errClientGone = errors.New("client gone")
+
+// goneHeaderStream is a GetBlockHeaders stream whose client went away.
+type goneHeaderStream struct {
+	testHeaderStream
+}
+
+func (s *goneHeaderStream) Send(header *walletrpc.BlockHeader) error {
+	return errClientGone
+}
+
+// waitForGoroutines fails t unless the goroutines left running drop back
+// to before within a second.
+func waitForGoroutines(t *testing.T, before int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d goroutines left running", runtime.NumGoroutine()-before)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestGetBlockHeadersClientGone(t *testing.T) {
+	s := newTestStreamer(t, newFakeZcashd(), Options{})
+	fillCache(t, s, 1000, 1010)
+
+	before := runtime.NumGoroutine()
+	if err := s.GetBlockHeaders(blockRange(1000, 1010), &goneHeaderStream{}); err != errClientGone {
+		t.Fatalf("expected the Send error, got %v", err)
+	}
+	// The lookups stop with the call.
+	waitForGoroutines(t, before)
+}
+
+func TestGetBlockRangeNullifiers(t *testing.T) {
+	s := newTestStreamer(t, newFakeZcashd(), Options{})
+	fillCache(t, s, 1000, 1002)
+	spend := &walletrpc.CompactSpend{Nf: []byte("nullifier")}
+	output := &walletrpc.CompactOutput{Cmu: []byte("cmu"), Epk: []byte("epk"), Ciphertext: []byte("ciphertext")}
+	if err, _ := s.cache.Add(1003, &walletrpc.CompactBlock{
+		Height:   1003,
+		Hash:     []byte("hash-1003"),
+		PrevHash: []byte("hash-1002"),
+		Vtx: []*walletrpc.CompactTx{
+			{Index: 1, Hash: []byte("received"), Outputs: []*walletrpc.CompactOutput{output}},
+			{Index: 2, Hash: []byte("spent"), Spends: []*walletrpc.CompactSpend{spend}, Outputs: []*walletrpc.CompactOutput{output}},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	stream := &testRangeStream{ctx: context.Background()}
+	if err := s.GetBlockRangeNullifiers(blockRange(1003, 1001), stream); err != nil {
+		t.Fatal(err)
+	}
+	if len(stream.blocks) != 3 || stream.blocks[0].Height != 1003 || stream.blocks[2].Height != 1001 {
+		t.Fatalf("expected blocks 1003 down to 1001, got %v", stream.blocks)
+	}
+	vtx := stream.blocks[0].Vtx
+	if len(vtx) != 1 || vtx[0].Index != 2 || string(vtx[0].Hash) != "spent" ||
+		len(vtx[0].Spends) != 1 || len(vtx[0].Outputs) != 0 {
+		t.Errorf("expected only the spend of transaction 2, got %v", vtx)
+	}
+
+	// The cached block keeps its outputs.
+	if cached := s.cache.Get(1003); cached == nil || len(cached.Vtx) != 2 || len(cached.Vtx[1].Outputs) != 1 {
+		t.Errorf("cached block trimmed: %v", cached)
+	}
+
+	if err := s.GetBlockRangeNullifiers(&walletrpc.BlockRange{Start: &walletrpc.BlockID{Height: 1000}}, stream); err != ErrUnspecified {
+		t.Errorf("expected a range without an end to be refused, got %v", err)
+	}
+}
+
+// goneRangeStream is a block stream whose client went away.
+type goneRangeStream struct {
+	testRangeStream
+}
+
+func (s *goneRangeStream) Send(block *walletrpc.CompactBlock) error {
+	return errClientGone
+}
+
+func TestGetBlockRangeNullifiersClientGone(t *testing.T) {
+	s := newTestStreamer(t, newFakeZcashd(), Options{})
+	fillCache(t, s, 1000, 1010)
+
+	before := runtime.NumGoroutine()
+	stream := &goneRangeStream{testRangeStream{ctx: context.Background()}}
+	if err := s.GetBlockRangeNullifiers(blockRange(1000, 1010), stream); err != errClientGone {
+		t.Fatalf("expected the Send error, got %v", err)
+	}
+	// The lookups stop with the call.
+	waitForGoroutines(t, before)
+}
+
+func TestGetBlockByTime(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStreamer(t, newFakeZcashd(), Options{})
+	if _, err := s.GetBlockByTime(ctx, &walletrpc.BlockTimeArg{Time: 1600000000}); status.Code(err) != codes.NotFound {
+		t.Errorf("expected NotFound from an empty cache, got %v", err)
+	}
+
+	// Blocks every 75 seconds from 1000.
+	var prevHash []byte
+	for height := 1000; height <= 1080; height++ {
+		hash := []byte(fmt.Sprintf("hash-%d", height))
+		block := &walletrpc.CompactBlock{Height: uint64(height), Hash: hash, PrevHash: prevHash, Time: uint32(1600000000 + 75*(height-1000))}
+		if err, _ := s.cache.Add(height, block); err != nil {
+			t.Fatal(err)
+		}
+		prevHash = hash
+	}
+
+	for _, tt := range []struct {
+		time   uint32
+		height uint64
+	}{
+		{1600000000, 1000},
+		{1600000001, 1001},
+		{1600000075, 1001},
+		{1600003000, 1040},
+		{1600006000, 1080},
+	} {
+		header, err := s.GetBlockByTime(ctx, &walletrpc.BlockTimeArg{Time: tt.time})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if header.Height != tt.height || string(header.Hash) != fmt.Sprintf("hash-%d", tt.height) || header.Time < tt.time {
+			t.Errorf("time %d: got %v, expected block %d", tt.time, header, tt.height)
+		}
+	}
+
+	if _, err := s.GetBlockByTime(ctx, &walletrpc.BlockTimeArg{Time: 1599999999}); status.Code(err) != codes.OutOfRange {
+		t.Errorf("expected OutOfRange before the first cached block, got %v", err)
+	}
+	if _, err := s.GetBlockByTime(ctx, &walletrpc.BlockTimeArg{Time: 1600006001}); status.Code(err) != codes.NotFound {
+		t.Errorf("expected NotFound after the tip, got %v", err)
+	}
+}
+
+// testUpdateStream passes the updates sent on a SubscribeBlocks stream on to
+// updates.
+type testUpdateStream struct {
+	grpc.ServerStream
+	ctx     context.Context
+	updates chan *walletrpc.BlockUpdate
+}
+
+func (s *testUpdateStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *testUpdateStream) Send(update *walletrpc.BlockUpdate) error {
+	s.updates <- update
+	return nil
+}
+
+func TestSubscribeBlocks(t *testing.T) {
+	s := newTestStreamer(t, newFakeZcashd(), Options{SubscribeKeepalive: 20 * time.Millisecond})
+	fillCache(t, s, 1000, 1001)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stream := &testUpdateStream{ctx: ctx, updates: make(chan *walletrpc.BlockUpdate)}
+	done := make(chan error)
+	go func() { done <- s.SubscribeBlocks(&walletrpc.SubscribeBlocksArg{IncludeBlocks: true}, stream) }()
+
+	next := func() *walletrpc.BlockUpdate {
+		select {
+		case update := <-stream.updates:
+			return update
+		case <-time.After(time.Second):
+			t.Fatal("no update")
+			return nil
+		}
+	}
+
+	// The current tip comes first.
+	if update := next(); update.Tip == nil || update.Tip.Height != 1001 || string(update.Tip.Hash) != "hash-1001" || update.Block.Height != 1001 {
+		t.Fatalf("expected tip 1001 first, got %v", update)
+	}
+	// Nothing happens for a while.
+	if update := next(); update.Tip != nil {
+		t.Fatalf("expected a ping, got %v", update)
+	}
+
+	if err, _ := s.cache.Add(1002, &walletrpc.CompactBlock{Height: 1002, Hash: []byte("hash-1002"), PrevHash: []byte("hash-1001"), Coinbase: []byte("coinbase")}); err != nil {
+		t.Fatal(err)
+	}
+	update := next()
+	for update.Tip == nil {
+		update = next()
+	}
+	if update.Tip.Height != 1002 || update.Block == nil || update.Block.Height != 1002 || update.Block.Coinbase != nil {
+		t.Errorf("expected block 1002 without its coinbase, got %v", update)
+	}
+
+	cancel()
+	for {
+		select {
+		case err := <-done:
+			if err != context.Canceled {
+				t.Errorf("expected the stream to end with the client, got %v", err)
+			}
+			return
+		case <-stream.updates:
+			// A ping sent as the client left.
+		case <-time.After(time.Second):
+			t.Fatal("stream still open after the client left")
+		}
+	}
+}
+
+// testReorgStream passes the events sent on a SubscribeReorgs stream on to
+// events.
+type testReorgStream struct {
+	grpc.ServerStream
+	ctx    context.Context
+	events chan *walletrpc.ReorgEvent
+}
+
+func (s *testReorgStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *testReorgStream) Send(event *walletrpc.ReorgEvent) error {
+	select {
+	case s.events <- event:
+	case <-s.ctx.Done():
+	}
+	return nil
+}
+
+func TestSubscribeReorgs(t *testing.T) {
+	s := newTestStreamer(t, newFakeZcashd(), Options{SubscribeKeepalive: 20 * time.Millisecond})
+	fillCache(t, s, 1000, 1010)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream := &testReorgStream{ctx: ctx, events: make(chan *walletrpc.ReorgEvent)}
+	go s.SubscribeReorgs(&walletrpc.Empty{}, stream)
+
+	next := func() *walletrpc.ReorgEvent {
+		select {
+		case event := <-stream.events:
+			return event
+		case <-time.After(time.Second):
+			t.Fatal("no event")
+			return nil
+		}
+	}
+	// Pings come while nothing happens, which also shows the stream is
+	// subscribed.
+	if event := next(); event.NewTip != nil {
+		t.Fatalf("expected a ping, got %v", event)
+	}
+
+	if err, _ := s.cache.Add(1009, &walletrpc.CompactBlock{Height: 1009, Hash: []byte("new-1009"), PrevHash: []byte("hash-1008")}); err != nil {
+		t.Fatal(err)
+	}
+	event := next()
+	for event.NewTip == nil {
+		event = next()
+	}
+	if event.AncestorHeight != 1008 || event.NewTip.Height != 1009 || string(event.NewTip.Hash) != "new-1009" {
+		t.Errorf("expected a reorg back to 1008 and on to new-1009, got %v", event)
+	}
+}
+
+// testTxStream collects the transactions sent on a GetTaddressTxids stream.
+type testTxStream struct {
+	grpc.ServerStream
+	txs []*walletrpc.RawTransaction
+}
+
+func (s *testTxStream) Context() context.Context {
+	return context.Background()
+}
+
+func (s *testTxStream) Send(tx *walletrpc.RawTransaction) error {
+	s.txs = append(s.txs, tx)
+	return nil
+}
+
+func TestGetTaddressTxids(t *testing.T) {
+	const address = "t1XVXWCvpMgBvUaed4XDqWtgQgJSu1Ghz7F"
+	zcashd := newFakeZcashd()
+	var pages [][2]float64
+	zcashd.handle("getaddresstxids", func(params []json.RawMessage) (interface{}, error) {
+		var filter struct {
+			Addresses []string
+			Start     float64
+			End       float64
+		}
+		if err := json.Unmarshal(params[0], &filter); err != nil || len(filter.Addresses) != 1 || filter.Addresses[0] != address {
+			t.Errorf("bad getaddresstxids params %s", params[0])
+		}
+		if filter.End > 1200 {
+			return nil, &btcjson.RPCError{Code: -8, Message: "End height out of range"}
+		}
+		pages = append(pages, [2]float64{filter.Start, filter.End})
+		// One transaction per page, its txid naming the page's start.
+		return []string{fmt.Sprintf("%064x", int(filter.Start))}, nil
+	})
+	zcashd.handle("getrawtransaction", func(params []json.RawMessage) (interface{}, error) {
+		if len(params) == 2 {
+			return map[string]interface{}{"height": 1000}, nil
+		}
+		var txid string
+		json.Unmarshal(params[0], &txid)
+		return txid, nil
+	})
+
+	s := newTestStreamer(t, zcashd, Options{TaddressTxidsPage: 100})
+	stream := &testTxStream{}
+	filter := &walletrpc.TransparentAddressBlockFilter{Address: address, Range: blockRange(1000, 1150)}
+	if err := s.GetTaddressTxids(filter, stream); err != nil {
+		t.Fatal(err)
+	}
+	if len(pages) != 2 || pages[0] != [2]float64{1000, 1099} || pages[1] != [2]float64{1100, 1150} {
+		t.Errorf("expected pages 1000-1099 and 1100-1150, asked for %v", pages)
+	}
+	if len(stream.txs) != 2 || stream.txs[0].Data[31] != 1000&0xff || stream.txs[1].Data[31] != 1100&0xff {
+		t.Errorf("expected a transaction from each page, in order, got %v", stream.txs)
+	}
+
+	for _, tt := range []struct {
+		filter *walletrpc.TransparentAddressBlockFilter
+		code   codes.Code
+	}{
+		{&walletrpc.TransparentAddressBlockFilter{Address: address}, codes.InvalidArgument},
+		{&walletrpc.TransparentAddressBlockFilter{Address: "zs1notatransparentaddress", Range: blockRange(1000, 1150)}, codes.InvalidArgument},
+		{&walletrpc.TransparentAddressBlockFilter{Address: address, Range: blockRange(1150, 1000)}, codes.InvalidArgument},
+		{&walletrpc.TransparentAddressBlockFilter{Address: address, Range: blockRange(1000, 1300)}, codes.OutOfRange},
+	} {
+		if err := s.GetTaddressTxids(tt.filter, &testTxStream{}); status.Code(err) != tt.code {
+			t.Errorf("%v: expected %v, got %v", tt.filter, tt.code, err)
+		}
+	}
+}
+
+func TestGetAddressUtxos(t *testing.T) {
+	const (
+		address1 = "t1XVXWCvpMgBvUaed4XDqWtgQgJSu1Ghz7F"
+		address2 = "t1Xxa5ZVPKvs9bGMn7aWTiHjyHvR31XkUst"
+	)
+	zcashd := newFakeZcashd()
+	zcashd.handle("getaddressutxos", func(params []json.RawMessage) (interface{}, error) {
+		return []map[string]interface{}{
+			{"address": address1, "txid": "01" + strings.Repeat("00", 31), "outputIndex": 1, "script": "76a9", "satoshis": 5000, "height": 1200},
+			{"address": address1, "txid": strings.Repeat("00", 32), "outputIndex": 0, "script": "76a9", "satoshis": 100, "height": 900},
+			{"address": address2, "txid": "02" + strings.Repeat("00", 31), "outputIndex": 2, "script": "a914", "satoshis": 7000, "height": 1100},
+		}, nil
+	})
+	s := newTestStreamer(t, zcashd, Options{})
+
+	reply, err := s.GetAddressUtxos(context.Background(), &walletrpc.GetAddressUtxosArg{
+		Addresses:   []string{address1, address2},
+		StartHeight: 1000,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	utxos := reply.AddressUtxos
+	if len(utxos) != 2 || utxos[0].Height != 1100 || utxos[1].Height != 1200 {
+		t.Fatalf("expected the outputs at 1100 and 1200, in height order, got %v", utxos)
+	}
+	if utxos[0].Address != address2 || utxos[0].Index != 2 || utxos[0].ValueZat != 7000 ||
+		!bytes.Equal(utxos[0].Script, []byte{0xa9, 0x14}) || utxos[0].Txid[31] != 2 {
+		t.Errorf("bad output %v", utxos[0])
+	}
+
+	reply, err = s.GetAddressUtxos(context.Background(), &walletrpc.GetAddressUtxosArg{
+		Addresses:  []string{address1},
+		MaxEntries: 1,
+	})
+	if err != nil || len(reply.AddressUtxos) != 1 || reply.AddressUtxos[0].Height != 900 {
+		t.Errorf("expected only the oldest output, got %v, %v", reply, err)
+	}
+
+	for _, arg := range []*walletrpc.GetAddressUtxosArg{
+		{},
+		{Addresses: []string{address1, "zs1notatransparentaddress"}},
+	} {
+		if _, err := s.GetAddressUtxos(context.Background(), arg); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("%v: expected InvalidArgument, got %v", arg, err)
+		}
+	}
+}
+
+// testBalanceStream sends addresses to GetTaddressBalanceStream.
+type testBalanceStream struct {
+	grpc.ServerStream
+	addresses []string
+	balance   *walletrpc.Balance
+}
+
+func (s *testBalanceStream) Recv() (*walletrpc.TransparentAddress, error) {
+	if len(s.addresses) == 0 {
+		return nil, io.EOF
+	}
+	address := s.addresses[0]
+	s.addresses = s.addresses[1:]
+	return &walletrpc.TransparentAddress{Address: address}, nil
+}
+
+func (s *testBalanceStream) SendAndClose(balance *walletrpc.Balance) error {
+	s.balance = balance
+	return nil
+}
+
+func TestGetTaddressBalance(t *testing.T) {
+	const (
+		address1 = "t1XVXWCvpMgBvUaed4XDqWtgQgJSu1Ghz7F"
+		address2 = "t1Xxa5ZVPKvs9bGMn7aWTiHjyHvR31XkUst"
+	)
+	zcashd := newFakeZcashd()
+	zcashd.handle("getaddressbalance", func(params []json.RawMessage) (interface{}, error) {
+		var arg struct{ Addresses []string }
+		json.Unmarshal(params[0], &arg)
+		if len(arg.Addresses) != 2 {
+			t.Errorf("expected each address once, got %v", arg.Addresses)
+		}
+		return map[string]interface{}{"balance": 12000, "received": 20000}, nil
+	})
+	zcashd.handle("getaddressmempool", func(params []json.RawMessage) (interface{}, error) {
+		return []map[string]interface{}{
+			{"address": address1, "satoshis": -5000},
+			{"address": address2, "satoshis": 3000},
+		}, nil
+	})
+	s := newTestStreamer(t, zcashd, Options{})
+
+	balance, err := s.GetTaddressBalance(context.Background(), &walletrpc.AddressList{
+		Addresses: []string{address1, address2, address1},
+	})
+	if err != nil || balance.ConfirmedZat != 12000 || balance.UnconfirmedZat != -2000 {
+		t.Errorf("expected 12000 confirmed and -2000 unconfirmed, got %v, %v", balance, err)
+	}
+
+	stream := &testBalanceStream{addresses: []string{address1, address2}}
+	if err := s.GetTaddressBalanceStream(stream); err != nil {
+		t.Fatal(err)
+	}
+	if stream.balance.ConfirmedZat != 12000 || stream.balance.UnconfirmedZat != -2000 {
+		t.Errorf("expected the same balance from the stream, got %v", stream.balance)
+	}
+
+	for _, addresses := range [][]string{nil, {address1, "zs1notatransparentaddress"}} {
+		if _, err := s.GetTaddressBalance(context.Background(), &walletrpc.AddressList{Addresses: addresses}); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("%v: expected InvalidArgument, got %v", addresses, err)
+		}
+	}
+	stream = &testBalanceStream{addresses: make([]string, maxBalanceAddresses+1)}
+	for i := range stream.addresses {
+		stream.addresses[i] = address1
+	}
+	if err := s.GetTaddressBalanceStream(stream); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected too many addresses to be refused, got %v", err)
+	}
+}
+
+// testUtxoStream collects the outputs sent on a GetAddressUtxosStream, and
+// fails Send once it has limit of them, if limit is set.
+type testUtxoStream struct {
+	grpc.ServerStream
+	ctx   context.Context
+	limit int
+	utxos []*walletrpc.GetAddressUtxosReply
+}
+
+func (s *testUtxoStream) Context() context.Context {
+	if s.ctx != nil {
+		return s.ctx
+	}
+	return context.Background()
+}
+
+func (s *testUtxoStream) Send(utxo *walletrpc.GetAddressUtxosReply) error {
+	if s.limit > 0 && len(s.utxos) == s.limit {
+		return status.Error(codes.Unavailable, "connection lost")
+	}
+	s.utxos = append(s.utxos, utxo)
+	return nil
+}
+
+func TestGetAddressUtxosStream(t *testing.T) {
+	const address = "t1XVXWCvpMgBvUaed4XDqWtgQgJSu1Ghz7F"
+	zcashd := newFakeZcashd()
+	zcashd.handle("getaddressutxos", func(params []json.RawMessage) (interface{}, error) {
+		var utxos []map[string]interface{}
+		for _, height := range []int{1300, 1100, 1200, 1100} {
+			utxos = append(utxos, map[string]interface{}{
+				"address": address, "txid": fmt.Sprintf("%064x", height+len(utxos)),
+				"outputIndex": 0, "script": "76a9", "satoshis": 1000, "height": height,
+			})
+		}
+		return utxos, nil
+	})
+	s := newTestStreamer(t, zcashd, Options{})
+	arg := &walletrpc.GetAddressUtxosArg{Addresses: []string{address}}
+
+	// The client is cut off after two outputs, and resumes from the height
+	// of the second.
+	stream := &testUtxoStream{limit: 2}
+	if err := s.GetAddressUtxosStream(arg, stream); status.Code(err) != codes.Unavailable {
+		t.Errorf("expected the failed send to end the stream, got %v", err)
+	}
+	if len(stream.utxos) != 2 || stream.utxos[0].Height != 1100 || stream.utxos[1].Height != 1100 ||
+		bytes.Compare(stream.utxos[0].Txid, stream.utxos[1].Txid) >= 0 {
+		t.Fatalf("expected the two outputs at 1100 first, in txid order, got %v", stream.utxos)
+	}
+	arg.StartHeight = stream.utxos[1].Height
+	stream = &testUtxoStream{}
+	if err := s.GetAddressUtxosStream(arg, stream); err != nil {
+		t.Fatal(err)
+	}
+	if len(stream.utxos) != 4 || stream.utxos[2].Height != 1200 || stream.utxos[3].Height != 1300 {
+		t.Errorf("expected the outputs from 1100 on, in height order, got %v", stream.utxos)
+	}
+}
+
+func TestGetAddressUtxosStreamBatches(t *testing.T) {
+	// Each address has an output at the height of its position in the
+	// request and one at 1000, the node listing them newest first.
+	addresses := make([]string, 2*addressUtxosBatch+1)
+	position := make(map[string]int)
+	for i := range addresses {
+		addresses[i] = fmt.Sprintf("t1%033d", i)
+		position[addresses[i]] = i
+	}
+	zcashd := newFakeZcashd()
+	var largest int
+	zcashd.handle("getaddressutxos", func(params []json.RawMessage) (interface{}, error) {
+		var arg struct{ Addresses []string }
+		json.Unmarshal(params[0], &arg)
+		if len(arg.Addresses) > largest {
+			largest = len(arg.Addresses)
+		}
+		var utxos []map[string]interface{}
+		for _, address := range arg.Addresses {
+			for _, height := range []int{2000 + position[address], 1000} {
+				utxos = append(utxos, map[string]interface{}{
+					"address": address, "txid": fmt.Sprintf("%064x", height),
+					"outputIndex": 0, "script": "76a9", "satoshis": 1000, "height": height,
+				})
+			}
+		}
+		return utxos, nil
+	})
+	s := newTestStreamer(t, zcashd, Options{})
+
+	stream := &testUtxoStream{}
+	if err := s.GetAddressUtxosStream(&walletrpc.GetAddressUtxosArg{Addresses: addresses}, stream); err != nil {
+		t.Fatal(err)
+	}
+	if calls := zcashd.count("getaddressutxos"); calls != 3 || largest != addressUtxosBatch {
+		t.Errorf("expected 3 calls of at most %d addresses, made %d of up to %d", addressUtxosBatch, calls, largest)
+	}
+	if len(stream.utxos) != 2*len(addresses) {
+		t.Fatalf("expected %d outputs, got %d", 2*len(addresses), len(stream.utxos))
+	}
+	for i, utxo := range stream.utxos {
+		address := addresses[i/2]
+		if height := uint64(1000 + (i%2)*(1000+i/2)); utxo.Address != address || utxo.Height != height {
+			t.Fatalf("output %d: got %s at %d, expected %s at %d", i, utxo.Address, utxo.Height, address, height)
+		}
+	}
+
+	// MaxEntries stops the stream before the later batches are asked for.
+	stream = &testUtxoStream{}
+	if err := s.GetAddressUtxosStream(&walletrpc.GetAddressUtxosArg{Addresses: addresses, MaxEntries: 10}, stream); err != nil {
+		t.Fatal(err)
+	}
+	if calls := zcashd.count("getaddressutxos"); len(stream.utxos) != 10 || calls != 4 {
+		t.Errorf("expected 10 outputs from one more call, got %d after %d calls", len(stream.utxos), calls)
+	}
+
+	// A batch is held against the call's memory budget.
+	memory := common.NewRequestMemory(1000)
+	stream = &testUtxoStream{ctx: common.WithRequestMemory(context.Background(), memory)}
+	if err := s.GetAddressUtxosStream(&walletrpc.GetAddressUtxosArg{Addresses: addresses}, stream); err == nil || !memory.Exceeded() {
+		t.Errorf("expected a batch to go over a 1000 byte budget, got %v", err)
+	}
+	if len(stream.utxos) != 0 {
+		t.Errorf("expected nothing sent over budget, got %d outputs", len(stream.utxos))
+	}
+}
+
+func TestGetTransactionCache(t *testing.T) {
+	zcashd := newFakeZcashd()
+	heights := map[string]interface{}{
+		strings.Repeat("01", 32): 289460,
+		strings.Repeat("02", 32): nil, // in the mempool
+	}
+	zcashd.handle("getrawtransaction", func(params []json.RawMessage) (interface{}, error) {
+		var txid string
+		json.Unmarshal(params[0], &txid)
+		if len(params) == 2 {
+			if height := heights[txid]; height != nil {
+				return map[string]interface{}{"height": height}, nil
+			}
+			return map[string]interface{}{}, nil
+		}
+		return "abcd", nil
+	})
+	s := newTestStreamer(t, zcashd, Options{TxCacheSize: 10, TxCacheTTL: time.Minute})
+
+	for i := 0; i < 2; i++ {
+		tx, err := s.GetTransaction(context.Background(), &walletrpc.TxFilter{Hash: bytes.Repeat([]byte{1}, 32)})
+		if err != nil || tx.Height != 289460 || !bytes.Equal(tx.Data, []byte{0xab, 0xcd}) {
+			t.Fatalf("expected the transaction at 289460, got %v, %v", tx, err)
+		}
+	}
+	if calls := zcashd.count("getrawtransaction"); calls != 2 {
+		t.Errorf("expected the mined transaction to be fetched once, in 2 calls, made %d", calls)
+	}
+
+	for i := 0; i < 2; i++ {
+		tx, err := s.GetTransaction(context.Background(), &walletrpc.TxFilter{Hash: bytes.Repeat([]byte{2}, 32)})
+		if err != nil || tx.Height != 0 {
+			t.Fatalf("expected the mempool transaction without a height, got %v, %v", tx, err)
+		}
+	}
+	if calls := zcashd.count("getrawtransaction"); calls != 6 {
+		t.Errorf("expected the mempool transaction to be fetched each time, made %d calls", calls-2)
+	}
+}
+
+func TestTxCacheBounded(t *testing.T) {
+	c := newTxCache(time.Minute, 2)
+	for i := byte(0); i < 3; i++ {
+		c.add([32]byte{i}, &walletrpc.RawTransaction{Height: uint64(i)})
+	}
+	if len(c.txs) != 2 || c.get([32]byte{0}) != nil || c.get([32]byte{2}) == nil {
+		t.Errorf("expected the oldest transaction to be forgotten, have %v", c.txs)
+	}
+}
+
+// batchingZcashd is a fakeZcashd that answers batches, counting them.
+type batchingZcashd struct {
+	*fakeZcashd
+	batches int
+	largest int
+}
+
+func (b *batchingZcashd) BatchRequest(method string, params [][]json.RawMessage) ([]json.RawMessage, []error, error) {
+	b.batches++
+	if len(params) > b.largest {
+		b.largest = len(params)
+	}
+	results := make([]json.RawMessage, len(params))
+	errs := make([]error, len(params))
+	for i := range params {
+		results[i], errs[i] = b.RawRequest(method, params[i])
+	}
+	return results, errs, nil
+}
+
+// testTxidStream sends txids to GetTransactions, and collects what it
+// sends back.
+type testTxidStream struct {
+	grpc.ServerStream
+	txids [][]byte
+	txs   []*walletrpc.RawTransaction
+}
+
+func (s *testTxidStream) Context() context.Context {
+	return context.Background()
+}
+
+func (s *testTxidStream) Recv() (*walletrpc.TxFilter, error) {
+	if len(s.txids) == 0 {
+		return nil, io.EOF
+	}
+	txid := s.txids[0]
+	s.txids = s.txids[1:]
+	return &walletrpc.TxFilter{Hash: txid}, nil
+}
+
+func (s *testTxidStream) Send(tx *walletrpc.RawTransaction) error {
+	s.txs = append(s.txs, tx)
+	return nil
+}
+
+func TestGetTransactions(t *testing.T) {
+	zcashd := &batchingZcashd{fakeZcashd: newFakeZcashd()}
+	zcashd.handle("getrawtransaction", func(params []json.RawMessage) (interface{}, error) {
+		var txid string
+		json.Unmarshal(params[0], &txid)
+		if txid == strings.Repeat("ff", 32) {
+			return nil, &btcjson.RPCError{Code: -5, Message: "No such mempool or blockchain transaction"}
+		}
+		// The first byte of the big-endian txid is the last of TxFilter.hash.
+		height, _ := strconv.ParseUint(txid[:2], 16, 8)
+		return map[string]interface{}{"hex": txid[:2], "height": height}, nil
+	})
+
+	var txids [][]byte
+	for i := 1; i <= 20; i++ {
+		txid := make([]byte, 32)
+		txid[31] = byte(i)
+		txids = append(txids, txid)
+	}
+	s := newTestStreamer(t, zcashd, Options{TransactionsBatch: 8})
+
+	stream := &testTxidStream{txids: txids}
+	if err := s.GetTransactions(stream); err != nil {
+		t.Fatal(err)
+	}
+	if len(stream.txs) != 20 {
+		t.Fatalf("expected 20 transactions, got %d", len(stream.txs))
+	}
+	for i, tx := range stream.txs {
+		if tx.Height != uint64(i+1) || !bytes.Equal(tx.Data, []byte{byte(i + 1)}) {
+			t.Errorf("expected transaction %d at %d, got %v", i+1, i+1, tx)
+		}
+	}
+	if zcashd.batches > 20 || zcashd.largest > 8 || zcashd.count("getrawtransaction") != 20 {
+		t.Errorf("expected 20 calls in batches of at most 8, made %d calls in %d batches of up to %d",
+			zcashd.count("getrawtransaction"), zcashd.batches, zcashd.largest)
+	}
+
+	missing := bytes.Repeat([]byte{0xff}, 32)
+	stream = &testTxidStream{txids: [][]byte{txids[0], missing}}
+	if err := s.GetTransactions(stream); status.Code(err) != codes.NotFound {
+		t.Errorf("expected NotFound for a missing transaction, got %v", err)
+	}
+	stream = &testTxidStream{txids: [][]byte{{1, 2, 3}}}
+	if err := s.GetTransactions(stream); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected InvalidArgument for a short txid, got %v", err)
+	}
+}
+
+func TestGetTxStatus(t *testing.T) {
+	txData, tx := testTxWithInputs(t)
+	txid := tx.GetEncodableHash()
+
+	zcashd := newFakeZcashd()
+	zcashd.handle("sendrawtransaction", func(params []json.RawMessage) (interface{}, error) {
+		return "txid", nil
+	})
+	var txinfo interface{}
+	zcashd.handle("getrawtransaction", func(params []json.RawMessage) (interface{}, error) {
+		if txinfo == nil {
+			return nil, &btcjson.RPCError{Code: -5, Message: "No such mempool or blockchain transaction"}
+		}
+		return txinfo, nil
+	})
+	var txout interface{}
+	zcashd.handle("gettxout", func(params []json.RawMessage) (interface{}, error) {
+		return txout, nil
+	})
+	s := newTestStreamer(t, zcashd, Options{TxStatusWindow: time.Hour, TxStatusMax: 10})
+
+	getStatus := func() *walletrpc.TxStatus {
+		txStatus, err := s.GetTxStatus(context.Background(), &walletrpc.TxFilter{Hash: txid})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return txStatus
+	}
+
+	// Not sent through this server, so its inputs aren't known.
+	if got := getStatus(); got.Status != walletrpc.TxStatus_UNKNOWN || zcashd.count("gettxout") != 0 {
+		t.Errorf("expected UNKNOWN without looking at the inputs, got %v", got)
+	}
+
+	if _, err := s.SendTransaction(context.Background(), &walletrpc.RawTransaction{Data: txData}); err != nil {
+		t.Fatal(err)
+	}
+	txinfo = map[string]interface{}{"hex": "00"}
+	if got := getStatus(); got.Status != walletrpc.TxStatus_IN_MEMPOOL {
+		t.Errorf("expected IN_MEMPOOL, got %v", got)
+	}
+	txinfo = map[string]interface{}{"hex": "00", "height": 1000, "confirmations": 3}
+	if got := getStatus(); got.Status != walletrpc.TxStatus_MINED || got.Height != 1000 || got.Confirmations != 3 {
+		t.Errorf("expected MINED at 1000 with 3 confirmations, got %v", got)
+	}
+
+	// Evicted, with its inputs still unspent.
+	txinfo = nil
+	txout = map[string]interface{}{"value": 1}
+	if got := getStatus(); got.Status != walletrpc.TxStatus_UNKNOWN {
+		t.Errorf("expected UNKNOWN, got %v", got)
+	}
+	// An input spent by another transaction.
+	txout = nil
+	if got := getStatus(); got.Status != walletrpc.TxStatus_CONFLICTED {
+		t.Errorf("expected CONFLICTED, got %v", got)
+	}
+
+	// Found in the block cache, without asking the node.
+	calls := zcashd.count("getrawtransaction")
+	s.cache.Add(500, &walletrpc.CompactBlock{Height: 500, Hash: []byte{5}, Vtx: []*walletrpc.CompactTx{{Hash: txid}}})
+	s.cache.Add(501, &walletrpc.CompactBlock{Height: 501, Hash: []byte{6}, PrevHash: []byte{5}})
+	if got := getStatus(); got.Status != walletrpc.TxStatus_MINED || got.Height != 500 || got.Confirmations != 2 {
+		t.Errorf("expected MINED at 500 with 2 confirmations, got %v", got)
+	}
+	if zcashd.count("getrawtransaction") != calls {
+		t.Error("asked the node about a transaction in the block cache")
+	}
+}
+
+func TestGetTxProof(t *testing.T) {
+	txid := bytes.Repeat([]byte{1}, 32)
+	sibling := bytes.Repeat([]byte{2}, 32)
+	root := sha256.Sum256(append(append([]byte{}, sibling...), txid...))
+	root = sha256.Sum256(root[:])
+
+	// A block of two transactions with txid second, as gettxoutproof
+	// serializes it, with an empty Equihash solution.
+	header := make([]byte, 141)
+	header[0] = 4
+	copy(header[36:68], root[:])
+	proof := append(append([]byte{}, header...), 2, 0, 0, 0, 2)
+	proof = append(append(append(proof, sibling...), txid...), 1, 0x05)
+
+	zcashd := newFakeZcashd()
+	zcashd.handle("gettxoutproof", func(params []json.RawMessage) (interface{}, error) {
+		if string(params[0]) != `["`+strings.Repeat("01", 32)+`"]` {
+			return nil, &btcjson.RPCError{Code: -5, Message: "Transaction not yet in block"}
+		}
+		return hex.EncodeToString(proof), nil
+	})
+	zcashd.handle("getblockheader", func(params []json.RawMessage) (interface{}, error) {
+		return map[string]interface{}{"height": 1234}, nil
+	})
+	s := newTestStreamer(t, zcashd, Options{})
+
+	txProof, err := s.GetTxProof(context.Background(), &walletrpc.TxFilter{Hash: txid})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(txProof.Header, header) || txProof.Height != 1234 || txProof.Index != 1 ||
+		len(txProof.Branch) != 1 || !bytes.Equal(txProof.Branch[0], sibling) {
+		t.Errorf("bad proof %v", txProof)
+	}
+
+	if _, err := s.GetTxProof(context.Background(), &walletrpc.TxFilter{Hash: sibling}); status.Code(err) != codes.NotFound {
+		t.Errorf("expected NotFound for a transaction not mined, got %v", err)
+	}
+
+	// A proof that doesn't lead to the header's root is refused.
+	copy(proof[36:68], sibling)
+	if _, err := s.GetTxProof(context.Background(), &walletrpc.TxFilter{Hash: txid}); status.Code(err) != codes.Internal {
+		t.Errorf("expected a bad proof to be refused, got %v", err)
+	}
+}
+
+// testCoinbaseBlock returns a block at height after prevHash, at time, with
+// only a version 1 coinbase paying value to script.
+func testCoinbaseBlock(t *testing.T, height int, prevHash []byte, time uint32, value uint64, script []byte) *parser.Block {
+	var data bytes.Buffer
+	binary.Write(&data, binary.LittleEndian, int32(4))
+	data.Write(prevHash)
+	data.Write(make([]byte, 32+32))
+	binary.Write(&data, binary.LittleEndian, time)
+	data.Write(make([]byte, 4+32))
+	data.Write([]byte{0, 1})
+
+	binary.Write(&data, binary.LittleEndian, uint32(1))
+	data.WriteByte(1)
+	data.Write(make([]byte, 32))
+	binary.Write(&data, binary.LittleEndian, uint32(0xffffffff))
+	if height == 0 {
+		data.Write([]byte{1, 0x00})
+	} else {
+		data.Write([]byte{3, 0x02, byte(height), byte(height >> 8)})
+	}
+	binary.Write(&data, binary.LittleEndian, uint32(0xffffffff))
+	data.WriteByte(1)
+	binary.Write(&data, binary.LittleEndian, value)
+	data.WriteByte(byte(len(script)))
+	data.Write(script)
+	binary.Write(&data, binary.LittleEndian, uint32(0))
+
+	block := parser.NewBlock()
+	if _, err := block.ParseFromSlice(data.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	return block
+}
+
+type testBalanceDeltaStream struct {
+	grpc.ServerStream
+	deltas []*walletrpc.BalanceDelta
+}
+
+func (s *testBalanceDeltaStream) Send(delta *walletrpc.BalanceDelta) error {
+	s.deltas = append(s.deltas, delta)
+	return nil
+}
+
+func TestGetTaddressBalanceHistory(t *testing.T) {
+	const address = "tmAD3152NxJATDTSbUabyZF8Lso5GueTeVy"
+	script, _ := hex.DecodeString("76a914056cc3284e4aff0fc8d32e1960800cf5233c9a0a88ac")
+	const midnight = 1600041600
+
+	s := newTestStreamer(t, newFakeZcashd(), Options{})
+	arg := &walletrpc.BalanceHistoryArg{Address: address}
+	if err := s.GetTaddressBalanceHistory(arg, &testBalanceDeltaStream{}); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("expected FailedPrecondition without an address index, got %v", err)
+	}
+
+	if !common.SQLiteSupported {
+		t.Skip("SQLite needs cgo")
+	}
+	index, err := common.NewAddressIndex(":memory:", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer index.Close()
+	prevHash := make([]byte, 32)
+	for height, payment := range []struct {
+		time  uint32
+		value uint64
+	}{
+		{midnight, 50},
+		{midnight + 3600, 25},
+		{midnight + 2*3600, 0},
+		{midnight + secondsPerDay + 60, 10},
+	} {
+		block := testCoinbaseBlock(t, height, prevHash, payment.time, payment.value, script)
+		if payment.value == 0 {
+			block = testCoinbaseBlock(t, height, prevHash, payment.time, 1, []byte{0x6a})
+		}
+		if err := index.Add(block); err != nil {
+			t.Fatal(err)
+		}
+		prevHash = block.GetEncodableHash()
+	}
+	s = newTestStreamer(t, newFakeZcashd(), Options{AddressIndex: index})
+
+	for _, tt := range []struct {
+		granularity walletrpc.BalanceHistoryArg_Granularity
+		want        []walletrpc.BalanceDelta
+	}{
+		{walletrpc.BalanceHistoryArg_BLOCK, []walletrpc.BalanceDelta{
+			{Height: 0, Time: midnight, DeltaZat: 50, BalanceZat: 50},
+			{Height: 1, Time: midnight + 3600, DeltaZat: 25, BalanceZat: 75},
+			{Height: 3, Time: midnight + secondsPerDay + 60, DeltaZat: 10, BalanceZat: 85},
+		}},
+		{walletrpc.BalanceHistoryArg_DAY, []walletrpc.BalanceDelta{
+			{Height: 1, Time: midnight, DeltaZat: 75, BalanceZat: 75},
+			{Height: 3, Time: midnight + secondsPerDay, DeltaZat: 10, BalanceZat: 85},
+		}},
+	} {
+		arg.Granularity = tt.granularity
+		stream := &testBalanceDeltaStream{}
+		if err := s.GetTaddressBalanceHistory(arg, stream); err != nil {
+			t.Fatal(err)
+		}
+		if len(stream.deltas) != len(tt.want) {
+			t.Fatalf("%v: got %v, expected %v", tt.granularity, stream.deltas, tt.want)
+		}
+		for i := range tt.want {
+			got := stream.deltas[i]
+			if got.Height != tt.want[i].Height || got.Time != tt.want[i].Time ||
+				got.DeltaZat != tt.want[i].DeltaZat || got.BalanceZat != tt.want[i].BalanceZat {
+				t.Errorf("%v delta %d: got %v, expected %v", tt.granularity, i, got, &tt.want[i])
+			}
+		}
+	}
+
+	arg.Address = "zs1notatransparentaddress"
+	if err := s.GetTaddressBalanceHistory(arg, &testBalanceDeltaStream{}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected InvalidArgument for a shielded address, got %v", err)
+	}
+}
+
+func TestGetTreeState(t *testing.T) {
+	ctx := context.Background()
+	hash := bytes.Repeat([]byte{0xab, 0x01}, 16)
+	displayHash := make([]byte, 32)
+	copy(displayHash, hash)
+	reverseBytes(displayHash)
+	skipHash := strings.Repeat("cd", 32)
+
+	zcashd := newFakeZcashd()
+	zcashd.handle("z_gettreestate", func(params []json.RawMessage) (interface{}, error) {
+		var arg string
+		json.Unmarshal(params[0], &arg)
+		switch arg {
+		case "1000", hex.EncodeToString(displayHash):
+			// The tree didn't change in this block.
+			return map[string]interface{}{
+				"hash": hex.EncodeToString(displayHash), "height": 1000, "time": 1600000000,
+				"sapling": map[string]interface{}{"skipHash": skipHash},
+			}, nil
+		case skipHash:
+			return map[string]interface{}{
+				"hash": skipHash, "height": 990, "time": 1599990000,
+				"sapling": map[string]interface{}{"commitments": map[string]interface{}{"finalState": "01ff00"}},
+			}, nil
+		}
+		return nil, &btcjson.RPCError{Code: -8, Message: "Block height out of range"}
+	})
+	treeStates, err := common.NewTreeStateStore("", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer treeStates.Close()
+	s := newTestStreamer(t, zcashd, Options{TreeStates: treeStates, ChainName: "test"})
+
+	state, err := s.GetTreeState(ctx, &walletrpc.BlockID{Height: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Network != "test" || state.Height != 1000 || state.Hash != hex.EncodeToString(displayHash) ||
+		state.Time != 1600000000 || state.Tree != "01ff00" {
+		t.Errorf("bad tree state %v", state)
+	}
+	if calls := zcashd.count("z_gettreestate"); calls != 2 {
+		t.Errorf("expected the skip hash to be followed, got %d calls", calls)
+	}
+
+	// Asking again, by hash or by the height of a cached block, doesn't
+	// cost a call.
+	if err, _ := s.cache.Add(1000, &walletrpc.CompactBlock{Height: 1000, Hash: hash}); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []*walletrpc.BlockID{{Hash: hash}, {Height: 1000}} {
+		state, err := s.GetTreeState(ctx, id)
+		if err != nil || state.Tree != "01ff00" {
+			t.Errorf("expected the kept tree state, got %v, %v", state, err)
+		}
+	}
+	if calls := zcashd.count("z_gettreestate"); calls != 2 {
+		t.Errorf("expected kept tree states to be answered without the node, got %d calls", calls)
+	}
+
+	for _, tt := range []struct {
+		id   *walletrpc.BlockID
+		code codes.Code
+	}{
+		{&walletrpc.BlockID{Height: 5000}, codes.OutOfRange},
+		{&walletrpc.BlockID{}, codes.InvalidArgument},
+		{&walletrpc.BlockID{Hash: []byte{1}}, codes.InvalidArgument},
+	} {
+		if _, err := s.GetTreeState(ctx, tt.id); status.Code(err) != tt.code {
+			t.Errorf("%v: expected %v, got %v", tt.id, tt.code, err)
+		}
+	}
+
+	s = newTestStreamer(t, newFakeZcashd(), Options{})
+	if _, err := s.GetTreeState(ctx, &walletrpc.BlockID{Height: 1000}); status.Code(err) != codes.Unimplemented {
+		t.Errorf("expected Unimplemented from a node without z_gettreestate, got %v", err)
+	}
+}
+
+type testSubtreeRootStream struct {
+	grpc.ServerStream
+	roots []*walletrpc.SubtreeRoot
+}
+
+func (s *testSubtreeRootStream) Context() context.Context {
+	return context.Background()
+}
+
+func (s *testSubtreeRootStream) Send(root *walletrpc.SubtreeRoot) error {
+	s.roots = append(s.roots, root)
+	return nil
+}
+
+func TestGetSubtreeRoots(t *testing.T) {
+	zcashd := newFakeZcashd()
+	var lastParams []json.RawMessage
+	zcashd.handle("z_getsubtreesbyindex", func(params []json.RawMessage) (interface{}, error) {
+		lastParams = params
+		var pool string
+		json.Unmarshal(params[0], &pool)
+		if pool != "sapling" {
+			return nil, &btcjson.RPCError{Code: -8, Message: "Invalid pool name"}
+		}
+		return map[string]interface{}{
+			"pool":        "sapling",
+			"start_index": 1,
+			"subtrees": []map[string]interface{}{
+				{"root": strings.Repeat("11", 32), "end_hash": "00" + strings.Repeat("ab", 31), "end_height": 1200},
+				{"root": strings.Repeat("22", 32), "end_hash": "00" + strings.Repeat("cd", 31), "end_height": 1500},
+			},
+		}, nil
+	})
+	s := newTestStreamer(t, zcashd, Options{})
+
+	stream := &testSubtreeRootStream{}
+	if err := s.GetSubtreeRoots(&walletrpc.GetSubtreeRootsArg{StartIndex: 1, MaxEntries: 2}, stream); err != nil {
+		t.Fatal(err)
+	}
+	if params := fmt.Sprintf("%s", lastParams); params != `["sapling" 1 2]` {
+		t.Errorf("z_getsubtreesbyindex called with %s", params)
+	}
+	if len(stream.roots) != 2 {
+		t.Fatalf("expected 2 subtree roots, got %d", len(stream.roots))
+	}
+	root := stream.roots[1]
+	wantHash := append(bytes.Repeat([]byte{0xcd}, 31), 0)
+	if !bytes.Equal(root.RootHash, bytes.Repeat([]byte{0x22}, 32)) ||
+		!bytes.Equal(root.CompletingBlockHash, wantHash) || root.CompletingBlockHeight != 1500 {
+		t.Errorf("bad subtree root %v", root)
+	}
+
+	// No limit asks for every subtree.
+	if err := s.GetSubtreeRoots(&walletrpc.GetSubtreeRootsArg{}, &testSubtreeRootStream{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(lastParams) != 2 {
+		t.Errorf("expected no limit, got %s", lastParams)
+	}
+
+	err := s.GetSubtreeRoots(&walletrpc.GetSubtreeRootsArg{ShieldedProtocol: walletrpc.ShieldedProtocol_orchard}, &testSubtreeRootStream{})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected InvalidArgument for orchard, got %v", err)
+	}
+
+	s = newTestStreamer(t, newFakeZcashd(), Options{})
+	if err := s.GetSubtreeRoots(&walletrpc.GetSubtreeRootsArg{}, &testSubtreeRootStream{}); status.Code(err) != codes.Unimplemented {
+		t.Errorf("expected Unimplemented from a node without z_getsubtreesbyindex, got %v", err)
+	}
+}
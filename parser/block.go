@@ -54,6 +54,12 @@ func (b *Block) GetDisplayPrevHash() []byte {
 	return rhash
 }
 
+// GetSaplingRoot returns the root of the Sapling note commitment tree as of
+// this block, in little-endian wire order.
+func (b *Block) GetSaplingRoot() []byte {
+	return b.hdr.HashFinalSaplingRoot
+}
+
 func (b *Block) HasSaplingTransactions() bool {
 	for _, tx := range b.vtx {
 		if tx.HasSaplingTransactions() {
@@ -94,10 +100,24 @@ func (b *Block) GetHeight() int {
 	return int(blockHeight)
 }
 
+// GetTime returns the block's timestamp, in seconds since the epoch.
+func (b *Block) GetTime() uint32 {
+	return b.hdr.Time
+}
+
 func (b *Block) GetPrevHash() []byte {
 	return b.hdr.HashPrevBlock
 }
 
+// Coinbase returns the serialized coinbase transaction, the block's first,
+// or nil if the block has no transactions.
+func (b *Block) Coinbase() []byte {
+	if len(b.vtx) == 0 {
+		return nil
+	}
+	return b.vtx[0].Bytes()
+}
+
 func (b *Block) ToCompact() *walletrpc.CompactBlock {
 	compactBlock := &walletrpc.CompactBlock{
 		//TODO ProtoVersion: 1,
@@ -0,0 +1,178 @@
+package parser
+
+import (
+	"bytes"
+	"crypto/sha256"
+
+	"github.com/adityapk00/lightwalletd/parser/internal/bytestring"
+	"github.com/pkg/errors"
+)
+
+// MerkleBlock is a block header with a partial Merkle tree of the block's
+// transactions, proving that it includes the ones the tree matches. It's
+// serialized as a CMerkleBlock, as BIP 37 defines it, which is what zcashd's
+// gettxoutproof returns.
+type MerkleBlock struct {
+	header      *blockHeader
+	headerBytes []byte
+	txCount     uint32
+	hashes      [][]byte
+	bits        []bool
+}
+
+func NewMerkleBlock() *MerkleBlock {
+	return &MerkleBlock{header: NewBlockHeader()}
+}
+
+// ParseFromSlice parses the merkle block from the provided byte slice,
+// advancing over the bytes read. If successful it returns the rest of the
+// slice, otherwise it returns the input slice unaltered along with an error.
+func (mb *MerkleBlock) ParseFromSlice(data []byte) (rest []byte, err error) {
+	s, err := mb.header.ParseFromSlice(data)
+	if err != nil {
+		return data, errors.Wrap(err, "parsing block header")
+	}
+	mb.headerBytes = data[:len(data)-len(s)]
+
+	str := bytestring.String(s)
+	if ok := str.ReadUint32(&mb.txCount); !ok {
+		return data, errors.New("could not read transaction count")
+	}
+
+	var hashCount int
+	if ok := str.ReadCompactSize(&hashCount); !ok {
+		return data, errors.New("could not read hash count")
+	}
+	mb.hashes = make([][]byte, hashCount)
+	for i := range mb.hashes {
+		if ok := str.ReadBytes(&mb.hashes[i], 32); !ok {
+			return data, errors.New("could not read hash")
+		}
+	}
+
+	var flags bytestring.String
+	if ok := str.ReadCompactLengthPrefixed(&flags); !ok {
+		return data, errors.New("could not read flag bits")
+	}
+	// The bits are packed least significant first.
+	mb.bits = make([]bool, len(flags)*8)
+	for i := range mb.bits {
+		mb.bits[i] = flags[i/8]&(1<<uint(i%8)) != 0
+	}
+
+	return []byte(str), nil
+}
+
+// Header returns the serialized block header.
+func (mb *MerkleBlock) Header() []byte {
+	return mb.headerBytes
+}
+
+// GetEncodableHash returns the block hash in little-endian wire order.
+func (mb *MerkleBlock) GetEncodableHash() []byte {
+	return mb.header.GetEncodableHash()
+}
+
+// merkleNode is the result of walking a subtree of the partial Merkle tree.
+type merkleNode struct {
+	hash []byte
+	// If the subtree has the matched transaction, its hash, position and
+	// branch up to the subtree's root.
+	matched bool
+	txid    []byte
+	index   uint32
+	branch  [][]byte
+}
+
+// Branch returns the hash of the one transaction the partial tree matches,
+// in internal byte order, its position in the block, and its Merkle branch:
+// the hashes it's combined with on the way up to the root, from the
+// transaction up. The bits of the position tell whether each is on the
+// left (1) or the right (0). It fails unless the tree is well formed, leads
+// to the Merkle root in the header, and matches exactly one transaction.
+func (mb *MerkleBlock) Branch() (txid []byte, index uint32, branch [][]byte, err error) {
+	if mb.txCount == 0 {
+		return nil, 0, nil, errors.New("merkle block has no transactions")
+	}
+	if len(mb.hashes) > int(mb.txCount) {
+		return nil, 0, nil, errors.New("merkle block has more hashes than transactions")
+	}
+	height := uint(0)
+	for mb.treeWidth(height) > 1 {
+		height++
+	}
+
+	var bitsUsed, hashesUsed int
+	root, err := mb.traverse(height, 0, &bitsUsed, &hashesUsed)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	// All the hashes, and the bits but for padding, must have been used.
+	if hashesUsed != len(mb.hashes) || (bitsUsed+7)/8 != len(mb.bits)/8 {
+		return nil, 0, nil, errors.New("merkle block has unused hashes or bits")
+	}
+	if !bytes.Equal(root.hash, mb.header.HashMerkleRoot) {
+		return nil, 0, nil, errors.New("partial merkle tree doesn't lead to the header's merkle root")
+	}
+	if !root.matched {
+		return nil, 0, nil, errors.New("merkle block matches no transaction")
+	}
+	return root.txid, root.index, root.branch, nil
+}
+
+// treeWidth returns the number of nodes at height in the tree, counting the
+// leaves as height 0.
+func (mb *MerkleBlock) treeWidth(height uint) uint32 {
+	return uint32((uint64(mb.txCount) + (1 << height) - 1) >> height)
+}
+
+func (mb *MerkleBlock) traverse(height uint, pos uint32, bitsUsed, hashesUsed *int) (*merkleNode, error) {
+	if *bitsUsed >= len(mb.bits) {
+		return nil, errors.New("partial merkle tree ran out of bits")
+	}
+	parentOfMatch := mb.bits[*bitsUsed]
+	*bitsUsed++
+
+	if height == 0 || !parentOfMatch {
+		if *hashesUsed >= len(mb.hashes) {
+			return nil, errors.New("partial merkle tree ran out of hashes")
+		}
+		node := &merkleNode{hash: mb.hashes[*hashesUsed]}
+		*hashesUsed++
+		if height == 0 && parentOfMatch {
+			node.matched, node.txid, node.index = true, node.hash, pos
+		}
+		return node, nil
+	}
+
+	left, err := mb.traverse(height-1, pos*2, bitsUsed, hashesUsed)
+	if err != nil {
+		return nil, err
+	}
+	right := left
+	if pos*2+1 < mb.treeWidth(height-1) {
+		if right, err = mb.traverse(height-1, pos*2+1, bitsUsed, hashesUsed); err != nil {
+			return nil, err
+		}
+		// Two equal children would let a tree with a duplicated
+		// transaction pass for the real one (CVE-2012-2459).
+		if bytes.Equal(left.hash, right.hash) {
+			return nil, errors.New("partial merkle tree has equal siblings")
+		}
+	}
+
+	digest := sha256.Sum256(append(append([]byte{}, left.hash...), right.hash...))
+	digest = sha256.Sum256(digest[:])
+	node := &merkleNode{hash: digest[:]}
+	switch {
+	case left.matched && right.matched && right != left:
+		return nil, errors.New("partial merkle tree matches more than one transaction")
+	case left.matched:
+		node.matched, node.txid, node.index = true, left.txid, left.index
+		node.branch = append(left.branch, right.hash)
+	case right.matched:
+		node.matched, node.txid, node.index = true, right.txid, right.index
+		node.branch = append(right.branch, left.hash)
+	}
+	return node, nil
+}
@@ -0,0 +1,81 @@
+package parser
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"testing"
+)
+
+func sha256d(left, right []byte) []byte {
+	digest := sha256.Sum256(append(append([]byte{}, left...), right...))
+	digest = sha256.Sum256(digest[:])
+	return digest[:]
+}
+
+// testMerkleBlock serializes a merkle block with an empty Equihash solution.
+func testMerkleBlock(root []byte, txCount byte, hashes [][]byte, flags byte) []byte {
+	header := make([]byte, serBlockHeaderMinusEquihashSize)
+	header[0] = 4
+	copy(header[36:68], root)
+	data := append(header, 0) // no solution
+	data = append(data, txCount, 0, 0, 0, byte(len(hashes)))
+	for _, hash := range hashes {
+		data = append(data, hash...)
+	}
+	return append(data, 1, flags)
+}
+
+func TestMerkleBlockBranch(t *testing.T) {
+	var txids [][]byte
+	for i := byte(0); i < 3; i++ {
+		txids = append(txids, bytes.Repeat([]byte{i + 1}, 32))
+	}
+	// The third of three transactions: its sibling is itself, duplicated,
+	// then the hash of the first two.
+	h01 := sha256d(txids[0], txids[1])
+	h22 := sha256d(txids[2], txids[2])
+	root := sha256d(h01, h22)
+
+	mb := NewMerkleBlock()
+	data := testMerkleBlock(root, 3, [][]byte{h01, txids[2]}, 0x0d)
+	rest, err := mb.ParseFromSlice(append(data, 0xff))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rest) != 1 || len(mb.Header()) != serBlockHeaderMinusEquihashSize+1 {
+		t.Errorf("parsed %d bytes of header, %d left", len(mb.Header()), len(rest))
+	}
+	txid, index, branch, err := mb.Branch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(txid, txids[2]) || index != 2 {
+		t.Errorf("expected the third transaction, got %x at %d", txid, index)
+	}
+	if len(branch) != 2 || !bytes.Equal(branch[0], txids[2]) || !bytes.Equal(branch[1], h01) {
+		t.Errorf("bad branch %x", branch)
+	}
+
+	// The same tree under a different root.
+	mb = NewMerkleBlock()
+	if _, err := mb.ParseFromSlice(testMerkleBlock(h01, 3, [][]byte{h01, txids[2]}, 0x0d)); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := mb.Branch(); err == nil {
+		t.Error("expected a tree not leading to the root to be refused")
+	}
+
+	// No transaction matched.
+	mb = NewMerkleBlock()
+	if _, err := mb.ParseFromSlice(testMerkleBlock(root, 3, [][]byte{root}, 0x00)); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := mb.Branch(); err == nil {
+		t.Error("expected a tree matching nothing to be refused")
+	}
+
+	mb = NewMerkleBlock()
+	if _, err := mb.ParseFromSlice(data[:len(data)-1]); err == nil {
+		t.Error("expected a truncated merkle block to be refused")
+	}
+}
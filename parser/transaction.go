@@ -300,6 +300,47 @@ func (tx *Transaction) Bytes() []byte {
 	return tx.rawBytes
 }
 
+// PrevOutpoint identifies the transaction output spent by a transparent input.
+type PrevOutpoint struct {
+	// Hash of the transaction being spent, in little-endian wire order.
+	TxHash []byte
+	Index  uint32
+}
+
+// PrevOutpoints returns the outputs spent by the transparent inputs.
+func (tx *Transaction) PrevOutpoints() []PrevOutpoint {
+	outpoints := make([]PrevOutpoint, len(tx.transparentInputs))
+	for i, ti := range tx.transparentInputs {
+		outpoints[i] = PrevOutpoint{TxHash: ti.PrevTxHash, Index: ti.PrevTxOutIndex}
+	}
+	return outpoints
+}
+
+// TxOutput is a transparent output.
+type TxOutput struct {
+	Value  uint64
+	Script []byte
+}
+
+// TransparentOutputs returns the transparent outputs, in order.
+func (tx *Transaction) TransparentOutputs() []TxOutput {
+	outputs := make([]TxOutput, len(tx.transparentOutputs))
+	for i, to := range tx.transparentOutputs {
+		outputs[i] = TxOutput{Value: to.Value, Script: to.Script}
+	}
+	return outputs
+}
+
+// GetVersion returns the transaction version, without the fOverwintered flag.
+func (tx *Transaction) GetVersion() uint32 {
+	return tx.version
+}
+
+// GetVersionGroupID returns nVersionGroupId, which is zero before Overwinter.
+func (tx *Transaction) GetVersionGroupID() uint32 {
+	return tx.nVersionGroupId
+}
+
 func (tx *Transaction) HasSaplingTransactions() bool {
 	return tx.version >= 4 && (len(tx.shieldedSpends)+len(tx.shieldedOutputs)) > 0
 }
@@ -398,6 +398,40 @@ JoinSplitLoop:
 	return success
 }
 
+func subTestPrevOutpoints(tx *Transaction, t *testing.T, caseNum int) bool {
+	outpoints := tx.PrevOutpoints()
+	if len(outpoints) != len(tx.transparentInputs) {
+		t.Errorf("Test %d: PrevOutpoints returned %d outpoints for %d inputs", caseNum, len(outpoints), len(tx.transparentInputs))
+		return false
+	}
+
+	for idx, op := range outpoints {
+		ti := tx.transparentInputs[idx]
+		if !bytes.Equal(op.TxHash, ti.PrevTxHash) || op.Index != ti.PrevTxOutIndex {
+			t.Errorf("Test %d tin %d: outpoint mismatch %x:%d", caseNum, idx, op.TxHash, op.Index)
+			return false
+		}
+	}
+	return true
+}
+
+func subTestTransparentOutputAccessor(tx *Transaction, t *testing.T, caseNum int) bool {
+	outputs := tx.TransparentOutputs()
+	if len(outputs) != len(tx.transparentOutputs) {
+		t.Errorf("Test %d: TransparentOutputs returned %d outputs for %d", caseNum, len(outputs), len(tx.transparentOutputs))
+		return false
+	}
+
+	for idx, out := range outputs {
+		to := tx.transparentOutputs[idx]
+		if out.Value != to.Value || !bytes.Equal(out.Script, to.Script) {
+			t.Errorf("Test %d tout %d: output mismatch %d %x", caseNum, idx, out.Value, out.Script)
+			return false
+		}
+	}
+	return true
+}
+
 func subTestTransparentInputs(testInputs [][]string, txInputs []*txIn, t *testing.T, caseNum int) bool {
 	if testInputs == nil && txInputs != nil {
 		t.Errorf("Test %d: non-zero vin when expected zero", caseNum)
@@ -707,9 +741,15 @@ func TestSaplingTransactionParser(t *testing.T) {
 		if ok := subTestTransparentInputs(tt.vin, tx.transparentInputs, t, i); !ok {
 			continue
 		}
+		if ok := subTestPrevOutpoints(tx, t, i); !ok {
+			continue
+		}
 		if ok := subTestTransparentOutputs(tt.vout, tx.transparentOutputs, t, i); !ok {
 			continue
 		}
+		if ok := subTestTransparentOutputAccessor(tx, t, i); !ok {
+			continue
+		}
 
 		// JoinSplits
 		if ok := subTestJoinSplits(tt.vJoinSplits, tx.joinSplits, t, i); !ok {
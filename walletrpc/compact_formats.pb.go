@@ -21,9 +21,9 @@ var _ = math.Inf
 const _ = proto.ProtoPackageIsVersion3 // please upgrade the proto package
 
 // CompactBlock is a packaging of ONLY the data from a block that's needed to:
-//   1. Detect a payment to your shielded Sapling address
-//   2. Detect a spend of your shielded Sapling notes
-//   3. Update your witnesses to generate new Sapling spend proofs.
+//  1. Detect a payment to your shielded Sapling address
+//  2. Detect a spend of your shielded Sapling notes
+//  3. Update your witnesses to generate new Sapling spend proofs.
 type CompactBlock struct {
 	ProtoVersion         uint32       `protobuf:"varint,1,opt,name=protoVersion,proto3" json:"protoVersion,omitempty"`
 	Height               uint64       `protobuf:"varint,2,opt,name=height,proto3" json:"height,omitempty"`
@@ -32,6 +32,9 @@ type CompactBlock struct {
 	Time                 uint32       `protobuf:"varint,5,opt,name=time,proto3" json:"time,omitempty"`
 	Header               []byte       `protobuf:"bytes,6,opt,name=header,proto3" json:"header,omitempty"`
 	Vtx                  []*CompactTx `protobuf:"bytes,7,rep,name=vtx,proto3" json:"vtx,omitempty"`
+	FullBlock            []byte       `protobuf:"bytes,8,opt,name=fullBlock,proto3" json:"fullBlock,omitempty"`
+	Checkpoint           *Checkpoint  `protobuf:"bytes,9,opt,name=checkpoint,proto3" json:"checkpoint,omitempty"`
+	Coinbase             []byte       `protobuf:"bytes,10,opt,name=coinbase,proto3" json:"coinbase,omitempty"`
 	XXX_NoUnkeyedLiteral struct{}     `json:"-"`
 	XXX_unrecognized     []byte       `json:"-"`
 	XXX_sizecache        int32        `json:"-"`
@@ -111,6 +114,27 @@ func (m *CompactBlock) GetVtx() []*CompactTx {
 	return nil
 }
 
+func (m *CompactBlock) GetFullBlock() []byte {
+	if m != nil {
+		return m.FullBlock
+	}
+	return nil
+}
+
+func (m *CompactBlock) GetCheckpoint() *Checkpoint {
+	if m != nil {
+		return m.Checkpoint
+	}
+	return nil
+}
+
+func (m *CompactBlock) GetCoinbase() []byte {
+	if m != nil {
+		return m.Coinbase
+	}
+	return nil
+}
+
 type CompactTx struct {
 	// Index and hash will allow the receiver to call out to chain
 	// explorers or other data structures to retrieve more information
@@ -284,37 +308,100 @@ func (m *CompactOutput) GetCiphertext() []byte {
 	return nil
 }
 
+// Checkpoint identifies a block and the Sapling note commitment tree after it,
+// so that a wallet can start scanning from that block.
+type Checkpoint struct {
+	Height               uint64   `protobuf:"varint,1,opt,name=height,proto3" json:"height,omitempty"`
+	Hash                 []byte   `protobuf:"bytes,2,opt,name=hash,proto3" json:"hash,omitempty"`
+	SaplingTreeRoot      []byte   `protobuf:"bytes,3,opt,name=saplingTreeRoot,proto3" json:"saplingTreeRoot,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Checkpoint) Reset()         { *m = Checkpoint{} }
+func (m *Checkpoint) String() string { return proto.CompactTextString(m) }
+func (*Checkpoint) ProtoMessage()    {}
+func (*Checkpoint) Descriptor() ([]byte, []int) {
+	return fileDescriptor_dce29fee3ee34899, []int{4}
+}
+
+func (m *Checkpoint) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Checkpoint.Unmarshal(m, b)
+}
+func (m *Checkpoint) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Checkpoint.Marshal(b, m, deterministic)
+}
+func (m *Checkpoint) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Checkpoint.Merge(m, src)
+}
+func (m *Checkpoint) XXX_Size() int {
+	return xxx_messageInfo_Checkpoint.Size(m)
+}
+func (m *Checkpoint) XXX_DiscardUnknown() {
+	xxx_messageInfo_Checkpoint.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Checkpoint proto.InternalMessageInfo
+
+func (m *Checkpoint) GetHeight() uint64 {
+	if m != nil {
+		return m.Height
+	}
+	return 0
+}
+
+func (m *Checkpoint) GetHash() []byte {
+	if m != nil {
+		return m.Hash
+	}
+	return nil
+}
+
+func (m *Checkpoint) GetSaplingTreeRoot() []byte {
+	if m != nil {
+		return m.SaplingTreeRoot
+	}
+	return nil
+}
+
 func init() {
 	proto.RegisterType((*CompactBlock)(nil), "cash.z.wallet.sdk.rpc.CompactBlock")
 	proto.RegisterType((*CompactTx)(nil), "cash.z.wallet.sdk.rpc.CompactTx")
 	proto.RegisterType((*CompactSpend)(nil), "cash.z.wallet.sdk.rpc.CompactSpend")
 	proto.RegisterType((*CompactOutput)(nil), "cash.z.wallet.sdk.rpc.CompactOutput")
+	proto.RegisterType((*Checkpoint)(nil), "cash.z.wallet.sdk.rpc.Checkpoint")
 }
 
 func init() { proto.RegisterFile("compact_formats.proto", fileDescriptor_dce29fee3ee34899) }
 
 var fileDescriptor_dce29fee3ee34899 = []byte{
-	// 346 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x7c, 0x51, 0xbf, 0x4e, 0xf3, 0x30,
-	0x1c, 0x54, 0xfe, 0x34, 0xfd, 0xfa, 0x6b, 0xfa, 0x09, 0x59, 0x14, 0x59, 0x0c, 0x55, 0x14, 0x18,
-	0x32, 0x65, 0x28, 0x23, 0x12, 0x43, 0x59, 0xd8, 0x90, 0x5c, 0xc4, 0xc0, 0x82, 0x82, 0xe3, 0x90,
-	0xa8, 0x4d, 0x62, 0xd9, 0x6e, 0x89, 0x78, 0x3e, 0x9e, 0x82, 0xa7, 0x41, 0x76, 0x4c, 0xd4, 0x4a,
-	0xa8, 0xdb, 0xdd, 0xe9, 0xce, 0xbe, 0xd3, 0x0f, 0xe6, 0xb4, 0xad, 0x79, 0x46, 0xd5, 0x6b, 0xd1,
-	0x8a, 0x3a, 0x53, 0x32, 0xe5, 0xa2, 0x55, 0x2d, 0x9a, 0xd3, 0x4c, 0x96, 0xe9, 0x67, 0xfa, 0x91,
-	0x6d, 0xb7, 0x4c, 0xa5, 0x32, 0xdf, 0xa4, 0x82, 0xd3, 0xf8, 0xdb, 0x81, 0xf0, 0xbe, 0x0f, 0xac,
-	0xb6, 0x2d, 0xdd, 0xa0, 0x18, 0x42, 0x13, 0x78, 0x66, 0x42, 0x56, 0x6d, 0x83, 0x9d, 0xc8, 0x49,
-	0x66, 0xe4, 0x48, 0x43, 0x17, 0x10, 0x94, 0xac, 0x7a, 0x2f, 0x15, 0x76, 0x23, 0x27, 0xf1, 0x89,
-	0x65, 0x08, 0x81, 0x5f, 0x66, 0xb2, 0xc4, 0x5e, 0xe4, 0x24, 0x21, 0x31, 0x18, 0x5d, 0xc2, 0x3f,
-	0x2e, 0xd8, 0xfe, 0x41, 0xeb, 0xbe, 0xd1, 0x07, 0xae, 0xfd, 0xaa, 0xaa, 0x19, 0x1e, 0x99, 0x3f,
-	0x0c, 0xee, 0xdf, 0xce, 0x72, 0x26, 0x70, 0x60, 0xdc, 0x96, 0xa1, 0x25, 0x78, 0x7b, 0xd5, 0xe1,
-	0x71, 0xe4, 0x25, 0xd3, 0x65, 0x94, 0xfe, 0xb9, 0x26, 0xb5, 0x4b, 0x9e, 0x3a, 0xa2, 0xcd, 0xf1,
-	0x97, 0x03, 0x93, 0x41, 0x42, 0xe7, 0x30, 0xaa, 0x9a, 0x9c, 0x75, 0x66, 0x92, 0x4f, 0x7a, 0x32,
-	0x74, 0x76, 0x0f, 0x3a, 0x9f, 0x81, 0x57, 0x30, 0x66, 0x66, 0xcc, 0x88, 0x86, 0xe8, 0x16, 0x02,
-	0xc9, 0x59, 0x93, 0x4b, 0xec, 0x9b, 0x02, 0x57, 0xa7, 0x0b, 0xac, 0xb5, 0x97, 0xd8, 0x08, 0xba,
-	0x83, 0x71, 0xbb, 0x53, 0x7c, 0xa7, 0x24, 0x1e, 0x99, 0xf4, 0xf5, 0xe9, 0xf4, 0xa3, 0x31, 0x93,
-	0xdf, 0x50, 0xbc, 0x18, 0x4e, 0x64, 0xde, 0x45, 0xff, 0xc1, 0x6d, 0x0a, 0xb3, 0x22, 0x24, 0x6e,
-	0x53, 0xc4, 0x6b, 0x98, 0x1d, 0x25, 0x75, 0x7f, 0x5a, 0xef, 0xac, 0x43, 0x43, 0xad, 0x30, 0xbe,
-	0xb1, 0x23, 0x35, 0x44, 0x0b, 0x00, 0x5a, 0xf1, 0x92, 0x09, 0xc5, 0x3a, 0x65, 0x2f, 0x76, 0xa0,
-	0xac, 0xa6, 0x2f, 0x93, 0xbe, 0x9d, 0xe0, 0xf4, 0x2d, 0x30, 0xe7, 0xbf, 0xf9, 0x09, 0x00, 0x00,
-	0xff, 0xff, 0x22, 0x92, 0x05, 0x70, 0x5c, 0x02, 0x00, 0x00,
+	// 426 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x7c, 0x52, 0xc1, 0x8e, 0xd3, 0x30,
+	0x14, 0x54, 0xd2, 0xb4, 0xbb, 0x7d, 0x4d, 0x01, 0x59, 0x2c, 0xb2, 0x10, 0x5a, 0x85, 0xc0, 0x21,
+	0xa7, 0x1c, 0xca, 0x11, 0x09, 0x89, 0xe5, 0xc2, 0x0d, 0xc9, 0xbb, 0xe2, 0xc0, 0x05, 0xa5, 0xee,
+	0xcb, 0xc6, 0x6a, 0x6a, 0x5b, 0xb6, 0xbb, 0x54, 0x7c, 0x1f, 0xff, 0xc0, 0xef, 0x20, 0x3b, 0x69,
+	0x36, 0x8b, 0x76, 0x7b, 0x7b, 0x6f, 0x34, 0x33, 0xf6, 0x8c, 0x1e, 0x5c, 0x70, 0xb5, 0xd3, 0x15,
+	0x77, 0x3f, 0x6b, 0x65, 0x76, 0x95, 0xb3, 0xa5, 0x36, 0xca, 0x29, 0x72, 0xc1, 0x2b, 0xdb, 0x94,
+	0xbf, 0xcb, 0x5f, 0x55, 0xdb, 0xa2, 0x2b, 0xed, 0x66, 0x5b, 0x1a, 0xcd, 0xf3, 0xbf, 0x31, 0xa4,
+	0x5f, 0x3a, 0xc1, 0x55, 0xab, 0xf8, 0x96, 0xe4, 0x90, 0x06, 0xc1, 0x77, 0x34, 0x56, 0x28, 0x49,
+	0xa3, 0x2c, 0x2a, 0x96, 0xec, 0x01, 0x46, 0x5e, 0xc1, 0xac, 0x41, 0x71, 0xdb, 0x38, 0x1a, 0x67,
+	0x51, 0x91, 0xb0, 0x7e, 0x23, 0x04, 0x92, 0xa6, 0xb2, 0x0d, 0x9d, 0x64, 0x51, 0x91, 0xb2, 0x30,
+	0x93, 0xd7, 0x70, 0xae, 0x0d, 0xde, 0x7d, 0xf5, 0x78, 0x12, 0xf0, 0x61, 0xf7, 0x7c, 0x27, 0x76,
+	0x48, 0xa7, 0xe1, 0x8d, 0x30, 0x77, 0xde, 0xd5, 0x06, 0x0d, 0x9d, 0x05, 0x76, 0xbf, 0x91, 0x15,
+	0x4c, 0xee, 0xdc, 0x81, 0x9e, 0x65, 0x93, 0x62, 0xb1, 0xca, 0xca, 0x47, 0xd3, 0x94, 0x7d, 0x92,
+	0x9b, 0x03, 0xf3, 0x64, 0xf2, 0x06, 0xe6, 0xf5, 0xbe, 0x6d, 0x43, 0x30, 0x7a, 0x1e, 0xec, 0xee,
+	0x01, 0xf2, 0x19, 0x80, 0x37, 0xc8, 0xb7, 0x5a, 0x09, 0xe9, 0xe8, 0x3c, 0x8b, 0x8a, 0xc5, 0xea,
+	0xed, 0x53, 0xc6, 0x03, 0x91, 0x8d, 0x44, 0x3e, 0x1c, 0x57, 0x42, 0xae, 0x2b, 0x8b, 0x14, 0xba,
+	0x70, 0xc7, 0x3d, 0xff, 0x13, 0xc1, 0x7c, 0xf8, 0x0f, 0x79, 0x09, 0x53, 0x21, 0x37, 0x78, 0x08,
+	0x7d, 0x26, 0xac, 0x5b, 0x86, 0xc2, 0xe2, 0x51, 0x61, 0x2f, 0x60, 0x52, 0x23, 0x86, 0x0e, 0x97,
+	0xcc, 0x8f, 0xe4, 0x23, 0xcc, 0xac, 0x46, 0xb9, 0xb1, 0x34, 0x09, 0xe9, 0xdf, 0x9d, 0x4e, 0x7f,
+	0xed, 0xb9, 0xac, 0x97, 0x90, 0x4f, 0x70, 0xa6, 0xf6, 0x4e, 0xef, 0x9d, 0xa5, 0xd3, 0xa0, 0x7e,
+	0x7f, 0x5a, 0xfd, 0x2d, 0x90, 0xd9, 0x51, 0x94, 0x5f, 0x42, 0x3a, 0xf6, 0x25, 0xcf, 0x20, 0x96,
+	0x75, 0x48, 0x91, 0xb2, 0x58, 0xd6, 0xf9, 0x35, 0x2c, 0x1f, 0x28, 0xfd, 0xff, 0xf9, 0x6e, 0xdf,
+	0x33, 0xfc, 0xe8, 0x11, 0xd4, 0xdb, 0x3e, 0xa4, 0x1f, 0xc9, 0x25, 0x00, 0x17, 0xba, 0x41, 0xe3,
+	0xf0, 0xe0, 0xfa, 0x73, 0x19, 0x21, 0xf9, 0x1a, 0xe0, 0xbe, 0xf1, 0xd1, 0xb9, 0x45, 0x8f, 0x9e,
+	0xdb, 0xb8, 0xbd, 0x02, 0x9e, 0xdb, 0x4a, 0xb7, 0x42, 0xde, 0xde, 0x18, 0x44, 0xa6, 0xd4, 0xd1,
+	0xfe, 0x7f, 0xf8, 0x6a, 0xf1, 0x63, 0xde, 0x35, 0x60, 0x34, 0x5f, 0xcf, 0xc2, 0x7d, 0x7f, 0xf8,
+	0x37, 0x00, 0x2c, 0x27, 0xe9, 0x75, 0x3d, 0x03, 0x00, 0x00,
 }
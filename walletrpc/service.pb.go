@@ -24,11 +24,96 @@ var _ = math.Inf
 // proto package needs to be updated.
 const _ = proto.ProtoPackageIsVersion3 // please upgrade the proto package
 
+type ShieldedProtocol int32
+
+const (
+	ShieldedProtocol_sapling ShieldedProtocol = 0
+	ShieldedProtocol_orchard ShieldedProtocol = 1
+)
+
+var ShieldedProtocol_name = map[int32]string{
+	0: "sapling",
+	1: "orchard",
+}
+
+var ShieldedProtocol_value = map[string]int32{
+	"sapling": 0,
+	"orchard": 1,
+}
+
+func (x ShieldedProtocol) String() string {
+	return proto.EnumName(ShieldedProtocol_name, int32(x))
+}
+
+func (ShieldedProtocol) EnumDescriptor() ([]byte, []int) {
+	return fileDescriptor_a0b84a42fa06f626, []int{0}
+}
+
+type TxStatus_Status int32
+
+const (
+	TxStatus_UNKNOWN    TxStatus_Status = 0
+	TxStatus_IN_MEMPOOL TxStatus_Status = 1
+	TxStatus_MINED      TxStatus_Status = 2
+	TxStatus_CONFLICTED TxStatus_Status = 3
+)
+
+var TxStatus_Status_name = map[int32]string{
+	0: "UNKNOWN",
+	1: "IN_MEMPOOL",
+	2: "MINED",
+	3: "CONFLICTED",
+}
+
+var TxStatus_Status_value = map[string]int32{
+	"UNKNOWN":    0,
+	"IN_MEMPOOL": 1,
+	"MINED":      2,
+	"CONFLICTED": 3,
+}
+
+func (x TxStatus_Status) String() string {
+	return proto.EnumName(TxStatus_Status_name, int32(x))
+}
+
+func (TxStatus_Status) EnumDescriptor() ([]byte, []int) {
+	return fileDescriptor_a0b84a42fa06f626, []int{10, 0}
+}
+
+type BalanceHistoryArg_Granularity int32
+
+const (
+	BalanceHistoryArg_BLOCK BalanceHistoryArg_Granularity = 0
+	BalanceHistoryArg_DAY   BalanceHistoryArg_Granularity = 1
+)
+
+var BalanceHistoryArg_Granularity_name = map[int32]string{
+	0: "BLOCK",
+	1: "DAY",
+}
+
+var BalanceHistoryArg_Granularity_value = map[string]int32{
+	"BLOCK": 0,
+	"DAY":   1,
+}
+
+func (x BalanceHistoryArg_Granularity) String() string {
+	return proto.EnumName(BalanceHistoryArg_Granularity_name, int32(x))
+}
+
+func (BalanceHistoryArg_Granularity) EnumDescriptor() ([]byte, []int) {
+	return fileDescriptor_a0b84a42fa06f626, []int{34, 0}
+}
+
 // A BlockID message contains identifiers to select a block: a height or a
-// hash. If the hash is present it takes precedence.
+// hash. If the hash is present it takes precedence. The hash is in
+// little-endian wire order, as in CompactBlock, and GetBlock only finds
+// blocks on the best chain by it.
 type BlockID struct {
 	Height               uint64   `protobuf:"varint,1,opt,name=height,proto3" json:"height,omitempty"`
 	Hash                 []byte   `protobuf:"bytes,2,opt,name=hash,proto3" json:"hash,omitempty"`
+	IncludeFull          bool     `protobuf:"varint,3,opt,name=includeFull,proto3" json:"includeFull,omitempty"`
+	IncludeCoinbase      bool     `protobuf:"varint,4,opt,name=includeCoinbase,proto3" json:"includeCoinbase,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -73,12 +158,31 @@ func (m *BlockID) GetHash() []byte {
 	return nil
 }
 
+func (m *BlockID) GetIncludeFull() bool {
+	if m != nil {
+		return m.IncludeFull
+	}
+	return false
+}
+
+func (m *BlockID) GetIncludeCoinbase() bool {
+	if m != nil {
+		return m.IncludeCoinbase
+	}
+	return false
+}
+
 // BlockRange technically allows ranging from hash to hash etc but this is not
 // currently intended for support, though there is no reason you couldn't do
-// it. Further permutations are left as an exercise.
+// it. Further permutations are left as an exercise. A range whose end is
+// below its start is streamed from the start down to the end.
 type BlockRange struct {
 	Start                *BlockID `protobuf:"bytes,1,opt,name=start,proto3" json:"start,omitempty"`
 	End                  *BlockID `protobuf:"bytes,2,opt,name=end,proto3" json:"end,omitempty"`
+	CheckpointInterval   uint64   `protobuf:"varint,3,opt,name=checkpointInterval,proto3" json:"checkpointInterval,omitempty"`
+	Follow               bool     `protobuf:"varint,4,opt,name=follow,proto3" json:"follow,omitempty"`
+	IncludeCoinbase      bool     `protobuf:"varint,5,opt,name=includeCoinbase,proto3" json:"includeCoinbase,omitempty"`
+	SkipEmpty            bool     `protobuf:"varint,6,opt,name=skipEmpty,proto3" json:"skipEmpty,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -123,6 +227,280 @@ func (m *BlockRange) GetEnd() *BlockID {
 	return nil
 }
 
+func (m *BlockRange) GetCheckpointInterval() uint64 {
+	if m != nil {
+		return m.CheckpointInterval
+	}
+	return 0
+}
+
+func (m *BlockRange) GetFollow() bool {
+	if m != nil {
+		return m.Follow
+	}
+	return false
+}
+
+func (m *BlockRange) GetIncludeCoinbase() bool {
+	if m != nil {
+		return m.IncludeCoinbase
+	}
+	return false
+}
+
+func (m *BlockRange) GetSkipEmpty() bool {
+	if m != nil {
+		return m.SkipEmpty
+	}
+	return false
+}
+
+// BlockHeader is what GetBlockHeaders streams of each block: enough to check
+// that the blocks link up, without their transactions.
+type BlockHeader struct {
+	Height               uint64   `protobuf:"varint,1,opt,name=height,proto3" json:"height,omitempty"`
+	Hash                 []byte   `protobuf:"bytes,2,opt,name=hash,proto3" json:"hash,omitempty"`
+	PrevHash             []byte   `protobuf:"bytes,3,opt,name=prevHash,proto3" json:"prevHash,omitempty"`
+	Time                 uint32   `protobuf:"varint,4,opt,name=time,proto3" json:"time,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *BlockHeader) Reset()         { *m = BlockHeader{} }
+func (m *BlockHeader) String() string { return proto.CompactTextString(m) }
+func (*BlockHeader) ProtoMessage()    {}
+func (*BlockHeader) Descriptor() ([]byte, []int) {
+	return fileDescriptor_a0b84a42fa06f626, []int{2}
+}
+
+func (m *BlockHeader) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_BlockHeader.Unmarshal(m, b)
+}
+func (m *BlockHeader) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_BlockHeader.Marshal(b, m, deterministic)
+}
+func (m *BlockHeader) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_BlockHeader.Merge(m, src)
+}
+func (m *BlockHeader) XXX_Size() int {
+	return xxx_messageInfo_BlockHeader.Size(m)
+}
+func (m *BlockHeader) XXX_DiscardUnknown() {
+	xxx_messageInfo_BlockHeader.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_BlockHeader proto.InternalMessageInfo
+
+func (m *BlockHeader) GetHeight() uint64 {
+	if m != nil {
+		return m.Height
+	}
+	return 0
+}
+
+func (m *BlockHeader) GetHash() []byte {
+	if m != nil {
+		return m.Hash
+	}
+	return nil
+}
+
+func (m *BlockHeader) GetPrevHash() []byte {
+	if m != nil {
+		return m.PrevHash
+	}
+	return nil
+}
+
+func (m *BlockHeader) GetTime() uint32 {
+	if m != nil {
+		return m.Time
+	}
+	return 0
+}
+
+// SubscribeBlocksArg asks SubscribeBlocks to send each new block as well
+// as its height and hash.
+type SubscribeBlocksArg struct {
+	IncludeBlocks        bool     `protobuf:"varint,1,opt,name=includeBlocks,proto3" json:"includeBlocks,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *SubscribeBlocksArg) Reset()         { *m = SubscribeBlocksArg{} }
+func (m *SubscribeBlocksArg) String() string { return proto.CompactTextString(m) }
+func (*SubscribeBlocksArg) ProtoMessage()    {}
+func (*SubscribeBlocksArg) Descriptor() ([]byte, []int) {
+	return fileDescriptor_a0b84a42fa06f626, []int{3}
+}
+
+func (m *SubscribeBlocksArg) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_SubscribeBlocksArg.Unmarshal(m, b)
+}
+func (m *SubscribeBlocksArg) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_SubscribeBlocksArg.Marshal(b, m, deterministic)
+}
+func (m *SubscribeBlocksArg) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_SubscribeBlocksArg.Merge(m, src)
+}
+func (m *SubscribeBlocksArg) XXX_Size() int {
+	return xxx_messageInfo_SubscribeBlocksArg.Size(m)
+}
+func (m *SubscribeBlocksArg) XXX_DiscardUnknown() {
+	xxx_messageInfo_SubscribeBlocksArg.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_SubscribeBlocksArg proto.InternalMessageInfo
+
+func (m *SubscribeBlocksArg) GetIncludeBlocks() bool {
+	if m != nil {
+		return m.IncludeBlocks
+	}
+	return false
+}
+
+// BlockUpdate is what SubscribeBlocks sends: a new tip, or, without one, a
+// keepalive ping.
+type BlockUpdate struct {
+	Tip                  *BlockID      `protobuf:"bytes,1,opt,name=tip,proto3" json:"tip,omitempty"`
+	Block                *CompactBlock `protobuf:"bytes,2,opt,name=block,proto3" json:"block,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}      `json:"-"`
+	XXX_unrecognized     []byte        `json:"-"`
+	XXX_sizecache        int32         `json:"-"`
+}
+
+func (m *BlockUpdate) Reset()         { *m = BlockUpdate{} }
+func (m *BlockUpdate) String() string { return proto.CompactTextString(m) }
+func (*BlockUpdate) ProtoMessage()    {}
+func (*BlockUpdate) Descriptor() ([]byte, []int) {
+	return fileDescriptor_a0b84a42fa06f626, []int{4}
+}
+
+func (m *BlockUpdate) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_BlockUpdate.Unmarshal(m, b)
+}
+func (m *BlockUpdate) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_BlockUpdate.Marshal(b, m, deterministic)
+}
+func (m *BlockUpdate) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_BlockUpdate.Merge(m, src)
+}
+func (m *BlockUpdate) XXX_Size() int {
+	return xxx_messageInfo_BlockUpdate.Size(m)
+}
+func (m *BlockUpdate) XXX_DiscardUnknown() {
+	xxx_messageInfo_BlockUpdate.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_BlockUpdate proto.InternalMessageInfo
+
+func (m *BlockUpdate) GetTip() *BlockID {
+	if m != nil {
+		return m.Tip
+	}
+	return nil
+}
+
+func (m *BlockUpdate) GetBlock() *CompactBlock {
+	if m != nil {
+		return m.Block
+	}
+	return nil
+}
+
+// ReorgEvent is what SubscribeReorgs sends: the height the server rolled
+// back to, above which wallets should drop what they learned, and the first
+// block of the new chain on top of it; or, without a new tip, a keepalive
+// ping.
+type ReorgEvent struct {
+	AncestorHeight       uint64   `protobuf:"varint,1,opt,name=ancestorHeight,proto3" json:"ancestorHeight,omitempty"`
+	NewTip               *BlockID `protobuf:"bytes,2,opt,name=newTip,proto3" json:"newTip,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ReorgEvent) Reset()         { *m = ReorgEvent{} }
+func (m *ReorgEvent) String() string { return proto.CompactTextString(m) }
+func (*ReorgEvent) ProtoMessage()    {}
+func (*ReorgEvent) Descriptor() ([]byte, []int) {
+	return fileDescriptor_a0b84a42fa06f626, []int{5}
+}
+
+func (m *ReorgEvent) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ReorgEvent.Unmarshal(m, b)
+}
+func (m *ReorgEvent) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ReorgEvent.Marshal(b, m, deterministic)
+}
+func (m *ReorgEvent) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ReorgEvent.Merge(m, src)
+}
+func (m *ReorgEvent) XXX_Size() int {
+	return xxx_messageInfo_ReorgEvent.Size(m)
+}
+func (m *ReorgEvent) XXX_DiscardUnknown() {
+	xxx_messageInfo_ReorgEvent.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ReorgEvent proto.InternalMessageInfo
+
+func (m *ReorgEvent) GetAncestorHeight() uint64 {
+	if m != nil {
+		return m.AncestorHeight
+	}
+	return 0
+}
+
+func (m *ReorgEvent) GetNewTip() *BlockID {
+	if m != nil {
+		return m.NewTip
+	}
+	return nil
+}
+
+// BlockTimeArg is a Unix time, in seconds.
+type BlockTimeArg struct {
+	Time                 uint32   `protobuf:"varint,1,opt,name=time,proto3" json:"time,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *BlockTimeArg) Reset()         { *m = BlockTimeArg{} }
+func (m *BlockTimeArg) String() string { return proto.CompactTextString(m) }
+func (*BlockTimeArg) ProtoMessage()    {}
+func (*BlockTimeArg) Descriptor() ([]byte, []int) {
+	return fileDescriptor_a0b84a42fa06f626, []int{6}
+}
+
+func (m *BlockTimeArg) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_BlockTimeArg.Unmarshal(m, b)
+}
+func (m *BlockTimeArg) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_BlockTimeArg.Marshal(b, m, deterministic)
+}
+func (m *BlockTimeArg) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_BlockTimeArg.Merge(m, src)
+}
+func (m *BlockTimeArg) XXX_Size() int {
+	return xxx_messageInfo_BlockTimeArg.Size(m)
+}
+func (m *BlockTimeArg) XXX_DiscardUnknown() {
+	xxx_messageInfo_BlockTimeArg.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_BlockTimeArg proto.InternalMessageInfo
+
+func (m *BlockTimeArg) GetTime() uint32 {
+	if m != nil {
+		return m.Time
+	}
+	return 0
+}
+
 // A TxFilter contains the information needed to identify a particular
 // transaction: either a block and an index, or a direct transaction hash.
 type TxFilter struct {
@@ -138,7 +516,7 @@ func (m *TxFilter) Reset()         { *m = TxFilter{} }
 func (m *TxFilter) String() string { return proto.CompactTextString(m) }
 func (*TxFilter) ProtoMessage()    {}
 func (*TxFilter) Descriptor() ([]byte, []int) {
-	return fileDescriptor_a0b84a42fa06f626, []int{2}
+	return fileDescriptor_a0b84a42fa06f626, []int{7}
 }
 
 func (m *TxFilter) XXX_Unmarshal(b []byte) error {
@@ -194,7 +572,7 @@ func (m *RawTransaction) Reset()         { *m = RawTransaction{} }
 func (m *RawTransaction) String() string { return proto.CompactTextString(m) }
 func (*RawTransaction) ProtoMessage()    {}
 func (*RawTransaction) Descriptor() ([]byte, []int) {
-	return fileDescriptor_a0b84a42fa06f626, []int{3}
+	return fileDescriptor_a0b84a42fa06f626, []int{8}
 }
 
 func (m *RawTransaction) XXX_Unmarshal(b []byte) error {
@@ -241,7 +619,7 @@ func (m *SendResponse) Reset()         { *m = SendResponse{} }
 func (m *SendResponse) String() string { return proto.CompactTextString(m) }
 func (*SendResponse) ProtoMessage()    {}
 func (*SendResponse) Descriptor() ([]byte, []int) {
-	return fileDescriptor_a0b84a42fa06f626, []int{4}
+	return fileDescriptor_a0b84a42fa06f626, []int{9}
 }
 
 func (m *SendResponse) XXX_Unmarshal(b []byte) error {
@@ -276,359 +654,2301 @@ func (m *SendResponse) GetErrorMessage() string {
 	return ""
 }
 
-// Empty placeholder. Someday we may want to specify e.g. a particular chain fork.
-type ChainSpec struct {
-	XXX_NoUnkeyedLiteral struct{} `json:"-"`
-	XXX_unrecognized     []byte   `json:"-"`
-	XXX_sizecache        int32    `json:"-"`
+// TxStatus is what became of a transaction, as far as the node knows.
+type TxStatus struct {
+	Status               TxStatus_Status `protobuf:"varint,1,opt,name=status,proto3,enum=cash.z.wallet.sdk.rpc.TxStatus_Status" json:"status,omitempty"`
+	Height               uint64          `protobuf:"varint,2,opt,name=height,proto3" json:"height,omitempty"`
+	Confirmations        uint64          `protobuf:"varint,3,opt,name=confirmations,proto3" json:"confirmations,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}        `json:"-"`
+	XXX_unrecognized     []byte          `json:"-"`
+	XXX_sizecache        int32           `json:"-"`
 }
 
-func (m *ChainSpec) Reset()         { *m = ChainSpec{} }
-func (m *ChainSpec) String() string { return proto.CompactTextString(m) }
-func (*ChainSpec) ProtoMessage()    {}
-func (*ChainSpec) Descriptor() ([]byte, []int) {
-	return fileDescriptor_a0b84a42fa06f626, []int{5}
+func (m *TxStatus) Reset()         { *m = TxStatus{} }
+func (m *TxStatus) String() string { return proto.CompactTextString(m) }
+func (*TxStatus) ProtoMessage()    {}
+func (*TxStatus) Descriptor() ([]byte, []int) {
+	return fileDescriptor_a0b84a42fa06f626, []int{10}
 }
 
-func (m *ChainSpec) XXX_Unmarshal(b []byte) error {
-	return xxx_messageInfo_ChainSpec.Unmarshal(m, b)
+func (m *TxStatus) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_TxStatus.Unmarshal(m, b)
 }
-func (m *ChainSpec) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
-	return xxx_messageInfo_ChainSpec.Marshal(b, m, deterministic)
+func (m *TxStatus) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_TxStatus.Marshal(b, m, deterministic)
 }
-func (m *ChainSpec) XXX_Merge(src proto.Message) {
-	xxx_messageInfo_ChainSpec.Merge(m, src)
+func (m *TxStatus) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_TxStatus.Merge(m, src)
 }
-func (m *ChainSpec) XXX_Size() int {
-	return xxx_messageInfo_ChainSpec.Size(m)
+func (m *TxStatus) XXX_Size() int {
+	return xxx_messageInfo_TxStatus.Size(m)
 }
-func (m *ChainSpec) XXX_DiscardUnknown() {
-	xxx_messageInfo_ChainSpec.DiscardUnknown(m)
+func (m *TxStatus) XXX_DiscardUnknown() {
+	xxx_messageInfo_TxStatus.DiscardUnknown(m)
 }
 
-var xxx_messageInfo_ChainSpec proto.InternalMessageInfo
+var xxx_messageInfo_TxStatus proto.InternalMessageInfo
 
-type Empty struct {
+func (m *TxStatus) GetStatus() TxStatus_Status {
+	if m != nil {
+		return m.Status
+	}
+	return TxStatus_UNKNOWN
+}
+
+func (m *TxStatus) GetHeight() uint64 {
+	if m != nil {
+		return m.Height
+	}
+	return 0
+}
+
+func (m *TxStatus) GetConfirmations() uint64 {
+	if m != nil {
+		return m.Confirmations
+	}
+	return 0
+}
+
+// TxProof proves that a block includes a transaction. Hashing the txid, in
+// internal byte order, with each hash of the branch in turn, as SHA-256d of
+// the left then the right one, gives the Merkle root in the header. The bits
+// of index, lowest first, tell whether the branch hash is on the left (1)
+// or the right (0).
+type TxProof struct {
+	Header               []byte   `protobuf:"bytes,1,opt,name=header,proto3" json:"header,omitempty"`
+	Height               uint64   `protobuf:"varint,2,opt,name=height,proto3" json:"height,omitempty"`
+	Index                uint32   `protobuf:"varint,3,opt,name=index,proto3" json:"index,omitempty"`
+	Branch               [][]byte `protobuf:"bytes,4,rep,name=branch,proto3" json:"branch,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
 }
 
-func (m *Empty) Reset()         { *m = Empty{} }
-func (m *Empty) String() string { return proto.CompactTextString(m) }
-func (*Empty) ProtoMessage()    {}
-func (*Empty) Descriptor() ([]byte, []int) {
-	return fileDescriptor_a0b84a42fa06f626, []int{6}
+func (m *TxProof) Reset()         { *m = TxProof{} }
+func (m *TxProof) String() string { return proto.CompactTextString(m) }
+func (*TxProof) ProtoMessage()    {}
+func (*TxProof) Descriptor() ([]byte, []int) {
+	return fileDescriptor_a0b84a42fa06f626, []int{11}
 }
 
-func (m *Empty) XXX_Unmarshal(b []byte) error {
-	return xxx_messageInfo_Empty.Unmarshal(m, b)
+func (m *TxProof) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_TxProof.Unmarshal(m, b)
 }
-func (m *Empty) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
-	return xxx_messageInfo_Empty.Marshal(b, m, deterministic)
+func (m *TxProof) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_TxProof.Marshal(b, m, deterministic)
 }
-func (m *Empty) XXX_Merge(src proto.Message) {
-	xxx_messageInfo_Empty.Merge(m, src)
+func (m *TxProof) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_TxProof.Merge(m, src)
 }
-func (m *Empty) XXX_Size() int {
-	return xxx_messageInfo_Empty.Size(m)
+func (m *TxProof) XXX_Size() int {
+	return xxx_messageInfo_TxProof.Size(m)
 }
-func (m *Empty) XXX_DiscardUnknown() {
-	xxx_messageInfo_Empty.DiscardUnknown(m)
+func (m *TxProof) XXX_DiscardUnknown() {
+	xxx_messageInfo_TxProof.DiscardUnknown(m)
 }
 
-var xxx_messageInfo_Empty proto.InternalMessageInfo
+var xxx_messageInfo_TxProof proto.InternalMessageInfo
 
-type LightdInfo struct {
-	Version                 string   `protobuf:"bytes,1,opt,name=version,proto3" json:"version,omitempty"`
-	Vendor                  string   `protobuf:"bytes,2,opt,name=vendor,proto3" json:"vendor,omitempty"`
-	TaddrSupport            bool     `protobuf:"varint,3,opt,name=taddrSupport,proto3" json:"taddrSupport,omitempty"`
-	ChainName               string   `protobuf:"bytes,4,opt,name=chainName,proto3" json:"chainName,omitempty"`
-	SaplingActivationHeight uint64   `protobuf:"varint,5,opt,name=saplingActivationHeight,proto3" json:"saplingActivationHeight,omitempty"`
-	ConsensusBranchId       string   `protobuf:"bytes,6,opt,name=consensusBranchId,proto3" json:"consensusBranchId,omitempty"`
-	BlockHeight             uint64   `protobuf:"varint,7,opt,name=blockHeight,proto3" json:"blockHeight,omitempty"`
-	XXX_NoUnkeyedLiteral    struct{} `json:"-"`
-	XXX_unrecognized        []byte   `json:"-"`
-	XXX_sizecache           int32    `json:"-"`
+func (m *TxProof) GetHeader() []byte {
+	if m != nil {
+		return m.Header
+	}
+	return nil
 }
 
-func (m *LightdInfo) Reset()         { *m = LightdInfo{} }
-func (m *LightdInfo) String() string { return proto.CompactTextString(m) }
-func (*LightdInfo) ProtoMessage()    {}
-func (*LightdInfo) Descriptor() ([]byte, []int) {
-	return fileDescriptor_a0b84a42fa06f626, []int{7}
+func (m *TxProof) GetHeight() uint64 {
+	if m != nil {
+		return m.Height
+	}
+	return 0
 }
 
-func (m *LightdInfo) XXX_Unmarshal(b []byte) error {
-	return xxx_messageInfo_LightdInfo.Unmarshal(m, b)
+func (m *TxProof) GetIndex() uint32 {
+	if m != nil {
+		return m.Index
+	}
+	return 0
 }
-func (m *LightdInfo) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
-	return xxx_messageInfo_LightdInfo.Marshal(b, m, deterministic)
+
+func (m *TxProof) GetBranch() [][]byte {
+	if m != nil {
+		return m.Branch
+	}
+	return nil
 }
-func (m *LightdInfo) XXX_Merge(src proto.Message) {
-	xxx_messageInfo_LightdInfo.Merge(m, src)
+
+// TreeState is the Sapling note commitment tree as of a block, which a
+// wallet needs to spend notes it found from there on.
+type TreeState struct {
+	Network              string   `protobuf:"bytes,1,opt,name=network,proto3" json:"network,omitempty"`
+	Height               uint64   `protobuf:"varint,2,opt,name=height,proto3" json:"height,omitempty"`
+	Hash                 string   `protobuf:"bytes,3,opt,name=hash,proto3" json:"hash,omitempty"`
+	Time                 uint32   `protobuf:"varint,4,opt,name=time,proto3" json:"time,omitempty"`
+	Tree                 string   `protobuf:"bytes,5,opt,name=tree,proto3" json:"tree,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
 }
-func (m *LightdInfo) XXX_Size() int {
-	return xxx_messageInfo_LightdInfo.Size(m)
+
+func (m *TreeState) Reset()         { *m = TreeState{} }
+func (m *TreeState) String() string { return proto.CompactTextString(m) }
+func (*TreeState) ProtoMessage()    {}
+func (*TreeState) Descriptor() ([]byte, []int) {
+	return fileDescriptor_a0b84a42fa06f626, []int{12}
 }
-func (m *LightdInfo) XXX_DiscardUnknown() {
-	xxx_messageInfo_LightdInfo.DiscardUnknown(m)
+
+func (m *TreeState) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_TreeState.Unmarshal(m, b)
+}
+func (m *TreeState) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_TreeState.Marshal(b, m, deterministic)
+}
+func (m *TreeState) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_TreeState.Merge(m, src)
+}
+func (m *TreeState) XXX_Size() int {
+	return xxx_messageInfo_TreeState.Size(m)
+}
+func (m *TreeState) XXX_DiscardUnknown() {
+	xxx_messageInfo_TreeState.DiscardUnknown(m)
 }
 
-var xxx_messageInfo_LightdInfo proto.InternalMessageInfo
+var xxx_messageInfo_TreeState proto.InternalMessageInfo
 
-func (m *LightdInfo) GetVersion() string {
+func (m *TreeState) GetNetwork() string {
 	if m != nil {
-		return m.Version
+		return m.Network
 	}
 	return ""
 }
 
-func (m *LightdInfo) GetVendor() string {
+func (m *TreeState) GetHeight() uint64 {
 	if m != nil {
-		return m.Vendor
+		return m.Height
+	}
+	return 0
+}
+
+func (m *TreeState) GetHash() string {
+	if m != nil {
+		return m.Hash
 	}
 	return ""
 }
 
-func (m *LightdInfo) GetTaddrSupport() bool {
+func (m *TreeState) GetTime() uint32 {
 	if m != nil {
-		return m.TaddrSupport
+		return m.Time
 	}
-	return false
+	return 0
 }
 
-func (m *LightdInfo) GetChainName() string {
+func (m *TreeState) GetTree() string {
 	if m != nil {
-		return m.ChainName
+		return m.Tree
 	}
 	return ""
 }
 
-func (m *LightdInfo) GetSaplingActivationHeight() uint64 {
+// GetSubtreeRootsArg asks for the roots of the completed note commitment
+// subtrees of a pool from startIndex on, at most maxEntries (0 for all).
+type GetSubtreeRootsArg struct {
+	StartIndex           uint32           `protobuf:"varint,1,opt,name=startIndex,proto3" json:"startIndex,omitempty"`
+	ShieldedProtocol     ShieldedProtocol `protobuf:"varint,2,opt,name=shieldedProtocol,proto3,enum=cash.z.wallet.sdk.rpc.ShieldedProtocol" json:"shieldedProtocol,omitempty"`
+	MaxEntries           uint32           `protobuf:"varint,3,opt,name=maxEntries,proto3" json:"maxEntries,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}         `json:"-"`
+	XXX_unrecognized     []byte           `json:"-"`
+	XXX_sizecache        int32            `json:"-"`
+}
+
+func (m *GetSubtreeRootsArg) Reset()         { *m = GetSubtreeRootsArg{} }
+func (m *GetSubtreeRootsArg) String() string { return proto.CompactTextString(m) }
+func (*GetSubtreeRootsArg) ProtoMessage()    {}
+func (*GetSubtreeRootsArg) Descriptor() ([]byte, []int) {
+	return fileDescriptor_a0b84a42fa06f626, []int{13}
+}
+
+func (m *GetSubtreeRootsArg) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_GetSubtreeRootsArg.Unmarshal(m, b)
+}
+func (m *GetSubtreeRootsArg) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_GetSubtreeRootsArg.Marshal(b, m, deterministic)
+}
+func (m *GetSubtreeRootsArg) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_GetSubtreeRootsArg.Merge(m, src)
+}
+func (m *GetSubtreeRootsArg) XXX_Size() int {
+	return xxx_messageInfo_GetSubtreeRootsArg.Size(m)
+}
+func (m *GetSubtreeRootsArg) XXX_DiscardUnknown() {
+	xxx_messageInfo_GetSubtreeRootsArg.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_GetSubtreeRootsArg proto.InternalMessageInfo
+
+func (m *GetSubtreeRootsArg) GetStartIndex() uint32 {
 	if m != nil {
-		return m.SaplingActivationHeight
+		return m.StartIndex
 	}
 	return 0
 }
 
-func (m *LightdInfo) GetConsensusBranchId() string {
+func (m *GetSubtreeRootsArg) GetShieldedProtocol() ShieldedProtocol {
 	if m != nil {
-		return m.ConsensusBranchId
+		return m.ShieldedProtocol
 	}
-	return ""
+	return ShieldedProtocol_sapling
 }
 
-func (m *LightdInfo) GetBlockHeight() uint64 {
+func (m *GetSubtreeRootsArg) GetMaxEntries() uint32 {
 	if m != nil {
-		return m.BlockHeight
+		return m.MaxEntries
 	}
 	return 0
 }
 
-type TransparentAddress struct {
-	Address              string   `protobuf:"bytes,1,opt,name=address,proto3" json:"address,omitempty"`
+// SubtreeRoot is the root of a completed subtree of 2^16 note commitments,
+// and the block that completed it.
+type SubtreeRoot struct {
+	RootHash              []byte   `protobuf:"bytes,2,opt,name=rootHash,proto3" json:"rootHash,omitempty"`
+	CompletingBlockHash   []byte   `protobuf:"bytes,3,opt,name=completingBlockHash,proto3" json:"completingBlockHash,omitempty"`
+	CompletingBlockHeight uint64   `protobuf:"varint,4,opt,name=completingBlockHeight,proto3" json:"completingBlockHeight,omitempty"`
+	XXX_NoUnkeyedLiteral  struct{} `json:"-"`
+	XXX_unrecognized      []byte   `json:"-"`
+	XXX_sizecache         int32    `json:"-"`
+}
+
+func (m *SubtreeRoot) Reset()         { *m = SubtreeRoot{} }
+func (m *SubtreeRoot) String() string { return proto.CompactTextString(m) }
+func (*SubtreeRoot) ProtoMessage()    {}
+func (*SubtreeRoot) Descriptor() ([]byte, []int) {
+	return fileDescriptor_a0b84a42fa06f626, []int{14}
+}
+
+func (m *SubtreeRoot) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_SubtreeRoot.Unmarshal(m, b)
+}
+func (m *SubtreeRoot) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_SubtreeRoot.Marshal(b, m, deterministic)
+}
+func (m *SubtreeRoot) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_SubtreeRoot.Merge(m, src)
+}
+func (m *SubtreeRoot) XXX_Size() int {
+	return xxx_messageInfo_SubtreeRoot.Size(m)
+}
+func (m *SubtreeRoot) XXX_DiscardUnknown() {
+	xxx_messageInfo_SubtreeRoot.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_SubtreeRoot proto.InternalMessageInfo
+
+func (m *SubtreeRoot) GetRootHash() []byte {
+	if m != nil {
+		return m.RootHash
+	}
+	return nil
+}
+
+func (m *SubtreeRoot) GetCompletingBlockHash() []byte {
+	if m != nil {
+		return m.CompletingBlockHash
+	}
+	return nil
+}
+
+func (m *SubtreeRoot) GetCompletingBlockHeight() uint64 {
+	if m != nil {
+		return m.CompletingBlockHeight
+	}
+	return 0
+}
+
+// Empty placeholder. Someday we may want to specify e.g. a particular chain fork.
+type ChainSpec struct {
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
 }
 
-func (m *TransparentAddress) Reset()         { *m = TransparentAddress{} }
-func (m *TransparentAddress) String() string { return proto.CompactTextString(m) }
-func (*TransparentAddress) ProtoMessage()    {}
-func (*TransparentAddress) Descriptor() ([]byte, []int) {
-	return fileDescriptor_a0b84a42fa06f626, []int{8}
+func (m *ChainSpec) Reset()         { *m = ChainSpec{} }
+func (m *ChainSpec) String() string { return proto.CompactTextString(m) }
+func (*ChainSpec) ProtoMessage()    {}
+func (*ChainSpec) Descriptor() ([]byte, []int) {
+	return fileDescriptor_a0b84a42fa06f626, []int{15}
 }
 
-func (m *TransparentAddress) XXX_Unmarshal(b []byte) error {
-	return xxx_messageInfo_TransparentAddress.Unmarshal(m, b)
+func (m *ChainSpec) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ChainSpec.Unmarshal(m, b)
 }
-func (m *TransparentAddress) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
-	return xxx_messageInfo_TransparentAddress.Marshal(b, m, deterministic)
+func (m *ChainSpec) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ChainSpec.Marshal(b, m, deterministic)
 }
-func (m *TransparentAddress) XXX_Merge(src proto.Message) {
-	xxx_messageInfo_TransparentAddress.Merge(m, src)
+func (m *ChainSpec) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ChainSpec.Merge(m, src)
 }
-func (m *TransparentAddress) XXX_Size() int {
-	return xxx_messageInfo_TransparentAddress.Size(m)
+func (m *ChainSpec) XXX_Size() int {
+	return xxx_messageInfo_ChainSpec.Size(m)
 }
-func (m *TransparentAddress) XXX_DiscardUnknown() {
-	xxx_messageInfo_TransparentAddress.DiscardUnknown(m)
+func (m *ChainSpec) XXX_DiscardUnknown() {
+	xxx_messageInfo_ChainSpec.DiscardUnknown(m)
 }
 
-var xxx_messageInfo_TransparentAddress proto.InternalMessageInfo
+var xxx_messageInfo_ChainSpec proto.InternalMessageInfo
 
-func (m *TransparentAddress) GetAddress() string {
+type Empty struct {
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Empty) Reset()         { *m = Empty{} }
+func (m *Empty) String() string { return proto.CompactTextString(m) }
+func (*Empty) ProtoMessage()    {}
+func (*Empty) Descriptor() ([]byte, []int) {
+	return fileDescriptor_a0b84a42fa06f626, []int{16}
+}
+
+func (m *Empty) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Empty.Unmarshal(m, b)
+}
+func (m *Empty) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Empty.Marshal(b, m, deterministic)
+}
+func (m *Empty) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Empty.Merge(m, src)
+}
+func (m *Empty) XXX_Size() int {
+	return xxx_messageInfo_Empty.Size(m)
+}
+func (m *Empty) XXX_DiscardUnknown() {
+	xxx_messageInfo_Empty.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Empty proto.InternalMessageInfo
+
+// PingRequest carries a payload of the client's, at most 1 KiB, which Ping
+// sends back.
+type PingRequest struct {
+	Payload              []byte   `protobuf:"bytes,1,opt,name=payload,proto3" json:"payload,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *PingRequest) Reset()         { *m = PingRequest{} }
+func (m *PingRequest) String() string { return proto.CompactTextString(m) }
+func (*PingRequest) ProtoMessage()    {}
+func (*PingRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_a0b84a42fa06f626, []int{17}
+}
+
+func (m *PingRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_PingRequest.Unmarshal(m, b)
+}
+func (m *PingRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_PingRequest.Marshal(b, m, deterministic)
+}
+func (m *PingRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_PingRequest.Merge(m, src)
+}
+func (m *PingRequest) XXX_Size() int {
+	return xxx_messageInfo_PingRequest.Size(m)
+}
+func (m *PingRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_PingRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_PingRequest proto.InternalMessageInfo
+
+func (m *PingRequest) GetPayload() []byte {
 	if m != nil {
-		return m.Address
+		return m.Payload
 	}
-	return ""
+	return nil
 }
 
-type TransparentAddressBlockFilter struct {
-	Address              string      `protobuf:"bytes,1,opt,name=address,proto3" json:"address,omitempty"`
-	Range                *BlockRange `protobuf:"bytes,2,opt,name=range,proto3" json:"range,omitempty"`
-	XXX_NoUnkeyedLiteral struct{}    `json:"-"`
-	XXX_unrecognized     []byte      `json:"-"`
-	XXX_sizecache        int32       `json:"-"`
+// PingResponse echoes the payload, with when the server received the ping
+// and answered it, in Unix nanoseconds, and the height and time of the
+// latest cached block, which lag if the node is behind.
+type PingResponse struct {
+	Payload              []byte   `protobuf:"bytes,1,opt,name=payload,proto3" json:"payload,omitempty"`
+	ReceivedAt           int64    `protobuf:"varint,2,opt,name=receivedAt,proto3" json:"receivedAt,omitempty"`
+	SentAt               int64    `protobuf:"varint,3,opt,name=sentAt,proto3" json:"sentAt,omitempty"`
+	LatestBlock          uint64   `protobuf:"varint,4,opt,name=latestBlock,proto3" json:"latestBlock,omitempty"`
+	LatestBlockTime      uint32   `protobuf:"varint,5,opt,name=latestBlockTime,proto3" json:"latestBlockTime,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
 }
 
-func (m *TransparentAddressBlockFilter) Reset()         { *m = TransparentAddressBlockFilter{} }
-func (m *TransparentAddressBlockFilter) String() string { return proto.CompactTextString(m) }
-func (*TransparentAddressBlockFilter) ProtoMessage()    {}
-func (*TransparentAddressBlockFilter) Descriptor() ([]byte, []int) {
-	return fileDescriptor_a0b84a42fa06f626, []int{9}
+func (m *PingResponse) Reset()         { *m = PingResponse{} }
+func (m *PingResponse) String() string { return proto.CompactTextString(m) }
+func (*PingResponse) ProtoMessage()    {}
+func (*PingResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_a0b84a42fa06f626, []int{18}
 }
 
-func (m *TransparentAddressBlockFilter) XXX_Unmarshal(b []byte) error {
-	return xxx_messageInfo_TransparentAddressBlockFilter.Unmarshal(m, b)
+func (m *PingResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_PingResponse.Unmarshal(m, b)
 }
-func (m *TransparentAddressBlockFilter) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
-	return xxx_messageInfo_TransparentAddressBlockFilter.Marshal(b, m, deterministic)
+func (m *PingResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_PingResponse.Marshal(b, m, deterministic)
 }
-func (m *TransparentAddressBlockFilter) XXX_Merge(src proto.Message) {
-	xxx_messageInfo_TransparentAddressBlockFilter.Merge(m, src)
+func (m *PingResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_PingResponse.Merge(m, src)
 }
-func (m *TransparentAddressBlockFilter) XXX_Size() int {
-	return xxx_messageInfo_TransparentAddressBlockFilter.Size(m)
+func (m *PingResponse) XXX_Size() int {
+	return xxx_messageInfo_PingResponse.Size(m)
 }
-func (m *TransparentAddressBlockFilter) XXX_DiscardUnknown() {
-	xxx_messageInfo_TransparentAddressBlockFilter.DiscardUnknown(m)
+func (m *PingResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_PingResponse.DiscardUnknown(m)
 }
 
-var xxx_messageInfo_TransparentAddressBlockFilter proto.InternalMessageInfo
+var xxx_messageInfo_PingResponse proto.InternalMessageInfo
 
-func (m *TransparentAddressBlockFilter) GetAddress() string {
+func (m *PingResponse) GetPayload() []byte {
 	if m != nil {
-		return m.Address
+		return m.Payload
 	}
-	return ""
+	return nil
 }
 
-func (m *TransparentAddressBlockFilter) GetRange() *BlockRange {
+func (m *PingResponse) GetReceivedAt() int64 {
 	if m != nil {
-		return m.Range
+		return m.ReceivedAt
 	}
-	return nil
+	return 0
 }
 
-func init() {
-	proto.RegisterType((*BlockID)(nil), "cash.z.wallet.sdk.rpc.BlockID")
-	proto.RegisterType((*BlockRange)(nil), "cash.z.wallet.sdk.rpc.BlockRange")
-	proto.RegisterType((*TxFilter)(nil), "cash.z.wallet.sdk.rpc.TxFilter")
-	proto.RegisterType((*RawTransaction)(nil), "cash.z.wallet.sdk.rpc.RawTransaction")
-	proto.RegisterType((*SendResponse)(nil), "cash.z.wallet.sdk.rpc.SendResponse")
-	proto.RegisterType((*ChainSpec)(nil), "cash.z.wallet.sdk.rpc.ChainSpec")
-	proto.RegisterType((*Empty)(nil), "cash.z.wallet.sdk.rpc.Empty")
-	proto.RegisterType((*LightdInfo)(nil), "cash.z.wallet.sdk.rpc.LightdInfo")
-	proto.RegisterType((*TransparentAddress)(nil), "cash.z.wallet.sdk.rpc.TransparentAddress")
-	proto.RegisterType((*TransparentAddressBlockFilter)(nil), "cash.z.wallet.sdk.rpc.TransparentAddressBlockFilter")
+func (m *PingResponse) GetSentAt() int64 {
+	if m != nil {
+		return m.SentAt
+	}
+	return 0
 }
 
-func init() { proto.RegisterFile("service.proto", fileDescriptor_a0b84a42fa06f626) }
+func (m *PingResponse) GetLatestBlock() uint64 {
+	if m != nil {
+		return m.LatestBlock
+	}
+	return 0
+}
 
-var fileDescriptor_a0b84a42fa06f626 = []byte{
-	// 643 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x09, 0x6e, 0x88, 0x02, 0xff, 0x8c, 0x55, 0xdd, 0x6e, 0x13, 0x3d,
-	0x10, 0xed, 0x4f, 0xb6, 0x69, 0x26, 0xfd, 0x51, 0xad, 0xaf, 0x1f, 0x51, 0x54, 0xa0, 0x18, 0x21,
-	0x71, 0x81, 0x56, 0x55, 0x29, 0x82, 0x0b, 0x6e, 0xda, 0xf2, 0x57, 0xa9, 0x20, 0x70, 0x72, 0x55,
-	0x2e, 0x2a, 0x77, 0xd7, 0xcd, 0x2e, 0x4d, 0xd6, 0x2b, 0xdb, 0x4d, 0x03, 0x8f, 0xc0, 0x43, 0xf0,
-	0xac, 0xd8, 0xe3, 0x4d, 0xb3, 0x51, 0xd9, 0x26, 0x77, 0x3b, 0xe3, 0x99, 0x73, 0x8e, 0x8f, 0x67,
-	0x12, 0x58, 0xd7, 0x42, 0x0d, 0xd3, 0x48, 0x84, 0xb9, 0x92, 0x46, 0x92, 0xed, 0x88, 0xeb, 0x24,
-	0xfc, 0x15, 0xde, 0xf0, 0x7e, 0x5f, 0x98, 0x50, 0xc7, 0x57, 0xa1, 0xca, 0xa3, 0xf6, 0x76, 0x24,
-	0x07, 0x39, 0x8f, 0xcc, 0xf9, 0xa5, 0x54, 0x03, 0x6e, 0xb4, 0xaf, 0xa6, 0xaf, 0xa0, 0x7e, 0xd4,
-	0x97, 0xd1, 0xd5, 0xc9, 0x3b, 0xf2, 0x3f, 0xac, 0x24, 0x22, 0xed, 0x25, 0xa6, 0xb5, 0xb8, 0xbb,
-	0xf8, 0xbc, 0xc6, 0x8a, 0x88, 0x10, 0xa8, 0x25, 0x16, 0xb2, 0xb5, 0x64, 0xb3, 0x6b, 0x0c, 0xbf,
-	0xa9, 0x01, 0xc0, 0x36, 0xc6, 0xb3, 0x9e, 0x20, 0x07, 0x10, 0x68, 0xc3, 0x95, 0x6f, 0x6c, 0xee,
-	0x3f, 0x0a, 0xff, 0x29, 0x21, 0x2c, 0x88, 0x98, 0x2f, 0x26, 0x7b, 0xb0, 0x2c, 0xb2, 0x18, 0x61,
-	0x67, 0xf7, 0xb8, 0x52, 0xfa, 0x03, 0x56, 0xbb, 0xa3, 0x0f, 0x69, 0xdf, 0x08, 0xe5, 0x38, 0x2f,
-	0xdc, 0xd9, 0xbc, 0x9c, 0x58, 0x4c, 0xfe, 0x83, 0x20, 0xcd, 0x62, 0x31, 0x42, 0xd6, 0x1a, 0xf3,
-	0xc1, 0xed, 0x0d, 0x97, 0x4b, 0x37, 0x7c, 0x0b, 0x1b, 0x8c, 0xdf, 0x74, 0x15, 0xcf, 0xb4, 0x75,
-	0x2d, 0x95, 0x99, 0xab, 0x8a, 0xb9, 0xe1, 0x48, 0x68, 0xab, 0xdc, 0x77, 0xc9, 0xb3, 0xa5, 0xb2,
-	0x67, 0xf4, 0x2b, 0xac, 0x75, 0xac, 0x62, 0x26, 0x74, 0x2e, 0x33, 0x2d, 0xc8, 0x0e, 0x34, 0x84,
-	0x52, 0x52, 0x1d, 0xcb, 0x58, 0x20, 0x40, 0xc0, 0x26, 0x09, 0x42, 0x61, 0x0d, 0x83, 0xcf, 0x42,
-	0x6b, 0xde, 0x13, 0x88, 0xd5, 0x60, 0x53, 0x39, 0xda, 0x84, 0xc6, 0x71, 0xc2, 0xd3, 0xac, 0x93,
-	0x8b, 0x88, 0xd6, 0x21, 0x78, 0x3f, 0xc8, 0xcd, 0x4f, 0xfa, 0x7b, 0x09, 0xe0, 0xd4, 0x31, 0xc6,
-	0x27, 0xd9, 0xa5, 0x24, 0x2d, 0xa8, 0x0f, 0x85, 0xd2, 0x56, 0x2d, 0x92, 0x34, 0xd8, 0x38, 0x74,
-	0x42, 0x87, 0x56, 0x90, 0x54, 0x05, 0x78, 0x11, 0x39, 0x6a, 0xc3, 0xe3, 0x58, 0x75, 0xae, 0xf3,
-	0x5c, 0xda, 0x17, 0x74, 0x16, 0xac, 0xb2, 0xa9, 0x9c, 0x13, 0x1f, 0x39, 0xea, 0x2f, 0x7c, 0x20,
-	0x5a, 0x35, 0x6c, 0x9f, 0x24, 0xc8, 0x1b, 0x78, 0xa0, 0x79, 0xde, 0x4f, 0xb3, 0xde, 0xa1, 0xf5,
-	0x69, 0xc8, 0x9d, 0x57, 0x9f, 0xbc, 0x27, 0x01, 0x7a, 0x52, 0x75, 0x4c, 0x5e, 0xc0, 0x56, 0xe4,
-	0xdc, 0xc9, 0xf4, 0xb5, 0x3e, 0xb2, 0x46, 0x47, 0xc9, 0x49, 0xdc, 0x5a, 0x41, 0xfc, 0xbb, 0x07,
-	0x64, 0x17, 0x9a, 0xf8, 0x86, 0x05, 0x76, 0x1d, 0xb1, 0xcb, 0x29, 0x1a, 0x02, 0xc1, 0xf7, 0xca,
-	0xb9, 0x12, 0x99, 0x39, 0xb4, 0x37, 0xb0, 0xde, 0x39, 0x4f, 0xb8, 0xff, 0x1c, 0x7b, 0x52, 0x84,
-	0x54, 0xc1, 0xc3, 0xbb, 0xf5, 0x38, 0x30, 0xc5, 0x8c, 0x55, 0xb6, 0x92, 0xd7, 0x10, 0x28, 0x37,
-	0xfa, 0xc5, 0xf4, 0x3e, 0xb9, 0x6f, 0xfa, 0x70, 0x47, 0x98, 0xaf, 0xdf, 0xff, 0x13, 0xc0, 0xd6,
-	0xb1, 0xdf, 0xc4, 0xee, 0xa8, 0x63, 0x94, 0xb0, 0x16, 0x2a, 0xd2, 0x85, 0x8d, 0x8f, 0xc2, 0x9c,
-	0x72, 0x23, 0xb4, 0xc1, 0x1e, 0xb2, 0x5b, 0x81, 0x78, 0x3b, 0x03, 0xed, 0x19, 0x13, 0x4f, 0x17,
-	0xc8, 0x37, 0x58, 0xb5, 0xa8, 0x1e, 0x6f, 0x46, 0x75, 0xfb, 0x69, 0x15, 0x9f, 0xd7, 0x8a, 0x65,
-	0x16, 0xf2, 0x3b, 0xac, 0x8f, 0x21, 0xfd, 0xea, 0xcf, 0xbe, 0xf9, 0x9c, 0xd0, 0x7b, 0x8b, 0xe4,
-	0x0c, 0x5d, 0x28, 0xaf, 0xdc, 0xe3, 0x8a, 0xd6, 0xf1, 0xaf, 0x40, 0xfb, 0x59, 0x45, 0xc1, 0xf4,
-	0xea, 0x5a, 0xe1, 0xe7, 0xb0, 0xe9, 0x16, 0xb2, 0x0c, 0x3e, 0x5f, 0x6f, 0xa5, 0xfc, 0xf2, 0x7e,
-	0x5b, 0x02, 0x05, 0x9b, 0x56, 0x7c, 0x31, 0x44, 0xdd, 0x51, 0x1a, 0x6b, 0x72, 0x50, 0xa5, 0xfe,
-	0xbe, 0xa1, 0x9b, 0xfb, 0x4a, 0xd6, 0x30, 0x86, 0xaf, 0x51, 0xda, 0xff, 0x9d, 0x8a, 0x5e, 0xfc,
-	0xb1, 0x68, 0x57, 0xbd, 0xd5, 0x04, 0x80, 0x2e, 0x1c, 0x35, 0xcf, 0x1a, 0xfe, 0xd8, 0x9e, 0x5c,
-	0xac, 0xe0, 0x9f, 0xc4, 0xcb, 0xbf, 0x01, 0x00, 0x00, 0xff, 0xff, 0x75, 0x7c, 0x9a, 0xee, 0x63,
-	0x06, 0x00, 0x00,
+func (m *PingResponse) GetLatestBlockTime() uint32 {
+	if m != nil {
+		return m.LatestBlockTime
+	}
+	return 0
 }
 
-// Reference imports to suppress errors if they are not otherwise used.
-var _ context.Context
-var _ grpc.ClientConn
+// ServerNotice is an announcement from the server's operator, such as a
+// maintenance window or a move to another address. level is "info",
+// "warning" or "critical"; from and until, in Unix seconds, are when it's
+// shown, 0 if open-ended.
+type ServerNotice struct {
+	Id                   string   `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Level                string   `protobuf:"bytes,2,opt,name=level,proto3" json:"level,omitempty"`
+	Message              string   `protobuf:"bytes,3,opt,name=message,proto3" json:"message,omitempty"`
+	Url                  string   `protobuf:"bytes,4,opt,name=url,proto3" json:"url,omitempty"`
+	From                 int64    `protobuf:"varint,5,opt,name=from,proto3" json:"from,omitempty"`
+	Until                int64    `protobuf:"varint,6,opt,name=until,proto3" json:"until,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
 
-// This is a compile-time assertion to ensure that this generated file
-// is compatible with the grpc package it is being compiled against.
-const _ = grpc.SupportPackageIsVersion4
+func (m *ServerNotice) Reset()         { *m = ServerNotice{} }
+func (m *ServerNotice) String() string { return proto.CompactTextString(m) }
+func (*ServerNotice) ProtoMessage()    {}
+func (*ServerNotice) Descriptor() ([]byte, []int) {
+	return fileDescriptor_a0b84a42fa06f626, []int{19}
+}
 
-// CompactTxStreamerClient is the client API for CompactTxStreamer service.
-//
-// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://godoc.org/google.golang.org/grpc#ClientConn.NewStream.
-type CompactTxStreamerClient interface {
-	// Compact Blocks
-	GetLatestBlock(ctx context.Context, in *ChainSpec, opts ...grpc.CallOption) (*BlockID, error)
-	GetBlock(ctx context.Context, in *BlockID, opts ...grpc.CallOption) (*CompactBlock, error)
+func (m *ServerNotice) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ServerNotice.Unmarshal(m, b)
+}
+func (m *ServerNotice) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ServerNotice.Marshal(b, m, deterministic)
+}
+func (m *ServerNotice) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ServerNotice.Merge(m, src)
+}
+func (m *ServerNotice) XXX_Size() int {
+	return xxx_messageInfo_ServerNotice.Size(m)
+}
+func (m *ServerNotice) XXX_DiscardUnknown() {
+	xxx_messageInfo_ServerNotice.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ServerNotice proto.InternalMessageInfo
+
+func (m *ServerNotice) GetId() string {
+	if m != nil {
+		return m.Id
+	}
+	return ""
+}
+
+func (m *ServerNotice) GetLevel() string {
+	if m != nil {
+		return m.Level
+	}
+	return ""
+}
+
+func (m *ServerNotice) GetMessage() string {
+	if m != nil {
+		return m.Message
+	}
+	return ""
+}
+
+func (m *ServerNotice) GetUrl() string {
+	if m != nil {
+		return m.Url
+	}
+	return ""
+}
+
+func (m *ServerNotice) GetFrom() int64 {
+	if m != nil {
+		return m.From
+	}
+	return 0
+}
+
+func (m *ServerNotice) GetUntil() int64 {
+	if m != nil {
+		return m.Until
+	}
+	return 0
+}
+
+type ServerNotices struct {
+	Notices              []*ServerNotice `protobuf:"bytes,1,rep,name=notices,proto3" json:"notices,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}        `json:"-"`
+	XXX_unrecognized     []byte          `json:"-"`
+	XXX_sizecache        int32           `json:"-"`
+}
+
+func (m *ServerNotices) Reset()         { *m = ServerNotices{} }
+func (m *ServerNotices) String() string { return proto.CompactTextString(m) }
+func (*ServerNotices) ProtoMessage()    {}
+func (*ServerNotices) Descriptor() ([]byte, []int) {
+	return fileDescriptor_a0b84a42fa06f626, []int{20}
+}
+
+func (m *ServerNotices) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ServerNotices.Unmarshal(m, b)
+}
+func (m *ServerNotices) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ServerNotices.Marshal(b, m, deterministic)
+}
+func (m *ServerNotices) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ServerNotices.Merge(m, src)
+}
+func (m *ServerNotices) XXX_Size() int {
+	return xxx_messageInfo_ServerNotices.Size(m)
+}
+func (m *ServerNotices) XXX_DiscardUnknown() {
+	xxx_messageInfo_ServerNotices.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ServerNotices proto.InternalMessageInfo
+
+func (m *ServerNotices) GetNotices() []*ServerNotice {
+	if m != nil {
+		return m.Notices
+	}
+	return nil
+}
+
+type LightdInfo struct {
+	Version                 string            `protobuf:"bytes,1,opt,name=version,proto3" json:"version,omitempty"`
+	Vendor                  string            `protobuf:"bytes,2,opt,name=vendor,proto3" json:"vendor,omitempty"`
+	TaddrSupport            bool              `protobuf:"varint,3,opt,name=taddrSupport,proto3" json:"taddrSupport,omitempty"`
+	ChainName               string            `protobuf:"bytes,4,opt,name=chainName,proto3" json:"chainName,omitempty"`
+	SaplingActivationHeight uint64            `protobuf:"varint,5,opt,name=saplingActivationHeight,proto3" json:"saplingActivationHeight,omitempty"`
+	ConsensusBranchId       string            `protobuf:"bytes,6,opt,name=consensusBranchId,proto3" json:"consensusBranchId,omitempty"`
+	BlockHeight             uint64            `protobuf:"varint,7,opt,name=blockHeight,proto3" json:"blockHeight,omitempty"`
+	DeprecatedMethods       []string          `protobuf:"bytes,8,rep,name=deprecatedMethods,proto3" json:"deprecatedMethods,omitempty"`
+	SendReady               bool              `protobuf:"varint,9,opt,name=sendReady,proto3" json:"sendReady,omitempty"`
+	CompactFormatVersions   []uint32          `protobuf:"varint,10,rep,packed,name=compactFormatVersions,proto3" json:"compactFormatVersions,omitempty"`
+	NodeReachable           bool              `protobuf:"varint,11,opt,name=nodeReachable,proto3" json:"nodeReachable,omitempty"`
+	NodeSubversion          string            `protobuf:"bytes,12,opt,name=nodeSubversion,proto3" json:"nodeSubversion,omitempty"`
+	MempoolSize             uint64            `protobuf:"varint,13,opt,name=mempoolSize,proto3" json:"mempoolSize,omitempty"`
+	GitCommit               string            `protobuf:"bytes,14,opt,name=gitCommit,proto3" json:"gitCommit,omitempty"`
+	BuildDate               string            `protobuf:"bytes,15,opt,name=buildDate,proto3" json:"buildDate,omitempty"`
+	EstimatedHeight         uint64            `protobuf:"varint,16,opt,name=estimatedHeight,proto3" json:"estimatedHeight,omitempty"`
+	NodeVersion             uint64            `protobuf:"varint,17,opt,name=nodeVersion,proto3" json:"nodeVersion,omitempty"`
+	DonationAddress         string            `protobuf:"bytes,18,opt,name=donationAddress,proto3" json:"donationAddress,omitempty"`
+	DonationTaddress        string            `protobuf:"bytes,19,opt,name=donationTaddress,proto3" json:"donationTaddress,omitempty"`
+	OperatorName            string            `protobuf:"bytes,20,opt,name=operatorName,proto3" json:"operatorName,omitempty"`
+	OperatorContact         string            `protobuf:"bytes,21,opt,name=operatorContact,proto3" json:"operatorContact,omitempty"`
+	PrivacyPolicyUrl        string            `protobuf:"bytes,22,opt,name=privacyPolicyUrl,proto3" json:"privacyPolicyUrl,omitempty"`
+	Upgrades                []*NetworkUpgrade `protobuf:"bytes,23,rep,name=upgrades,proto3" json:"upgrades,omitempty"`
+	Notices                 []*ServerNotice   `protobuf:"bytes,24,rep,name=notices,proto3" json:"notices,omitempty"`
+	ProtocolVersion         uint32            `protobuf:"varint,25,opt,name=protocolVersion,proto3" json:"protocolVersion,omitempty"`
+	MinProtocolVersion      uint32            `protobuf:"varint,26,opt,name=minProtocolVersion,proto3" json:"minProtocolVersion,omitempty"`
+	XXX_NoUnkeyedLiteral    struct{}          `json:"-"`
+	XXX_unrecognized        []byte            `json:"-"`
+	XXX_sizecache           int32             `json:"-"`
+}
+
+func (m *LightdInfo) Reset()         { *m = LightdInfo{} }
+func (m *LightdInfo) String() string { return proto.CompactTextString(m) }
+func (*LightdInfo) ProtoMessage()    {}
+func (*LightdInfo) Descriptor() ([]byte, []int) {
+	return fileDescriptor_a0b84a42fa06f626, []int{21}
+}
+
+func (m *LightdInfo) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_LightdInfo.Unmarshal(m, b)
+}
+func (m *LightdInfo) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_LightdInfo.Marshal(b, m, deterministic)
+}
+func (m *LightdInfo) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_LightdInfo.Merge(m, src)
+}
+func (m *LightdInfo) XXX_Size() int {
+	return xxx_messageInfo_LightdInfo.Size(m)
+}
+func (m *LightdInfo) XXX_DiscardUnknown() {
+	xxx_messageInfo_LightdInfo.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_LightdInfo proto.InternalMessageInfo
+
+func (m *LightdInfo) GetVersion() string {
+	if m != nil {
+		return m.Version
+	}
+	return ""
+}
+
+func (m *LightdInfo) GetVendor() string {
+	if m != nil {
+		return m.Vendor
+	}
+	return ""
+}
+
+func (m *LightdInfo) GetTaddrSupport() bool {
+	if m != nil {
+		return m.TaddrSupport
+	}
+	return false
+}
+
+func (m *LightdInfo) GetChainName() string {
+	if m != nil {
+		return m.ChainName
+	}
+	return ""
+}
+
+func (m *LightdInfo) GetSaplingActivationHeight() uint64 {
+	if m != nil {
+		return m.SaplingActivationHeight
+	}
+	return 0
+}
+
+func (m *LightdInfo) GetConsensusBranchId() string {
+	if m != nil {
+		return m.ConsensusBranchId
+	}
+	return ""
+}
+
+func (m *LightdInfo) GetBlockHeight() uint64 {
+	if m != nil {
+		return m.BlockHeight
+	}
+	return 0
+}
+
+func (m *LightdInfo) GetDeprecatedMethods() []string {
+	if m != nil {
+		return m.DeprecatedMethods
+	}
+	return nil
+}
+
+func (m *LightdInfo) GetSendReady() bool {
+	if m != nil {
+		return m.SendReady
+	}
+	return false
+}
+
+func (m *LightdInfo) GetCompactFormatVersions() []uint32 {
+	if m != nil {
+		return m.CompactFormatVersions
+	}
+	return nil
+}
+
+func (m *LightdInfo) GetNodeReachable() bool {
+	if m != nil {
+		return m.NodeReachable
+	}
+	return false
+}
+
+func (m *LightdInfo) GetNodeSubversion() string {
+	if m != nil {
+		return m.NodeSubversion
+	}
+	return ""
+}
+
+func (m *LightdInfo) GetMempoolSize() uint64 {
+	if m != nil {
+		return m.MempoolSize
+	}
+	return 0
+}
+
+func (m *LightdInfo) GetGitCommit() string {
+	if m != nil {
+		return m.GitCommit
+	}
+	return ""
+}
+
+func (m *LightdInfo) GetBuildDate() string {
+	if m != nil {
+		return m.BuildDate
+	}
+	return ""
+}
+
+func (m *LightdInfo) GetEstimatedHeight() uint64 {
+	if m != nil {
+		return m.EstimatedHeight
+	}
+	return 0
+}
+
+func (m *LightdInfo) GetNodeVersion() uint64 {
+	if m != nil {
+		return m.NodeVersion
+	}
+	return 0
+}
+
+func (m *LightdInfo) GetDonationAddress() string {
+	if m != nil {
+		return m.DonationAddress
+	}
+	return ""
+}
+
+func (m *LightdInfo) GetDonationTaddress() string {
+	if m != nil {
+		return m.DonationTaddress
+	}
+	return ""
+}
+
+func (m *LightdInfo) GetOperatorName() string {
+	if m != nil {
+		return m.OperatorName
+	}
+	return ""
+}
+
+func (m *LightdInfo) GetOperatorContact() string {
+	if m != nil {
+		return m.OperatorContact
+	}
+	return ""
+}
+
+func (m *LightdInfo) GetPrivacyPolicyUrl() string {
+	if m != nil {
+		return m.PrivacyPolicyUrl
+	}
+	return ""
+}
+
+func (m *LightdInfo) GetUpgrades() []*NetworkUpgrade {
+	if m != nil {
+		return m.Upgrades
+	}
+	return nil
+}
+
+func (m *LightdInfo) GetNotices() []*ServerNotice {
+	if m != nil {
+		return m.Notices
+	}
+	return nil
+}
+
+func (m *LightdInfo) GetProtocolVersion() uint32 {
+	if m != nil {
+		return m.ProtocolVersion
+	}
+	return 0
+}
+
+func (m *LightdInfo) GetMinProtocolVersion() uint32 {
+	if m != nil {
+		return m.MinProtocolVersion
+	}
+	return 0
+}
+
+// ConsensusBranch is the consensus branch in effect at a height, which
+// transactions expiring at that height are signed for.
+type ConsensusBranch struct {
+	Height               uint64   `protobuf:"varint,1,opt,name=height,proto3" json:"height,omitempty"`
+	BranchId             string   `protobuf:"bytes,2,opt,name=branchId,proto3" json:"branchId,omitempty"`
+	UpgradeName          string   `protobuf:"bytes,3,opt,name=upgradeName,proto3" json:"upgradeName,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ConsensusBranch) Reset()         { *m = ConsensusBranch{} }
+func (m *ConsensusBranch) String() string { return proto.CompactTextString(m) }
+func (*ConsensusBranch) ProtoMessage()    {}
+func (*ConsensusBranch) Descriptor() ([]byte, []int) {
+	return fileDescriptor_a0b84a42fa06f626, []int{22}
+}
+
+func (m *ConsensusBranch) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ConsensusBranch.Unmarshal(m, b)
+}
+func (m *ConsensusBranch) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ConsensusBranch.Marshal(b, m, deterministic)
+}
+func (m *ConsensusBranch) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ConsensusBranch.Merge(m, src)
+}
+func (m *ConsensusBranch) XXX_Size() int {
+	return xxx_messageInfo_ConsensusBranch.Size(m)
+}
+func (m *ConsensusBranch) XXX_DiscardUnknown() {
+	xxx_messageInfo_ConsensusBranch.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ConsensusBranch proto.InternalMessageInfo
+
+func (m *ConsensusBranch) GetHeight() uint64 {
+	if m != nil {
+		return m.Height
+	}
+	return 0
+}
+
+func (m *ConsensusBranch) GetBranchId() string {
+	if m != nil {
+		return m.BranchId
+	}
+	return ""
+}
+
+func (m *ConsensusBranch) GetUpgradeName() string {
+	if m != nil {
+		return m.UpgradeName
+	}
+	return ""
+}
+
+// ValuePool is the value held in a pool ("transparent", "sprout",
+// "sapling"), in zatoshis, which is only known if the node monitors it.
+type ValuePool struct {
+	Id                   string   `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	ValueZat             int64    `protobuf:"varint,2,opt,name=valueZat,proto3" json:"valueZat,omitempty"`
+	Monitored            bool     `protobuf:"varint,3,opt,name=monitored,proto3" json:"monitored,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ValuePool) Reset()         { *m = ValuePool{} }
+func (m *ValuePool) String() string { return proto.CompactTextString(m) }
+func (*ValuePool) ProtoMessage()    {}
+func (*ValuePool) Descriptor() ([]byte, []int) {
+	return fileDescriptor_a0b84a42fa06f626, []int{23}
+}
+
+func (m *ValuePool) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ValuePool.Unmarshal(m, b)
+}
+func (m *ValuePool) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ValuePool.Marshal(b, m, deterministic)
+}
+func (m *ValuePool) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ValuePool.Merge(m, src)
+}
+func (m *ValuePool) XXX_Size() int {
+	return xxx_messageInfo_ValuePool.Size(m)
+}
+func (m *ValuePool) XXX_DiscardUnknown() {
+	xxx_messageInfo_ValuePool.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ValuePool proto.InternalMessageInfo
+
+func (m *ValuePool) GetId() string {
+	if m != nil {
+		return m.Id
+	}
+	return ""
+}
+
+func (m *ValuePool) GetValueZat() int64 {
+	if m != nil {
+		return m.ValueZat
+	}
+	return 0
+}
+
+func (m *ValuePool) GetMonitored() bool {
+	if m != nil {
+		return m.Monitored
+	}
+	return false
+}
+
+// ValuePools is the value in each pool at height, and the total supply, if
+// supplyKnown.
+type ValuePools struct {
+	Height               uint64       `protobuf:"varint,1,opt,name=height,proto3" json:"height,omitempty"`
+	Pools                []*ValuePool `protobuf:"bytes,2,rep,name=pools,proto3" json:"pools,omitempty"`
+	TotalSupplyZat       int64        `protobuf:"varint,3,opt,name=totalSupplyZat,proto3" json:"totalSupplyZat,omitempty"`
+	SupplyKnown          bool         `protobuf:"varint,4,opt,name=supplyKnown,proto3" json:"supplyKnown,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}     `json:"-"`
+	XXX_unrecognized     []byte       `json:"-"`
+	XXX_sizecache        int32        `json:"-"`
+}
+
+func (m *ValuePools) Reset()         { *m = ValuePools{} }
+func (m *ValuePools) String() string { return proto.CompactTextString(m) }
+func (*ValuePools) ProtoMessage()    {}
+func (*ValuePools) Descriptor() ([]byte, []int) {
+	return fileDescriptor_a0b84a42fa06f626, []int{24}
+}
+
+func (m *ValuePools) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ValuePools.Unmarshal(m, b)
+}
+func (m *ValuePools) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ValuePools.Marshal(b, m, deterministic)
+}
+func (m *ValuePools) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ValuePools.Merge(m, src)
+}
+func (m *ValuePools) XXX_Size() int {
+	return xxx_messageInfo_ValuePools.Size(m)
+}
+func (m *ValuePools) XXX_DiscardUnknown() {
+	xxx_messageInfo_ValuePools.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ValuePools proto.InternalMessageInfo
+
+func (m *ValuePools) GetHeight() uint64 {
+	if m != nil {
+		return m.Height
+	}
+	return 0
+}
+
+func (m *ValuePools) GetPools() []*ValuePool {
+	if m != nil {
+		return m.Pools
+	}
+	return nil
+}
+
+func (m *ValuePools) GetTotalSupplyZat() int64 {
+	if m != nil {
+		return m.TotalSupplyZat
+	}
+	return 0
+}
+
+func (m *ValuePools) GetSupplyKnown() bool {
+	if m != nil {
+		return m.SupplyKnown
+	}
+	return false
+}
+
+// NetworkUpgrade is a network upgrade as the node reports it in
+// getblockchaininfo.
+type NetworkUpgrade struct {
+	Name                 string   `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	BranchId             string   `protobuf:"bytes,2,opt,name=branchId,proto3" json:"branchId,omitempty"`
+	ActivationHeight     uint64   `protobuf:"varint,3,opt,name=activationHeight,proto3" json:"activationHeight,omitempty"`
+	Status               string   `protobuf:"bytes,4,opt,name=status,proto3" json:"status,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *NetworkUpgrade) Reset()         { *m = NetworkUpgrade{} }
+func (m *NetworkUpgrade) String() string { return proto.CompactTextString(m) }
+func (*NetworkUpgrade) ProtoMessage()    {}
+func (*NetworkUpgrade) Descriptor() ([]byte, []int) {
+	return fileDescriptor_a0b84a42fa06f626, []int{25}
+}
+
+func (m *NetworkUpgrade) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_NetworkUpgrade.Unmarshal(m, b)
+}
+func (m *NetworkUpgrade) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_NetworkUpgrade.Marshal(b, m, deterministic)
+}
+func (m *NetworkUpgrade) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_NetworkUpgrade.Merge(m, src)
+}
+func (m *NetworkUpgrade) XXX_Size() int {
+	return xxx_messageInfo_NetworkUpgrade.Size(m)
+}
+func (m *NetworkUpgrade) XXX_DiscardUnknown() {
+	xxx_messageInfo_NetworkUpgrade.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_NetworkUpgrade proto.InternalMessageInfo
+
+func (m *NetworkUpgrade) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+func (m *NetworkUpgrade) GetBranchId() string {
+	if m != nil {
+		return m.BranchId
+	}
+	return ""
+}
+
+func (m *NetworkUpgrade) GetActivationHeight() uint64 {
+	if m != nil {
+		return m.ActivationHeight
+	}
+	return 0
+}
+
+func (m *NetworkUpgrade) GetStatus() string {
+	if m != nil {
+		return m.Status
+	}
+	return ""
+}
+
+// CheckpointIndex lists a Checkpoint every interval blocks, in height order.
+type CheckpointIndex struct {
+	Interval             uint64        `protobuf:"varint,1,opt,name=interval,proto3" json:"interval,omitempty"`
+	Checkpoints          []*Checkpoint `protobuf:"bytes,2,rep,name=checkpoints,proto3" json:"checkpoints,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}      `json:"-"`
+	XXX_unrecognized     []byte        `json:"-"`
+	XXX_sizecache        int32         `json:"-"`
+}
+
+func (m *CheckpointIndex) Reset()         { *m = CheckpointIndex{} }
+func (m *CheckpointIndex) String() string { return proto.CompactTextString(m) }
+func (*CheckpointIndex) ProtoMessage()    {}
+func (*CheckpointIndex) Descriptor() ([]byte, []int) {
+	return fileDescriptor_a0b84a42fa06f626, []int{26}
+}
+
+func (m *CheckpointIndex) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_CheckpointIndex.Unmarshal(m, b)
+}
+func (m *CheckpointIndex) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_CheckpointIndex.Marshal(b, m, deterministic)
+}
+func (m *CheckpointIndex) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_CheckpointIndex.Merge(m, src)
+}
+func (m *CheckpointIndex) XXX_Size() int {
+	return xxx_messageInfo_CheckpointIndex.Size(m)
+}
+func (m *CheckpointIndex) XXX_DiscardUnknown() {
+	xxx_messageInfo_CheckpointIndex.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_CheckpointIndex proto.InternalMessageInfo
+
+func (m *CheckpointIndex) GetInterval() uint64 {
+	if m != nil {
+		return m.Interval
+	}
+	return 0
+}
+
+func (m *CheckpointIndex) GetCheckpoints() []*Checkpoint {
+	if m != nil {
+		return m.Checkpoints
+	}
+	return nil
+}
+
+type TransparentAddress struct {
+	Address              string   `protobuf:"bytes,1,opt,name=address,proto3" json:"address,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *TransparentAddress) Reset()         { *m = TransparentAddress{} }
+func (m *TransparentAddress) String() string { return proto.CompactTextString(m) }
+func (*TransparentAddress) ProtoMessage()    {}
+func (*TransparentAddress) Descriptor() ([]byte, []int) {
+	return fileDescriptor_a0b84a42fa06f626, []int{27}
+}
+
+func (m *TransparentAddress) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_TransparentAddress.Unmarshal(m, b)
+}
+func (m *TransparentAddress) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_TransparentAddress.Marshal(b, m, deterministic)
+}
+func (m *TransparentAddress) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_TransparentAddress.Merge(m, src)
+}
+func (m *TransparentAddress) XXX_Size() int {
+	return xxx_messageInfo_TransparentAddress.Size(m)
+}
+func (m *TransparentAddress) XXX_DiscardUnknown() {
+	xxx_messageInfo_TransparentAddress.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_TransparentAddress proto.InternalMessageInfo
+
+func (m *TransparentAddress) GetAddress() string {
+	if m != nil {
+		return m.Address
+	}
+	return ""
+}
+
+type TransparentAddressBlockFilter struct {
+	Address              string      `protobuf:"bytes,1,opt,name=address,proto3" json:"address,omitempty"`
+	Range                *BlockRange `protobuf:"bytes,2,opt,name=range,proto3" json:"range,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}    `json:"-"`
+	XXX_unrecognized     []byte      `json:"-"`
+	XXX_sizecache        int32       `json:"-"`
+}
+
+func (m *TransparentAddressBlockFilter) Reset()         { *m = TransparentAddressBlockFilter{} }
+func (m *TransparentAddressBlockFilter) String() string { return proto.CompactTextString(m) }
+func (*TransparentAddressBlockFilter) ProtoMessage()    {}
+func (*TransparentAddressBlockFilter) Descriptor() ([]byte, []int) {
+	return fileDescriptor_a0b84a42fa06f626, []int{28}
+}
+
+func (m *TransparentAddressBlockFilter) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_TransparentAddressBlockFilter.Unmarshal(m, b)
+}
+func (m *TransparentAddressBlockFilter) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_TransparentAddressBlockFilter.Marshal(b, m, deterministic)
+}
+func (m *TransparentAddressBlockFilter) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_TransparentAddressBlockFilter.Merge(m, src)
+}
+func (m *TransparentAddressBlockFilter) XXX_Size() int {
+	return xxx_messageInfo_TransparentAddressBlockFilter.Size(m)
+}
+func (m *TransparentAddressBlockFilter) XXX_DiscardUnknown() {
+	xxx_messageInfo_TransparentAddressBlockFilter.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_TransparentAddressBlockFilter proto.InternalMessageInfo
+
+func (m *TransparentAddressBlockFilter) GetAddress() string {
+	if m != nil {
+		return m.Address
+	}
+	return ""
+}
+
+func (m *TransparentAddressBlockFilter) GetRange() *BlockRange {
+	if m != nil {
+		return m.Range
+	}
+	return nil
+}
+
+type AddressList struct {
+	Addresses            []string `protobuf:"bytes,1,rep,name=addresses,proto3" json:"addresses,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *AddressList) Reset()         { *m = AddressList{} }
+func (m *AddressList) String() string { return proto.CompactTextString(m) }
+func (*AddressList) ProtoMessage()    {}
+func (*AddressList) Descriptor() ([]byte, []int) {
+	return fileDescriptor_a0b84a42fa06f626, []int{29}
+}
+
+func (m *AddressList) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_AddressList.Unmarshal(m, b)
+}
+func (m *AddressList) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_AddressList.Marshal(b, m, deterministic)
+}
+func (m *AddressList) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_AddressList.Merge(m, src)
+}
+func (m *AddressList) XXX_Size() int {
+	return xxx_messageInfo_AddressList.Size(m)
+}
+func (m *AddressList) XXX_DiscardUnknown() {
+	xxx_messageInfo_AddressList.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_AddressList proto.InternalMessageInfo
+
+func (m *AddressList) GetAddresses() []string {
+	if m != nil {
+		return m.Addresses
+	}
+	return nil
+}
+
+// Balance is the total value of a set of transparent addresses. The
+// unconfirmed change, from transactions in the mempool, may be negative.
+type Balance struct {
+	ConfirmedZat         int64    `protobuf:"varint,1,opt,name=confirmedZat,proto3" json:"confirmedZat,omitempty"`
+	UnconfirmedZat       int64    `protobuf:"varint,2,opt,name=unconfirmedZat,proto3" json:"unconfirmedZat,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Balance) Reset()         { *m = Balance{} }
+func (m *Balance) String() string { return proto.CompactTextString(m) }
+func (*Balance) ProtoMessage()    {}
+func (*Balance) Descriptor() ([]byte, []int) {
+	return fileDescriptor_a0b84a42fa06f626, []int{30}
+}
+
+func (m *Balance) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Balance.Unmarshal(m, b)
+}
+func (m *Balance) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Balance.Marshal(b, m, deterministic)
+}
+func (m *Balance) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Balance.Merge(m, src)
+}
+func (m *Balance) XXX_Size() int {
+	return xxx_messageInfo_Balance.Size(m)
+}
+func (m *Balance) XXX_DiscardUnknown() {
+	xxx_messageInfo_Balance.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Balance proto.InternalMessageInfo
+
+func (m *Balance) GetConfirmedZat() int64 {
+	if m != nil {
+		return m.ConfirmedZat
+	}
+	return 0
+}
+
+func (m *Balance) GetUnconfirmedZat() int64 {
+	if m != nil {
+		return m.UnconfirmedZat
+	}
+	return 0
+}
+
+// GetAddressUtxosArg asks for the unspent outputs of transparent addresses
+// at or above startHeight, at most maxEntries of them (0 for all).
+type GetAddressUtxosArg struct {
+	Addresses            []string `protobuf:"bytes,1,rep,name=addresses,proto3" json:"addresses,omitempty"`
+	StartHeight          uint64   `protobuf:"varint,2,opt,name=startHeight,proto3" json:"startHeight,omitempty"`
+	MaxEntries           uint32   `protobuf:"varint,3,opt,name=maxEntries,proto3" json:"maxEntries,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *GetAddressUtxosArg) Reset()         { *m = GetAddressUtxosArg{} }
+func (m *GetAddressUtxosArg) String() string { return proto.CompactTextString(m) }
+func (*GetAddressUtxosArg) ProtoMessage()    {}
+func (*GetAddressUtxosArg) Descriptor() ([]byte, []int) {
+	return fileDescriptor_a0b84a42fa06f626, []int{31}
+}
+
+func (m *GetAddressUtxosArg) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_GetAddressUtxosArg.Unmarshal(m, b)
+}
+func (m *GetAddressUtxosArg) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_GetAddressUtxosArg.Marshal(b, m, deterministic)
+}
+func (m *GetAddressUtxosArg) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_GetAddressUtxosArg.Merge(m, src)
+}
+func (m *GetAddressUtxosArg) XXX_Size() int {
+	return xxx_messageInfo_GetAddressUtxosArg.Size(m)
+}
+func (m *GetAddressUtxosArg) XXX_DiscardUnknown() {
+	xxx_messageInfo_GetAddressUtxosArg.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_GetAddressUtxosArg proto.InternalMessageInfo
+
+func (m *GetAddressUtxosArg) GetAddresses() []string {
+	if m != nil {
+		return m.Addresses
+	}
+	return nil
+}
+
+func (m *GetAddressUtxosArg) GetStartHeight() uint64 {
+	if m != nil {
+		return m.StartHeight
+	}
+	return 0
+}
+
+func (m *GetAddressUtxosArg) GetMaxEntries() uint32 {
+	if m != nil {
+		return m.MaxEntries
+	}
+	return 0
+}
+
+// GetAddressUtxosReply is an unspent transparent output: enough to spend it.
+type GetAddressUtxosReply struct {
+	Txid                 []byte   `protobuf:"bytes,1,opt,name=txid,proto3" json:"txid,omitempty"`
+	Index                int32    `protobuf:"varint,2,opt,name=index,proto3" json:"index,omitempty"`
+	Script               []byte   `protobuf:"bytes,3,opt,name=script,proto3" json:"script,omitempty"`
+	ValueZat             int64    `protobuf:"varint,4,opt,name=valueZat,proto3" json:"valueZat,omitempty"`
+	Height               uint64   `protobuf:"varint,5,opt,name=height,proto3" json:"height,omitempty"`
+	Address              string   `protobuf:"bytes,6,opt,name=address,proto3" json:"address,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *GetAddressUtxosReply) Reset()         { *m = GetAddressUtxosReply{} }
+func (m *GetAddressUtxosReply) String() string { return proto.CompactTextString(m) }
+func (*GetAddressUtxosReply) ProtoMessage()    {}
+func (*GetAddressUtxosReply) Descriptor() ([]byte, []int) {
+	return fileDescriptor_a0b84a42fa06f626, []int{32}
+}
+
+func (m *GetAddressUtxosReply) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_GetAddressUtxosReply.Unmarshal(m, b)
+}
+func (m *GetAddressUtxosReply) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_GetAddressUtxosReply.Marshal(b, m, deterministic)
+}
+func (m *GetAddressUtxosReply) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_GetAddressUtxosReply.Merge(m, src)
+}
+func (m *GetAddressUtxosReply) XXX_Size() int {
+	return xxx_messageInfo_GetAddressUtxosReply.Size(m)
+}
+func (m *GetAddressUtxosReply) XXX_DiscardUnknown() {
+	xxx_messageInfo_GetAddressUtxosReply.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_GetAddressUtxosReply proto.InternalMessageInfo
+
+func (m *GetAddressUtxosReply) GetTxid() []byte {
+	if m != nil {
+		return m.Txid
+	}
+	return nil
+}
+
+func (m *GetAddressUtxosReply) GetIndex() int32 {
+	if m != nil {
+		return m.Index
+	}
+	return 0
+}
+
+func (m *GetAddressUtxosReply) GetScript() []byte {
+	if m != nil {
+		return m.Script
+	}
+	return nil
+}
+
+func (m *GetAddressUtxosReply) GetValueZat() int64 {
+	if m != nil {
+		return m.ValueZat
+	}
+	return 0
+}
+
+func (m *GetAddressUtxosReply) GetHeight() uint64 {
+	if m != nil {
+		return m.Height
+	}
+	return 0
+}
+
+func (m *GetAddressUtxosReply) GetAddress() string {
+	if m != nil {
+		return m.Address
+	}
+	return ""
+}
+
+type GetAddressUtxosReplyList struct {
+	AddressUtxos         []*GetAddressUtxosReply `protobuf:"bytes,1,rep,name=addressUtxos,proto3" json:"addressUtxos,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}                `json:"-"`
+	XXX_unrecognized     []byte                  `json:"-"`
+	XXX_sizecache        int32                   `json:"-"`
+}
+
+func (m *GetAddressUtxosReplyList) Reset()         { *m = GetAddressUtxosReplyList{} }
+func (m *GetAddressUtxosReplyList) String() string { return proto.CompactTextString(m) }
+func (*GetAddressUtxosReplyList) ProtoMessage()    {}
+func (*GetAddressUtxosReplyList) Descriptor() ([]byte, []int) {
+	return fileDescriptor_a0b84a42fa06f626, []int{33}
+}
+
+func (m *GetAddressUtxosReplyList) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_GetAddressUtxosReplyList.Unmarshal(m, b)
+}
+func (m *GetAddressUtxosReplyList) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_GetAddressUtxosReplyList.Marshal(b, m, deterministic)
+}
+func (m *GetAddressUtxosReplyList) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_GetAddressUtxosReplyList.Merge(m, src)
+}
+func (m *GetAddressUtxosReplyList) XXX_Size() int {
+	return xxx_messageInfo_GetAddressUtxosReplyList.Size(m)
+}
+func (m *GetAddressUtxosReplyList) XXX_DiscardUnknown() {
+	xxx_messageInfo_GetAddressUtxosReplyList.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_GetAddressUtxosReplyList proto.InternalMessageInfo
+
+func (m *GetAddressUtxosReplyList) GetAddressUtxos() []*GetAddressUtxosReply {
+	if m != nil {
+		return m.AddressUtxos
+	}
+	return nil
+}
+
+// BalanceHistoryArg asks for the changes in the balance of a transparent
+// address, per block or per UTC day.
+type BalanceHistoryArg struct {
+	Address              string                        `protobuf:"bytes,1,opt,name=address,proto3" json:"address,omitempty"`
+	Granularity          BalanceHistoryArg_Granularity `protobuf:"varint,2,opt,name=granularity,proto3,enum=cash.z.wallet.sdk.rpc.BalanceHistoryArg_Granularity" json:"granularity,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}                      `json:"-"`
+	XXX_unrecognized     []byte                        `json:"-"`
+	XXX_sizecache        int32                         `json:"-"`
+}
+
+func (m *BalanceHistoryArg) Reset()         { *m = BalanceHistoryArg{} }
+func (m *BalanceHistoryArg) String() string { return proto.CompactTextString(m) }
+func (*BalanceHistoryArg) ProtoMessage()    {}
+func (*BalanceHistoryArg) Descriptor() ([]byte, []int) {
+	return fileDescriptor_a0b84a42fa06f626, []int{34}
+}
+
+func (m *BalanceHistoryArg) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_BalanceHistoryArg.Unmarshal(m, b)
+}
+func (m *BalanceHistoryArg) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_BalanceHistoryArg.Marshal(b, m, deterministic)
+}
+func (m *BalanceHistoryArg) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_BalanceHistoryArg.Merge(m, src)
+}
+func (m *BalanceHistoryArg) XXX_Size() int {
+	return xxx_messageInfo_BalanceHistoryArg.Size(m)
+}
+func (m *BalanceHistoryArg) XXX_DiscardUnknown() {
+	xxx_messageInfo_BalanceHistoryArg.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_BalanceHistoryArg proto.InternalMessageInfo
+
+func (m *BalanceHistoryArg) GetAddress() string {
+	if m != nil {
+		return m.Address
+	}
+	return ""
+}
+
+func (m *BalanceHistoryArg) GetGranularity() BalanceHistoryArg_Granularity {
+	if m != nil {
+		return m.Granularity
+	}
+	return BalanceHistoryArg_BLOCK
+}
+
+// BalanceDelta is the change in a balance over a block or a day.
+type BalanceDelta struct {
+	Height               uint64   `protobuf:"varint,1,opt,name=height,proto3" json:"height,omitempty"`
+	Time                 uint32   `protobuf:"varint,2,opt,name=time,proto3" json:"time,omitempty"`
+	DeltaZat             int64    `protobuf:"varint,3,opt,name=deltaZat,proto3" json:"deltaZat,omitempty"`
+	BalanceZat           int64    `protobuf:"varint,4,opt,name=balanceZat,proto3" json:"balanceZat,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *BalanceDelta) Reset()         { *m = BalanceDelta{} }
+func (m *BalanceDelta) String() string { return proto.CompactTextString(m) }
+func (*BalanceDelta) ProtoMessage()    {}
+func (*BalanceDelta) Descriptor() ([]byte, []int) {
+	return fileDescriptor_a0b84a42fa06f626, []int{35}
+}
+
+func (m *BalanceDelta) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_BalanceDelta.Unmarshal(m, b)
+}
+func (m *BalanceDelta) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_BalanceDelta.Marshal(b, m, deterministic)
+}
+func (m *BalanceDelta) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_BalanceDelta.Merge(m, src)
+}
+func (m *BalanceDelta) XXX_Size() int {
+	return xxx_messageInfo_BalanceDelta.Size(m)
+}
+func (m *BalanceDelta) XXX_DiscardUnknown() {
+	xxx_messageInfo_BalanceDelta.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_BalanceDelta proto.InternalMessageInfo
+
+func (m *BalanceDelta) GetHeight() uint64 {
+	if m != nil {
+		return m.Height
+	}
+	return 0
+}
+
+func (m *BalanceDelta) GetTime() uint32 {
+	if m != nil {
+		return m.Time
+	}
+	return 0
+}
+
+func (m *BalanceDelta) GetDeltaZat() int64 {
+	if m != nil {
+		return m.DeltaZat
+	}
+	return 0
+}
+
+func (m *BalanceDelta) GetBalanceZat() int64 {
+	if m != nil {
+		return m.BalanceZat
+	}
+	return 0
+}
+
+func init() {
+	proto.RegisterEnum("cash.z.wallet.sdk.rpc.ShieldedProtocol", ShieldedProtocol_name, ShieldedProtocol_value)
+	proto.RegisterEnum("cash.z.wallet.sdk.rpc.TxStatus_Status", TxStatus_Status_name, TxStatus_Status_value)
+	proto.RegisterEnum("cash.z.wallet.sdk.rpc.BalanceHistoryArg_Granularity", BalanceHistoryArg_Granularity_name, BalanceHistoryArg_Granularity_value)
+	proto.RegisterType((*BlockID)(nil), "cash.z.wallet.sdk.rpc.BlockID")
+	proto.RegisterType((*BlockRange)(nil), "cash.z.wallet.sdk.rpc.BlockRange")
+	proto.RegisterType((*BlockHeader)(nil), "cash.z.wallet.sdk.rpc.BlockHeader")
+	proto.RegisterType((*SubscribeBlocksArg)(nil), "cash.z.wallet.sdk.rpc.SubscribeBlocksArg")
+	proto.RegisterType((*BlockUpdate)(nil), "cash.z.wallet.sdk.rpc.BlockUpdate")
+	proto.RegisterType((*ReorgEvent)(nil), "cash.z.wallet.sdk.rpc.ReorgEvent")
+	proto.RegisterType((*BlockTimeArg)(nil), "cash.z.wallet.sdk.rpc.BlockTimeArg")
+	proto.RegisterType((*TxFilter)(nil), "cash.z.wallet.sdk.rpc.TxFilter")
+	proto.RegisterType((*RawTransaction)(nil), "cash.z.wallet.sdk.rpc.RawTransaction")
+	proto.RegisterType((*SendResponse)(nil), "cash.z.wallet.sdk.rpc.SendResponse")
+	proto.RegisterType((*TxStatus)(nil), "cash.z.wallet.sdk.rpc.TxStatus")
+	proto.RegisterType((*TxProof)(nil), "cash.z.wallet.sdk.rpc.TxProof")
+	proto.RegisterType((*TreeState)(nil), "cash.z.wallet.sdk.rpc.TreeState")
+	proto.RegisterType((*GetSubtreeRootsArg)(nil), "cash.z.wallet.sdk.rpc.GetSubtreeRootsArg")
+	proto.RegisterType((*SubtreeRoot)(nil), "cash.z.wallet.sdk.rpc.SubtreeRoot")
+	proto.RegisterType((*ChainSpec)(nil), "cash.z.wallet.sdk.rpc.ChainSpec")
+	proto.RegisterType((*Empty)(nil), "cash.z.wallet.sdk.rpc.Empty")
+	proto.RegisterType((*PingRequest)(nil), "cash.z.wallet.sdk.rpc.PingRequest")
+	proto.RegisterType((*PingResponse)(nil), "cash.z.wallet.sdk.rpc.PingResponse")
+	proto.RegisterType((*ServerNotice)(nil), "cash.z.wallet.sdk.rpc.ServerNotice")
+	proto.RegisterType((*ServerNotices)(nil), "cash.z.wallet.sdk.rpc.ServerNotices")
+	proto.RegisterType((*LightdInfo)(nil), "cash.z.wallet.sdk.rpc.LightdInfo")
+	proto.RegisterType((*ConsensusBranch)(nil), "cash.z.wallet.sdk.rpc.ConsensusBranch")
+	proto.RegisterType((*ValuePool)(nil), "cash.z.wallet.sdk.rpc.ValuePool")
+	proto.RegisterType((*ValuePools)(nil), "cash.z.wallet.sdk.rpc.ValuePools")
+	proto.RegisterType((*NetworkUpgrade)(nil), "cash.z.wallet.sdk.rpc.NetworkUpgrade")
+	proto.RegisterType((*CheckpointIndex)(nil), "cash.z.wallet.sdk.rpc.CheckpointIndex")
+	proto.RegisterType((*TransparentAddress)(nil), "cash.z.wallet.sdk.rpc.TransparentAddress")
+	proto.RegisterType((*TransparentAddressBlockFilter)(nil), "cash.z.wallet.sdk.rpc.TransparentAddressBlockFilter")
+	proto.RegisterType((*AddressList)(nil), "cash.z.wallet.sdk.rpc.AddressList")
+	proto.RegisterType((*Balance)(nil), "cash.z.wallet.sdk.rpc.Balance")
+	proto.RegisterType((*GetAddressUtxosArg)(nil), "cash.z.wallet.sdk.rpc.GetAddressUtxosArg")
+	proto.RegisterType((*GetAddressUtxosReply)(nil), "cash.z.wallet.sdk.rpc.GetAddressUtxosReply")
+	proto.RegisterType((*GetAddressUtxosReplyList)(nil), "cash.z.wallet.sdk.rpc.GetAddressUtxosReplyList")
+	proto.RegisterType((*BalanceHistoryArg)(nil), "cash.z.wallet.sdk.rpc.BalanceHistoryArg")
+	proto.RegisterType((*BalanceDelta)(nil), "cash.z.wallet.sdk.rpc.BalanceDelta")
+}
+
+func init() { proto.RegisterFile("service.proto", fileDescriptor_a0b84a42fa06f626) }
+
+var fileDescriptor_a0b84a42fa06f626 = []byte{
+	// 2432 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xbc, 0x38, 0xdd, 0x6f, 0x1b, 0xc7,
+	0xf1, 0x3c, 0x51, 0x94, 0xc8, 0xa1, 0x3e, 0xe8, 0x4d, 0x9c, 0x5c, 0x88, 0xfc, 0x12, 0x65, 0x9d,
+	0x5f, 0xa2, 0x26, 0x81, 0x6a, 0xb8, 0x46, 0xfa, 0x81, 0xb6, 0xa8, 0x4c, 0x7f, 0x09, 0xb1, 0x25,
+	0xf5, 0x24, 0xb9, 0x6d, 0x52, 0x34, 0x58, 0xde, 0xad, 0xc8, 0xad, 0x8f, 0xb7, 0xd7, 0xbd, 0x25,
+	0x2d, 0xe5, 0xad, 0x40, 0x9e, 0xfa, 0x50, 0xa0, 0xff, 0x44, 0xd0, 0x02, 0x7d, 0xe8, 0xdf, 0xd1,
+	0xbf, 0xaa, 0x98, 0xdd, 0x25, 0x79, 0x3c, 0xea, 0x48, 0x3a, 0x28, 0xfa, 0x44, 0xce, 0xec, 0xdc,
+	0xec, 0xcc, 0xec, 0x7c, 0xc3, 0x76, 0xc6, 0xd5, 0x48, 0x84, 0xfc, 0x20, 0x55, 0x52, 0x4b, 0x72,
+	0x3b, 0x64, 0x59, 0xff, 0xe0, 0x9b, 0x83, 0x57, 0x2c, 0x8e, 0xb9, 0x3e, 0xc8, 0xa2, 0x97, 0x07,
+	0x2a, 0x0d, 0xdb, 0xb7, 0x43, 0x39, 0x48, 0x59, 0xa8, 0xbf, 0xbe, 0x94, 0x6a, 0xc0, 0x74, 0x66,
+	0xa9, 0xe9, 0x9f, 0x3d, 0xd8, 0x7c, 0x10, 0xcb, 0xf0, 0xe5, 0xd1, 0x43, 0xf2, 0x16, 0x6c, 0xf4,
+	0xb9, 0xe8, 0xf5, 0xb5, 0xef, 0xed, 0x79, 0xfb, 0xeb, 0x81, 0x83, 0x08, 0x81, 0xf5, 0x3e, 0xcb,
+	0xfa, 0xfe, 0xda, 0x9e, 0xb7, 0xbf, 0x15, 0x98, 0xff, 0x64, 0x0f, 0x9a, 0x22, 0x09, 0xe3, 0x61,
+	0xc4, 0x1f, 0x0f, 0xe3, 0xd8, 0xaf, 0xee, 0x79, 0xfb, 0xf5, 0x20, 0x8f, 0x22, 0xfb, 0xb0, 0xeb,
+	0xc0, 0x8e, 0x14, 0x49, 0x97, 0x65, 0xdc, 0x5f, 0x37, 0x54, 0x45, 0x34, 0xfd, 0x76, 0x0d, 0xc0,
+	0xc8, 0x10, 0xb0, 0xa4, 0xc7, 0xc9, 0x7d, 0xa8, 0x65, 0x9a, 0x29, 0x2b, 0x45, 0xf3, 0xde, 0x7b,
+	0x07, 0x37, 0x2a, 0x74, 0xe0, 0xa4, 0x0e, 0x2c, 0x31, 0xb9, 0x0b, 0x55, 0x9e, 0x44, 0xfe, 0xda,
+	0x4a, 0xdf, 0x20, 0x29, 0x39, 0x00, 0x12, 0xf6, 0x79, 0xf8, 0x32, 0x95, 0x22, 0xd1, 0x47, 0x89,
+	0xe6, 0x6a, 0xc4, 0xac, 0x26, 0xeb, 0xc1, 0x0d, 0x27, 0x68, 0x9e, 0x4b, 0x19, 0xc7, 0xf2, 0x95,
+	0xd3, 0xc3, 0x41, 0x37, 0x29, 0x5a, 0xbb, 0x51, 0x51, 0xf2, 0x2e, 0x34, 0xb2, 0x97, 0x22, 0x7d,
+	0x34, 0x48, 0xf5, 0xb5, 0xbf, 0x61, 0x68, 0xa6, 0x08, 0x2a, 0xa0, 0x69, 0xe4, 0x7b, 0xca, 0x59,
+	0xc4, 0xd5, 0x6b, 0xbd, 0x46, 0x1b, 0xea, 0xa9, 0xe2, 0xa3, 0xa7, 0x88, 0xaf, 0x1a, 0xfc, 0x04,
+	0x46, 0x7a, 0x2d, 0x06, 0xd6, 0xf8, 0xdb, 0x81, 0xf9, 0x4f, 0x7f, 0x06, 0xe4, 0x6c, 0xd8, 0xcd,
+	0x42, 0x25, 0xba, 0xdc, 0xdc, 0x99, 0x1d, 0xaa, 0x1e, 0xf9, 0x10, 0xb6, 0x9d, 0xc4, 0x16, 0x67,
+	0x2e, 0xae, 0x07, 0xb3, 0x48, 0xfa, 0x8d, 0x13, 0xf3, 0x22, 0x8d, 0x98, 0xe6, 0x68, 0x77, 0x2d,
+	0xd2, 0x15, 0xdf, 0x0a, 0x49, 0xc9, 0x4f, 0xa1, 0xd6, 0x45, 0xd8, 0xbd, 0xd5, 0x9d, 0x92, 0x6f,
+	0x3a, 0xd6, 0x5f, 0xad, 0x63, 0xd8, 0x2f, 0x68, 0x0c, 0x10, 0x70, 0xa9, 0x7a, 0x8f, 0x46, 0x3c,
+	0xd1, 0xe4, 0x23, 0xd8, 0x61, 0x49, 0xc8, 0x33, 0x2d, 0xd5, 0xd3, 0xbc, 0xa5, 0x0a, 0x58, 0xf2,
+	0x39, 0x6c, 0x24, 0xfc, 0xd5, 0xb9, 0x48, 0x57, 0xf4, 0x0e, 0x47, 0x4d, 0x29, 0x6c, 0x19, 0xd4,
+	0xb9, 0x18, 0x70, 0xb4, 0xcf, 0xd8, 0x92, 0x5e, 0xce, 0x92, 0x7f, 0x84, 0xfa, 0xf9, 0xd5, 0x63,
+	0x11, 0x6b, 0xae, 0xd0, 0x71, 0xad, 0x62, 0x2b, 0x3a, 0xae, 0x21, 0x26, 0x6f, 0x42, 0x4d, 0x24,
+	0x11, 0xbf, 0x32, 0xc2, 0xad, 0x07, 0x16, 0x98, 0xbc, 0x72, 0x75, 0xfa, 0xca, 0xf4, 0xe7, 0xb0,
+	0x13, 0xb0, 0x57, 0xe7, 0x8a, 0x25, 0x19, 0x0b, 0xb5, 0x90, 0x09, 0x52, 0x45, 0x4c, 0x33, 0x73,
+	0xe1, 0x56, 0x60, 0xfe, 0xe7, 0xfc, 0x66, 0x2d, 0xef, 0x37, 0xf4, 0x14, 0xb6, 0xce, 0x78, 0x12,
+	0x05, 0x3c, 0x4b, 0x65, 0x62, 0x9d, 0x91, 0x2b, 0x25, 0x55, 0x47, 0x46, 0x56, 0xa5, 0x5a, 0x30,
+	0x45, 0x10, 0x0a, 0x5b, 0x06, 0x78, 0xce, 0xb3, 0x8c, 0xf5, 0xb8, 0xe1, 0xd5, 0x08, 0x66, 0x70,
+	0xf4, 0xdf, 0x1e, 0x2a, 0x7f, 0xa6, 0x99, 0x1e, 0x66, 0xe4, 0x97, 0xb0, 0x91, 0x99, 0x7f, 0x86,
+	0xd7, 0xce, 0xbd, 0x8f, 0x4a, 0xb4, 0x1f, 0x7f, 0x70, 0x60, 0x7f, 0x02, 0xf7, 0x55, 0x99, 0xd8,
+	0xe8, 0x94, 0xa1, 0x4c, 0x2e, 0x05, 0x26, 0x2d, 0x21, 0x93, 0xcc, 0x05, 0xe8, 0x2c, 0x92, 0xfe,
+	0x0a, 0x36, 0x9c, 0x1c, 0x4d, 0xd8, 0xbc, 0x38, 0xfe, 0xe2, 0xf8, 0xe4, 0x37, 0xc7, 0xad, 0x0a,
+	0xd9, 0x01, 0x38, 0x3a, 0xfe, 0xfa, 0xf9, 0xa3, 0xe7, 0xa7, 0x27, 0x27, 0xcf, 0x5a, 0x1e, 0x69,
+	0x40, 0xed, 0xf9, 0xd1, 0xf1, 0xa3, 0x87, 0xad, 0x35, 0x3c, 0xea, 0x9c, 0x1c, 0x3f, 0x7e, 0x76,
+	0xd4, 0x39, 0x7f, 0xf4, 0xb0, 0x55, 0xa5, 0x3d, 0xd8, 0x3c, 0xbf, 0x3a, 0x55, 0x52, 0x5e, 0x5a,
+	0x51, 0x30, 0x06, 0x9d, 0x5d, 0x1d, 0x54, 0x2a, 0xe2, 0xe4, 0x05, 0xab, 0xc6, 0x31, 0x2c, 0x80,
+	0xd4, 0x5d, 0xc5, 0x92, 0xb0, 0xef, 0xaf, 0xef, 0x55, 0x91, 0x8b, 0x85, 0xe8, 0x35, 0x34, 0xce,
+	0x15, 0xe7, 0x28, 0x2e, 0x27, 0x3e, 0x6c, 0x26, 0x5c, 0xbf, 0x92, 0xca, 0x3a, 0x4d, 0x23, 0x18,
+	0x83, 0xa5, 0x97, 0xe5, 0x1d, 0xa3, 0xe1, 0xc2, 0xff, 0x86, 0x10, 0x37, 0x38, 0xc5, 0x6d, 0x2a,
+	0x6a, 0x04, 0xe6, 0x3f, 0xfd, 0x87, 0x07, 0xe4, 0x09, 0xd7, 0x67, 0xc3, 0x2e, 0x82, 0x81, 0x94,
+	0xda, 0xc4, 0xfd, 0x7b, 0x00, 0x26, 0x87, 0x1e, 0x19, 0x25, 0xac, 0x77, 0xe7, 0x30, 0xe4, 0x0c,
+	0x5a, 0x59, 0x5f, 0xf0, 0x38, 0xe2, 0xd1, 0x29, 0x16, 0x8d, 0x50, 0xc6, 0x46, 0xa8, 0x9d, 0x7b,
+	0x1f, 0x97, 0x3c, 0xf2, 0x59, 0x81, 0x3c, 0x98, 0x63, 0x80, 0x97, 0x0e, 0xd8, 0xd5, 0xa3, 0x44,
+	0x2b, 0xc1, 0x33, 0x67, 0xb9, 0x1c, 0x86, 0xfe, 0xcd, 0x83, 0x66, 0x4e, 0x50, 0x4c, 0x71, 0x4a,
+	0x4a, 0xfd, 0x74, 0x9a, 0xfa, 0x26, 0x30, 0xb9, 0x0b, 0x6f, 0x60, 0x75, 0x8b, 0xb9, 0x16, 0x49,
+	0xcf, 0xe6, 0xd0, 0x69, 0xec, 0xdc, 0x74, 0x44, 0xee, 0xc3, 0xed, 0x22, 0xda, 0x1a, 0x7b, 0xdd,
+	0x18, 0xfb, 0xe6, 0x43, 0xda, 0x84, 0x46, 0xa7, 0xcf, 0x44, 0x72, 0x96, 0xf2, 0x90, 0x6e, 0x42,
+	0xcd, 0xe6, 0xed, 0x8f, 0xa1, 0x79, 0x2a, 0x92, 0x5e, 0xc0, 0xff, 0x34, 0xe4, 0x99, 0xc6, 0x27,
+	0x4d, 0xd9, 0x75, 0x2c, 0x59, 0xe4, 0xdc, 0x67, 0x0c, 0xd2, 0x7f, 0x7a, 0xb0, 0x65, 0x29, 0x5d,
+	0x08, 0x96, 0x92, 0xa2, 0x75, 0x14, 0x0f, 0xb9, 0x18, 0xf1, 0xe8, 0xd0, 0x7a, 0x40, 0x35, 0xc8,
+	0x61, 0xd0, 0x3b, 0x32, 0x9e, 0xe8, 0x43, 0x6d, 0x94, 0xac, 0x06, 0x0e, 0xc2, 0xb2, 0x1c, 0x33,
+	0xcd, 0x33, 0x9b, 0x36, 0x9d, 0x36, 0x79, 0x14, 0x56, 0xab, 0x1c, 0x88, 0xa9, 0xcd, 0xb8, 0xc8,
+	0x76, 0x50, 0x44, 0xd3, 0xbf, 0x78, 0x98, 0x31, 0xd4, 0x88, 0xab, 0x63, 0xa9, 0x45, 0xc8, 0xc9,
+	0x0e, 0xac, 0x89, 0xc8, 0xf9, 0xe9, 0x9a, 0x88, 0xd0, 0xef, 0x63, 0x3e, 0xe2, 0xb1, 0x4b, 0x0e,
+	0x16, 0x40, 0xa5, 0x06, 0x2e, 0x69, 0x58, 0x1f, 0x1d, 0x83, 0xa4, 0x05, 0xd5, 0xa1, 0x8a, 0x8d,
+	0x50, 0x8d, 0x00, 0xff, 0xa2, 0x93, 0x5e, 0x2a, 0x39, 0x30, 0x12, 0x54, 0x03, 0xf3, 0x1f, 0xb9,
+	0x0e, 0x13, 0x2d, 0x62, 0x53, 0x20, 0xab, 0x81, 0x05, 0xe8, 0x31, 0x6c, 0xe7, 0x65, 0xc9, 0xc8,
+	0x2f, 0x60, 0x33, 0xb1, 0x7f, 0x7d, 0x6f, 0xaf, 0xba, 0xa0, 0x8e, 0xe4, 0x3f, 0x0b, 0xc6, 0xdf,
+	0xd0, 0x7f, 0xd5, 0x01, 0x9e, 0xe1, 0xa3, 0x46, 0x47, 0xc9, 0xa5, 0x44, 0xa1, 0x47, 0x5c, 0x65,
+	0x42, 0x26, 0xe3, 0x38, 0x74, 0x20, 0x5a, 0x7a, 0xc4, 0x93, 0x48, 0x2a, 0xa7, 0xa5, 0x83, 0x30,
+	0x41, 0x6a, 0x16, 0x45, 0xea, 0x6c, 0x98, 0xa6, 0x52, 0x69, 0xd7, 0x01, 0xcd, 0xe0, 0x30, 0xc5,
+	0x86, 0xe8, 0x2f, 0xc7, 0xcc, 0x05, 0x67, 0x23, 0x98, 0x22, 0xc8, 0x4f, 0xe0, 0xed, 0x8c, 0xa5,
+	0xb1, 0x48, 0x7a, 0x87, 0xa1, 0x16, 0x23, 0x93, 0xc9, 0x9c, 0x17, 0xd6, 0xcc, 0xbb, 0x95, 0x1d,
+	0x93, 0xcf, 0xe0, 0x56, 0x88, 0x0e, 0x94, 0x64, 0xc3, 0xec, 0x81, 0xc9, 0x2a, 0x47, 0x91, 0x31,
+	0x57, 0x23, 0x98, 0x3f, 0x40, 0x9f, 0xe8, 0xe6, 0x3c, 0x7c, 0xd3, 0xfa, 0x44, 0x0e, 0x85, 0xfc,
+	0x22, 0x9e, 0x2a, 0x1e, 0x32, 0xcd, 0xa3, 0xe7, 0x5c, 0xf7, 0x65, 0x94, 0xf9, 0xf5, 0xbd, 0x2a,
+	0xf2, 0x9b, 0x3b, 0x40, 0xad, 0x32, 0x53, 0x48, 0x58, 0x74, 0xed, 0x37, 0x5c, 0x17, 0x33, 0x46,
+	0x8c, 0x23, 0x8b, 0x85, 0xfa, 0xb1, 0x69, 0x34, 0x5f, 0x58, 0x3b, 0x66, 0x3e, 0xec, 0x55, 0xf7,
+	0xb7, 0x83, 0x9b, 0x0f, 0x31, 0xcb, 0x27, 0x32, 0xe2, 0x01, 0x67, 0x61, 0x9f, 0x75, 0x63, 0xee,
+	0x37, 0x6d, 0xeb, 0x31, 0x83, 0xc4, 0x82, 0x8f, 0x88, 0xb3, 0x61, 0x77, 0xfc, 0x58, 0x5b, 0x46,
+	0xe9, 0x02, 0x16, 0x35, 0x1e, 0xf0, 0x41, 0x2a, 0x65, 0x7c, 0x26, 0xbe, 0xe1, 0xfe, 0xb6, 0xd5,
+	0x38, 0x87, 0x42, 0x1d, 0x7a, 0x42, 0x77, 0xe4, 0x60, 0x20, 0xb4, 0xbf, 0x63, 0x5f, 0x66, 0x82,
+	0xc0, 0xd3, 0xee, 0x50, 0xc4, 0xd1, 0x43, 0xa6, 0xb9, 0xbf, 0x6b, 0x4f, 0x27, 0x08, 0x8c, 0x20,
+	0x9e, 0x69, 0x31, 0x40, 0x9b, 0x38, 0x9b, 0xb6, 0xcc, 0x0d, 0x45, 0x34, 0xca, 0x81, 0x92, 0x39,
+	0x2d, 0xfd, 0x5b, 0x56, 0x8e, 0x1c, 0x0a, 0x79, 0x45, 0x32, 0x31, 0x6f, 0x7b, 0x18, 0x45, 0x8a,
+	0x67, 0x99, 0x4f, 0xcc, 0x7d, 0x45, 0x34, 0xf9, 0x04, 0x5a, 0x63, 0xd4, 0x39, 0x73, 0xa4, 0x6f,
+	0x18, 0xd2, 0x39, 0x3c, 0xfa, 0xa6, 0x4c, 0xb9, 0x62, 0x5a, 0x2a, 0xe3, 0x7a, 0x6f, 0xda, 0xe2,
+	0x9d, 0xc7, 0xe1, 0xcd, 0x63, 0xb8, 0x23, 0x13, 0xcd, 0x42, 0xed, 0xdf, 0xb6, 0x37, 0x17, 0xd0,
+	0x78, 0x73, 0xaa, 0xc4, 0x88, 0x85, 0xd7, 0xa7, 0x32, 0x16, 0xe1, 0xf5, 0x85, 0x8a, 0xfd, 0xb7,
+	0xec, 0xcd, 0x45, 0x3c, 0x39, 0x84, 0xfa, 0x30, 0xed, 0x29, 0x16, 0xf1, 0xcc, 0x7f, 0xdb, 0x84,
+	0xe5, 0xff, 0x97, 0x84, 0xe5, 0xb1, 0xad, 0x73, 0x17, 0x96, 0x3a, 0x98, 0x7c, 0x96, 0x0f, 0x6c,
+	0xff, 0xf5, 0x03, 0x1b, 0xf5, 0x4a, 0x5d, 0x8d, 0x19, 0xdb, 0xfd, 0x1d, 0x9b, 0xdf, 0x0a, 0x68,
+	0xec, 0xff, 0x07, 0x22, 0x39, 0x2d, 0x10, 0xb7, 0x0d, 0xf1, 0x0d, 0x27, 0xb4, 0x07, 0xbb, 0x9d,
+	0xd9, 0xe0, 0x2a, 0xed, 0xd1, 0xdb, 0x50, 0xef, 0x8e, 0xe3, 0xd2, 0xa6, 0x8d, 0x09, 0x8c, 0x4e,
+	0xe1, 0x74, 0x35, 0x6f, 0x63, 0x73, 0x64, 0x1e, 0x45, 0x2f, 0xa0, 0xf1, 0x82, 0xc5, 0x43, 0x7e,
+	0x2a, 0x65, 0x3c, 0x97, 0x74, 0xdb, 0x50, 0x1f, 0xe1, 0xe1, 0x97, 0x6c, 0x5c, 0x17, 0x26, 0x30,
+	0xfa, 0xed, 0x40, 0x26, 0x42, 0x4b, 0xc5, 0x23, 0x97, 0x90, 0xa6, 0x08, 0xfa, 0x9d, 0x07, 0x30,
+	0xe1, 0x9b, 0x95, 0xca, 0xfe, 0x39, 0xd4, 0x30, 0x4c, 0x32, 0x7f, 0xcd, 0x58, 0x7f, 0xaf, 0xc4,
+	0xfa, 0x13, 0x4e, 0x81, 0x25, 0xc7, 0xe0, 0xd4, 0x52, 0xb3, 0x18, 0x93, 0x5f, 0x7c, 0x8d, 0xe2,
+	0xd9, 0xd2, 0x54, 0xc0, 0xa2, 0xfe, 0x99, 0x01, 0xbe, 0x48, 0xe4, 0xab, 0xc4, 0xcd, 0x52, 0x79,
+	0x14, 0xfd, 0xd6, 0x83, 0x9d, 0x59, 0xf7, 0xc0, 0x42, 0x91, 0x30, 0xd7, 0x7a, 0x37, 0x02, 0xf3,
+	0x7f, 0xa1, 0x91, 0x3f, 0x81, 0x16, 0x2b, 0x26, 0x55, 0xdb, 0x38, 0xce, 0xe1, 0x4d, 0x2d, 0xb5,
+	0x9d, 0xab, 0x4d, 0xd1, 0x0e, 0xa2, 0x0a, 0x76, 0x3b, 0xb9, 0x29, 0x10, 0x3b, 0xa1, 0x36, 0xd4,
+	0xc5, 0x78, 0x50, 0xb4, 0x56, 0x9b, 0xc0, 0xa4, 0x03, 0xcd, 0xe9, 0xd0, 0x38, 0xb6, 0xde, 0x07,
+	0x65, 0xc3, 0xcd, 0x84, 0x32, 0xc8, 0x7f, 0x45, 0x0f, 0x80, 0x98, 0xfe, 0x3e, 0x65, 0x0a, 0x0b,
+	0xba, 0x8b, 0x67, 0x1f, 0x36, 0xc7, 0x21, 0xef, 0xaa, 0x93, 0x03, 0xa9, 0x82, 0xff, 0x9b, 0xa7,
+	0x37, 0x25, 0xdc, 0xcd, 0x24, 0xa5, 0x9f, 0x92, 0x1f, 0x43, 0x4d, 0xe1, 0xbc, 0xed, 0x86, 0xa2,
+	0x0f, 0x16, 0x4d, 0x2b, 0x66, 0x30, 0x0f, 0x2c, 0x3d, 0xfd, 0x14, 0x9a, 0xee, 0xa2, 0x67, 0x22,
+	0x33, 0x4e, 0xe7, 0x58, 0xba, 0x52, 0xdc, 0x08, 0xa6, 0x08, 0x7a, 0x01, 0x9b, 0x0f, 0x58, 0x8c,
+	0x03, 0x19, 0x66, 0x25, 0xd7, 0xb4, 0xf3, 0x08, 0xdd, 0xc3, 0x33, 0xee, 0x31, 0x83, 0x43, 0x27,
+	0x1a, 0x26, 0x33, 0x54, 0xd6, 0xc7, 0x0b, 0x58, 0xaa, 0x4d, 0x23, 0xeb, 0xc4, 0xb8, 0xd0, 0x57,
+	0xd2, 0x34, 0xb2, 0x0b, 0x45, 0x31, 0x8e, 0xa7, 0x99, 0xd2, 0x4f, 0xf3, 0x6d, 0x75, 0x1e, 0xb5,
+	0xb4, 0x27, 0xfd, 0xce, 0x83, 0x37, 0x0b, 0xd7, 0x06, 0x3c, 0x8d, 0xaf, 0x4d, 0xb3, 0x7d, 0x25,
+	0xc6, 0x5d, 0x9c, 0xf9, 0x3f, 0x3b, 0xd7, 0xd5, 0x72, 0x53, 0x01, 0x8e, 0xdd, 0xa9, 0x76, 0xdd,
+	0xa9, 0x83, 0x66, 0xc2, 0x7a, 0xbd, 0x10, 0xd6, 0xd3, 0x48, 0xad, 0xcd, 0x44, 0x6a, 0xee, 0x6d,
+	0x37, 0x66, 0xdd, 0xe2, 0x25, 0xf8, 0x37, 0xc9, 0x69, 0xde, 0xeb, 0x04, 0xb6, 0x58, 0xee, 0xc0,
+	0x75, 0x4f, 0x9f, 0x96, 0x3c, 0xff, 0x4d, 0x6c, 0x82, 0x19, 0x06, 0xf4, 0xef, 0x1e, 0xdc, 0x72,
+	0x6f, 0xfc, 0x54, 0xe0, 0xd8, 0x7d, 0x8d, 0x6f, 0x51, 0xee, 0x78, 0x2f, 0xa0, 0xd9, 0x53, 0x2c,
+	0x19, 0xc6, 0x4c, 0x09, 0x7d, 0xed, 0x26, 0x89, 0xfb, 0x65, 0xee, 0x57, 0x64, 0x7c, 0xf0, 0x64,
+	0xfa, 0x6d, 0x90, 0x67, 0x44, 0x3f, 0x80, 0x66, 0xee, 0x0c, 0x67, 0xbd, 0x07, 0xcf, 0x4e, 0x3a,
+	0x5f, 0xb4, 0x2a, 0x64, 0x13, 0xaa, 0x0f, 0x0f, 0x7f, 0xd7, 0xf2, 0xe8, 0x08, 0xb6, 0x1c, 0xc3,
+	0x87, 0x3c, 0x9e, 0x99, 0x95, 0xe7, 0x76, 0x2c, 0x66, 0xa0, 0x5a, 0xcb, 0x0d, 0x54, 0x6d, 0xa8,
+	0x47, 0xf8, 0xd1, 0x34, 0xb3, 0x4d, 0x60, 0x74, 0x9c, 0xae, 0xe5, 0x3b, 0x7d, 0xbf, 0x1c, 0xe6,
+	0x93, 0xcf, 0xa0, 0x55, 0x1c, 0x89, 0x70, 0x50, 0x75, 0xfd, 0x5d, 0xab, 0x82, 0x80, 0x54, 0x61,
+	0x9f, 0xa9, 0xa8, 0xe5, 0xdd, 0xfb, 0xeb, 0x6d, 0xb8, 0xe5, 0xb6, 0x1f, 0x38, 0x2d, 0x2b, 0xce,
+	0x06, 0x5c, 0x91, 0x73, 0xd8, 0x79, 0xc2, 0xf5, 0xb3, 0x5c, 0x2b, 0xbf, 0x57, 0x9a, 0x5c, 0xdc,
+	0x8c, 0xd2, 0x5e, 0xb2, 0x82, 0xa0, 0x15, 0xf2, 0x6b, 0xa8, 0x3f, 0xe1, 0x8e, 0xdf, 0x12, 0xea,
+	0xf6, 0x2a, 0x9b, 0x1a, 0x5a, 0x21, 0x5f, 0xc1, 0xf6, 0x98, 0xa5, 0x5d, 0xe8, 0x2d, 0x4f, 0x2d,
+	0x2b, 0xb2, 0xbe, 0xeb, 0x91, 0xdf, 0xc3, 0xee, 0x98, 0xb9, 0xdd, 0x93, 0x65, 0xab, 0xb0, 0xa7,
+	0x8b, 0x48, 0x2c, 0x1f, 0xc3, 0x9d, 0xc3, 0xdb, 0x33, 0xa2, 0x1f, 0x0f, 0xe3, 0x58, 0x5c, 0x8a,
+	0x15, 0x6f, 0x59, 0x59, 0x89, 0xaf, 0xcc, 0x53, 0x1a, 0xf8, 0xc1, 0x35, 0xce, 0x5a, 0xe4, 0xce,
+	0x22, 0xee, 0x6e, 0xff, 0xb4, 0x9a, 0x16, 0x24, 0x82, 0xdd, 0xc2, 0x6e, 0x8f, 0xfc, 0xa0, 0xac,
+	0x83, 0x9a, 0xdb, 0x01, 0x2e, 0xbe, 0xc3, 0xae, 0xfc, 0x8c, 0x0a, 0x2f, 0x72, 0xb7, 0x98, 0x95,
+	0x5c, 0x46, 0xde, 0x2d, 0xf9, 0xd4, 0x4c, 0xc9, 0xed, 0x32, 0xfb, 0x4d, 0xf7, 0x79, 0xee, 0x7d,
+	0x31, 0xb1, 0x17, 0xeb, 0xee, 0x62, 0xd6, 0x1f, 0x2d, 0x2d, 0xb2, 0x86, 0x0b, 0xad, 0x90, 0x00,
+	0xb6, 0x9e, 0x70, 0x3d, 0x5d, 0xbf, 0x2c, 0xf3, 0xf8, 0xb2, 0x08, 0x9b, 0x70, 0xb0, 0xf6, 0x2e,
+	0xec, 0x54, 0x4a, 0xed, 0x3d, 0xbf, 0x7b, 0x29, 0xb5, 0x77, 0x8e, 0xce, 0xd8, 0xe5, 0x4b, 0xe3,
+	0x32, 0xf9, 0xdd, 0xdf, 0xfb, 0xa5, 0x0b, 0x36, 0x5b, 0xfa, 0xdb, 0x65, 0x9d, 0xf7, 0xec, 0x0e,
+	0x91, 0x56, 0xc8, 0xd7, 0x46, 0x83, 0x1c, 0x2e, 0xfb, 0xef, 0x31, 0xdf, 0xf7, 0xee, 0x7a, 0x78,
+	0x01, 0xae, 0x1e, 0xf3, 0xd2, 0xaf, 0xf6, 0x7d, 0xbb, 0xbc, 0xf7, 0x9f, 0x6e, 0x32, 0x4d, 0x16,
+	0x6b, 0xa2, 0x06, 0xe3, 0x5d, 0xe4, 0x52, 0xe9, 0xdf, 0x5f, 0xb2, 0x9c, 0xa4, 0x15, 0x72, 0x02,
+	0x60, 0x58, 0xda, 0x95, 0xe0, 0x52, 0x8e, 0xef, 0x95, 0x12, 0x18, 0x06, 0xb4, 0x42, 0x14, 0xec,
+	0x4e, 0x8b, 0xe9, 0xf9, 0x95, 0x88, 0x32, 0x72, 0xbf, 0xd4, 0xbd, 0x16, 0xb4, 0x74, 0x2b, 0x9b,
+	0xfe, 0xae, 0x47, 0x32, 0x68, 0xa1, 0x12, 0xec, 0x7f, 0x7a, 0xa9, 0xcc, 0x2b, 0x6a, 0x5a, 0x84,
+	0x45, 0x01, 0x51, 0xe8, 0xe1, 0xda, 0x3f, 0x7c, 0x8d, 0x46, 0x04, 0xfb, 0x19, 0x5a, 0x21, 0x19,
+	0xdc, 0x2e, 0x9c, 0xda, 0xa2, 0xf9, 0x3a, 0xd7, 0xbe, 0x4e, 0xff, 0xe3, 0x02, 0x92, 0xe4, 0x4c,
+	0x3b, 0xe9, 0x71, 0x4b, 0xd8, 0xe4, 0x1a, 0xe6, 0xf2, 0xa2, 0x6c, 0x79, 0xd0, 0x0a, 0x11, 0xe0,
+	0xcf, 0xf3, 0x5e, 0xa2, 0xd3, 0xfc, 0xf3, 0x2d, 0xbf, 0x68, 0xdf, 0x23, 0x09, 0xbc, 0x33, 0x7f,
+	0x95, 0xeb, 0xb6, 0xc8, 0xfe, 0xaa, 0x4d, 0x59, 0xfb, 0xce, 0x62, 0x4a, 0xd3, 0x6d, 0x19, 0xb3,
+	0x05, 0xa6, 0x39, 0xc8, 0x6d, 0xde, 0xbe, 0x5f, 0xd5, 0x98, 0x32, 0xa0, 0x15, 0xf2, 0x07, 0x5b,
+	0x33, 0x0a, 0xb3, 0xf9, 0xb2, 0xdc, 0x5e, 0x5a, 0x35, 0x66, 0xf9, 0x98, 0xec, 0xb2, 0x8e, 0x6b,
+	0xdb, 0xd2, 0xc7, 0xcd, 0x6d, 0x7f, 0xdb, 0x77, 0x16, 0xd2, 0x4c, 0x12, 0xd6, 0x6f, 0x4d, 0x60,
+	0xce, 0x6e, 0x34, 0x17, 0x5b, 0xe2, 0xc3, 0x15, 0xb6, 0x20, 0x19, 0xad, 0x38, 0x03, 0xe7, 0xe6,
+	0xfc, 0xef, 0x67, 0xe0, 0x29, 0x03, 0x5a, 0x79, 0xd0, 0xfc, 0xb2, 0x61, 0x8f, 0x55, 0x1a, 0x76,
+	0x37, 0xcc, 0x1e, 0xe5, 0x47, 0xff, 0x19, 0x00, 0xe5, 0xbf, 0x44, 0xae, 0x77, 0x1e, 0x00, 0x00,
+}
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ context.Context
+var _ grpc.ClientConn
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+const _ = grpc.SupportPackageIsVersion4
+
+// CompactTxStreamerClient is the client API for CompactTxStreamer service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://godoc.org/google.golang.org/grpc#ClientConn.NewStream.
+type CompactTxStreamerClient interface {
+	// Compact Blocks
+	GetLatestBlock(ctx context.Context, in *ChainSpec, opts ...grpc.CallOption) (*BlockID, error)
+	GetBlock(ctx context.Context, in *BlockID, opts ...grpc.CallOption) (*CompactBlock, error)
+	// GetBlockRange streams the blocks of a range. With skipEmpty, blocks
+	// without transactions are left out, but for the last of the range,
+	// those carrying a checkpoint, and one after each run of as many
+	// skipped blocks as the server sets, so that clients can follow its
+	// progress.
 	GetBlockRange(ctx context.Context, in *BlockRange, opts ...grpc.CallOption) (CompactTxStreamer_GetBlockRangeClient, error)
+	// GetBlockHeaders streams the headers of the blocks of a range, up or
+	// down; only its start and end are used.
+	GetBlockHeaders(ctx context.Context, in *BlockRange, opts ...grpc.CallOption) (CompactTxStreamer_GetBlockHeadersClient, error)
+	// GetBlockRangeNullifiers streams the blocks of a range, up or down,
+	// with only the transactions that spend notes, and only their
+	// nullifiers, for wallets that know their notes and look for spends.
+	GetBlockRangeNullifiers(ctx context.Context, in *BlockRange, opts ...grpc.CallOption) (CompactTxStreamer_GetBlockRangeNullifiersClient, error)
+	// GetBlockByTime returns the header of the first cached block whose
+	// time is at or after the given time, for example to turn a wallet's
+	// birthday into the height to start scanning from.
+	GetBlockByTime(ctx context.Context, in *BlockTimeArg, opts ...grpc.CallOption) (*BlockHeader, error)
+	// SubscribeBlocks sends the current tip, then each block the server
+	// adds, so that wallets don't need to poll GetLatestBlock. A tip lower
+	// than the last one means a reorg. Updates without a tip are pings,
+	// sent when the chain is quiet, so that proxies keep the stream open.
+	SubscribeBlocks(ctx context.Context, in *SubscribeBlocksArg, opts ...grpc.CallOption) (CompactTxStreamer_SubscribeBlocksClient, error)
+	// SubscribeReorgs sends an event each time the server's chain is
+	// reorganized, with pings in between like SubscribeBlocks.
+	SubscribeReorgs(ctx context.Context, in *Empty, opts ...grpc.CallOption) (CompactTxStreamer_SubscribeReorgsClient, error)
+	GetCheckpointIndex(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*CheckpointIndex, error)
+	// GetTreeState returns the Sapling commitment tree as of the block
+	// with BlockID.hash, or else BlockID.height.
+	GetTreeState(ctx context.Context, in *BlockID, opts ...grpc.CallOption) (*TreeState, error)
+	// GetSubtreeRoots streams the roots of completed note commitment
+	// subtrees, in index order, from the node's z_getsubtreesbyindex, so
+	// that wallets can spend before they've scanned the whole chain.
+	GetSubtreeRoots(ctx context.Context, in *GetSubtreeRootsArg, opts ...grpc.CallOption) (CompactTxStreamer_GetSubtreeRootsClient, error)
 	// Transactions
 	GetTransaction(ctx context.Context, in *TxFilter, opts ...grpc.CallOption) (*RawTransaction, error)
+	// GetTransactions returns the transactions whose txids the client
+	// streams, in the same order, fetching them from the node in batches.
+	// Only TxFilter.hash is supported.
+	GetTransactions(ctx context.Context, opts ...grpc.CallOption) (CompactTxStreamer_GetTransactionsClient, error)
 	SendTransaction(ctx context.Context, in *RawTransaction, opts ...grpc.CallOption) (*SendResponse, error)
+	// GetTxStatus reports whether a transaction, by TxFilter.hash, was mined,
+	// is waiting in the mempool, or neither.
+	GetTxStatus(ctx context.Context, in *TxFilter, opts ...grpc.CallOption) (*TxStatus, error)
+	// GetTxProof returns the Merkle proof that a mined transaction, by
+	// TxFilter.hash, is in its block, for clients that check the server.
+	GetTxProof(ctx context.Context, in *TxFilter, opts ...grpc.CallOption) (*TxProof, error)
 	// t-Address support
+	// GetAddressTxids is superseded by GetTaddressTxids, which pages through
+	// long ranges and reports errors with gRPC status codes.
 	GetAddressTxids(ctx context.Context, in *TransparentAddressBlockFilter, opts ...grpc.CallOption) (CompactTxStreamer_GetAddressTxidsClient, error)
+	// GetTaddressTxids streams the transactions touching a transparent
+	// address within the range, in the order the node lists them.
+	GetTaddressTxids(ctx context.Context, in *TransparentAddressBlockFilter, opts ...grpc.CallOption) (CompactTxStreamer_GetTaddressTxidsClient, error)
+	// GetAddressUtxos returns the unspent outputs of transparent addresses,
+	// in height order.
+	GetAddressUtxos(ctx context.Context, in *GetAddressUtxosArg, opts ...grpc.CallOption) (*GetAddressUtxosReplyList, error)
+	// GetAddressUtxosStream streams them instead, address by address, each
+	// in order of height, txid and index; to resume, ask again for the last
+	// address from the last height received, and for those after it.
+	GetAddressUtxosStream(ctx context.Context, in *GetAddressUtxosArg, opts ...grpc.CallOption) (CompactTxStreamer_GetAddressUtxosStreamClient, error)
+	// GetTaddressBalance returns the total balance of transparent addresses;
+	// GetTaddressBalanceStream does the same for addresses sent one by one.
+	GetTaddressBalance(ctx context.Context, in *AddressList, opts ...grpc.CallOption) (*Balance, error)
+	GetTaddressBalanceStream(ctx context.Context, opts ...grpc.CallOption) (CompactTxStreamer_GetTaddressBalanceStreamClient, error)
+	// GetTaddressBalanceHistory streams the changes in the balance of a
+	// transparent address, oldest first, for charting it. It needs the
+	// server's address index.
+	GetTaddressBalanceHistory(ctx context.Context, in *BalanceHistoryArg, opts ...grpc.CallOption) (CompactTxStreamer_GetTaddressBalanceHistoryClient, error)
 	// Misc
 	GetLightdInfo(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*LightdInfo, error)
+	// GetConsensusBranch returns the consensus branch at BlockID.height,
+	// from the network upgrades the node has scheduled.
+	GetConsensusBranch(ctx context.Context, in *BlockID, opts ...grpc.CallOption) (*ConsensusBranch, error)
+	// Ping answers without calling the node, to measure round trips and
+	// tell a server that's down from one whose node is behind.
+	Ping(ctx context.Context, in *PingRequest, opts ...grpc.CallOption) (*PingResponse, error)
+	// GetServerNotices returns the operator's current notices, which wallets
+	// should show their users.
+	GetServerNotices(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*ServerNotices, error)
+	// GetValuePools returns the value in each shielded and transparent pool
+	// and the total supply at the node's tip, for network statistics.
+	GetValuePools(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*ValuePools, error)
+}
+
+type compactTxStreamerClient struct {
+	cc *grpc.ClientConn
+}
+
+func NewCompactTxStreamerClient(cc *grpc.ClientConn) CompactTxStreamerClient {
+	return &compactTxStreamerClient{cc}
+}
+
+func (c *compactTxStreamerClient) GetLatestBlock(ctx context.Context, in *ChainSpec, opts ...grpc.CallOption) (*BlockID, error) {
+	out := new(BlockID)
+	err := c.cc.Invoke(ctx, "/cash.z.wallet.sdk.rpc.CompactTxStreamer/GetLatestBlock", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *compactTxStreamerClient) GetBlock(ctx context.Context, in *BlockID, opts ...grpc.CallOption) (*CompactBlock, error) {
+	out := new(CompactBlock)
+	err := c.cc.Invoke(ctx, "/cash.z.wallet.sdk.rpc.CompactTxStreamer/GetBlock", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *compactTxStreamerClient) GetBlockRange(ctx context.Context, in *BlockRange, opts ...grpc.CallOption) (CompactTxStreamer_GetBlockRangeClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_CompactTxStreamer_serviceDesc.Streams[0], "/cash.z.wallet.sdk.rpc.CompactTxStreamer/GetBlockRange", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &compactTxStreamerGetBlockRangeClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type CompactTxStreamer_GetBlockRangeClient interface {
+	Recv() (*CompactBlock, error)
+	grpc.ClientStream
+}
+
+type compactTxStreamerGetBlockRangeClient struct {
+	grpc.ClientStream
+}
+
+func (x *compactTxStreamerGetBlockRangeClient) Recv() (*CompactBlock, error) {
+	m := new(CompactBlock)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *compactTxStreamerClient) GetBlockHeaders(ctx context.Context, in *BlockRange, opts ...grpc.CallOption) (CompactTxStreamer_GetBlockHeadersClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_CompactTxStreamer_serviceDesc.Streams[1], "/cash.z.wallet.sdk.rpc.CompactTxStreamer/GetBlockHeaders", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &compactTxStreamerGetBlockHeadersClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type CompactTxStreamer_GetBlockHeadersClient interface {
+	Recv() (*BlockHeader, error)
+	grpc.ClientStream
+}
+
+type compactTxStreamerGetBlockHeadersClient struct {
+	grpc.ClientStream
+}
+
+func (x *compactTxStreamerGetBlockHeadersClient) Recv() (*BlockHeader, error) {
+	m := new(BlockHeader)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *compactTxStreamerClient) GetBlockRangeNullifiers(ctx context.Context, in *BlockRange, opts ...grpc.CallOption) (CompactTxStreamer_GetBlockRangeNullifiersClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_CompactTxStreamer_serviceDesc.Streams[2], "/cash.z.wallet.sdk.rpc.CompactTxStreamer/GetBlockRangeNullifiers", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &compactTxStreamerGetBlockRangeNullifiersClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type CompactTxStreamer_GetBlockRangeNullifiersClient interface {
+	Recv() (*CompactBlock, error)
+	grpc.ClientStream
+}
+
+type compactTxStreamerGetBlockRangeNullifiersClient struct {
+	grpc.ClientStream
+}
+
+func (x *compactTxStreamerGetBlockRangeNullifiersClient) Recv() (*CompactBlock, error) {
+	m := new(CompactBlock)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *compactTxStreamerClient) GetBlockByTime(ctx context.Context, in *BlockTimeArg, opts ...grpc.CallOption) (*BlockHeader, error) {
+	out := new(BlockHeader)
+	err := c.cc.Invoke(ctx, "/cash.z.wallet.sdk.rpc.CompactTxStreamer/GetBlockByTime", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *compactTxStreamerClient) SubscribeBlocks(ctx context.Context, in *SubscribeBlocksArg, opts ...grpc.CallOption) (CompactTxStreamer_SubscribeBlocksClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_CompactTxStreamer_serviceDesc.Streams[3], "/cash.z.wallet.sdk.rpc.CompactTxStreamer/SubscribeBlocks", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &compactTxStreamerSubscribeBlocksClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type CompactTxStreamer_SubscribeBlocksClient interface {
+	Recv() (*BlockUpdate, error)
+	grpc.ClientStream
+}
+
+type compactTxStreamerSubscribeBlocksClient struct {
+	grpc.ClientStream
+}
+
+func (x *compactTxStreamerSubscribeBlocksClient) Recv() (*BlockUpdate, error) {
+	m := new(BlockUpdate)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *compactTxStreamerClient) SubscribeReorgs(ctx context.Context, in *Empty, opts ...grpc.CallOption) (CompactTxStreamer_SubscribeReorgsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_CompactTxStreamer_serviceDesc.Streams[4], "/cash.z.wallet.sdk.rpc.CompactTxStreamer/SubscribeReorgs", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &compactTxStreamerSubscribeReorgsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type CompactTxStreamer_SubscribeReorgsClient interface {
+	Recv() (*ReorgEvent, error)
+	grpc.ClientStream
+}
+
+type compactTxStreamerSubscribeReorgsClient struct {
+	grpc.ClientStream
+}
+
+func (x *compactTxStreamerSubscribeReorgsClient) Recv() (*ReorgEvent, error) {
+	m := new(ReorgEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *compactTxStreamerClient) GetCheckpointIndex(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*CheckpointIndex, error) {
+	out := new(CheckpointIndex)
+	err := c.cc.Invoke(ctx, "/cash.z.wallet.sdk.rpc.CompactTxStreamer/GetCheckpointIndex", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *compactTxStreamerClient) GetTreeState(ctx context.Context, in *BlockID, opts ...grpc.CallOption) (*TreeState, error) {
+	out := new(TreeState)
+	err := c.cc.Invoke(ctx, "/cash.z.wallet.sdk.rpc.CompactTxStreamer/GetTreeState", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *compactTxStreamerClient) GetSubtreeRoots(ctx context.Context, in *GetSubtreeRootsArg, opts ...grpc.CallOption) (CompactTxStreamer_GetSubtreeRootsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_CompactTxStreamer_serviceDesc.Streams[5], "/cash.z.wallet.sdk.rpc.CompactTxStreamer/GetSubtreeRoots", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &compactTxStreamerGetSubtreeRootsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type CompactTxStreamer_GetSubtreeRootsClient interface {
+	Recv() (*SubtreeRoot, error)
+	grpc.ClientStream
+}
+
+type compactTxStreamerGetSubtreeRootsClient struct {
+	grpc.ClientStream
+}
+
+func (x *compactTxStreamerGetSubtreeRootsClient) Recv() (*SubtreeRoot, error) {
+	m := new(SubtreeRoot)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *compactTxStreamerClient) GetTransaction(ctx context.Context, in *TxFilter, opts ...grpc.CallOption) (*RawTransaction, error) {
+	out := new(RawTransaction)
+	err := c.cc.Invoke(ctx, "/cash.z.wallet.sdk.rpc.CompactTxStreamer/GetTransaction", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *compactTxStreamerClient) GetTransactions(ctx context.Context, opts ...grpc.CallOption) (CompactTxStreamer_GetTransactionsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_CompactTxStreamer_serviceDesc.Streams[6], "/cash.z.wallet.sdk.rpc.CompactTxStreamer/GetTransactions", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &compactTxStreamerGetTransactionsClient{stream}
+	return x, nil
+}
+
+type CompactTxStreamer_GetTransactionsClient interface {
+	Send(*TxFilter) error
+	Recv() (*RawTransaction, error)
+	grpc.ClientStream
+}
+
+type compactTxStreamerGetTransactionsClient struct {
+	grpc.ClientStream
+}
+
+func (x *compactTxStreamerGetTransactionsClient) Send(m *TxFilter) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *compactTxStreamerGetTransactionsClient) Recv() (*RawTransaction, error) {
+	m := new(RawTransaction)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *compactTxStreamerClient) SendTransaction(ctx context.Context, in *RawTransaction, opts ...grpc.CallOption) (*SendResponse, error) {
+	out := new(SendResponse)
+	err := c.cc.Invoke(ctx, "/cash.z.wallet.sdk.rpc.CompactTxStreamer/SendTransaction", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *compactTxStreamerClient) GetTxStatus(ctx context.Context, in *TxFilter, opts ...grpc.CallOption) (*TxStatus, error) {
+	out := new(TxStatus)
+	err := c.cc.Invoke(ctx, "/cash.z.wallet.sdk.rpc.CompactTxStreamer/GetTxStatus", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *compactTxStreamerClient) GetTxProof(ctx context.Context, in *TxFilter, opts ...grpc.CallOption) (*TxProof, error) {
+	out := new(TxProof)
+	err := c.cc.Invoke(ctx, "/cash.z.wallet.sdk.rpc.CompactTxStreamer/GetTxProof", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *compactTxStreamerClient) GetAddressTxids(ctx context.Context, in *TransparentAddressBlockFilter, opts ...grpc.CallOption) (CompactTxStreamer_GetAddressTxidsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_CompactTxStreamer_serviceDesc.Streams[7], "/cash.z.wallet.sdk.rpc.CompactTxStreamer/GetAddressTxids", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &compactTxStreamerGetAddressTxidsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type CompactTxStreamer_GetAddressTxidsClient interface {
+	Recv() (*RawTransaction, error)
+	grpc.ClientStream
 }
 
-type compactTxStreamerClient struct {
-	cc *grpc.ClientConn
+type compactTxStreamerGetAddressTxidsClient struct {
+	grpc.ClientStream
 }
 
-func NewCompactTxStreamerClient(cc *grpc.ClientConn) CompactTxStreamerClient {
-	return &compactTxStreamerClient{cc}
+func (x *compactTxStreamerGetAddressTxidsClient) Recv() (*RawTransaction, error) {
+	m := new(RawTransaction)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
 }
 
-func (c *compactTxStreamerClient) GetLatestBlock(ctx context.Context, in *ChainSpec, opts ...grpc.CallOption) (*BlockID, error) {
-	out := new(BlockID)
-	err := c.cc.Invoke(ctx, "/cash.z.wallet.sdk.rpc.CompactTxStreamer/GetLatestBlock", in, out, opts...)
+func (c *compactTxStreamerClient) GetTaddressTxids(ctx context.Context, in *TransparentAddressBlockFilter, opts ...grpc.CallOption) (CompactTxStreamer_GetTaddressTxidsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_CompactTxStreamer_serviceDesc.Streams[8], "/cash.z.wallet.sdk.rpc.CompactTxStreamer/GetTaddressTxids", opts...)
 	if err != nil {
 		return nil, err
 	}
-	return out, nil
+	x := &compactTxStreamerGetTaddressTxidsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
 }
 
-func (c *compactTxStreamerClient) GetBlock(ctx context.Context, in *BlockID, opts ...grpc.CallOption) (*CompactBlock, error) {
-	out := new(CompactBlock)
-	err := c.cc.Invoke(ctx, "/cash.z.wallet.sdk.rpc.CompactTxStreamer/GetBlock", in, out, opts...)
+type CompactTxStreamer_GetTaddressTxidsClient interface {
+	Recv() (*RawTransaction, error)
+	grpc.ClientStream
+}
+
+type compactTxStreamerGetTaddressTxidsClient struct {
+	grpc.ClientStream
+}
+
+func (x *compactTxStreamerGetTaddressTxidsClient) Recv() (*RawTransaction, error) {
+	m := new(RawTransaction)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *compactTxStreamerClient) GetAddressUtxos(ctx context.Context, in *GetAddressUtxosArg, opts ...grpc.CallOption) (*GetAddressUtxosReplyList, error) {
+	out := new(GetAddressUtxosReplyList)
+	err := c.cc.Invoke(ctx, "/cash.z.wallet.sdk.rpc.CompactTxStreamer/GetAddressUtxos", in, out, opts...)
 	if err != nil {
 		return nil, err
 	}
 	return out, nil
 }
 
-func (c *compactTxStreamerClient) GetBlockRange(ctx context.Context, in *BlockRange, opts ...grpc.CallOption) (CompactTxStreamer_GetBlockRangeClient, error) {
-	stream, err := c.cc.NewStream(ctx, &_CompactTxStreamer_serviceDesc.Streams[0], "/cash.z.wallet.sdk.rpc.CompactTxStreamer/GetBlockRange", opts...)
+func (c *compactTxStreamerClient) GetAddressUtxosStream(ctx context.Context, in *GetAddressUtxosArg, opts ...grpc.CallOption) (CompactTxStreamer_GetAddressUtxosStreamClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_CompactTxStreamer_serviceDesc.Streams[9], "/cash.z.wallet.sdk.rpc.CompactTxStreamer/GetAddressUtxosStream", opts...)
 	if err != nil {
 		return nil, err
 	}
-	x := &compactTxStreamerGetBlockRangeClient{stream}
+	x := &compactTxStreamerGetAddressUtxosStreamClient{stream}
 	if err := x.ClientStream.SendMsg(in); err != nil {
 		return nil, err
 	}
@@ -638,47 +2958,72 @@ func (c *compactTxStreamerClient) GetBlockRange(ctx context.Context, in *BlockRa
 	return x, nil
 }
 
-type CompactTxStreamer_GetBlockRangeClient interface {
-	Recv() (*CompactBlock, error)
+type CompactTxStreamer_GetAddressUtxosStreamClient interface {
+	Recv() (*GetAddressUtxosReply, error)
 	grpc.ClientStream
 }
 
-type compactTxStreamerGetBlockRangeClient struct {
+type compactTxStreamerGetAddressUtxosStreamClient struct {
 	grpc.ClientStream
 }
 
-func (x *compactTxStreamerGetBlockRangeClient) Recv() (*CompactBlock, error) {
-	m := new(CompactBlock)
+func (x *compactTxStreamerGetAddressUtxosStreamClient) Recv() (*GetAddressUtxosReply, error) {
+	m := new(GetAddressUtxosReply)
 	if err := x.ClientStream.RecvMsg(m); err != nil {
 		return nil, err
 	}
 	return m, nil
 }
 
-func (c *compactTxStreamerClient) GetTransaction(ctx context.Context, in *TxFilter, opts ...grpc.CallOption) (*RawTransaction, error) {
-	out := new(RawTransaction)
-	err := c.cc.Invoke(ctx, "/cash.z.wallet.sdk.rpc.CompactTxStreamer/GetTransaction", in, out, opts...)
+func (c *compactTxStreamerClient) GetTaddressBalance(ctx context.Context, in *AddressList, opts ...grpc.CallOption) (*Balance, error) {
+	out := new(Balance)
+	err := c.cc.Invoke(ctx, "/cash.z.wallet.sdk.rpc.CompactTxStreamer/GetTaddressBalance", in, out, opts...)
 	if err != nil {
 		return nil, err
 	}
 	return out, nil
 }
 
-func (c *compactTxStreamerClient) SendTransaction(ctx context.Context, in *RawTransaction, opts ...grpc.CallOption) (*SendResponse, error) {
-	out := new(SendResponse)
-	err := c.cc.Invoke(ctx, "/cash.z.wallet.sdk.rpc.CompactTxStreamer/SendTransaction", in, out, opts...)
+func (c *compactTxStreamerClient) GetTaddressBalanceStream(ctx context.Context, opts ...grpc.CallOption) (CompactTxStreamer_GetTaddressBalanceStreamClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_CompactTxStreamer_serviceDesc.Streams[10], "/cash.z.wallet.sdk.rpc.CompactTxStreamer/GetTaddressBalanceStream", opts...)
 	if err != nil {
 		return nil, err
 	}
-	return out, nil
+	x := &compactTxStreamerGetTaddressBalanceStreamClient{stream}
+	return x, nil
 }
 
-func (c *compactTxStreamerClient) GetAddressTxids(ctx context.Context, in *TransparentAddressBlockFilter, opts ...grpc.CallOption) (CompactTxStreamer_GetAddressTxidsClient, error) {
-	stream, err := c.cc.NewStream(ctx, &_CompactTxStreamer_serviceDesc.Streams[1], "/cash.z.wallet.sdk.rpc.CompactTxStreamer/GetAddressTxids", opts...)
+type CompactTxStreamer_GetTaddressBalanceStreamClient interface {
+	Send(*TransparentAddress) error
+	CloseAndRecv() (*Balance, error)
+	grpc.ClientStream
+}
+
+type compactTxStreamerGetTaddressBalanceStreamClient struct {
+	grpc.ClientStream
+}
+
+func (x *compactTxStreamerGetTaddressBalanceStreamClient) Send(m *TransparentAddress) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *compactTxStreamerGetTaddressBalanceStreamClient) CloseAndRecv() (*Balance, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(Balance)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *compactTxStreamerClient) GetTaddressBalanceHistory(ctx context.Context, in *BalanceHistoryArg, opts ...grpc.CallOption) (CompactTxStreamer_GetTaddressBalanceHistoryClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_CompactTxStreamer_serviceDesc.Streams[11], "/cash.z.wallet.sdk.rpc.CompactTxStreamer/GetTaddressBalanceHistory", opts...)
 	if err != nil {
 		return nil, err
 	}
-	x := &compactTxStreamerGetAddressTxidsClient{stream}
+	x := &compactTxStreamerGetTaddressBalanceHistoryClient{stream}
 	if err := x.ClientStream.SendMsg(in); err != nil {
 		return nil, err
 	}
@@ -688,17 +3033,17 @@ func (c *compactTxStreamerClient) GetAddressTxids(ctx context.Context, in *Trans
 	return x, nil
 }
 
-type CompactTxStreamer_GetAddressTxidsClient interface {
-	Recv() (*RawTransaction, error)
+type CompactTxStreamer_GetTaddressBalanceHistoryClient interface {
+	Recv() (*BalanceDelta, error)
 	grpc.ClientStream
 }
 
-type compactTxStreamerGetAddressTxidsClient struct {
+type compactTxStreamerGetTaddressBalanceHistoryClient struct {
 	grpc.ClientStream
 }
 
-func (x *compactTxStreamerGetAddressTxidsClient) Recv() (*RawTransaction, error) {
-	m := new(RawTransaction)
+func (x *compactTxStreamerGetTaddressBalanceHistoryClient) Recv() (*BalanceDelta, error) {
+	m := new(BalanceDelta)
 	if err := x.ClientStream.RecvMsg(m); err != nil {
 		return nil, err
 	}
@@ -714,19 +3059,129 @@ func (c *compactTxStreamerClient) GetLightdInfo(ctx context.Context, in *Empty,
 	return out, nil
 }
 
+func (c *compactTxStreamerClient) GetConsensusBranch(ctx context.Context, in *BlockID, opts ...grpc.CallOption) (*ConsensusBranch, error) {
+	out := new(ConsensusBranch)
+	err := c.cc.Invoke(ctx, "/cash.z.wallet.sdk.rpc.CompactTxStreamer/GetConsensusBranch", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *compactTxStreamerClient) Ping(ctx context.Context, in *PingRequest, opts ...grpc.CallOption) (*PingResponse, error) {
+	out := new(PingResponse)
+	err := c.cc.Invoke(ctx, "/cash.z.wallet.sdk.rpc.CompactTxStreamer/Ping", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *compactTxStreamerClient) GetServerNotices(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*ServerNotices, error) {
+	out := new(ServerNotices)
+	err := c.cc.Invoke(ctx, "/cash.z.wallet.sdk.rpc.CompactTxStreamer/GetServerNotices", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *compactTxStreamerClient) GetValuePools(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*ValuePools, error) {
+	out := new(ValuePools)
+	err := c.cc.Invoke(ctx, "/cash.z.wallet.sdk.rpc.CompactTxStreamer/GetValuePools", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // CompactTxStreamerServer is the server API for CompactTxStreamer service.
 type CompactTxStreamerServer interface {
 	// Compact Blocks
 	GetLatestBlock(context.Context, *ChainSpec) (*BlockID, error)
 	GetBlock(context.Context, *BlockID) (*CompactBlock, error)
+	// GetBlockRange streams the blocks of a range. With skipEmpty, blocks
+	// without transactions are left out, but for the last of the range,
+	// those carrying a checkpoint, and one after each run of as many
+	// skipped blocks as the server sets, so that clients can follow its
+	// progress.
 	GetBlockRange(*BlockRange, CompactTxStreamer_GetBlockRangeServer) error
+	// GetBlockHeaders streams the headers of the blocks of a range, up or
+	// down; only its start and end are used.
+	GetBlockHeaders(*BlockRange, CompactTxStreamer_GetBlockHeadersServer) error
+	// GetBlockRangeNullifiers streams the blocks of a range, up or down,
+	// with only the transactions that spend notes, and only their
+	// nullifiers, for wallets that know their notes and look for spends.
+	GetBlockRangeNullifiers(*BlockRange, CompactTxStreamer_GetBlockRangeNullifiersServer) error
+	// GetBlockByTime returns the header of the first cached block whose
+	// time is at or after the given time, for example to turn a wallet's
+	// birthday into the height to start scanning from.
+	GetBlockByTime(context.Context, *BlockTimeArg) (*BlockHeader, error)
+	// SubscribeBlocks sends the current tip, then each block the server
+	// adds, so that wallets don't need to poll GetLatestBlock. A tip lower
+	// than the last one means a reorg. Updates without a tip are pings,
+	// sent when the chain is quiet, so that proxies keep the stream open.
+	SubscribeBlocks(*SubscribeBlocksArg, CompactTxStreamer_SubscribeBlocksServer) error
+	// SubscribeReorgs sends an event each time the server's chain is
+	// reorganized, with pings in between like SubscribeBlocks.
+	SubscribeReorgs(*Empty, CompactTxStreamer_SubscribeReorgsServer) error
+	GetCheckpointIndex(context.Context, *Empty) (*CheckpointIndex, error)
+	// GetTreeState returns the Sapling commitment tree as of the block
+	// with BlockID.hash, or else BlockID.height.
+	GetTreeState(context.Context, *BlockID) (*TreeState, error)
+	// GetSubtreeRoots streams the roots of completed note commitment
+	// subtrees, in index order, from the node's z_getsubtreesbyindex, so
+	// that wallets can spend before they've scanned the whole chain.
+	GetSubtreeRoots(*GetSubtreeRootsArg, CompactTxStreamer_GetSubtreeRootsServer) error
 	// Transactions
 	GetTransaction(context.Context, *TxFilter) (*RawTransaction, error)
+	// GetTransactions returns the transactions whose txids the client
+	// streams, in the same order, fetching them from the node in batches.
+	// Only TxFilter.hash is supported.
+	GetTransactions(CompactTxStreamer_GetTransactionsServer) error
 	SendTransaction(context.Context, *RawTransaction) (*SendResponse, error)
+	// GetTxStatus reports whether a transaction, by TxFilter.hash, was mined,
+	// is waiting in the mempool, or neither.
+	GetTxStatus(context.Context, *TxFilter) (*TxStatus, error)
+	// GetTxProof returns the Merkle proof that a mined transaction, by
+	// TxFilter.hash, is in its block, for clients that check the server.
+	GetTxProof(context.Context, *TxFilter) (*TxProof, error)
 	// t-Address support
+	// GetAddressTxids is superseded by GetTaddressTxids, which pages through
+	// long ranges and reports errors with gRPC status codes.
 	GetAddressTxids(*TransparentAddressBlockFilter, CompactTxStreamer_GetAddressTxidsServer) error
+	// GetTaddressTxids streams the transactions touching a transparent
+	// address within the range, in the order the node lists them.
+	GetTaddressTxids(*TransparentAddressBlockFilter, CompactTxStreamer_GetTaddressTxidsServer) error
+	// GetAddressUtxos returns the unspent outputs of transparent addresses,
+	// in height order.
+	GetAddressUtxos(context.Context, *GetAddressUtxosArg) (*GetAddressUtxosReplyList, error)
+	// GetAddressUtxosStream streams them instead, address by address, each
+	// in order of height, txid and index; to resume, ask again for the last
+	// address from the last height received, and for those after it.
+	GetAddressUtxosStream(*GetAddressUtxosArg, CompactTxStreamer_GetAddressUtxosStreamServer) error
+	// GetTaddressBalance returns the total balance of transparent addresses;
+	// GetTaddressBalanceStream does the same for addresses sent one by one.
+	GetTaddressBalance(context.Context, *AddressList) (*Balance, error)
+	GetTaddressBalanceStream(CompactTxStreamer_GetTaddressBalanceStreamServer) error
+	// GetTaddressBalanceHistory streams the changes in the balance of a
+	// transparent address, oldest first, for charting it. It needs the
+	// server's address index.
+	GetTaddressBalanceHistory(*BalanceHistoryArg, CompactTxStreamer_GetTaddressBalanceHistoryServer) error
 	// Misc
 	GetLightdInfo(context.Context, *Empty) (*LightdInfo, error)
+	// GetConsensusBranch returns the consensus branch at BlockID.height,
+	// from the network upgrades the node has scheduled.
+	GetConsensusBranch(context.Context, *BlockID) (*ConsensusBranch, error)
+	// Ping answers without calling the node, to measure round trips and
+	// tell a server that's down from one whose node is behind.
+	Ping(context.Context, *PingRequest) (*PingResponse, error)
+	// GetServerNotices returns the operator's current notices, which wallets
+	// should show their users.
+	GetServerNotices(context.Context, *Empty) (*ServerNotices, error)
+	// GetValuePools returns the value in each shielded and transparent pool
+	// and the total supply at the node's tip, for network statistics.
+	GetValuePools(context.Context, *Empty) (*ValuePools, error)
 }
 
 // UnimplementedCompactTxStreamerServer can be embedded to have forward compatible implementations.
@@ -742,77 +3197,299 @@ func (*UnimplementedCompactTxStreamerServer) GetBlock(ctx context.Context, req *
 func (*UnimplementedCompactTxStreamerServer) GetBlockRange(req *BlockRange, srv CompactTxStreamer_GetBlockRangeServer) error {
 	return status.Errorf(codes.Unimplemented, "method GetBlockRange not implemented")
 }
+func (*UnimplementedCompactTxStreamerServer) GetBlockHeaders(req *BlockRange, srv CompactTxStreamer_GetBlockHeadersServer) error {
+	return status.Errorf(codes.Unimplemented, "method GetBlockHeaders not implemented")
+}
+func (*UnimplementedCompactTxStreamerServer) GetBlockRangeNullifiers(req *BlockRange, srv CompactTxStreamer_GetBlockRangeNullifiersServer) error {
+	return status.Errorf(codes.Unimplemented, "method GetBlockRangeNullifiers not implemented")
+}
+func (*UnimplementedCompactTxStreamerServer) GetBlockByTime(ctx context.Context, req *BlockTimeArg) (*BlockHeader, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetBlockByTime not implemented")
+}
+func (*UnimplementedCompactTxStreamerServer) SubscribeBlocks(req *SubscribeBlocksArg, srv CompactTxStreamer_SubscribeBlocksServer) error {
+	return status.Errorf(codes.Unimplemented, "method SubscribeBlocks not implemented")
+}
+func (*UnimplementedCompactTxStreamerServer) SubscribeReorgs(req *Empty, srv CompactTxStreamer_SubscribeReorgsServer) error {
+	return status.Errorf(codes.Unimplemented, "method SubscribeReorgs not implemented")
+}
+func (*UnimplementedCompactTxStreamerServer) GetCheckpointIndex(ctx context.Context, req *Empty) (*CheckpointIndex, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetCheckpointIndex not implemented")
+}
+func (*UnimplementedCompactTxStreamerServer) GetTreeState(ctx context.Context, req *BlockID) (*TreeState, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetTreeState not implemented")
+}
+func (*UnimplementedCompactTxStreamerServer) GetSubtreeRoots(req *GetSubtreeRootsArg, srv CompactTxStreamer_GetSubtreeRootsServer) error {
+	return status.Errorf(codes.Unimplemented, "method GetSubtreeRoots not implemented")
+}
 func (*UnimplementedCompactTxStreamerServer) GetTransaction(ctx context.Context, req *TxFilter) (*RawTransaction, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method GetTransaction not implemented")
 }
+func (*UnimplementedCompactTxStreamerServer) GetTransactions(srv CompactTxStreamer_GetTransactionsServer) error {
+	return status.Errorf(codes.Unimplemented, "method GetTransactions not implemented")
+}
 func (*UnimplementedCompactTxStreamerServer) SendTransaction(ctx context.Context, req *RawTransaction) (*SendResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method SendTransaction not implemented")
 }
+func (*UnimplementedCompactTxStreamerServer) GetTxStatus(ctx context.Context, req *TxFilter) (*TxStatus, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetTxStatus not implemented")
+}
+func (*UnimplementedCompactTxStreamerServer) GetTxProof(ctx context.Context, req *TxFilter) (*TxProof, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetTxProof not implemented")
+}
 func (*UnimplementedCompactTxStreamerServer) GetAddressTxids(req *TransparentAddressBlockFilter, srv CompactTxStreamer_GetAddressTxidsServer) error {
 	return status.Errorf(codes.Unimplemented, "method GetAddressTxids not implemented")
 }
+func (*UnimplementedCompactTxStreamerServer) GetTaddressTxids(req *TransparentAddressBlockFilter, srv CompactTxStreamer_GetTaddressTxidsServer) error {
+	return status.Errorf(codes.Unimplemented, "method GetTaddressTxids not implemented")
+}
+func (*UnimplementedCompactTxStreamerServer) GetAddressUtxos(ctx context.Context, req *GetAddressUtxosArg) (*GetAddressUtxosReplyList, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetAddressUtxos not implemented")
+}
+func (*UnimplementedCompactTxStreamerServer) GetAddressUtxosStream(req *GetAddressUtxosArg, srv CompactTxStreamer_GetAddressUtxosStreamServer) error {
+	return status.Errorf(codes.Unimplemented, "method GetAddressUtxosStream not implemented")
+}
+func (*UnimplementedCompactTxStreamerServer) GetTaddressBalance(ctx context.Context, req *AddressList) (*Balance, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetTaddressBalance not implemented")
+}
+func (*UnimplementedCompactTxStreamerServer) GetTaddressBalanceStream(srv CompactTxStreamer_GetTaddressBalanceStreamServer) error {
+	return status.Errorf(codes.Unimplemented, "method GetTaddressBalanceStream not implemented")
+}
+func (*UnimplementedCompactTxStreamerServer) GetTaddressBalanceHistory(req *BalanceHistoryArg, srv CompactTxStreamer_GetTaddressBalanceHistoryServer) error {
+	return status.Errorf(codes.Unimplemented, "method GetTaddressBalanceHistory not implemented")
+}
 func (*UnimplementedCompactTxStreamerServer) GetLightdInfo(ctx context.Context, req *Empty) (*LightdInfo, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method GetLightdInfo not implemented")
 }
+func (*UnimplementedCompactTxStreamerServer) GetConsensusBranch(ctx context.Context, req *BlockID) (*ConsensusBranch, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetConsensusBranch not implemented")
+}
+func (*UnimplementedCompactTxStreamerServer) Ping(ctx context.Context, req *PingRequest) (*PingResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Ping not implemented")
+}
+func (*UnimplementedCompactTxStreamerServer) GetServerNotices(ctx context.Context, req *Empty) (*ServerNotices, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetServerNotices not implemented")
+}
+func (*UnimplementedCompactTxStreamerServer) GetValuePools(ctx context.Context, req *Empty) (*ValuePools, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetValuePools not implemented")
+}
 
 func RegisterCompactTxStreamerServer(s *grpc.Server, srv CompactTxStreamerServer) {
 	s.RegisterService(&_CompactTxStreamer_serviceDesc, srv)
 }
 
-func _CompactTxStreamer_GetLatestBlock_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
-	in := new(ChainSpec)
+func _CompactTxStreamer_GetLatestBlock_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ChainSpec)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CompactTxStreamerServer).GetLatestBlock(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/cash.z.wallet.sdk.rpc.CompactTxStreamer/GetLatestBlock",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CompactTxStreamerServer).GetLatestBlock(ctx, req.(*ChainSpec))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CompactTxStreamer_GetBlock_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BlockID)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CompactTxStreamerServer).GetBlock(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/cash.z.wallet.sdk.rpc.CompactTxStreamer/GetBlock",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CompactTxStreamerServer).GetBlock(ctx, req.(*BlockID))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CompactTxStreamer_GetBlockRange_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(BlockRange)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(CompactTxStreamerServer).GetBlockRange(m, &compactTxStreamerGetBlockRangeServer{stream})
+}
+
+type CompactTxStreamer_GetBlockRangeServer interface {
+	Send(*CompactBlock) error
+	grpc.ServerStream
+}
+
+type compactTxStreamerGetBlockRangeServer struct {
+	grpc.ServerStream
+}
+
+func (x *compactTxStreamerGetBlockRangeServer) Send(m *CompactBlock) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _CompactTxStreamer_GetBlockHeaders_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(BlockRange)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(CompactTxStreamerServer).GetBlockHeaders(m, &compactTxStreamerGetBlockHeadersServer{stream})
+}
+
+type CompactTxStreamer_GetBlockHeadersServer interface {
+	Send(*BlockHeader) error
+	grpc.ServerStream
+}
+
+type compactTxStreamerGetBlockHeadersServer struct {
+	grpc.ServerStream
+}
+
+func (x *compactTxStreamerGetBlockHeadersServer) Send(m *BlockHeader) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _CompactTxStreamer_GetBlockRangeNullifiers_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(BlockRange)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(CompactTxStreamerServer).GetBlockRangeNullifiers(m, &compactTxStreamerGetBlockRangeNullifiersServer{stream})
+}
+
+type CompactTxStreamer_GetBlockRangeNullifiersServer interface {
+	Send(*CompactBlock) error
+	grpc.ServerStream
+}
+
+type compactTxStreamerGetBlockRangeNullifiersServer struct {
+	grpc.ServerStream
+}
+
+func (x *compactTxStreamerGetBlockRangeNullifiersServer) Send(m *CompactBlock) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _CompactTxStreamer_GetBlockByTime_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BlockTimeArg)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CompactTxStreamerServer).GetBlockByTime(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/cash.z.wallet.sdk.rpc.CompactTxStreamer/GetBlockByTime",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CompactTxStreamerServer).GetBlockByTime(ctx, req.(*BlockTimeArg))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CompactTxStreamer_SubscribeBlocks_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(SubscribeBlocksArg)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(CompactTxStreamerServer).SubscribeBlocks(m, &compactTxStreamerSubscribeBlocksServer{stream})
+}
+
+type CompactTxStreamer_SubscribeBlocksServer interface {
+	Send(*BlockUpdate) error
+	grpc.ServerStream
+}
+
+type compactTxStreamerSubscribeBlocksServer struct {
+	grpc.ServerStream
+}
+
+func (x *compactTxStreamerSubscribeBlocksServer) Send(m *BlockUpdate) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _CompactTxStreamer_SubscribeReorgs_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(Empty)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(CompactTxStreamerServer).SubscribeReorgs(m, &compactTxStreamerSubscribeReorgsServer{stream})
+}
+
+type CompactTxStreamer_SubscribeReorgsServer interface {
+	Send(*ReorgEvent) error
+	grpc.ServerStream
+}
+
+type compactTxStreamerSubscribeReorgsServer struct {
+	grpc.ServerStream
+}
+
+func (x *compactTxStreamerSubscribeReorgsServer) Send(m *ReorgEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _CompactTxStreamer_GetCheckpointIndex_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Empty)
 	if err := dec(in); err != nil {
 		return nil, err
 	}
 	if interceptor == nil {
-		return srv.(CompactTxStreamerServer).GetLatestBlock(ctx, in)
+		return srv.(CompactTxStreamerServer).GetCheckpointIndex(ctx, in)
 	}
 	info := &grpc.UnaryServerInfo{
 		Server:     srv,
-		FullMethod: "/cash.z.wallet.sdk.rpc.CompactTxStreamer/GetLatestBlock",
+		FullMethod: "/cash.z.wallet.sdk.rpc.CompactTxStreamer/GetCheckpointIndex",
 	}
 	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return srv.(CompactTxStreamerServer).GetLatestBlock(ctx, req.(*ChainSpec))
+		return srv.(CompactTxStreamerServer).GetCheckpointIndex(ctx, req.(*Empty))
 	}
 	return interceptor(ctx, in, info, handler)
 }
 
-func _CompactTxStreamer_GetBlock_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+func _CompactTxStreamer_GetTreeState_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(BlockID)
 	if err := dec(in); err != nil {
 		return nil, err
 	}
 	if interceptor == nil {
-		return srv.(CompactTxStreamerServer).GetBlock(ctx, in)
+		return srv.(CompactTxStreamerServer).GetTreeState(ctx, in)
 	}
 	info := &grpc.UnaryServerInfo{
 		Server:     srv,
-		FullMethod: "/cash.z.wallet.sdk.rpc.CompactTxStreamer/GetBlock",
+		FullMethod: "/cash.z.wallet.sdk.rpc.CompactTxStreamer/GetTreeState",
 	}
 	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return srv.(CompactTxStreamerServer).GetBlock(ctx, req.(*BlockID))
+		return srv.(CompactTxStreamerServer).GetTreeState(ctx, req.(*BlockID))
 	}
 	return interceptor(ctx, in, info, handler)
 }
 
-func _CompactTxStreamer_GetBlockRange_Handler(srv interface{}, stream grpc.ServerStream) error {
-	m := new(BlockRange)
+func _CompactTxStreamer_GetSubtreeRoots_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(GetSubtreeRootsArg)
 	if err := stream.RecvMsg(m); err != nil {
 		return err
 	}
-	return srv.(CompactTxStreamerServer).GetBlockRange(m, &compactTxStreamerGetBlockRangeServer{stream})
+	return srv.(CompactTxStreamerServer).GetSubtreeRoots(m, &compactTxStreamerGetSubtreeRootsServer{stream})
 }
 
-type CompactTxStreamer_GetBlockRangeServer interface {
-	Send(*CompactBlock) error
+type CompactTxStreamer_GetSubtreeRootsServer interface {
+	Send(*SubtreeRoot) error
 	grpc.ServerStream
 }
 
-type compactTxStreamerGetBlockRangeServer struct {
+type compactTxStreamerGetSubtreeRootsServer struct {
 	grpc.ServerStream
 }
 
-func (x *compactTxStreamerGetBlockRangeServer) Send(m *CompactBlock) error {
+func (x *compactTxStreamerGetSubtreeRootsServer) Send(m *SubtreeRoot) error {
 	return x.ServerStream.SendMsg(m)
 }
 
@@ -834,6 +3511,32 @@ func _CompactTxStreamer_GetTransaction_Handler(srv interface{}, ctx context.Cont
 	return interceptor(ctx, in, info, handler)
 }
 
+func _CompactTxStreamer_GetTransactions_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(CompactTxStreamerServer).GetTransactions(&compactTxStreamerGetTransactionsServer{stream})
+}
+
+type CompactTxStreamer_GetTransactionsServer interface {
+	Send(*RawTransaction) error
+	Recv() (*TxFilter, error)
+	grpc.ServerStream
+}
+
+type compactTxStreamerGetTransactionsServer struct {
+	grpc.ServerStream
+}
+
+func (x *compactTxStreamerGetTransactionsServer) Send(m *RawTransaction) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *compactTxStreamerGetTransactionsServer) Recv() (*TxFilter, error) {
+	m := new(TxFilter)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 func _CompactTxStreamer_SendTransaction_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(RawTransaction)
 	if err := dec(in); err != nil {
@@ -852,6 +3555,42 @@ func _CompactTxStreamer_SendTransaction_Handler(srv interface{}, ctx context.Con
 	return interceptor(ctx, in, info, handler)
 }
 
+func _CompactTxStreamer_GetTxStatus_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TxFilter)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CompactTxStreamerServer).GetTxStatus(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/cash.z.wallet.sdk.rpc.CompactTxStreamer/GetTxStatus",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CompactTxStreamerServer).GetTxStatus(ctx, req.(*TxFilter))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CompactTxStreamer_GetTxProof_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TxFilter)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CompactTxStreamerServer).GetTxProof(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/cash.z.wallet.sdk.rpc.CompactTxStreamer/GetTxProof",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CompactTxStreamerServer).GetTxProof(ctx, req.(*TxFilter))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _CompactTxStreamer_GetAddressTxids_Handler(srv interface{}, stream grpc.ServerStream) error {
 	m := new(TransparentAddressBlockFilter)
 	if err := stream.RecvMsg(m); err != nil {
@@ -873,6 +3612,131 @@ func (x *compactTxStreamerGetAddressTxidsServer) Send(m *RawTransaction) error {
 	return x.ServerStream.SendMsg(m)
 }
 
+func _CompactTxStreamer_GetTaddressTxids_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(TransparentAddressBlockFilter)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(CompactTxStreamerServer).GetTaddressTxids(m, &compactTxStreamerGetTaddressTxidsServer{stream})
+}
+
+type CompactTxStreamer_GetTaddressTxidsServer interface {
+	Send(*RawTransaction) error
+	grpc.ServerStream
+}
+
+type compactTxStreamerGetTaddressTxidsServer struct {
+	grpc.ServerStream
+}
+
+func (x *compactTxStreamerGetTaddressTxidsServer) Send(m *RawTransaction) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _CompactTxStreamer_GetAddressUtxos_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetAddressUtxosArg)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CompactTxStreamerServer).GetAddressUtxos(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/cash.z.wallet.sdk.rpc.CompactTxStreamer/GetAddressUtxos",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CompactTxStreamerServer).GetAddressUtxos(ctx, req.(*GetAddressUtxosArg))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CompactTxStreamer_GetAddressUtxosStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(GetAddressUtxosArg)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(CompactTxStreamerServer).GetAddressUtxosStream(m, &compactTxStreamerGetAddressUtxosStreamServer{stream})
+}
+
+type CompactTxStreamer_GetAddressUtxosStreamServer interface {
+	Send(*GetAddressUtxosReply) error
+	grpc.ServerStream
+}
+
+type compactTxStreamerGetAddressUtxosStreamServer struct {
+	grpc.ServerStream
+}
+
+func (x *compactTxStreamerGetAddressUtxosStreamServer) Send(m *GetAddressUtxosReply) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _CompactTxStreamer_GetTaddressBalance_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AddressList)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CompactTxStreamerServer).GetTaddressBalance(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/cash.z.wallet.sdk.rpc.CompactTxStreamer/GetTaddressBalance",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CompactTxStreamerServer).GetTaddressBalance(ctx, req.(*AddressList))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CompactTxStreamer_GetTaddressBalanceStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(CompactTxStreamerServer).GetTaddressBalanceStream(&compactTxStreamerGetTaddressBalanceStreamServer{stream})
+}
+
+type CompactTxStreamer_GetTaddressBalanceStreamServer interface {
+	SendAndClose(*Balance) error
+	Recv() (*TransparentAddress, error)
+	grpc.ServerStream
+}
+
+type compactTxStreamerGetTaddressBalanceStreamServer struct {
+	grpc.ServerStream
+}
+
+func (x *compactTxStreamerGetTaddressBalanceStreamServer) SendAndClose(m *Balance) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *compactTxStreamerGetTaddressBalanceStreamServer) Recv() (*TransparentAddress, error) {
+	m := new(TransparentAddress)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _CompactTxStreamer_GetTaddressBalanceHistory_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(BalanceHistoryArg)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(CompactTxStreamerServer).GetTaddressBalanceHistory(m, &compactTxStreamerGetTaddressBalanceHistoryServer{stream})
+}
+
+type CompactTxStreamer_GetTaddressBalanceHistoryServer interface {
+	Send(*BalanceDelta) error
+	grpc.ServerStream
+}
+
+type compactTxStreamerGetTaddressBalanceHistoryServer struct {
+	grpc.ServerStream
+}
+
+func (x *compactTxStreamerGetTaddressBalanceHistoryServer) Send(m *BalanceDelta) error {
+	return x.ServerStream.SendMsg(m)
+}
+
 func _CompactTxStreamer_GetLightdInfo_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(Empty)
 	if err := dec(in); err != nil {
@@ -891,6 +3755,78 @@ func _CompactTxStreamer_GetLightdInfo_Handler(srv interface{}, ctx context.Conte
 	return interceptor(ctx, in, info, handler)
 }
 
+func _CompactTxStreamer_GetConsensusBranch_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BlockID)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CompactTxStreamerServer).GetConsensusBranch(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/cash.z.wallet.sdk.rpc.CompactTxStreamer/GetConsensusBranch",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CompactTxStreamerServer).GetConsensusBranch(ctx, req.(*BlockID))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CompactTxStreamer_Ping_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PingRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CompactTxStreamerServer).Ping(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/cash.z.wallet.sdk.rpc.CompactTxStreamer/Ping",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CompactTxStreamerServer).Ping(ctx, req.(*PingRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CompactTxStreamer_GetServerNotices_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CompactTxStreamerServer).GetServerNotices(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/cash.z.wallet.sdk.rpc.CompactTxStreamer/GetServerNotices",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CompactTxStreamerServer).GetServerNotices(ctx, req.(*Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CompactTxStreamer_GetValuePools_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CompactTxStreamerServer).GetValuePools(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/cash.z.wallet.sdk.rpc.CompactTxStreamer/GetValuePools",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CompactTxStreamerServer).GetValuePools(ctx, req.(*Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 var _CompactTxStreamer_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "cash.z.wallet.sdk.rpc.CompactTxStreamer",
 	HandlerType: (*CompactTxStreamerServer)(nil),
@@ -903,6 +3839,18 @@ var _CompactTxStreamer_serviceDesc = grpc.ServiceDesc{
 			MethodName: "GetBlock",
 			Handler:    _CompactTxStreamer_GetBlock_Handler,
 		},
+		{
+			MethodName: "GetBlockByTime",
+			Handler:    _CompactTxStreamer_GetBlockByTime_Handler,
+		},
+		{
+			MethodName: "GetCheckpointIndex",
+			Handler:    _CompactTxStreamer_GetCheckpointIndex_Handler,
+		},
+		{
+			MethodName: "GetTreeState",
+			Handler:    _CompactTxStreamer_GetTreeState_Handler,
+		},
 		{
 			MethodName: "GetTransaction",
 			Handler:    _CompactTxStreamer_GetTransaction_Handler,
@@ -911,10 +3859,42 @@ var _CompactTxStreamer_serviceDesc = grpc.ServiceDesc{
 			MethodName: "SendTransaction",
 			Handler:    _CompactTxStreamer_SendTransaction_Handler,
 		},
+		{
+			MethodName: "GetTxStatus",
+			Handler:    _CompactTxStreamer_GetTxStatus_Handler,
+		},
+		{
+			MethodName: "GetTxProof",
+			Handler:    _CompactTxStreamer_GetTxProof_Handler,
+		},
+		{
+			MethodName: "GetAddressUtxos",
+			Handler:    _CompactTxStreamer_GetAddressUtxos_Handler,
+		},
+		{
+			MethodName: "GetTaddressBalance",
+			Handler:    _CompactTxStreamer_GetTaddressBalance_Handler,
+		},
 		{
 			MethodName: "GetLightdInfo",
 			Handler:    _CompactTxStreamer_GetLightdInfo_Handler,
 		},
+		{
+			MethodName: "GetConsensusBranch",
+			Handler:    _CompactTxStreamer_GetConsensusBranch_Handler,
+		},
+		{
+			MethodName: "Ping",
+			Handler:    _CompactTxStreamer_Ping_Handler,
+		},
+		{
+			MethodName: "GetServerNotices",
+			Handler:    _CompactTxStreamer_GetServerNotices_Handler,
+		},
+		{
+			MethodName: "GetValuePools",
+			Handler:    _CompactTxStreamer_GetValuePools_Handler,
+		},
 	},
 	Streams: []grpc.StreamDesc{
 		{
@@ -922,11 +3902,62 @@ var _CompactTxStreamer_serviceDesc = grpc.ServiceDesc{
 			Handler:       _CompactTxStreamer_GetBlockRange_Handler,
 			ServerStreams: true,
 		},
+		{
+			StreamName:    "GetBlockHeaders",
+			Handler:       _CompactTxStreamer_GetBlockHeaders_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "GetBlockRangeNullifiers",
+			Handler:       _CompactTxStreamer_GetBlockRangeNullifiers_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "SubscribeBlocks",
+			Handler:       _CompactTxStreamer_SubscribeBlocks_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "SubscribeReorgs",
+			Handler:       _CompactTxStreamer_SubscribeReorgs_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "GetSubtreeRoots",
+			Handler:       _CompactTxStreamer_GetSubtreeRoots_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "GetTransactions",
+			Handler:       _CompactTxStreamer_GetTransactions_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
 		{
 			StreamName:    "GetAddressTxids",
 			Handler:       _CompactTxStreamer_GetAddressTxids_Handler,
 			ServerStreams: true,
 		},
+		{
+			StreamName:    "GetTaddressTxids",
+			Handler:       _CompactTxStreamer_GetTaddressTxids_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "GetAddressUtxosStream",
+			Handler:       _CompactTxStreamer_GetAddressUtxosStream_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "GetTaddressBalanceStream",
+			Handler:       _CompactTxStreamer_GetTaddressBalanceStream_Handler,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "GetTaddressBalanceHistory",
+			Handler:       _CompactTxStreamer_GetTaddressBalanceHistory_Handler,
+			ServerStreams: true,
+		},
 	},
 	Metadata: "service.proto",
 }